@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var validPdbAccession = regexp.MustCompile(`^[0-9][A-Za-z0-9]{3}$`).MatchString
+var afdbAccession = regexp.MustCompile(`^(?:AF-)?([A-Z0-9]{6,10})(?:-F[0-9]+)?$`)
+
+const maxStructureSize = 128 * 1024 * 1024
+
+var structureClient = &http.Client{Timeout: 30 * time.Second}
+
+func fetchUrl(url string) ([]byte, error) {
+	resp, err := structureClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch %s: %s", url, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxStructureSize))
+}
+
+// FetchStructure downloads the structure of a PDB (e.g. 1TIM) or AlphaFold DB (e.g. AF-P0DTC2-F1, P0DTC2) accession
+func FetchStructure(accession string) (string, error) {
+	accession = strings.TrimSpace(accession)
+	if validPdbAccession(accession) {
+		data, err := fetchUrl("https://files.rcsb.org/download/" + strings.ToUpper(accession) + ".cif")
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+
+	match := afdbAccession.FindStringSubmatch(strings.ToUpper(accession))
+	if match == nil {
+		return "", errors.New("invalid accession")
+	}
+
+	data, err := fetchUrl("https://alphafold.ebi.ac.uk/api/prediction/" + match[1])
+	if err != nil {
+		return "", err
+	}
+
+	type Prediction struct {
+		PdbUrl string `json:"pdbUrl"`
+	}
+	var predictions []Prediction
+	if err := json.Unmarshal(data, &predictions); err != nil {
+		return "", err
+	}
+	if len(predictions) == 0 || predictions[0].PdbUrl == "" {
+		return "", errors.New("accession not found in AlphaFold DB")
+	}
+
+	data, err = fetchUrl(predictions[0].PdbUrl)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
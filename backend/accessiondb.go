@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// databases built from a list of accessions keep the list in path.accessions and are fetched into path.fasta by the index job
+const accessionsFormat = "accessions"
+
+const maxAccessions = 100000
+
+var uniprotAccession = regexp.MustCompile(`^(?:[OPQ][0-9][A-Z0-9]{3}[0-9]|[A-NR-Z][0-9](?:[A-Z][A-Z0-9]{2}[0-9]){1,2})(?:-[0-9]+)?$`)
+
+// RefSeq (e.g. NP_000517.1) and GenBank/EMBL/DDBJ (e.g. AAA12345.1) protein accessions
+var ncbiAccession = regexp.MustCompile(`^(?:[A-Z]{2}_[0-9]+|[A-Z]{3}[0-9]{5,7})(?:\.[0-9]+)?$`)
+
+const (
+	accessionUniProt = "uniprot"
+	accessionNCBI    = "ncbi"
+)
+
+func accessionSource(accession string) string {
+	if uniprotAccession.MatchString(accession) {
+		return accessionUniProt
+	}
+	if ncbiAccession.MatchString(accession) {
+		return accessionNCBI
+	}
+	return ""
+}
+
+// parseAccessionList reads accessions separated by whitespace or commas, duplicates are removed
+func parseAccessionList(data string) ([]string, error) {
+	fields := strings.FieldsFunc(data, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	accessions := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		accession := strings.ToUpper(field)
+		if accessionSource(accession) == "" {
+			return nil, errors.New("invalid UniProt or NCBI accession " + field)
+		}
+		if seen[accession] {
+			continue
+		}
+		seen[accession] = true
+		accessions = append(accessions, accession)
+	}
+	if len(accessions) == 0 {
+		return nil, errors.New("no accessions given")
+	}
+	if len(accessions) > maxAccessions {
+		return nil, fmt.Errorf("at most %d accessions are supported", maxAccessions)
+	}
+	return accessions, nil
+}
+
+// fastaEntryAccession returns the accession of a FASTA entry, UniProt headers look like >sp|P69905|HBA_HUMAN
+func fastaEntryAccession(header string) string {
+	fields := strings.Fields(strings.TrimPrefix(header, ">"))
+	if len(fields) == 0 {
+		return ""
+	}
+	id := fields[0]
+	if parts := strings.Split(id, "|"); len(parts) >= 2 && (parts[0] == "sp" || parts[0] == "tr") {
+		return strings.ToUpper(parts[1])
+	}
+	return strings.ToUpper(id)
+}
+
+func withoutVersion(accession string) string {
+	if i := strings.LastIndexByte(accession, '.'); i != -1 {
+		return accession[:i]
+	}
+	return accession
+}
+
+// splitFastaEntries maps the accessions of the requested batch to their entries,
+// NCBI returns versioned accessions for accessions requested without version
+func splitFastaEntries(data string, requested []string) map[string]string {
+	entries := make(map[string]string)
+	var header string
+	var entry strings.Builder
+	flush := func() {
+		if header == "" {
+			return
+		}
+		accession := fastaEntryAccession(header)
+		for _, candidate := range requested {
+			if candidate == accession || (!strings.Contains(candidate, ".") && candidate == withoutVersion(accession)) {
+				entries[candidate] = entry.String()
+			}
+		}
+	}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, ">") {
+			flush()
+			header = line
+			entry.Reset()
+		}
+		if header != "" && strings.TrimSpace(line) != "" {
+			entry.WriteString(line)
+			entry.WriteByte('\n')
+		}
+	}
+	flush()
+	return entries
+}
+
+// NCBI allows 3 requests per second without an API key and 10 with one
+const (
+	ncbiRate       = 3
+	ncbiRateApiKey = 10
+)
+
+var ncbiLimiterMu sync.Mutex
+var ncbiLimiter *rate.Limiter
+
+// ncbiRequests returns the token bucket of the requests to NCBI, it is shared since the limit
+// applies to all requests of the server and the accession lists of several databases can be fetched at the same time
+func ncbiRequests(limit rate.Limit) *rate.Limiter {
+	ncbiLimiterMu.Lock()
+	defer ncbiLimiterMu.Unlock()
+	if ncbiLimiter == nil {
+		ncbiLimiter = rate.NewLimiter(limit, 1)
+	} else if ncbiLimiter.Limit() != limit {
+		ncbiLimiter.SetLimit(limit)
+	}
+	return ncbiLimiter
+}
+
+type accessionFetcher struct {
+	client      *http.Client
+	uniprotUrl  string
+	ncbiUrl     string
+	ncbiApiKey  string
+	ncbiLimiter *rate.Limiter
+	cacheDir    string
+	maxAge      time.Duration
+	batchSize   int
+	concurrency int
+	retries     int
+}
+
+func newAccessionFetcher(config ConfigRoot) (*accessionFetcher, error) {
+	fetcher := &accessionFetcher{
+		&http.Client{Timeout: 5 * time.Minute},
+		"https://rest.uniprot.org/uniprotkb/accessions",
+		"https://eutils.ncbi.nlm.nih.gov/entrez/eutils/efetch.fcgi",
+		"",
+		nil,
+		filepath.Join(config.Paths.Databases, ".accessions"),
+		30 * 24 * time.Hour,
+		100,
+		3,
+		defaultDownloadRetries,
+	}
+	if options := config.Worker.Accessions; options != nil {
+		if options.UniProtUrl != "" {
+			fetcher.uniprotUrl = options.UniProtUrl
+		}
+		if options.NcbiUrl != "" {
+			fetcher.ncbiUrl = options.NcbiUrl
+		}
+		fetcher.ncbiApiKey = options.NcbiApiKey
+		if options.BatchSize > 0 {
+			fetcher.batchSize = options.BatchSize
+		}
+		if options.Concurrency > 0 {
+			fetcher.concurrency = options.Concurrency
+		}
+		if options.CacheMaxAge != "" {
+			maxAge, err := time.ParseDuration(options.CacheMaxAge)
+			if err != nil {
+				return nil, err
+			}
+			fetcher.maxAge = maxAge
+		}
+	}
+	limit := rate.Limit(ncbiRate)
+	if fetcher.ncbiApiKey != "" {
+		limit = ncbiRateApiKey
+	}
+	if options := config.Worker.Accessions; options != nil && options.NcbiRate > 0 {
+		limit = rate.Limit(options.NcbiRate)
+	}
+	fetcher.ncbiLimiter = ncbiRequests(limit)
+	return fetcher, nil
+}
+
+func (f *accessionFetcher) cacheFile(source string, accession string) string {
+	return filepath.Join(f.cacheDir, source, accession+".fasta")
+}
+
+func (f *accessionFetcher) cached(source string, accession string) (string, bool) {
+	path := f.cacheFile(source, accession)
+	info, err := os.Stat(path)
+	if err != nil || (f.maxAge > 0 && time.Since(info.ModTime()) > f.maxAge) {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func (f *accessionFetcher) store(source string, accession string, entry string) error {
+	path := f.cacheFile(source, accession)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".part", []byte(entry), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".part", path)
+}
+
+func (f *accessionFetcher) batchUrl(source string, accessions []string) string {
+	if source == accessionUniProt {
+		return f.uniprotUrl + "?" + url.Values{"accessions": {strings.Join(accessions, ",")}, "format": {"fasta"}}.Encode()
+	}
+	query := url.Values{"db": {"protein"}, "id": {strings.Join(accessions, ",")}, "rettype": {"fasta"}, "retmode": {"text"}}
+	if f.ncbiApiKey != "" {
+		query.Set("api_key", f.ncbiApiKey)
+	}
+	return f.ncbiUrl + "?" + query.Encode()
+}
+
+func (f *accessionFetcher) fetchBatch(ctx context.Context, source string, accessions []string) (map[string]string, error) {
+	var entries map[string]string
+	err := withRetries(ctx, f.retries, func() error {
+		// retries count against the limit as well
+		if source == accessionNCBI {
+			if err := f.ncbiLimiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", f.batchUrl(source, accessions), nil)
+		if err != nil {
+			return err
+		}
+		res, err := f.client.Do(req)
+		if err != nil {
+			return &retryableError{err}
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return statusError(source, res)
+		}
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			return &retryableError{err}
+		}
+		entries = splitFastaEntries(string(data), accessions)
+		return nil
+	})
+	return entries, err
+}
+
+// Fetch returns the FASTA entries of the accessions in their order and the accessions that were not found,
+// cached entries are not fetched again, at most concurrency batches are fetched at the same time
+// and requests to NCBI are limited to the rate it allows
+func (f *accessionFetcher) Fetch(ctx context.Context, accessions []string, progress io.Writer) ([]string, []string, error) {
+	found := make(map[string]string, len(accessions))
+	missing := make(map[string][]string)
+	for _, accession := range accessions {
+		source := accessionSource(accession)
+		if entry, ok := f.cached(source, accession); ok {
+			found[accession] = entry
+		} else {
+			missing[source] = append(missing[source], accession)
+		}
+	}
+
+	type batch struct {
+		source     string
+		accessions []string
+	}
+	batches := make([]batch, 0)
+	for source, pending := range missing {
+		for start := 0; start < len(pending); start += f.batchSize {
+			end := start + f.batchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			batches = append(batches, batch{source, pending[start:end]})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	done := 0
+	sem := make(chan struct{}, f.concurrency)
+	for _, b := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(b batch) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			entries, err := f.fetchBatch(ctx, b.source, b.accessions)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			for accession, entry := range entries {
+				found[accession] = entry
+				if err := f.store(b.source, accession, entry); err != nil {
+					databaseLog.Error("Failed to cache accession", "accession", accession, "error", err)
+				}
+			}
+			done++
+			if progress != nil {
+				fmt.Fprintf(progress, "[%s] %.2f%% batch %d of %d\n", strings.Repeat("=", done*65/len(batches))+strings.Repeat(" ", 65-done*65/len(batches)), 100*float64(done)/float64(len(batches)), done, len(batches))
+			}
+		}(b)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+
+	entries := make([]string, 0, len(found))
+	notFound := make([]string, 0)
+	for _, accession := range accessions {
+		if entry, ok := found[accession]; ok {
+			entries = append(entries, entry)
+		} else {
+			notFound = append(notFound, accession)
+		}
+	}
+	return entries, notFound, nil
+}
+
+// fetchAccessionDatabase writes the sequences of the accession list of a database to its FASTA input
+func fetchAccessionDatabase(ctx context.Context, config ConfigRoot, file string, progress io.Writer) error {
+	data, err := os.ReadFile(file + ".accessions")
+	if err != nil {
+		return err
+	}
+	accessions, err := parseAccessionList(string(data))
+	if err != nil {
+		return err
+	}
+	fetcher, err := newAccessionFetcher(config)
+	if err != nil {
+		return err
+	}
+	entries, missing, err := fetcher.Fetch(ctx, accessions, progress)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errors.New("none of the accessions were found")
+	}
+	if len(missing) > 0 && progress != nil {
+		fmt.Fprintf(progress, "%d accessions were not found: %s\n", len(missing), strings.Join(missing, ", "))
+	}
+	return writeDatabaseSource(file+".fasta", strings.NewReader(strings.Join(entries, "")), false)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseAccessionList(t *testing.T) {
+	accessions, err := parseAccessionList("P69905, p68871\nNP_000517.1;A0A023GPI8-2 P69905\tAAA12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(accessions, []string{"P69905", "P68871", "NP_000517.1", "A0A023GPI8-2", "AAA12345"}) {
+		t.Errorf("parseAccessionList = %v", accessions)
+	}
+	if _, err := parseAccessionList("P69905 not-an-accession"); err == nil {
+		t.Error("expected error for invalid accession")
+	}
+	if _, err := parseAccessionList(" \n"); err == nil {
+		t.Error("expected error for empty list")
+	}
+}
+
+func TestFetchAccessions(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch req.URL.Path {
+		case "/uniprot":
+			for _, accession := range strings.Split(req.URL.Query().Get("accessions"), ",") {
+				if accession == "P69905" {
+					w.Write([]byte(">sp|P69905|HBA_HUMAN Hemoglobin subunit alpha\nMVLSPADKTN\nVKAAWGKVGA\n"))
+				}
+			}
+		case "/ncbi":
+			if req.URL.Query().Get("db") != "protein" {
+				http.Error(w, "invalid db", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(">NP_000508.1 hemoglobin subunit beta [Homo sapiens]\nMVHLTPEEKS\n\n"))
+		}
+	}))
+	defer srv.Close()
+
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	config.Worker.Accessions = &ConfigAccessions{UniProtUrl: srv.URL + "/uniprot", NcbiUrl: srv.URL + "/ncbi", BatchSize: 1}
+	fetcher, err := newAccessionFetcher(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessions := []string{"NP_000508", "P69905", "P99999"}
+	entries, missing, err := fetcher.Fetch(context.Background(), accessions, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		">NP_000508.1 hemoglobin subunit beta [Homo sapiens]\nMVHLTPEEKS\n",
+		">sp|P69905|HBA_HUMAN Hemoglobin subunit alpha\nMVLSPADKTN\nVKAAWGKVGA\n",
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("entries = %q", entries)
+	}
+	if !reflect.DeepEqual(missing, []string{"P99999"}) {
+		t.Errorf("missing = %v", missing)
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Errorf("expected one request per batch, got %d", got)
+	}
+
+	// found entries are cached, only the missing accession is requested again
+	atomic.StoreInt32(&requests, 0)
+	if _, _, err := fetcher.Fetch(context.Background(), accessions, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("expected 1 request with cached entries, got %d", got)
+	}
+}
+
+func TestFetchAccessionsRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.URL.Query().Get("id")
+		w.Write([]byte(">" + id + ".1 protein\nMVHLTPEEKS\n"))
+	}))
+	defer srv.Close()
+
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	config.Worker.Accessions = &ConfigAccessions{NcbiUrl: srv.URL, BatchSize: 1, Concurrency: 4, NcbiRate: 10}
+	fetcher, err := newAccessionFetcher(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	entries, _, err := fetcher.Fetch(context.Background(), []string{"NP_000508", "NP_000509", "NP_000510", "NP_000511"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("expected 4 entries, got %d", len(entries))
+	}
+	// the first request uses the token of the full bucket, the others wait for a new one each
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("expected parallel batches to be limited to 10 requests per second, took %s", elapsed)
+	}
+}
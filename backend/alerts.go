@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const defaultWorkerTimeout = 5 * time.Minute
+const defaultBacklogDuration = 15 * time.Minute
+const workerHeartbeatInterval = 1 * time.Minute
+
+// workers that are gone for longer are forgotten after their alert
+const forgetWorkerAfter = 24 * time.Hour
+
+// Alerter notifies the operators, a nil alerter only logs
+type Alerter struct {
+	email   []string
+	sender  string
+	mailer  MailTransport
+	webhook *ConfigWebhook
+}
+
+func NewAlerter(config ConfigRoot) *Alerter {
+	if config.Alerts == nil {
+		return nil
+	}
+	mailer := MailTransport(NullTransport{})
+	if config.Mail.Mailer != nil {
+		mailer = config.Mail.Mailer.GetTransport()
+	}
+	return &Alerter{config.Alerts.Email, config.Mail.Sender, mailer, config.Alerts.Webhook}
+}
+
+func (a *Alerter) Alert(subject string, body string) {
+	alertLog.Warn(subject, "alert", body)
+	if a == nil {
+		return
+	}
+	host, _ := os.Hostname()
+	subject += " on " + host
+	for _, recipient := range a.email {
+		if err := a.mailer.Send(Mail{Sender: a.sender, Recipient: recipient, Subject: subject, Body: body}); err != nil {
+			alertLog.Error("Failed to send alert", "recipient", recipient, "error", err)
+		}
+	}
+	if a.webhook != nil {
+		if err := sendAlertWebhook(*a.webhook, subject+": "+body); err != nil {
+			alertLog.Error("Failed to post alert", "error", err)
+		}
+	}
+}
+
+func sendAlertWebhook(hook ConfigWebhook, text string) error {
+	if hook.Type == "" {
+		var err error
+		if hook.Type, err = detectWebhook(hook.Url); err != nil {
+			return err
+		}
+	}
+	body, err := webhookPayload(hook.Type, text)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return postNotification(req)
+}
+
+type heartbeatStore interface {
+	Beat(worker string) error
+	Heartbeats() (map[string]time.Time, error)
+	Forget(worker string) error
+}
+
+type redisHeartbeatStore struct {
+	client *redis.Client
+}
+
+func (s redisHeartbeatStore) Beat(worker string) error {
+	return s.client.HSet("mmseqs:workers", worker, time.Now().Unix()).Err()
+}
+
+func (s redisHeartbeatStore) Heartbeats() (map[string]time.Time, error) {
+	values, err := s.client.HGetAll("mmseqs:workers").Result()
+	if err != nil {
+		return nil, err
+	}
+	heartbeats := make(map[string]time.Time, len(values))
+	for worker, value := range values {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			heartbeats[worker] = time.Unix(seconds, 0)
+		}
+	}
+	return heartbeats, nil
+}
+
+func (s redisHeartbeatStore) Forget(worker string) error {
+	return s.client.HDel("mmseqs:workers", worker).Err()
+}
+
+type memoryHeartbeatStore struct {
+	mu         sync.Mutex
+	heartbeats map[string]time.Time
+}
+
+var localHeartbeatStore = &memoryHeartbeatStore{heartbeats: make(map[string]time.Time)}
+
+func (s *memoryHeartbeatStore) Beat(worker string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heartbeats[worker] = time.Now()
+	return nil
+}
+
+func (s *memoryHeartbeatStore) Heartbeats() (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	heartbeats := make(map[string]time.Time, len(s.heartbeats))
+	for worker, last := range s.heartbeats {
+		heartbeats[worker] = last
+	}
+	return heartbeats, nil
+}
+
+func (s *memoryHeartbeatStore) Forget(worker string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.heartbeats, worker)
+	return nil
+}
+
+func newHeartbeatStore(jobsystem JobSystem) heartbeatStore {
+	if redisJobs, ok := jobsystem.(*RedisJobSystem); ok {
+		return redisHeartbeatStore{redisJobs.Client}
+	}
+	return localHeartbeatStore
+}
+
+// alertStore keeps the raised alerts, so servers sharing Redis alert once and a restarted server remembers them
+type alertStore interface {
+	// Raise is true if this call raised the alert
+	Raise(alert string) (bool, error)
+	// Resolve is true if this call resolved a raised alert, it also clears its start
+	Resolve(alert string) (bool, error)
+	// Since is when the condition of an alert was first seen, now if it was not seen before
+	Since(alert string, now time.Time) (time.Time, error)
+}
+
+type redisAlertStore struct {
+	client *redis.Client
+}
+
+func (s redisAlertStore) Raise(alert string) (bool, error) {
+	added, err := s.client.SAdd("mmseqs:alerts", alert).Result()
+	return added == 1, err
+}
+
+func (s redisAlertStore) Resolve(alert string) (bool, error) {
+	var removed *redis.IntCmd
+	_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		removed = pipe.SRem("mmseqs:alerts", alert)
+		pipe.HDel("mmseqs:alerts:since", alert)
+		return nil
+	})
+	return err == nil && removed.Val() == 1, err
+}
+
+func (s redisAlertStore) Since(alert string, now time.Time) (time.Time, error) {
+	if err := s.client.HSetNX("mmseqs:alerts:since", alert, now.Unix()).Err(); err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := s.client.HGet("mmseqs:alerts:since", alert).Int64()
+	return time.Unix(seconds, 0), err
+}
+
+type memoryAlertStore struct {
+	mu     sync.Mutex
+	raised map[string]bool
+	since  map[string]time.Time
+}
+
+func (s *memoryAlertStore) Raise(alert string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.raised[alert] {
+		return false, nil
+	}
+	s.raised[alert] = true
+	return true, nil
+}
+
+func (s *memoryAlertStore) Resolve(alert string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raised := s.raised[alert]
+	delete(s.raised, alert)
+	delete(s.since, alert)
+	return raised, nil
+}
+
+func (s *memoryAlertStore) Since(alert string, now time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if since, ok := s.since[alert]; ok {
+		return since, nil
+	}
+	s.since[alert] = now
+	return now, nil
+}
+
+func newAlertStore(jobsystem JobSystem) alertStore {
+	if redisJobs, ok := jobsystem.(*RedisJobSystem); ok {
+		return redisAlertStore{redisJobs.Client}
+	}
+	return &memoryAlertStore{raised: make(map[string]bool), since: make(map[string]time.Time)}
+}
+
+// workerName identifies a worker in the heartbeats and metrics, it stays the same when the worker restarts
+func workerName(config ConfigWorker) string {
+	if config.Id != "" {
+		return config.Id
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// sendHeartbeats reports that the worker is alive, remote workers report to the server
+func sendHeartbeats(jobsystem JobSystem, name string) {
+	var beat func(string) error
+	if remote, ok := jobsystem.(*RemoteJobSystem); ok {
+		beat = remote.Beat
+	} else {
+		beat = newHeartbeatStore(jobsystem).Beat
+	}
+	for {
+		if err := beat(name); err != nil {
+			workerLog.Error("Failed to send worker heartbeat", "error", err)
+		}
+		time.Sleep(workerHeartbeatInterval)
+	}
+}
+
+// AlertMonitor watches the heartbeats of the workers and the length of the queue
+type AlertMonitor struct {
+	jobsystem       JobSystem
+	heartbeats      heartbeatStore
+	alerts          alertStore
+	alerter         *Alerter
+	workerTimeout   time.Duration
+	backlog         int
+	backlogDuration time.Duration
+}
+
+func NewAlertMonitor(jobsystem JobSystem, config ConfigAlerts, alerter *Alerter) (*AlertMonitor, error) {
+	workerTimeout := defaultWorkerTimeout
+	backlogDuration := defaultBacklogDuration
+	var err error
+	if config.WorkerTimeout != "" {
+		if workerTimeout, err = time.ParseDuration(config.WorkerTimeout); err != nil {
+			return nil, err
+		}
+	}
+	if config.BacklogDuration != "" {
+		if backlogDuration, err = time.ParseDuration(config.BacklogDuration); err != nil {
+			return nil, err
+		}
+	}
+	return &AlertMonitor{jobsystem, newHeartbeatStore(jobsystem), newAlertStore(jobsystem), alerter, workerTimeout, config.Backlog, backlogDuration}, nil
+}
+
+func (m *AlertMonitor) checkWorkers(now time.Time) {
+	heartbeats, err := m.heartbeats.Heartbeats()
+	if err != nil {
+		alertLog.Error("Failed to read worker heartbeats", "error", err)
+		return
+	}
+	for worker, last := range heartbeats {
+		silent := now.Sub(last)
+		alert := "worker:" + worker
+		var changed bool
+		switch {
+		case silent > forgetWorkerAfter:
+			m.alerts.Resolve(alert)
+			m.heartbeats.Forget(worker)
+		case silent > m.workerTimeout:
+			if changed, err = m.alerts.Raise(alert); changed {
+				m.alerter.Alert("Worker missing", "Worker "+worker+" sent no heartbeat since "+last.Format(time.RFC3339))
+			}
+		default:
+			if changed, err = m.alerts.Resolve(alert); changed {
+				m.alerter.Alert("Worker recovered", "Worker "+worker+" sends heartbeats again")
+			}
+		}
+		if err != nil {
+			alertLog.Error("Failed to store worker alert", "worker", worker, "error", err)
+		}
+	}
+}
+
+func (m *AlertMonitor) checkBacklog(now time.Time) {
+	if m.backlog <= 0 {
+		return
+	}
+	length, err := m.jobsystem.QueueLength()
+	if err != nil {
+		alertLog.Error("Failed to read queue length", "error", err)
+		return
+	}
+	if length < m.backlog {
+		if cleared, err := m.alerts.Resolve("backlog"); err != nil {
+			alertLog.Error("Failed to store backlog alert", "error", err)
+		} else if cleared {
+			m.alerter.Alert("Queue backlog cleared", strconv.Itoa(length)+" jobs are queued")
+		}
+		return
+	}
+	since, err := m.alerts.Since("backlog", now)
+	if err != nil {
+		alertLog.Error("Failed to store backlog alert", "error", err)
+		return
+	}
+	if now.Sub(since) < m.backlogDuration {
+		return
+	}
+	if raised, err := m.alerts.Raise("backlog"); err != nil {
+		alertLog.Error("Failed to store backlog alert", "error", err)
+	} else if raised {
+		m.alerter.Alert("Queue backlog", strconv.Itoa(length)+" jobs are queued, at least "+strconv.Itoa(m.backlog)+" since "+since.Format(time.RFC3339))
+	}
+}
+
+func (m *AlertMonitor) Run() {
+	for {
+		time.Sleep(workerHeartbeatInterval)
+		now := time.Now()
+		m.checkWorkers(now)
+		m.checkBacklog(now)
+	}
+}
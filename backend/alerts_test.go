@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type fixedQueue struct {
+	JobSystem
+	length int
+}
+
+func (q *fixedQueue) QueueLength() (int, error) {
+	return q.length, nil
+}
+
+func TestAlertMonitor(t *testing.T) {
+	mailer := &recordingTransport{}
+	alerter := &Alerter{[]string{"admin@example.org"}, "mail@example.org", mailer, nil}
+	queue := &fixedQueue{nil, 0}
+	monitor, err := NewAlertMonitor(queue, ConfigAlerts{WorkerTimeout: "5m", Backlog: 10, BacklogDuration: "10m"}, alerter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heartbeats := &memoryHeartbeatStore{heartbeats: make(map[string]time.Time)}
+	monitor.heartbeats = heartbeats
+
+	now := time.Now()
+	heartbeats.heartbeats["worker-1"] = now.Add(-10 * time.Minute)
+	monitor.checkWorkers(now)
+	monitor.checkWorkers(now)
+	// a restarted server or a second one sharing the alerts does not alert again
+	other, _ := NewAlertMonitor(queue, ConfigAlerts{WorkerTimeout: "5m"}, alerter)
+	other.heartbeats, other.alerts = heartbeats, monitor.alerts
+	other.checkWorkers(now)
+	heartbeats.Beat("worker-1")
+	monitor.checkWorkers(time.Now())
+	other.checkWorkers(time.Now())
+	if len(mailer.mails) != 2 || !strings.HasPrefix(mailer.mails[0].Subject, "Worker missing") || !strings.HasPrefix(mailer.mails[1].Subject, "Worker recovered") {
+		t.Fatalf("unexpected worker alerts %+v", mailer.mails)
+	}
+
+	queue.length = 20
+	monitor.checkBacklog(now)
+	monitor.checkBacklog(now.Add(5 * time.Minute))
+	if len(mailer.mails) != 2 {
+		t.Error("backlog was reported before it lasted long enough")
+	}
+	monitor.checkBacklog(now.Add(11 * time.Minute))
+	monitor.checkBacklog(now.Add(12 * time.Minute))
+	queue.length = 0
+	monitor.checkBacklog(now.Add(13 * time.Minute))
+	if len(mailer.mails) != 4 || !strings.HasPrefix(mailer.mails[2].Subject, "Queue backlog") || !strings.HasPrefix(mailer.mails[3].Subject, "Queue backlog cleared") {
+		t.Errorf("unexpected backlog alerts %+v", mailer.mails)
+	}
+}
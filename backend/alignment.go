@@ -2,8 +2,6 @@ package main
 
 import (
 	"archive/tar"
-	"bufio"
-	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
@@ -33,6 +31,9 @@ type AlignmentEntry struct {
 	DbAln         string      `json:"dbAln"`
 	TaxonId       json.Number `json:"taxId,omitempty"`
 	TaxonName     string      `json:"taxName,omitempty"`
+	TaxonLineage  string      `json:"taxLineage,omitempty"`
+	// rank of the taxon, derived from the lineage (not a result column)
+	TaxonRank string `json:"taxRank,omitempty"`
 }
 
 type MarshalFormat int
@@ -66,6 +67,12 @@ type FoldseekAlignmentEntry struct {
 	TargetSeq     string        `json:"tSeq"`
 	TaxonId       json.Number   `json:"taxId,omitempty"`
 	TaxonName     string        `json:"taxName,omitempty"`
+	TaxonLineage  string        `json:"taxLineage,omitempty"`
+	// appended after the taxonomy columns, so results written before they existed are still parsed correctly
+	AlnTmScore float32 `json:"alnTmScore,omitempty"`
+	U          string  `json:"u,omitempty"`
+	T          string  `json:"t,omitempty"`
+	TaxonRank  string  `json:"taxRank,omitempty"`
 }
 
 func (entry FoldseekAlignmentEntry) MarshalJSON() ([]byte, error) {
@@ -137,6 +144,8 @@ type ComplexAlignmentEntry struct {
 	ComplexT        string        `json:"complext"`
 	TaxonId         json.Number   `json:"taxId,omitempty"`
 	TaxonName       string        `json:"taxName,omitempty"`
+	TaxonLineage    string        `json:"taxLineage,omitempty"`
+	TaxonRank       string        `json:"taxRank,omitempty"`
 }
 
 func (entry ComplexAlignmentEntry) MarshalJSON() ([]byte, error) {
@@ -190,6 +199,10 @@ type FastaEntry struct {
 type SearchResult struct {
 	Database   string      `json:"db"`
 	Alignments interface{} `json:"alignments"`
+	// links of the targets, keyed by target
+	Links map[string][]HitLink `json:"links,omitempty"`
+	// lowest common ancestor of the hits of each query, only if requested
+	Lca []TaxonLca `json:"lca,omitempty"`
 }
 
 func dbpaths(path string) (string, string) {
@@ -214,8 +227,11 @@ func ReadAlignment[T any](reader io.Reader) ([]T, error) {
 }
 
 func ReadQueryByIds(id Id, ids []int64, jobsbase string) ([]FastaEntry, error) {
-	base := filepath.Join(jobsbase, string(id))
-	query := filepath.Join(base, "query")
+	return readQueryEntries(filepath.Join(jobsbase, string(id), "query"), ids)
+}
+
+// readQueryEntries reads the given entries of a query database and its headers
+func readQueryEntries(query string, ids []int64) ([]FastaEntry, error) {
 	seqReader := Reader[uint32]{}
 	err := seqReader.Make(dbpaths(query))
 	fasta := make([]FastaEntry, 0)
@@ -229,13 +245,19 @@ func ReadQueryByIds(id Id, ids []int64, jobsbase string) ([]FastaEntry, error) {
 		return fasta, err
 	}
 
+	defer seqReader.Delete()
+	defer hdrReader.Delete()
 	for _, id := range ids {
-		sequence := strings.TrimSpace(seqReader.Data(id))
-		header := strings.TrimSpace(hdrReader.Data(id))
-		fasta = append(fasta, FastaEntry{header, sequence})
+		sequence, err := seqReader.Data(id)
+		if err != nil {
+			return fasta, err
+		}
+		header, err := hdrReader.Data(id)
+		if err != nil {
+			return fasta, err
+		}
+		fasta = append(fasta, FastaEntry{strings.TrimSpace(header), strings.TrimSpace(sequence)})
 	}
-	seqReader.Delete()
-	hdrReader.Delete()
 	return fasta, nil
 }
 
@@ -255,21 +277,25 @@ func ReadQueryByKeys(id Id, keys []uint32, jobsbase string) ([]FastaEntry, error
 		return fasta, err
 	}
 
+	defer seqReader.Delete()
+	defer hdrReader.Delete()
 	for _, key := range keys {
 		id, found := seqReader.Id(key)
 		sequence := ""
 		if found {
-			sequence = strings.TrimSpace(seqReader.Data(id))
+			if sequence, err = seqReader.Data(id); err != nil {
+				return fasta, err
+			}
 		}
 		id, found = hdrReader.Id(key)
 		header := ""
 		if found {
-			header = strings.TrimSpace(hdrReader.Data(id))
+			if header, err = hdrReader.Data(id); err != nil {
+				return fasta, err
+			}
 		}
-		fasta = append(fasta, FastaEntry{header, sequence})
+		fasta = append(fasta, FastaEntry{strings.TrimSpace(header), strings.TrimSpace(sequence)})
 	}
-	seqReader.Delete()
-	hdrReader.Delete()
 	return fasta, nil
 }
 
@@ -296,20 +322,26 @@ func ReadAlignments[T any, U interface{ ~uint32 | ~int64 }](id Id, entries []U,
 		}
 		all := make([][]T, 0)
 		for _, entry := range entries {
-			var body string
+			var alnId int64
 			if lookupByKey {
 				alnKey := any(entry).(uint32)
-				alnId, found := reader.Id(alnKey)
-				if !found {
+				var found bool
+				if alnId, found = reader.Id(alnKey); !found {
 					reader.Delete()
 					return nil, fmt.Errorf("missing key: %T", alnKey)
 				}
-				body = reader.Data(alnId)
 			} else {
-				body = reader.Data(any(entry).(int64))
+				alnId = any(entry).(int64)
+			}
+			// entries that do not exist have no alignments
+			var results []T
+			data, err := reader.Open(alnId)
+			if err == nil {
+				results, err = ReadAlignment[T](data)
+				data.Close()
+			} else if alnId < 0 || alnId >= reader.Size() {
+				err = nil
 			}
-			data := strings.NewReader(body)
-			results, err := ReadAlignment[T](data)
 			if err != nil {
 				reader.Delete()
 				return res, err
@@ -321,7 +353,7 @@ func ReadAlignments[T any, U interface{ ~uint32 | ~int64 }](id Id, entries []U,
 		}
 		reader.Delete()
 		base := filepath.Base(name)
-		res = append(res, SearchResult{strings.TrimPrefix(base, "alis_"), all})
+		res = append(res, SearchResult{strings.TrimPrefix(base, "alis_"), all, nil, nil})
 	}
 
 	return res, nil
@@ -332,7 +364,32 @@ func Alignments(id Id, entry []int64, databases []string, jobsbase string) ([]Se
 }
 
 func FSAlignments(id Id, entry []int64, databases []string, jobsbase string) ([]SearchResult, error) {
-	return ReadAlignments[FoldseekAlignmentEntry, int64](id, entry, databases, jobsbase)
+	res, err := ReadAlignments[FoldseekAlignmentEntry, int64](id, entry, databases, jobsbase)
+	if err != nil {
+		return res, err
+	}
+	for _, result := range res {
+		for _, alignments := range result.Alignments.([][]FoldseekAlignmentEntry) {
+			for i := range alignments {
+				clearEmptyColumns(&alignments[i])
+			}
+		}
+	}
+	return res, nil
+}
+
+// clearEmptyColumns removes the placeholders of the empty columns written for databases without taxonomy
+// and for 3Di queries without superposition
+func clearEmptyColumns(entry *FoldseekAlignmentEntry) {
+	if entry.TaxonId == "-" {
+		entry.TaxonId = ""
+		entry.TaxonName = ""
+		entry.TaxonLineage = ""
+	}
+	if entry.U == "-" {
+		entry.U = ""
+		entry.T = ""
+	}
 }
 
 func ComplexAlignments(id Id, entry []uint32, databases []string, jobsbase string) ([]SearchResult, error) {
@@ -390,9 +447,20 @@ func ResultArchive(w io.Writer, id Id, base string) (err error) {
 		return err
 	}
 
+	// results of multiple databases are additionally merged into one file, with the database as last column
+	var merged *os.File
+	if len(matches) > 1 {
+		merged, err = os.Create(filepath.Join(base, "merged.m8"))
+		if err != nil {
+			return err
+		}
+		defer merged.Close()
+	}
+
 	reader := Reader[uint32]{}
 	for _, item := range matches {
 		name := strings.TrimSuffix(item, ".index")
+		database := strings.TrimPrefix(filepath.Base(name), "alis_")
 
 		result, err := os.Create(name + ".m8")
 		if err != nil {
@@ -403,13 +471,21 @@ func ResultArchive(w io.Writer, id Id, base string) (err error) {
 			return err
 		}
 		for i := int64(0); i < reader.Size(); i++ {
-			data := strings.NewReader(reader.Data(i))
-			scanner := bufio.NewScanner(data)
-			for scanner.Scan() {
-				line := scanner.Bytes()
-				bytes.Trim(line, "\x00")
-				result.Write(line)
+			err := reader.ScanLines(i, func(line string) error {
+				result.WriteString(line)
 				result.Write([]byte{'\n'})
+				if merged != nil {
+					merged.WriteString(line)
+					merged.Write([]byte{'\t'})
+					merged.WriteString(database)
+					merged.Write([]byte{'\n'})
+				}
+				return nil
+			})
+			if err != nil {
+				reader.Delete()
+				result.Close()
+				return err
 			}
 		}
 		reader.Delete()
@@ -421,5 +497,21 @@ func ResultArchive(w io.Writer, id Id, base string) (err error) {
 			return err
 		}
 	}
+
+	if merged != nil {
+		if err = merged.Close(); err != nil {
+			return err
+		}
+		if err = addFile(tw, merged.Name()); err != nil {
+			return err
+		}
+	}
+
+	// the exact database versions are shipped with the results
+	if versions := filepath.Join(base, databaseVersionsFile); fileExists(versions) {
+		if err = addFile(tw, versions); err != nil {
+			return err
+		}
+	}
 	return nil
 }
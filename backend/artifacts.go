@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// smaller result files are not worth a lookup in paths.artifacts
+const minArtifactSize = 1024 * 1024
+
+// without link counts (windows) artifacts are removed once they were not reused for this long,
+// results that link to them keep their data, identical results are only stored twice from then on
+const artifactMaxAge = 30 * 24 * time.Hour
+
+func artifactPath(artifacts string, hash string) string {
+	return filepath.Join(filepath.Clean(artifacts), hash[:2], hash)
+}
+
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DeduplicateResults replaces the large result files of a finished job with hard links to content-addressed copies in
+// paths.artifacts, so identical results of repeated searches are only stored once. The link count of an artifact is its
+// reference count, it is removed by SweepArtifacts once no result links to it anymore (or by its age without link counts).
+// Job files are rewritten in place and are left out, as are encrypted files, which differ for identical results.
+// paths.artifacts has to be on the file system of paths.results.
+func DeduplicateResults(artifacts string, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || isJobFile(entry.Name()) || filepath.Ext(entry.Name()) == sealedSuffix {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < minArtifactSize {
+			continue
+		}
+		if err := deduplicateFile(artifacts, filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deduplicateFile(artifacts string, path string) error {
+	hash, err := fileHash(path)
+	if err != nil {
+		return err
+	}
+	artifact := artifactPath(artifacts, hash)
+	// an existing copy replaces the file atomically
+	if err := os.Link(artifact, path+".dedup"); err == nil {
+		// the age of an artifact is the time since it was last reused
+		now := time.Now()
+		if err := os.Chtimes(artifact, now, now); err != nil {
+			return err
+		}
+		return os.Rename(path+".dedup", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(artifact), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(path, artifact); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SweepArtifacts removes the artifacts that are not linked from any result anymore,
+// or the ones older than maxAge if the file system has no link counts
+func SweepArtifacts(artifacts string, maxAge time.Duration) {
+	err := filepath.WalkDir(artifacts, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		links, ok := linkCount(info)
+		if (ok && links <= 1) || (!ok && time.Since(info.ModTime()) > maxAge) {
+			if err := os.Remove(path); err != nil {
+				cleanupLog.Error("Failed to remove result artifact", "path", path, "error", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		cleanupLog.Error("Failed to sweep result artifacts", "error", err)
+	}
+}
+
+func artifactSweeper(config ConfigRoot) {
+	for {
+		SweepArtifacts(config.Paths.Artifacts, artifactMaxAge)
+		time.Sleep(1 * time.Hour)
+	}
+}
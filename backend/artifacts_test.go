@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDeduplicateResults(t *testing.T) {
+	artifacts := t.TempDir()
+	results := t.TempDir()
+	content := bytes.Repeat([]byte("q1\tt1\n"), minArtifactSize)
+	for _, id := range []string{"a", "b"} {
+		dir := filepath.Join(results, id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"alis_db", "job.fasta"} {
+			if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := DeduplicateResults(artifacts, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := os.Stat(filepath.Join(results, "a", "alis_db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(results, "b", "alis_db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("identical results should share one file")
+	}
+	if fa, _ := os.Stat(filepath.Join(results, "a", "job.fasta")); os.SameFile(fa, a) {
+		t.Error("job files should not be deduplicated")
+	}
+	hash, err := fileHash(filepath.Join(results, "a", "alis_db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "windows" {
+		SweepArtifacts(artifacts, artifactMaxAge)
+		if !fileExists(artifactPath(artifacts, hash)) {
+			t.Fatal("a recently used artifact was removed")
+		}
+		old := time.Now().Add(-2 * artifactMaxAge)
+		os.Chtimes(artifactPath(artifacts, hash), old, old)
+		SweepArtifacts(artifacts, artifactMaxAge)
+		if fileExists(artifactPath(artifacts, hash)) {
+			t.Error("an artifact that was not reused was kept")
+		}
+		return
+	}
+	os.RemoveAll(filepath.Join(results, "a"))
+	SweepArtifacts(artifacts, artifactMaxAge)
+	if !fileExists(artifactPath(artifacts, hash)) {
+		t.Fatal("a referenced artifact was removed")
+	}
+	os.RemoveAll(filepath.Join(results, "b"))
+	SweepArtifacts(artifacts, artifactMaxAge)
+	if fileExists(artifactPath(artifacts, hash)) {
+		t.Error("an unreferenced artifact was kept")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// the link count is not part of the file info on windows, artifacts are swept by their age instead
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
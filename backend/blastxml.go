@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const blastXMLHeader = `<?xml version="1.0"?>
+<!DOCTYPE BlastOutput PUBLIC "-//NCBI//NCBI BlastOutput/EN" "http://www.ncbi.nlm.nih.gov/dtd/NCBI_BlastOutput.dtd">
+`
+
+type blastHsp struct {
+	Num         int     `xml:"Hsp_num"`
+	BitScore    float64 `xml:"Hsp_bit-score"`
+	Score       int     `xml:"Hsp_score"`
+	Evalue      string  `xml:"Hsp_evalue"`
+	QueryFrom   int     `xml:"Hsp_query-from"`
+	QueryTo     int     `xml:"Hsp_query-to"`
+	HitFrom     int     `xml:"Hsp_hit-from"`
+	HitTo       int     `xml:"Hsp_hit-to"`
+	QueryFrame  int     `xml:"Hsp_query-frame"`
+	HitFrame    int     `xml:"Hsp_hit-frame"`
+	Identity    int     `xml:"Hsp_identity"`
+	Positive    int     `xml:"Hsp_positive"`
+	Gaps        int     `xml:"Hsp_gaps"`
+	AlignLength int     `xml:"Hsp_align-len"`
+	QuerySeq    string  `xml:"Hsp_qseq"`
+	HitSeq      string  `xml:"Hsp_hseq"`
+	Midline     string  `xml:"Hsp_midline"`
+}
+
+type blastHit struct {
+	Num       int        `xml:"Hit_num"`
+	Id        string     `xml:"Hit_id"`
+	Def       string     `xml:"Hit_def"`
+	Accession string     `xml:"Hit_accession"`
+	Length    int        `xml:"Hit_len"`
+	Hsps      []blastHsp `xml:"Hit_hsps>Hsp"`
+}
+
+type blastIteration struct {
+	XMLName     xml.Name   `xml:"Iteration"`
+	Num         int        `xml:"Iteration_iter-num"`
+	QueryId     string     `xml:"Iteration_query-ID"`
+	QueryDef    string     `xml:"Iteration_query-def"`
+	QueryLength int        `xml:"Iteration_query-len"`
+	Hits        []blastHit `xml:"Iteration_hits>Hit"`
+}
+
+// blastProgram is the BLAST program that matches a job, structure searches report their amino acid alignments
+func blastProgram(request JobRequest) string {
+	if job, ok := request.Job.(SearchJob); ok && job.SearchType != "" {
+		return job.SearchType
+	}
+	return "blastp"
+}
+
+// blastHspOf converts an alignment result line, the raw score is not part of
+// the results so the rounded bit score is reported in its place
+func blastHspOf(fields []string, columns alignmentColumns) (blastHsp, error) {
+	var hsp blastHsp
+	ints := make([]int, 5)
+	for i, column := range []int{3, 6, 7, 8, 9} {
+		value, err := strconv.Atoi(fields[column])
+		if err != nil {
+			return hsp, err
+		}
+		ints[i] = value
+	}
+	pident, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return hsp, err
+	}
+	bits, err := strconv.ParseFloat(fields[columns.Bits], 64)
+	if err != nil {
+		return hsp, err
+	}
+	qaln, taln := fields[columns.QueryAln], fields[columns.TargetAln]
+	midline := make([]byte, 0, len(qaln))
+	for i := 0; i < len(qaln); i++ {
+		if i < len(taln) && qaln[i] == taln[i] && qaln[i] != '-' {
+			midline = append(midline, qaln[i])
+		} else {
+			midline = append(midline, ' ')
+		}
+	}
+	identity := int(math.Round(pident / 100 * float64(ints[0])))
+	gaps := strings.Count(qaln, "-") + strings.Count(taln, "-")
+	hsp = blastHsp{1, bits, int(math.Round(bits)), fields[columns.Evalue], ints[1], ints[2], ints[3], ints[4], 0, 0, identity, identity, gaps, ints[0], qaln, taln, string(midline)}
+	return hsp, nil
+}
+
+// BlastXML writes the alignments in NCBI BLAST XML format (-outfmt 5). The hits of all databases
+// are reported in one iteration per query, queries without hits are left out.
+func BlastXML(w io.Writer, base string, databases []string, foldseek bool, program string, queries []uint32) error {
+	columns := resultColumns(foldseek)
+	readers := make([]*Reader[uint32], 0, len(databases))
+	defer func() {
+		for _, reader := range readers {
+			reader.Delete()
+		}
+	}()
+	keys := make(map[uint32]bool)
+	for _, database := range databases {
+		reader := &Reader[uint32]{}
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return err
+		}
+		readers = append(readers, reader)
+		for _, i := range resultEntries(reader, queries) {
+			keys[reader.Index[i].Key] = true
+		}
+	}
+	sorted := make([]uint32, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	out := bufio.NewWriter(w)
+	out.WriteString(blastXMLHeader)
+	out.WriteString("<BlastOutput>\n")
+	enc := xml.NewEncoder(out)
+	enc.Indent("  ", "  ")
+	header := []struct {
+		name  string
+		value string
+	}{
+		{"BlastOutput_program", program},
+		{"BlastOutput_version", "MMseqs2-App"},
+		{"BlastOutput_reference", "Steinegger M and Soeding J. MMseqs2 enables sensitive protein sequence searching for the analysis of massive data sets. Nat Biotechnol, doi: 10.1038/nbt.3988 (2017)."},
+		{"BlastOutput_db", strings.Join(databases, " ")},
+	}
+	for _, element := range header {
+		if err := enc.EncodeElement(element.value, xml.StartElement{Name: xml.Name{Local: element.name}}); err != nil {
+			return err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	out.WriteString("\n  <BlastOutput_iterations>")
+
+	enc.Indent("    ", "  ")
+	num := 0
+	for _, key := range sorted {
+		var iteration blastIteration
+		for _, reader := range readers {
+			id, found := reader.Id(key)
+			if !found {
+				continue
+			}
+			err := reader.ScanLines(id, func(line string) error {
+				fields := strings.Split(line, "\t")
+				if len(fields) <= columns.TargetAln {
+					return errors.New("unexpected alignment line: " + line)
+				}
+				hsp, err := blastHspOf(fields, columns)
+				if err != nil {
+					return err
+				}
+				if len(iteration.Hits) == 0 {
+					iteration.QueryDef = fields[0]
+					iteration.QueryLength, _ = strconv.Atoi(fields[columns.QueryLength])
+				}
+				length, _ := strconv.Atoi(fields[columns.TargetLength])
+				// the description of full headers follows the identifier
+				target, def, found := strings.Cut(fields[1], " ")
+				if !found {
+					def = target
+				}
+				iteration.Hits = append(iteration.Hits, blastHit{len(iteration.Hits) + 1, target, def, target, length, []blastHsp{hsp}})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(iteration.Hits) == 0 {
+			continue
+		}
+		num++
+		iteration.Num = num
+		iteration.QueryId = "Query_" + strconv.Itoa(num)
+		if err := enc.Encode(iteration); err != nil {
+			return err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	out.WriteString("\n  </BlastOutput_iterations>\n</BlastOutput>\n")
+	return out.Flush()
+}
+
+func init() {
+	RegisterResultFormatter("blastxml", resultFormat{"xml", "application/xml", func(r ResultRequest) (ResultWriter, error) {
+		return func(w io.Writer) error {
+			return BlastXML(w, r.Base, r.Databases, r.Foldseek, blastProgram(r.Request), r.Queries)
+		}, nil
+	}})
+}
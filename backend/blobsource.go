@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"golang.org/x/oauth2/google"
+)
+
+// database sources can be gs://bucket/object and az://account/container/blob URLs next to http(s) URLs,
+// they are downloaded over the HTTPS endpoints of Google Cloud Storage and Azure Blob Storage
+const (
+	gcsReadScope     = "https://www.googleapis.com/auth/devstorage.read_only"
+	azureScope       = "https://storage.azure.com/.default"
+	azureBlobVersion = "2020-10-02"
+)
+
+var gcsCredentials = &blobCredentials{find: googleTokenSource}
+var azureCredentials = &blobCredentials{find: azureTokenSource}
+
+// blobSourceURL maps gs:// and az:// URLs to the HTTPS URL of the object, other URLs are returned unchanged
+func blobSourceURL(source string) (*url.URL, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "gs":
+		if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return nil, errors.New("expected gs://bucket/object, got " + source)
+		}
+		endpoint := "https://storage.googleapis.com"
+		// same variable as the Google Cloud client libraries, e.g. for fake-gcs-server
+		if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+			endpoint = host
+			if !strings.Contains(host, "://") {
+				endpoint = "http://" + host
+			}
+		}
+		e, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return &url.URL{Scheme: e.Scheme, Host: e.Host, Path: "/" + u.Host + u.Path}, nil
+	case "az":
+		parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("expected az://account/container/blob, got " + source)
+		}
+		return &url.URL{Scheme: "https", Host: u.Host + ".blob.core.windows.net", Path: u.Path}, nil
+	}
+	return u, nil
+}
+
+// newSourceRequest creates a request for a database source, requests to object stores are
+// authorized with the credentials found by the standard credential chain of the cloud, public objects need none
+func newSourceRequest(ctx context.Context, method string, source string) (*http.Request, error) {
+	u, err := blobSourceURL(source)
+	if err != nil {
+		return nil, err
+	}
+	scheme, _, _ := strings.Cut(source, "://")
+
+	if scheme == "az" {
+		// a SAS token replaces all other credentials
+		if sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"); sas != "" {
+			u.RawQuery = sas
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case "gs":
+		token, err := gcsCredentials.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	case "az":
+		req.Header.Set("x-ms-version", azureBlobVersion)
+		if u.RawQuery != "" {
+			break
+		}
+		token, err := azureCredentials.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return req, nil
+}
+
+// blobCredentials finds the credentials of a cloud with the credential chain of its SDK. Requests are only
+// anonymous if the chain finds no credentials at all, a provider that fails fails the download instead.
+type blobCredentials struct {
+	mu sync.Mutex
+	// returns nil if no credentials are configured
+	find   func(ctx context.Context) (blobTokenSource, error)
+	source blobTokenSource
+	// the chain is asked again after a while, e.g. once a managed identity was assigned
+	anonymousUntil time.Time
+}
+
+// blobTokenSource returns an access token, the SDKs cache and refresh the tokens themselves
+type blobTokenSource func(ctx context.Context) (string, error)
+
+// Token returns an empty token if no credentials are configured
+func (c *blobCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.source == nil {
+		if time.Now().Before(c.anonymousUntil) {
+			return "", nil
+		}
+		source, err := c.find(ctx)
+		if err != nil {
+			return "", err
+		}
+		if source == nil {
+			c.anonymousUntil = time.Now().Add(10 * time.Minute)
+			return "", nil
+		}
+		c.source = source
+	}
+	return c.source(ctx)
+}
+
+func googleWellKnownFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// googleTokenSource uses the application default credentials: GOOGLE_APPLICATION_CREDENTIALS,
+// the file written by gcloud auth application-default login and the metadata server
+func googleTokenSource(ctx context.Context) (blobTokenSource, error) {
+	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" && !fileExists(googleWellKnownFile()) && !metadata.OnGCE() {
+		return nil, nil
+	}
+	// the token source refreshes its tokens after the download that created it is done
+	credentials, err := google.FindDefaultCredentials(context.Background(), gcsReadScope)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) (string, error) {
+		token, err := credentials.TokenSource.Token()
+		if err != nil {
+			return "", err
+		}
+		return token.AccessToken, nil
+	}, nil
+}
+
+// azureTokenSource uses DefaultAzureCredential: a service principal or workload identity configured
+// in the environment, the managed identity of the VM and the logins of the Azure CLIs
+func azureTokenSource(ctx context.Context) (blobTokenSource, error) {
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	options := policy.TokenRequestOptions{Scopes: []string{azureScope}}
+	if _, err := credential.GetToken(ctx, options); err != nil {
+		var failed *azidentity.AuthenticationFailedError
+		// none of the credentials is available and none was configured in the environment
+		if !errors.As(err, &failed) && os.Getenv("AZURE_CLIENT_ID") == "" && os.Getenv("AZURE_TENANT_ID") == "" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return func(ctx context.Context) (string, error) {
+		token, err := credential.GetToken(ctx, options)
+		if err != nil {
+			return "", err
+		}
+		return token.Token, nil
+	}, nil
+}
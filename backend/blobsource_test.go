@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobSourceURL(t *testing.T) {
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"gs://bucket/dbs/uniref50.fasta.gz", "https://storage.googleapis.com/bucket/dbs/uniref50.fasta.gz"},
+		{"gs://bucket/a b.fasta", "https://storage.googleapis.com/bucket/a%20b.fasta"},
+		{"az://account/container/dbs/pdb.fasta", "https://account.blob.core.windows.net/container/dbs/pdb.fasta"},
+		{"https://example.org/db.fasta", "https://example.org/db.fasta"},
+	}
+	for _, test := range tests {
+		u, err := blobSourceURL(test.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.String() != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.source, u.String(), test.expected)
+		}
+	}
+	for _, invalid := range []string{"gs://bucket", "az://account/container"} {
+		if _, err := blobSourceURL(invalid); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestGoogleServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		if fail || len(strings.Split(req.Form.Get("assertion"), ".")) != 3 {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"ya29.token","token_type":"Bearer","expires_in":3599}`))
+	}))
+	defer srv.Close()
+
+	credentials, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "worker@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(file, credentials, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", file)
+
+	cache := &blobCredentials{find: googleTokenSource}
+	token, err := cache.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "ya29.token" {
+		t.Errorf("unexpected token %s", token)
+	}
+
+	// a provider that refuses the credentials fails the request instead of downloading anonymously
+	fail = true
+	if _, err := (&blobCredentials{find: googleTokenSource}).Token(context.Background()); err == nil {
+		t.Error("expected refused credentials to fail")
+	}
+	if err := os.WriteFile(file, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&blobCredentials{find: googleTokenSource}).Token(context.Background()); err == nil {
+		t.Error("expected broken credentials to fail")
+	}
+}
+
+func TestAnonymousBlobCredentials(t *testing.T) {
+	calls := 0
+	cache := &blobCredentials{find: func(ctx context.Context) (blobTokenSource, error) {
+		calls++
+		return nil, nil
+	}}
+	for i := 0; i < 2; i++ {
+		if token, err := cache.Token(context.Background()); err != nil || token != "" {
+			t.Errorf("expected anonymous requests, got %q %v", token, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the credential chain to be asked once, got %d", calls)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// database types of the mmseqs .dbtype files
+const (
+	dbTypeAminoAcids  = 0
+	dbTypeNucleotides = 1
+	dbTypeHmmProfile  = 2
+)
+
+const (
+	DatabaseProtein    = "protein"
+	DatabaseNucleotide = "nucleotide"
+	DatabaseStructure  = "structure"
+	DatabaseProfile    = "profile"
+)
+
+// newDatabaseId returns a random id that stays with a database when its files are moved or renamed
+func newDatabaseId() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// legacyDatabaseId is the id given to databases that were created before databases had ids, it is derived
+// from their path when they are first listed and then stored, archived versions share the id of their database
+func legacyDatabaseId(path string) string {
+	path, _ = splitPinnedDatabase(path)
+	sum := sha1.Sum([]byte(path))
+	return hex.EncodeToString(sum[:8])
+}
+
+// databaseType classifies a database, databases that were not built yet have no type
+func databaseType(basepath string, params Params) string {
+	if params.Type != "" {
+		return params.Type
+	}
+	if params.Structure {
+		return DatabaseStructure
+	}
+	dbtype, err := databaseDbType(filepath.Join(basepath, params.Path))
+	if err != nil {
+		return ""
+	}
+	switch dbtype {
+	case dbTypeAminoAcids:
+		return DatabaseProtein
+	case dbTypeNucleotides:
+		return DatabaseNucleotide
+	case dbTypeHmmProfile:
+		return DatabaseProfile
+	}
+	return ""
+}
+
+// splitTags reads a comma separated list of tags
+func splitTags(tags string) []string {
+	res := make([]string, 0)
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			res = append(res, tag)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+// DatabaseFilter selects databases of the catalog, empty fields match every database
+type DatabaseFilter struct {
+	Type string
+	// true or false
+	Taxonomy string
+	Scope    string
+	// databases need all tags
+	Tags []string
+}
+
+func parseDatabaseFilter(query url.Values) (DatabaseFilter, error) {
+	filter := DatabaseFilter{query.Get("type"), query.Get("taxonomy"), query.Get("scope"), nil}
+	switch filter.Type {
+	case "", DatabaseProtein, DatabaseNucleotide, DatabaseStructure, DatabaseProfile:
+	default:
+		return filter, errors.New("invalid database type " + filter.Type)
+	}
+	if filter.Taxonomy != "" && filter.Taxonomy != "true" && filter.Taxonomy != "false" {
+		return filter, errors.New("taxonomy has to be true or false")
+	}
+	for _, tags := range query["tag"] {
+		filter.Tags = append(filter.Tags, splitTags(tags)...)
+	}
+	return filter, nil
+}
+
+func (f DatabaseFilter) Match(params Params) bool {
+	if f.Type != "" && params.Type != f.Type {
+		return false
+	}
+	if f.Taxonomy != "" && params.Taxonomy != (f.Taxonomy == "true") {
+		return false
+	}
+	if f.Scope != "" && !strings.EqualFold(params.Scope, f.Scope) {
+		return false
+	}
+outer:
+	for _, tag := range f.Tags {
+		for _, other := range params.Tags {
+			if strings.EqualFold(tag, other) {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// filterDatabases fills in the type of databases that were built before it was recorded and applies the filter
+func filterDatabases(basepath string, databases []Params, filter DatabaseFilter) []Params {
+	res := make([]Params, 0, len(databases))
+	for _, params := range databases {
+		params.Type = databaseType(basepath, params)
+		if filter.Match(params) {
+			res = append(res, params)
+		}
+	}
+	return res
+}
+
+// resolveDatabaseIds replaces database ids (optionally pinned as id@version) by the paths of the databases
+func resolveDatabaseIds(dbs []string, databases []Params) []string {
+	paths := make(map[string]string, len(databases))
+	for _, params := range databases {
+		if params.ID != "" {
+			paths[params.ID] = params.Path
+		}
+	}
+	resolved := make([]string, len(dbs))
+	for i, database := range dbs {
+		resolved[i] = database
+		id, version := splitPinnedDatabase(database)
+		// paths take precedence, so an id can not shadow a database
+		if path, ok := paths[id]; ok && !isDatabasePath(database, databases) {
+			if version != "" {
+				path += "@" + version
+			}
+			resolved[i] = path
+		}
+	}
+	return resolved
+}
+
+func isDatabasePath(database string, databases []Params) bool {
+	path, _ := splitPinnedDatabase(database)
+	for _, params := range databases {
+		if params.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDatabaseCatalog changes the tags and taxonomic scope a database is listed with
+func SetDatabaseCatalog(basepath string, path string, tags []string, scope string) (Params, error) {
+	return updateParams(basepath, path, func(params *Params) {
+		params.Tags = tags
+		params.Scope = scope
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDatabaseCatalog(t *testing.T) {
+	dir := t.TempDir()
+	for name, dbtype := range map[string]byte{"uniref": dbTypeAminoAcids, "nt": dbTypeNucleotides, "pfam": dbTypeHmmProfile} {
+		if err := os.WriteFile(filepath.Join(dir, name+".dbtype"), []byte{dbtype, 0, 0, 0}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	databases := []Params{
+		{ID: "u1", Path: "uniref", Taxonomy: true, Tags: []string{"Reference", "large"}, Scope: "all"},
+		{Path: "nt"},
+		{Path: "pfam", Tags: []string{"reference"}},
+		{Path: "afdb", Structure: true, Scope: "Homo sapiens"},
+	}
+
+	paths := func(query string) []string {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := parseDatabaseFilter(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := make([]string, 0)
+		for _, params := range filterDatabases(dir, databases, filter) {
+			res = append(res, params.Path)
+		}
+		return res
+	}
+	tests := map[string][]string{
+		"":                         {"uniref", "nt", "pfam", "afdb"},
+		"type=protein":             {"uniref"},
+		"type=nucleotide":          {"nt"},
+		"type=profile":             {"pfam"},
+		"type=structure":           {"afdb"},
+		"taxonomy=true":            {"uniref"},
+		"taxonomy=false":           {"nt", "pfam", "afdb"},
+		"scope=homo%20sapiens":     {"afdb"},
+		"tag=reference":            {"uniref", "pfam"},
+		"tag=reference&tag=large":  {"uniref"},
+		"tag=reference,large":      {"uniref"},
+		"type=protein&tag=missing": {},
+	}
+	for query, expected := range tests {
+		if got := paths(query); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: got %v, expected %v", query, got, expected)
+		}
+	}
+	if _, err := parseDatabaseFilter(url.Values{"type": {"dna"}}); err == nil {
+		t.Error("expected error for invalid type")
+	}
+
+	// ids resolve to the current path, paths are kept
+	resolved := resolveDatabaseIds([]string{"u1", "u1@2023", "nt"}, databases)
+	if !reflect.DeepEqual(resolved, []string{"uniref", "uniref@2023", "nt"}) {
+		t.Errorf("resolveDatabaseIds = %v", resolved)
+	}
+	if legacyDatabaseId("uniref@2023") != legacyDatabaseId("uniref") {
+		t.Error("archived versions should share the id of their database")
+	}
+
+	// the id of a database without one is kept when it is moved
+	dir = t.TempDir()
+	if err := SaveParams(filepath.Join(dir, "old.params"), Params{Name: "old", Path: "old", Status: StatusComplete}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Databases(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "old.params"), filepath.Join(dir, "moved.params")); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := Databases(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 1 || moved[0].Path != "moved" || moved[0].ID != legacyDatabaseId("old") {
+		t.Errorf("expected the moved database to keep its id, got %+v", moved)
+	}
+}
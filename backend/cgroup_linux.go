@@ -0,0 +1,149 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const cgroupCpuPeriod = 100000
+
+var cgroupCounter uint64
+
+type Cgroup struct {
+	path string
+	fd   *os.File
+}
+
+func NewCgroup(config ConfigCgroup, priority ConfigProcessPriority) (*Cgroup, error) {
+	if err := enableCgroupControllers(config, priority); err != nil {
+		return nil, err
+	}
+
+	name := "job-" + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatUint(atomic.AddUint64(&cgroupCounter, 1), 10)
+	path := filepath.Join(config.Root, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cgroup{path: path}
+
+	if config.CPUs > 0 {
+		quota := int64(config.CPUs * cgroupCpuPeriod)
+		if err := c.write("cpu.max", fmt.Sprintf("%d %d", quota, cgroupCpuPeriod)); err != nil {
+			c.Remove()
+			return nil, err
+		}
+	}
+	if config.Memory != "" {
+		if err := c.write("memory.max", config.Memory); err != nil {
+			c.Remove()
+			return nil, err
+		}
+		// a job that exceeds its limit should be killed instead of swapping
+		c.write("memory.swap.max", "0")
+	}
+	for _, line := range config.IO {
+		if err := c.write("io.max", line); err != nil {
+			c.Remove()
+			return nil, err
+		}
+	}
+	if priority.CPUWeight > 0 {
+		if err := c.write("cpu.weight", strconv.Itoa(priority.CPUWeight)); err != nil {
+			c.Remove()
+			return nil, err
+		}
+	}
+	if priority.IOWeight > 0 {
+		if err := c.write("io.weight", "default "+strconv.Itoa(priority.IOWeight)); err != nil {
+			c.Remove()
+			return nil, err
+		}
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		c.Remove()
+		return nil, err
+	}
+	c.fd = fd
+	return c, nil
+}
+
+func enableCgroupControllers(config ConfigCgroup, priority ConfigProcessPriority) error {
+	controllers := make([]string, 0)
+	if config.CPUs > 0 || priority.CPUWeight > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if config.Memory != "" {
+		controllers = append(controllers, "+memory")
+	}
+	if len(config.IO) > 0 || priority.IOWeight > 0 {
+		controllers = append(controllers, "+io")
+	}
+	if len(controllers) == 0 {
+		return nil
+	}
+	err := os.WriteFile(filepath.Join(config.Root, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644)
+	if err != nil {
+		return errors.New("could not enable cgroup controllers in " + config.Root + ": " + err.Error())
+	}
+	return nil
+}
+
+func (c *Cgroup) write(file string, value string) error {
+	err := os.WriteFile(filepath.Join(c.path, file), []byte(value), 0644)
+	if err != nil {
+		return errors.New("could not set " + file + " for cgroup " + c.path + ": " + err.Error())
+	}
+	return nil
+}
+
+// Apply makes the command start directly inside the cgroup, so no child process can escape it
+func (c *Cgroup) Apply(cmd *exec.Cmd) {
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.fd.Fd())
+}
+
+func (c *Cgroup) OOMKilled() bool {
+	file, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "oom_kill" {
+			continue
+		}
+		count, err := strconv.ParseInt(fields[1], 10, 64)
+		return err == nil && count > 0
+	}
+	return false
+}
+
+// Kill terminates every process in the cgroup, including children that left the process group
+func (c *Cgroup) Kill() error {
+	return c.write("cgroup.kill", "1")
+}
+
+func (c *Cgroup) Remove() error {
+	if c.fd != nil {
+		c.fd.Close()
+		c.fd = nil
+	}
+	// kill leftover processes, a populated cgroup cannot be removed
+	c.Kill()
+	return os.Remove(c.path)
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type Cgroup struct {
+}
+
+func NewCgroup(config ConfigCgroup, priority ConfigProcessPriority) (*Cgroup, error) {
+	return nil, errors.New("cgroups are only supported on linux")
+}
+
+func (c *Cgroup) Apply(cmd *exec.Cmd) {
+}
+
+func (c *Cgroup) OOMKilled() bool {
+	return false
+}
+
+func (c *Cgroup) Kill() error {
+	return nil
+}
+
+func (c *Cgroup) Remove() error {
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const checkpointFile = "checkpoint"
+
+// how often a running job touches its checkpoint file
+const checkpointHeartbeat = 1 * time.Minute
+
+// Checkpoint records the finished stages of a job and their runtimes in its temporary directory.
+// A job that is run again after a worker restart skips these stages, unfinished mmseqs calls reuse their temporary files.
+type Checkpoint struct {
+	path string
+	mu   sync.Mutex
+	done map[string]bool
+	// a stage starts when it is first checked with Done
+	started  map[string]time.Time
+	runtimes map[string]time.Duration
+	loaded   time.Time
+}
+
+func loadCheckpoint(tempDir string) (*Checkpoint, error) {
+	c := &Checkpoint{filepath.Join(tempDir, checkpointFile), sync.Mutex{}, make(map[string]bool), make(map[string]time.Time), make(map[string]time.Duration), time.Now()}
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// the heartbeat starts only after a minute, a requeued job should not look interrupted before that
+	now := time.Now()
+	if err := os.Chtimes(c.path, now, now); err != nil {
+		return nil, err
+	}
+
+	// lines are the stage and its runtime in seconds, older checkpoints only have the stage
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		stage, runtime, _ := strings.Cut(strings.TrimSpace(scanner.Text()), "\t")
+		if stage == "" {
+			continue
+		}
+		c.done[stage] = true
+		if seconds, err := strconv.ParseFloat(runtime, 64); err == nil {
+			c.runtimes[stage] = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return c, scanner.Err()
+}
+
+func (c *Checkpoint) Done(stage string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.started[stage]; !ok && !c.done[stage] {
+		c.started[stage] = time.Now()
+	}
+	return c.done[stage]
+}
+
+func (c *Checkpoint) Mark(stage string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	started, ok := c.started[stage]
+	if !ok {
+		started = c.loaded
+	}
+	runtime := time.Since(started)
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(stage + "\t" + strconv.FormatFloat(runtime.Seconds(), 'f', 3, 64) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	c.done[stage] = true
+	c.runtimes[stage] = runtime
+	return file.Close()
+}
+
+// Runtimes returns the runtimes of the finished stages, including the ones of earlier attempts
+func (c *Checkpoint) Runtimes() map[string]time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	runtimes := make(map[string]time.Duration, len(c.runtimes))
+	for stage, runtime := range c.runtimes {
+		runtimes[stage] = runtime
+	}
+	return runtimes
+}
+
+// heartbeat updates the modification time of the checkpoint until done is closed,
+// jobs with an old checkpoint were interrupted and are picked up by ResumeStaleJobs
+func (c *Checkpoint) heartbeat(done chan struct{}) {
+	ticker := time.NewTicker(checkpointHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			os.Chtimes(c.path, now, now)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// ResumeStaleJobs requeues running jobs whose checkpoint was not updated for staleAfter, their worker was stopped or crashed
+func ResumeStaleJobs(jobsystem JobSystem, config ConfigRoot, staleAfter time.Duration) {
+	dirs, err := os.ReadDir(config.Paths.Results)
+	if err != nil {
+		workerLog.Error("Failed to check for interrupted jobs", "error", err)
+		return
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		status, _, err := getStatusFromJobFile(filepath.Join(config.Paths.Results, dir.Name(), "job.json"))
+		if err != nil || status != StatusRunning {
+			continue
+		}
+		id := Id(dir.Name())
+		info, err := os.Stat(filepath.Join(jobTempDir(config, id), checkpointFile))
+		if err != nil || time.Since(info.ModTime()) < staleAfter {
+			continue
+		}
+		workerLog.Info("Resuming interrupted job", "ticket", id)
+		if err := jobsystem.Requeue(id); err != nil {
+			workerLog.Error("Failed to requeue job", "ticket", id, "error", err)
+		}
+	}
+}
+
+func jobResumer(jobsystem JobSystem, config ConfigRoot) {
+	if config.Worker.ResumeAfter == "" {
+		return
+	}
+	staleAfter, err := time.ParseDuration(config.Worker.ResumeAfter)
+	if err != nil {
+		workerLog.Warn("Invalid worker.resumeafter, interrupted jobs are not resumed", "error", err)
+		return
+	}
+	// a heartbeat has to be missed at least once
+	if staleAfter < 2*checkpointHeartbeat {
+		staleAfter = 2 * checkpointHeartbeat
+	}
+
+	for {
+		ResumeStaleJobs(jobsystem, config, staleAfter)
+		time.Sleep(staleAfter / 2)
+	}
+}
+
+// moveDb runs mvdb once, a resumed job skips the databases that were already moved out of its temporary directory
+func moveDb(executor Executor, checkpoint *Checkpoint, tool string, source string, target string) error {
+	stage := "mvdb-" + filepath.Base(target)
+	if checkpoint.Done(stage) {
+		return nil
+	}
+	if err := execCommandSync(executor, tool, "mvdb", source, target); err != nil {
+		return err
+	}
+	return checkpoint.Mark(stage)
+}
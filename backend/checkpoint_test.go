@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	checkpoint, err := loadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Done("search-uniref") {
+		t.Error("new checkpoint has finished stages")
+	}
+	if err := checkpoint.Mark("search-uniref"); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := loadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.Done("search-uniref") || resumed.Done("search-pdb") {
+		t.Errorf("unexpected stages after reload: %v", resumed.done)
+	}
+	if _, ok := resumed.Runtimes()["search-uniref"]; !ok {
+		t.Error("runtime of a finished stage was not kept")
+	}
+}
+
+// failingExecutor counts the started calls and fails all of them
+type failingExecutor struct {
+	LocalExecutor
+	started int
+}
+
+func (e *failingExecutor) Start(parameters []string) (Process, error) {
+	e.started++
+	return nil, errors.New("not started")
+}
+
+func TestMoveDbCheckpoint(t *testing.T) {
+	checkpoint, err := loadCheckpoint(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := &failingExecutor{}
+	if err := moveDb(executor, checkpoint, "mmseqs", "tmp/query", "result/query"); err == nil || executor.started != 1 {
+		t.Fatalf("mvdb was not run: %v", err)
+	}
+	if err := checkpoint.Mark("mvdb-query"); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveDb(executor, checkpoint, "mmseqs", "tmp/query", "result/query"); err != nil || executor.started != 1 {
+		t.Errorf("moved database was moved again: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type ClusterJob struct {
+	Size     int      `json:"size" validate:"required"`
+	Mode     string   `json:"mode" validate:"oneof=cluster linclust"`
+	MinSeqId float64  `json:"minseqid"`
+	Coverage float64  `json:"coverage"`
+	CovMode  int      `json:"covmode"`
+	Params   []string `json:"params,omitempty"`
+	query    string
+}
+
+func (r ClusterJob) Hash() Id {
+	h := sha256.New224()
+	h.Write(([]byte)(JobCluster))
+	h.Write([]byte(r.query))
+	h.Write([]byte(r.Mode))
+	h.Write([]byte(strconv.FormatFloat(r.MinSeqId, 'f', -1, 64)))
+	h.Write([]byte(strconv.FormatFloat(r.Coverage, 'f', -1, 64)))
+	h.Write([]byte(strconv.Itoa(r.CovMode)))
+	for _, value := range r.Params {
+		h.Write([]byte(value))
+	}
+
+	bs := h.Sum(nil)
+	return Id(base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(bs))
+}
+
+func (r ClusterJob) Rank() float64 {
+	return float64(r.Size)
+}
+
+func (r ClusterJob) WriteFasta(path string) error {
+	err := os.WriteFile(path, []byte(r.query), 0644)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func NewClusterJobRequest(query string, mode string, minSeqId float64, coverage float64, covMode int, email string, parameters string) (JobRequest, error) {
+	extra, err := ParseExtraParameters(JobCluster, parameters)
+	job := ClusterJob{
+		max(strings.Count(query, ">"), 1),
+		mode,
+		minSeqId,
+		coverage,
+		covMode,
+		extra,
+		query,
+	}
+
+	request := JobRequest{
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobCluster,
+		Job:    job,
+		Email:  email,
+	}
+
+	if err != nil {
+		return request, err
+	}
+
+	if mode != "cluster" && mode != "linclust" {
+		return request, errors.New("invalid cluster mode")
+	}
+
+	if minSeqId < 0 || minSeqId > 1 {
+		return request, errors.New("minimum sequence identity must be between 0 and 1")
+	}
+
+	if coverage < 0 || coverage > 1 {
+		return request, errors.New("coverage must be between 0 and 1")
+	}
+
+	if covMode < 0 || covMode > 5 {
+		return request, errors.New("invalid coverage mode")
+	}
+
+	return request, nil
+}
@@ -14,7 +14,10 @@ type ComplexSearchJob struct {
 	Database  []string `json:"database" validate:"required"`
 	Mode      string   `json:"mode" validate:"oneof=3di tmalign 3diaa"`
 	TaxFilter string   `json:"taxfilter"`
-	query     string
+	Params    []string `json:"params,omitempty"`
+	// defaults of each database that the parameter allowlist of complex searches accepts, the others are left out
+	DatabaseParams map[string][]string `json:"databaseparams,omitempty"`
+	query          string
 }
 
 func (r ComplexSearchJob) Hash() Id {
@@ -25,6 +28,10 @@ func (r ComplexSearchJob) Hash() Id {
 	if r.TaxFilter != "" {
 		h.Write([]byte(r.TaxFilter))
 	}
+	for _, value := range r.Params {
+		h.Write([]byte(value))
+	}
+	hashDatabaseParams(h, r.DatabaseParams)
 
 	sort.Strings(r.Database)
 
@@ -48,21 +55,33 @@ func (r ComplexSearchJob) WritePDB(path string) error {
 	return nil
 }
 
-func NewComplexSearchJobRequest(query string, dbs []string, validDbs []Params, mode string, resultPath string, email string, taxfilter string) (JobRequest, error) {
+func NewComplexSearchJobRequest(query string, dbs []string, validDbs []Params, mode string, resultPath string, email string, taxfilter string, parameters string) (JobRequest, error) {
+	extra, err := ParseExtraParameters(JobComplexSearch, parameters)
+	var defaults map[string][]string
+	if err == nil {
+		defaults, err = databaseSearchDefaults(JobComplexSearch, dbs, validDbs, extra)
+	}
+	mode = defaultSearchMode(mode, dbs, validDbs)
 	job := ComplexSearchJob{
 		max(strings.Count(query, "HEADER"), 1),
 		dbs,
 		mode,
 		taxfilter,
+		extra,
+		defaults,
 		query,
 	}
 
 	request := JobRequest{
-		job.Hash(),
-		StatusPending,
-		JobComplexSearch,
-		job,
-		email,
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobComplexSearch,
+		Job:    job,
+		Email:  email,
+	}
+
+	if err != nil {
+		return request, err
 	}
 
 	ids := make([]string, 0)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -16,14 +17,35 @@ var defaultFileContent = []byte(`{
 	// One of: mmseqs,foldseek,colabfold,predictprotein
 	"app": "mmseqs",
     // should mmseqs und webserver output be printed
-    "verbose": true,
+    "logging" : {
+        // debug, info, warn or error, debug also prints the output of the tools
+        "level"  : "debug",
+        // text or json lines
+        "format" : "text"
+        /* write to files instead of stderr and rotate them, without an external logrotate setup
+        ,
+        // {process} is server, worker, local or job-<ticket>, every process needs its own file
+        "file"   : "/var/log/mmseqs/{process}.log",
+        "access" : "/var/log/mmseqs/access.log",
+        "rotate" : {
+            "maxsize"  : "100M",
+            "interval" : "24h",
+            "keep"     : 7,
+            "maxage"   : "720h"
+        }
+        */
+    },
     "server" : {
         "address"    : "127.0.0.1:8081",
         // prefix for all API endpoints
         "pathprefix" : "/api/",
         // enables additional API endpoints for adding databases
-        // WARNING: No additional authentication provided. Enable only within trusted network/for trusted admins.
+        // WARNING: Only protected by dbtoken. Enable only within trusted network/for trusted admins.
         "dbmanagment": false,
+        // bearer token required for the database management endpoints, empty refuses all requests
+        "dbtoken": "",
+        // how often the databases directory is scanned for added or removed databases, empty only scans at startup
+        "dbwatch": "30s",
         /* enable HTTP Basic Auth (optional)
         "auth": {
             "username" : "",
@@ -46,13 +68,242 @@ var defaultFileContent = []byte(`{
         // should CORS headers be set to allow requests from anywhere
         "cors"       : true,
 		// should old jobs be checked on startup
-		"checkold"   : true
+		"checkold"   : true,
+        // shared secret for remote workers, enables the /worker endpoints if not empty
+        "workertoken": "",
+        /* let users upload FASTA files that are built into databases only their session can search (optional)
+        "sessiondatabases": {
+            // how long a session database is kept after its upload
+            "ttl"          : "24h",
+            // maximum size of an uploaded FASTA file
+            "maxsize"      : "50M",
+            // maximum number of databases per session, 0 is unlimited,
+            // a client also starts at most this many sessions per ttl
+            "maxdatabases" : 5
+        },
+        */
+        /* remove the results and tickets of finished jobs after a retention period (optional)
+        "retention": {
+            // time since a job finished
+            "maxage"     : "720h",
+            // retention periods per priority class (interactive, batch) that replace maxage
+            "priorities" : { "batch" : "168h" },
+            // how often expired results are looked for
+            "interval"   : "1h"
+        },
+        */
+        // limits for sequence queries, 0 disables a limit
+        "querylimits": {
+            // maximum length of a single sequence
+            "maxlength"    : 0,
+            // maximum summed length of all sequences
+            "totallength"  : 0,
+            "maxsequences" : 0
+        }
     },
     "worker": {
+        // name of this worker in heartbeat alerts and metrics, the hostname if empty; set it when several workers share a host
+        // "id" : "worker-1",
         // should workers exit immediately after SIGINT/SIGTERM signal or gracefully wait for job completion
         "gracefulexit": false,
-        // How many databases can be searched in parallel (used additional CPUs)
-        "paralleldatabases": 1
+        /* run each mmseqs/foldseek call in its own cgroup v2 (optional, linux only)
+        "cgroup": {
+            // delegated cgroup v2 subtree the worker is allowed to create child groups in
+            "root"   : "/sys/fs/cgroup/mmseqs-web",
+            // maximum number of CPUs, converted to cpu.max
+            "cpus"   : 16,
+            // written to memory.max, jobs exceeding it fail with an out of memory error
+            "memory" : "64G",
+            // lines written to io.max
+            "io"     : ["8:0 rbps=1073741824 wbps=1073741824"]
+        },
+        */
+        /* pull jobs from a server over HTTP instead of redis, for workers without access to the shared results directory (optional)
+        "remote": {
+            // URL of the API including the path prefix
+            "url"   : "https://search.example.org/api",
+            // has to match server.workertoken
+            "token" : ""
+        },
+        */
+        // how mmseqs/foldseek are executed, one of: local, container, slurm, kubernetes
+        "executor": "local",
+        /* settings for the container executor, every call runs in a new container (optional)
+        "container": {
+            // docker or podman
+            "runtime" : "docker",
+            // default image, can be overwritten with "image" in a database's .params file
+            "image"   : "ghcr.io/soedinglab/mmseqs2:latest",
+            // additional arguments passed to "docker run"
+            "args"    : []
+        },
+        */
+        /* settings for the slurm executor, every job is submitted with sbatch (optional)
+        // results and databases have to be on a file system shared with the compute nodes
+        "slurm": {
+            // MMseqs2-App binary and config file on the compute nodes, default to the ones of the worker
+            "binary"    : "",
+            "config"    : "",
+            // additional arguments for every sbatch call
+            "args"      : ["--partition=search"],
+            // additional sbatch arguments per job type
+            "resources" : {
+                "search" : ["--cpus-per-task=16", "--mem=64G"],
+                "msa"    : ["--cpus-per-task=32", "--mem=256G"]
+            },
+            // how often the job state is checked
+            "poll"      : "10s"
+        },
+        */
+        /* settings for the kubernetes executor, every job runs as a kubernetes Job using kubectl (optional)
+        // results and databases have to be on volumes shared with the worker, mounted at the same paths
+        "kubernetes": {
+            "namespace"       : "mmseqs-web",
+            "image"           : "ghcr.io/soedinglab/mmseqs-app-backend:master",
+            // config file inside the pod, e.g. mounted from a ConfigMap
+            "config"          : "/etc/mmseqs-web/config.json",
+            // resource requests and limits per job type
+            "resources"       : {
+                "search" : { "requests": { "cpu": "16", "memory": "64Gi" }, "limits": { "memory": "64Gi" } }
+            },
+            "nodeselector"    : {},
+            // merged into the node selector for jobs on GPU databases
+            "gpunodeselector" : { "nvidia.com/gpu.present": "true" },
+            "volumes"         : [
+                { "name": "data", "persistentVolumeClaim": { "claimName": "mmseqs-web-data" } },
+                { "name": "config", "configMap": { "name": "mmseqs-web-config" } }
+            ],
+            "volumemounts"    : [
+                { "name": "data", "mountPath": "/data" },
+                { "name": "config", "mountPath": "/etc/mmseqs-web" }
+            ],
+            "serviceaccount"  : "",
+            // how often the job state is checked
+            "poll"            : "10s"
+        },
+        */
+        // GPU device IDs available to this worker, at most one job runs per GPU
+        // databases with "gpu": true in their .params file can only be searched on workers with GPUs
+        "gpus": [],
+        // maximum wall-clock time of a job, can be overwritten with "timeout" in a database's .params file
+        "timeout": "1h",
+        // leftover temporary directories of pending or running jobs in paths.temporary are removed after this time
+        "tempmaxage": "24h",
+        // running jobs that did not report progress for this time are requeued and resume from their last finished stage
+        // empty disables resuming, e.g. "10m"
+        "resumeafter": "",
+        // compress alignment and MSA result files with zstd once a job finished, they are decompressed when served
+        "compressresults": false,
+        // also keep a gzip copy of the compressed flat result files for clients without zstd support
+        "gzipresults": false,
+        /* keep the intermediate files of jobs (prefilter and alignment databases, profiles) for debugging (optional),
+           they are kept in the result directory of the job and listed by /debug/intermediates/{ticket} with database management enabled
+        "intermediates": {
+            // kept files are removed after this time
+            "maxage": "48h"
+        },
+        */
+        /* default createindex options for databases without own index options (optional)
+        "index": {
+            // number of index splits, 0 lets createindex decide
+            "split"            : 0,
+            // maximum memory of one index split
+            "splitmemorylimit" : "100G",
+            // leave headers out of the index
+            "excludeheaders"   : false,
+            // createindex --search-type, e.g. 2 for translated or 3 for nucleotide databases
+            "searchtype"       : 0
+        },
+        */
+        /* fetch databases from an S3 compatible object store instead of a shared databases directory (optional)
+        // paths.databases of the worker is used as a local cache, the files of a database are downloaded
+        // before the first job that uses it and the least recently used databases are removed when maxsize is exceeded
+        "cache": {
+            // s3://bucket/prefix or gs://bucket/prefix containing the params and files of the databases
+            "source"    : "s3://mmseqs-databases/v1",
+            // defaults to AWS S3 in region or storage.googleapis.com, e.g. http://minio:9000
+            "endpoint"  : "",
+            "region"    : "us-east-1",
+            // default to AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (GCS: HMAC keys), public buckets need none
+            "accesskey" : "",
+            "secretkey" : "",
+            "maxsize"   : "500G"
+        },
+        */
+        /* how database sources are downloaded (optional), partial downloads are continued when the index job is retried
+        "download": {
+            // parallel range requests, servers without range support are downloaded over one connection
+            "connections" : 4,
+            "chunksize"   : "64M",
+            // summed over all connections per download, empty is unlimited
+            "bandwidth"   : "50M",
+            // per chunk, with exponential backoff
+            "retries"     : 5
+        },
+        */
+        /* how sequences of databases built from UniProt or NCBI accession lists are fetched (optional)
+        "accessions": {
+            // parallel requests
+            "concurrency" : 3,
+            // requests per second to NCBI, 3 without an API key and 10 with one by default
+            "ncbirate"    : 3,
+            "batchsize"   : 100,
+            // fetched sequences are kept in the .accessions directory of the databases
+            "cachemaxage" : "720h",
+            "ncbiapikey"  : ""
+        },
+        */
+        /* Prometheus metrics of the jobs of this worker: durations by stage, queue wait, temporary disk usage,
+           peak RSS and failures by error class, scraped from the address or pushed to a pushgateway (optional)
+        "metrics": {
+            "address"     : ":9101",
+            "pushgateway" : "http://pushgateway.example.org:9091",
+            "interval"    : "1m",
+            "job"         : "mmseqs_worker"
+        },
+        */
+        /* keep the indexes of frequently searched databases in the page cache (optional)
+        "warmup": {
+            // database paths, their precomputed .idx is read if it exists, otherwise the database itself
+            "databases"    : ["uniref30_2302"],
+            // also keep the given number of most searched databases warm
+            "top"          : 3,
+            // how often the files are read again, empty reads them only at startup and when needed
+            "interval"     : "1h",
+            // how often replaced or evicted files are detected and read again
+            "check"        : "1m",
+            // fraction of the files that has to be in the page cache for /ready to report a database as warm
+            "minresident"  : 0.9
+        },
+        */
+        // jobs with a rank (number of queries times number of databases) above this are batch jobs, 0 disables this
+        "batchthreshold": 0,
+        // per priority class (interactive, batch) timeouts
+        "prioritytimeouts": {},
+        /* per priority class (interactive, batch) process priorities, e.g. to keep a co-hosted server responsive (optional)
+        "priorities": {
+            "batch": {
+                // niceness of local calls from -20 to 19, also passed to sbatch --nice
+                "nice"      : 10,
+                // ionice scheduling class, one of: idle, best-effort, realtime (linux only)
+                "ioclass"   : "best-effort",
+                // ionice level for best-effort and realtime, from 0 (highest) to 7
+                "iolevel"   : 7,
+                // cgroup weights from 1 to 10000 (default 100), need worker.cgroup or the container executor
+                // the container executor only uses the weights
+                "cpuweight" : 20,
+                "ioweight"  : 20
+            }
+        },
+        */
+        // number of jobs a worker runs at the same time, the CPUs are split between running jobs by their slot cost
+        "slots": 1,
+        // job rank (number of queries times number of databases) covered by one slot, bigger jobs take more slots
+        // up to all slots, 0 makes every job take one slot
+        "slotrank": 0,
+        // How many databases can be searched in parallel, 0 searches all selected databases at once
+        // the available CPUs are split evenly between parallel searches
+        "paralleldatabases": 0
     },
     // paths to workfolders and mmseqs, special character ~ is resolved relative to the binary location
     "paths" : {
@@ -60,6 +311,12 @@ var defaultFileContent = []byte(`{
         "databases"    : "~databases",
         // path to job results and scratch directory, has to be shared between server/workers
         "results"      : "~jobs",
+        // scratch space for running jobs, each job gets its own directory that is removed when it finishes
+        // defaults to a directory inside the job results
+        // "temporary"    : "/tmp",
+        // large result files of identical jobs are stored once as hard links into this directory (optional),
+        // has to be on the same file system as the results
+        // "artifacts"    : "~jobs/.artifacts",
         /*
         // paths to colabfold templates
         "colabfold"    : {
@@ -73,7 +330,9 @@ var defaultFileContent = []byte(`{
             // paths for templates
             "pdb70"         : "~databases/pdb70",
             "pdbdivided"    : "~databases/pdbdivided",
-            "pdbobsolete"   : "~databases/pdbobsolete"
+            "pdbobsolete"   : "~databases/pdbobsolete",
+            // the search databases were padded for GPU searches, MSA and pair jobs against them then require a GPU
+            "gpu"           : false
         },
         */
         // path to foldseek binary
@@ -82,6 +341,120 @@ var defaultFileContent = []byte(`{
         // path to mmseqs binary
         "mmseqs"       : "~mmseqs"
     },
+    /* copy the results of finished jobs into an S3 compatible object store (optional)
+    // downloads of the result archive are streamed from the store, other result files are read from
+    // a copy in the cache that is removed after an hour without reads
+    "resultstore" : {
+        "source"    : "s3://mmseqs-results/jobs",
+        // defaults to AWS S3 in region or storage.googleapis.com, e.g. http://minio:9000
+        "endpoint"  : "",
+        "region"    : "us-east-1",
+        // default to AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (GCS: HMAC keys)
+        "accesskey" : "",
+        "secretkey" : "",
+        // keep the result files in paths.results after they were uploaded, only the job files stay otherwise
+        "keeplocal" : false,
+        // directory for the copies of stored results, defaults to mmseqs-results in the system temp directory
+        "cache"     : ""
+    },
+    */
+    /* encrypt the query and result files of finished jobs with AES-256-GCM (optional), they are decrypted when served.
+       Workers and the server need the same key.
+    "encryption" : {
+        // base64 encoded 32 byte key, e.g. from openssl rand -base64 32
+        "key"     : "",
+        // or read the key from a file
+        "keyfile" : ""
+    },
+    */
+    /* named pipelines of mmseqs modules, selected by submitting a search with the pipeline name as mode (optional)
+    // the steps run for each selected database, arguments can use the variables
+    // ${QUERY} (query fasta), ${QUERYDB} (query database), ${TARGET} (database), ${DBNAME}, ${RESULT} (alis_<database>),
+    // ${BASE} (result directory), ${WORK} (scratch directory of the database), ${TMP} and ${THREADS}
+    "pipelines" : {
+        "msa" : {
+            "description" : "Search and build a multiple sequence alignment",
+            "steps" : [
+                { "module": "search", "args": ["${QUERYDB}", "${TARGET}", "${WORK}/aln", "${TMP}", "--threads", "${THREADS}"] },
+                { "module": "result2msa", "args": ["${QUERYDB}", "${TARGET}", "${WORK}/aln", "${BASE}/${DBNAME}.a3m", "--msa-format-mode", "6"] }
+            ],
+            // files in the result directory that are included in the result archive, all alis_* databases if empty
+            "output" : ["*.a3m"]
+        }
+    },
+    */
+    /* rebuild databases automatically when a new upstream release is published (optional)
+    // keys are paths of existing databases, the rebuild runs as an index job on a worker in a staging directory
+    // and the new files replace the old ones once they are complete
+    "updates" : {
+        "uniref50" : {
+            // FASTA (or stockholm) file, gzip compressed files are decompressed
+            "source"   : "https://ftp.uniprot.org/pub/databases/uniprot/uniref/uniref50/uniref50.fasta.gz",
+            // the first line of this file identifies the release, without it the ETag or Last-Modified header of source is used
+            "release"  : "https://ftp.uniprot.org/pub/databases/uniprot/current_release/knowledgebase/complete/reldate.txt",
+            "format"   : "fasta",
+            // how often the release is checked
+            "interval" : "24h",
+            // previous releases that stay available as uniref50@<release> for pinned searches
+            "keep"     : 2,
+            // SHA256 of the download, either "sha256:<hex>" or the URL of a sha256sum file (optional)
+            "checksum"  : "https://example.org/uniref50/SHA256SUMS",
+            // URL of a base64 ed25519 signature of the hex encoded SHA256 and the base64 public key (optional)
+            "signature" : "https://example.org/uniref50/SHA256.sig",
+            "publickey" : ""
+        },
+        "pdb_seqres" : {
+            "source"   : "https://files.wwpdb.org/pub/pdb/derived_data/pdb_seqres.txt.gz",
+            "interval" : "168h"
+        },
+        "afdb_sequences" : {
+            "source"   : "https://ftp.ebi.ac.uk/pub/databases/alphafold/sequences.fasta"
+        },
+        // gs://bucket/object uses the Google application default credentials (GOOGLE_APPLICATION_CREDENTIALS,
+        // gcloud auth application-default login or the metadata server), public objects are downloaded
+        // anonymously if none are configured, credentials that fail stop the download
+        "internal_proteins" : {
+            "source"   : "gs://example-databases/internal_proteins.fasta.gz"
+        },
+        // az://account/container/blob uses AZURE_STORAGE_SAS_TOKEN, the AZURE_TENANT_ID, AZURE_CLIENT_ID and
+        // AZURE_CLIENT_SECRET of a service principal, workload identity, the managed identity of the VM or az login
+        "internal_nucleotides" : {
+            "source"   : "az://exampleaccount/databases/internal_nucleotides.fasta.gz"
+        }
+    },
+    */
+    /* named groups of databases that can be selected like a database, submissions are expanded to the members (optional)
+    // more groups can be created with the database management API
+    "groups" : {
+        "env_dbs" : ["bfd_mgy_colabfold", "gtdb"]
+    },
+    */
+    // minimum release of each binary (e.g. 15 for MMseqs2 15.6f452), the server and workers refuse to start with older versions
+    "minversions" : {},
+    /* stop accepting and starting jobs while a volume is low on free space (optional)
+    "diskspace" : {
+        // minimum free space on the results and temporary volumes, e.g. 500M, 20G, 1T
+        "results"   : "20G",
+        "temporary" : "50G",
+        // how often the free space is checked
+        "interval"  : "1m",
+        // recipients of alert emails when the free space falls below or recovers above a threshold
+        "alert"     : ["admin@example.org"],
+        // maximum size of the databases directory, installing new databases fails once it is reached
+        "databasequota" : "2T"
+    },
+    */
+    /* alert operators of missing worker heartbeats, disk space thresholds, failed database updates and queue backlog (optional)
+    "alerts" : {
+        "email"   : ["admin@example.org"],
+        "webhook" : { "url" : "https://hooks.slack.com/services/XXXX" },
+        // workers send a heartbeat every minute
+        "workertimeout"   : "5m",
+        // alert once at least "backlog" jobs are queued for "backlogduration"
+        "backlog"         : 1000,
+        "backlogduration" : "15m"
+    },
+    */
     // connection details for redis database, not used in -local mode
     "redis" : {
         "network"  : "tcp",
@@ -97,15 +470,22 @@ var defaultFileContent = []byte(`{
     },
     "mail" : {
         "mailer" : {
-            // three types available:
+            // five types available:
             // null: uses NullTransport class, which ignores all sent emails
             "type" : "null"
             /* smtp: Uses SMTP to send emails example for gmail
             "type" : "smtp",
             "transport" : {
-                // full host URL with port
-                "host" : "smtp.gmail.com:587",
-                // RFC 4616  PLAIN authentication
+                "host" : "smtp.gmail.com",
+                "port" : 587,
+                // empty uses STARTTLS if the server offers it, "starttls" requires it,
+                // "tls" connects with implicit TLS (port 465) and "none" never encrypts
+                "security" : "starttls",
+                // skip the certificate verification of relays with self-signed certificates
+                "insecure" : false,
+                // for connecting and sending, 30s by default
+                "timeout" : "30s",
+                // RFC 4616  PLAIN authentication, leave out the username for relays without authentication
                 "auth" : {
                     {
                         // empty for gmail
@@ -127,6 +507,27 @@ var defaultFileContent = []byte(`{
                 "secretkey" : "key-XXXX",
                 // mailgun API public key
                 "publickey" : "pubkey-XXXX"
+                // API keys can also be read from a file instead, the secret key defaults to MAILGUN_API_KEY
+                // "secretkeyfile" : "/run/secrets/mailgun",
+                // "endpoint" : "https://api.eu.mailgun.net/v3"
+            }
+            */
+            /* sendgrid: Uses the SendGrid v3 API to send emails
+            "type"      : "sendgrid",
+            "transport" : {
+                // defaults to SENDGRID_API_KEY
+                "apikey" : "SG.XXXX"
+                // "apikeyfile" : "/run/secrets/sendgrid"
+            }
+            */
+            /* ses: Uses the Amazon SES v2 API to send emails, the sender has to be verified
+            "type"      : "ses",
+            "transport" : {
+                "region" : "eu-central-1",
+                // default to AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+                "accesskey" : "",
+                "secretkey" : ""
+                // "secretkeyfile" : "/run/secrets/ses"
             }
             */
         },
@@ -135,7 +536,87 @@ var defaultFileContent = []byte(`{
         /* Bracket notation is also possible:
         "sender"    : "Webserver <mail@example.org>",
         */
-        // Email templates. First "%s" is resolved to the ticket identifier
+        /* post the outcome of every job to a Slack, Discord or Teams webhook, jobs submitted with a webhook
+           (Slack, Discord and Teams URLs only) are posted there instead. "text" takes the fields of the email templates.
+        "webhook" : {
+            // slack, discord or teams, detected from the URL if empty
+            "type" : "slack",
+            "url"  : "https://hooks.slack.com/services/XXXX",
+            "text" : "Job {{.TicketID}}: {{.Status}} {{.ResultURL}}"
+        },
+        */
+        /* only mail addresses that confirmed a verification link sent on their first use, needs redis with more than one server
+        "verification" : {
+            // public address of the API server
+            "url"      : "https://search.example.org/api",
+            "expires"  : "24h",
+            // empty to remember addresses forever
+            "remember" : "8760h",
+            "template" : {
+                "subject" : "Confirm notifications for {{.Address}}",
+                "body"    : "Open {{.VerifyURL}} to receive notifications of your search jobs."
+            }
+        },
+        */
+        /* send the first "limit" mails of each "window" to a recipient and collect the others into one digest mail per window,
+           workers sharing Redis count the mails of a recipient together
+        "batching" : {
+            "window" : "1h",
+            "limit"  : 5,
+            "template" : {
+                "subject" : "{{.Count}} search jobs finished",
+                "body"    : "{{range .Mails}}{{.Subject}}\n{{end}}"
+            }
+        },
+        */
+        // attach a TSV of the best hits to success mails
+        // "attachment" : { "hits" : 100, "maxsize" : 65536 },
+        /* append a signed unsubscribe link to notification mails, templates can place it with {{.UnsubscribeURL}}.
+           Mails also get one-click List-Unsubscribe headers, the link asks for confirmation and offers to subscribe again.
+           Opt-outs are kept in redis, otherwise in paths.results/.notify-optout.json of the server.
+        "unsubscribe" : {
+            // public address of the API server
+            "url"        : "https://search.example.org/api",
+            "secretfile" : "/etc/mmseqs/unsubscribe.secret"
+        },
+        */
+        // sign mails so recipients can verify they came from this server, only with the smtp or ses mailer
+        // "signing" : { "type" : "smime", "certificate" : "/etc/mmseqs/mail.crt", "key" : "/etc/mmseqs/mail.key" },
+        // "signing" : { "type" : "pgp", "key" : "/etc/mmseqs/mail.asc", "passphrasefile" : "/etc/mmseqs/mail.pass" },
+        // settings of notifiers compiled in with RegisterNotifier, submitters choose them with notify=<channel>&notify_<channel>=<recipient>
+        // "notifiers" : { "sms" : { "gateway" : "https://sms.example.org" } },
+        /* telegram: a submitter gets a link token from POST /notify/telegram, starts the bot with the returned t.me link
+           and submits with notify=telegram&notify_telegram=<token>. One of the servers sharing Redis polls the bot at a time.
+        "notifiers" : {
+            "telegram" : {
+                // defaults to TELEGRAM_BOT_TOKEN
+                "bottoken" : "123456:XXXX",
+                // "bottokenfile" : "/run/secrets/telegram",
+                "bot"      : "mmseqs_search_bot",
+                "text"     : "Job {{.TicketID}}: {{.Status}} {{.ResultURL}}"
+            }
+        },
+        */
+        // failed mails and webhooks are retried with exponential backoff up to a day, the queue is kept in redis if it is used
+        // and otherwise in paths.results/.notify-retry.json
+        // "retry" : { "attempts" : 5, "backoff" : "1m" },
+        // public address of the web interface, {{.ResultURL}} links to <url>/result/<ticket>/0
+        // "url" : "https://search.example.org",
+        // Email templates for text/template with the fields {{.TicketID}}, {{.Status}}, {{.ResultURL}}, {{.Runtime}},
+        // {{.Database}}, {{.Summary}}, {{.HitsTable}}, {{.Hits}}, {{.Error}} and {{.Hint}}. Templates without fields resolve "%s" to the ticket identifier.
+        // "bodyfile" reads the plain text body from a file and "htmlfile" adds an html/template alternative part, e.g.
+        // "success" : { "subject" : "Done -- {{.TicketID}}", "bodyfile" : "/etc/mmseqs-web/success.txt", "htmlfile" : "/etc/mmseqs-web/success.html" }
+        /* templates for submissions with locale=, "pt-br" is used for pt-BR, then "pt", then the templates below
+        "locales" : {
+            "de" : {
+                "templates" : {
+                    "success" : { "subject" : "Fertig -- {{.TicketID}}", "body" : "Ihr Job ist fertig: {{.ResultURL}}" },
+                    "error"   : { "subject" : "Fehler -- {{.TicketID}}", "body" : "{{.Error}}" }
+                },
+                "webhooktext" : "Job {{.TicketID}}: {{.Status}} {{.ResultURL}}"
+            }
+        },
+        */
         "templates" : {
             "success" : {
                 "subject" : "Done -- %s",
@@ -163,12 +644,14 @@ type ConfigColabFoldPaths struct {
 	Pdb70             string `json:"pdb70"`
 	PdbDivided        string `json:"pdbdivided"`
 	PdbObsolete       string `json:"pdbobsolete"`
+	Gpu               bool   `json:"gpu"`
 }
 
 type ConfigPaths struct {
 	Databases string                `json:"databases"`
 	Results   string                `json:"results"`
 	Temporary string                `json:"temporary"`
+	Artifacts string                `json:"artifacts"`
 	Mmseqs    string                `json:"mmseqs"`
 	FoldSeek  string                `json:"foldseek"`
 	FoldMason string                `json:"foldmason"`
@@ -190,6 +673,9 @@ type ConfigLocal struct {
 type ConfigMailTemplate struct {
 	Subject string `json:"subject"`
 	Body    string `json:"body"`
+	// replace body with the content of a file
+	BodyFile string `json:"bodyfile"`
+	HtmlFile string `json:"htmlfile"`
 }
 
 type ConfigMailTemplates struct {
@@ -202,6 +688,86 @@ type ConfigMail struct {
 	Mailer    *ConfigMailtransport `json:"mailer"`
 	Sender    string               `json:"sender"`
 	Templates ConfigMailTemplates  `json:"templates"`
+	// public address of the web interface for result links
+	Url string `json:"url"`
+	// chat notification of all jobs, submissions can give their own webhook
+	Webhook *ConfigWebhook `json:"webhook"`
+	// mail a verification link to an address before the first notification
+	Verification *ConfigMailVerification `json:"verification"`
+	// digest of the mails above a per-recipient limit
+	Batching *ConfigMailBatching `json:"batching"`
+	Retry    ConfigMailRetry     `json:"retry"`
+	// TSV of the best hits attached to success mails
+	Attachment *ConfigMailAttachment `json:"attachment"`
+	// signed unsubscribe links in mails, opted out addresses get no more notifications
+	Unsubscribe *ConfigMailUnsubscribe `json:"unsubscribe"`
+	// sign mails with S/MIME or PGP, needs the smtp or ses transport
+	Signing *ConfigMailSigning `json:"signing"`
+	// settings of the notifiers registered with RegisterNotifier, by channel
+	Notifiers map[NotifyChannel]json.RawMessage `json:"notifiers"`
+	// templates by the locale of a submission, empty ones fall back to the templates above
+	Locales map[string]ConfigMailLocale `json:"locales"`
+}
+
+type ConfigMailLocale struct {
+	Templates ConfigMailTemplates `json:"templates"`
+	// text of chat notifications, mail.webhook.text if empty
+	WebhookText string `json:"webhooktext"`
+}
+
+type ConfigMailSigning struct {
+	// smime or pgp
+	Type string `json:"type"`
+	// PEM certificate chain with the signing certificate first, for smime
+	Certificate string `json:"certificate"`
+	// PEM private key for smime, armored secret key for pgp
+	Key string `json:"key"`
+	// of an encrypted pgp key, defaults to the MAIL_SIGNING_PASSPHRASE environment variable
+	Passphrase     string `json:"passphrase"`
+	PassphraseFile string `json:"passphrasefile"`
+}
+
+type ConfigMailAttachment struct {
+	// at most 100, all of the job summary if zero
+	Hits int `json:"hits"`
+	// in bytes, rows above it are left out, 64KiB if zero
+	MaxSize int `json:"maxsize"`
+}
+
+// failed notifications are retried after backoff, 2*backoff, 4*backoff, ...
+type ConfigMailRetry struct {
+	// including the first delivery, 5 if zero, 1 disables retries
+	Attempts int `json:"attempts"`
+	// 1m if empty
+	Backoff string `json:"backoff"`
+}
+
+type ConfigMailBatching struct {
+	// 1h if empty
+	Window string `json:"window"`
+	// mails sent individually to a recipient per window, 5 if zero
+	Limit int `json:"limit"`
+	// text/template with {{.Count}}, {{.Mails}} (each with {{.Subject}} and {{.Body}}) and {{.Omitted}}
+	Template ConfigMailTemplate `json:"template"`
+}
+
+type ConfigMailVerification struct {
+	// public address of this server, links go to <url>/notify/verify/<token>
+	Url string `json:"url" validate:"required,url"`
+	// how long a link is valid, 24h if empty
+	Expires string `json:"expires"`
+	// how long an address stays verified, forever if empty
+	Remember string `json:"remember"`
+	// text/template with the fields {{.Address}} and {{.VerifyURL}}
+	Template ConfigMailTemplate `json:"template"`
+}
+
+type ConfigMailUnsubscribe struct {
+	// public address of this server, links go to <url>/notify/unsubscribe/<address>/<signature>
+	Url string `json:"url" validate:"required,url"`
+	// signs the links, defaults to the MAIL_UNSUBSCRIBE_SECRET environment variable
+	Secret     string `json:"secret"`
+	SecretFile string `json:"secretfile"`
 }
 
 type ConfigAuth struct {
@@ -218,19 +784,223 @@ type ConfigRateLimit struct {
 	AllowList      []string `json:"allowlist"`
 }
 
+type ConfigCgroup struct {
+	Root   string   `json:"root" validate:"required"`
+	CPUs   float64  `json:"cpus"`
+	Memory string   `json:"memory"`
+	IO     []string `json:"io"`
+}
+
+type ConfigProcessPriority struct {
+	Nice      int    `json:"nice" validate:"min=-20,max=19"`
+	IOClass   string `json:"ioclass" validate:"omitempty,oneof=idle best-effort realtime"`
+	IOLevel   int    `json:"iolevel" validate:"min=0,max=7"`
+	CPUWeight int    `json:"cpuweight" validate:"omitempty,min=1,max=10000"`
+	IOWeight  int    `json:"ioweight" validate:"omitempty,min=1,max=10000"`
+}
+
+type ConfigContainer struct {
+	Runtime string   `json:"runtime" validate:"omitempty,oneof=docker podman"`
+	Image   string   `json:"image" validate:"required"`
+	Args    []string `json:"args"`
+}
+
+type ConfigRemote struct {
+	Url   string `json:"url" validate:"required"`
+	Token string `json:"token" validate:"required"`
+}
+
+type ConfigSlurm struct {
+	// MMseqs2-App binary as seen from the compute nodes, defaults to the worker binary
+	Binary string `json:"binary"`
+	// config file as seen from the compute nodes, defaults to the worker config file
+	Config    string               `json:"config"`
+	Args      []string             `json:"args"`
+	Resources map[JobType][]string `json:"resources"`
+	Poll      string               `json:"poll"`
+}
+
+type ConfigKubernetesResources struct {
+	Requests map[string]string `json:"requests"`
+	Limits   map[string]string `json:"limits"`
+}
+
+type ConfigKubernetes struct {
+	Namespace string `json:"namespace"`
+	// image containing MMseqs2-App and mmseqs/foldseek, e.g. the image built from Dockerfile.backend
+	Image string `json:"image" validate:"required"`
+	// config file as seen from inside the pod
+	Config          string                                `json:"config" validate:"required"`
+	Resources       map[JobType]ConfigKubernetesResources `json:"resources"`
+	NodeSelector    map[string]string                     `json:"nodeselector"`
+	GpuNodeSelector map[string]string                     `json:"gpunodeselector"`
+	Volumes         []json.RawMessage                     `json:"volumes"`
+	VolumeMounts    []json.RawMessage                     `json:"volumemounts"`
+	ServiceAccount  string                                `json:"serviceaccount"`
+	Poll            string                                `json:"poll"`
+}
+
+type ConfigWarmup struct {
+	Databases   []string `json:"databases"`
+	Interval    string   `json:"interval"`
+	Top         int      `json:"top" validate:"min=0"`
+	Check       string   `json:"check"`
+	MinResident float64  `json:"minresident" validate:"min=0,max=1"`
+}
+
 type ConfigWorker struct {
-	GracefulExit      bool `json:"gracefulexit"`
-	ParallelDatabases int  `json:"paralleldatabases"`
+	// name of the worker in heartbeats and metrics, the hostname if empty
+	Id                string                                  `json:"id"`
+	GracefulExit      bool                                    `json:"gracefulexit"`
+	ParallelDatabases int                                     `json:"paralleldatabases"`
+	Slots             int                                     `json:"slots"`
+	SlotRank          float64                                 `json:"slotrank"`
+	Cgroup            *ConfigCgroup                           `json:"cgroup"`
+	Executor          ExecutorType                            `json:"executor" validate:"omitempty,oneof=local container slurm kubernetes"`
+	Container         *ConfigContainer                        `json:"container" validate:"required_if=Executor container"`
+	Slurm             *ConfigSlurm                            `json:"slurm" validate:"required_if=Executor slurm"`
+	Kubernetes        *ConfigKubernetes                       `json:"kubernetes" validate:"required_if=Executor kubernetes"`
+	Gpus              []string                                `json:"gpus"`
+	Timeout           string                                  `json:"timeout"`
+	BatchThreshold    float64                                 `json:"batchthreshold"`
+	PriorityTimeouts  map[PriorityClass]string                `json:"prioritytimeouts"`
+	Priorities        map[PriorityClass]ConfigProcessPriority `json:"priorities" validate:"dive"`
+	Remote            *ConfigRemote                           `json:"remote"`
+	TempMaxAge        string                                  `json:"tempmaxage"`
+	ResumeAfter       string                                  `json:"resumeafter"`
+	CompressResults   bool                                    `json:"compressresults"`
+	GzipResults       bool                                    `json:"gzipresults"`
+	Intermediates     *ConfigIntermediates                    `json:"intermediates"`
+	Warmup            *ConfigWarmup                           `json:"warmup"`
+	Index             *IndexOptions                           `json:"index"`
+	Cache             *ConfigObjectStore                      `json:"cache"`
+	Download          *ConfigDownload                         `json:"download"`
+	Accessions        *ConfigAccessions                       `json:"accessions"`
+	// job metrics of the worker for Prometheus
+	Metrics *ConfigWorkerMetrics `json:"metrics"`
+	// CPUs available to a job running in a slot, set per job by the worker
+	CPUs int `json:"-"`
+}
+
+type ConfigWorkerMetrics struct {
+	// listen address of the /metrics endpoint of the worker, like :9101
+	Address string `json:"address"`
+	// address of a Prometheus pushgateway the metrics are pushed to every interval
+	PushGateway string `json:"pushgateway" validate:"omitempty,url"`
+	// 1m if empty
+	Interval string `json:"interval"`
+	// job label of the pushed metrics, mmseqs_worker if empty, the instance is worker.id and removed on a graceful exit
+	Job string `json:"job"`
+}
+
+type ConfigIntermediates struct {
+	MaxAge string `json:"maxage"`
+}
+
+type ConfigDownload struct {
+	Connections int    `json:"connections" validate:"min=0"`
+	ChunkSize   string `json:"chunksize"`
+	Bandwidth   string `json:"bandwidth"`
+	Retries     int    `json:"retries" validate:"min=0"`
+}
+
+type ConfigAccessions struct {
+	Concurrency int     `json:"concurrency" validate:"min=0"`
+	BatchSize   int     `json:"batchsize" validate:"min=0"`
+	CacheMaxAge string  `json:"cachemaxage"`
+	NcbiApiKey  string  `json:"ncbiapikey"`
+	NcbiRate    float64 `json:"ncbirate" validate:"min=0"`
+	UniProtUrl  string  `json:"uniproturl" validate:"omitempty,url"`
+	NcbiUrl     string  `json:"ncbiurl" validate:"omitempty,url"`
+}
+
+type ConfigObjectStore struct {
+	Source    string `json:"source" validate:"required"`
+	Endpoint  string `json:"endpoint" validate:"omitempty,url"`
+	Region    string `json:"region"`
+	AccessKey string `json:"accesskey"`
+	SecretKey string `json:"secretkey"`
+	MaxSize   string `json:"maxsize"`
+}
+
+type ConfigResultStore struct {
+	ConfigObjectStore
+	KeepLocal bool   `json:"keeplocal"`
+	Cache     string `json:"cache"`
+}
+
+type ConfigEncryption struct {
+	Key     string `json:"key"`
+	KeyFile string `json:"keyfile"`
+}
+
+type ConfigDiskSpace struct {
+	Results   string   `json:"results"`
+	Temporary string   `json:"temporary"`
+	Interval  string   `json:"interval"`
+	Alert     []string `json:"alert"`
+	// maximum size of the databases directory, new databases can not be installed once it is reached
+	DatabaseQuota string `json:"databasequota"`
+}
+
+// ConfigAlerts are the operator alerts, sent with the mail transport of mail but to their own recipients
+type ConfigAlerts struct {
+	Email []string `json:"email"`
+	// Slack, Discord or Teams webhook
+	Webhook *ConfigWebhook `json:"webhook"`
+	// 5m if empty
+	WorkerTimeout string `json:"workertimeout"`
+	// queue length that counts as backlog, no backlog alerts if zero
+	Backlog int `json:"backlog"`
+	// 15m if empty
+	BacklogDuration string `json:"backlogduration"`
+}
+
+type ConfigQueryLimits struct {
+	MaxLength    int `json:"maxlength"`
+	TotalLength  int `json:"totallength"`
+	MaxSequences int `json:"maxsequences"`
+}
+
+type ConfigPipelineStep struct {
+	Module string   `json:"module" validate:"required"`
+	Args   []string `json:"args"`
+}
+
+type ConfigPipeline struct {
+	Description string               `json:"description"`
+	Steps       []ConfigPipelineStep `json:"steps" validate:"required,min=1,dive"`
+	Output      []string             `json:"output"`
 }
 
 type ConfigServer struct {
-	Address     string           `json:"address" validate:"required"`
-	PathPrefix  string           `json:"pathprefix"`
-	DbManagment bool             `json:"dbmanagment"`
-	CORS        bool             `json:"cors"`
-	CheckOld    bool             `json:"checkold"`
-	Auth        *ConfigAuth      `json:"auth"`
-	RateLimit   *ConfigRateLimit `json:"ratelimit"`
+	Address     string            `json:"address" validate:"required"`
+	PathPrefix  string            `json:"pathprefix"`
+	DbManagment bool              `json:"dbmanagment"`
+	DbToken     string            `json:"dbtoken"`
+	DbWatch     string            `json:"dbwatch"`
+	CORS        bool              `json:"cors"`
+	CheckOld    bool              `json:"checkold"`
+	Auth        *ConfigAuth       `json:"auth"`
+	RateLimit   *ConfigRateLimit  `json:"ratelimit"`
+	WorkerToken string            `json:"workertoken"`
+	QueryLimits ConfigQueryLimits `json:"querylimits"`
+	// users can upload their own target databases, disabled if nil
+	SessionDatabases *ConfigSessionDatabases `json:"sessiondatabases"`
+	// results are kept forever if nil
+	Retention *ConfigRetention `json:"retention"`
+}
+
+type ConfigRetention struct {
+	MaxAge     string                   `json:"maxage" validate:"required"`
+	Priorities map[PriorityClass]string `json:"priorities"`
+	Interval   string                   `json:"interval"`
+}
+
+type ConfigSessionDatabases struct {
+	TTL          string `json:"ttl"`
+	MaxSize      string `json:"maxsize"`
+	MaxDatabases int    `json:"maxdatabases" validate:"min=0"`
 }
 
 type ConfigApp string
@@ -243,15 +1013,63 @@ const (
 	AppFoldMason      ConfigApp = "foldmason"
 )
 
+type ConfigDatabaseUpdate struct {
+	Source    string `json:"source" validate:"required,url"`
+	Release   string `json:"release" validate:"omitempty,url"`
+	Format    string `json:"format" validate:"omitempty,oneof=fasta stockholm"`
+	Interval  string `json:"interval"`
+	Keep      int    `json:"keep" validate:"min=0"`
+	Checksum  string `json:"checksum"`
+	Signature string `json:"signature" validate:"omitempty,url"`
+	PublicKey string `json:"publickey" validate:"required_with=Signature"`
+}
+
+type ConfigLogging struct {
+	Level  string `json:"level" validate:"omitempty,oneof=debug info warn error DEBUG INFO WARN ERROR"`
+	Format string `json:"format" validate:"omitempty,oneof=text json"`
+	// log to a file instead of stderr, server and worker processes need their own files,
+	// {process} is replaced by server, worker, local or job-<ticket>
+	File string `json:"file"`
+	// access log of the server with one record per request, requests are only logged at debug level without it
+	Access string            `json:"access"`
+	Rotate ConfigLogRotation `json:"rotate"`
+}
+
+type ConfigLogRotation struct {
+	// rotate files to <file>.<time> above this size, like 100M
+	MaxSize string `json:"maxsize"`
+	// rotate files after this duration, like 24h
+	Interval string `json:"interval"`
+	// number of rotated files that are kept, all if zero
+	Keep int `json:"keep" validate:"min=0"`
+	// remove rotated files older than this, like 720h
+	MaxAge string `json:"maxage"`
+}
+
 type ConfigRoot struct {
-	App     ConfigApp    `json:"app" validate:"oneof=mmseqs foldseek colabfold predictprotein foldmason"`
-	Server  ConfigServer `json:"server" validate:"required"`
-	Worker  ConfigWorker `json:"worker"`
-	Paths   ConfigPaths  `json:"paths" validate:"required"`
-	Redis   ConfigRedis  `json:"redis"`
-	Local   ConfigLocal  `json:"local"`
-	Mail    ConfigMail   `json:"mail"`
-	Verbose bool         `json:"verbose"`
+	App       ConfigApp        `json:"app" validate:"oneof=mmseqs foldseek colabfold predictprotein foldmason"`
+	Server    ConfigServer     `json:"server" validate:"required"`
+	Worker    ConfigWorker     `json:"worker"`
+	Paths     ConfigPaths      `json:"paths" validate:"required"`
+	Redis     ConfigRedis      `json:"redis"`
+	Local     ConfigLocal      `json:"local"`
+	Mail      ConfigMail       `json:"mail"`
+	DiskSpace *ConfigDiskSpace `json:"diskspace"`
+	Alerts    *ConfigAlerts    `json:"alerts"`
+	Logging   ConfigLogging    `json:"logging"`
+	// deprecated, sets logging.level to debug if it is empty
+	Verbose   bool                            `json:"verbose"`
+	Pipelines map[string]ConfigPipeline       `json:"pipelines" validate:"dive"`
+	Updates   map[string]ConfigDatabaseUpdate `json:"updates" validate:"dive"`
+	Groups    map[string][]string             `json:"groups"`
+	// results are only kept in paths.results if nil
+	ResultStore *ConfigResultStore `json:"resultstore"`
+	// query and result files are stored unencrypted if nil
+	Encryption *ConfigEncryption `json:"encryption"`
+	// release numbers per binary name (mmseqs, foldseek, foldmason)
+	MinVersions map[string]int `json:"minversions"`
+	// detected at startup
+	Versions ToolVersions `json:"-"`
 }
 
 func ReadConfigFromFile(name string) (ConfigRoot, error) {
@@ -292,12 +1110,17 @@ func ReadConfig(r io.Reader, relativeTo string) (ConfigRoot, error) {
 
 	// set default values
 	config.Local.CheckOld = true
+	config.Worker.Timeout = "1h"
+	config.Worker.TempMaxAge = "24h"
 
 	if err := DecodeJsonAndValidate(r, &config); err != nil {
 		return config, fmt.Errorf("fatal error for config file: %s", err)
 	}
+	if config.DiskSpace != nil && config.DiskSpace.Interval == "" {
+		config.DiskSpace.Interval = "1m"
+	}
 
-	paths := []*string{&config.Paths.Databases, &config.Paths.Results, &config.Paths.Mmseqs}
+	paths := []*string{&config.Paths.Databases, &config.Paths.Results, &config.Paths.Mmseqs, &config.Paths.Artifacts}
 	for _, path := range paths {
 		if strings.HasPrefix(*path, "~") {
 			*path = strings.TrimLeft(*path, "~")
@@ -310,6 +1133,9 @@ func ReadConfig(r io.Reader, relativeTo string) (ConfigRoot, error) {
 
 func (c *ConfigRoot) CheckPaths() error {
 	paths := []string{c.Paths.Databases, c.Paths.Results}
+	if c.Paths.Artifacts != "" {
+		paths = append(paths, c.Paths.Artifacts)
+	}
 	for _, path := range paths {
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			os.MkdirAll(path, 0755)
@@ -10,23 +10,51 @@ import (
 
 	"encoding/json"
 	"errors"
-	"os/exec"
 	"strconv"
 )
 
 type Params struct {
-	Name       string `json:"name" validate:"required"`
-	Version    string `json:"version"`
-	Path       string `json:"path" validate:"required"`
-	Default    bool   `json:"default"`
-	Order      int    `json:"order"`
-	Taxonomy   bool   `json:"taxonomy"`
-	Complex    bool   `json:"complex"`
-	FullHeader bool   `json:"full_header"`
-	Index      string `json:"index"`
-	Search     string `json:"search"`
-	Multimer   string `json:"multimer"`
-	Status     Status `json:"status"`
+	// stays the same when the path of a database changes
+	ID          string         `json:"id,omitempty"`
+	Name        string         `json:"name" validate:"required"`
+	Version     string         `json:"version"`
+	Description string         `json:"description,omitempty"`
+	Citation    string         `json:"citation,omitempty"`
+	Links       []LinkTemplate `json:"links,omitempty"`
+	// protein, nucleotide, structure or profile
+	Type           string          `json:"type,omitempty"`
+	Scope          string          `json:"scope,omitempty"`
+	Tags           []string        `json:"tags,omitempty"`
+	Path           string          `json:"path" validate:"required"`
+	Default        bool            `json:"default"`
+	Order          int             `json:"order"`
+	Taxonomy       bool            `json:"taxonomy"`
+	Complex        bool            `json:"complex"`
+	Structure      bool            `json:"structure"`
+	FullHeader     bool            `json:"full_header"`
+	Index          string          `json:"index"`
+	IndexOptions   *IndexOptions   `json:"index_options,omitempty"`
+	Search         string          `json:"search"`
+	SearchDefaults *SearchDefaults `json:"search_defaults,omitempty"`
+	Multimer       string          `json:"multimer"`
+	Image          string          `json:"image"`
+	GPU            bool            `json:"gpu"`
+	Timeout        string          `json:"timeout"`
+	Source         string          `json:"source,omitempty"`
+	Format         string          `json:"format,omitempty"`
+	Checksum       string          `json:"checksum,omitempty"`
+	Upstream       string          `json:"upstream,omitempty"`
+	Disabled       bool            `json:"disabled,omitempty"`
+	Remove         bool            `json:"remove,omitempty"`
+	Archived       bool            `json:"archived,omitempty"`
+	// hash of the token of the session that uploaded a session database and when it expires
+	Session   string        `json:"session,omitempty"`
+	Expires   string        `json:"expires,omitempty"`
+	Sequences int64         `json:"sequences,omitempty"`
+	Size      int64         `json:"size,omitempty"`
+	Updated   string        `json:"updated,omitempty"`
+	Stage     DatabaseStage `json:"stage,omitempty"`
+	Status    Status        `json:"status"`
 }
 
 type paramsByOrder []Params
@@ -41,17 +69,62 @@ func (d paramsByOrder) Less(i, j int) bool {
 	return d[i].Order < d[j].Order
 }
 
-func CheckDatabase(basepath string, params Params, config ConfigRoot) error {
+// CheckDatabase builds the database and its index from the input files that are present,
+// stage is called (if not nil) when a build stage starts
+func CheckDatabase(basepath string, params Params, config ConfigRoot, executor Executor, stage func(DatabaseStage)) error {
+	if stage == nil {
+		stage = func(DatabaseStage) {}
+	}
 	app := config.Paths.Mmseqs
 	if config.App == "foldseek" {
 		app = config.Paths.FoldSeek
 	}
-	verbose := config.Verbose
+	// structure databases are built by foldseek from a tar archive or directory of PDB/mmCIF files,
+	// createdb also writes the 3Di (_ss) and C-alpha (_ca) components
+	if input := structureInput(basepath); input != "" {
+		if config.App != AppFoldSeek {
+			return errors.New("structure databases can only be built by foldseek")
+		}
+		if !fileExists(basepath) && !fileExists(basepath+".index") {
+			stage(DatabaseConverting)
+			err := quickExec(
+				executor,
+				app,
+				"createdb",
+				input,
+				basepath,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		stage(DatabaseIndexing)
+		parameters := []string{
+			"createindex",
+			basepath,
+			config.Paths.Temporary,
+			"--remove-tmp-files",
+			"true",
+			"--check-compatible",
+			"1",
+		}
+		parameters = append(parameters, indexParameters(params, config)...)
+		parameters = append(parameters, strings.Fields(params.Index)...)
+		err := quickExec(executor, app, parameters...)
+		if err != nil {
+			return err
+		}
+
+		return quickExec(executor, app, "touchdb", basepath)
+	}
+
 	if fileExists(basepath + ".fasta") {
 		if !fileExists(basepath) && !fileExists(basepath+".index") {
+			stage(DatabaseConverting)
 			err := quickExec(
+				executor,
 				app,
-				config.Verbose,
 				"createdb",
 				basepath+".fasta",
 				basepath,
@@ -61,6 +134,7 @@ func CheckDatabase(basepath string, params Params, config ConfigRoot) error {
 			}
 		}
 
+		stage(DatabaseIndexing)
 		parameters := []string{
 			"createindex",
 			basepath,
@@ -70,13 +144,14 @@ func CheckDatabase(basepath string, params Params, config ConfigRoot) error {
 			"--check-compatible",
 			"1",
 		}
+		parameters = append(parameters, indexParameters(params, config)...)
 		parameters = append(parameters, strings.Fields(params.Index)...)
-		err := quickExec(app, config.Verbose, parameters...)
+		err := quickExec(executor, app, parameters...)
 		if err != nil {
 			return err
 		}
 
-		err = quickExec(app, config.Verbose, "touchdb", basepath)
+		err = quickExec(executor, app, "touchdb", basepath)
 		if err != nil {
 			return err
 		}
@@ -84,9 +159,10 @@ func CheckDatabase(basepath string, params Params, config ConfigRoot) error {
 	}
 
 	if fileExists(basepath+".sto") && !fileExists(basepath+"_msa") && !fileExists(basepath+"_msa.index") {
+		stage(DatabaseConverting)
 		err := quickExec(
+			executor,
 			app,
-			config.Verbose,
 			"convertmsa",
 			basepath+".sto",
 			basepath+"_msa",
@@ -100,9 +176,10 @@ func CheckDatabase(basepath string, params Params, config ConfigRoot) error {
 
 	if fileExists(basepath+"_msa") && fileExists(basepath+"_msa.index") {
 		if !fileExists(basepath) && !fileExists(basepath+".index") {
+			stage(DatabaseConverting)
 			err := quickExec(
+				executor,
 				app,
-				config.Verbose,
 				"msa2profile",
 				basepath+"_msa",
 				basepath,
@@ -114,6 +191,7 @@ func CheckDatabase(basepath string, params Params, config ConfigRoot) error {
 			}
 		}
 
+		stage(DatabaseIndexing)
 		parameters := []string{
 			"createindex",
 			basepath,
@@ -125,13 +203,14 @@ func CheckDatabase(basepath string, params Params, config ConfigRoot) error {
 			"--check-compatible",
 			"1",
 		}
+		parameters = append(parameters, indexParameters(params, config)...)
 		parameters = append(parameters, strings.Fields(params.Index)...)
-		err := quickExec(app, verbose, parameters...)
+		err := quickExec(executor, app, parameters...)
 		if err != nil {
 			return err
 		}
 
-		err = quickExec(app, verbose, "touchdb", basepath)
+		err = quickExec(executor, app, "touchdb", basepath)
 		if err != nil {
 			return err
 		}
@@ -236,25 +315,36 @@ func Databases(basepath string, complete bool) ([]Params, error) {
 	res := make([]Params, 0)
 	for _, value := range matches {
 		params, err := ReadParams(value)
+		// broken params files are reported by the validation of the database watcher
 		if err != nil {
-			return nil, err
+			continue
 		}
 
-		if complete && params.Status != StatusComplete {
+		// disabled databases are hidden and can not be used by new jobs, archived versions have to be pinned,
+		// session databases are only visible to their session
+		if complete && (params.Status != StatusComplete || params.Disabled || params.Archived || params.Session != "") {
 			continue
 		}
 
 		base := filepath.Base(value)
 		name := strings.TrimSuffix(base, filepath.Ext(base))
 
+		changed := false
 		if params.Path != name {
 			params.Path = name
+			changed = true
+		}
+		// saved, so the id stays once the database is moved
+		if params.ID == "" {
+			params.ID = legacyDatabaseId(name)
+			changed = true
+		}
+		if changed {
 			err = SaveParams(value, params)
 			if err != nil {
 				return nil, err
 			}
 		}
-
 		res = append(res, params)
 	}
 
@@ -318,26 +408,13 @@ func SafePath(base, name, version string) string {
 	return result
 }
 
-func DeleteDatabase(basepath string) bool {
-	if fileExists(basepath + ".params") {
-		err := os.Rename(basepath+".params", basepath+".params_disabled")
-		if err != nil {
-			return false
-		}
-	}
-	return true
-}
-
-func quickExec(command string, verbose bool, params ...string) error {
-	cmd := exec.Command(command, params...)
-	if verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+func quickExec(executor Executor, command string, params ...string) error {
+	process, err := executor.Start(append([]string{command}, params...))
+	if err != nil {
+		return err
 	}
-	// Make sure MMseqs2's progress bar doesn't break
-	cmd.Env = append(os.Environ(), "TTY=0")
 
-	err := cmd.Run()
+	err = process.Wait()
 	if err != nil {
 		return err
 	}
@@ -345,6 +422,20 @@ func quickExec(command string, verbose bool, params ...string) error {
 	return nil
 }
 
+// structureDirSuffix is appended to the path of a database for a directory of structure files
+const structureDirSuffix = "_structures"
+
+// structureInput returns the tar archive or directory a structure database is built from, if there is one
+func structureInput(basepath string) string {
+	if fileExists(basepath + ".tar") {
+		return basepath + ".tar"
+	}
+	if info, err := os.Stat(basepath + structureDirSuffix); err == nil && info.IsDir() {
+		return basepath + structureDirSuffix
+	}
+	return ""
+}
+
 func fileExists(file string) bool {
 	_, err := os.Stat(file)
 	return !os.IsNotExist(err)
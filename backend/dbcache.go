@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DatabaseCache keeps the files of databases stored in an object store in the local databases directory of a worker,
+// the least recently used databases are removed once the cache grows beyond its maximum size
+type DatabaseCache struct {
+	store   *S3Store
+	dir     string
+	maxSize uint64
+	mu      sync.Mutex
+	users   map[string]int
+	// only one database is synced at a time
+	syncMu sync.Mutex
+}
+
+func NewDatabaseCache(config ConfigRoot) (*DatabaseCache, error) {
+	if config.Worker.Cache == nil {
+		return nil, nil
+	}
+	store, err := NewS3Store(*config.Worker.Cache)
+	if err != nil {
+		return nil, err
+	}
+	var maxSize uint64
+	if config.Worker.Cache.MaxSize != "" {
+		maxSize, err = parseByteSize(config.Worker.Cache.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(config.Paths.Databases, ".cache"), 0755); err != nil {
+		return nil, err
+	}
+	return &DatabaseCache{store, config.Paths.Databases, maxSize, sync.Mutex{}, make(map[string]int), sync.Mutex{}}, nil
+}
+
+// cachedDatabases are the databases a job needs locally, index jobs build databases and do not read from the cache
+func cachedDatabases(request JobRequest) []string {
+	if _, ok := request.Job.(IndexJob); ok {
+		return nil
+	}
+	return jobDatabases(request)
+}
+
+// isSourceFile matches the input files a database was built from, they are not needed for searches
+func isSourceFile(name string, path string) bool {
+	for _, suffix := range []string{".fasta", ".sto", ".tar", ".accessions", structureDirSuffix} {
+		if name == path+suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// download writes an object to the cache directory, the modification time is set to the one of the object
+func (c *DatabaseCache) download(ctx context.Context, object ObjectInfo) error {
+	body, err := c.store.Get(ctx, object.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	path := filepath.Join(c.dir, object.Key)
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chtimes(tmp, object.Modified, object.Modified); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// FetchParams downloads the params of databases, they are needed to schedule a job before its databases are synced
+func (c *DatabaseCache) FetchParams(ctx context.Context, dbs []string) error {
+	for _, database := range dbs {
+		objects, err := c.store.List(ctx, database+".params")
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, object := range objects {
+			if object.Key != database+".params" {
+				continue
+			}
+			found = true
+			if err := c.download(ctx, object); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return errors.New("database " + database + " not found in object store")
+		}
+	}
+	return nil
+}
+
+// sync downloads the files of a database that are missing or changed in the object store
+func (c *DatabaseCache) sync(ctx context.Context, database string) error {
+	objects, err := c.store.List(ctx, database)
+	if err != nil {
+		return err
+	}
+	// other databases sharing the prefix, e.g. pdb and pdb_seqres
+	others := make([]string, 0)
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, ".params") {
+			others = append(others, strings.TrimSuffix(object.Key, ".params"))
+		}
+	}
+
+outer:
+	for _, object := range objects {
+		if strings.Contains(object.Key, "/") || !isDatabaseFile(object.Key, database) || isSourceFile(object.Key, database) {
+			continue
+		}
+		for _, other := range others {
+			if other != database && len(other) > len(database) && isDatabaseFile(object.Key, other) {
+				continue outer
+			}
+		}
+		if info, err := os.Stat(filepath.Join(c.dir, object.Key)); err == nil && info.Size() == object.Size && info.ModTime().Equal(object.Modified) {
+			continue
+		}
+		databaseLog.Info("Fetching from object store", "key", object.Key)
+		if err := c.download(ctx, object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *DatabaseCache) marker(database string) string {
+	return filepath.Join(c.dir, ".cache", database)
+}
+
+// Acquire syncs the databases of a job and protects them from eviction until they are released
+func (c *DatabaseCache) Acquire(ctx context.Context, dbs []string) error {
+	c.mu.Lock()
+	for _, database := range dbs {
+		c.users[database]++
+	}
+	c.mu.Unlock()
+
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	for _, database := range dbs {
+		if err := c.sync(ctx, database); err != nil {
+			c.Release(dbs)
+			return err
+		}
+		if err := os.WriteFile(c.marker(database), nil, 0644); err != nil {
+			c.Release(dbs)
+			return err
+		}
+	}
+	if err := c.evict(); err != nil {
+		databaseLog.Error("Failed to evict databases from cache", "error", err)
+	}
+	return nil
+}
+
+// Release marks databases as recently used and allows their eviction
+func (c *DatabaseCache) Release(dbs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, database := range dbs {
+		c.users[database]--
+		if c.users[database] <= 0 {
+			delete(c.users, database)
+		}
+		os.Chtimes(c.marker(database), now, now)
+	}
+}
+
+// evict removes the least recently used databases that are not in use until the cache fits into its maximum size
+func (c *DatabaseCache) evict() error {
+	if c.maxSize == 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(c.dir, ".cache"))
+	if err != nil {
+		return err
+	}
+
+	type cached struct {
+		path  string
+		used  time.Time
+		files []string
+		size  uint64
+	}
+	others := make([]string, len(entries))
+	for i, entry := range entries {
+		others[i] = entry.Name()
+	}
+	databases := make([]cached, 0, len(entries))
+	var total uint64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files, err := databaseFiles(c.dir, entry.Name(), others)
+		if err != nil {
+			return err
+		}
+		var size uint64
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				size += uint64(info.Size())
+			}
+		}
+		total += size
+		databases = append(databases, cached{entry.Name(), info.ModTime(), files, size})
+	}
+	sort.Slice(databases, func(i, j int) bool {
+		return databases[i].used.Before(databases[j].used)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, database := range databases {
+		if total <= c.maxSize {
+			break
+		}
+		if c.users[database.path] > 0 {
+			continue
+		}
+		for _, file := range database.files {
+			if err := os.RemoveAll(file); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(c.marker(database.path)); err != nil {
+			return err
+		}
+		total -= database.size
+		databaseLog.Info("Evicted database from cache", "database", database.path)
+	}
+	if total > c.maxSize {
+		databaseLog.Warn("Database cache is larger than its maximum size", "bytes", total, "maxsize", c.maxSize)
+	}
+	return nil
+}
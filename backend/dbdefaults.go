@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SearchDefaults are curated search parameters of a database,
+// they are applied to every search of the database for the parameters the submission does not set
+type SearchDefaults struct {
+	Sensitivity float64 `json:"sensitivity,omitempty"`
+	MaxSeqs     int     `json:"maxseqs,omitempty"`
+	Evalue      float64 `json:"evalue,omitempty"`
+	// result mode of submissions that do not choose one, e.g. summary
+	Mode string `json:"mode,omitempty"`
+	// other allowlisted parameters, e.g. "--exhaustive-search 1"
+	Params string `json:"params,omitempty"`
+}
+
+// Parameters returns the defaults as flag value pairs, checked against the allowlist of the job type
+func (d SearchDefaults) Parameters(jobType JobType) ([]string, error) {
+	return ParseExtraParameters(jobType, strings.Join(d.fields(), " "))
+}
+
+// supported leaves out the defaults the job type does not have, e.g. --min-seq-id for complex searches
+func (d SearchDefaults) supported(jobType JobType) ([]string, error) {
+	fields := d.fields()
+	allowed := extraParameterAllowlist[jobType]
+	res := make([]string, 0, len(fields))
+	for i := 0; i+1 < len(fields); i += 2 {
+		if _, ok := allowed[fields[i]]; ok {
+			res = append(res, fields[i], fields[i+1])
+		}
+	}
+	return ParseExtraParameters(jobType, strings.Join(res, " "))
+}
+
+func (d SearchDefaults) fields() []string {
+	fields := make([]string, 0)
+	if d.Sensitivity != 0 {
+		fields = append(fields, "-s", strconv.FormatFloat(d.Sensitivity, 'f', -1, 64))
+	}
+	if d.MaxSeqs != 0 {
+		fields = append(fields, "--max-seqs", strconv.Itoa(d.MaxSeqs))
+	}
+	if d.Evalue != 0 {
+		fields = append(fields, "-e", strconv.FormatFloat(d.Evalue, 'g', -1, 64))
+	}
+	return append(fields, strings.Fields(d.Params)...)
+}
+
+// databaseSearchDefaults returns the default parameters of each selected database that the submitted parameters do not override
+func databaseSearchDefaults(jobType JobType, dbs []string, databases []Params, submitted []string) (map[string][]string, error) {
+	set := make(map[string]bool, len(submitted)/2)
+	for i := 0; i+1 < len(submitted); i += 2 {
+		set[submitted[i]] = true
+	}
+
+	var res map[string][]string
+	for _, params := range databases {
+		if params.SearchDefaults == nil || isIn(params.Path, dbs) == -1 {
+			continue
+		}
+		defaults, err := params.SearchDefaults.supported(jobType)
+		if err != nil {
+			return nil, err
+		}
+		applied := make([]string, 0, len(defaults))
+		for i := 0; i < len(defaults); i += 2 {
+			if !set[defaults[i]] {
+				applied = append(applied, defaults[i], defaults[i+1])
+			}
+		}
+		if len(applied) == 0 {
+			continue
+		}
+		if res == nil {
+			res = make(map[string][]string)
+		}
+		res[params.Path] = applied
+	}
+	return res, nil
+}
+
+// defaultSearchMode is the mode of the first selected database that defines one
+func defaultSearchMode(mode string, dbs []string, databases []Params) string {
+	if mode != "" {
+		return mode
+	}
+	for _, db := range dbs {
+		for _, params := range databases {
+			if params.Path == db && params.SearchDefaults != nil && params.SearchDefaults.Mode != "" {
+				return params.SearchDefaults.Mode
+			}
+		}
+	}
+	return mode
+}
+
+// hashDatabaseParams adds the applied defaults to a job hash, so jobs are not reused once the defaults change
+func hashDatabaseParams(h io.Writer, params map[string][]string) {
+	dbs := make([]string, 0, len(params))
+	for db := range params {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+	for _, db := range dbs {
+		h.Write([]byte(db))
+		for _, value := range params[db] {
+			h.Write([]byte(value))
+		}
+	}
+}
+
+// parseSearchDefaults reads the defaults of a database from JSON, an empty string removes them
+func parseSearchDefaults(data string, params Params) (*SearchDefaults, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var defaults SearchDefaults
+	if err := json.Unmarshal([]byte(data), &defaults); err != nil {
+		return nil, err
+	}
+	jobType := JobSearch
+	if params.Structure {
+		jobType = JobStructureSearch
+	}
+	if _, err := defaults.Parameters(jobType); err != nil {
+		return nil, err
+	}
+	return &defaults, nil
+}
+
+// SetDatabaseSearchDefaults replaces the search defaults of a database, nil removes them
+func SetDatabaseSearchDefaults(basepath string, path string, defaults *SearchDefaults) (Params, error) {
+	return updateParams(basepath, path, func(params *Params) {
+		params.SearchDefaults = defaults
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDatabaseSearchDefaults(t *testing.T) {
+	databases := []Params{
+		{Path: "small", SearchDefaults: &SearchDefaults{7.5, 1000, 0.001, "summary", "--exhaustive-search 1"}},
+		{Path: "large"},
+	}
+
+	defaults, err := databaseSearchDefaults(JobSearch, []string{"small", "large"}, databases, []string{"-s", "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{"small": {"--max-seqs", "1000", "-e", "0.001", "--exhaustive-search", "1"}}
+	if !reflect.DeepEqual(defaults, expected) {
+		t.Errorf("got %v, expected %v", defaults, expected)
+	}
+	if mode := defaultSearchMode("", []string{"large", "small"}, databases); mode != "summary" {
+		t.Errorf("expected the default mode, got %s", mode)
+	}
+	if mode := defaultSearchMode("all", []string{"small"}, databases); mode != "all" {
+		t.Errorf("expected the submitted mode, got %s", mode)
+	}
+
+	// the defaults change the job, so results with other defaults are not reused
+	a, _ := NewSearchJobRequest(">q\nMKV\n", nil, []string{"small"}, databases, "all", "", 0, false, "", "", "", "")
+	databases[0].SearchDefaults.MaxSeqs = 300
+	b, _ := NewSearchJobRequest(">q\nMKV\n", nil, []string{"small"}, databases, "all", "", 0, false, "", "", "", "")
+	if a.Id == b.Id {
+		t.Error("expected different job ids")
+	}
+
+	if _, err := parseSearchDefaults(`{"sensitivity": 12}`, Params{}); err == nil {
+		t.Error("expected an out of range sensitivity to be rejected")
+	}
+	if parsed, err := parseSearchDefaults("", Params{}); err != nil || parsed != nil {
+		t.Error("expected empty defaults to remove them")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// hidden, so it is not mistaken for a file of a database named groups
+const databaseGroupsFile = ".groups.json"
+
+// DatabaseGroup is a name that can be submitted instead of its member databases
+type DatabaseGroup struct {
+	Name      string   `json:"name"`
+	Databases []string `json:"databases"`
+	// groups from the config file can not be changed through the API
+	Config bool `json:"config"`
+}
+
+var databaseGroupsMu sync.Mutex
+
+func readStoredGroups(basepath string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	data, err := os.ReadFile(filepath.Join(basepath, databaseGroupsFile))
+	if os.IsNotExist(err) {
+		return groups, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// DatabaseGroups returns the groups of the config file and the ones created through the API, sorted by name
+func DatabaseGroups(config ConfigRoot) ([]DatabaseGroup, error) {
+	databaseGroupsMu.Lock()
+	stored, err := readStoredGroups(config.Paths.Databases)
+	databaseGroupsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]DatabaseGroup, 0, len(stored)+len(config.Groups))
+	for name, databases := range config.Groups {
+		groups = append(groups, DatabaseGroup{name, databases, true})
+	}
+	for name, databases := range stored {
+		if _, ok := config.Groups[name]; ok {
+			continue
+		}
+		groups = append(groups, DatabaseGroup{name, databases, false})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// SaveDatabaseGroup creates or replaces a group, an empty list of databases removes it
+func SaveDatabaseGroup(config ConfigRoot, name string, databases []string) error {
+	if name == "" || cleanPathComponent.MatchString(name) {
+		return errors.New("invalid group name")
+	}
+	if _, ok := config.Groups[name]; ok {
+		return errors.New("group " + name + " is defined in the config file")
+	}
+	if fileExists(filepath.Join(config.Paths.Databases, name+".params")) {
+		return errors.New("a database with the name " + name + " already exists")
+	}
+
+	databaseGroupsMu.Lock()
+	defer databaseGroupsMu.Unlock()
+	groups, err := readStoredGroups(config.Paths.Databases)
+	if err != nil {
+		return err
+	}
+	if len(databases) == 0 {
+		delete(groups, name)
+	} else {
+		groups[name] = databases
+	}
+	data, err := json.Marshal(groups)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(config.Paths.Databases, databaseGroupsFile)
+	if err := os.WriteFile(filename+".part", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".part", filename)
+}
+
+// expandDatabaseGroups replaces groups by their members that are currently available, so jobs always store
+// the databases they searched and changes of a group do not affect existing jobs
+func expandDatabaseGroups(groups []DatabaseGroup, dbs []string, valid []Params) ([]string, error) {
+	available := make(map[string]bool, len(valid))
+	for _, params := range valid {
+		available[params.Path] = true
+	}
+
+	expanded := make([]string, 0, len(dbs))
+	seen := make(map[string]bool)
+	add := func(database string) {
+		if !seen[database] {
+			seen[database] = true
+			expanded = append(expanded, database)
+		}
+	}
+	for _, database := range dbs {
+		found := false
+		for _, group := range groups {
+			if group.Name != database {
+				continue
+			}
+			found = true
+			members := 0
+			for _, member := range group.Databases {
+				// removed or disabled members are skipped, pinned versions are checked later
+				if _, version := splitPinnedDatabase(member); available[member] || version != "" {
+					add(member)
+					members++
+				}
+			}
+			if members == 0 {
+				return nil, errors.New("no database of group " + group.Name + " is available")
+			}
+			break
+		}
+		if !found {
+			add(database)
+		}
+	}
+	return expanded, nil
+}
+
+// resolveJobDatabases resolves the submitted databases of a job and returns them with the params of all available databases.
+// Clients can refer to databases by their id, it does not change when a database is moved,
+// groups are expanded to their members, databases can be pinned to an archived version as path@version
+// and databases with known problems are rejected, so a job does not fail with an error of mmseqs later.
+func resolveJobDatabases(config ConfigRoot, watcher *DatabaseWatcher, sessionToken string, dbs []string) ([]string, []Params, error) {
+	databases, err := Databases(config.Paths.Databases, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := sessionDatabases(config.Paths.Databases, sessionToken, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	databases = append(databases, session...)
+	dbs = resolveDatabaseIds(dbs, databases)
+	groups, err := DatabaseGroups(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbs, err = expandDatabaseGroups(groups, dbs, databases)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbs, databases, err = resolvePinnedDatabases(config.Paths.Databases, dbs, databases)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := watcher.Usable(dbs); err != nil {
+		return nil, nil, err
+	}
+	return dbs, databases, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDatabaseGroups(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	config.Groups = map[string][]string{"env_dbs": {"bfd", "gtdb"}}
+
+	if err := SaveDatabaseGroup(config, "proteomes", []string{"human", "mouse", "yeast"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveDatabaseGroup(config, "env_dbs", []string{"bfd"}); err == nil {
+		t.Error("expected groups of the config file to be read-only")
+	}
+	groups, err := DatabaseGroups(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Name != "env_dbs" || !groups[0].Config || groups[1].Name != "proteomes" {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+
+	valid := []Params{{Path: "human"}, {Path: "mouse"}, {Path: "bfd"}, {Path: "pdb"}}
+	dbs, err := expandDatabaseGroups(groups, []string{"pdb", "proteomes", "human", "env_dbs"}, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// yeast and gtdb are not available and skipped, duplicates are removed
+	if !reflect.DeepEqual(dbs, []string{"pdb", "human", "mouse", "bfd"}) {
+		t.Errorf("unexpected expansion %v", dbs)
+	}
+
+	if err := SaveDatabaseGroup(config, "proteomes", nil); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _ := DatabaseGroups(config); len(groups) != 1 {
+		t.Errorf("expected the group to be removed, got %v", groups)
+	}
+}
+
+func TestResolveJobDatabases(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	config.Groups = map[string][]string{"env_dbs": {"bfd", "gtdb"}}
+	for _, path := range []string{"uniref", "bfd", "gtdb"} {
+		if err := SaveParams(filepath.Join(config.Paths.Databases, path+".params"), Params{Name: path, Path: path, Status: StatusComplete}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	watcher := NewDatabaseWatcher(nil, config)
+	dbs, databases, err := resolveJobDatabases(config, watcher, "", []string{"env_dbs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dbs, []string{"bfd", "gtdb"}) || len(databases) != 3 {
+		t.Errorf("unexpected databases %v %v", dbs, databases)
+	}
+	if _, err := NewPairJobRequest(">a\nMKV\n", dbs, databases, "", ""); err != nil {
+		t.Errorf("pairing against resolved databases failed: %v", err)
+	}
+
+	watcher.problems["gtdb"] = []string{"missing gtdb.index"}
+	if _, _, err := resolveJobDatabases(config, watcher, "", []string{"env_dbs"}); err == nil {
+		t.Error("databases with problems should be rejected")
+	}
+	if _, err := NewPairJobRequest(">a\nMKV\n", []string{"pdb"}, databases, "", ""); err == nil {
+		t.Error("unknown databases should be rejected")
+	}
+}
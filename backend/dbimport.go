@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// databaseComponents are the suffixes of the files that mmseqs and foldseek create next to a database
+var databaseComponents = []string{"_h", "_ss", "_ss_h", "_ca", "_seq", "_seq_h", "_seq_ss", "_seq_ca", "_aln", "_msa", "_taxonomy", "_mapping", ".idx"}
+
+// importFiles lists the files of the database at source, other databases in the same directory
+// that share its prefix (e.g. pdb_seqres next to pdb) are left out
+func importFiles(source string) ([]string, error) {
+	dir := filepath.Dir(source)
+	base := filepath.Base(source)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	others := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".dbtype") {
+			continue
+		}
+		other := strings.TrimSuffix(name, ".dbtype")
+		if other == base || !isDatabaseFile(other, base) {
+			continue
+		}
+		component := false
+		for _, suffix := range databaseComponents {
+			if other == base+suffix {
+				component = true
+				break
+			}
+		}
+		if !component {
+			others = append(others, other)
+		}
+	}
+	return databaseFiles(dir, base, others)
+}
+
+// ImportDatabase registers a database that was built outside of the databases directory,
+// its files are either symlinked (mode link) or moved (mode move) into the databases directory
+func ImportDatabase(config ConfigRoot, source string, mode string, params Params) (Params, error) {
+	source = filepath.Clean(source)
+	if !filepath.IsAbs(source) {
+		return params, errors.New("the database to import needs an absolute path")
+	}
+	if mode != "link" && mode != "move" {
+		return params, errors.New("invalid import mode " + mode)
+	}
+	if params.Name == "" {
+		params.Name = filepath.Base(source)
+	}
+
+	check := params
+	check.Path = filepath.Base(source)
+	check.Status = StatusComplete
+	if problems := validateDatabase(filepath.Dir(source), check, config.App); len(problems) > 0 {
+		return params, errors.New("database can not be imported: " + strings.Join(problems, "; "))
+	}
+
+	files, err := importFiles(source)
+	if err != nil {
+		return params, err
+	}
+	path := SafePath(config.Paths.Databases, params.Name, params.Version)
+	for _, file := range files {
+		name := filepath.Base(file)
+		// the params are generated for the new location
+		if strings.HasSuffix(name, ".params") {
+			continue
+		}
+		target := filepath.Join(config.Paths.Databases, path+strings.TrimPrefix(name, filepath.Base(source)))
+		if mode == "link" {
+			err = os.Symlink(file, target)
+		} else {
+			err = os.Rename(file, target)
+		}
+		if err != nil {
+			return params, err
+		}
+	}
+
+	params.Path = path
+	params.Status = StatusComplete
+	params.Stage = DatabaseReady
+	if err := fillManifest(config.Paths.Databases, &params); err != nil {
+		return params, err
+	}
+	return params, SaveParams(filepath.Join(config.Paths.Databases, path+".params"), params)
+}
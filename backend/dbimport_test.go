@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportDatabase(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"pdb":               "AAAA\x00",
+		"pdb.index":         "0\t0\t5\n",
+		"pdb.dbtype":        "\x00\x00\x00\x00",
+		"pdb_h":             "a\n\x00",
+		"pdb_h.index":       "0\t0\t3\n",
+		"pdb_h.dbtype":      "\x0c\x00\x00\x00",
+		"pdb_seqres":        "CCCC\x00",
+		"pdb_seqres.index":  "0\t0\t5\n",
+		"pdb_seqres.dbtype": "\x00\x00\x00\x00",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	if _, err := ImportDatabase(config, "pdb", "link", Params{}); err == nil {
+		t.Error("expected relative paths to be rejected")
+	}
+	if _, err := ImportDatabase(config, filepath.Join(src, "missing"), "link", Params{}); err == nil {
+		t.Error("expected an invalid database to be rejected")
+	}
+
+	params, err := ImportDatabase(config, filepath.Join(src, "pdb"), "link", Params{Name: "PDB", Version: "2024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Status != StatusComplete || params.Sequences != 1 {
+		t.Errorf("unexpected params %+v", params)
+	}
+	for _, suffix := range []string{"", ".index", "_h", "_h.index", ".params"} {
+		if !fileExists(filepath.Join(config.Paths.Databases, params.Path+suffix)) {
+			t.Errorf("expected %s%s to be imported", params.Path, suffix)
+		}
+	}
+	if fileExists(filepath.Join(config.Paths.Databases, params.Path+"_seqres")) {
+		t.Error("expected pdb_seqres not to be imported")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// jobDatabases returns the databases a job reads from or builds
+func jobDatabases(request JobRequest) []string {
+	switch job := request.Job.(type) {
+	case SearchJob:
+		return job.Database
+	case StructureSearchJob:
+		return job.Database
+	case ComplexSearchJob:
+		return job.Database
+	case IndexJob:
+		return []string{job.Path}
+	}
+	return nil
+}
+
+// databaseUsers counts the pending and running jobs that reference a database
+func databaseUsers(config ConfigRoot, path string) (int, error) {
+	entries, err := os.ReadDir(config.Paths.Results)
+	if err != nil {
+		return 0, err
+	}
+
+	users := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		request, err := getJobRequestFromFile(filepath.Join(config.Paths.Results, entry.Name(), "job.json"))
+		if err != nil || (request.Status != StatusPending && request.Status != StatusRunning) {
+			continue
+		}
+		// the index job of the database itself does not block its removal
+		if _, ok := request.Job.(IndexJob); ok {
+			continue
+		}
+		for _, database := range jobDatabases(request) {
+			if database == path {
+				users++
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+func isDatabaseFile(name string, path string) bool {
+	return name == path || strings.HasPrefix(name, path+".") || strings.HasPrefix(name, path+"_")
+}
+
+// databaseFiles lists the files of a database, files that belong to another database
+// with a longer name sharing the same prefix (e.g. pdb and pdb_seqres) are skipped
+func databaseFiles(basepath string, path string, others []string) ([]string, error) {
+	entries, err := os.ReadDir(basepath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+outer:
+	for _, entry := range entries {
+		name := entry.Name()
+		if !isDatabaseFile(name, path) {
+			continue
+		}
+		for _, other := range others {
+			if other != path && len(other) > len(path) && isDatabaseFile(name, other) {
+				continue outer
+			}
+		}
+		files = append(files, filepath.Join(basepath, name))
+	}
+	return files, nil
+}
+
+func updateParams(basepath string, path string, update func(*Params)) (Params, error) {
+	filename := filepath.Join(basepath, filepath.Base(path)+".params")
+	if !fileExists(filename) {
+		return Params{}, errors.New("database " + path + " not found")
+	}
+	params, err := ReadParams(filename)
+	if err != nil {
+		return params, err
+	}
+	update(&params)
+	return params, SaveParams(filename, params)
+}
+
+// SetDatabaseDisabled hides a database from the database list, so no new jobs can use it
+func SetDatabaseDisabled(basepath string, path string, disabled bool) (Params, error) {
+	return updateParams(basepath, path, func(params *Params) {
+		params.Disabled = disabled
+	})
+}
+
+// RenameDatabase changes the display name, the files of a database keep their path
+func RenameDatabase(basepath string, path string, name string) (Params, error) {
+	if name == "" {
+		return Params{}, errors.New("name is required")
+	}
+	return updateParams(basepath, path, func(params *Params) {
+		params.Name = name
+	})
+}
+
+// DeleteDatabase disables a database and marks it for removal, its files are removed
+// right away if no job uses it and otherwise by the database remover once the last job finished
+func DeleteDatabase(config ConfigRoot, path string) (bool, error) {
+	_, err := updateParams(config.Paths.Databases, path, func(params *Params) {
+		params.Disabled = true
+		params.Remove = true
+	})
+	if err != nil {
+		return false, err
+	}
+	return removeUnusedDatabase(config, path)
+}
+
+func removeUnusedDatabase(config ConfigRoot, path string) (bool, error) {
+	users, err := databaseUsers(config, path)
+	if err != nil || users > 0 {
+		return false, err
+	}
+	// a job or an update still holds the database, the remover tries again later
+	lock, err := tryLockDatabase(config.Paths.Databases, path, true)
+	if err == errDatabaseLocked {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+
+	databases, err := Databases(config.Paths.Databases, false)
+	if err != nil {
+		return false, err
+	}
+	others := make([]string, 0, len(databases))
+	for _, db := range databases {
+		others = append(others, db.Path)
+	}
+
+	files, err := databaseFiles(config.Paths.Databases, path, others)
+	if err != nil {
+		return false, err
+	}
+	// the params file goes last, so an interrupted removal is picked up again
+	params := filepath.Join(config.Paths.Databases, path+".params")
+	for _, file := range files {
+		if file == params {
+			continue
+		}
+		if err := os.RemoveAll(file); err != nil {
+			return false, err
+		}
+	}
+	if err := os.Remove(params); err != nil {
+		return false, err
+	}
+	os.RemoveAll(filepath.Join(config.Paths.Databases, databaseReleaseDir, path))
+	os.Remove(databaseLockFile(config.Paths.Databases, path))
+	databaseLog.Info("Removed database", "database", path)
+	return true, nil
+}
+
+// databaseRemover removes databases marked for removal once they are not used anymore and expired session databases
+func databaseRemover(config ConfigRoot) {
+	for {
+		time.Sleep(1 * time.Minute)
+		databases, err := Databases(config.Paths.Databases, false)
+		if err != nil {
+			databaseLog.Error("Failed to list databases", "error", err)
+			continue
+		}
+		now := time.Now()
+		for _, db := range databases {
+			if !db.Remove && sessionExpired(db, now) {
+				if _, err := DeleteDatabase(config, db.Path); err != nil {
+					databaseLog.Error("Failed to remove expired session database", "database", db.Path, "error", err)
+				}
+				continue
+			}
+			if !db.Remove {
+				continue
+			}
+			if _, err := removeUnusedDatabase(config, db.Path); err != nil {
+				databaseLog.Error("Failed to remove database", "database", db.Path, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDatabaseFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"pdb", "pdb.index", "pdb.dbtype", "pdb_h", "pdb_h.index", "pdb.params", "pdb_seqres", "pdb_seqres.index", "pdb_seqres_h", "pdb_seqres.params", "pdb100", "other.params"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := databaseFiles(dir, "pdb", []string{"pdb", "pdb_seqres", "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	sort.Strings(names)
+
+	expected := []string{"pdb", "pdb.dbtype", "pdb.index", "pdb.params", "pdb_h", "pdb_h.index"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("databaseFiles = %v, expected %v", names, expected)
+	}
+}
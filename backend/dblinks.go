@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// LinkTemplate turns the accession of a hit into a link to an external resource,
+// e.g. {"name": "UniProt", "url": "https://www.uniprot.org/uniprotkb/{accession}"}
+type LinkTemplate struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// the first group of the pattern is the accession, without a pattern the target up to the first whitespace is used
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// HitLink is a link template applied to a hit
+type HitLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+func (l LinkTemplate) validate() error {
+	if l.Name == "" {
+		return errors.New("link template needs a name")
+	}
+	if !strings.Contains(l.URL, "{accession}") {
+		return errors.New("link template " + l.Name + " needs an {accession} placeholder")
+	}
+	u, err := url.Parse(strings.ReplaceAll(l.URL, "{accession}", "x"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("link template " + l.Name + " needs a http or https url")
+	}
+	if l.Pattern != "" {
+		re, err := regexp.Compile(l.Pattern)
+		if err != nil {
+			return errors.New("invalid pattern of link template " + l.Name + ": " + err.Error())
+		}
+		if re.NumSubexp() < 1 {
+			return errors.New("pattern of link template " + l.Name + " needs a group for the accession")
+		}
+	}
+	return nil
+}
+
+// parseLinkTemplates reads the JSON list of link templates submitted with a database
+func parseLinkTemplates(data string) ([]LinkTemplate, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var links []LinkTemplate
+	if err := json.Unmarshal([]byte(data), &links); err != nil {
+		return nil, errors.New("invalid link templates: " + err.Error())
+	}
+	for _, link := range links {
+		if err := link.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// SetDatabaseLinks replaces the link templates of a database, an empty list removes them
+func SetDatabaseLinks(basepath string, path string, links []LinkTemplate) (Params, error) {
+	return updateParams(basepath, path, func(params *Params) {
+		params.Links = links
+	})
+}
+
+type compiledLink struct {
+	template LinkTemplate
+	pattern  *regexp.Regexp
+}
+
+func compileLinks(templates []LinkTemplate) []compiledLink {
+	compiled := make([]compiledLink, 0, len(templates))
+	for _, template := range templates {
+		var pattern *regexp.Regexp
+		if template.Pattern != "" {
+			var err error
+			// params edited by hand are not validated
+			if pattern, err = regexp.Compile(template.Pattern); err != nil || pattern.NumSubexp() < 1 {
+				continue
+			}
+		}
+		compiled = append(compiled, compiledLink{template, pattern})
+	}
+	return compiled
+}
+
+// apply returns the link for a target, false if the target does not match the pattern
+func (l compiledLink) apply(target string) (HitLink, bool) {
+	accession := target
+	if l.pattern != nil {
+		match := l.pattern.FindStringSubmatch(target)
+		if match == nil || match[1] == "" {
+			return HitLink{}, false
+		}
+		accession = match[1]
+	} else if fields := strings.Fields(target); len(fields) > 0 {
+		accession = fields[0]
+	}
+	if accession == "" {
+		return HitLink{}, false
+	}
+	return HitLink{l.template.Name, strings.ReplaceAll(l.template.URL, "{accession}", url.PathEscape(accession))}, true
+}
+
+func resultTargets(alignments interface{}) []string {
+	targets := make([]string, 0)
+	switch conv := alignments.(type) {
+	case [][]AlignmentEntry:
+		for _, inner := range conv {
+			for _, entry := range inner {
+				targets = append(targets, entry.Target)
+			}
+		}
+	case [][]FoldseekAlignmentEntry:
+		for _, inner := range conv {
+			for _, entry := range inner {
+				targets = append(targets, entry.Target)
+			}
+		}
+	case [][]ComplexAlignmentEntry:
+		for _, inner := range conv {
+			for _, entry := range inner {
+				targets = append(targets, entry.Target)
+			}
+		}
+	}
+	return targets
+}
+
+// addHitLinks applies the link templates of the searched databases to the targets of their hits
+func addHitLinks(basepath string, results []SearchResult) {
+	for i := range results {
+		params, err := ReadParams(filepath.Join(basepath, filepath.Base(results[i].Database)+".params"))
+		// removed databases have no links
+		if err != nil || len(params.Links) == 0 {
+			continue
+		}
+		links := compileLinks(params.Links)
+		for _, target := range resultTargets(results[i].Alignments) {
+			if _, ok := results[i].Links[target]; ok {
+				continue
+			}
+			hits := make([]HitLink, 0, len(links))
+			for _, link := range links {
+				if hit, ok := link.apply(target); ok {
+					hits = append(hits, hit)
+				}
+			}
+			if len(hits) == 0 {
+				continue
+			}
+			if results[i].Links == nil {
+				results[i].Links = make(map[string][]HitLink)
+			}
+			results[i].Links[target] = hits
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHitLinks(t *testing.T) {
+	if _, err := parseLinkTemplates(`[{"name": "UniProt", "url": "https://www.uniprot.org/uniprotkb/"}]`); err == nil {
+		t.Error("expected error for template without placeholder")
+	}
+	if _, err := parseLinkTemplates(`[{"name": "UniProt", "url": "javascript:{accession}"}]`); err == nil {
+		t.Error("expected error for template without http url")
+	}
+	if _, err := parseLinkTemplates(`[{"name": "UniProt", "url": "https://www.uniprot.org/uniprotkb/{accession}", "pattern": "sp"}]`); err == nil {
+		t.Error("expected error for pattern without group")
+	}
+	links, err := parseLinkTemplates(`[{"name": "UniProt", "url": "https://www.uniprot.org/uniprotkb/{accession}", "pattern": "^(?:sp|tr)\\|([^|]+)\\|"}, {"name": "Search", "url": "https://example.org/?q={accession}"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	params := Params{Name: "uniprot", Path: "uniprot", Links: links}
+	if err := SaveParams(filepath.Join(dir, "uniprot.params"), params); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pdb.params"), []byte(`{"name": "pdb", "path": "pdb"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := []SearchResult{
+		{"uniprot", [][]AlignmentEntry{{{Target: "sp|P69905|HBA_HUMAN Hemoglobin"}, {Target: "UniRef50_A0A0 cluster"}}}, nil, nil},
+		{"pdb", [][]AlignmentEntry{{{Target: "1a00_A"}}}, nil, nil},
+	}
+	addHitLinks(dir, results)
+
+	expected := map[string][]HitLink{
+		"sp|P69905|HBA_HUMAN Hemoglobin": {
+			{"UniProt", "https://www.uniprot.org/uniprotkb/P69905"},
+			{"Search", "https://example.org/?q=sp%7CP69905%7CHBA_HUMAN"},
+		},
+		"UniRef50_A0A0 cluster": {
+			{"Search", "https://example.org/?q=UniRef50_A0A0"},
+		},
+	}
+	if !reflect.DeepEqual(results[0].Links, expected) {
+		t.Errorf("links = %+v, expected %+v", results[0].Links, expected)
+	}
+	if results[1].Links != nil {
+		t.Errorf("expected no links for database without templates, got %+v", results[1].Links)
+	}
+}
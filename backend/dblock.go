@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// databases are locked with advisory file locks next to the databases, so the server and
+// all workers sharing the databases directory agree on them; the locks are released by the
+// operating system when a process dies
+const databaseLockDir = ".locks"
+
+const databaseLockRetry = 1 * time.Second
+
+var errDatabaseLocked = errors.New("database is locked")
+
+type databaseLock struct {
+	file *os.File
+}
+
+func databaseLockFile(basepath string, path string) string {
+	return filepath.Join(basepath, databaseLockDir, path+".lock")
+}
+
+// databaseIntentFile is held exclusively by a writer while it waits for the readers of a database,
+// readers pass it with a shared lock, so no new reader can start while a writer is waiting
+func databaseIntentFile(basepath string, path string) string {
+	return filepath.Join(basepath, databaseLockDir, path+".intent")
+}
+
+func tryLockFile(name string, exclusive bool) (*databaseLock, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file, exclusive); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &databaseLock{file}, nil
+}
+
+func waitLockFile(ctx context.Context, name string, exclusive bool) (*databaseLock, error) {
+	for {
+		lock, err := tryLockFile(name, exclusive)
+		if err != errDatabaseLocked {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(databaseLockRetry):
+		}
+	}
+}
+
+// tryLockDatabase returns errDatabaseLocked if a conflicting lock is held or a writer is waiting,
+// jobs that read a database hold shared locks and jobs that replace its files exclusive ones
+func tryLockDatabase(basepath string, path string, exclusive bool) (*databaseLock, error) {
+	intent, err := tryLockFile(databaseIntentFile(basepath, path), exclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer intent.Unlock()
+	return tryLockFile(databaseLockFile(basepath, path), exclusive)
+}
+
+// lockDatabase waits until the lock is available or the context is done,
+// a waiting writer keeps new readers out so it is not starved by a stream of searches
+func lockDatabase(ctx context.Context, basepath string, path string, exclusive bool) (*databaseLock, error) {
+	intent, err := waitLockFile(ctx, databaseIntentFile(basepath, path), exclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer intent.Unlock()
+	return waitLockFile(ctx, databaseLockFile(basepath, path), exclusive)
+}
+
+// Unlock can be called more than once, so it can be deferred and still released early
+func (l *databaseLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
+
+// lockDatabases takes shared locks in a fixed order, so jobs waiting for each other can not deadlock
+func lockDatabases(ctx context.Context, basepath string, paths []string) ([]*databaseLock, error) {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+	locks := make([]*databaseLock, 0, len(sorted))
+	for i, path := range sorted {
+		if i > 0 && sorted[i-1] == path {
+			continue
+		}
+		lock, err := lockDatabase(ctx, basepath, path, false)
+		if err != nil {
+			unlockDatabases(locks)
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+func unlockDatabases(locks []*databaseLock) {
+	for _, lock := range locks {
+		lock.Unlock()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDatabaseLock(t *testing.T) {
+	dir := t.TempDir()
+	shared, err := lockDatabases(context.Background(), dir, []string{"uniref", "pdb", "uniref"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 2 {
+		t.Fatalf("expected 2 locks, got %d", len(shared))
+	}
+	// searches can share a database
+	other, err := tryLockDatabase(dir, "pdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Unlock()
+
+	if _, err := tryLockDatabase(dir, "pdb", true); err != errDatabaseLocked {
+		t.Fatalf("expected the database to be locked, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := lockDatabase(ctx, dir, "pdb", true); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+
+	unlockDatabases(shared)
+	lock, err := tryLockDatabase(dir, "pdb", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabaseLockWriterIntent(t *testing.T) {
+	dir := t.TempDir()
+	search, err := tryLockDatabase(dir, "pdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan *databaseLock)
+	go func() {
+		lock, err := lockDatabase(context.Background(), dir, "pdb", true)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- lock
+	}()
+	// new searches wait for the update once it started waiting
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		lock, err := tryLockDatabase(dir, "pdb", false)
+		if err == errDatabaseLocked {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lock.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatal("a waiting writer should keep new readers out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	search.Unlock()
+	update := <-locked
+	if _, err := tryLockDatabase(dir, "pdb", false); err != errDatabaseLocked {
+		t.Fatalf("expected the database to be locked, got %v", err)
+	}
+	update.Unlock()
+	if lock, err := tryLockDatabase(dir, "pdb", false); err != nil {
+		t.Fatal(err)
+	} else {
+		lock.Unlock()
+	}
+}
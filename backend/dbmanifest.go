@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// countIndexEntries counts the lines of a database .index file, which is the number of sequences
+func countIndexEntries(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var count int64
+	buf := make([]byte, 1024*1024)
+	for {
+		n, err := f.Read(buf)
+		count += int64(bytes.Count(buf[:n], []byte{'\n'}))
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// fillManifest updates the metadata of a database that is derived from its files,
+// it is called whenever a database was built or updated
+func fillManifest(basepath string, params *Params) error {
+	file := filepath.Join(basepath, params.Path)
+	sequences, err := countIndexEntries(file + ".index")
+	if err != nil {
+		return err
+	}
+
+	databases, err := Databases(basepath, false)
+	if err != nil {
+		return err
+	}
+	others := make([]string, 0, len(databases))
+	for _, db := range databases {
+		others = append(others, db.Path)
+	}
+	files, err := databaseFiles(basepath, params.Path, others)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+	}
+
+	params.Sequences = sequences
+	params.Size = size
+	params.Updated = time.Now().UTC().Format(time.RFC3339)
+	if fileExists(file+"_taxonomy") || fileExists(file+"_mapping") {
+		params.Taxonomy = true
+	}
+	// foldseek databases carry their 3Di sequences in _ss
+	if fileExists(file + "_ss") {
+		params.Structure = true
+	}
+	// a rebuilt database can have a different type
+	params.Type = ""
+	params.Type = databaseType(basepath, *params)
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFillManifest(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"db":          "AAAA\x00CCCC\x00GGGG\x00",
+		"db.index":    "0\t0\t5\n1\t5\t5\n2\t10\t5\n",
+		"db_mapping":  "0\t9606\n",
+		"db_h":        "",
+		"db2":         "AAAA\x00",
+		"db2.index":   "0\t0\t5\n",
+		"db2.params":  `{"id":"d2","name":"db2","path":"db2"}`,
+		"db.params":   `{"id":"d1","name":"db","path":"db"}`,
+		"unrelated.x": "xxxxxxxx",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	params := Params{Name: "db", Path: "db"}
+	if err := fillManifest(dir, &params); err != nil {
+		t.Fatal(err)
+	}
+	if params.Sequences != 3 {
+		t.Errorf("expected 3 sequences, got %d", params.Sequences)
+	}
+	expectedSize := int64(len(files["db"]) + len(files["db.index"]) + len(files["db_mapping"]) + len(files["db.params"]))
+	if params.Size != expectedSize {
+		t.Errorf("expected size %d, got %d", expectedSize, params.Size)
+	}
+	if !params.Taxonomy {
+		t.Error("expected taxonomy to be detected")
+	}
+	if params.Updated == "" {
+		t.Error("expected updated to be set")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a database package is a gzip compressed tar archive of the files of a database,
+// the params come first and a sha256sum file of all other files last
+const packageChecksumsSuffix = ".sha256"
+
+// ExportDatabase writes the package of a database, the input files it was built from are only included with sources set
+func ExportDatabase(ctx context.Context, config ConfigRoot, path string, sources bool, w io.Writer) error {
+	path = filepath.Base(path)
+	params, err := ReadParams(filepath.Join(config.Paths.Databases, path+".params"))
+	if err != nil {
+		return err
+	}
+	if params.Status != StatusComplete {
+		return errors.New("database " + path + " is not built")
+	}
+	// an update can not swap the files while they are packaged
+	lock, err := lockDatabase(ctx, config.Paths.Databases, path, false)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	databases, err := Databases(config.Paths.Databases, false)
+	if err != nil {
+		return err
+	}
+	others := make([]string, len(databases))
+	for i, db := range databases {
+		others[i] = db.Path
+	}
+	files, err := databaseFiles(config.Paths.Databases, path, others)
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	metadata, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, path+".params", metadata); err != nil {
+		return err
+	}
+
+	var checksums strings.Builder
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name == path+".params" || (!sources && isSourceFile(name, path)) {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		// e.g. the directory of structure files
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		digest, err := writeTarEntry(tw, name, file, info)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&checksums, "%s  %s\n", digest, name)
+	}
+	if err := writeTarFile(tw, path+packageChecksumsSuffix, []byte(checksums.String())); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// writeTarEntry adds a file (symlinks of imported databases are followed) and returns its SHA256
+func writeTarEntry(tw *tar.Writer, name string, file string, info os.FileInfo) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(tw, io.TeeReader(f, h)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func parseChecksums(data string) map[string]string {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		digest, name, found := strings.Cut(scanner.Text(), "  ")
+		if found {
+			checksums[name] = digest
+		}
+	}
+	return checksums
+}
+
+// ImportDatabasePackage adds a database from the package of another instance, the files are extracted
+// into the staging directory and checked before they are moved into place. An existing database with
+// the same path is only replaced if replace is set, its previous release is kept according to keep.
+func ImportDatabasePackage(ctx context.Context, config ConfigRoot, r io.Reader, replace bool, keep int) (Params, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Params{}, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil {
+		return Params{}, errors.New("invalid database package: " + err.Error())
+	}
+	if !strings.HasSuffix(header.Name, ".params") {
+		return Params{}, errors.New("invalid database package: expected the params first, found " + header.Name)
+	}
+	var params Params
+	if err := DecodeJsonAndValidate(io.LimitReader(tr, 1024*1024), &params); err != nil {
+		return Params{}, errors.New("invalid params in database package: " + err.Error())
+	}
+	if params.IndexOptions != nil {
+		if err := params.IndexOptions.Validate(); err != nil {
+			return Params{}, errors.New("invalid params in database package: " + err.Error())
+		}
+	}
+	path := params.Path
+	if path == "" || path != filepath.Base(path) || strings.HasPrefix(path, ".") || header.Name != path+".params" {
+		return Params{}, errors.New("invalid database path " + path + " in package")
+	}
+	exists := fileExists(filepath.Join(config.Paths.Databases, path+".params"))
+	if exists && !replace {
+		return Params{}, errors.New("database " + path + " already exists")
+	}
+
+	swap, err := beginStagedSwap(config, path)
+	if err != nil {
+		return Params{}, err
+	}
+	defer swap.Close()
+
+	digests := make(map[string]string)
+	var checksums map[string]string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Params{}, err
+		}
+		name := header.Name
+		if name != filepath.Base(name) || !isDatabaseFile(name, path) || header.Typeflag != tar.TypeReg {
+			return Params{}, errors.New("unexpected entry " + name + " in database package")
+		}
+		if name == path+packageChecksumsSuffix {
+			data, err := io.ReadAll(io.LimitReader(tr, 1024*1024))
+			if err != nil {
+				return Params{}, err
+			}
+			checksums = parseChecksums(string(data))
+			continue
+		}
+		digest, err := extractTarEntry(ctx, tr, filepath.Join(swap.dir, name))
+		if err != nil {
+			return Params{}, err
+		}
+		digests[name] = digest
+	}
+
+	if checksums == nil {
+		return Params{}, errors.New("database package is incomplete, the checksums are missing")
+	}
+	for name, digest := range checksums {
+		if digests[name] != digest {
+			return Params{}, errors.New("checksum of " + name + " does not match, the package is damaged")
+		}
+	}
+	if len(digests) != len(checksums) {
+		return Params{}, errors.New("database package contains files without checksums")
+	}
+	if err := checkPackageParams(config, swap.dir, params); err != nil {
+		return Params{}, err
+	}
+	if err := swap.Verify(params); err != nil {
+		return Params{}, err
+	}
+
+	if exists {
+		return swap.Commit(ctx, keep, func(current *Params) {
+			// the local order and default selection stay
+			order, isDefault := current.Order, current.Default
+			*current = params
+			current.Order = order
+			current.Default = isDefault
+			current.Status = StatusComplete
+			current.Stage = DatabaseReady
+		})
+	}
+
+	// searches of a database that was added in the meantime wait until it is moved into place
+	lock, err := lockDatabase(ctx, config.Paths.Databases, path, true)
+	if err != nil {
+		return Params{}, err
+	}
+	defer lock.Unlock()
+	if fileExists(filepath.Join(config.Paths.Databases, path+".params")) {
+		return Params{}, errors.New("database " + path + " already exists")
+	}
+	staged, err := databaseFiles(swap.dir, path, nil)
+	if err != nil {
+		return Params{}, err
+	}
+	for _, file := range staged {
+		if err := os.Rename(file, filepath.Join(config.Paths.Databases, filepath.Base(file))); err != nil {
+			return Params{}, err
+		}
+	}
+	if params.ID == "" {
+		params.ID = newDatabaseId()
+	}
+	params.Status = StatusComplete
+	params.Stage = DatabaseReady
+	// the params are written last, so the database is only listed once it is complete
+	return params, SaveParams(filepath.Join(config.Paths.Databases, path+".params"), params)
+}
+
+// checkPackageParams compares the params of a package with its files and the local databases,
+// they were written by another instance and would otherwise be trusted as they are
+func checkPackageParams(config ConfigRoot, dir string, params Params) error {
+	base := filepath.Join(dir, params.Path)
+	// a structure database that is not marked as one would be offered to sequence searches
+	if !params.Structure && config.App != AppFoldSeek && fileExists(base+"_ss.dbtype") {
+		return errors.New("database package contains structures, but its params do not mark it as a structure database")
+	}
+	if params.ID == "" {
+		return nil
+	}
+	databases, err := Databases(config.Paths.Databases, false)
+	if err != nil {
+		return err
+	}
+	for _, database := range databases {
+		if database.ID == params.ID && database.Path != params.Path {
+			return errors.New("id " + params.ID + " of the package is already used by database " + database.Path)
+		}
+	}
+	return nil
+}
+
+func extractTarEntry(ctx context.Context, r io.Reader, file string) (string, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return hex.EncodeToString(h.Sum(nil)), err
+}
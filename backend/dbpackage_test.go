@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDatabasePackage(t *testing.T) {
+	var source ConfigRoot
+	source.Paths.Databases = t.TempDir()
+	writeTestDatabase(t, source.Paths.Databases, "db", "SEQ\x00")
+	files := map[string]string{
+		"db.params": `{"id":"0123456789abcdef","name":"db","version":"1","path":"db","status":"COMPLETE"}`,
+		"db.fasta":  ">a\nSEQ\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(source.Paths.Databases, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var archive bytes.Buffer
+	if err := ExportDatabase(context.Background(), source, "db", false, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	var target ConfigRoot
+	target.Paths.Databases = t.TempDir()
+	target.Paths.Results = t.TempDir()
+	params, err := ImportDatabasePackage(context.Background(), target, bytes.NewReader(archive.Bytes()), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.ID != "0123456789abcdef" || params.Status != StatusComplete {
+		t.Errorf("unexpected params %+v", params)
+	}
+	data, err := os.ReadFile(filepath.Join(target.Paths.Databases, "db"))
+	if err != nil || string(data) != "SEQ\x00" {
+		t.Errorf("expected the data of the database, got %q", data)
+	}
+	if fileExists(filepath.Join(target.Paths.Databases, "db.fasta")) {
+		t.Error("expected the sources to be left out")
+	}
+
+	if _, err := ImportDatabasePackage(context.Background(), target, bytes.NewReader(archive.Bytes()), false, 0); err == nil {
+		t.Error("expected an existing database not to be replaced")
+	}
+	if _, err := ImportDatabasePackage(context.Background(), target, bytes.NewReader(archive.Bytes()), true, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// a damaged package is refused
+	damaged := archive.Bytes()[:archive.Len()/2]
+	if _, err := ImportDatabasePackage(context.Background(), target, bytes.NewReader(damaged), true, 0); err == nil {
+		t.Error("expected a truncated package to be refused")
+	}
+
+	// the id of the package can not take over another database
+	var other ConfigRoot
+	other.Paths.Databases = t.TempDir()
+	if err := SaveParams(filepath.Join(other.Paths.Databases, "other.params"), Params{ID: "0123456789abcdef", Name: "other", Path: "other", Status: StatusComplete}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportDatabasePackage(context.Background(), other, bytes.NewReader(archive.Bytes()), false, 0); err == nil {
+		t.Error("expected a package with the id of another database to be refused")
+	}
+
+	// structures in a package that is not marked as a structure database are refused
+	writeTestDatabase(t, source.Paths.Databases, "db_ss", "ACD\x00")
+	archive.Reset()
+	if err := ExportDatabase(context.Background(), source, "db", false, &archive); err != nil {
+		t.Fatal(err)
+	}
+	target.Paths.Databases = t.TempDir()
+	if _, err := ImportDatabasePackage(context.Background(), target, bytes.NewReader(archive.Bytes()), false, 0); err == nil {
+		t.Error("expected a package with params that do not match its files to be refused")
+	}
+}
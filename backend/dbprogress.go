@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type DatabaseStage string
+
+const (
+	DatabaseDownloading DatabaseStage = "downloading"
+	DatabaseConverting  DatabaseStage = "converting"
+	DatabaseIndexing    DatabaseStage = "indexing"
+	DatabaseReady       DatabaseStage = "ready"
+	DatabaseFailed      DatabaseStage = "failed"
+)
+
+// DatabaseStatus adds the progress of a running build and the end of the log of a failed build to the params
+type DatabaseStatus struct {
+	Params
+	Progress float64 `json:"progress,omitempty"`
+	Log      string  `json:"log,omitempty"`
+}
+
+type DatabaseStatusResponse struct {
+	Databases []DatabaseStatus `json:"databases"`
+}
+
+// the progress bar of mmseqs ends with the percentage, e.g. [=====     ] 52.40% 1.20M 3s 2ms
+var progressPattern = regexp.MustCompile(`\]\s*(\d+(?:\.\d+)?)%`)
+
+// parseProgress returns the percentage of the last progress bar in the output of a module
+func parseProgress(output string) (float64, bool) {
+	matches := progressPattern.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	progress, err := strconv.ParseFloat(matches[len(matches)-1][1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return progress, true
+}
+
+// tailFile returns up to size bytes from the end of a file, starting at a line boundary
+func tailFile(path string, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - size
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	tail := string(data)
+	if offset > 0 {
+		if i := strings.IndexByte(tail, '\n'); i != -1 {
+			tail = tail[i+1:]
+		}
+	}
+	return tail, nil
+}
+
+// DatabaseStatuses reads the log of the index job of databases that are being built or failed,
+// complete databases report the progress of their queued update
+func DatabaseStatuses(config ConfigRoot, databases []Params) []DatabaseStatus {
+	statuses := make([]DatabaseStatus, 0, len(databases))
+	for _, params := range databases {
+		status := DatabaseStatus{params, 0, ""}
+		if id, ok := queuedUpdate(params.Path); ok && params.Status == StatusComplete {
+			if tail, err := tailFile(filepath.Join(config.Paths.Results, string(id), "job.log"), 64*1024); err == nil {
+				status.Progress, _ = parseProgress(tail)
+			}
+		} else if params.Status != StatusComplete {
+			request, _ := NewIndexJobRequest(params.Path, "")
+			jobLog := filepath.Join(config.Paths.Results, string(request.Id), "job.log")
+			switch params.Status {
+			case StatusPending, StatusRunning:
+				if tail, err := tailFile(jobLog, 64*1024); err == nil {
+					status.Progress, _ = parseProgress(tail)
+				}
+			case StatusError:
+				if tail, err := tailFile(jobLog, 4*1024); err == nil {
+					status.Log = tail
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseProgress(t *testing.T) {
+	output := "createindex db tmp\n[=================                ] 40.00% 1.00M 2s\r[=========================       ] 75.50% 1.00M 3s\n"
+	progress, ok := parseProgress(output)
+	if !ok || progress != 75.5 {
+		t.Errorf("parseProgress = %v %v, expected 75.5", progress, ok)
+	}
+
+	if _, ok := parseProgress("Index table: counting k-mers\n"); ok {
+		t.Error("expected no progress without progress bar")
+	}
+}
+
+func TestDatabaseStatusOfUpdate(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Results = t.TempDir()
+	request := NewDatabaseUpdateRequest("uniref", "https://example.org/uniref.fasta.gz", "fasta", "2024_01")
+	if err := os.MkdirAll(filepath.Join(config.Paths.Results, string(request.Id)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(config.Paths.Results, string(request.Id), "job.log"), []byte("[=========          ] 30.00% 1.00M 2s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setQueuedUpdate("uniref", request.Id)
+	defer setQueuedUpdate("uniref", "")
+	statuses := DatabaseStatuses(config, []Params{{Name: "uniref", Path: "uniref", Status: StatusComplete}})
+	if len(statuses) != 1 || statuses[0].Progress != 30 {
+		t.Errorf("the progress of the update was not read from its job: %+v", statuses)
+	}
+}
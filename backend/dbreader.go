@@ -2,9 +2,17 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"math"
 	"os"
 	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type Entry[V ~uint32 | string] struct {
@@ -28,9 +36,24 @@ func (d EntryByKey[V]) Less(i, j int) bool {
 type Reader[V ~uint32 | string] struct {
 	Index []Entry[V]
 	file  *os.File
+	// written by CompressResults, every entry is a zstd frame
+	compressed bool
+	// written by EncryptResults, every entry is sealed, before it is decompressed
+	sealed bool
+	// binds the sealed entries to the file
+	context []byte
 }
 
 func (d *Reader[V]) Make(data string, index string) error {
+	if !fileExists(data) && resultFileExists(data+zstdSuffix) {
+		data, index = data+zstdSuffix, data+zstdSuffix+".index"
+		d.compressed = true
+	}
+	if !fileExists(data) && fileExists(data+sealedSuffix) {
+		d.context = sealedContext(data)
+		data, index = data+sealedSuffix, data+sealedSuffix+".index"
+		d.sealed = true
+	}
 	file, err := os.Open(data)
 	if err != nil {
 		return err
@@ -100,14 +123,113 @@ func (d *Reader[V]) Length(id int64) uint64 {
 	return d.Index[id].Length
 }
 
-func (d *Reader[V]) Data(id int64) string {
+// Data reads an entry without its null byte separator, entries that do not exist are empty
+func (d *Reader[V]) Data(id int64) (string, error) {
 	if id < 0 || id >= d.Size() {
-		return ""
+		return "", nil
+	}
+	length := int64(d.Index[id].Length) - 1
+	if length < 0 {
+		length = 0
 	}
-	length := d.Index[id].Length - 1
 	buffer := make([]byte, length)
-	d.file.ReadAt(buffer, int64(d.Index[id].Offset))
-	return string(buffer[:length])
+	if _, err := d.file.ReadAt(buffer, int64(d.Index[id].Offset)); err != nil && err != io.EOF {
+		return "", err
+	}
+	if d.sealed {
+		var err error
+		if buffer, err = d.unseal(id, buffer); err != nil {
+			return "", err
+		}
+	}
+	if d.compressed {
+		decoded, err := zstdDecoder.DecodeAll(buffer, nil)
+		if err != nil {
+			return "", err
+		}
+		return string(decoded), nil
+	}
+	return string(buffer), nil
+}
+
+func (d *Reader[V]) unseal(id int64, buffer []byte) ([]byte, error) {
+	return unsealed(buffer, entryContext(d.context, fmt.Sprint(d.Index[id].Key)))
+}
+
+// streaming decoders are reused between entries, each one holds a window buffer
+var zstdStreamDecoders = sync.Pool{New: func() interface{} {
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	return decoder
+}}
+
+type entryReader struct {
+	io.Reader
+	decoder *zstd.Decoder
+}
+
+func (e entryReader) Close() error {
+	if e.decoder != nil {
+		e.decoder.Reset(nil)
+		zstdStreamDecoders.Put(e.decoder)
+	}
+	return nil
+}
+
+// Open streams an entry instead of reading it into memory as a whole like Data,
+// only sealed entries are read at once since they are authenticated as a whole
+func (d *Reader[V]) Open(id int64) (io.ReadCloser, error) {
+	if id < 0 || id >= d.Size() {
+		return nil, errors.New("entry " + fmt.Sprint(id) + " does not exist")
+	}
+	length := int64(d.Index[id].Length) - 1
+	if length < 0 {
+		length = 0
+	}
+	var r io.Reader = io.NewSectionReader(d.file, int64(d.Index[id].Offset), length)
+	if d.sealed {
+		buffer := make([]byte, length)
+		if _, err := io.ReadFull(r, buffer); err != nil {
+			return nil, err
+		}
+		data, err := d.unseal(id, buffer)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	if d.compressed {
+		decoder := zstdStreamDecoders.Get().(*zstd.Decoder)
+		if err := decoder.Reset(r); err != nil {
+			zstdStreamDecoders.Put(decoder)
+			return nil, err
+		}
+		return entryReader{decoder, decoder}, nil
+	}
+	return entryReader{r, nil}, nil
+}
+
+// ScanLines calls f with every non-empty line of an entry read with Open, without line length limit
+func (d *Reader[V]) ScanLines(id int64, f func(line string) error) error {
+	entry, err := d.Open(id)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+	r := bufio.NewReader(entry)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.Trim(line, "\x00\n"); line != "" {
+			if ferr := f(line); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
 }
 
 func (d *Reader[V]) Size() int64 {
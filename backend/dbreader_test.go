@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReaderOpen(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("A", 100*1024)
+	writeTestDatabase(t, dir, "alis_db", "q1\tt1\t0.9\n\x00q1\t"+long+"\n\x00")
+	path := filepath.Join(dir, "alis_db")
+	// both entries are in one index line of the helper, split them
+	if err := os.WriteFile(path+".index", []byte("0\t0\t11\n1\t11\t"+strconv.Itoa(len(long)+5)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compressed := range []bool{false, true} {
+		if compressed {
+			if err := CompressResults(dir, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reader := Reader[uint32]{}
+		if err := reader.Make(dbpaths(path)); err != nil {
+			t.Fatal(err)
+		}
+		entry, err := reader.Open(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(entry)
+		entry.Close()
+		if err != nil || string(data) != "q1\tt1\t0.9\n" {
+			t.Errorf("unexpected entry %q %v", data, err)
+		}
+		// lines are not limited to the size of a scanner buffer
+		var lines []string
+		if err := reader.ScanLines(1, func(line string) error {
+			lines = append(lines, line)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != 1 || lines[0] != "q1\t"+long {
+			t.Errorf("unexpected lines of compressed=%v", compressed)
+		}
+		reader.Delete()
+	}
+}
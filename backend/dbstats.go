@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shared by the server, which counts submitted searches, and the workers, which add the runtimes.
+// Workers also read it to find the databases worth keeping in the page cache.
+const databaseStatsFile = ".stats.json"
+
+// DatabaseStats counts how often and how much a database was searched
+type DatabaseStats struct {
+	Searches int64 `json:"searches"`
+	// residues of all queries
+	Residues int64 `json:"residues"`
+	// finished searches and their summed runtime in seconds
+	Runs         int64   `json:"runs"`
+	Runtime      float64 `json:"runtime"`
+	LastSearched string  `json:"lastSearched"`
+}
+
+func (s *DatabaseStats) add(other DatabaseStats) {
+	s.Searches += other.Searches
+	s.Residues += other.Residues
+	s.Runs += other.Runs
+	s.Runtime += other.Runtime
+	// RFC3339 in UTC sorts like time
+	if other.LastSearched > s.LastSearched {
+		s.LastSearched = other.LastSearched
+	}
+}
+
+func readDatabaseStats(basepath string) (map[string]DatabaseStats, error) {
+	stats := make(map[string]DatabaseStats)
+	data, err := os.ReadFile(filepath.Join(basepath, databaseStatsFile))
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// DatabaseStatsRecorder collects statistics in memory and periodically adds them to the statistics file,
+// the file is locked while it is updated, so several processes can record into it
+type DatabaseStatsRecorder struct {
+	basepath string
+	mu       sync.Mutex
+	pending  map[string]DatabaseStats
+}
+
+func NewDatabaseStatsRecorder(config ConfigRoot) *DatabaseStatsRecorder {
+	return &DatabaseStatsRecorder{config.Paths.Databases, sync.Mutex{}, make(map[string]DatabaseStats)}
+}
+
+func (r *DatabaseStatsRecorder) record(database string, stats DatabaseStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.pending[database]
+	pending.add(stats)
+	r.pending[database] = pending
+}
+
+// Record counts a submitted search of the databases with a query of residues length
+func (r *DatabaseStatsRecorder) Record(dbs []string, residues int64) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, database := range dbs {
+		r.record(database, DatabaseStats{1, residues, 0, 0, now})
+	}
+}
+
+// RecordRuntime adds a finished search of a database, it does nothing on a nil recorder
+func (r *DatabaseStatsRecorder) RecordRuntime(database string, runtime time.Duration) {
+	if r == nil {
+		return
+	}
+	r.record(database, DatabaseStats{0, 0, 1, runtime.Seconds(), ""})
+}
+
+func (r *DatabaseStatsRecorder) Flush() error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]DatabaseStats)
+	r.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := r.merge(pending)
+	if err != nil {
+		// kept for the next flush
+		for database, stats := range pending {
+			r.record(database, stats)
+		}
+	}
+	return err
+}
+
+func (r *DatabaseStatsRecorder) merge(pending map[string]DatabaseStats) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	lock, err := lockDatabase(ctx, r.basepath, databaseStatsFile, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	stats, err := readDatabaseStats(r.basepath)
+	if err != nil {
+		return err
+	}
+	for database, other := range pending {
+		merged := stats[database]
+		merged.add(other)
+		stats[database] = merged
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(r.basepath, databaseStatsFile)
+	if err := os.WriteFile(filename+".part", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".part", filename)
+}
+
+// Snapshot returns the recorded statistics including the ones that were not written yet
+func (r *DatabaseStatsRecorder) Snapshot() (map[string]DatabaseStats, error) {
+	stats, err := readDatabaseStats(r.basepath)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for database, pending := range r.pending {
+		merged := stats[database]
+		merged.add(pending)
+		stats[database] = merged
+	}
+	return stats, nil
+}
+
+func (r *DatabaseStatsRecorder) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := r.Flush(); err != nil {
+			databaseLog.Error("Failed to write database statistics", "error", err)
+		}
+	}
+}
+
+// DatabaseStatistic is the usage of a database next to the disk space it takes
+type DatabaseStatistic struct {
+	Path     string `json:"path"`
+	Searches int64  `json:"searches"`
+	Residues int64  `json:"residues"`
+	// in seconds, of the searches that finished
+	AverageRuntime float64 `json:"averageRuntime"`
+	LastSearched   string  `json:"lastSearched,omitempty"`
+	Size           uint64  `json:"size"`
+}
+
+// databaseStatistics lists the measured databases and all searched ones, the most searched first
+func databaseStatistics(stats map[string]DatabaseStats, usage DiskUsage) []DatabaseStatistic {
+	sizes := make(map[string]uint64, len(usage.Databases))
+	for _, db := range usage.Databases {
+		sizes[db.Path] = db.Size
+		if _, ok := stats[db.Path]; !ok {
+			stats[db.Path] = DatabaseStats{}
+		}
+	}
+	res := make([]DatabaseStatistic, 0, len(stats))
+	for _, path := range mostSearchedDatabases(stats, len(stats)) {
+		s := stats[path]
+		average := 0.0
+		if s.Runs > 0 {
+			average = s.Runtime / float64(s.Runs)
+		}
+		res = append(res, DatabaseStatistic{path, s.Searches, s.Residues, average, s.LastSearched, sizes[path]})
+	}
+	return res
+}
+
+// databaseRuntimes records the runtimes of searches in worker processes
+var databaseRuntimes *DatabaseStatsRecorder
+
+// mostSearchedDatabases returns up to n databases ordered by the number of searches
+func mostSearchedDatabases(stats map[string]DatabaseStats, n int) []string {
+	paths := make([]string, 0, len(stats))
+	for path := range stats {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := stats[paths[i]], stats[paths[j]]
+		if a.Searches != b.Searches {
+			return a.Searches > b.Searches
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
+
+// fastaResidues counts the residues of the sequences of a FASTA query
+func fastaResidues(query string) int64 {
+	var residues int64
+	for _, line := range strings.Split(query, "\n") {
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		residues += int64(len(strings.TrimSpace(line)))
+	}
+	return residues
+}
+
+// structureResidues counts the C-alpha atoms of a PDB or mmCIF query
+func structureResidues(query string) int64 {
+	var residues int64
+	for _, line := range strings.Split(query, "\n") {
+		if !strings.HasPrefix(line, "ATOM") {
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			if field == "CA" {
+				residues++
+				break
+			}
+		}
+	}
+	return residues
+}
+
+// jobResidues is the query length of a search for the statistics
+func jobResidues(request JobRequest) int64 {
+	switch job := request.Job.(type) {
+	case SearchJob:
+		return fastaResidues(job.query)
+	case MsaJob:
+		return fastaResidues(job.query)
+	case StructureSearchJob:
+		return structureResidues(job.query)
+	case ComplexSearchJob:
+		return structureResidues(job.query)
+	}
+	return 0
+}
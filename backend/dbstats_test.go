@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHotDatabases(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	for _, name := range []string{"uniref", "pdb", "afdb", "nt"} {
+		if err := os.WriteFile(filepath.Join(config.Paths.Databases, name+".params"), []byte(`{"name": "`+name+`", "path": "`+name+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := NewDatabaseStatsRecorder(config)
+	recorder.Record([]string{"uniref", "pdb"}, 100)
+	recorder.Record([]string{"pdb", "removed"}, 50)
+	recorder.Record([]string{"pdb", "afdb", "removed"}, 10)
+	recorder.Record([]string{"removed"}, 1)
+	if err := recorder.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := readDatabaseStats(config.Paths.Databases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["pdb"].Searches != 3 || stats["removed"].Searches != 3 || stats["uniref"].LastSearched == "" {
+		t.Errorf("unexpected statistics %+v", stats)
+	}
+	if stats["pdb"].Residues != 160 {
+		t.Errorf("expected 160 residues for pdb, got %d", stats["pdb"].Residues)
+	}
+	if got := mostSearchedDatabases(stats, 2); !reflect.DeepEqual(got, []string{"pdb", "removed"}) {
+		t.Errorf("mostSearchedDatabases = %v", got)
+	}
+
+	// configured databases come first, removed databases are skipped
+	config.Worker.Warmup = &ConfigWarmup{Databases: []string{"nt", "pdb"}, Top: 2}
+	if got := hotDatabases(config); !reflect.DeepEqual(got, []string{"nt", "pdb", "afdb", "uniref"}) {
+		t.Errorf("hotDatabases = %v", got)
+	}
+}
+
+func TestDatabaseStatsMerge(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	// the server and a worker record into the same file
+	server := NewDatabaseStatsRecorder(config)
+	worker := NewDatabaseStatsRecorder(config)
+	server.Record([]string{"pdb"}, 120)
+	worker.RecordRuntime("pdb", 3*time.Second)
+	worker.RecordRuntime("pdb", 5*time.Second)
+	if err := worker.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	server.Record([]string{"pdb"}, 80)
+
+	snapshot, err := server.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statistics := databaseStatistics(snapshot, DiskUsage{Databases: []DatabaseUsage{{"pdb", 1000}, {"unused", 5000}}})
+	expected := []DatabaseStatistic{
+		{"pdb", 2, 200, 4, snapshot["pdb"].LastSearched, 1000},
+		{"unused", 0, 0, 0, "", 5000},
+	}
+	if !reflect.DeepEqual(statistics, expected) {
+		t.Errorf("got %+v, expected %+v", statistics, expected)
+	}
+
+	var nilRecorder *DatabaseStatsRecorder
+	nilRecorder.RecordRuntime("pdb", time.Second)
+
+	if residues := fastaResidues(">a\nMKV\nLL\n>b\nAC\n"); residues != 7 {
+		t.Errorf("expected 7 residues, got %d", residues)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the releases of swapped databases are kept in .releases/<path>/<release>, .releases/<path>/current links to the one in use
+const (
+	databaseReleaseDir = ".releases"
+	currentRelease     = "current"
+)
+
+// releaseLink is where the file of a database links to, relative to the databases directory
+func releaseLink(path string, name string) string {
+	return filepath.Join(databaseReleaseDir, path, currentRelease, name)
+}
+
+func isReleaseLink(file string, path string) bool {
+	link, err := os.Readlink(file)
+	return err == nil && link == releaseLink(path, filepath.Base(file))
+}
+
+// replaceWithLink replaces a file by a symbolic link in one rename
+func replaceWithLink(file string, target string) error {
+	tmp := file + ".link"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// linkReleaseFile adds a file to a release without copying it, links to databases built elsewhere are copied as links
+func linkReleaseFile(file string, target string) error {
+	info, err := os.Lstat(file)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	}
+	if info.IsDir() {
+		return os.Rename(file, target)
+	}
+	return os.Link(file, target)
+}
+
+// adoptRelease returns the directory of the current release of a database. Files that are not links into it yet,
+// e.g. all files before the first swap, are added to it and replaced by links with the same content.
+// Releases left over by an interrupted swap are removed.
+func adoptRelease(basepath string, path string, files []string) (string, error) {
+	releases := filepath.Join(basepath, databaseReleaseDir, path)
+	if err := os.MkdirAll(releases, 0755); err != nil {
+		return "", err
+	}
+	current, err := os.Readlink(filepath.Join(releases, currentRelease))
+	if err != nil {
+		dir, err := os.MkdirTemp(releases, "release-")
+		if err != nil {
+			return "", err
+		}
+		current = filepath.Base(dir)
+		if err := replaceWithLink(filepath.Join(releases, currentRelease), current); err != nil {
+			return "", err
+		}
+	}
+	entries, err := os.ReadDir(releases)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Name() != current && entry.Name() != currentRelease {
+			if err := os.RemoveAll(filepath.Join(releases, entry.Name())); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	dir := filepath.Join(releases, current)
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name == path+".params" || isReleaseLink(file, path) {
+			continue
+		}
+		os.RemoveAll(filepath.Join(dir, name))
+		if err := linkReleaseFile(file, filepath.Join(dir, name)); err != nil {
+			return "", err
+		}
+		if err := replaceWithLink(file, releaseLink(path, name)); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// StagedSwap replaces the files of a database by a new release: the release is built in a staging directory,
+// verified, swapped in while no search holds the database and the previous release is retired.
+// Searches either see the complete previous or the complete new release, the files of the database are links
+// into the current release directory and the swap replaces the link to it.
+type StagedSwap struct {
+	config ConfigRoot
+	path   string
+	dir    string
+	lock   *databaseLock
+}
+
+// beginStagedSwap prepares an empty staging directory, only one swap of a database can be staged at a time
+func beginStagedSwap(config ConfigRoot, path string) (*StagedSwap, error) {
+	lock, err := tryLockDatabase(config.Paths.Databases, path+".update", true)
+	if err == errDatabaseLocked {
+		return nil, errors.New("an update of " + path + " is already running")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// staging has to be on the same file system for the renames to be atomic
+	dir := filepath.Join(config.Paths.Databases, ".staging", path)
+	if err := os.RemoveAll(dir); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return &StagedSwap{config, path, dir, lock}, nil
+}
+
+// Base is the path the files of the new release are written to, e.g. Base()+".fasta"
+func (s *StagedSwap) Base() string {
+	return filepath.Join(s.dir, s.path)
+}
+
+// Stage moves (mode move) or symlinks (mode link) the files of a database that was built elsewhere into the staging directory
+func (s *StagedSwap) Stage(source string, mode string) error {
+	source = filepath.Clean(source)
+	if !filepath.IsAbs(source) {
+		return errors.New("the database to stage needs an absolute path")
+	}
+	if mode != "link" && mode != "move" {
+		return errors.New("invalid mode " + mode)
+	}
+	files, err := importFiles(source)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		// the release keeps the metadata of the database
+		if strings.HasSuffix(name, ".params") {
+			continue
+		}
+		target := s.Base() + strings.TrimPrefix(name, filepath.Base(source))
+		if mode == "link" {
+			err = os.Symlink(file, target)
+		} else {
+			err = os.Rename(file, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks that the staged release is complete and consistent
+func (s *StagedSwap) Verify(params Params) error {
+	params.Path = s.path
+	params.Status = StatusComplete
+	if problems := validateDatabase(s.dir, params, s.config.App); len(problems) > 0 {
+		return errors.New("staged release of " + s.path + " is invalid: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// swapRelease moves the staged files into a new release directory and points the current release link of the database to it.
+// The files of the database are links into the current release, so searches see one release or the other.
+func (s *StagedSwap) swapRelease(basepath string, staged []string, previous []string) error {
+	releases := filepath.Join(basepath, databaseReleaseDir, s.path)
+	current, err := adoptRelease(basepath, s.path, previous)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(staged))
+	for _, file := range staged {
+		names[filepath.Base(file)] = true
+	}
+	// files of the previous release that are not replaced stay, except for a precomputed index that would not match the new data
+	entries, err := os.ReadDir(current)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if names[name] || strings.HasPrefix(name, s.path+".idx") {
+			continue
+		}
+		if err := linkReleaseFile(filepath.Join(current, name), filepath.Join(s.dir, name)); err != nil {
+			return err
+		}
+		names[name] = true
+	}
+
+	release, err := os.MkdirTemp(releases, "release-")
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(release); err != nil {
+		return err
+	}
+	if err := os.Rename(s.dir, release); err != nil {
+		return err
+	}
+	// new files get their links before the swap, they point to nothing until then
+	created := make([]string, 0)
+	for name := range names {
+		file := filepath.Join(basepath, name)
+		if isReleaseLink(file, s.path) {
+			continue
+		}
+		if err := replaceWithLink(file, releaseLink(s.path, name)); err != nil {
+			for _, file := range created {
+				os.Remove(file)
+			}
+			os.RemoveAll(release)
+			return err
+		}
+		created = append(created, file)
+	}
+
+	if err := replaceWithLink(filepath.Join(releases, currentRelease), filepath.Base(release)); err != nil {
+		for _, file := range created {
+			os.Remove(file)
+		}
+		os.RemoveAll(release)
+		return err
+	}
+
+	for _, file := range previous {
+		if !names[filepath.Base(file)] && isReleaseLink(file, s.path) {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(current)
+}
+
+// Commit swaps the staged release in, keep previous releases stay available as pinned versions.
+// update can change the params of the new release, they are read again since they might have changed in the meantime.
+func (s *StagedSwap) Commit(ctx context.Context, keep int, update func(*Params)) (Params, error) {
+	basepath := s.config.Paths.Databases
+	staged, err := databaseFiles(s.dir, s.path, nil)
+	if err != nil {
+		return Params{}, err
+	}
+	if len(staged) == 0 {
+		return Params{}, errors.New("nothing was staged for " + s.path)
+	}
+
+	// searches only wait for the files to be swapped
+	lock, err := lockDatabase(ctx, basepath, s.path, true)
+	if err != nil {
+		return Params{}, err
+	}
+	defer lock.Unlock()
+
+	if keep > 0 {
+		pinned, err := archiveDatabase(s.config, s.path)
+		if err != nil {
+			return Params{}, err
+		}
+		databaseLog.Info("Keeping previous version", "database", s.path, "pinned", pinned)
+	}
+
+	databases, err := Databases(basepath, false)
+	if err != nil {
+		return Params{}, err
+	}
+	others := make([]string, len(databases))
+	for i, db := range databases {
+		others[i] = db.Path
+	}
+	previous, err := databaseFiles(basepath, s.path, others)
+	if err != nil {
+		return Params{}, err
+	}
+
+	if err := s.swapRelease(basepath, staged, previous); err != nil {
+		return Params{}, err
+	}
+
+	params, err := updateParams(basepath, s.path, func(params *Params) {
+		params.Status = StatusComplete
+		params.Stage = DatabaseReady
+		if update != nil {
+			update(params)
+		}
+		if err := fillManifest(basepath, params); err != nil {
+			databaseLog.Error("Failed to collect metadata", "database", s.path, "error", err)
+		}
+	})
+	if err != nil {
+		return params, err
+	}
+	lock.Unlock()
+
+	if err := pruneDatabaseVersions(s.config, s.path, keep); err != nil {
+		databaseLog.Error("Failed to remove old versions", "database", s.path, "error", err)
+	}
+	return params, nil
+}
+
+// Close removes what is left of the staging directory
+func (s *StagedSwap) Close() error {
+	err := os.RemoveAll(s.dir)
+	if uerr := s.lock.Unlock(); err == nil {
+		err = uerr
+	}
+	return err
+}
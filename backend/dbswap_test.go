@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func writeTestDatabase(t *testing.T, dir string, path string, data string) {
+	files := map[string]string{
+		path:               data,
+		path + ".index":    "0\t0\t" + strconv.Itoa(len(data)) + "\n",
+		path + ".dbtype":   "\x00\x00\x00\x00",
+		path + "_h":        "h\x00",
+		path + "_h.index":  "0\t0\t2\n",
+		path + "_h.dbtype": "\x0c\x00\x00\x00",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStagedSwap(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	config.Paths.Results = t.TempDir()
+	writeTestDatabase(t, config.Paths.Databases, "db", "OLD\x00")
+	// the index of the previous release does not match the new data
+	if err := os.WriteFile(filepath.Join(config.Paths.Databases, "db.idx"), []byte("index"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(config.Paths.Databases, "db.params"), []byte(`{"name":"db","path":"db","upstream":"1","status":"COMPLETE"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	release := t.TempDir()
+	writeTestDatabase(t, release, "build", "NEW!\x00")
+
+	swap, err := beginStagedSwap(config, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := beginStagedSwap(config, "db"); err == nil {
+		t.Error("expected a second swap of the database to be refused")
+	}
+	if err := swap.Stage(filepath.Join(release, "build"), "move"); err != nil {
+		t.Fatal(err)
+	}
+	params, err := ReadParams(filepath.Join(config.Paths.Databases, "db.params"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := swap.Verify(params); err != nil {
+		t.Fatal(err)
+	}
+
+	// the swap waits for running searches
+	search, err := lockDatabases(context.Background(), config.Paths.Databases, []string{"db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := swap.Commit(ctx, 1, nil); err == nil {
+		t.Fatal("expected the swap to wait for the search")
+	}
+	unlockDatabases(search)
+
+	params, err = swap.Commit(context.Background(), 1, func(params *Params) { params.Upstream = "2" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := swap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(config.Paths.Databases, "db"))
+	if err != nil || string(data) != "NEW!\x00" {
+		t.Errorf("expected the new release, got %q", data)
+	}
+	if params.Upstream != "2" || params.Status != StatusComplete {
+		t.Errorf("unexpected params %+v", params)
+	}
+	if fileExists(filepath.Join(config.Paths.Databases, "db.idx")) {
+		t.Error("expected the stale index to be removed")
+	}
+	archived, err := os.ReadFile(filepath.Join(config.Paths.Databases, "db@1"))
+	if err != nil || string(archived) != "OLD\x00" {
+		t.Errorf("expected the previous release to be kept as db@1, got %q", archived)
+	}
+	if fileExists(filepath.Join(config.Paths.Databases, ".staging", "db")) {
+		t.Error("expected the staging directory to be removed")
+	}
+	if !isReleaseLink(filepath.Join(config.Paths.Databases, "db.index"), "db") {
+		t.Error("expected the files to link to the current release")
+	}
+
+	// the next swap only replaces the link to the release, the archive keeps the files of the replaced one
+	next := t.TempDir()
+	writeTestDatabase(t, next, "build", "NEWER\x00")
+	if err := os.Remove(filepath.Join(next, "build_h.dbtype")); err != nil {
+		t.Fatal(err)
+	}
+	if swap, err = beginStagedSwap(config, "db"); err != nil {
+		t.Fatal(err)
+	}
+	defer swap.Close()
+	if err := swap.Stage(filepath.Join(next, "build"), "move"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swap.Commit(context.Background(), 2, func(params *Params) { params.Upstream = "3" }); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"db": "NEWER\x00", "db_h.dbtype": "\x0c\x00\x00\x00", "db@2": "NEW!\x00", "db@1": "OLD\x00"} {
+		if data, err := os.ReadFile(filepath.Join(config.Paths.Databases, name)); err != nil || string(data) != expected {
+			t.Errorf("expected %q in %s, got %q %v", expected, name, data, err)
+		}
+	}
+	releases, err := os.ReadDir(filepath.Join(config.Paths.Databases, databaseReleaseDir, "db"))
+	if err != nil || len(releases) != 2 {
+		t.Errorf("expected the current release and its link, got %v %v", releases, err)
+	}
+}
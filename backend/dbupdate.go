@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultUpdateInterval = 24 * time.Hour
+
+// queuedUpdates has the tickets of the update jobs the scheduler submitted and that did not finish yet, by database path.
+// Their ids include the upstream release, so they can not be derived from the path like the ids of other index jobs.
+var queuedUpdates = struct {
+	sync.Mutex
+	tickets map[string]Id
+}{tickets: make(map[string]Id)}
+
+// queuedUpdate returns the ticket of the running or pending update of a database
+func queuedUpdate(path string) (Id, bool) {
+	queuedUpdates.Lock()
+	defer queuedUpdates.Unlock()
+	id, ok := queuedUpdates.tickets[path]
+	return id, ok
+}
+
+func setQueuedUpdate(path string, id Id) {
+	queuedUpdates.Lock()
+	defer queuedUpdates.Unlock()
+	if id == "" {
+		delete(queuedUpdates.tickets, path)
+	} else {
+		queuedUpdates.tickets[path] = id
+	}
+}
+
+// NewDatabaseUpdateRequest creates an index job that rebuilds a database from a new upstream release
+func NewDatabaseUpdateRequest(path string, source string, format string, upstream string) JobRequest {
+	job := IndexJob{
+		path,
+		upstream,
+		source,
+		format,
+	}
+	return JobRequest{
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobIndex,
+		Job:    job,
+	}
+}
+
+// upstreamVersion identifies the current upstream release, either by the first line of the release URL
+// (e.g. UniProt's reldate.txt) or by the ETag or Last-Modified header of the source
+func upstreamVersion(ctx context.Context, update ConfigDatabaseUpdate) (string, error) {
+	method := "HEAD"
+	url := update.Source
+	if update.Release != "" {
+		method = "GET"
+		url = update.Release
+	}
+
+	req, err := newSourceRequest(ctx, method, url)
+	if err != nil {
+		return "", err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New("checking " + url + " failed: " + res.Status)
+	}
+
+	if update.Release != "" {
+		scanner := bufio.NewScanner(io.LimitReader(res.Body, 64*1024))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				return line, nil
+			}
+		}
+		return "", errors.New("empty release information at " + url)
+	}
+	if etag := res.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+	if modified := res.Header.Get("Last-Modified"); modified != "" {
+		return modified, nil
+	}
+	return "", errors.New(url + " has neither an ETag nor a Last-Modified header, configure a release URL")
+}
+
+// checkDatabaseUpdate returns the ticket of a queued update, or an empty one if the database is up to date
+func checkDatabaseUpdate(jobsystem JobSystem, config ConfigRoot, path string, update ConfigDatabaseUpdate) (Ticket, error) {
+	params, err := ReadParams(filepath.Join(config.Paths.Databases, filepath.Base(path)+".params"))
+	if err != nil {
+		return Ticket{}, err
+	}
+	if params.Status != StatusComplete || params.Disabled {
+		return Ticket{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	upstream, err := upstreamVersion(ctx, update)
+	if err != nil {
+		return Ticket{}, err
+	}
+	if upstream == params.Upstream {
+		return Ticket{}, nil
+	}
+
+	format := update.Format
+	if format == "" {
+		format = "fasta"
+	}
+	request := NewDatabaseUpdateRequest(params.Path, update.Source, format, upstream)
+	ticket, err := jobsystem.NewJob(request, config.Paths.Results, false)
+	if err != nil {
+		return Ticket{}, err
+	}
+	if ticket.RawStatus == StatusPending {
+		databaseLog.Debug("Queued database update", "database", params.Path, "upstream", upstream, "ticket", ticket.Id)
+	}
+	return ticket, nil
+}
+
+// databaseUpdater periodically checks the configured upstream sources and queues a rebuild for new releases,
+// operators are alerted when a check starts failing or an update job fails
+func databaseUpdater(jobsystem JobSystem, config ConfigRoot, alerter *Alerter) {
+	if len(config.Updates) == 0 {
+		return
+	}
+
+	lastCheck := make(map[string]time.Time)
+	failing := make(map[string]bool)
+	updates := make(map[Id]string)
+	for {
+		for id, path := range updates {
+			ticket, err := jobsystem.GetTicket(id)
+			if err != nil {
+				continue
+			}
+			switch ticket.RawStatus {
+			case StatusError, StatusTimeout:
+				message := "The update job " + string(id) + " did not finish"
+				if ticket.Error != nil {
+					message += ": " + ticket.Error.Message
+				}
+				alerter.Alert("Database update of "+path+" failed", message)
+				delete(updates, id)
+				setQueuedUpdate(path, "")
+			case StatusComplete:
+				delete(updates, id)
+				setQueuedUpdate(path, "")
+			}
+		}
+		for path, update := range config.Updates {
+			interval := defaultUpdateInterval
+			if update.Interval != "" {
+				if i, err := time.ParseDuration(update.Interval); err == nil {
+					interval = i
+				}
+			}
+			if time.Since(lastCheck[path]) < interval {
+				continue
+			}
+			lastCheck[path] = time.Now()
+			ticket, err := checkDatabaseUpdate(jobsystem, config, path, update)
+			if err != nil {
+				databaseLog.Error("Failed to check for an update", "database", path, "error", err)
+				if !failing[path] {
+					alerter.Alert("Database update check of "+path+" failed", err.Error())
+				}
+				failing[path] = true
+				continue
+			}
+			failing[path] = false
+			if ticket.Id != "" {
+				updates[ticket.Id] = path
+				setQueuedUpdate(path, ticket.Id)
+			}
+		}
+		time.Sleep(1 * time.Minute)
+	}
+}
+
+// UpdateDatabase builds the new release of a database in a staging directory next to the databases,
+// the running database stays searchable until the finished files are moved over it
+func UpdateDatabase(ctx context.Context, config ConfigRoot, job IndexJob, executor Executor, progress io.Writer) error {
+	file := filepath.Join(config.Paths.Databases, job.Path)
+	params, err := ReadParams(file + ".params")
+	if err != nil {
+		return err
+	}
+	suffix, err := databaseInputSuffix(job.Format)
+	if err != nil {
+		return err
+	}
+
+	swap, err := beginStagedSwap(config, job.Path)
+	if err != nil {
+		return err
+	}
+	defer swap.Close()
+
+	base := swap.Base()
+	databaseLog.Debug("Downloading database", "source", job.Source)
+	// downloaded next to the staging directory, so an interrupted download is not removed with it
+	download := filepath.Join(config.Paths.Databases, ".staging", job.Path+suffix)
+	// a failed verification fails the job, the staging directory is removed and the current version stays in place
+	update := config.Updates[job.Path]
+	_, err = downloadDatabaseSource(ctx, config, job.Source, download, progress, func(digest string) error {
+		if err := verifyChecksum(ctx, update.Checksum, job.Source, digest); err != nil {
+			return err
+		}
+		return verifySignature(ctx, update.Signature, update.PublicKey, digest)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(download, base+suffix); err != nil {
+		return err
+	}
+	if err := CheckDatabase(base, params, config, executor.ForDatabase(params), nil); err != nil {
+		return err
+	}
+	if err := swap.Verify(params); err != nil {
+		return err
+	}
+
+	_, err = swap.Commit(ctx, update.Keep, func(params *Params) {
+		params.Upstream = job.Upstream
+		params.Source = job.Source
+		params.Format = job.Format
+	})
+	if err != nil {
+		return err
+	}
+	databaseLog.Info("Updated database", "database", job.Path, "upstream", job.Upstream)
+	return nil
+}
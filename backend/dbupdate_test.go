@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/reldate.txt":
+			w.Write([]byte("\nUniProt Knowledgebase Release 2024_01 consists of:\nUniProtKB/Swiss-Prot Release 2024_01\n"))
+		case "/db.fasta":
+			w.Header().Set("ETag", `"abc"`)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		update   ConfigDatabaseUpdate
+		expected string
+	}{
+		{ConfigDatabaseUpdate{Source: server.URL + "/db.fasta", Release: server.URL + "/reldate.txt"}, "UniProt Knowledgebase Release 2024_01 consists of:"},
+		{ConfigDatabaseUpdate{Source: server.URL + "/db.fasta"}, `"abc"`},
+	}
+	for _, test := range tests {
+		version, err := upstreamVersion(context.Background(), test.update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != test.expected {
+			t.Errorf("upstreamVersion = %q, expected %q", version, test.expected)
+		}
+	}
+
+	if _, err := upstreamVersion(context.Background(), ConfigDatabaseUpdate{Source: server.URL + "/missing"}); err == nil {
+		t.Error("expected an error for a missing source")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// dbManagementAuthorized requires the server.dbtoken as bearer token, all requests are refused if none is configured
+func dbManagementAuthorized(config ConfigRoot, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if config.Server.DbToken == "" {
+			http.Error(w, "Database management requires a server.dbtoken", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.Server.DbToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Invalid database management token", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func validSourceURL(source string) error {
+	u, err := url.Parse(source)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "gs", "az":
+		_, err = blobSourceURL(source)
+		return err
+	default:
+		return errors.New("only http, https, gs and az URLs are supported")
+	}
+	return nil
+}
+
+// writeDatabaseSource streams an uploaded or downloaded database input file to path,
+// gzip compressed input is decompressed. The file only appears under its final name once it is complete.
+func writeDatabaseSource(path string, r io.Reader, compressed bool) error {
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// databaseInputSuffix returns the suffix of the input file CheckDatabase builds a database from
+func databaseInputSuffix(format string) (string, error) {
+	switch format {
+	case "fasta":
+		return ".fasta", nil
+	case "stockholm":
+		return ".sto", nil
+	case "structures":
+		return ".tar", nil
+	}
+	return "", errors.New("invalid database input format " + format)
+}
+
+// fetchDatabaseSource downloads the input of a database that was registered with a source, if it is still missing
+func fetchDatabaseSource(ctx context.Context, config ConfigRoot, file string, params Params, executor Executor, tempDir string, stage func(DatabaseStage), progress io.Writer) error {
+	if params.Format == accessionsFormat {
+		if fileExists(file + ".fasta") {
+			return nil
+		}
+		stage(DatabaseDownloading)
+		return fetchAccessionDatabase(ctx, config, file, progress)
+	}
+	if params.Source == "" {
+		return nil
+	}
+	// public databases are downloaded and indexed by the databases module
+	if params.Format == publicDatabaseFormat {
+		if fileExists(file + ".dbtype") {
+			return nil
+		}
+		databaseLog.Debug("Downloading database", "source", params.Source)
+		stage(DatabaseDownloading)
+		return downloadPublicDatabase(ctx, config, params.Source, file, params, executor, tempDir, stage)
+	}
+
+	suffix, err := databaseInputSuffix(params.Format)
+	if err != nil || fileExists(file+suffix) {
+		return err
+	}
+	databaseLog.Debug("Downloading database", "source", params.Source)
+	stage(DatabaseDownloading)
+	// unverified files are not kept, the next attempt downloads them again
+	_, err = downloadDatabaseSource(ctx, config, params.Source, file+suffix, progress, func(digest string) error {
+		return verifyChecksum(ctx, params.Checksum, params.Source, digest)
+	})
+	return err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDbManagementAuthorized(t *testing.T) {
+	next := func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		token    string
+		header   string
+		expected int
+	}{
+		{"", "", http.StatusForbidden},
+		{"", "Bearer ", http.StatusForbidden},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		var config ConfigRoot
+		config.Server.DbToken = test.token
+		req := httptest.NewRequest("POST", "/database", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		w := httptest.NewRecorder()
+		dbManagementAuthorized(config, next)(w, req)
+		if w.Code != test.expected {
+			t.Errorf("token %q, header %q: status %d, expected %d", test.token, test.header, w.Code, test.expected)
+		}
+	}
+}
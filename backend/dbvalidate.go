@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DatabaseHealth lists the problems found while validating a database, a database without problems is usable
+type DatabaseHealth struct {
+	Path     string   `json:"path"`
+	Problems []string `json:"problems"`
+}
+
+// dataSize returns the size of a database data file, split databases are stored as file.0, file.1, ...
+func dataSize(data string) (int64, error) {
+	if info, err := os.Stat(data); err == nil {
+		return info.Size(), nil
+	}
+
+	var size int64
+	for i := 0; ; i++ {
+		info, err := os.Stat(data + "." + strconv.Itoa(i))
+		if os.IsNotExist(err) && i > 0 {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+}
+
+// checkIndex verifies that every entry of the index of a database points into its data file
+func checkIndex(data string) error {
+	size, err := dataSize(data)
+	if err != nil {
+		return fmt.Errorf("missing data file %s", filepath.Base(data))
+	}
+	f, err := os.Open(data + ".index")
+	if err != nil {
+		return fmt.Errorf("missing index file %s.index", filepath.Base(data))
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			return fmt.Errorf("%s.index line %d: expected 3 columns, found %d", filepath.Base(data), line, len(fields))
+		}
+		offset, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s.index line %d: invalid offset %s", filepath.Base(data), line, fields[1])
+		}
+		length, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s.index line %d: invalid length %s", filepath.Base(data), line, fields[2])
+		}
+		if offset+length > size {
+			return fmt.Errorf("%s.index line %d: entry ends at byte %d but %s has %d bytes, the index does not match the data", filepath.Base(data), line, offset+length, filepath.Base(data), size)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s.index: %s", filepath.Base(data), err)
+	}
+	if line == 0 {
+		return fmt.Errorf("%s.index is empty", filepath.Base(data))
+	}
+	return nil
+}
+
+// validateDatabase checks that the files a search needs are present and consistent,
+// databases that are not built yet are not checked
+func validateDatabase(basepath string, params Params, app ConfigApp) []string {
+	problems := make([]string, 0)
+	switch params.Status {
+	case StatusComplete:
+	case StatusError:
+		return append(problems, "building the database failed, see the log of its index job")
+	default:
+		return problems
+	}
+
+	base := filepath.Join(basepath, params.Path)
+	parts := []string{"", "_h"}
+	if app == AppFoldSeek || params.Structure {
+		parts = append(parts, "_ss")
+	}
+	for _, part := range parts {
+		if !fileExists(base + part + ".dbtype") {
+			problems = append(problems, "missing "+params.Path+part+".dbtype")
+		}
+		if err := checkIndex(base + part); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if params.Structure && !fileExists(base+"_ca") && !fileExists(base+"_ca.index") {
+		problems = append(problems, "missing C-alpha coordinates "+params.Path+"_ca of the structure database")
+	}
+	if params.Taxonomy && !fileExists(base+"_taxonomy") && !fileExists(base+"_mapping") {
+		problems = append(problems, "taxonomy is enabled but neither "+params.Path+"_taxonomy nor "+params.Path+"_mapping exist")
+	}
+	if fileExists(base+".idx") && !fileExists(base+".idx.index") {
+		problems = append(problems, "missing index file "+params.Path+".idx.index, recreate the index with createindex")
+	}
+	return problems
+}
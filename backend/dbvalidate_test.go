@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateDatabase(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"db":            "AAAA\x00CCCC\x00",
+		"db.index":      "0\t0\t5\n1\t5\t5\n",
+		"db.dbtype":     "\x00\x00\x00\x00",
+		"db_h":          "a\n\x00b\n\x00",
+		"db_h.index":    "0\t0\t3\n1\t3\t3\n",
+		"db_h.dbtype":   "\x0c\x00\x00\x00",
+		"split.0":       "AAAA\x00",
+		"split.1":       "CCCC\x00",
+		"split.index":   "0\t0\t5\n1\t5\t5\n",
+		"split.dbtype":  "\x00\x00\x00\x00",
+		"split_h":       "a\n\x00b\n\x00",
+		"split_h.index": "0\t0\t3\n1\t3\t3\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if problems := validateDatabase(dir, Params{Path: "db", Status: StatusComplete}, AppMMseqs2); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+	if problems := validateDatabase(dir, Params{Path: "missing", Status: StatusPending}, AppMMseqs2); len(problems) != 0 {
+		t.Errorf("expected pending databases to be skipped, got %v", problems)
+	}
+
+	problems := validateDatabase(dir, Params{Path: "split", Status: StatusComplete, Taxonomy: true}, AppMMseqs2)
+	if len(problems) != 2 || !strings.Contains(problems[0], "split_h.dbtype") || !strings.Contains(problems[1], "taxonomy") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "db.index"), []byte("0\t0\t5\n1\t5\t50\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	problems = validateDatabase(dir, Params{Path: "db", Status: StatusComplete}, AppMMseqs2)
+	if len(problems) != 1 || !strings.Contains(problems[0], "line 2") {
+		t.Errorf("expected the index mismatch to be found, got %v", problems)
+	}
+}
+
+func TestStructureInput(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "afdb")
+	if structureInput(base) != "" {
+		t.Error("expected no structure input")
+	}
+	if err := os.Mkdir(base+structureDirSuffix, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if structureInput(base) != base+structureDirSuffix {
+		t.Error("expected the structure directory to be used")
+	}
+	if err := os.WriteFile(base+".tar", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if structureInput(base) != base+".tar" {
+		t.Error("expected the tar archive to be preferred")
+	}
+
+	params := Params{Path: "afdb", Status: StatusComplete, Structure: true}
+	problems := validateDatabase(dir, params, AppMMseqs2)
+	found := false
+	for _, problem := range problems {
+		found = found || strings.Contains(problem, "afdb_ss")
+	}
+	if !found {
+		t.Errorf("expected the missing 3Di component to be reported, got %v", problems)
+	}
+}
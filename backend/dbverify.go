@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// fetchSmallFile downloads a checksum or signature file
+func fetchSmallFile(ctx context.Context, source string) ([]byte, error) {
+	req, err := newSourceRequest(ctx, "GET", source)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("downloading " + source + " failed: " + res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1024*1024))
+}
+
+// parseChecksumFile finds the SHA256 of a file in the output of sha256sum,
+// a file with a single checksum is used for any name
+func parseChecksumFile(data string, name string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	var checksums [][]string
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		checksums = append(checksums, fields)
+	}
+	if len(checksums) == 1 {
+		return strings.ToLower(checksums[0][0]), nil
+	}
+	for _, fields := range checksums {
+		// binary mode entries are prefixed with *
+		if len(fields) > 1 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", errors.New("no checksum for " + name)
+}
+
+// expectedChecksum resolves a checksum setting, either sha256:<hex> or the URL of a sha256sum file
+func expectedChecksum(ctx context.Context, checksum string, source string) (string, error) {
+	if strings.HasPrefix(checksum, "sha256:") {
+		return strings.ToLower(strings.TrimPrefix(checksum, "sha256:")), nil
+	}
+	data, err := fetchSmallFile(ctx, checksum)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", err
+	}
+	return parseChecksumFile(string(data), path.Base(u.Path))
+}
+
+// verifyChecksum compares the SHA256 of a downloaded file with the expected one, an empty checksum is not checked
+func verifyChecksum(ctx context.Context, checksum string, source string, digest string) error {
+	if checksum == "" {
+		return nil
+	}
+	expected, err := expectedChecksum(ctx, checksum, source)
+	if err != nil {
+		return err
+	}
+	if expected != digest {
+		return errors.New("checksum mismatch for " + source + ": expected " + expected + ", got " + digest)
+	}
+	return nil
+}
+
+// verifySignature checks a detached base64 encoded ed25519 signature of the hex encoded SHA256 of the download
+func verifySignature(ctx context.Context, signature string, publicKey string, digest string) error {
+	if signature == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 public key")
+	}
+	data, err := fetchSmallFile(ctx, signature)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.New("invalid signature at " + signature)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), []byte(digest), sig) {
+		return errors.New("signature verification failed for " + signature)
+	}
+	return nil
+}
+
+// validChecksum checks the format of a checksum setting
+func validChecksum(checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	if strings.HasPrefix(checksum, "sha256:") {
+		if digest, err := hex.DecodeString(strings.TrimPrefix(checksum, "sha256:")); err != nil || len(digest) != 32 {
+			return errors.New("invalid sha256 checksum")
+		}
+		return nil
+	}
+	return validSourceURL(checksum)
+}
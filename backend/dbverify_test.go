@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseChecksumFile(t *testing.T) {
+	sums := "aaaa  uniref50.fasta.gz\nBBBB *uniref90.fasta.gz\n"
+	if got, err := parseChecksumFile(sums, "uniref90.fasta.gz"); err != nil || got != "bbbb" {
+		t.Errorf("unexpected checksum %s (%v)", got, err)
+	}
+	if _, err := parseChecksumFile(sums, "uniref100.fasta.gz"); err == nil {
+		t.Error("expected a missing checksum to be an error")
+	}
+	if got, err := parseChecksumFile("cccc\n", "anything"); err != nil || got != "cccc" {
+		t.Errorf("expected a single checksum to be used, got %s (%v)", got, err)
+	}
+}
+
+func TestVerifyDownload(t *testing.T) {
+	sum := sha256.Sum256([]byte("data"))
+	digest := hex.EncodeToString(sum[:])
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/SHA256SUMS":
+			fmt.Fprintf(w, "%s  db.fasta.gz\n0000  other.fasta.gz\n", digest)
+		case "/good.sig":
+			fmt.Fprint(w, base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(digest))))
+		case "/bad.sig":
+			fmt.Fprint(w, base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte("other"))))
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	source := "https://example.org/db.fasta.gz"
+	if err := verifyChecksum(ctx, srv.URL+"/SHA256SUMS", source, digest); err != nil {
+		t.Error(err)
+	}
+	if err := verifyChecksum(ctx, "sha256:"+digest, source, digest); err != nil {
+		t.Error(err)
+	}
+	if err := verifyChecksum(ctx, "sha256:"+digest, source, "0000"); err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+
+	key := base64.StdEncoding.EncodeToString(public)
+	if err := verifySignature(ctx, srv.URL+"/good.sig", key, digest); err != nil {
+		t.Error(err)
+	}
+	if err := verifySignature(ctx, srv.URL+"/bad.sig", key, digest); err == nil {
+		t.Error("expected an invalid signature to be rejected")
+	}
+}
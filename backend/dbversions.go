@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const databaseVersionsFile = "databases.json"
+
+// DatabaseVersion identifies the release of a database that a job was computed with
+type DatabaseVersion struct {
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Upstream  string `json:"upstream,omitempty"`
+	Updated   string `json:"updated,omitempty"`
+	Sequences int64  `json:"sequences,omitempty"`
+}
+
+// databaseVersion is the part after the @ of a pinned database, the upstream release is preferred over the user given version
+func databaseVersion(params Params) string {
+	version := cleanPathComponent.ReplaceAllString(params.Upstream, "")
+	if version == "" {
+		version = cleanPathComponent.ReplaceAllString(params.Version, "")
+	}
+	if version == "" {
+		if updated, err := time.Parse(time.RFC3339, params.Updated); err == nil {
+			version = updated.UTC().Format("20060102150405")
+		}
+	}
+	return version
+}
+
+// splitPinnedDatabase splits a database like uniref50@2024_01 into its path and version
+func splitPinnedDatabase(database string) (string, string) {
+	path, version, _ := strings.Cut(database, "@")
+	return path, version
+}
+
+// resolvePinnedDatabases checks the pinned databases of a submission, pins of the current version are
+// replaced by the database itself and archived versions are added to the valid databases
+func resolvePinnedDatabases(basepath string, dbs []string, valid []Params) ([]string, []Params, error) {
+	resolved := make([]string, len(dbs))
+	for i, database := range dbs {
+		resolved[i] = database
+		path, version := splitPinnedDatabase(database)
+		if version == "" {
+			continue
+		}
+
+		if current, err := ReadParams(filepath.Join(basepath, filepath.Base(path)+".params")); err == nil && !current.Archived && databaseVersion(current) == version {
+			resolved[i] = path
+			continue
+		}
+
+		filename := filepath.Join(basepath, filepath.Base(database)+".params")
+		if !fileExists(filename) {
+			return nil, nil, errors.New("version " + version + " of database " + path + " is not available")
+		}
+		params, err := ReadParams(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !params.Archived || params.Remove || params.Status != StatusComplete {
+			return nil, nil, errors.New("version " + version + " of database " + path + " is not available")
+		}
+		valid = append(valid, params)
+	}
+	return resolved, valid, nil
+}
+
+// archiveDatabase keeps the current files of a database as the pinned database path@version.
+// Hard links are used, so the files are not copied and stay intact when new files are moved over the originals.
+func archiveDatabase(config ConfigRoot, path string) (string, error) {
+	params, err := ReadParams(filepath.Join(config.Paths.Databases, path+".params"))
+	if err != nil {
+		return "", err
+	}
+	version := databaseVersion(params)
+	if version == "" {
+		version = time.Now().UTC().Format("20060102150405")
+	}
+	pinned := path + "@" + version
+	if fileExists(filepath.Join(config.Paths.Databases, pinned+".params")) {
+		return pinned, nil
+	}
+
+	databases, err := Databases(config.Paths.Databases, false)
+	if err != nil {
+		return "", err
+	}
+	others := make([]string, len(databases))
+	for i, db := range databases {
+		others[i] = db.Path
+	}
+	files, err := databaseFiles(config.Paths.Databases, path, others)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		// the structure files a database was built from are not needed to search it
+		if name == path+".params" || name == path+structureDirSuffix {
+			continue
+		}
+		// swapped databases link to their current release, the archive keeps the files of that release
+		source := file
+		if isReleaseLink(file, path) {
+			source = filepath.Join(config.Paths.Databases, releaseLink(path, name))
+		}
+		if err := os.Link(source, filepath.Join(config.Paths.Databases, pinned+strings.TrimPrefix(name, path))); err != nil {
+			return "", err
+		}
+	}
+
+	// the params are written last, so the archive is only listed once it is complete
+	params.Path = pinned
+	params.Default = false
+	params.Archived = true
+	if err := SaveParams(filepath.Join(config.Paths.Databases, pinned+".params"), params); err != nil {
+		return "", err
+	}
+	return pinned, nil
+}
+
+// pruneDatabaseVersions removes all but the keep most recently archived versions of a database
+func pruneDatabaseVersions(config ConfigRoot, path string, keep int) error {
+	matches, err := filepath.Glob(filepath.Join(config.Paths.Databases, path+"@*.params"))
+	if err != nil {
+		return err
+	}
+
+	type archived struct {
+		path     string
+		modified time.Time
+	}
+	versions := make([]archived, 0, len(matches))
+	for _, match := range matches {
+		params, err := ReadParams(match)
+		if err != nil {
+			return err
+		}
+		// versions waiting for their last job are already removed
+		if params.Remove {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, archived{strings.TrimSuffix(filepath.Base(match), ".params"), info.ModTime()})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].modified.After(versions[j].modified)
+	})
+
+	for i := keep; i < len(versions); i++ {
+		if _, err := DeleteDatabase(config, versions[i].path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDatabaseVersions records the versions of the databases used by a job next to the job.json
+func writeDatabaseVersions(config ConfigRoot, request JobRequest) error {
+	if _, ok := request.Job.(IndexJob); ok {
+		return nil
+	}
+	databases := jobDatabases(request)
+	if len(databases) == 0 {
+		return nil
+	}
+	versions := make([]DatabaseVersion, 0, len(databases))
+	for _, database := range databases {
+		params, err := ReadParams(filepath.Join(config.Paths.Databases, database+".params"))
+		if err != nil {
+			return err
+		}
+		version := databaseVersion(params)
+		if _, pinned := splitPinnedDatabase(database); pinned != "" {
+			version = pinned
+		}
+		versions = append(versions, DatabaseVersion{database, params.Name, version, params.Upstream, params.Updated, params.Sequences})
+	}
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(config.Paths.Results, string(request.Id), databaseVersionsFile), data, 0644)
+}
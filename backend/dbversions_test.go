@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveAndPinDatabase(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"db":         "AAAA\x00",
+		"db.index":   "0\t0\t5\n",
+		"db.params":  `{"name":"db","path":"db","upstream":"2024_01","status":"COMPLETE"}`,
+		"db2.params": `{"name":"db2","path":"db2","status":"COMPLETE"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var config ConfigRoot
+	config.Paths.Databases = dir
+	config.Paths.Results = t.TempDir()
+
+	pinned, err := archiveDatabase(config, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned != "db@2024_01" {
+		t.Fatalf("unexpected archive %s", pinned)
+	}
+	if !fileExists(filepath.Join(dir, "db@2024_01.index")) || fileExists(filepath.Join(dir, "db2@2024_01.params")) {
+		t.Error("expected only the files of db to be archived")
+	}
+
+	valid, err := Databases(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(valid) != 2 {
+		t.Fatalf("expected archived versions to be hidden, got %d databases", len(valid))
+	}
+
+	// the archive is still the current version
+	dbs, _, err := resolvePinnedDatabases(dir, []string{"db@2024_01", "db2"}, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbs[0] != "db" {
+		t.Errorf("expected the current version to be used, got %s", dbs[0])
+	}
+
+	if _, err := updateParams(dir, "db", func(params *Params) { params.Upstream = "2024_02" }); err != nil {
+		t.Fatal(err)
+	}
+	dbs, valid, err = resolvePinnedDatabases(dir, []string{"db@2024_01"}, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbs[0] != "db@2024_01" || valid[len(valid)-1].Path != "db@2024_01" {
+		t.Errorf("expected the archived version to be pinned, got %v", dbs)
+	}
+	if _, _, err := resolvePinnedDatabases(dir, []string{"db@2023_12"}, valid); err == nil {
+		t.Error("expected an unknown version to be rejected")
+	}
+
+	if err := pruneDatabaseVersions(config, "db", 0); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Join(dir, "db@2024_01.index")) || !fileExists(filepath.Join(dir, "db.index")) {
+		t.Error("expected only the archived version to be removed")
+	}
+}
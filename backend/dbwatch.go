@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// databaseSnapshot maps the databases in basepath to the modification time of their params file
+func databaseSnapshot(basepath string) (map[string]time.Time, error) {
+	matches, err := filepath.Glob(filepath.Clean(basepath) + "/*.params")
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]time.Time, len(matches))
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			// removed while listing
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		base := filepath.Base(match)
+		snapshot[strings.TrimSuffix(base, filepath.Ext(base))] = info.ModTime()
+	}
+	return snapshot, nil
+}
+
+// changedDatabases returns the sorted paths that were added to or removed from previous
+func changedDatabases(previous, current map[string]time.Time) ([]string, []string) {
+	added := make([]string, 0)
+	removed := make([]string, 0)
+	for path := range current {
+		if _, ok := previous[path]; !ok {
+			added = append(added, path)
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// databasesETag changes whenever a database is added, removed or its params are modified
+func databasesETag(snapshot map[string]time.Time) string {
+	paths := make([]string, 0, len(snapshot))
+	for path := range snapshot {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	h := sha1.New()
+	for _, path := range paths {
+		h.Write([]byte(path + "\x00" + strconv.FormatInt(snapshot[path].UnixNano(), 10) + "\n"))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil))[:16] + `"`
+}
+
+// DatabaseWatcher picks up databases that are added to or removed from the databases directory at runtime
+type DatabaseWatcher struct {
+	jobsystem JobSystem
+	config    ConfigRoot
+	mu        sync.Mutex
+	known     map[string]time.Time
+	healthMu  sync.RWMutex
+	problems  map[string][]string
+}
+
+func NewDatabaseWatcher(jobsystem JobSystem, config ConfigRoot) *DatabaseWatcher {
+	return &DatabaseWatcher{jobsystem, config, sync.Mutex{}, nil, sync.RWMutex{}, make(map[string][]string)}
+}
+
+// validate checks a database and logs the problems that were found
+func (w *DatabaseWatcher) validate(path string) []string {
+	var problems []string
+	params, err := ReadParams(filepath.Join(w.config.Paths.Databases, path+".params"))
+	if err != nil {
+		problems = []string{"invalid params file " + path + ".params: " + err.Error()}
+	} else {
+		problems = validateDatabase(w.config.Paths.Databases, params, w.config.App)
+	}
+	for _, problem := range problems {
+		databaseLog.Warn(problem, "database", path)
+	}
+	return problems
+}
+
+// Health returns the databases with problems found during the last scans
+func (w *DatabaseWatcher) Health() []DatabaseHealth {
+	w.healthMu.RLock()
+	defer w.healthMu.RUnlock()
+	health := make([]DatabaseHealth, 0, len(w.problems))
+	for path, problems := range w.problems {
+		health = append(health, DatabaseHealth{path, problems})
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Path < health[j].Path
+	})
+	return health
+}
+
+// Usable rejects databases with known problems, so a job does not fail with an error of mmseqs later
+func (w *DatabaseWatcher) Usable(dbs []string) error {
+	w.healthMu.RLock()
+	defer w.healthMu.RUnlock()
+	for _, database := range dbs {
+		if problems, ok := w.problems[database]; ok {
+			return errors.New("database " + database + " can not be used: " + strings.Join(problems, "; "))
+		}
+	}
+	return nil
+}
+
+// Reload rescans the databases directory, enqueues index jobs for new databases and validates changed databases.
+// The first scan (re-)indexes every database, like a server restart.
+// The directory is scanned and the databases are validated without holding the lock, it is only taken to publish the results.
+func (w *DatabaseWatcher) Reload() ([]string, []string, error) {
+	w.mu.Lock()
+	known := w.known
+	w.mu.Unlock()
+
+	snapshot, err := databaseSnapshot(w.config.Paths.Databases)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	initial := known == nil
+	added, removed := changedDatabases(known, snapshot)
+	for _, path := range added {
+		params, err := ReadParams(filepath.Join(w.config.Paths.Databases, path+".params"))
+		// invalid params are reported by the validation below
+		if err != nil {
+			continue
+		}
+		if params.Status == StatusRunning || params.Remove || params.Archived {
+			continue
+		}
+
+		request, err := NewIndexJobRequest(path, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		// jobs of databases added at runtime are not repeated if they already finished
+		if _, err := w.jobsystem.NewJob(request, w.config.Paths.Results, initial); err != nil {
+			return nil, nil, err
+		}
+		if !initial {
+			databaseLog.Info("Database added", "database", path)
+		}
+	}
+	for _, path := range removed {
+		databaseLog.Info("Database removed", "database", path)
+	}
+	// new databases and databases whose params changed (e.g. after a build) are validated again
+	validated := make(map[string][]string)
+	for path, modified := range snapshot {
+		if previous, ok := known[path]; ok && previous.Equal(modified) {
+			continue
+		}
+		validated[path] = w.validate(path)
+	}
+
+	w.mu.Lock()
+	w.known = snapshot
+	w.mu.Unlock()
+	w.healthMu.Lock()
+	defer w.healthMu.Unlock()
+	for _, path := range removed {
+		delete(w.problems, path)
+	}
+	for path, problems := range validated {
+		if len(problems) == 0 {
+			delete(w.problems, path)
+		} else {
+			w.problems[path] = problems
+		}
+	}
+	return added, removed, nil
+}
+
+// Run scans the databases directory once and then every interval, an empty interval only scans at startup
+func (w *DatabaseWatcher) Run(interval string) {
+	if _, _, err := w.Reload(); err != nil {
+		databaseLog.Error("Failed to load databases", "error", err)
+	}
+	if interval == "" {
+		return
+	}
+
+	wait, err := time.ParseDuration(interval)
+	if err != nil {
+		databaseLog.Error("Invalid database watch interval", "interval", interval, "error", err)
+		return
+	}
+	for {
+		time.Sleep(wait)
+		if _, _, err := w.Reload(); err != nil {
+			databaseLog.Error("Failed to reload databases", "error", err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangedDatabases(t *testing.T) {
+	now := time.Now()
+	previous := map[string]time.Time{"uniref": now, "pdb": now}
+	current := map[string]time.Time{"pdb": now.Add(time.Second), "afdb": now, "bfd": now}
+
+	added, removed := changedDatabases(previous, current)
+	if !reflect.DeepEqual(added, []string{"afdb", "bfd"}) {
+		t.Errorf("unexpected added databases %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"uniref"}) {
+		t.Errorf("unexpected removed databases %v", removed)
+	}
+
+	added, _ = changedDatabases(nil, current)
+	if len(added) != 3 {
+		t.Errorf("expected all databases to be added, got %v", added)
+	}
+}
+
+func TestDatabasesETag(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pdb.params"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := databaseSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := snapshot["pdb"]; !ok || len(snapshot) != 1 {
+		t.Fatalf("unexpected snapshot %v", snapshot)
+	}
+	etag := databasesETag(snapshot)
+
+	if err := os.WriteFile(filepath.Join(dir, "afdb.params"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err = databaseSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if databasesETag(snapshot) == etag {
+		t.Error("expected the etag to change after adding a database")
+	}
+	if databasesETag(snapshot) != databasesETag(snapshot) {
+		t.Error("expected a stable etag")
+	}
+}
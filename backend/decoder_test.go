@@ -8,8 +8,8 @@ import (
 )
 
 func TestParseTSV(t *testing.T) {
-	tsvData := "job	MGYP003416764702.pdb.gz	74.000	27	7	0	1	27	1	27	0.999	3.179E-01	96	28	38	YCESCGVEIGIRRLEARPTADLCIDCK	YCESCGEEIGLKRLEARPVTTLCIRCK	10.123,0.971,0.677,-11.251,-7.304,12.998	YCESCGEEIGLKRLEARPVTTLCIRCKEDQERVERDFG	9606	Homo sapiens\n" +
-		"job	MGYP001608907542.pdb.gz	81.400	27	5	0	1	27	35	61	0.998	4.008E-01	93	28	73	YCESCGVEIGIRRLEARPTADLCIDCK	FCDSCGVEIGLKRLEARPTAPLCIDCK	21.560,5.233,-22.520,14.412,17.200,4.409	ETDIALELRNRDRERKLIKKIDETLGRIDGDEYGFCDSCGVEIGLKRLEARPTAPLCIDCKTLEEVREKQVAK	9606	Homo sapiens\n"
+	tsvData := "job	MGYP003416764702.pdb.gz	74.000	27	7	0	1	27	1	27	0.999	3.179E-01	96	28	38	YCESCGVEIGIRRLEARPTADLCIDCK	YCESCGEEIGLKRLEARPVTTLCIRCK	10.123,0.971,0.677,-11.251,-7.304,12.998	YCESCGEEIGLKRLEARPVTTLCIRCKEDQERVERDFG	9606	Homo sapiens	Eukaryota;Homo sapiens	0.51230	0.993,0.102,-0.058,-0.098,0.993,0.061,0.064,-0.055,0.996	1.204,-3.771,0.420\n" +
+		"job	MGYP001608907542.pdb.gz	81.400	27	5	0	1	27	35	61	0.998	4.008E-01	93	28	73	YCESCGVEIGIRRLEARPTADLCIDCK	FCDSCGVEIGLKRLEARPTAPLCIDCK	21.560,5.233,-22.520,14.412,17.200,4.409	ETDIALELRNRDRERKLIKKIDETLGRIDGDEYGFCDSCGVEIGLKRLEARPTAPLCIDCKTLEEVREKQVAK	9606	Homo sapiens	Eukaryota;Homo sapiens	0.48710	0.871,-0.420,0.254,0.451,0.891,-0.046,-0.207,0.155,0.966	-12.330,8.025,4.113\n"
 
 	reader := strings.NewReader(tsvData)
 
@@ -54,6 +54,10 @@ func TestParseTSV(t *testing.T) {
 			TargetSeq:     "YCESCGEEIGLKRLEARPVTTLCIRCKEDQERVERDFG",
 			TaxonId:       "9606",
 			TaxonName:     "Homo sapiens",
+			TaxonLineage:  "Eukaryota;Homo sapiens",
+			AlnTmScore:    0.5123,
+			U:             "0.993,0.102,-0.058,-0.098,0.993,0.061,0.064,-0.055,0.996",
+			T:             "1.204,-3.771,0.420",
 		},
 		{
 			MarshalFormat: MarshalDefault,
@@ -78,6 +82,10 @@ func TestParseTSV(t *testing.T) {
 			TargetSeq:     "ETDIALELRNRDRERKLIKKIDETLGRIDGDEYGFCDSCGVEIGLKRLEARPTAPLCIDCKTLEEVREKQVAK",
 			TaxonId:       "9606",
 			TaxonName:     "Homo sapiens",
+			TaxonLineage:  "Eukaryota;Homo sapiens",
+			AlnTmScore:    0.4871,
+			U:             "0.871,-0.420,0.254,0.451,0.891,-0.046,-0.207,0.155,0.966",
+			T:             "-12.330,8.025,4.113",
 		},
 	}
 
@@ -149,3 +157,35 @@ func TestParseTSVMarshalTargetOnly(t *testing.T) {
 		t.Errorf("Parsed results do not match expected data. Got %+v, want %+v", output, expected)
 	}
 }
+
+func TestParseTSVEmptyColumns(t *testing.T) {
+	// results written before the superposition columns existed and structure searches of a database without taxonomy
+	tsvData := []string{
+		"job	MGYP003416764702.pdb.gz	74.000	27	7	0	1	27	1	27	0.999	3.179E-01	96	28	38	YCE	YCE	10.123,0.971,0.677	YCESCG	9606	Homo sapiens	Eukaryota;Homo sapiens\n",
+		"job	MGYP001608907542.pdb.gz	81.400	27	5	0	1	27	35	61	0.998	4.008E-01	93	28	73	FCD	FCD	21.560,5.233,-22.520	FCDSCG	-	-	-	0.48710	0.871,-0.420,0.254,0.451,0.891,-0.046,-0.207,0.155,0.966	-12.330,8.025,4.113\n",
+	}
+
+	entries := make([]FoldseekAlignmentEntry, 0)
+	for _, data := range tsvData {
+		var aln FoldseekAlignmentEntry
+		parser := NewTsvParser(strings.NewReader(data), &aln)
+		if _, err := parser.Next(); err != nil {
+			t.Fatalf("Failed to parse record: %s", err)
+		}
+		clearEmptyColumns(&aln)
+		entries = append(entries, aln)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].TaxonLineage != "Eukaryota;Homo sapiens" || entries[0].AlnTmScore != 0 || entries[0].U != "" {
+		t.Errorf("unexpected entry of the old layout %+v", entries[0])
+	}
+	if entries[1].TaxonId != "" || entries[1].TaxonName != "" || entries[1].AlnTmScore != 0.4871 || entries[1].T != "-12.330,8.025,4.113" {
+		t.Errorf("unexpected entry without taxonomy %+v", entries[1])
+	}
+	if _, err := json.Marshal(entries); err != nil {
+		t.Errorf("failed to marshal entries: %s", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseByteSize parses sizes like 500M or 20G, suffixes are powers of 1024
+func parseByteSize(size string) (uint64, error) {
+	size = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	multiplier := uint64(1)
+	if len(size) > 0 {
+		switch size[len(size)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			size = size[:len(size)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil || value < 0 {
+		return 0, errors.New("invalid size: " + size)
+	}
+	return uint64(value * float64(multiplier)), nil
+}
+
+type diskLimit struct {
+	name    string
+	path    string
+	minFree uint64
+}
+
+// DiskWatchdog periodically checks the free space of the results and temporary volumes.
+// While any of them is below its threshold, workers stop dequeueing and the server rejects new jobs.
+type DiskWatchdog struct {
+	limits   []diskLimit
+	interval time.Duration
+	alert    []string
+	sender   string
+	mailer   MailTransport
+	alerter  *Alerter
+
+	mu      sync.RWMutex
+	message string
+}
+
+// MakeDiskWatchdog returns nil if no thresholds are configured, a nil watchdog never reports low disk space
+func MakeDiskWatchdog(config ConfigRoot) (*DiskWatchdog, error) {
+	if config.DiskSpace == nil {
+		return nil, nil
+	}
+
+	interval, err := time.ParseDuration(config.DiskSpace.Interval)
+	if err != nil {
+		return nil, errors.New("invalid diskspace.interval: " + err.Error())
+	}
+
+	tempPath := config.Paths.Results
+	if config.Paths.Temporary != "" {
+		tempPath = config.Paths.Temporary
+	}
+
+	var limits []diskLimit
+	for _, limit := range []struct {
+		name string
+		path string
+		size string
+	}{
+		{"results", config.Paths.Results, config.DiskSpace.Results},
+		{"temporary", tempPath, config.DiskSpace.Temporary},
+	} {
+		if limit.size == "" {
+			continue
+		}
+		minFree, err := parseByteSize(limit.size)
+		if err != nil {
+			return nil, errors.New("invalid diskspace." + limit.name + ": " + err.Error())
+		}
+		limits = append(limits, diskLimit{limit.name, limit.path, minFree})
+	}
+
+	mailer := MailTransport(NullTransport{})
+	if config.Mail.Mailer != nil {
+		mailer = config.Mail.Mailer.GetTransport()
+	}
+
+	d := &DiskWatchdog{
+		limits:   limits,
+		interval: interval,
+		alert:    config.DiskSpace.Alert,
+		sender:   config.Mail.Sender,
+		mailer:   mailer,
+		alerter:  NewAlerter(config),
+	}
+	d.check()
+	return d, nil
+}
+
+func formatByteSize(size uint64) string {
+	return fmt.Sprintf("%.1fG", float64(size)/(1<<30))
+}
+
+func (d *DiskWatchdog) check() {
+	message := ""
+	for _, limit := range d.limits {
+		free, err := freeDiskSpace(limit.path)
+		if err != nil {
+			alertLog.Error("Failed to check free disk space", "path", limit.path, "error", err)
+			continue
+		}
+		if free < limit.minFree {
+			message = fmt.Sprintf("%s volume %s has %s free, below the minimum of %s", limit.name, limit.path, formatByteSize(free), formatByteSize(limit.minFree))
+			break
+		}
+	}
+
+	d.mu.Lock()
+	previous := d.message
+	d.message = message
+	d.mu.Unlock()
+
+	if previous == "" && message != "" {
+		d.notify("Low disk space", "New jobs are not accepted and queued jobs are not started: "+message)
+	} else if previous != "" && message == "" {
+		d.notify("Disk space recovered", "Jobs are accepted and started again")
+	}
+}
+
+func (d *DiskWatchdog) notify(subject string, body string) {
+	d.alerter.Alert(subject, body)
+	host, _ := os.Hostname()
+	for _, recipient := range d.alert {
+		err := d.mailer.Send(Mail{Sender: d.sender, Recipient: recipient, Subject: subject + " on " + host, Body: body})
+		if err != nil {
+			alertLog.Warn(err.Error())
+		}
+	}
+}
+
+func (d *DiskWatchdog) Run() {
+	if d == nil {
+		return
+	}
+	for {
+		time.Sleep(d.interval)
+		d.check()
+	}
+}
+
+// Err returns an error describing the volume that is low on space, or nil if there is enough space
+func (d *DiskWatchdog) Err() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.message == "" {
+		return nil
+	}
+	return errors.New(d.message)
+}
+
+// Handler rejects job submissions while disk space is low
+func (d *DiskWatchdog) Handler(next http.HandlerFunc) http.HandlerFunc {
+	if d == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := d.Err(); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(d.interval.Seconds())))
+			http.Error(w, "The server is low on disk space and does not accept new jobs, please try again later", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, req)
+	}
+}
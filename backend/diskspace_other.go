@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "errors"
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("disk space monitoring is only supported on linux and macOS")
+}
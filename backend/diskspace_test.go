@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+		err   bool
+	}{
+		{"1024", 1024, false},
+		{"10K", 10 << 10, false},
+		{"500M", 500 << 20, false},
+		{"20G", 20 << 30, false},
+		{"1.5t", 3 << 39, false},
+		{"2GB", 2 << 30, false},
+		{"", 0, true},
+		{"G", 0, true},
+		{"-1G", 0, true},
+		{"ten", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseByteSize(test.input)
+		if (err != nil) != test.err {
+			t.Errorf("parseByteSize(%q) error = %v, want error %v", test.input, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DatabaseUsage is the size of all files of a database
+type DatabaseUsage struct {
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+}
+
+// DiskUsage is the space used by the databases, job results and scratch directories
+type DiskUsage struct {
+	Databases      []DatabaseUsage `json:"databases"`
+	DatabasesTotal uint64          `json:"databasesTotal"`
+	// files in the databases directory that belong to no database, e.g. staging directories
+	DatabasesOther uint64 `json:"databasesOther"`
+	Results        uint64 `json:"results"`
+	Jobs           int    `json:"jobs"`
+	Temporary      uint64 `json:"temporary"`
+	Quota          uint64 `json:"quota,omitempty"`
+	Updated        string `json:"updated"`
+}
+
+// directorySize sums up the size of all files below path, files removed while walking are ignored
+func directorySize(path string) uint64 {
+	var size uint64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// databaseUsage attributes every entry of the databases directory to the database with the longest matching path
+func databaseUsage(basepath string) ([]DatabaseUsage, uint64, error) {
+	databases, err := Databases(basepath, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, err := os.ReadDir(basepath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sizes := make(map[string]uint64, len(databases))
+	for _, db := range databases {
+		sizes[db.Path] = 0
+	}
+	var other uint64
+	// the releases of swapped databases belong to them, their files are links into them
+	if releases, err := os.ReadDir(filepath.Join(basepath, databaseReleaseDir)); err == nil {
+		for _, entry := range releases {
+			size := directorySize(filepath.Join(basepath, databaseReleaseDir, entry.Name()))
+			if _, ok := sizes[entry.Name()]; ok {
+				sizes[entry.Name()] += size
+			} else {
+				other += size
+			}
+		}
+	}
+	for _, entry := range entries {
+		if entry.Name() == databaseReleaseDir {
+			continue
+		}
+		var size uint64
+		if entry.IsDir() {
+			size = directorySize(filepath.Join(basepath, entry.Name()))
+		} else if info, err := entry.Info(); err == nil {
+			size = uint64(info.Size())
+		}
+
+		owner := ""
+		for path := range sizes {
+			if len(path) > len(owner) && isDatabaseFile(entry.Name(), path) {
+				owner = path
+			}
+		}
+		if owner == "" {
+			other += size
+		} else {
+			sizes[owner] += size
+		}
+	}
+
+	usage := make([]DatabaseUsage, 0, len(sizes))
+	for path, size := range sizes {
+		usage = append(usage, DatabaseUsage{path, size})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Path < usage[j].Path
+	})
+	return usage, other, nil
+}
+
+const diskUsageInterval = 10 * time.Minute
+
+// DiskUsageTracker periodically measures the disk usage, walking the results takes too long to do it per request
+type DiskUsageTracker struct {
+	config ConfigRoot
+	quota  uint64
+	mu     sync.RWMutex
+	usage  DiskUsage
+}
+
+func NewDiskUsageTracker(config ConfigRoot) (*DiskUsageTracker, error) {
+	var quota uint64
+	if config.DiskSpace != nil && config.DiskSpace.DatabaseQuota != "" {
+		var err error
+		quota, err = parseByteSize(config.DiskSpace.DatabaseQuota)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DiskUsageTracker{config, quota, sync.RWMutex{}, DiskUsage{}}, nil
+}
+
+func (t *DiskUsageTracker) Refresh() error {
+	databases, other, err := databaseUsage(t.config.Paths.Databases)
+	if err != nil {
+		return err
+	}
+	total := other
+	for _, db := range databases {
+		total += db.Size
+	}
+	usage := DiskUsage{databases, total, other, 0, 0, 0, t.quota, time.Now().UTC().Format(time.RFC3339)}
+
+	if entries, err := os.ReadDir(t.config.Paths.Results); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				usage.Jobs++
+			}
+		}
+	}
+	usage.Results = directorySize(t.config.Paths.Results)
+	if t.config.Paths.Temporary != "" {
+		usage.Temporary = directorySize(t.config.Paths.Temporary)
+	}
+
+	t.mu.Lock()
+	t.usage = usage
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *DiskUsageTracker) Run(interval time.Duration) {
+	for {
+		if err := t.Refresh(); err != nil {
+			cleanupLog.Error("Failed to measure disk usage", "error", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Usage returns the last measurement
+func (t *DiskUsageTracker) Usage() DiskUsage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.usage
+}
+
+// QuotaExceeded measures the databases again, so a database installed since the last refresh is taken into account
+func (t *DiskUsageTracker) QuotaExceeded() (bool, error) {
+	if t.quota == 0 {
+		return false, nil
+	}
+	databases, other, err := databaseUsage(t.config.Paths.Databases)
+	if err != nil {
+		return false, err
+	}
+	total := other
+	for _, db := range databases {
+		total += db.Size
+	}
+	return total >= t.quota, nil
+}
+
+// QuotaLimited rejects requests that install a database while the databases exceed their quota
+func (t *DiskUsageTracker) QuotaLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		exceeded, err := t.QuotaExceeded()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if exceeded {
+			http.Error(w, "Database quota of "+formatByteSize(t.quota)+" exceeded, remove databases first", http.StatusInsufficientStorage)
+			return
+		}
+		next(w, req)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDatabaseUsage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pdb.params":        `{"id":"p1","name":"pdb","path":"pdb"}`,
+		"pdb":               "AAAA",
+		"pdb_seqres.params": `{"id":"p2","name":"pdb_seqres","path":"pdb_seqres"}`,
+		"pdb_seqres":        "CCCCCCCC",
+		"notes.txt":         "xx",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, other, err := databaseUsage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 || usage[0].Path != "pdb" || usage[1].Path != "pdb_seqres" {
+		t.Fatalf("unexpected usage %v", usage)
+	}
+	if usage[0].Size != uint64(len(files["pdb"])+len(files["pdb.params"])) {
+		t.Errorf("files of pdb_seqres were counted for pdb: %d", usage[0].Size)
+	}
+	if other != 2 {
+		t.Errorf("expected 2 bytes outside of databases, got %d", other)
+	}
+
+	var config ConfigRoot
+	config.Paths.Databases = dir
+	config.DiskSpace = &ConfigDiskSpace{DatabaseQuota: "50"}
+	tracker, err := NewDiskUsageTracker(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exceeded, err := tracker.QuotaExceeded(); err != nil || !exceeded {
+		t.Errorf("expected the quota to be exceeded (%v)", err)
+	}
+}
+
+func TestWriteMetric(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetric(&buf, "mmseqs_database_size_bytes", map[string]string{"database": `a"b`, "app": "mmseqs"}, 1024)
+	expected := `mmseqs_database_size_bytes{app="mmseqs",database="a\"b"} 1024`
+	if strings.TrimSpace(buf.String()) != expected {
+		t.Errorf("unexpected sample %s", buf.String())
+	}
+}
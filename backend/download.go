@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDownloadConnections = 4
+	defaultDownloadChunkSize   = 64 * 1024 * 1024
+	defaultDownloadRetries     = 5
+	downloadProgressInterval   = 5 * time.Second
+)
+
+type downloadOptions struct {
+	connections int
+	chunkSize   int64
+	retries     int
+	limiter     *bandwidthLimiter
+	// receives progress lines in the format of the mmseqs progress bar, so they are picked up by parseProgress
+	progress io.Writer
+}
+
+func newDownloadOptions(config *ConfigDownload, progress io.Writer) (downloadOptions, error) {
+	options := downloadOptions{defaultDownloadConnections, defaultDownloadChunkSize, defaultDownloadRetries, nil, progress}
+	if config == nil {
+		return options, nil
+	}
+	if config.Connections > 0 {
+		options.connections = config.Connections
+	}
+	if config.Retries > 0 {
+		options.retries = config.Retries
+	}
+	if config.ChunkSize != "" {
+		size, err := parseByteSize(config.ChunkSize)
+		if err != nil {
+			return options, err
+		}
+		if size == 0 {
+			return options, errors.New("download chunk size has to be larger than 0")
+		}
+		options.chunkSize = int64(size)
+	}
+	if config.Bandwidth != "" {
+		rate, err := parseByteSize(config.Bandwidth)
+		if err != nil {
+			return options, err
+		}
+		if rate > 0 {
+			options.limiter = &bandwidthLimiter{rate: float64(rate)}
+		}
+	}
+	return options, nil
+}
+
+// bandwidthLimiter limits the bytes per second summed over all connections
+type bandwidthLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+// wait reserves the time to transfer n bytes and blocks until the reservation starts
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// downloadState records the finished chunks next to a partial download, so an interrupted download continues where it stopped
+type downloadState struct {
+	Source    string `json:"source"`
+	Size      int64  `json:"size"`
+	ETag      string `json:"etag"`
+	ChunkSize int64  `json:"chunkSize"`
+	Done      []bool `json:"done"`
+}
+
+func readDownloadState(path string) (downloadState, error) {
+	var state downloadState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func (s downloadState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".part", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".part", path)
+}
+
+// retryableError marks failures that are likely to go away, e.g. dropped connections or overloaded servers
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func statusError(source string, res *http.Response) error {
+	err := errors.New("downloading " + source + " failed: " + res.Status)
+	if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusRequestTimeout {
+		return &retryableError{err}
+	}
+	return err
+}
+
+// withRetries calls fn until it succeeds, fails with an error that is not retryable or runs out of retries
+func withRetries(ctx context.Context, retries int, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= retries {
+			return err
+		}
+		backoff := time.Duration(1<<attempt) * time.Second
+		if backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// probeDownload requests the first byte to find out if the server supports range requests and how large the file is
+func probeDownload(ctx context.Context, source string) (int64, string, bool, error) {
+	req, err := newSourceRequest(ctx, "GET", source)
+	if err != nil {
+		return 0, "", false, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", false, &retryableError{err}
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-0/1234
+		_, total, found := strings.Cut(res.Header.Get("Content-Range"), "/")
+		size, err := strconv.ParseInt(total, 10, 64)
+		if !found || err != nil {
+			return 0, "", false, nil
+		}
+		return size, res.Header.Get("ETag"), true, nil
+	case http.StatusOK:
+		return res.ContentLength, res.Header.Get("ETag"), false, nil
+	}
+	return 0, "", false, statusError(source, res)
+}
+
+// copyAt writes the body of a response to the file starting at offset
+func copyAt(ctx context.Context, f *os.File, offset int64, r io.Reader, options downloadOptions, done *int64) (int64, error) {
+	buffer := make([]byte, 256*1024)
+	var written int64
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			if err := options.limiter.wait(ctx, n); err != nil {
+				return written, err
+			}
+			if _, err := f.WriteAt(buffer[:n], offset+written); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			atomic.AddInt64(done, int64(n))
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, &retryableError{err}
+		}
+	}
+}
+
+// fetchRange downloads the bytes from start to end (inclusive), If-Range makes sure all chunks come from the same file
+func fetchRange(ctx context.Context, f *os.File, source string, etag string, start int64, end int64, options downloadOptions, done *int64) error {
+	req, err := newSourceRequest(ctx, "GET", source)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+	if etag != "" {
+		req.Header.Set("If-Range", etag)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &retryableError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return errors.New(source + " changed during the download")
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		return statusError(source, res)
+	}
+	written, err := copyAt(ctx, f, start, res.Body, options, done)
+	if err == nil && written != end-start+1 {
+		err = &retryableError{fmt.Errorf("received %d of %d bytes", written, end-start+1)}
+	}
+	if err != nil {
+		// the next attempt downloads the whole chunk again
+		atomic.AddInt64(done, -written)
+	}
+	return err
+}
+
+// reportProgress writes a progress bar until stop is closed
+func reportProgress(w io.Writer, total int64, done *int64, stop chan struct{}) {
+	if w == nil || total <= 0 {
+		return
+	}
+	ticker := time.NewTicker(downloadProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := atomic.LoadInt64(done)
+			percent := float64(current) / float64(total) * 100
+			width := 65
+			filled := int(percent / 100 * float64(width))
+			bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+			fmt.Fprintf(w, "[%s] %.2f%% %s/%s\n", bar, percent, formatByteSize(uint64(current)), formatByteSize(uint64(total)))
+		}
+	}
+}
+
+// downloadFile fetches source to path over several connections, servers that do not support range requests
+// are downloaded over a single connection that starts over after a failure
+func downloadFile(ctx context.Context, source string, path string, options downloadOptions) error {
+	var size int64
+	var etag string
+	var ranges bool
+	err := withRetries(ctx, options.retries, func() error {
+		var err error
+		size, etag, ranges, err = probeDownload(ctx, source)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var done int64
+	stop := make(chan struct{})
+	defer close(stop)
+	go reportProgress(options.progress, size, &done, stop)
+
+	if !ranges || size <= 0 {
+		os.Remove(path + ".state")
+		return withRetries(ctx, options.retries, func() error {
+			atomic.StoreInt64(&done, 0)
+			return fetchWhole(ctx, source, path, options, &done)
+		})
+	}
+
+	statePath := path + ".state"
+	chunks := int((size + options.chunkSize - 1) / options.chunkSize)
+	state, err := readDownloadState(statePath)
+	if err != nil || state.Source != source || state.Size != size || state.ETag != etag || state.ChunkSize != options.chunkSize || len(state.Done) != chunks || !fileExists(path) {
+		state = downloadState{source, size, etag, options.chunkSize, make([]bool, chunks)}
+		os.Remove(path)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+
+	pending := make(chan int, chunks)
+	for i, finished := range state.Done {
+		if finished {
+			atomic.AddInt64(&done, chunkEnd(i, options.chunkSize, size)-int64(i)*options.chunkSize+1)
+		} else {
+			pending <- i
+		}
+	}
+	close(pending)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for c := 0; c < options.connections; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				start := int64(i) * options.chunkSize
+				err := withRetries(ctx, options.retries, func() error {
+					return fetchRange(ctx, f, source, etag, start, chunkEnd(i, options.chunkSize, size), options, &done)
+				})
+				mu.Lock()
+				if err == nil {
+					state.Done[i] = true
+					err = state.save(statePath)
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return os.Remove(statePath)
+}
+
+func chunkEnd(i int, chunkSize int64, size int64) int64 {
+	end := int64(i+1)*chunkSize - 1
+	if end >= size {
+		end = size - 1
+	}
+	return end
+}
+
+func fetchWhole(ctx context.Context, source string, path string, options downloadOptions, done *int64) error {
+	req, err := newSourceRequest(ctx, "GET", source)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &retryableError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return statusError(source, res)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	written, err := copyAt(ctx, f, 0, res.Body, options, done)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && res.ContentLength > 0 && written != res.ContentLength {
+		err = &retryableError{fmt.Errorf("received %d of %d bytes", written, res.ContentLength)}
+	}
+	return err
+}
+
+// downloadDatabaseSource fetches the input file of a database index job,
+// it returns the hex encoded SHA256 of the downloaded (still compressed) file.
+// verify is called with it before anything is written to path, so an unverified file never takes the place of the input.
+// The partial download is kept as path.download, so a failed job continues the download when it is retried.
+func downloadDatabaseSource(ctx context.Context, config ConfigRoot, source string, path string, progress io.Writer, verify func(digest string) error) (string, error) {
+	options, err := newDownloadOptions(config.Worker.Download, progress)
+	if err != nil {
+		return "", err
+	}
+	raw := path + ".download"
+	if err := downloadFile(ctx, source, raw, options); err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(raw)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	// a complete but broken or unverified download can not be resumed
+	defer os.Remove(raw)
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	if err := verify(digest); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	u, _ := url.Parse(source)
+	if err := writeDatabaseSource(path, f, strings.HasSuffix(u.Path, ".gz")); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadFile(t *testing.T) {
+	content := []byte(strings.Repeat(">seq\nMKVLAAGIVG\n", 100))
+	var requests, failed int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// the first request for the second chunk fails
+		if req.Header.Get("Range") == "bytes=256-511" && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, req, "db.fasta", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var progress bytes.Buffer
+	options := downloadOptions{2, 256, 2, nil, &progress}
+	path := filepath.Join(dir, "db.fasta")
+	if err := downloadFile(context.Background(), srv.URL+"/db.fasta", path, options); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("downloaded file differs")
+	}
+	if fileExists(path + ".state") {
+		t.Error("expected state to be removed after the download")
+	}
+
+	// finished chunks of an interrupted download are not fetched again
+	chunks := (len(content) + 255) / 256
+	state := downloadState{srv.URL + "/db.fasta", int64(len(content)), `"v1"`, 256, make([]bool, chunks)}
+	for i := 0; i < chunks-1; i++ {
+		state.Done[i] = true
+	}
+	if err := state.save(path + ".state"); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&requests, 0)
+	if err := downloadFile(context.Background(), srv.URL+"/db.fasta", path, options); err != nil {
+		t.Fatal(err)
+	}
+	// the probe and the last chunk
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("expected 2 requests to resume the download, got %d", got)
+	}
+
+	sum := sha256.Sum256(content)
+	var config ConfigRoot
+	rejected := errors.New("rejected")
+	if _, err := downloadDatabaseSource(context.Background(), config, srv.URL+"/db.fasta", filepath.Join(dir, "other.fasta"), nil, func(string) error { return rejected }); err != rejected {
+		t.Fatalf("expected the verification error, got %v", err)
+	}
+	if fileExists(filepath.Join(dir, "other.fasta")) || fileExists(filepath.Join(dir, "other.fasta.download")) {
+		t.Error("an unverified download should not be kept")
+	}
+	digest, err := downloadDatabaseSource(context.Background(), config, srv.URL+"/db.fasta", filepath.Join(dir, "other.fasta"), nil, func(string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != hex.EncodeToString(sum[:]) {
+		t.Errorf("digest = %s, expected %s", digest, hex.EncodeToString(sum[:]))
+	}
+	if fileExists(filepath.Join(dir, "other.fasta.download")) {
+		t.Error("expected the raw download to be removed")
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	limiter := &bandwidthLimiter{rate: 1000}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.wait(context.Background(), 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the third reservation starts after 200 bytes were transferred
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the limiter to wait, took %s", elapsed)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+const defaultVerificationExpiry = 24 * time.Hour
+
+const defaultVerificationSubject = "Confirm notifications for {{.Address}}"
+const defaultVerificationBody = "Notifications of search jobs were requested for {{.Address}}.\nOpen {{.VerifyURL}} to receive them, ignore this mail otherwise."
+
+// EmailVerifier tells if notifications may be sent to an address
+type EmailVerifier interface {
+	Verified(address string) (bool, error)
+}
+
+// verificationStore keeps pending tokens and verified addresses, addresses and tokens are only stored hashed
+type verificationStore interface {
+	EmailVerifier
+	// Request returns a new token for an address, or an empty one if a token is already pending
+	Request(address string, expiry time.Duration) (string, error)
+	// Confirm marks the address of a token as verified, false for unknown or expired tokens
+	Confirm(token string, remember time.Duration) (bool, error)
+}
+
+func addressHash(address string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(address))))
+	return hex.EncodeToString(hash[:])
+}
+
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func newVerificationToken() string {
+	var token [20]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token[:])
+}
+
+type redisVerificationStore struct {
+	client *redis.Client
+}
+
+func (s redisVerificationStore) Verified(address string) (bool, error) {
+	n, err := s.client.Exists("mmseqs:verified:" + addressHash(address)).Result()
+	return n > 0, err
+}
+
+func (s redisVerificationStore) Request(address string, expiry time.Duration) (string, error) {
+	token := newVerificationToken()
+	hash := addressHash(address)
+	ok, err := s.client.SetNX("mmseqs:verify:address:"+hash, tokenHash(token), expiry).Result()
+	if err != nil || !ok {
+		return "", err
+	}
+	return token, s.client.Set("mmseqs:verify:token:"+tokenHash(token), hash, expiry).Err()
+}
+
+func (s redisVerificationStore) Confirm(token string, remember time.Duration) (bool, error) {
+	key := "mmseqs:verify:token:" + tokenHash(token)
+	hash, err := s.client.Get(key).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	_, err = s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(key, "mmseqs:verify:address:"+hash)
+		pipe.Set("mmseqs:verified:"+hash, "1", remember)
+		return nil
+	})
+	return err == nil, err
+}
+
+type pendingVerification struct {
+	address string
+	expires time.Time
+}
+
+// memoryVerificationStore is used without redis, server and workers of a local job system share it
+type memoryVerificationStore struct {
+	mu sync.Mutex
+	// the pending token hash of an address hash
+	pending map[string]string
+	// the address hash of a token hash
+	tokens map[string]pendingVerification
+	// a zero time never expires
+	verified map[string]time.Time
+}
+
+var localVerificationStore = &memoryVerificationStore{pending: make(map[string]string), tokens: make(map[string]pendingVerification), verified: make(map[string]time.Time)}
+
+func (s *memoryVerificationStore) Verified(address string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires, ok := s.verified[addressHash(address)]
+	return ok && (expires.IsZero() || time.Now().Before(expires)), nil
+}
+
+// prune removes expired tokens and verifications, the caller holds mu
+func (s *memoryVerificationStore) prune(now time.Time) {
+	for token, pending := range s.tokens {
+		if now.After(pending.expires) {
+			delete(s.tokens, token)
+			delete(s.pending, pending.address)
+		}
+	}
+	for hash, expires := range s.verified {
+		if !expires.IsZero() && now.After(expires) {
+			delete(s.verified, hash)
+		}
+	}
+}
+
+func (s *memoryVerificationStore) Request(address string, expiry time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.prune(now)
+	hash := addressHash(address)
+	if _, ok := s.pending[hash]; ok {
+		return "", nil
+	}
+	token := newVerificationToken()
+	s.pending[hash] = tokenHash(token)
+	s.tokens[tokenHash(token)] = pendingVerification{hash, now.Add(expiry)}
+	return token, nil
+}
+
+func (s *memoryVerificationStore) Confirm(token string, remember time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, ok := s.tokens[tokenHash(token)]
+	if !ok {
+		return false, nil
+	}
+	delete(s.tokens, tokenHash(token))
+	delete(s.pending, pending.address)
+	if time.Now().After(pending.expires) {
+		return false, nil
+	}
+	var verified time.Time
+	if remember > 0 {
+		verified = time.Now().Add(remember)
+	}
+	s.verified[pending.address] = verified
+	return true, nil
+}
+
+func newVerificationStore(jobsystem JobSystem) verificationStore {
+	if redisJobs, ok := jobsystem.(*RedisJobSystem); ok {
+		return redisVerificationStore{redisJobs.Client}
+	}
+	return localVerificationStore
+}
+
+// jobVerifier is the verifier workers check before mailing, remote workers ask the server
+func jobVerifier(jobsystem JobSystem) EmailVerifier {
+	if remote, ok := jobsystem.(*RemoteJobSystem); ok {
+		return remote
+	}
+	return newVerificationStore(jobsystem)
+}
+
+// verifiedTransport drops mails to addresses that were not verified
+type verifiedTransport struct {
+	MailTransport
+	verifier EmailVerifier
+}
+
+func (t verifiedTransport) Send(mail Mail) error {
+	ok, err := t.verifier.Verified(mail.Recipient)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		notifyLog.Info("Not sending mail to unverified address")
+		return errDeliverySkipped
+	}
+	return t.MailTransport.Send(mail)
+}
+
+// VerificationData are the fields of the verification mail template
+type VerificationData struct {
+	Address   string
+	VerifyURL string
+}
+
+func parseVerificationDurations(config *ConfigMailVerification) (time.Duration, time.Duration, error) {
+	expiry := defaultVerificationExpiry
+	var remember time.Duration
+	var err error
+	if config.Expires != "" {
+		if expiry, err = time.ParseDuration(config.Expires); err != nil {
+			return 0, 0, err
+		}
+	}
+	if config.Remember != "" {
+		if remember, err = time.ParseDuration(config.Remember); err != nil {
+			return 0, 0, err
+		}
+	}
+	return expiry, remember, nil
+}
+
+// RequestVerification mails a verification link to an address the first time it is used and while it is not verified
+func RequestVerification(config ConfigMail, store verificationStore, mailer MailTransport, address string) error {
+	if config.Verification == nil || address == "" {
+		return nil
+	}
+	if ok, err := store.Verified(address); err != nil || ok {
+		return err
+	}
+	expiry, _, err := parseVerificationDurations(config.Verification)
+	if err != nil {
+		return err
+	}
+	token, err := store.Request(address, expiry)
+	if err != nil || token == "" {
+		return err
+	}
+	template := config.Verification.Template
+	if template.Subject == "" {
+		template.Subject = defaultVerificationSubject
+	}
+	if template.Body == "" && template.BodyFile == "" {
+		template.Body = defaultVerificationBody
+	}
+	mail, err := RenderMail(template, VerificationData{address, strings.TrimSuffix(config.Verification.Url, "/") + "/notify/verify/" + url.PathEscape(token)})
+	if err != nil {
+		return err
+	}
+	mail.Sender = config.Sender
+	mail.Recipient = address
+	return mailer.Send(mail)
+}
+
+// confirmPage asks to confirm a link from a mail with a POST to the same address,
+// so that mail scanners opening the link do not confirm it. A non-empty field is posted as field=1.
+func confirmPage(w http.ResponseWriter, title string, button string, field string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	confirmTemplate.Execute(w, struct{ Title, Button, Field string }{title, button, field})
+}
+
+var confirmTemplate = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body><form method="post"><p>{{.Title}}</p>{{if .Field}}<input type="hidden" name="{{.Field}}" value="1">{{end}}<button type="submit">{{.Button}}</button></form></body></html>
+`))
+
+// verifyHandler shows the confirmation page of a verification link on GET and confirms its token on POST
+func verifyHandler(config ConfigMail, store verificationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			confirmPage(w, "Receive notifications of your search jobs?", "Enable notifications", "")
+			return
+		}
+		_, remember, err := parseVerificationDurations(config.Verification)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ok, err := store.Confirm(mux.Vars(req)["token"], remember)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Notifications are enabled.\n"))
+	}
+}
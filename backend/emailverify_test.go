@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestEmailVerification(t *testing.T) {
+	store := &memoryVerificationStore{pending: make(map[string]string), tokens: make(map[string]pendingVerification), verified: make(map[string]time.Time)}
+	config := ConfigMail{Sender: "mail@example.org", Verification: &ConfigMailVerification{Url: "https://search.example.org/api/"}}
+	mailer := &recordingTransport{}
+
+	for i := 0; i < 2; i++ {
+		if err := RequestVerification(config, store, mailer, "User@example.org"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(mailer.mails) != 1 {
+		t.Fatalf("expected a single verification mail, got %d", len(mailer.mails))
+	}
+	body := mailer.mails[0].Body
+	start := strings.Index(body, "https://search.example.org/api/notify/verify/")
+	if start < 0 {
+		t.Fatalf("missing verification link in %q", body)
+	}
+	link := strings.Fields(body[start:])[0]
+
+	jobs := &recordingTransport{}
+	verified := verifiedTransport{jobs, store}
+	verified.Send(Mail{Recipient: "user@example.org"})
+	if len(jobs.mails) != 0 {
+		t.Error("mail to an unverified address was sent")
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/notify/verify/{token}", verifyHandler(config, store))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", strings.TrimPrefix(link, "https://search.example.org/api"), nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `method="post"`) {
+		t.Fatalf("expected a confirmation page, got %d: %s", w.Code, w.Body.String())
+	}
+	verified.Send(Mail{Recipient: "user@example.org"})
+	if len(jobs.mails) != 0 {
+		t.Error("opening the link verified the address")
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", strings.TrimPrefix(link, "https://search.example.org/api"), nil))
+	if w.Code != 200 {
+		t.Fatalf("verification failed with %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/notify/verify/unknown", nil))
+	if w.Code != 400 {
+		t.Errorf("unknown token returned %d", w.Code)
+	}
+
+	verified.Send(Mail{Recipient: "user@example.org"})
+	if len(jobs.mails) != 1 {
+		t.Error("mail to a verified address was not sent")
+	}
+	if err := RequestVerification(config, store, mailer, "user@example.org"); err != nil || len(mailer.mails) != 1 {
+		t.Error("verified address was asked again")
+	}
+}
+
+func TestVerificationsExpire(t *testing.T) {
+	store := &memoryVerificationStore{pending: make(map[string]string), tokens: make(map[string]pendingVerification), verified: make(map[string]time.Time)}
+	token, _ := store.Request("a@example.org", time.Hour)
+	if ok, _ := store.Confirm(token, time.Nanosecond); !ok {
+		t.Fatal("token was not confirmed")
+	}
+	store.Request("b@example.org", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := store.Request("c@example.org", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.tokens) != 1 || len(store.pending) != 1 || len(store.verified) != 0 {
+		t.Errorf("expected expired entries to be removed %+v %+v %+v", store.tokens, store.pending, store.verified)
+	}
+	for hash := range store.pending {
+		if hash != addressHash("c@example.org") {
+			t.Errorf("unexpected pending address %s", hash)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrorCode classifies why a job failed, clients can decide on a retry by it
+type ErrorCode string
+
+const (
+	ErrorInvalidQuery    ErrorCode = "INVALID_QUERY"
+	ErrorDatabaseMissing ErrorCode = "DB_MISSING"
+	ErrorOutOfMemory     ErrorCode = "OOM"
+	ErrorTimeout         ErrorCode = "TIMEOUT"
+	ErrorInternal        ErrorCode = "INTERNAL"
+)
+
+// default message and remediation hint of each code
+var errorDescriptions = map[ErrorCode][2]string{
+	ErrorInvalidQuery:    {"The query could not be processed", "Check that the query is in a supported format and that the job parameters are valid."},
+	ErrorDatabaseMissing: {"A target database is not available", "Choose a different database or ask the server administrator to restore it."},
+	ErrorOutOfMemory:     {"The job ran out of memory", "Submit fewer queries per job or search smaller databases."},
+	ErrorTimeout:         {"The job did not finish in time", "Submit fewer queries per job or search fewer databases at once."},
+	ErrorInternal:        {"The job failed with an internal error", "Submit the job again later, contact the server administrator if it keeps failing."},
+}
+
+// JobError is the reason a job failed as it is reported with its ticket
+type JobError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Hint    string    `json:"hint,omitempty"`
+}
+
+// NewJobError uses the default message of the code for an empty message, unknown codes are internal errors
+func NewJobError(code ErrorCode, message string) *JobError {
+	description, ok := errorDescriptions[code]
+	if !ok {
+		code = ErrorInternal
+		description = errorDescriptions[code]
+	}
+	if message == "" {
+		message = description[0]
+	}
+	return &JobError{code, message, description[1]}
+}
+
+// UnmarshalJSON also reads the free text errors of older job files
+func (e *JobError) UnmarshalJSON(b []byte) error {
+	var message string
+	if err := json.Unmarshal(b, &message); err == nil {
+		*e = *NewJobError(ErrorInternal, message)
+		return nil
+	}
+	type jobError JobError
+	return json.Unmarshal(b, (*jobError)(e))
+}
+
+// errorStatus is the status of a job that failed with the code
+func errorStatus(code ErrorCode) Status {
+	if code == ErrorTimeout {
+		return StatusTimeout
+	}
+	return StatusError
+}
+
+// jobErrorCode classifies an error returned by ExecuteJob
+func jobErrorCode(config ConfigRoot, err error) ErrorCode {
+	var oomErr *JobOutOfMemoryError
+	var timeoutErr *JobTimeoutError
+	var invalidErr *JobInvalidError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &oomErr):
+		return ErrorOutOfMemory
+	case errors.As(err, &timeoutErr):
+		return ErrorTimeout
+	case errors.As(err, &invalidErr):
+		return ErrorInvalidQuery
+	case errors.As(err, &pathErr) && errors.Is(err, fs.ErrNotExist) && inDatabasesPath(config, pathErr.Path):
+		return ErrorDatabaseMissing
+	}
+	return ErrorInternal
+}
+
+// invalidInputLine matches the messages of mmseqs and foldseek about queries they cannot read
+var invalidInputLine = regexp.MustCompile(`(?i)(invalid (input|sequence|character|query|fasta|structure)|is not a valid|could not (parse|read) (the )?(input|query|structure)|no (sequences|structures|entries) (found|in)|(input|query) (file |database )?is empty|sequence is too long)`)
+
+// logTailSize is how much of the end of the job log is searched for input errors
+const logTailSize = 64 * 1024
+
+// jobFailure is the reported error of a failed job, with the message of err.
+// Execution errors are invalid queries if the tool rejected the input in the tail of the job log.
+func jobFailure(config ConfigRoot, err error, jobLog string) *JobError {
+	code := jobErrorCode(config, err)
+	var execErr *JobExecutionError
+	if code == ErrorInternal && errors.As(err, &execErr) {
+		if line := invalidInput(jobLog); line != "" {
+			return NewJobError(ErrorInvalidQuery, line)
+		}
+	}
+	switch code {
+	case ErrorOutOfMemory, ErrorTimeout:
+		return NewJobError(code, "")
+	}
+	return NewJobError(code, err.Error())
+}
+
+// invalidInput is the last line of the log tail that reports an invalid input
+func invalidInput(jobLog string) string {
+	file, err := os.Open(jobLog)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size() > logTailSize {
+		file.Seek(info.Size()-logTailSize, io.SeekStart)
+	}
+	line := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if invalidInputLine.MatchString(scanner.Text()) {
+			line = strings.TrimSpace(scanner.Text())
+		}
+	}
+	if len(line) > 200 {
+		line = line[:200]
+	}
+	return line
+}
+
+func inDatabasesPath(config ConfigRoot, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(config.Paths.Databases), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// jobNotComplete answers a result request of an unfinished job, failed jobs report their reason like the ticket
+func jobNotComplete(w http.ResponseWriter, ticket Ticket) {
+	if ticket.Error == nil {
+		http.Error(w, "Job is not complete", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(ticket)
+}
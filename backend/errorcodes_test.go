@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobErrorCodes(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	_, missing := os.Open(filepath.Join(config.Paths.Databases, "uniref.params"))
+	_, other := os.Open(filepath.Join(t.TempDir(), "job.fasta"))
+	for _, test := range []struct {
+		err  error
+		code ErrorCode
+	}{
+		{&JobExecutionError{&JobOutOfMemoryError{}}, ErrorOutOfMemory},
+		{&JobTimeoutError{}, ErrorTimeout},
+		{&JobInvalidError{}, ErrorInvalidQuery},
+		{&JobExecutionError{missing}, ErrorDatabaseMissing},
+		{&JobExecutionError{other}, ErrorInternal},
+	} {
+		if code := jobErrorCode(config, test.err); code != test.code {
+			t.Errorf("%s: expected %s, got %s", test.err, test.code, code)
+		}
+	}
+	if errorStatus(ErrorTimeout) != StatusTimeout || errorStatus(ErrorOutOfMemory) != StatusError {
+		t.Error("unexpected status of error codes")
+	}
+}
+
+func TestJobErrorJson(t *testing.T) {
+	var request JobRequest
+	if err := json.Unmarshal([]byte(`{"id":"a","status":"ERROR","type":"search","job":{},"error":"out of memory"}`), &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Error == nil || request.Error.Code != ErrorInternal || request.Error.Message != "out of memory" {
+		t.Errorf("free text error was not read: %+v", request.Error)
+	}
+
+	data, err := json.Marshal(Ticket{"a", StatusError, NewJobError("UNKNOWN", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ticket Ticket
+	if err := json.Unmarshal(data, &ticket); err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Error.Code != ErrorInternal || ticket.Error.Message == "" || ticket.Error.Hint == "" {
+		t.Errorf("unexpected error %+v", ticket.Error)
+	}
+}
+
+func TestJobFailureFromLog(t *testing.T) {
+	var config ConfigRoot
+	jobLog := filepath.Join(t.TempDir(), "job.log")
+	os.WriteFile(jobLog, []byte("createdb job.fasta input\nInvalid sequence in entry 3\nError: createdb died\n"), 0644)
+	if failure := jobFailure(config, &JobExecutionError{os.ErrInvalid}, jobLog); failure.Code != ErrorInvalidQuery || failure.Message != "Invalid sequence in entry 3" {
+		t.Errorf("rejected input was not reported: %+v", failure)
+	}
+	os.WriteFile(jobLog, []byte("Error: Segmentation fault\n"), 0644)
+	if failure := jobFailure(config, &JobExecutionError{os.ErrInvalid}, jobLog); failure.Code != ErrorInternal || failure.Message == "" {
+		t.Errorf("internal error lost its message: %+v", failure)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+type ExecutorType string
+
+const (
+	ExecutorLocal      ExecutorType = "local"
+	ExecutorContainer  ExecutorType = "container"
+	ExecutorSlurm      ExecutorType = "slurm"
+	ExecutorKubernetes ExecutorType = "kubernetes"
+)
+
+type Process interface {
+	Wait() error
+	Kill() error
+}
+
+type Executor interface {
+	Start(parameters []string) (Process, error)
+	// ForDatabase returns an executor that uses the database specific settings
+	ForDatabase(params Params) Executor
+	// Cleanup releases resources that are shared between all calls of a job
+	Cleanup() error
+}
+
+// MakeExecutor creates the executor for a single job, gpu is the device assigned to the job or empty.
+// The output of all calls is written to output (if not nil).
+func MakeExecutor(config ConfigRoot, request JobRequest, gpu string, output io.Writer) Executor {
+	switch config.Worker.Executor {
+	case ExecutorContainer:
+		return MakeContainerExecutor(config, request, gpu, output)
+	}
+	return LocalExecutor{config, gpu, jobPriority(config, request), output}
+}
+
+// schedulerExecutor is true for executors that hand whole jobs to a cluster scheduler,
+// which takes care of resources and GPU assignment
+func schedulerExecutor(config ConfigRoot) bool {
+	return config.Worker.Executor == ExecutorSlurm || config.Worker.Executor == ExecutorKubernetes
+}
+
+// ExecuteJob runs the job with the configured executor, gpu is only used by executors that are not schedulers
+func ExecuteJob(ctx context.Context, request JobRequest, config ConfigRoot, gpu string, needsGpu bool, timeout time.Duration, output io.Writer) error {
+	switch config.Worker.Executor {
+	case ExecutorSlurm:
+		return RunSlurmJob(ctx, request, config, needsGpu, timeout, output)
+	case ExecutorKubernetes:
+		return RunKubernetesJob(ctx, request, config, needsGpu, timeout, output)
+	}
+
+	executor := MakeExecutor(config, request, gpu, output)
+	err := RunJob(ctx, request, config, executor)
+	if cerr := executor.Cleanup(); cerr != nil {
+		executorLog.Error("Failed to clean up job", "error", cerr)
+	}
+	return err
+}
+
+type LocalExecutor struct {
+	config   ConfigRoot
+	gpu      string
+	priority ConfigProcessPriority
+	output   io.Writer
+}
+
+func setCommandOutput(cmd *exec.Cmd, verbose bool, output io.Writer) {
+	switch {
+	case verbose && output != nil:
+		cmd.Stdout = io.MultiWriter(os.Stdout, output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, output)
+	case verbose:
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	case output != nil:
+		cmd.Stdout = output
+		cmd.Stderr = output
+	}
+}
+
+type LocalProcess struct {
+	cmd    *exec.Cmd
+	cgroup *Cgroup
+}
+
+func (e LocalExecutor) Start(parameters []string) (Process, error) {
+	parameters = e.priority.wrap(parameters)
+	cmd := exec.Command(
+		parameters[0],
+		parameters[1:]...,
+	)
+
+	SetSysProcAttr(cmd)
+
+	// Make sure MMseqs2's progress bar doesn't break
+	cmd.Env = append(os.Environ(), "TTY=0", "MMSEQS_CALL_DEPTH=1")
+	if e.gpu != "" {
+		cmd.Env = append(cmd.Env, "CUDA_VISIBLE_DEVICES="+e.gpu)
+	}
+
+	setCommandOutput(cmd, debugLogging(), e.output)
+
+	process := &LocalProcess{cmd, nil}
+	if e.config.Worker.Cgroup != nil {
+		cgroup, err := NewCgroup(*e.config.Worker.Cgroup, e.priority)
+		if err != nil {
+			return process, err
+		}
+		cgroup.Apply(cmd)
+		process.cgroup = cgroup
+	}
+
+	err := cmd.Start()
+	if err != nil {
+		if process.cgroup != nil {
+			process.cgroup.Remove()
+		}
+		return process, err
+	}
+
+	return process, nil
+}
+
+func (e LocalExecutor) ForDatabase(params Params) Executor {
+	return e
+}
+
+func (e LocalExecutor) Cleanup() error {
+	return nil
+}
+
+func (p *LocalProcess) Wait() error {
+	err := p.cmd.Wait()
+	if p.cgroup != nil {
+		if p.cgroup.OOMKilled() {
+			err = &JobOutOfMemoryError{}
+		}
+		if rerr := p.cgroup.Remove(); rerr != nil {
+			executorLog.Error("Failed to remove cgroup", "error", rerr)
+		}
+	}
+	return err
+}
+
+func (p *LocalProcess) Kill() error {
+	if p.cgroup != nil {
+		p.cgroup.Kill()
+	}
+	return KillCommand(p.cmd)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var containerCounter uint64
+
+// ContainerExecutor runs every mmseqs/foldseek call of a job in a fresh docker/podman container.
+// Databases, the job result directory and the job temporary directory are bind-mounted to the same paths as on the host.
+type ContainerExecutor struct {
+	config   ConfigRoot
+	id       Id
+	image    string
+	writeDbs bool
+	gpu      string
+	priority ConfigProcessPriority
+	output   io.Writer
+}
+
+type ContainerProcess struct {
+	cmd     *exec.Cmd
+	runtime string
+	name    string
+}
+
+func MakeContainerExecutor(config ConfigRoot, request JobRequest, gpu string, output io.Writer) ContainerExecutor {
+	return ContainerExecutor{
+		config,
+		request.Id,
+		config.Worker.Container.Image,
+		request.Type == JobIndex,
+		gpu,
+		jobPriority(config, request),
+		output,
+	}
+}
+
+func (e ContainerExecutor) runtime() string {
+	if e.config.Worker.Container.Runtime == "" {
+		return "docker"
+	}
+	return e.config.Worker.Container.Runtime
+}
+
+func (e ContainerExecutor) name() string {
+	return "mmseqs-web-" + strings.ToLower(strings.Trim(string(e.id), "-_"))
+}
+
+// binary maps host paths of mmseqs/foldseek/foldmason to the binary name inside the image
+func (e ContainerExecutor) binary(parameter string) string {
+	paths := e.config.Paths
+	for _, path := range []string{paths.Mmseqs, paths.FoldSeek, paths.FoldMason} {
+		if path != "" && parameter == path {
+			return filepath.Base(path)
+		}
+	}
+	return parameter
+}
+
+func (e ContainerExecutor) Start(parameters []string) (Process, error) {
+	name := e.name() + "-" + strconv.FormatUint(atomic.AddUint64(&containerCounter, 1), 10)
+	databases := filepath.Clean(e.config.Paths.Databases)
+	results := filepath.Join(filepath.Clean(e.config.Paths.Results), string(e.id))
+	temporary := jobTempDir(e.config, e.id)
+
+	dbMount := databases + ":" + databases + ":ro"
+	if e.writeDbs {
+		dbMount = databases + ":" + databases
+	}
+
+	args := []string{
+		e.runtime(),
+		"run",
+		"--name", name,
+		"--init",
+		"--network", "none",
+		"-e", "TTY=0",
+		"-e", "MMSEQS_CALL_DEPTH=1",
+		"-v", dbMount,
+		"-v", results + ":" + results,
+		"-w", results,
+	}
+	// the temporary directory is inside the result directory if paths.temporary is not set
+	if !strings.HasPrefix(temporary, results+string(filepath.Separator)) {
+		args = append(args, "-v", temporary+":"+temporary)
+	}
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 && gid >= 0 {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", uid, gid))
+	}
+	if cgroup := e.config.Worker.Cgroup; cgroup != nil {
+		if cgroup.CPUs > 0 {
+			args = append(args, "--cpus", strconv.FormatFloat(cgroup.CPUs, 'f', -1, 64))
+		}
+		if cgroup.Memory != "" {
+			args = append(args, "--memory", cgroup.Memory)
+		}
+	}
+	if e.gpu != "" {
+		if e.runtime() == "podman" {
+			args = append(args, "--device", "nvidia.com/gpu="+e.gpu)
+		} else {
+			args = append(args, "--gpus", "device="+e.gpu)
+		}
+	}
+	args = append(args, e.priority.containerArgs()...)
+	args = append(args, e.config.Worker.Container.Args...)
+	args = append(args, "--entrypoint", e.binary(parameters[0]), e.image)
+	for _, parameter := range parameters[1:] {
+		args = append(args, e.binary(parameter))
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	SetSysProcAttr(cmd)
+	setCommandOutput(cmd, debugLogging(), e.output)
+
+	process := &ContainerProcess{cmd, e.runtime(), name}
+	if err := cmd.Start(); err != nil {
+		return process, err
+	}
+	return process, nil
+}
+
+func (e ContainerExecutor) ForDatabase(params Params) Executor {
+	if params.Image != "" {
+		e.image = params.Image
+	}
+	return e
+}
+
+func (e ContainerExecutor) Cleanup() error {
+	return nil
+}
+
+func (p *ContainerProcess) Wait() error {
+	err := p.cmd.Wait()
+	out, ierr := exec.Command(p.runtime, "inspect", "--format", "{{.State.OOMKilled}}", p.name).Output()
+	if ierr == nil && strings.TrimSpace(string(out)) == "true" {
+		err = &JobOutOfMemoryError{}
+	}
+	exec.Command(p.runtime, "rm", "-f", p.name).Run()
+	return err
+}
+
+func (p *ContainerProcess) Kill() error {
+	// killing the client alone would leave the container running
+	err := exec.Command(p.runtime, "kill", p.name).Run()
+	if kerr := KillCommand(p.cmd); err == nil {
+		err = kerr
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (k ConfigKubernetes) kubectl(stdin io.Reader, args ...string) (string, error) {
+	if k.Namespace != "" {
+		args = append([]string{"--namespace", k.Namespace}, args...)
+	}
+	cmd := exec.Command("kubectl", args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			return "", errors.New("kubectl: " + strings.TrimSpace(stderr.String()))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// kubernetesJobName derives a valid DNS-1123 name from a ticket id, which may contain upper case letters and underscores
+func kubernetesJobName(id Id) string {
+	sum := sha256.Sum256([]byte(id))
+	return "mmseqs-web-" + hex.EncodeToString(sum[:8])
+}
+
+func kubernetesManifest(request JobRequest, config ConfigRoot, needsGpu bool, timeout time.Duration) map[string]interface{} {
+	k8s := config.Worker.Kubernetes
+
+	resources := map[string]interface{}{}
+	if res, ok := k8s.Resources[request.Type]; ok {
+		if len(res.Requests) > 0 {
+			resources["requests"] = res.Requests
+		}
+		limits := map[string]string{}
+		for key, value := range res.Limits {
+			limits[key] = value
+		}
+		if needsGpu {
+			limits["nvidia.com/gpu"] = "1"
+		}
+		if len(limits) > 0 {
+			resources["limits"] = limits
+		}
+	} else if needsGpu {
+		resources["limits"] = map[string]string{"nvidia.com/gpu": "1"}
+	}
+
+	nodeSelector := map[string]string{}
+	for key, value := range k8s.NodeSelector {
+		nodeSelector[key] = value
+	}
+	if needsGpu {
+		for key, value := range k8s.GpuNodeSelector {
+			nodeSelector[key] = value
+		}
+	}
+
+	container := map[string]interface{}{
+		"name":            "job",
+		"image":           k8s.Image,
+		"imagePullPolicy": "IfNotPresent",
+		"args":            []string{"-job", string(request.Id), "-config", k8s.Config},
+		"resources":       resources,
+	}
+	if len(k8s.VolumeMounts) > 0 {
+		container["volumeMounts"] = k8s.VolumeMounts
+	}
+
+	pod := map[string]interface{}{
+		"restartPolicy": "Never",
+		"containers":    []interface{}{container},
+	}
+	if len(nodeSelector) > 0 {
+		pod["nodeSelector"] = nodeSelector
+	}
+	if len(k8s.Volumes) > 0 {
+		pod["volumes"] = k8s.Volumes
+	}
+	if k8s.ServiceAccount != "" {
+		pod["serviceAccountName"] = k8s.ServiceAccount
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]interface{}{
+			"name":        kubernetesJobName(request.Id),
+			"labels":      map[string]string{"app.kubernetes.io/managed-by": "mmseqs-web"},
+			"annotations": map[string]string{"mmseqs-web/ticket": string(request.Id)},
+		},
+		"spec": map[string]interface{}{
+			"backoffLimit": 0,
+			// leave the job some time to report a timeout on its own
+			"activeDeadlineSeconds":   int64(math.Ceil(timeout.Seconds())) + 60,
+			"ttlSecondsAfterFinished": 3600,
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels": map[string]string{"app.kubernetes.io/managed-by": "mmseqs-web"},
+				},
+				"spec": pod,
+			},
+		},
+	}
+}
+
+// RunKubernetesJob creates a Kubernetes Job that runs this binary in single job mode,
+// results are written to the results directory, which has to be a volume shared with the worker
+func RunKubernetesJob(ctx context.Context, request JobRequest, config ConfigRoot, needsGpu bool, timeout time.Duration, output io.Writer) error {
+	k8s := config.Worker.Kubernetes
+	base := filepath.Join(filepath.Clean(config.Paths.Results), string(request.Id))
+	sentinel := filepath.Join(base, jobOutcomeFile)
+	os.Remove(sentinel)
+
+	name := kubernetesJobName(request.Id)
+	// a job of a previous attempt would make create fail
+	k8s.kubectl(nil, "delete", "job", name, "--ignore-not-found", "--wait=true")
+
+	manifest, err := json.Marshal(kubernetesManifest(request, config, needsGpu, timeout))
+	if err != nil {
+		return &JobExecutionError{err}
+	}
+	if _, err := k8s.kubectl(bytes.NewReader(manifest), "create", "-f", "-"); err != nil {
+		return &JobExecutionError{err}
+	}
+	executorLog.Debug("Created kubernetes job", "ticket", request.Id, "kubernetes_job", name)
+
+	defer func() {
+		if output != nil {
+			if logs, err := k8s.kubectl(nil, "logs", "job/"+name, "--all-containers"); err == nil && logs != "" {
+				io.WriteString(output, logs+"\n")
+			}
+		}
+		if _, err := k8s.kubectl(nil, "delete", "job", name, "--ignore-not-found", "--wait=false", "--cascade=background"); err != nil {
+			executorLog.Error("Failed to delete kubernetes job", "kubernetes_job", name, "error", err)
+		}
+	}()
+
+	poll, err := time.ParseDuration(k8s.Poll)
+	if err != nil {
+		poll = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return &JobTimeoutError{}
+		case <-ticker.C:
+		}
+
+		if finished, err := readJobOutcome(sentinel); finished {
+			return err
+		}
+
+		state, err := k8s.kubectl(nil, "get", "job", name, "--output", "jsonpath={.status.succeeded},{.status.failed}")
+		if err != nil {
+			return &JobExecutionError{err}
+		}
+		counts := strings.SplitN(state, ",", 2)
+		if len(counts) != 2 || (counts[0] == "" && counts[1] == "") {
+			continue
+		}
+
+		// the volume might show the sentinel file with a delay
+		if finished, err := readJobOutcome(sentinel); finished {
+			return err
+		}
+		reasons, _ := k8s.kubectl(nil, "get", "pods", "--selector", "job-name="+name, "--output", "jsonpath={..state.terminated.reason}")
+		conditions, _ := k8s.kubectl(nil, "get", "job", name, "--output", "jsonpath={.status.conditions[*].reason}")
+		reasons = strings.TrimSpace(reasons + " " + conditions)
+		if strings.Contains(reasons, "OOMKilled") {
+			return &JobOutOfMemoryError{}
+		}
+		if strings.Contains(reasons, "DeadlineExceeded") {
+			return &JobTimeoutError{}
+		}
+		return &JobExecutionError{errors.New("kubernetes job " + name + " finished without result: " + reasons)}
+	}
+}
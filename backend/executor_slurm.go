@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// slurmCommand runs one of the slurm client tools and returns its trimmed output
+func slurmCommand(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", errors.New(name + ": " + strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// RunSlurmJob submits the whole job as a sbatch script that runs this binary in single job mode,
+// the job is finished once the job.exit sentinel file appears or slurm does not know the job anymore
+func RunSlurmJob(ctx context.Context, request JobRequest, config ConfigRoot, needsGpu bool, timeout time.Duration, output io.Writer) error {
+	slurm := config.Worker.Slurm
+	base := filepath.Join(filepath.Clean(config.Paths.Results), string(request.Id))
+	sentinel := filepath.Join(base, jobOutcomeFile)
+	os.Remove(sentinel)
+
+	binary := slurm.Binary
+	if binary == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return &JobExecutionError{err}
+		}
+		binary = executable
+	}
+
+	script := "#!/bin/sh\nexec " + shellQuote(binary) + " -job " + shellQuote(string(request.Id)) + " -config " + shellQuote(slurm.Config) + "\n"
+	scriptPath := filepath.Join(base, "slurm.sh")
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		return &JobExecutionError{err}
+	}
+
+	args := []string{
+		"--parsable",
+		"--job-name", "mmseqs-web-" + string(request.Id),
+		"--chdir", base,
+		"--output", filepath.Join(base, "slurm.out"),
+		// leave the job some time to report a timeout on its own
+		"--time", strconv.Itoa(int(math.Ceil(timeout.Minutes())) + 1),
+	}
+	if needsGpu {
+		args = append(args, "--gres=gpu:1")
+	}
+	// negative values are only allowed for slurm operators
+	if priority := jobPriority(config, request); priority.Nice != 0 {
+		args = append(args, "--nice="+strconv.Itoa(priority.Nice))
+	}
+	args = append(args, slurm.Args...)
+	args = append(args, slurm.Resources[request.Type]...)
+	args = append(args, scriptPath)
+
+	out, err := slurmCommand("sbatch", args...)
+	if err != nil {
+		return &JobExecutionError{err}
+	}
+	// --parsable prints jobid[;cluster]
+	jobId := strings.SplitN(out, ";", 2)[0]
+	executorLog.Debug("Submitted slurm job", "ticket", request.Id, "slurm_job", jobId)
+
+	poll, err := time.ParseDuration(slurm.Poll)
+	if err != nil {
+		poll = 10 * time.Second
+	}
+
+	defer func() {
+		if output == nil {
+			return
+		}
+		if slurmOut, err := os.Open(filepath.Join(base, "slurm.out")); err == nil {
+			io.Copy(output, slurmOut)
+			slurmOut.Close()
+			os.Remove(slurmOut.Name())
+		}
+	}()
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if _, err := slurmCommand("scancel", jobId); err != nil {
+				executorLog.Error("Failed to cancel slurm job", "slurm_job", jobId, "error", err)
+			}
+			return &JobTimeoutError{}
+		case <-ticker.C:
+		}
+
+		if finished, err := readJobOutcome(sentinel); finished {
+			return err
+		}
+
+		// squeue fails for jobs that already left the queue on some slurm versions, sacct has the final say
+		state, err := slurmCommand("squeue", "--noheader", "--jobs", jobId, "--format", "%T")
+		if err == nil && state != "" {
+			continue
+		}
+
+		if finished, err := readJobOutcome(sentinel); finished {
+			return err
+		}
+		state, err = slurmCommand("sacct", "--noheader", "--allocations", "--jobs", jobId, "--format", "State")
+		if err != nil {
+			return &JobExecutionError{err}
+		}
+		switch {
+		case state == "", strings.HasPrefix(state, "PENDING"), strings.HasPrefix(state, "RUNNING"), strings.HasPrefix(state, "COMPLETING"):
+			continue
+		case strings.HasPrefix(state, "OUT_OF_MEMORY"):
+			return &JobOutOfMemoryError{}
+		case strings.HasPrefix(state, "TIMEOUT"):
+			return &JobTimeoutError{}
+		default:
+			return &JobExecutionError{errors.New("slurm job " + jobId + " ended with state " + state)}
+		}
+	}
+}
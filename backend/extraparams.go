@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type ParameterKind int
+
+const (
+	ParameterInt   ParameterKind = 0
+	ParameterFloat ParameterKind = 1
+	ParameterEnum  ParameterKind = 2
+)
+
+// ParameterConstraint describes the values an additional parameter may take.
+// Int and float values have to lie within [Min, Max], enum values have to be one of Values.
+type ParameterConstraint struct {
+	Kind   ParameterKind
+	Min    float64
+	Max    float64
+	Values []string
+}
+
+var sensitivityParameter = ParameterConstraint{ParameterFloat, 1, 9.5, nil}
+var evalueParameter = ParameterConstraint{ParameterFloat, 0, 100, nil}
+var maxSeqsParameter = ParameterConstraint{ParameterInt, 1, 10000, nil}
+var fractionParameter = ParameterConstraint{ParameterFloat, 0, 1, nil}
+var covModeParameter = ParameterConstraint{ParameterEnum, 0, 0, []string{"0", "1", "2", "3", "4", "5"}}
+var switchParameter = ParameterConstraint{ParameterEnum, 0, 0, []string{"0", "1"}}
+
+// Flags the worker sets itself (output format, db loading, paths) are intentionally not allowed
+var extraParameterAllowlist = map[JobType]map[string]ParameterConstraint{
+	JobSearch: {
+		"-s":                  sensitivityParameter,
+		"-e":                  evalueParameter,
+		"--max-seqs":          maxSeqsParameter,
+		"-c":                  fractionParameter,
+		"--cov-mode":          covModeParameter,
+		"--min-seq-id":        fractionParameter,
+		"--exhaustive-search": switchParameter,
+		"--alignment-mode":    {ParameterEnum, 0, 0, []string{"0", "1", "2", "3", "4"}},
+		"--num-iterations":    {ParameterInt, 1, 5, nil},
+	},
+	JobStructureSearch: {
+		"-s":                  sensitivityParameter,
+		"-e":                  evalueParameter,
+		"--max-seqs":          maxSeqsParameter,
+		"-c":                  fractionParameter,
+		"--cov-mode":          covModeParameter,
+		"--min-seq-id":        fractionParameter,
+		"--exhaustive-search": switchParameter,
+		"--tmscore-threshold": fractionParameter,
+		"--lddt-threshold":    fractionParameter,
+	},
+	JobComplexSearch: {
+		"-s":                  sensitivityParameter,
+		"-e":                  evalueParameter,
+		"--max-seqs":          maxSeqsParameter,
+		"-c":                  fractionParameter,
+		"--cov-mode":          covModeParameter,
+		"--exhaustive-search": switchParameter,
+		"--tmscore-threshold": fractionParameter,
+	},
+	JobCluster: {
+		"-s":                 sensitivityParameter,
+		"-e":                 evalueParameter,
+		"--cluster-mode":     {ParameterEnum, 0, 0, []string{"0", "1", "2", "3"}},
+		"--cluster-reassign": switchParameter,
+		"--max-seqs":         maxSeqsParameter,
+		"--kmer-per-seq":     {ParameterInt, 1, 1000, nil},
+	},
+}
+
+// ParseExtraParameters splits a parameter string (e.g. "-s 7.5 --max-seqs 1000") into
+// flag value pairs and checks each one against the allowlist of the job type
+func ParseExtraParameters(jobType JobType, parameters string) ([]string, error) {
+	fields := strings.Fields(parameters)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	allowed, ok := extraParameterAllowlist[jobType]
+	if !ok {
+		return nil, errors.New("additional parameters are not supported for this job type")
+	}
+
+	if len(fields)%2 != 0 {
+		return nil, errors.New("every additional parameter requires a value")
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < len(fields); i += 2 {
+		flag, value := fields[i], fields[i+1]
+		constraint, ok := allowed[flag]
+		if !ok {
+			return nil, errors.New("parameter " + flag + " is not allowed")
+		}
+		if seen[flag] {
+			return nil, errors.New("parameter " + flag + " was given multiple times")
+		}
+		seen[flag] = true
+
+		if err := constraint.check(value); err != nil {
+			return nil, errors.New("invalid value for parameter " + flag + ": " + err.Error())
+		}
+	}
+
+	return fields, nil
+}
+
+func (c ParameterConstraint) check(value string) error {
+	switch c.Kind {
+	case ParameterInt:
+		num, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("expected an integer")
+		}
+		if float64(num) < c.Min || float64(num) > c.Max {
+			return errors.New("must be between " + strconv.FormatFloat(c.Min, 'f', -1, 64) + " and " + strconv.FormatFloat(c.Max, 'f', -1, 64))
+		}
+	case ParameterFloat:
+		num, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(num) {
+			return errors.New("expected a number")
+		}
+		if num < c.Min || num > c.Max {
+			return errors.New("must be between " + strconv.FormatFloat(c.Min, 'f', -1, 64) + " and " + strconv.FormatFloat(c.Max, 'f', -1, 64))
+		}
+	case ParameterEnum:
+		if isIn(value, c.Values) == -1 {
+			return errors.New("must be one of " + strings.Join(c.Values, ", "))
+		}
+	}
+	return nil
+}
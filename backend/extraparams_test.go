@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExtraParameters(t *testing.T) {
+	tests := []struct {
+		jobType    JobType
+		parameters string
+		expected   []string
+		valid      bool
+	}{
+		{JobSearch, "", nil, true},
+		{JobSearch, "-s 7.5 --max-seqs 1000", []string{"-s", "7.5", "--max-seqs", "1000"}, true},
+		{JobSearch, "  --cov-mode 1   -c 0.8 ", []string{"--cov-mode", "1", "-c", "0.8"}, true},
+		{JobSearch, "-s", nil, false},
+		{JobSearch, "-s 12", nil, false},
+		{JobSearch, "-s NaN", nil, false},
+		{JobSearch, "--max-seqs 10.5", nil, false},
+		{JobSearch, "--cov-mode 6", nil, false},
+		{JobSearch, "-s 5 -s 6", nil, false},
+		{JobSearch, "--format-output query,target", nil, false},
+		{JobSearch, "--threads 1;rm", nil, false},
+		{JobStructureSearch, "--tmscore-threshold 0.5", []string{"--tmscore-threshold", "0.5"}, true},
+		{JobSearch, "--tmscore-threshold 0.5", nil, false},
+		{JobMsa, "-s 7.5", nil, false},
+	}
+
+	for _, test := range tests {
+		result, err := ParseExtraParameters(test.jobType, test.parameters)
+		if test.valid && err != nil {
+			t.Errorf("%s %q: unexpected error: %s", test.jobType, test.parameters, err)
+			continue
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s %q: expected an error", test.jobType, test.parameters)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s %q: got %v, want %v", test.jobType, test.parameters, result, test.expected)
+		}
+	}
+}
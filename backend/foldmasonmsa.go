@@ -65,11 +65,10 @@ func NewFoldMasonMSAJobRequest(
 		gapExtend,
 	}
 	request := JobRequest{
-		job.Hash(),
-		StatusPending,
-		JobFoldMasonMSA,
-		job,
-		"",
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobFoldMasonMSA,
+		Job:    job,
 	}
 	return request, nil
 }
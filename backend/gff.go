@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gffMatchType is the sequence ontology type of the hits of a search against a database, GFF3 features are located on the query
+// so the query has to be nucleotide
+func gffMatchType(job SearchJob, dbPath string) (string, error) {
+	st, ok := searchTypes[job.SearchType]
+	if !ok {
+		nucleotide, err := databaseIsNucleotide(dbPath)
+		if err != nil {
+			return "", err
+		}
+		st.query = job.QueryType
+		st.nucleotide = nucleotide
+		st.translated = nucleotide != (job.QueryType == QueryNucleotide)
+	}
+	if st.query != QueryNucleotide {
+		return "", errors.New("GFF3 output needs a nucleotide query")
+	}
+	switch {
+	case !st.nucleotide:
+		return "protein_match", nil
+	case st.translated:
+		return "translated_nucleotide_match", nil
+	}
+	return "nucleotide_match", nil
+}
+
+// gffEscape percent-encodes the characters with a meaning in GFF3 columns and attribute values
+func gffEscape(value string) string {
+	var res strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c == 0x7f || c == '%' || c == ';' || c == '=' || c == '&' || c == ',' || c == ' ' {
+			fmt.Fprintf(&res, "%%%02X", c)
+			continue
+		}
+		res.WriteByte(c)
+	}
+	return res.String()
+}
+
+// gffFeature converts an alignment result line, the feature is on the strand of the query the target aligns to
+func gffFeature(fields []string, columns alignmentColumns, source string, matchType string, id int) (string, error) {
+	var coords [5]int
+	for i, column := range []int{6, 7, 8, 9, columns.QueryLength} {
+		value, err := strconv.Atoi(fields[column])
+		if err != nil {
+			return "", err
+		}
+		coords[i] = value
+	}
+	qstart, qend, tstart, tend, qlen := coords[0], coords[1], coords[2], coords[3], coords[4]
+	queryReverse := qstart > qend
+	targetReverse := tstart > tend
+	if queryReverse {
+		qstart, qend = qend, qstart
+	}
+	if targetReverse {
+		tstart, tend = tend, tstart
+	}
+	strand := "+"
+	if queryReverse != targetReverse {
+		strand = "-"
+	}
+
+	target := samName(fields[1])
+	targetLocation := gffEscape(target) + " " + strconv.Itoa(tstart) + " " + strconv.Itoa(tend)
+	if matchType != "protein_match" {
+		targetStrand := "+"
+		if targetReverse {
+			targetStrand = "-"
+		}
+		targetLocation += " " + targetStrand
+	}
+	attributes := []string{
+		"ID=match" + strconv.Itoa(id),
+		"Name=" + gffEscape(target),
+		"Target=" + targetLocation,
+		"identity=" + fields[2],
+		"bits=" + fields[columns.Bits],
+	}
+	if matchType != "nucleotide_match" {
+		// reading frame of the translated query, counted from the start of its strand
+		frame := (qstart-1)%3 + 1
+		if queryReverse {
+			frame = -((qlen-qend)%3 + 1)
+		}
+		attributes = append(attributes, "frame="+strconv.Itoa(frame))
+	}
+	record := []string{
+		gffEscape(samName(fields[0])), gffEscape(source), matchType, strconv.Itoa(qstart), strconv.Itoa(qend),
+		fields[columns.Evalue], strand, ".", strings.Join(attributes, ";"),
+	}
+	return strings.Join(record, "\t"), nil
+}
+
+// GFF3 writes the hits of nucleotide queries as features of the queries, matchTypes has the gffMatchType of each database.
+// The sequence regions of the queries are listed in the header, so the results are read twice.
+func GFF3(w io.Writer, base string, databases []string, matchTypes []string, queries []uint32) error {
+	columns := resultColumns(false)
+	readers := make([]*Reader[uint32], 0, len(databases))
+	defer func() {
+		for _, reader := range readers {
+			reader.Delete()
+		}
+	}()
+
+	out := bufio.NewWriter(w)
+	out.WriteString("##gff-version 3\n")
+	seen := make(map[string]bool)
+	for _, database := range databases {
+		reader := &Reader[uint32]{}
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return err
+		}
+		readers = append(readers, reader)
+		err := forEachAlignment(reader, columns, queries, func(fields []string) error {
+			name := gffEscape(samName(fields[0]))
+			if seen[name] {
+				return nil
+			}
+			seen[name] = true
+			out.WriteString("##sequence-region " + name + " 1 " + fields[columns.QueryLength] + "\n")
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	id := 0
+	for i, reader := range readers {
+		err := forEachAlignment(reader, columns, queries, func(fields []string) error {
+			id++
+			record, err := gffFeature(fields, columns, databases[i], matchTypes[i], id)
+			if err != nil {
+				return err
+			}
+			out.WriteString(record)
+			return out.WriteByte('\n')
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+func init() {
+	RegisterResultFormatter("gff3", resultFormat{"gff3", "text/plain", func(r ResultRequest) (ResultWriter, error) {
+		job, ok := r.Request.Job.(SearchJob)
+		if !ok {
+			return nil, errors.New("GFF3 output is only available for sequence searches")
+		}
+		var matchTypes []string
+		for _, database := range r.Databases {
+			matchType, err := gffMatchType(job, filepath.Join(r.Config.Paths.Databases, database))
+			if err != nil {
+				return nil, err
+			}
+			matchTypes = append(matchTypes, matchType)
+		}
+		return func(w io.Writer) error {
+			return GFF3(w, r.Base, r.Databases, matchTypes, r.Queries)
+		}, nil
+	}})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGFF3(t *testing.T) {
+	dir := t.TempDir()
+	// the second hit is on the reverse strand of the query
+	alignments := "q1 contig\tP1 some protein\t75.0\t4\t1\t0\t4\t15\t1\t4\t1E-3\t20\t30\t100\tACGT\tACGA\n" +
+		"q1 contig\tP2\t100.0\t4\t0\t0\t30\t19\t5\t8\t2E-5\t25\t30\t100\tACGT\tACGT\n\x00"
+	writeTestDatabase(t, dir, "alis_prot", alignments)
+
+	var out strings.Builder
+	if err := GFF3(&out, dir, []string{"prot"}, []string{"protein_match"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "##gff-version 3\n" +
+		"##sequence-region q1 1 30\n" +
+		"q1\tprot\tprotein_match\t4\t15\t1E-3\t+\t.\tID=match1;Name=P1;Target=P1 1 4;identity=75.0;bits=20;frame=1\n" +
+		"q1\tprot\tprotein_match\t19\t30\t2E-5\t-\t.\tID=match2;Name=P2;Target=P2 5 8;identity=100.0;bits=25;frame=-1\n"
+	if out.String() != expected {
+		t.Errorf("unexpected output\n%s", out.String())
+	}
+	if escaped := gffEscape("a;b=c d"); escaped != "a%3Bb%3Dc%20d" {
+		t.Errorf("unexpected escape %s", escaped)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// GpuPool hands out GPU device IDs so that at most one job runs per GPU
+type GpuPool struct {
+	devices chan string
+	size    int
+}
+
+func MakeGpuPool(devices []string) *GpuPool {
+	pool := &GpuPool{make(chan string, len(devices)), len(devices)}
+	for _, device := range devices {
+		pool.devices <- device
+	}
+	return pool
+}
+
+func (p *GpuPool) Size() int {
+	return p.size
+}
+
+// Acquire blocks until a device becomes free
+func (p *GpuPool) Acquire() string {
+	return <-p.devices
+}
+
+func (p *GpuPool) Release(device string) {
+	p.devices <- device
+}
+
+func requiresGpu(request JobRequest, config ConfigRoot) (bool, error) {
+	var databases []string
+	switch job := request.Job.(type) {
+	case SearchJob:
+		databases = job.Database
+	case StructureSearchJob:
+		databases = job.Database
+	case ComplexSearchJob:
+		databases = job.Database
+	case MsaJob:
+		return colabFoldGpu(config), nil
+	case PairJob:
+		if len(job.Database) == 0 {
+			return colabFoldGpu(config), nil
+		}
+		databases = job.Database
+	default:
+		return false, nil
+	}
+
+	for _, database := range databases {
+		params, err := ReadParams(filepath.Join(config.Paths.Databases, database+".params"))
+		if err != nil {
+			return false, err
+		}
+		if params.GPU {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// colabFoldGpu is the gpu flag of the colabfold databases, which the MSA and pair modes search
+func colabFoldGpu(config ConfigRoot) bool {
+	return config.Paths.ColabFold != nil && config.Paths.ColabFold.Gpu
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRequiresGpu(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	config.Paths.ColabFold = &ConfigColabFoldPaths{}
+	if err := SaveParams(filepath.Join(config.Paths.Databases, "uniref_gpu.params"), Params{Name: "uniref_gpu", Path: "uniref_gpu", GPU: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	msa := JobRequest{Type: JobMsa, Job: MsaJob{Mode: "env"}}
+	pair := JobRequest{Type: JobPair, Job: PairJob{Mode: "env"}}
+	for _, request := range []JobRequest{msa, pair} {
+		if gpu, err := requiresGpu(request, config); err != nil || gpu {
+			t.Errorf("%s jobs against CPU colabfold databases should not require a GPU", request.Type)
+		}
+	}
+
+	config.Paths.ColabFold.Gpu = true
+	for _, request := range []JobRequest{msa, pair} {
+		if gpu, err := requiresGpu(request, config); err != nil || !gpu {
+			t.Errorf("%s jobs against GPU colabfold databases should require a GPU", request.Type)
+		}
+	}
+
+	config.Paths.ColabFold.Gpu = false
+	pair = JobRequest{Type: JobPair, Job: PairJob{Mode: "env", Database: []string{"uniref_gpu"}}}
+	if gpu, err := requiresGpu(pair, config); err != nil || !gpu {
+		t.Errorf("pair jobs against GPU databases should require a GPU: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// kept in paths.results next to job.json like the summary
+const jobHistogramsFile = "job.histograms.json"
+
+// Histogram has equal width bins from start, values outside of the bins are counted in the first or last one
+type Histogram struct {
+	Start  float64 `json:"start"`
+	Width  float64 `json:"width"`
+	Counts []int64 `json:"counts"`
+}
+
+func newHistogram(start float64, width float64, bins int) Histogram {
+	return Histogram{start, width, make([]int64, bins)}
+}
+
+func (h *Histogram) add(value float64) {
+	bin := int(math.Floor((value - h.Start) / h.Width))
+	if bin < 0 || math.IsNaN(value) {
+		bin = 0
+	} else if bin >= len(h.Counts) {
+		bin = len(h.Counts) - 1
+	}
+	h.Counts[bin]++
+}
+
+// ResultHistograms are the distributions of the hits of a database, identity is in percent and
+// the E-values are binned by their log10, an E-value of 0 is in the first bin
+type ResultHistograms struct {
+	Identity Histogram `json:"identity"`
+	Coverage Histogram `json:"coverage"`
+	Evalue   Histogram `json:"log10Evalue"`
+}
+
+// ComputeHistograms bins the hits of every database of a job, jobs without alignment results have none
+func ComputeHistograms(base string, request JobRequest) (map[string]ResultHistograms, error) {
+	histograms := make(map[string]ResultHistograms)
+	databases, foldseek, err := resultDatabases(request)
+	if err != nil {
+		return histograms, nil
+	}
+	columns := resultColumns(foldseek)
+	reader := Reader[uint32]{}
+	for _, database := range databases {
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return histograms, err
+		}
+		h := ResultHistograms{newHistogram(0, 5, 20), newHistogram(0, 0.05, 20), newHistogram(-100, 5, 22)}
+		for i := int64(0); i < reader.Size(); i++ {
+			err := reader.ScanLines(i, func(line string) error {
+				fields := strings.Split(line, "\t")
+				if len(fields) <= columns.QueryLength {
+					return nil
+				}
+				if identity, err := strconv.ParseFloat(fields[2], 64); err == nil {
+					h.Identity.add(identity)
+				}
+				qstart, err1 := strconv.Atoi(fields[6])
+				qend, err2 := strconv.Atoi(fields[7])
+				qlen, err3 := strconv.Atoi(fields[columns.QueryLength])
+				if err1 == nil && err2 == nil && err3 == nil {
+					h.Coverage.add(queryCoverage(qstart, qend, qlen))
+				}
+				if evalue, err := strconv.ParseFloat(fields[columns.Evalue], 64); err == nil {
+					h.Evalue.add(math.Log10(evalue))
+				}
+				return nil
+			})
+			if err != nil {
+				reader.Delete()
+				return histograms, err
+			}
+		}
+		reader.Delete()
+		histograms[database] = h
+	}
+	return histograms, nil
+}
+
+func writeJobHistograms(base string, request JobRequest) error {
+	histograms, err := ComputeHistograms(base, request)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(histograms)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(base, jobHistogramsFile), data, 0644)
+}
+
+func readJobHistograms(base string) (map[string]ResultHistograms, error) {
+	var histograms map[string]ResultHistograms
+	data, err := os.ReadFile(filepath.Join(base, jobHistogramsFile))
+	if err != nil {
+		return histograms, err
+	}
+	err = json.Unmarshal(data, &histograms)
+	return histograms, err
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeHistograms(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "alis_db", "q1\tt1\t98.5\t100\t1\t0\t1\t100\t5\t104\t1.2E-50\t180\t100\t120\tAAA\tAAA\n"+
+		"q1\tt2\t42.0\t50\t1\t0\t1\t50\t5\t54\t0\t80\t100\t120\tAAA\tAAA\n\x00")
+
+	histograms, err := ComputeHistograms(dir, JobRequest{Type: JobSearch, Job: SearchJob{Database: []string{"db"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := histograms["db"]
+	expected := make([]int64, 20)
+	expected[8], expected[19] = 1, 1
+	if !reflect.DeepEqual(h.Identity.Counts, expected) {
+		t.Errorf("unexpected identity counts %v", h.Identity.Counts)
+	}
+	expected = make([]int64, 20)
+	expected[10], expected[19] = 1, 1
+	if !reflect.DeepEqual(h.Coverage.Counts, expected) {
+		t.Errorf("unexpected coverage counts %v", h.Coverage.Counts)
+	}
+	// log10(1.2E-50) is in [-50, -45), an E-value of 0 in the first bin
+	if h.Evalue.Counts[0] != 1 || h.Evalue.Counts[10] != 1 {
+		t.Errorf("unexpected E-value counts %v", h.Evalue.Counts)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HitFilter selects and orders the hits of each query of a result, zero values do not filter
+type HitFilter struct {
+	MaxEvalue float64
+	MinBits   int
+	// in percent like the seqId of the hits
+	MinIdentity float64
+	// fraction of the query that is aligned
+	MinCoverage float64
+	// case insensitive, matched against the target and its taxon name
+	Keyword string
+	// one of evalue, bits, identity, coverage or target
+	Sort       string
+	Descending bool
+	// hits per query, 0 keeps all
+	Limit int
+}
+
+var hitSortDescending = map[string]bool{"evalue": false, "bits": true, "identity": true, "coverage": true, "target": false}
+
+// ParseHitFilter reads the filter from the evalue, bits, identity, coverage, keyword, sort, order and limit query parameters
+func ParseHitFilter(query url.Values) (HitFilter, bool, error) {
+	var f HitFilter
+	var err error
+	active := false
+	for _, param := range []struct {
+		name  string
+		value *float64
+	}{{"evalue", &f.MaxEvalue}, {"identity", &f.MinIdentity}, {"coverage", &f.MinCoverage}} {
+		if v := query.Get(param.name); v != "" {
+			if *param.value, err = strconv.ParseFloat(v, 64); err != nil {
+				return f, false, errors.New("invalid " + param.name + " " + v)
+			}
+			active = true
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"bits", &f.MinBits}, {"limit", &f.Limit}} {
+		if v := query.Get(param.name); v != "" {
+			if *param.value, err = strconv.Atoi(v); err != nil || *param.value < 0 {
+				return f, false, errors.New("invalid " + param.name + " " + v)
+			}
+			active = true
+		}
+	}
+	if f.Keyword = strings.ToLower(strings.TrimSpace(query.Get("keyword"))); f.Keyword != "" {
+		active = true
+	}
+	if f.Sort = query.Get("sort"); f.Sort != "" {
+		descending, ok := hitSortDescending[f.Sort]
+		if !ok {
+			return f, false, errors.New("invalid sort " + f.Sort)
+		}
+		f.Descending = descending
+		active = true
+	}
+	switch order := query.Get("order"); order {
+	case "":
+	case "asc":
+		f.Descending = false
+	case "desc":
+		f.Descending = true
+	default:
+		return f, false, errors.New("invalid order " + order)
+	}
+	return f, active, nil
+}
+
+// hitValues are the properties of a hit that can be filtered and sorted by
+type hitValues struct {
+	evalue   float64
+	bits     int
+	identity float64
+	coverage float64
+	target   string
+	taxon    string
+	query    string
+}
+
+func queryCoverage(start int, end int, length int) float64 {
+	if length <= 0 {
+		return 0
+	}
+	if start > end {
+		start, end = end, start
+	}
+	return float64(end-start+1) / float64(length)
+}
+
+func (e AlignmentEntry) hit() hitValues {
+	return hitValues{e.Eval, e.Score, float64(e.SeqId), queryCoverage(e.QueryStartPos, e.QueryEndPos, e.QueryLength), e.Target, e.TaxonName, e.Query}
+}
+
+func (e FoldseekAlignmentEntry) hit() hitValues {
+	return hitValues{e.Eval, e.Score, float64(e.SeqId), queryCoverage(e.QueryStartPos, e.QueryEndPos, e.QueryLength), e.Target, e.TaxonName, e.Query}
+}
+
+func (e ComplexAlignmentEntry) hit() hitValues {
+	return hitValues{e.Eval, e.Score, float64(e.SeqId), queryCoverage(e.QueryStartPos, e.QueryEndPos, e.QueryLength), e.Target, e.TaxonName, e.Query}
+}
+
+func (f HitFilter) keep(h hitValues) bool {
+	if f.MaxEvalue != 0 && h.evalue > f.MaxEvalue {
+		return false
+	}
+	if h.bits < f.MinBits || h.identity < f.MinIdentity || h.coverage < f.MinCoverage {
+		return false
+	}
+	if f.Keyword != "" && !strings.Contains(strings.ToLower(h.target), f.Keyword) && !strings.Contains(strings.ToLower(h.taxon), f.Keyword) {
+		return false
+	}
+	return true
+}
+
+func (f HitFilter) less(a hitValues, b hitValues) bool {
+	switch f.Sort {
+	case "evalue":
+		return a.evalue < b.evalue
+	case "bits":
+		return a.bits < b.bits
+	case "identity":
+		return a.identity < b.identity
+	case "coverage":
+		return a.coverage < b.coverage
+	case "target":
+		return a.target < b.target
+	}
+	return false
+}
+
+func filterHits[T interface{ hit() hitValues }](groups [][]T, f HitFilter) [][]T {
+	res := make([][]T, 0, len(groups))
+	for _, hits := range groups {
+		kept := make([]T, 0, len(hits))
+		for _, hit := range hits {
+			if f.keep(hit.hit()) {
+				kept = append(kept, hit)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		if f.Sort != "" {
+			sort.SliceStable(kept, func(i, j int) bool {
+				if f.Descending {
+					return f.less(kept[j].hit(), kept[i].hit())
+				}
+				return f.less(kept[i].hit(), kept[j].hit())
+			})
+		}
+		if f.Limit > 0 && len(kept) > f.Limit {
+			kept = kept[:f.Limit]
+		}
+		res = append(res, kept)
+	}
+	return res
+}
+
+// FilterResults applies the filter to the hits of every database
+func FilterResults(results []SearchResult, f HitFilter) {
+	for i, res := range results {
+		switch alignments := res.Alignments.(type) {
+		case [][]AlignmentEntry:
+			results[i].Alignments = filterHits(alignments, f)
+		case [][]FoldseekAlignmentEntry:
+			results[i].Alignments = filterHits(alignments, f)
+		case [][]ComplexAlignmentEntry:
+			results[i].Alignments = filterHits(alignments, f)
+		}
+	}
+}
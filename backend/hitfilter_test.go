@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestFilterResults(t *testing.T) {
+	query, _ := url.ParseQuery("evalue=0.01&coverage=0.5&keyword=KINASE&sort=bits")
+	filter, active, err := ParseHitFilter(query)
+	if err != nil || !active {
+		t.Fatal(err)
+	}
+	if !filter.Descending {
+		t.Error("bits should sort descending by default")
+	}
+
+	hits := []AlignmentEntry{
+		{Target: "kinase_a", Eval: 1e-10, Score: 50, QueryStartPos: 1, QueryEndPos: 80, QueryLength: 100},
+		{Target: "kinase_b", Eval: 1e-20, Score: 90, QueryStartPos: 1, QueryEndPos: 60, QueryLength: 100},
+		// too short
+		{Target: "kinase_c", Eval: 1e-30, Score: 120, QueryStartPos: 1, QueryEndPos: 20, QueryLength: 100},
+		{Target: "kinase_d", Eval: 1, Score: 10, QueryStartPos: 1, QueryEndPos: 100, QueryLength: 100},
+		{Target: "other", Eval: 1e-10, Score: 70, QueryStartPos: 1, QueryEndPos: 100, QueryLength: 100, TaxonName: "Kinase virus"},
+		{Target: "unrelated", Eval: 1e-10, Score: 70, QueryStartPos: 1, QueryEndPos: 100, QueryLength: 100},
+	}
+	results := []SearchResult{{"db", [][]AlignmentEntry{hits, {hits[3]}}, nil, nil}}
+	FilterResults(results, filter)
+	groups := results[0].Alignments.([][]AlignmentEntry)
+	if len(groups) != 1 {
+		t.Fatalf("expected queries without hits to be removed, got %d", len(groups))
+	}
+	var targets []string
+	for _, hit := range groups[0] {
+		targets = append(targets, hit.Target)
+	}
+	if len(targets) != 3 || targets[0] != "kinase_b" || targets[1] != "other" || targets[2] != "kinase_a" {
+		t.Errorf("unexpected hits %v", targets)
+	}
+
+	if _, _, err := ParseHitFilter(url.Values{"sort": {"query"}}); err == nil {
+		t.Error("expected an invalid sort")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the tree is built with UPGMA in O(n³) of the leaves on an unauthenticated endpoint
+const (
+	defaultTreeHits = 100
+	maxTreeHits     = 300
+)
+
+// HitCluster are the hits that are closer to each other than the cluster threshold
+type HitCluster struct {
+	Id      int      `json:"id"`
+	Members []string `json:"members"`
+}
+
+type HitTree struct {
+	Newick   string       `json:"newick"`
+	Clusters []HitCluster `json:"clusters"`
+}
+
+// treeLeaf is a sequence aligned to the query, residues are indexed by the query position
+type treeLeaf struct {
+	name     string
+	residues []byte
+}
+
+// projectAlignment places the target residues of an alignment on the positions of the query
+func projectAlignment(qaln string, taln string, qstart int, qlen int) []byte {
+	residues := make([]byte, qlen+1)
+	position := qstart
+	for i := 0; i < len(qaln) && i < len(taln); i++ {
+		if qaln[i] == '-' {
+			continue
+		}
+		if position >= 0 && position < len(residues) && taln[i] != '-' {
+			residues[position] = taln[i]
+		}
+		position++
+	}
+	return residues
+}
+
+// leafDistance is one minus the identity over the query positions both sequences are aligned to
+func leafDistance(a []byte, b []byte) float64 {
+	shared, same := 0, 0
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == 0 || b[i] == 0 {
+			continue
+		}
+		shared++
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	if shared == 0 {
+		return 1
+	}
+	return 1 - float64(same)/float64(shared)
+}
+
+func newickLabel(name string) string {
+	if strings.ContainsAny(name, " \t()[]':;,") {
+		return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+	}
+	return name
+}
+
+func newickLength(length float64) string {
+	if length < 0 {
+		length = 0
+	}
+	return strconv.FormatFloat(length, 'f', 5, 64)
+}
+
+// upgma joins the leaves into a rooted tree and groups the leaves that are joined below the threshold
+func upgma(leaves []treeLeaf, threshold float64) HitTree {
+	n := len(leaves)
+	distances := make([][]float64, n)
+	for i := range distances {
+		distances[i] = make([]float64, n)
+		for j := 0; j < i; j++ {
+			distances[i][j] = leafDistance(leaves[i].residues, leaves[j].residues)
+			distances[j][i] = distances[i][j]
+		}
+	}
+	nodes := make([]string, n)
+	heights := make([]float64, n)
+	sizes := make([]int, n)
+	active := make([]bool, n)
+	// union find over the leaves for the clusters
+	parent := make([]int, n)
+	for i := range leaves {
+		nodes[i] = newickLabel(leaves[i].name)
+		sizes[i] = 1
+		active[i] = true
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for remaining := n; remaining > 1; remaining-- {
+		a, b := -1, -1
+		for i := 0; i < n; i++ {
+			if !active[i] {
+				continue
+			}
+			for j := i + 1; j < n; j++ {
+				if active[j] && (a == -1 || distances[i][j] < distances[a][b]) {
+					a, b = i, j
+				}
+			}
+		}
+		height := distances[a][b] / 2
+		nodes[a] = "(" + nodes[a] + ":" + newickLength(height-heights[a]) + "," + nodes[b] + ":" + newickLength(height-heights[b]) + ")"
+		if distances[a][b] <= threshold {
+			parent[find(b)] = find(a)
+		}
+		for k := 0; k < n; k++ {
+			if active[k] && k != a && k != b {
+				distances[a][k] = (distances[a][k]*float64(sizes[a]) + distances[b][k]*float64(sizes[b])) / float64(sizes[a]+sizes[b])
+				distances[k][a] = distances[a][k]
+			}
+		}
+		heights[a] = height
+		sizes[a] += sizes[b]
+		active[b] = false
+	}
+
+	tree := HitTree{"", make([]HitCluster, 0)}
+	for i := 0; i < n; i++ {
+		if active[i] {
+			tree.Newick = nodes[i] + ";"
+		}
+	}
+	ids := make(map[int]int)
+	for i, leaf := range leaves {
+		root := find(i)
+		id, ok := ids[root]
+		if !ok {
+			id = len(tree.Clusters)
+			ids[root] = id
+			tree.Clusters = append(tree.Clusters, HitCluster{id, make([]string, 0)})
+		}
+		tree.Clusters[id].Members = append(tree.Clusters[id].Members, leaf.name)
+	}
+	return tree
+}
+
+// BuildHitTree clusters the query and its best hits by the identity of their alignments to the query,
+// hits are compared on the query positions they share. Hits found in several databases are used once.
+// The tree has at most limit leaves, the query included.
+func BuildHitTree(base string, databases []string, foldseek bool, keys []uint32, limit int, threshold float64) (HitTree, error) {
+	columns := resultColumns(foldseek)
+	leaves := make([]treeLeaf, 0)
+	seen := make(map[string]bool)
+	reader := Reader[uint32]{}
+	for _, database := range databases {
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return HitTree{}, err
+		}
+		err := forEachAlignment(&reader, columns, keys, func(fields []string) error {
+			qstart, err := strconv.Atoi(fields[6])
+			if err != nil {
+				return err
+			}
+			qlen, err := strconv.Atoi(fields[columns.QueryLength])
+			if err != nil {
+				return err
+			}
+			if len(leaves) == 0 {
+				leaves = append(leaves, treeLeaf{fields[0], make([]byte, qlen+1)})
+				seen[fields[0]] = true
+			}
+			if seen[fields[1]] || len(leaves) >= limit {
+				return nil
+			}
+			seen[fields[1]] = true
+			// the query is the first leaf, it has its residues on all positions covered by a hit
+			qaln := strings.ReplaceAll(fields[columns.QueryAln], "-", "")
+			for i, residue := range projectAlignment(qaln, qaln, qstart, qlen) {
+				if residue != 0 && i < len(leaves[0].residues) {
+					leaves[0].residues[i] = residue
+				}
+			}
+			leaves = append(leaves, treeLeaf{fields[1], projectAlignment(fields[columns.QueryAln], fields[columns.TargetAln], qstart, qlen)})
+			return nil
+		})
+		reader.Delete()
+		if err != nil {
+			return HitTree{}, err
+		}
+	}
+	if len(leaves) == 0 {
+		return HitTree{}, errors.New("query has no hits")
+	}
+	return upgma(leaves, threshold), nil
+}
+
+type cachedHitTree struct {
+	tree HitTree
+	used time.Time
+}
+
+// hitTreeCache keeps the last built trees, the results of a finished job do not change
+type hitTreeCache struct {
+	mu    sync.Mutex
+	max   int
+	trees map[string]cachedHitTree
+}
+
+var hitTrees = &hitTreeCache{max: 64, trees: make(map[string]cachedHitTree)}
+
+// get returns the cached tree of key or builds it, trees that failed to build are not cached
+func (c *hitTreeCache) get(key string, build func() (HitTree, error)) (HitTree, error) {
+	c.mu.Lock()
+	if cached, ok := c.trees[key]; ok {
+		cached.used = time.Now()
+		c.trees[key] = cached
+		c.mu.Unlock()
+		return cached.tree, nil
+	}
+	c.mu.Unlock()
+
+	tree, err := build()
+	if err != nil {
+		return tree, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.trees) >= c.max {
+		oldest := ""
+		for key, cached := range c.trees {
+			if oldest == "" || cached.used.Before(c.trees[oldest].used) {
+				oldest = key
+			}
+		}
+		delete(c.trees, oldest)
+	}
+	c.trees[key] = cachedHitTree{tree, time.Now()}
+	return tree, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestBuildHitTree(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "alis_db", "q1\tA\t100.0\t8\t0\t0\t1\t8\t1\t8\t1E-10\t40\t8\t8\tMKVLAAGH\tMKVLAAGH\n"+
+		"q1\tB\t87.5\t8\t1\t0\t1\t8\t1\t8\t1E-9\t35\t8\t8\tMKVLAAGH\tMKVLAAGW\n"+
+		"q1\tC(x)\t25.0\t8\t6\t0\t1\t8\t1\t8\t1E-2\t10\t8\t8\tMKVLAAGH\tMRIFSSGW\n\x00")
+
+	tree, err := BuildHitTree(dir, []string{"db"}, false, nil, 10, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "(((q1:0.00000,A:0.00000):0.06250,B:0.06250):0.29167,'C(x)':0.35417);"; tree.Newick != expected {
+		t.Errorf("expected %s, got %s", expected, tree.Newick)
+	}
+	if len(tree.Clusters) != 2 || len(tree.Clusters[0].Members) != 3 || tree.Clusters[1].Members[0] != "C(x)" {
+		t.Errorf("unexpected clusters %+v", tree.Clusters)
+	}
+
+	if tree, err = BuildHitTree(dir, []string{"db"}, false, nil, 2, 0.2); err != nil || len(tree.Clusters) != 1 {
+		t.Errorf("the limit should keep the query and its best hit: %+v %v", tree, err)
+	}
+}
+
+func TestHitTreeCache(t *testing.T) {
+	cache := &hitTreeCache{max: 1, trees: make(map[string]cachedHitTree)}
+	builds := 0
+	build := func() (HitTree, error) {
+		builds++
+		return HitTree{Newick: "(q1);"}, nil
+	}
+	for _, key := range []string{"a", "a", "b", "a"} {
+		if tree, err := cache.get(key, build); err != nil || tree.Newick != "(q1);" {
+			t.Fatalf("unexpected tree %+v %v", tree, err)
+		}
+	}
+	// b pushed a out of the cache
+	if builds != 3 || len(cache.trees) != 1 {
+		t.Errorf("expected 3 builds and one cached tree, got %d %d", builds, len(cache.trees))
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// hits per query and database in the report, the archive has all of them
+const reportHitLimit = 100
+
+// letters per line of the alignments in the report
+const reportAlignmentWidth = 60
+
+type reportAlignmentBlock struct {
+	QueryStart  int
+	Query       string
+	Midline     string
+	Target      string
+	TargetStart int
+}
+
+type reportHit struct {
+	Target     string
+	Identity   string
+	Evalue     string
+	Bits       string
+	QueryStart string
+	QueryEnd   string
+	Blocks     []reportAlignmentBlock
+}
+
+type reportDatabase struct {
+	Name    string
+	Hits    []reportHit
+	Omitted int
+}
+
+type reportQuery struct {
+	Name      string
+	Databases []*reportDatabase
+}
+
+type htmlReport struct {
+	Id         Id
+	Type       JobType
+	Parameters string
+	Versions   []DatabaseVersion
+	Summary    *JobSummary
+	Queries    []*reportQuery
+	HitLimit   int
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Results {{.Id}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+th { background: #f0f0f0; }
+pre { background: #f8f8f8; padding: 0.5em; overflow-x: auto; }
+details > summary { cursor: pointer; }
+</style>
+</head>
+<body>
+<h1>Results {{.Id}}</h1>
+<h2>Job</h2>
+<p>Type: {{.Type}}</p>
+<pre>{{.Parameters}}</pre>
+{{with .Summary}}<p>{{.Text}}</p>{{end}}
+{{if .Versions}}<h2>Databases</h2>
+<table>
+<tr><th>Database</th><th>Name</th><th>Version</th><th>Sequences</th></tr>
+{{range .Versions}}<tr><td>{{.Path}}</td><td>{{.Name}}</td><td>{{.Version}}</td><td>{{.Sequences}}</td></tr>
+{{end}}</table>{{end}}
+<h2>Hits</h2>
+{{range .Queries}}<h3>{{.Name}}</h3>
+{{range .Databases}}<h4>{{.Name}}</h4>
+<table>
+<tr><th>Target</th><th>Identity</th><th>E-value</th><th>Score</th><th>Query range</th><th>Alignment</th></tr>
+{{range .Hits}}<tr><td>{{.Target}}</td><td>{{.Identity}}</td><td>{{.Evalue}}</td><td>{{.Bits}}</td><td>{{.QueryStart}}-{{.QueryEnd}}</td>
+<td><details><summary>show</summary><pre>{{range .Blocks}}Q {{printf "%6d" .QueryStart}} {{.Query}}
+         {{.Midline}}
+T {{printf "%6d" .TargetStart}} {{.Target}}
+
+{{end}}</pre></details></td></tr>
+{{end}}</table>
+{{if .Omitted}}<p>{{.Omitted}} more hits are in the result archive.</p>{{end}}
+{{end}}{{else}}<p>No hits.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// reportBlocks wraps an alignment, the target positions count down for hits on the reverse strand
+func reportBlocks(qaln string, taln string, qstart int, tstart int, reverse bool) []reportAlignmentBlock {
+	step := 1
+	if reverse {
+		step = -1
+	}
+	blocks := make([]reportAlignmentBlock, 0, len(qaln)/reportAlignmentWidth+1)
+	q, t := qstart, tstart
+	for start := 0; start < len(qaln) && start < len(taln); start += reportAlignmentWidth {
+		end := start + reportAlignmentWidth
+		if end > len(qaln) {
+			end = len(qaln)
+		}
+		if end > len(taln) {
+			end = len(taln)
+		}
+		midline := make([]byte, end-start)
+		block := reportAlignmentBlock{q, qaln[start:end], "", taln[start:end], t}
+		for i := start; i < end; i++ {
+			midline[i-start] = ' '
+			if qaln[i] == taln[i] && qaln[i] != '-' {
+				midline[i-start] = qaln[i]
+			}
+			if qaln[i] != '-' {
+				q++
+			}
+			if taln[i] != '-' {
+				t += step
+			}
+		}
+		block.Midline = string(midline)
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// HTMLReport writes a self-contained HTML page with the job parameters, database versions and the hits with their alignments
+func HTMLReport(w io.Writer, base string, request JobRequest) error {
+	databases, foldseek, err := resultDatabases(request)
+	if err != nil {
+		return err
+	}
+	parameters, err := json.MarshalIndent(request.Job, "", "  ")
+	if err != nil {
+		return err
+	}
+	report := htmlReport{request.Id, request.Type, string(parameters), nil, nil, make([]*reportQuery, 0), reportHitLimit}
+	if data, err := os.ReadFile(filepath.Join(base, databaseVersionsFile)); err == nil {
+		json.Unmarshal(data, &report.Versions)
+	}
+	if summary, err := readJobSummary(base); err == nil {
+		report.Summary = &summary
+	}
+
+	columns := resultColumns(foldseek)
+	queries := make(map[string]*reportQuery)
+	reader := Reader[uint32]{}
+	for _, database := range databases {
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return err
+		}
+		err := forEachAlignment(&reader, columns, nil, func(fields []string) error {
+			query, ok := queries[fields[0]]
+			if !ok {
+				query = &reportQuery{fields[0], make([]*reportDatabase, 0)}
+				queries[fields[0]] = query
+				report.Queries = append(report.Queries, query)
+			}
+			if len(query.Databases) == 0 || query.Databases[len(query.Databases)-1].Name != database {
+				query.Databases = append(query.Databases, &reportDatabase{database, make([]reportHit, 0), 0})
+			}
+			db := query.Databases[len(query.Databases)-1]
+			if len(db.Hits) >= reportHitLimit {
+				db.Omitted++
+				return nil
+			}
+			qstart, _ := strconv.Atoi(fields[6])
+			tstart, _ := strconv.Atoi(fields[8])
+			tend, _ := strconv.Atoi(fields[9])
+			blocks := reportBlocks(fields[columns.QueryAln], fields[columns.TargetAln], qstart, tstart, tend < tstart)
+			db.Hits = append(db.Hits, reportHit{fields[1], fields[2], fields[columns.Evalue], fields[columns.Bits], fields[6], fields[7], blocks})
+			return nil
+		})
+		reader.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	return reportTemplate.Execute(w, report)
+}
+
+func init() {
+	RegisterResultFormatter("html", resultFormat{"html", "text/html; charset=utf-8", func(r ResultRequest) (ResultWriter, error) {
+		return func(w io.Writer) error {
+			return HTMLReport(w, r.Base, r.Request)
+		}, nil
+	}})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTMLReport(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "alis_db", "q1\t<t1>\t75.0\t4\t1\t0\t1\t4\t5\t8\t1.2E-5\t30\t10\t20\tAC-D\tACGD\n\x00")
+	request := JobRequest{Id: "id", Status: StatusComplete, Type: JobSearch, Job: SearchJob{Database: []string{"db"}, Mode: "all"}}
+
+	var out strings.Builder
+	if err := HTMLReport(&out, dir, request); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, expected := range []string{"<h3>q1</h3>", "&lt;t1&gt;", "1.2E-5", "Q      1 AC-D\n         AC D\nT      5 ACGD", "&#34;mode&#34;: &#34;all&#34;"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("report does not contain %q", expected)
+		}
+	}
+}
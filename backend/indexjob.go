@@ -7,12 +7,17 @@ import (
 
 type IndexJob struct {
 	Path string `json:"path" validate:"required"`
+	// set for scheduled updates, the database is rebuilt from source once per upstream release
+	Upstream string `json:"upstream,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Format   string `json:"format,omitempty"`
 }
 
 func (r IndexJob) Hash() Id {
 	h := sha256.New224()
 	h.Write(([]byte)(JobIndex))
 	h.Write([]byte(r.Path))
+	h.Write([]byte(r.Upstream))
 
 	bs := h.Sum(nil)
 	return Id(base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(bs))
@@ -25,14 +30,17 @@ func (r IndexJob) Rank() float64 {
 func NewIndexJobRequest(path string, email string) (JobRequest, error) {
 	job := IndexJob{
 		path,
+		"",
+		"",
+		"",
 	}
 
 	request := JobRequest{
-		job.Hash(),
-		StatusPending,
-		JobIndex,
-		job,
-		email,
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobIndex,
+		Job:    job,
+		Email:  email,
 	}
 
 	return request, nil
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// IndexOptions are the createindex settings of a database, so that large databases can be indexed to fit the RAM of the workers
+type IndexOptions struct {
+	// number of splits of the index, 0 lets createindex decide
+	Split int `json:"split,omitempty" validate:"min=0"`
+	// maximum memory a single index split may use, e.g. 100G
+	SplitMemoryLimit string `json:"splitmemorylimit,omitempty"`
+	// leave the headers out of the index to save memory, they are read from the database instead
+	ExcludeHeaders bool `json:"excludeheaders,omitempty"`
+	// createindex --search-type, e.g. 2 for translated or 3 for nucleotide databases
+	SearchType int `json:"searchtype,omitempty" validate:"min=0,max=4"`
+}
+
+func (o *IndexOptions) Validate() error {
+	if o.Split < 0 {
+		return errors.New("split has to be positive")
+	}
+	if o.SearchType < 0 || o.SearchType > 4 {
+		return errors.New("search type has to be between 0 and 4")
+	}
+	if o.SplitMemoryLimit != "" {
+		if _, err := parseByteSize(o.SplitMemoryLimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexParameters converts the options to createindex arguments, options of the database take precedence over the worker defaults
+func indexParameters(params Params, config ConfigRoot) []string {
+	options := params.IndexOptions
+	if options == nil {
+		options = config.Worker.Index
+	}
+	if options == nil {
+		return []string{}
+	}
+
+	parameters := make([]string, 0)
+	if options.Split > 0 {
+		parameters = append(parameters, "--split", strconv.Itoa(options.Split))
+	}
+	if options.SplitMemoryLimit != "" {
+		parameters = append(parameters, "--split-memory-limit", options.SplitMemoryLimit)
+	}
+	if options.ExcludeHeaders {
+		parameters = append(parameters, "--index-subset", "1")
+	}
+	if options.SearchType > 0 {
+		parameters = append(parameters, "--search-type", strconv.Itoa(options.SearchType))
+	}
+	return parameters
+}
+
+// parseIndexOptions reads the index options of a database management request, nil if none are given
+func parseIndexOptions(req *http.Request) (*IndexOptions, error) {
+	split := req.FormValue("split")
+	memory := req.FormValue("splitmemorylimit")
+	headers := req.FormValue("excludeheaders")
+	searchType := req.FormValue("indexsearchtype")
+	if split == "" && memory == "" && headers == "" && searchType == "" {
+		return nil, nil
+	}
+
+	options := &IndexOptions{SplitMemoryLimit: memory, ExcludeHeaders: headers == "true"}
+	var err error
+	if split != "" {
+		if options.Split, err = strconv.Atoi(split); err != nil {
+			return nil, errors.New("invalid split")
+		}
+	}
+	if searchType != "" {
+		if options.SearchType, err = strconv.Atoi(searchType); err != nil {
+			return nil, errors.New("invalid index search type")
+		}
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// indexRebuildable is true if CheckDatabase builds the index of a database again,
+// databases without their sources keep the index they were installed with
+func indexRebuildable(basepath string, config ConfigRoot) bool {
+	if structureInput(basepath) != "" {
+		return config.App == AppFoldSeek
+	}
+	return fileExists(basepath+".fasta") || fileExists(basepath+".sto") || (fileExists(basepath+"_msa") && fileExists(basepath+"_msa.index"))
+}
+
+// asideIndex are the files of a precomputed index moved out of the way of the next index job
+type asideIndex []string
+
+const asideIndexSuffix = ".aside"
+
+// setAsideIndex moves the index of a database aside, so the next index job builds it with new options
+func setAsideIndex(basepath string, path string) (asideIndex, error) {
+	matches, err := filepath.Glob(filepath.Join(basepath, filepath.Base(path)+".idx*"))
+	if err != nil {
+		return nil, err
+	}
+	aside := make(asideIndex, 0, len(matches))
+	for _, match := range matches {
+		if strings.HasSuffix(match, asideIndexSuffix) {
+			continue
+		}
+		if err := os.Rename(match, match+asideIndexSuffix); err != nil {
+			aside.restore()
+			return nil, err
+		}
+		aside = append(aside, match)
+	}
+	return aside, nil
+}
+
+// restore moves the index back if the index job could not be queued
+func (a asideIndex) restore() error {
+	var err error
+	for _, path := range a {
+		if rerr := os.Rename(path+asideIndexSuffix, path); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// discard removes the old index once the index job is queued
+func (a asideIndex) discard() error {
+	var err error
+	for _, path := range a {
+		if rerr := os.Remove(path + asideIndexSuffix); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
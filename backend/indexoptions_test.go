@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndexParameters(t *testing.T) {
+	var config ConfigRoot
+	if got := indexParameters(Params{}, config); len(got) != 0 {
+		t.Errorf("expected no parameters, got %v", got)
+	}
+
+	config.Worker.Index = &IndexOptions{SplitMemoryLimit: "100G"}
+	if got := indexParameters(Params{}, config); !reflect.DeepEqual(got, []string{"--split-memory-limit", "100G"}) {
+		t.Errorf("unexpected worker default parameters %v", got)
+	}
+
+	params := Params{IndexOptions: &IndexOptions{Split: 4, ExcludeHeaders: true, SearchType: 3}}
+	expected := []string{"--split", "4", "--index-subset", "1", "--search-type", "3"}
+	if got := indexParameters(params, config); !reflect.DeepEqual(got, expected) {
+		t.Errorf("indexParameters = %v, expected %v", got, expected)
+	}
+}
+
+func TestParseIndexOptions(t *testing.T) {
+	req := httptest.NewRequest("POST", "/database?split=2&splitmemorylimit=20G", nil)
+	options, err := parseIndexOptions(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(options, &IndexOptions{Split: 2, SplitMemoryLimit: "20G"}) {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	req = httptest.NewRequest("POST", "/database?splitmemorylimit=lots", nil)
+	if _, err := parseIndexOptions(req); err == nil {
+		t.Error("expected an error for an invalid memory limit")
+	}
+
+	req = httptest.NewRequest("POST", "/database", nil)
+	if options, err := parseIndexOptions(req); options != nil || err != nil {
+		t.Errorf("expected no options, got %+v %v", options, err)
+	}
+}
+
+func TestSetAsideIndex(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"db.idx", "db.idx.index", "db.idx.dbtype"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if indexRebuildable(filepath.Join(dir, "db"), ConfigRoot{}) {
+		t.Error("a database without sources can not be indexed again")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "db.fasta"), []byte(">a\nMKV\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !indexRebuildable(filepath.Join(dir, "db"), ConfigRoot{}) {
+		t.Error("a database with its FASTA file can be indexed again")
+	}
+
+	aside, err := setAsideIndex(dir, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Join(dir, "db.idx")) || len(aside) != 3 {
+		t.Fatalf("index was not moved aside %v", aside)
+	}
+	if err := aside.restore(); err != nil || !fileExists(filepath.Join(dir, "db.idx.index")) {
+		t.Fatalf("index was not restored %v", err)
+	}
+	if aside, err = setAsideIndex(dir, "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := aside.discard(); err != nil {
+		t.Fatal(err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "db.idx*")); len(matches) != 0 {
+		t.Errorf("old index was not removed %v", matches)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// intermediate files are kept inside the result directory of the job, paths.temporary belongs to the worker
+// and the files are encrypted and removed together with the results
+const intermediatesDir = "intermediates"
+
+// jobIntermediatesDir is where the scratch directory of a finished job is kept if worker.intermediates is configured
+func jobIntermediatesDir(config ConfigRoot, id Id) string {
+	return filepath.Join(filepath.Clean(config.Paths.Results), string(id), intermediatesDir)
+}
+
+// keepIntermediates moves the scratch directory of a job into its result directory,
+// it is copied if paths.temporary is on another file system
+func keepIntermediates(config ConfigRoot, id Id, tempDir string) error {
+	dir := jobIntermediatesDir(config, id)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(tempDir, dir); err != nil {
+		if err := copyTree(tempDir, dir); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		if err := os.RemoveAll(tempDir); err != nil {
+			return err
+		}
+	}
+	// the age is counted from the end of the job
+	now := time.Now()
+	return os.Chtimes(dir, now, now)
+}
+
+// copyTree copies the regular files and directories below source to destination
+func copyTree(source string, destination string) error {
+	return filepath.WalkDir(source, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, rel)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		return copyResultFile(path, target)
+	})
+}
+
+// IntermediateFile is a file of the kept scratch directory of a job, path is relative to it
+type IntermediateFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+func ListIntermediates(config ConfigRoot, id Id) ([]IntermediateFile, error) {
+	dir := jobIntermediatesDir(config, id)
+	files := make([]IntermediateFile, 0)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		// encrypted files are listed by their name, the size is the one of the sealed file
+		files = append(files, IntermediateFile{filepath.ToSlash(strings.TrimSuffix(rel, sealedSuffix)), info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+// intermediatePath resolves a path of ListIntermediates, paths leaving the directory are rejected
+func intermediatePath(config ConfigRoot, id Id, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.New("invalid intermediate file " + name)
+	}
+	return filepath.Join(jobIntermediatesDir(config, id), clean), nil
+}
+
+// SweepIntermediates removes the kept scratch directories older than maxAge
+func SweepIntermediates(config ConfigRoot, maxAge time.Duration) {
+	dirs, err := filepath.Glob(filepath.Join(filepath.Clean(config.Paths.Results), "*", intermediatesDir))
+	if err != nil {
+		cleanupLog.Error("Failed to sweep intermediate files", "error", err)
+		return
+	}
+
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		cleanupLog.Debug("Removing intermediate files", "path", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			cleanupLog.Error("Failed to remove intermediate files", "path", dir, "error", err)
+		}
+	}
+}
+
+func intermediatesSweeper(config ConfigRoot) {
+	maxAge, err := time.ParseDuration(config.Worker.Intermediates.MaxAge)
+	if err != nil || maxAge <= 0 {
+		cleanupLog.Warn("Invalid worker.intermediates.maxage, intermediate files are not removed", "maxage", config.Worker.Intermediates.MaxAge)
+		return
+	}
+
+	for {
+		SweepIntermediates(config, maxAge)
+		time.Sleep(1 * time.Hour)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepIntermediates(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Temporary = t.TempDir()
+	config.Paths.Results = t.TempDir()
+	id := Id("job")
+	tempDir := jobTempDir(config, id)
+	if err := os.MkdirAll(filepath.Join(tempDir, "0", "latest"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "0", "latest", "pref"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := keepIntermediates(config, id, tempDir); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(tempDir) || !strings.HasPrefix(jobIntermediatesDir(config, id), config.Paths.Results) {
+		t.Error("intermediate files should be moved into the result directory")
+	}
+	files, err := ListIntermediates(config, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "0/latest/pref" || files[0].Size != 3 {
+		t.Errorf("unexpected files %v", files)
+	}
+	if _, err := intermediatePath(config, id, "../job.json"); err == nil {
+		t.Error("paths outside of the directory should be rejected")
+	}
+
+	SweepIntermediates(config, time.Hour)
+	if !fileExists(jobIntermediatesDir(config, id)) {
+		t.Fatal("recent intermediate files should be kept")
+	}
+	SweepIntermediates(config, 0)
+	if fileExists(jobIntermediatesDir(config, id)) {
+		t.Error("expired intermediate files should be removed")
+	}
+}
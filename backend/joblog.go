@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// streamJobLog sends the log of a job to the client while it is written, until the job has finished or the client disconnects.
+// With sse every line is sent as a server-sent event and an "end" event with the final status is sent last,
+// otherwise the log is sent as chunked plain text.
+func streamJobLog(ctx context.Context, w io.Writer, path string, status func() Status, sse bool, poll time.Duration) error {
+	flusher, _ := w.(http.Flusher)
+	send := func(line []byte) error {
+		var err error
+		if sse {
+			_, err = io.WriteString(w, "data: "+string(bytes.TrimRight(line, "\r\n"))+"\n\n")
+		} else {
+			_, err = w.Write(line)
+		}
+		return err
+	}
+
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	var pending []byte
+	buffer := make([]byte, 32*1024)
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		// the status has to be checked before reading, otherwise the last lines of a finished job could be missed
+		current := status()
+		finished := current != StatusPending && current != StatusRunning
+
+		if file == nil {
+			f, err := os.Open(path)
+			if err == nil {
+				file = f
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if file != nil {
+			for {
+				n, err := file.Read(buffer)
+				pending = append(pending, buffer[:n]...)
+				for {
+					i := bytes.IndexByte(pending, '\n')
+					if i == -1 {
+						break
+					}
+					if err := send(pending[:i+1]); err != nil {
+						return err
+					}
+					pending = pending[i+1:]
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if finished {
+			if len(pending) > 0 {
+				if err := send(pending); err != nil {
+					return err
+				}
+			}
+			if sse {
+				if _, err := io.WriteString(w, "event: end\ndata: "+string(current)+"\n\n"); err != nil {
+					return err
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
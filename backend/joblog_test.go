@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStreamJobLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	if err := os.WriteFile(path, []byte("createdb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	status := func() Status {
+		calls++
+		switch calls {
+		case 1:
+			return StatusRunning
+		case 2:
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString("search\npartial")
+			f.Close()
+			return StatusRunning
+		default:
+			return StatusComplete
+		}
+	}
+
+	var out bytes.Buffer
+	if err := streamJobLog(context.Background(), &out, path, status, true, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	expected := "data: createdb\n\ndata: search\n\ndata: partial\n\nevent: end\ndata: COMPLETE\n\n"
+	if out.String() != expected {
+		t.Errorf("got %q, want %q", out.String(), expected)
+	}
+
+	out.Reset()
+	if err := streamJobLog(context.Background(), &out, path, func() Status { return StatusError }, false, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "createdb\nsearch\npartial" {
+		t.Errorf("got %q", out.String())
+	}
+}
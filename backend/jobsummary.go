@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// kept in paths.results next to job.json, also when the results are moved into a result store
+const jobSummaryFile = "job.summary.json"
+
+// JobSummary is written when a job finished successfully
+type JobSummary struct {
+	Queries         int64 `json:"queries"`
+	QueriesWithHits int64 `json:"queriesWithHits"`
+	Hits            int64 `json:"hits"`
+	// lowest E-value of the hits of each query, keyed by the query identifier
+	BestEvalues map[string]float64 `json:"bestEvalues,omitempty"`
+	// hits with the lowest E-values over all databases, at most maxSummaryHits
+	TopHits []SummaryHit `json:"topHits,omitempty"`
+	// in seconds
+	Runtime float64            `json:"runtime"`
+	Stages  map[string]float64 `json:"stages,omitempty"`
+}
+
+// enough for the mail attachment, the summary is read for every ticket
+const maxSummaryHits = 100
+
+type SummaryHit struct {
+	Query    string  `json:"query"`
+	Target   string  `json:"target"`
+	Database string  `json:"database"`
+	Identity float64 `json:"identity"`
+	Evalue   float64 `json:"evalue"`
+	Bits     float64 `json:"bits"`
+}
+
+func keepTopHits(hits []SummaryHit) []SummaryHit {
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Evalue != hits[j].Evalue {
+			return hits[i].Evalue < hits[j].Evalue
+		}
+		return hits[i].Bits > hits[j].Bits
+	})
+	if len(hits) > maxSummaryHits {
+		hits = hits[:maxSummaryHits]
+	}
+	return hits
+}
+
+// SummarizeResults counts the hits of a job, jobs without alignment results have an empty summary
+func SummarizeResults(base string, request JobRequest) (JobSummary, error) {
+	summary := JobSummary{BestEvalues: make(map[string]float64)}
+	databases, foldseek, err := resultDatabases(request)
+	if err != nil {
+		return summary, nil
+	}
+	columns := resultColumns(foldseek)
+	withHits := make(map[uint32]bool)
+	reader := Reader[uint32]{}
+	for _, database := range databases {
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return summary, err
+		}
+		if reader.Size() > summary.Queries {
+			summary.Queries = reader.Size()
+		}
+		for i := int64(0); i < reader.Size(); i++ {
+			err := reader.ScanLines(i, func(line string) error {
+				fields := strings.Split(line, "\t")
+				if len(fields) <= columns.Evalue {
+					return nil
+				}
+				summary.Hits++
+				key, _ := reader.Key(i)
+				withHits[key] = true
+				evalue, err := strconv.ParseFloat(fields[columns.Evalue], 64)
+				if err != nil {
+					return nil
+				}
+				if best, ok := summary.BestEvalues[fields[0]]; !ok || evalue < best {
+					summary.BestEvalues[fields[0]] = evalue
+				}
+				identity, _ := strconv.ParseFloat(fields[2], 64)
+				var bits float64
+				if len(fields) > columns.Bits {
+					bits, _ = strconv.ParseFloat(fields[columns.Bits], 64)
+				}
+				summary.TopHits = append(summary.TopHits, SummaryHit{fields[0], fields[1], database, identity, evalue, bits})
+				if len(summary.TopHits) >= 2*maxSummaryHits {
+					summary.TopHits = keepTopHits(summary.TopHits)
+				}
+				return nil
+			})
+			if err != nil {
+				reader.Delete()
+				return summary, err
+			}
+		}
+		reader.Delete()
+	}
+	summary.QueriesWithHits = int64(len(withHits))
+	summary.TopHits = keepTopHits(summary.TopHits)
+	return summary, nil
+}
+
+func writeJobSummary(config ConfigRoot, request JobRequest, stages map[string]time.Duration, runtime time.Duration) error {
+	base := filepath.Join(filepath.Clean(config.Paths.Results), string(request.Id))
+	summary, err := SummarizeResults(base, request)
+	if err != nil {
+		return err
+	}
+	summary.Runtime = runtime.Seconds()
+	if len(stages) > 0 {
+		summary.Stages = make(map[string]float64, len(stages))
+		for stage, runtime := range stages {
+			summary.Stages[stage] = runtime.Seconds()
+		}
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(base, jobSummaryFile), data, 0644)
+}
+
+func readJobSummary(base string) (JobSummary, error) {
+	var summary JobSummary
+	data, err := os.ReadFile(filepath.Join(base, jobSummaryFile))
+	if err != nil {
+		return summary, err
+	}
+	err = json.Unmarshal(data, &summary)
+	return summary, err
+}
+
+// Text is the summary line of notification emails
+func (s JobSummary) Text() string {
+	runtime := time.Duration(s.Runtime * float64(time.Second)).Round(time.Second)
+	if s.Queries == 0 {
+		return fmt.Sprintf("The job finished in %s.", runtime)
+	}
+	return fmt.Sprintf("%d of %d queries have hits, %d hits in total. The job finished in %s.", s.QueriesWithHits, s.Queries, s.Hits, runtime)
+}
+
+const mailTableHits = 10
+
+const defaultAttachmentSize = 64 * 1024
+
+// HitsTable is the table of the best hits in notification emails
+func (s JobSummary) HitsTable() string {
+	if len(s.TopHits) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Query\tTarget\tDatabase\tIdentity\tE-value\tBits")
+	for i, hit := range s.TopHits {
+		if i == mailTableHits {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\t%.2g\t%.0f\n", hit.Query, hit.Target, hit.Database, hit.Identity, hit.Evalue, hit.Bits)
+	}
+	w.Flush()
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// hitsAttachment is a TSV of the best hits, rows are left out above maxSize bytes
+func (s JobSummary) hitsAttachment(id Id, hits int, maxSize int) (MailAttachment, bool) {
+	if len(s.TopHits) == 0 {
+		return MailAttachment{}, false
+	}
+	var b bytes.Buffer
+	b.WriteString("query\ttarget\tdatabase\tpident\tevalue\tbits\n")
+	for i, hit := range s.TopHits {
+		if i == hits {
+			break
+		}
+		row := fmt.Sprintf("%s\t%s\t%s\t%g\t%g\t%g\n", hit.Query, hit.Target, hit.Database, hit.Identity, hit.Evalue, hit.Bits)
+		if b.Len()+len(row) > maxSize {
+			break
+		}
+		b.WriteString(row)
+	}
+	return MailAttachment{"tophits-" + string(id) + ".tsv", "text/tab-separated-values", b.Bytes()}, true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeResults(t *testing.T) {
+	dir := t.TempDir()
+	data := "q1\tA\t90.0\t100\t10\t0\t1\t100\t1\t100\t1E-30\t120\t100\t100\tAAA\tAAA\nq1\tB\t40.0\t100\t60\t0\t1\t100\t1\t100\t1E-5\t40\t100\t100\tAAA\tAAA\n\x00\x00"
+	// the second query has no hits
+	end := strconv.Itoa(len(data) - 1)
+	files := map[string]string{
+		"alis_db":       data,
+		"alis_db.index": "0\t0\t" + end + "\n1\t" + end + "\t1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summary, err := SummarizeResults(dir, JobRequest{Type: JobSearch, Job: SearchJob{Database: []string{"db"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Queries != 2 || summary.QueriesWithHits != 1 || summary.Hits != 2 || summary.BestEvalues["q1"] != 1e-30 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if expected := "1 of 2 queries have hits, 2 hits in total. The job finished in 0s."; summary.Text() != expected {
+		t.Errorf("unexpected text %q", summary.Text())
+	}
+	if len(summary.TopHits) != 2 || summary.TopHits[0].Target != "A" || summary.TopHits[0].Bits != 120 || summary.TopHits[1].Identity != 40 {
+		t.Errorf("unexpected top hits %+v", summary.TopHits)
+	}
+	if table := summary.HitsTable(); !strings.HasPrefix(table, "Query") || strings.Count(table, "\n") != 2 {
+		t.Errorf("unexpected hits table %q", table)
+	}
+
+	attachment, ok := summary.hitsAttachment("abc", 100, 80)
+	if !ok || !strings.HasPrefix(string(attachment.Data), "query\t") || strings.Count(string(attachment.Data), "\n") != 2 {
+		t.Errorf("attachment is not capped %q", attachment.Data)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"sync"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/go-redis/redis"
@@ -25,6 +26,7 @@ const (
 	JobStructureSearch JobType = "structuresearch"
 	JobComplexSearch   JobType = "complexsearch"
 	JobFoldMasonMSA    JobType = "foldmasoneasymsa"
+	JobCluster         JobType = "cluster"
 )
 
 type JobRequest struct {
@@ -33,10 +35,38 @@ type JobRequest struct {
 	Type   JobType     `json:"type" validate:"required"`
 	Job    interface{} `json:"job" validate:"required"`
 	Email  string      `json:"email" validate:"omitempty,email"`
+	Error  *JobError   `json:"error,omitempty"`
+	// chat webhook that replaces mail.webhook for this job
+	Webhook string `json:"webhook,omitempty" validate:"omitempty,url"`
+	// channels chosen at submission, email and chat for the given addresses if empty
+	Notify   []NotifyChannel `json:"notify,omitempty"`
+	NotifyOn NotifyEvents    `json:"notifyon,omitempty"`
+	// recipients of the channels of registered notifiers
+	Recipients map[NotifyChannel]string `json:"recipients,omitempty"`
+	// language of the notifications, like de or pt-BR
+	Locale string `json:"locale,omitempty"`
+	// unix time of the submission, set by NewJob
+	Submitted int64 `json:"submitted,omitempty"`
 }
 
 type jobRequest JobRequest
 
+type PriorityClass string
+
+const (
+	PriorityInteractive PriorityClass = "interactive"
+	PriorityBatch       PriorityClass = "batch"
+)
+
+// Priority classifies jobs with a rank above the threshold as batch jobs, a threshold of 0 disables batch jobs
+func (m *JobRequest) Priority(threshold float64) PriorityClass {
+	job, ok := m.Job.(Job)
+	if !ok || threshold <= 0 || job.Rank() <= threshold {
+		return PriorityInteractive
+	}
+	return PriorityBatch
+}
+
 func (m *JobRequest) UnmarshalJSON(b []byte) error {
 	var msg json.RawMessage
 	var jr jobRequest
@@ -96,6 +126,13 @@ func (m *JobRequest) UnmarshalJSON(b []byte) error {
 		}
 		(*m).Job = j
 		return nil
+	case JobCluster:
+		var j ClusterJob
+		if err := json.Unmarshal(msg, &j); err != nil {
+			return err
+		}
+		(*m).Job = j
+		return nil
 	}
 
 	return errors.New("invalid job type")
@@ -135,6 +172,11 @@ func (m *JobRequest) WriteSupportFiles(base string) error {
 			return j.WritePDB(filepath.Join(base))
 		}
 		return errors.New("invalid job type")
+	case JobCluster:
+		if j, ok := m.Job.(ClusterJob); ok {
+			return j.WriteFasta(filepath.Join(base, "job.fasta"))
+		}
+		return errors.New("invalid job type")
 	}
 	return nil
 }
@@ -152,6 +194,7 @@ const (
 	StatusRunning  Status = "RUNNING"
 	StatusComplete Status = "COMPLETE"
 	StatusError    Status = "ERROR"
+	StatusTimeout  Status = "TIMEOUT"
 	StatusUnknown  Status = "UNKNOWN"
 )
 
@@ -169,8 +212,9 @@ func (e *Status) UnmarshalJSON(b []byte) error {
 }
 
 type Ticket struct {
-	Id        Id     `json:"id"`
-	RawStatus Status `json:"status"`
+	Id        Id        `json:"id"`
+	RawStatus Status    `json:"status"`
+	Error     *JobError `json:"error,omitempty"`
 }
 
 var validId = regexp.MustCompile(`^[A-Za-z0-9-_=]{38}$`).MatchString
@@ -181,12 +225,15 @@ func (t Ticket) Valid() bool {
 
 type JobSystem interface {
 	SetStatus(Id, Status) error
+	SetError(Id, ErrorCode, string) error
 	Status(Id) (Status, error)
 	GetTicket(Id) (Ticket, error)
 	NewJob(JobRequest, string, bool) (Ticket, error)
 	MultiStatus([]string) ([]Ticket, error)
 	Dequeue() (*Ticket, error)
 	QueueLength() (int, error)
+	// Requeue puts an interrupted job back into the queue
+	Requeue(Id) error
 }
 
 type BaseJobSystem struct {
@@ -235,7 +282,7 @@ func MakeRedisJobSystem(config ConfigRedis, results string, checkOld bool) (*Red
 		if err != nil {
 			continue
 		}
-		if job.Status != StatusComplete {
+		if job.Status != StatusComplete && job.Status != StatusTimeout {
 			job.Status = StatusError
 		}
 		jobsystem.SetStatus(job.Id, job.Status)
@@ -248,7 +295,7 @@ func (j *RedisJobSystem) NewJob(request JobRequest, jobsbase string, allowResubm
 	id := request.Id
 	res, err := j.Status(id)
 	if err != nil {
-		return Ticket{id, StatusError}, err
+		return Ticket{id, StatusError, nil}, err
 	}
 
 	workdir := filepath.Join(jobsbase, string(id))
@@ -259,33 +306,35 @@ func (j *RedisJobSystem) NewJob(request JobRequest, jobsbase string, allowResubm
 			os.RemoveAll(workdir)
 			break
 		} else {
-			return Ticket{id, res}, nil
+			touchJobFile(workdir)
+			return Ticket{id, res, nil}, nil
 		}
 	case StatusPending, StatusRunning:
-		return Ticket{id, res}, nil
-	case StatusError:
+		return Ticket{id, res, nil}, nil
+	case StatusError, StatusTimeout:
 		os.RemoveAll(workdir)
 	}
 
 	if _, err := os.Stat(workdir); os.IsNotExist(err) {
 		err = os.Mkdir(workdir, 0755)
 		if err != nil {
-			return Ticket{id, StatusError}, err
+			return Ticket{id, StatusError, nil}, err
 		}
 	}
 
 	job, ok := request.Job.(Job)
 	if !ok {
-		return Ticket{id, StatusError}, errors.New("invalid job")
+		return Ticket{id, StatusError, nil}, errors.New("invalid job")
 	}
 
-	t := Ticket{id, StatusPending}
+	t := Ticket{id, StatusPending, nil}
 	err = j.Client.Watch(func(tx *redis.Tx) error {
 		err := request.WriteSupportFiles(workdir)
 		if err != nil {
 			return err
 		}
 
+		request.Submitted = time.Now().Unix()
 		file, err := os.Create(filepath.Join(workdir, "job.json"))
 		if err != nil {
 			return err
@@ -352,6 +401,23 @@ func (j *RedisJobSystem) Dequeue() (*Ticket, error) {
 	return &ticket, nil
 }
 
+func (j *RedisJobSystem) Requeue(id Id) error {
+	request, err := getJobRequestFromFile(j.getJobFileName(id))
+	if err != nil {
+		return err
+	}
+	job, ok := request.Job.(Job)
+	if !ok {
+		return errors.New("invalid job")
+	}
+	err = j.SetStatus(id, StatusPending)
+	if err != nil {
+		return err
+	}
+	_, err = j.Client.ZAdd("mmseqs:pending", redis.Z{Score: job.Rank(), Member: string(id)}).Result()
+	return err
+}
+
 func (j *RedisJobSystem) QueueLength() (int, error) {
 	length, err := j.Client.ZCount("mmseqs:pending", "-inf", "+inf").Result()
 	if err != nil {
@@ -424,7 +490,7 @@ func (j *BaseJobSystem) getJobFileName(id Id) string {
 	return filepath.Join(filepath.Clean(j.Results), string(id), "job.json")
 }
 
-func setStatusInJobFile(file string, status Status) error {
+func setStatusInJobFile(file string, status Status, jobErr *JobError) error {
 	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return err
@@ -438,6 +504,7 @@ func setStatusInJobFile(file string, status Status) error {
 	}
 
 	job.Status = status
+	job.Error = jobErr
 
 	f.Truncate(0)
 	f.Seek(0, io.SeekStart)
@@ -461,23 +528,23 @@ func setStatusInJobFile(file string, status Status) error {
 	return nil
 }
 
-func getStatusFromJobFile(file string) (Status, error) {
+func getStatusFromJobFile(file string) (Status, *JobError, error) {
 	f, err := os.OpenFile(file, os.O_RDONLY, 0644)
 	if errors.Is(err, os.ErrNotExist) {
-		return StatusUnknown, nil
+		return StatusUnknown, nil, nil
 	} else if err != nil {
-		return StatusError, err
+		return StatusError, nil, err
 	}
 
 	var job JobRequest
 	err = DecodeJsonAndValidate(f, &job)
 	if err != nil {
 		f.Close()
-		return StatusError, err
+		return StatusError, nil, err
 	}
 
 	f.Close()
-	return job.Status, nil
+	return job.Status, job.Error, nil
 }
 
 func getJobRequestFromFile(file string) (JobRequest, error) {
@@ -499,7 +566,26 @@ func getJobRequestFromFile(file string) (JobRequest, error) {
 func (j *BaseJobSystem) SetStatus(id Id, status Status) error {
 	file := j.getJobFileName(id)
 	j.StatusMutex.Lock()
-	err := setStatusInJobFile(file, status)
+	// failures without a known reason are reported as internal errors
+	var jobErr *JobError
+	if status == StatusError {
+		jobErr = NewJobError(ErrorInternal, "")
+	} else if status == StatusTimeout {
+		jobErr = NewJobError(ErrorTimeout, "")
+	}
+	err := setStatusInJobFile(file, status, jobErr)
+	j.StatusMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetError marks a job as failed and keeps a reason that is reported with the ticket, an empty message uses the one of the code
+func (j *BaseJobSystem) SetError(id Id, code ErrorCode, message string) error {
+	file := j.getJobFileName(id)
+	j.StatusMutex.Lock()
+	err := setStatusInJobFile(file, errorStatus(code), NewJobError(code, message))
 	j.StatusMutex.Unlock()
 	if err != nil {
 		return err
@@ -508,23 +594,29 @@ func (j *BaseJobSystem) SetStatus(id Id, status Status) error {
 }
 
 func (j *BaseJobSystem) Status(id Id) (Status, error) {
+	res, _, err := j.statusWithError(id)
+	return res, err
+}
+
+func (j *BaseJobSystem) statusWithError(id Id) (Status, *JobError, error) {
 	file := j.getJobFileName(id)
 	j.StatusMutex.Lock()
-	res, err := getStatusFromJobFile(file)
+	res, jobErr, err := getStatusFromJobFile(file)
 	j.StatusMutex.Unlock()
 	if err != nil {
-		return StatusError, err
+		return StatusError, nil, err
 	}
-	return res, nil
+	return res, jobErr, nil
 }
 
 func (j *BaseJobSystem) GetTicket(id Id) (Ticket, error) {
-	t := Ticket{id, StatusUnknown}
+	t := Ticket{id, StatusUnknown, nil}
 	if !t.Valid() {
 		return t, errors.New("invalid ID")
 	}
-	res, err := j.Status(t.Id)
+	res, jobErr, err := j.statusWithError(t.Id)
 	t.RawStatus = res
+	t.Error = jobErr
 	return t, err
 }
 
@@ -532,12 +624,12 @@ func (j *LocalJobSystem) NewJob(request JobRequest, jobsbase string, allowResubm
 	id := request.Id
 	res, err := j.Status(id)
 	if err != nil {
-		return Ticket{id, StatusError}, err
+		return Ticket{id, StatusError, nil}, err
 	}
 
 	validate := validator.New()
 	if err := validate.Struct(request); err != nil {
-		return Ticket{id, StatusError}, err
+		return Ticket{id, StatusError, nil}, err
 	}
 
 	workdir := filepath.Join(jobsbase, string(id))
@@ -548,42 +640,44 @@ func (j *LocalJobSystem) NewJob(request JobRequest, jobsbase string, allowResubm
 			os.RemoveAll(workdir)
 			break
 		} else {
-			return Ticket{id, res}, nil
+			touchJobFile(workdir)
+			return Ticket{id, res, nil}, nil
 		}
 	case StatusPending, StatusRunning:
-		return Ticket{id, res}, nil
-	case StatusError:
+		return Ticket{id, res, nil}, nil
+	case StatusError, StatusTimeout:
 		os.RemoveAll(workdir)
 	}
 
-	t := Ticket{id, StatusUnknown}
+	t := Ticket{id, StatusUnknown, nil}
 
 	if _, err := os.Stat(workdir); os.IsNotExist(err) {
 		err = os.Mkdir(workdir, 0755)
 		if err != nil {
-			return Ticket{id, StatusError}, err
+			return Ticket{id, StatusError, nil}, err
 		}
 	}
 
 	err = request.WriteSupportFiles(workdir)
 	if err != nil {
-		return Ticket{id, StatusError}, err
+		return Ticket{id, StatusError, nil}, err
 	}
 
+	request.Submitted = time.Now().Unix()
 	file, err := os.Create(filepath.Join(workdir, "job.json"))
 	if err != nil {
-		return Ticket{id, StatusError}, err
+		return Ticket{id, StatusError, nil}, err
 	}
 
 	err = json.NewEncoder(file).Encode(request)
 	if err != nil {
 		file.Close()
-		return Ticket{id, StatusError}, err
+		return Ticket{id, StatusError, nil}, err
 	}
 
 	err = file.Close()
 	if err != nil {
-		return Ticket{id, StatusError}, err
+		return Ticket{id, StatusError, nil}, err
 	}
 
 	j.SetStatus(id, StatusPending)
@@ -608,8 +702,8 @@ func (j *BaseJobSystem) MultiStatus(ids []string) ([]Ticket, error) {
 		if !validId(value) {
 			continue
 		}
-		res, _ := j.Status(Id(value))
-		result = append(result, Ticket{Id(value), res})
+		res, jobErr, _ := j.statusWithError(Id(value))
+		result = append(result, Ticket{Id(value), res, jobErr})
 
 	}
 	return result, nil
@@ -635,6 +729,18 @@ func (j *LocalJobSystem) Dequeue() (*Ticket, error) {
 	return &ticket, nil
 }
 
+func (j *LocalJobSystem) Requeue(id Id) error {
+	err := j.SetStatus(id, StatusPending)
+	if err != nil {
+		return err
+	}
+	j.QueueMutex.Lock()
+	j.Queue = append(j.Queue, id)
+	j.queued += 1
+	j.QueueMutex.Unlock()
+	return nil
+}
+
 func (j *LocalJobSystem) QueueLength() (int, error) {
 	return j.queued, nil
 }
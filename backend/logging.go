@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// loggers of the components, they write to the handler set up from the logging block when they log
+var (
+	serverLog   = componentLogger("server")
+	workerLog   = componentLogger("worker")
+	executorLog = componentLogger("executor")
+	databaseLog = componentLogger("database")
+	cleanupLog  = componentLogger("cleanup")
+	notifyLog   = componentLogger("notify")
+	alertLog    = componentLogger("alerts")
+)
+
+// accessLog writes the request records of logging.access, nil if it is not configured
+var accessLog *slog.Logger
+
+// componentHandler adds its attributes to the records of the current default handler,
+// so package level loggers follow setupLogging
+type componentHandler struct {
+	attrs []slog.Attr
+}
+
+func componentLogger(component string) *slog.Logger {
+	return slog.New(componentHandler{[]slog.Attr{slog.String("component", component)}})
+}
+
+func (h componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Enabled(ctx, level)
+}
+
+func (h componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return slog.Default().Handler().WithAttrs(h.attrs).Handle(ctx, record)
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return componentHandler{append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	return slog.Default().Handler().WithAttrs(h.attrs).WithGroup(name)
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	var parsed slog.Level
+	err := parsed.UnmarshalText([]byte(level))
+	return parsed, err
+}
+
+// newLogHandler writes the records of the configured level and above as text or JSON lines,
+// the deprecated verbose flag lowers the level to debug
+func newLogHandler(config ConfigRoot, w io.Writer) (slog.Handler, error) {
+	level := slog.LevelInfo
+	if config.Logging.Level != "" {
+		var err error
+		if level, err = parseLogLevel(config.Logging.Level); err != nil {
+			return nil, err
+		}
+	} else if config.Verbose {
+		level = slog.LevelDebug
+	}
+	return newFormatHandler(config.Logging, w, level)
+}
+
+func newFormatHandler(config ConfigLogging, w io.Writer, level slog.Level) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(config.Format) {
+	case "", LogFormatText:
+		return slog.NewTextHandler(w, options), nil
+	case LogFormatJson:
+		return slog.NewJSONHandler(w, options), nil
+	default:
+		return nil, errors.New("unknown log format " + config.Format)
+	}
+}
+
+// setupLogging makes the configured handler the default, the standard logger writes to it at info level,
+// {process} in logging.file is replaced by the kind of this process, since each process needs its own file
+func setupLogging(config ConfigRoot, process string) error {
+	var w io.Writer = os.Stderr
+	if config.Logging.File != "" {
+		file, err := openRotatingFile(strings.ReplaceAll(config.Logging.File, "{process}", process), config.Logging.Rotate)
+		if err != nil {
+			return err
+		}
+		w = file
+	}
+	handler, err := newLogHandler(config, w)
+	if err != nil {
+		return err
+	}
+	if config.Logging.Access != "" {
+		file, err := openRotatingFile(config.Logging.Access, config.Logging.Rotate)
+		if err != nil {
+			return err
+		}
+		access, err := newFormatHandler(config.Logging, file, slog.LevelInfo)
+		if err != nil {
+			return err
+		}
+		accessLog = slog.New(access)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// debugLogging tells if debug records are logged, tools then also print their output
+func debugLogging() bool {
+	return slog.Default().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// logRequests writes the access log, to logging.access or at debug level to the log
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		logger, level := accessLog, slog.LevelInfo
+		if logger == nil {
+			logger, level = serverLog, slog.LevelDebug
+		}
+		if !logger.Enabled(req.Context(), level) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		metrics := httpsnoop.CaptureMetrics(h, w, req)
+		logger.Log(req.Context(), level, "Request", "method", req.Method, "path", req.URL.Path, "status", metrics.Code, "bytes", metrics.Written, "duration", metrics.Duration, "remote", req.RemoteAddr)
+	})
+}
+
+// logFatal logs err and exits
+func logFatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	var buffer bytes.Buffer
+	handler, err := newLogHandler(ConfigRoot{Logging: ConfigLogging{Level: "warn", Format: LogFormatJson}}, &buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	defer slog.SetDefault(previous)
+
+	workerLog.Info("Job started", "ticket", "abc")
+	workerLog.Error("Job failed", "ticket", "abc")
+	var record map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		t.Fatalf("expected one JSON record: %s %q", err, buffer.String())
+	}
+	if record["level"] != "ERROR" || record["component"] != "worker" || record["ticket"] != "abc" || record["msg"] != "Job failed" {
+		t.Errorf("unexpected record %v", record)
+	}
+	if debugLogging() {
+		t.Error("debug records are not logged at warn level")
+	}
+
+	buffer.Reset()
+	if handler, err = newLogHandler(ConfigRoot{Verbose: true}, &buffer); err != nil {
+		t.Fatal(err)
+	}
+	slog.SetDefault(slog.New(handler))
+	serverLog.With("database", "db").Debug("Swapped")
+	if line := buffer.String(); !strings.Contains(line, "level=DEBUG") || !strings.Contains(line, "component=server database=db") {
+		t.Errorf("unexpected text record %q", line)
+	}
+
+	if _, err := newLogHandler(ConfigRoot{Logging: ConfigLogging{Format: "xml"}}, &buffer); err == nil {
+		t.Error("unknown formats are rejected")
+	}
+}
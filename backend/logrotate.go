@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix sorts rotated files by their age
+const rotatedSuffix = "2006-01-02T15-04-05.000000000"
+
+// rotatingFile is a log file that is renamed to <path>.<time> once it reaches its size or age,
+// only one process may write to a path
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	interval time.Duration
+	keep     int
+	maxAge   time.Duration
+
+	file   *os.File
+	size   int64
+	opened time.Time
+	// no rotation is tried before this after a failed one
+	retry time.Time
+}
+
+func openRotatingFile(path string, config ConfigLogRotation) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, keep: config.Keep}
+	var err error
+	if config.MaxSize != "" {
+		size, err := parseByteSize(config.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		f.maxSize = int64(size)
+	}
+	if config.Interval != "" {
+		if f.interval, err = time.ParseDuration(config.Interval); err != nil {
+			return nil, err
+		}
+	}
+	if config.MaxAge != "" {
+		if f.maxAge, err = time.ParseDuration(config.MaxAge); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	// a file kept from before a restart is as old as its last write
+	f.opened = time.Now()
+	if info.Size() > 0 {
+		f.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	full := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	old := f.interval > 0 && time.Since(f.opened) >= f.interval
+	if (full || old) && time.Now().After(f.retry) {
+		// a failed rotation keeps writing to the reopened file
+		if err := f.rotate(); err != nil && f.file == nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate closes the file before the rename, which Windows needs, and reopens the path also if the rename fails
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	now := time.Now()
+	for fileExists(f.path + "." + now.Format(rotatedSuffix)) {
+		now = now.Add(time.Nanosecond)
+	}
+	renamed := os.Rename(f.path, f.path+"."+now.Format(rotatedSuffix))
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renamed != nil {
+		f.retry = time.Now().Add(time.Minute)
+		return renamed
+	}
+	f.prune()
+	return nil
+}
+
+// prune removes the rotated files beyond keep and those older than maxAge
+func (f *rotatingFile) prune() {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	var rotated []string
+	for _, path := range matches {
+		if _, err := time.Parse(rotatedSuffix, path[len(f.path)+1:]); err == nil {
+			rotated = append(rotated, path)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+	for i, path := range rotated {
+		expired := false
+		if info, err := os.Stat(path); err == nil && f.maxAge > 0 {
+			expired = time.Since(info.ModTime()) > f.maxAge
+		}
+		if (f.keep > 0 && i >= f.keep) || expired {
+			os.Remove(path)
+		}
+	}
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "server.log")
+	f, err := openRotatingFile(path, ConfigLogRotation{MaxSize: "20", Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "fourth line\n" {
+		t.Errorf("unexpected current file %q", current)
+	}
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 kept files, got %v", rotated)
+	}
+	newest, _ := os.ReadFile(rotated[1])
+	if !strings.HasPrefix(string(newest), "third") {
+		t.Errorf("the oldest rotated file was not removed, newest is %q", newest)
+	}
+
+	if _, err := openRotatingFile(path, ConfigLogRotation{Interval: "daily"}); err == nil {
+		t.Error("invalid intervals are rejected")
+	}
+}
+
+func TestRotatingFileKeepsAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := os.WriteFile(path, []byte("old line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRotatingFile(path, ConfigLogRotation{Interval: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("new line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if rotated, _ := filepath.Glob(path + ".*"); len(rotated) != 1 {
+		t.Errorf("expected the file from before the restart to be rotated, got %v", rotated)
+	}
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"os"
+	"errors"
+	"math"
 	"path/filepath"
 	"strings"
 )
@@ -21,7 +22,7 @@ type LookupResponse struct {
 func Lookup(ticketId Id, page uint64, limit uint64, basepath string, shouldGroup bool) (LookupResponse, error) {
 	result := filepath.Join(basepath, string(ticketId), "query.lookup")
 
-	file, err := os.Open(result)
+	file, err := openResultFile(result)
 	if err != nil {
 		return LookupResponse{}, err
 	}
@@ -90,3 +91,32 @@ func Lookup(ticketId Id, page uint64, limit uint64, basepath string, shouldGroup
 
 	return LookupResponse{results, hasNextPage, false}, nil
 }
+
+// QueryKeys resolves a query identifier to its result entry and the keys of its alignments.
+// Complexes are identified by their name without the chain suffix and have the keys of all their chains.
+func QueryKeys(ticketId Id, basepath string, name string) (uint32, []uint32, error) {
+	grouped, err := Lookup(ticketId, 0, math.MaxInt32, basepath, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, res := range grouped.Lookup {
+		if res.Name != name {
+			continue
+		}
+		if !grouped.GroupBySet {
+			return res.Id, []uint32{res.Id}, nil
+		}
+		chains, err := Lookup(ticketId, 0, math.MaxInt32, basepath, false)
+		if err != nil {
+			return 0, nil, err
+		}
+		keys := make([]uint32, 0)
+		for _, chain := range chains.Lookup {
+			if chain.Set == res.Set {
+				keys = append(keys, chain.Id)
+			}
+		}
+		return res.Set, keys, nil
+	}
+	return 0, nil, errors.New("query " + name + " not found")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryKeys(t *testing.T) {
+	base := t.TempDir()
+	write := func(id Id, job string, lookup string) {
+		dir := filepath.Join(base, string(id))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(dir, "job.json"), []byte(job), 0644)
+		os.WriteFile(filepath.Join(dir, "query.lookup"), []byte(lookup), 0644)
+	}
+	search := Id(strings.Repeat("s", 38))
+	write(search, `{"id":"s","status":"COMPLETE","type":"search","job":{}}`, "0\tq1\t0\n1\tq2\t1\n")
+	complex := Id(strings.Repeat("c", 38))
+	write(complex, `{"id":"c","status":"COMPLETE","type":"complexsearch","job":{}}`, "0\tfirst_A\t0\n1\tfirst_B\t0\n2\tsecond_A\t1\n")
+
+	if entry, keys, err := QueryKeys(search, base, "q2"); err != nil || entry != 1 || !reflect.DeepEqual(keys, []uint32{1}) {
+		t.Errorf("unexpected entry %d keys %v: %v", entry, keys, err)
+	}
+	if entry, keys, err := QueryKeys(complex, base, "first"); err != nil || entry != 0 || !reflect.DeepEqual(keys, []uint32{0, 1}) {
+		t.Errorf("unexpected entry %d keys %v: %v", entry, keys, err)
+	}
+	if _, _, err := QueryKeys(search, base, "q3"); err == nil {
+		t.Error("unknown queries should not be found")
+	}
+}
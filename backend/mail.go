@@ -5,4 +5,15 @@ type Mail struct {
 	Recipient string
 	Subject   string
 	Body      string
+	// alternative part, plain text only if empty
+	Html        string
+	Attachments []MailAttachment
+	// one-click unsubscribe link, sent as List-Unsubscribe header
+	Unsubscribe string
+}
+
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
 }
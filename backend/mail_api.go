@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	netmail "net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readSecret returns the content of file if it is set, otherwise value or the environment variable env
+func readSecret(value string, file string, env string) (string, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if value == "" && env != "" {
+		value = os.Getenv(env)
+	}
+	return value, nil
+}
+
+var notificationClient = &http.Client{Timeout: 30 * time.Second}
+
+// postNotification sends a JSON request to a mail API or webhook and fails on anything but a 2xx status
+func postNotification(req *http.Request) error {
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New("sending mail failed with status " + strconv.Itoa(resp.StatusCode) + ": " + strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+type SendgridTransport struct {
+	// defaults to the SENDGRID_API_KEY environment variable
+	ApiKey     string `json:"apikey"`
+	ApiKeyFile string `json:"apikeyfile"`
+	// https://api.eu.sendgrid.com for EU regional subusers
+	Endpoint string `json:"endpoint"`
+}
+
+func (t SendgridTransport) Send(mail Mail) error {
+	key, err := readSecret(t.ApiKey, t.ApiKeyFile, "SENDGRID_API_KEY")
+	if err != nil {
+		return err
+	}
+	sender, err := netmail.ParseAddress(mail.Sender)
+	if err != nil {
+		return err
+	}
+
+	type address struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+	type personalization struct {
+		To []address `json:"to"`
+	}
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	type attachment struct {
+		Content     []byte `json:"content"`
+		Type        string `json:"type"`
+		Filename    string `json:"filename"`
+		Disposition string `json:"disposition"`
+	}
+	type message struct {
+		Personalizations []personalization `json:"personalizations"`
+		From             address           `json:"from"`
+		Subject          string            `json:"subject"`
+		Content          []content         `json:"content"`
+		Attachments      []attachment      `json:"attachments,omitempty"`
+		Headers          map[string]string `json:"headers,omitempty"`
+	}
+	contents := []content{{"text/plain", mail.Body}}
+	if mail.Html != "" {
+		contents = append(contents, content{"text/html", mail.Html})
+	}
+	attachments := make([]attachment, 0, len(mail.Attachments))
+	for _, a := range mail.Attachments {
+		attachments = append(attachments, attachment{a.Data, a.ContentType, a.Name, "attachment"})
+	}
+	var headers map[string]string
+	for _, header := range unsubscribeHeaders(mail) {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[header[0]] = header[1]
+	}
+	body, err := json.Marshal(message{
+		[]personalization{{[]address{{mail.Recipient, ""}}}},
+		address{sender.Address, sender.Name},
+		mail.Subject,
+		contents,
+		attachments,
+		headers,
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := t.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.sendgrid.com"
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+	return postNotification(req)
+}
+
+// SesTransport uses the SendEmail action of the Amazon SES v2 API
+type SesTransport struct {
+	Region string `json:"region"`
+	// default to AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN like the object store
+	AccessKey     string `json:"accesskey"`
+	SecretKey     string `json:"secretkey"`
+	SecretKeyFile string `json:"secretkeyfile"`
+	Endpoint      string `json:"endpoint"`
+	// configuration set for delivery events, optional
+	ConfigurationSet string `json:"configurationset"`
+}
+
+func (t SesTransport) Send(mail Mail) error {
+	return t.send(mail, nil)
+}
+
+// SendRaw sends a complete MIME message, sender and recipient are bare addresses
+func (t SesTransport) SendRaw(sender string, recipient string, message []byte) error {
+	return t.send(Mail{Sender: sender, Recipient: recipient}, message)
+}
+
+// send uses the raw message if it is set
+func (t SesTransport) send(mail Mail, rawMessage []byte) error {
+	region := t.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	accessKey := t.AccessKey
+	secretKey, err := readSecret(t.SecretKey, t.SecretKeyFile, "")
+	if err != nil {
+		return err
+	}
+	sessionToken := ""
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	type text struct {
+		Data    string `json:"Data"`
+		Charset string `json:"Charset"`
+	}
+	type header struct {
+		Name  string `json:"Name"`
+		Value string `json:"Value"`
+	}
+	type simple struct {
+		Subject text `json:"Subject"`
+		Body    struct {
+			Text text  `json:"Text"`
+			Html *text `json:"Html,omitempty"`
+		} `json:"Body"`
+		Headers []header `json:"Headers,omitempty"`
+	}
+	// mails with attachments are sent as MIME message
+	type raw struct {
+		Data []byte `json:"Data"`
+	}
+	type message struct {
+		FromEmailAddress string `json:"FromEmailAddress"`
+		Destination      struct {
+			ToAddresses []string `json:"ToAddresses"`
+		} `json:"Destination"`
+		Content struct {
+			Simple *simple `json:"Simple,omitempty"`
+			Raw    *raw    `json:"Raw,omitempty"`
+		} `json:"Content"`
+		ConfigurationSetName string `json:"ConfigurationSetName,omitempty"`
+	}
+	var m message
+	m.FromEmailAddress = mail.Sender
+	m.Destination.ToAddresses = []string{mail.Recipient}
+	if rawMessage != nil {
+		m.Content.Raw = &raw{rawMessage}
+	} else if len(mail.Attachments) > 0 {
+		sender, err := netmail.ParseAddress(mail.Sender)
+		if err != nil {
+			return err
+		}
+		m.Content.Raw = &raw{smtpMessage(mail, sender.Address)}
+	} else {
+		m.Content.Simple = &simple{Subject: text{mail.Subject, "UTF-8"}}
+		m.Content.Simple.Body.Text = text{mail.Body, "UTF-8"}
+		if mail.Html != "" {
+			m.Content.Simple.Body.Html = &text{mail.Html, "UTF-8"}
+		}
+		for _, field := range unsubscribeHeaders(mail) {
+			m.Content.Simple.Headers = append(m.Content.Simple.Headers, header{field[0], field[1]})
+		}
+	}
+	m.ConfigurationSetName = t.ConfigurationSet
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	endpoint := t.Endpoint
+	if endpoint == "" {
+		endpoint = "https://email." + region + ".amazonaws.com"
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+	signV4Service(req, "ses", region, accessKey, secretKey, sessionToken, time.Now())
+	return postNotification(req)
+}
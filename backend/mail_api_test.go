@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMailApiTransports(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests = append(requests, req)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("SG.secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mail := Mail{Sender: "Webserver <mail@example.org>", Recipient: "user@example.org", Subject: "Done", Body: "body", Html: "<p>body</p>", Unsubscribe: "https://search.example.org/unsubscribe"}
+	if err := (SendgridTransport{"", keyFile, server.URL}).Send(mail); err != nil {
+		t.Fatal(err)
+	}
+	if requests[0].URL.Path != "/v3/mail/send" || requests[0].Header.Get("Authorization") != "Bearer SG.secret" {
+		t.Errorf("unexpected sendgrid request %s %v", requests[0].URL, requests[0].Header)
+	}
+	var message map[string]interface{}
+	if err := json.Unmarshal([]byte(bodies[0]), &message); err != nil {
+		t.Fatal(err)
+	}
+	if from := message["from"].(map[string]interface{}); from["email"] != "mail@example.org" || from["name"] != "Webserver" {
+		t.Errorf("unexpected sender %v", from)
+	}
+	if headers, _ := message["headers"].(map[string]interface{}); headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("missing unsubscribe headers %v", message["headers"])
+	}
+
+	if err := (SesTransport{"eu-central-1", "AKID", "secret", "", server.URL, ""}).Send(mail); err != nil {
+		t.Fatal(err)
+	}
+	if requests[1].URL.Path != "/v2/email/outbound-emails" || !strings.Contains(requests[1].Header.Get("Authorization"), "/eu-central-1/ses/aws4_request") {
+		t.Errorf("unexpected ses request %s %v", requests[1].URL, requests[1].Header)
+	}
+	if !strings.Contains(bodies[1], `"ToAddresses":["user@example.org"]`) || !strings.Contains(bodies[1], `{"Name":"List-Unsubscribe","Value":"\u003chttps://search.example.org/unsubscribe\u003e"}`) {
+		t.Errorf("unexpected ses body %s", bodies[1])
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "invalid key", http.StatusUnauthorized)
+	}))
+	defer failing.Close()
+	if err := (SendgridTransport{"key", "", failing.URL}).Send(mail); err == nil || !strings.Contains(err.Error(), "invalid key") {
+		t.Errorf("failed request was not reported: %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// STARTTLS if the server offers it, otherwise unencrypted
+	SmtpSecurityAuto = ""
+	// fails if the server does not offer STARTTLS
+	SmtpSecurityStartTls = "starttls"
+	// implicit TLS, usually on port 465
+	SmtpSecurityTls  = "tls"
+	SmtpSecurityNone = "none"
+)
+
+const defaultSmtpTimeout = 30 * time.Second
+
+type SmtpAuth struct {
+	Identity string `json:"identity"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// host name checked against the server, uses the host of the transport if empty
+	Host string `json:"host"`
+}
+
+type SmtpTransport struct {
+	// older configs give the port as part of the host
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Security string `json:"security"`
+	// skips the certificate verification for relays with self-signed certificates
+	Insecure bool `json:"insecure"`
+	// for connecting and the whole session, 30s if empty
+	Timeout string `json:"timeout"`
+	// name sent with EHLO, the default is localhost
+	LocalName string `json:"localname"`
+	// no authentication without a username
+	Auth SmtpAuth `json:"auth"`
+}
+
+func (t SmtpTransport) address() (string, string, error) {
+	host, port, err := net.SplitHostPort(t.Host)
+	if err != nil {
+		host = t.Host
+		port = ""
+	}
+	if t.Port != 0 {
+		port = strconv.Itoa(t.Port)
+	}
+	if port == "" {
+		port = "25"
+		if t.Security == SmtpSecurityTls {
+			port = "465"
+		}
+	}
+	if host == "" {
+		return "", "", errors.New("smtp host is not configured")
+	}
+	return net.JoinHostPort(host, port), host, nil
+}
+
+func (t SmtpTransport) Send(mail Mail) error {
+	sender, err := netmail.ParseAddress(mail.Sender)
+	if err != nil {
+		return err
+	}
+	recipient, err := netmail.ParseAddress(mail.Recipient)
+	if err != nil {
+		return err
+	}
+	return t.SendRaw(sender.Address, recipient.Address, smtpMessage(mail, sender.Address))
+}
+
+// SendRaw sends a complete MIME message, sender and recipient are bare addresses
+func (t SmtpTransport) SendRaw(sender string, recipient string, message []byte) error {
+	address, host, err := t.address()
+	if err != nil {
+		return err
+	}
+	timeout := defaultSmtpTimeout
+	if t.Timeout != "" {
+		if timeout, err = time.ParseDuration(t.Timeout); err != nil {
+			return err
+		}
+	}
+
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: t.Insecure}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch t.Security {
+	case SmtpSecurityTls:
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	case SmtpSecurityAuto, SmtpSecurityStartTls, SmtpSecurityNone:
+		conn, err = dialer.Dial("tcp", address)
+	default:
+		return errors.New("invalid smtp security " + t.Security)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if t.LocalName != "" {
+		if err := client.Hello(t.LocalName); err != nil {
+			return err
+		}
+	}
+	if t.Security == SmtpSecurityAuto || t.Security == SmtpSecurityStartTls {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if t.Security == SmtpSecurityStartTls {
+			return errors.New("smtp server " + address + " does not support STARTTLS")
+		}
+	}
+	// PlainAuth refuses to send the password over an unencrypted connection to anything but localhost
+	if t.Auth.Username != "" {
+		authHost := t.Auth.Host
+		if authHost == "" {
+			authHost = host
+		}
+		if err := client.Auth(smtp.PlainAuth(t.Auth.Identity, t.Auth.Username, t.Auth.Password, authHost)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(sender); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// smtpMessage writes the headers and the quoted-printable body of a mail, with an html part as multipart/alternative
+// and attachments as multipart/mixed
+func smtpMessage(mail Mail, sender string) []byte {
+	buffer := bytes.NewBuffer(smtpHeaders(mail, sender))
+	header, entity := mailEntity(mail)
+	writeMimeHeader(buffer, header)
+	buffer.Write(entity)
+	return buffer.Bytes()
+}
+
+func smtpHeaders(mail Mail, sender string) []byte {
+	var buffer bytes.Buffer
+	var id [16]byte
+	rand.Read(id[:])
+	domain := sender[strings.LastIndex(sender, "@")+1:]
+	header := [][2]string{
+		{"From", mail.Sender},
+		{"To", mail.Recipient},
+		{"Subject", mime.QEncoding.Encode("utf-8", mail.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id[:]) + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+	}
+	header = append(header, unsubscribeHeaders(mail)...)
+	for _, field := range header {
+		buffer.WriteString(field[0] + ": " + field[1] + "\r\n")
+	}
+	return buffer.Bytes()
+}
+
+// mailEntity is the body of a mail with its attachments
+func mailEntity(mail Mail) (textproto.MIMEHeader, []byte) {
+	bodyHeader, body := mailBody(mail)
+	if len(mail.Attachments) == 0 {
+		return bodyHeader, body
+	}
+
+	var buffer bytes.Buffer
+	parts := multipart.NewWriter(&buffer)
+	w, _ := parts.CreatePart(bodyHeader)
+	w.Write(body)
+	for _, attachment := range mail.Attachments {
+		w, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})},
+		})
+		writeBase64Lines(w, attachment.Data)
+	}
+	parts.Close()
+	return textproto.MIMEHeader{"Content-Type": {"multipart/mixed; boundary=" + parts.Boundary()}}, buffer.Bytes()
+}
+
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
+
+// mailBody is the plain text part, or the multipart/alternative of the plain text and the html part
+func mailBody(mail Mail) (textproto.MIMEHeader, []byte) {
+	var buffer bytes.Buffer
+	if mail.Html == "" {
+		writeQuotedPrintable(&buffer, mail.Body)
+		return textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}, "Content-Transfer-Encoding": {"quoted-printable"}}, buffer.Bytes()
+	}
+	parts := multipart.NewWriter(&buffer)
+	for _, part := range [][2]string{{"text/plain", mail.Body}, {"text/html", mail.Html}} {
+		w, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part[0] + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		writeQuotedPrintable(w, part[1])
+	}
+	parts.Close()
+	return textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + parts.Boundary()}}, buffer.Bytes()
+}
+
+func writeMimeHeader(w io.Writer, header textproto.MIMEHeader) {
+	for _, key := range []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition"} {
+		if value := header.Get(key); value != "" {
+			io.WriteString(w, key+": "+value+"\r\n")
+		}
+	}
+	io.WriteString(w, "\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, text string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")))
+	qp.Close()
+}
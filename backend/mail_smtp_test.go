@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpServer accepts one mail without extensions and returns the commands and the data it received
+func smtpServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var session strings.Builder
+		conn.Write([]byte("220 localhost\r\n"))
+		data := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			session.WriteString(line)
+			if data {
+				if line == ".\r\n" {
+					data = false
+					conn.Write([]byte("250 queued\r\n"))
+				}
+				continue
+			}
+			switch strings.ToUpper(strings.Fields(line)[0]) {
+			case "DATA":
+				data = true
+				conn.Write([]byte("354 go ahead\r\n"))
+			case "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				received <- session.String()
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+		received <- session.String()
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSmtpTransport(t *testing.T) {
+	address, received := smtpServer(t)
+	transport := SmtpTransport{Host: address, Security: SmtpSecurityNone, Timeout: "5s"}
+	err := transport.Send(Mail{Sender: "Webserver <mail@example.org>", Recipient: "user@example.org", Subject: "Done – 1", Body: "line\nnext"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := <-received
+	for _, expected := range []string{"MAIL FROM:<mail@example.org>", "RCPT TO:<user@example.org>", "Subject: =?utf-8?q?Done_=E2=80=93_1?=", "\r\n\r\nline\r\nnext"} {
+		if !strings.Contains(session, expected) {
+			t.Errorf("%q is missing in the session:\n%s", expected, session)
+		}
+	}
+
+	address, _ = smtpServer(t)
+	err = SmtpTransport{Host: address, Security: SmtpSecurityStartTls, Timeout: "5s"}.Send(Mail{Sender: "mail@example.org", Recipient: "user@example.org"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("missing STARTTLS support was not reported: %v", err)
+	}
+}
+
+func TestSmtpMessageAttachment(t *testing.T) {
+	mail := Mail{Sender: "mail@example.org", Recipient: "user@example.org", Subject: "Done", Body: "body", Attachments: []MailAttachment{{"tophits.tsv", "text/tab-separated-values", []byte("query\ttarget\n")}}}
+	message := string(smtpMessage(mail, "mail@example.org"))
+	for _, expected := range []string{"Content-Type: multipart/mixed; boundary=", "Content-Disposition: attachment; filename=tophits.tsv", "cXVlcnkJdGFyZ2V0Cg==", "\r\n\r\nbody"} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("%q is missing in the message:\n%s", expected, message)
+		}
+	}
+}
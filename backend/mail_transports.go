@@ -2,7 +2,7 @@ package main
 
 import (
 	"encoding/json"
-	"net/smtp"
+	"sync"
 
 	"gopkg.in/mailgun/mailgun-go.v1"
 )
@@ -10,9 +10,11 @@ import (
 type TransportType string
 
 const (
-	TransportSmtp    TransportType = "smtp"
-	TransportMailgun TransportType = "mailgun"
-	TransportNull    TransportType = "null"
+	TransportSmtp     TransportType = "smtp"
+	TransportMailgun  TransportType = "mailgun"
+	TransportSendgrid TransportType = "sendgrid"
+	TransportSes      TransportType = "ses"
+	TransportNull     TransportType = "null"
 )
 
 type ConfigMailtransport struct {
@@ -29,6 +31,47 @@ func (m *ConfigMailtransport) GetTransport() MailTransport {
 
 type configMailtransport ConfigMailtransport
 
+// MailTransportFactory decodes the transport settings of a mailer type
+type MailTransportFactory func(raw json.RawMessage) (MailTransport, error)
+
+var mailTransportRegistry = struct {
+	sync.RWMutex
+	factories map[TransportType]MailTransportFactory
+}{factories: make(map[TransportType]MailTransportFactory)}
+
+// RegisterMailTransport adds a mailer type, deployments compile in their own transports with an init function calling it.
+// Registering a type twice panics.
+func RegisterMailTransport(name TransportType, factory MailTransportFactory) {
+	mailTransportRegistry.Lock()
+	defer mailTransportRegistry.Unlock()
+	if _, ok := mailTransportRegistry.factories[name]; ok {
+		panic("mail transport " + string(name) + " registered twice")
+	}
+	mailTransportRegistry.factories[name] = factory
+}
+
+func mailTransportFactory(name TransportType) (MailTransportFactory, bool) {
+	mailTransportRegistry.RLock()
+	defer mailTransportRegistry.RUnlock()
+	factory, ok := mailTransportRegistry.factories[name]
+	return factory, ok
+}
+
+func decodeTransport[T MailTransport](raw json.RawMessage) (MailTransport, error) {
+	var t T
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func init() {
+	RegisterMailTransport(TransportSmtp, decodeTransport[SmtpTransport])
+	RegisterMailTransport(TransportMailgun, decodeTransport[MailgunTransport])
+	RegisterMailTransport(TransportSendgrid, decodeTransport[SendgridTransport])
+	RegisterMailTransport(TransportSes, decodeTransport[SesTransport])
+}
+
 func (m *ConfigMailtransport) UnmarshalJSON(b []byte) error {
 	var msg json.RawMessage
 	var mt configMailtransport
@@ -38,17 +81,9 @@ func (m *ConfigMailtransport) UnmarshalJSON(b []byte) error {
 	}
 
 	*m = ConfigMailtransport(mt)
-	switch mt.Type {
-	case TransportSmtp:
-		var t SmtpTransport
-		if err := json.Unmarshal(msg, &t); err != nil {
-			return err
-		}
-		(*m).Transport = t
-		return nil
-	case TransportMailgun:
-		var t MailgunTransport
-		if err := json.Unmarshal(msg, &t); err != nil {
+	if factory, ok := mailTransportFactory(mt.Type); ok {
+		t, err := factory(msg)
+		if err != nil {
 			return err
 		}
 		(*m).Transport = t
@@ -72,45 +107,40 @@ func (t NullTransport) Send(mail Mail) error {
 	return nil
 }
 
-type SmtpAuth struct {
-	Identity string `json:"identity"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Host     string `json:"host"`
-}
-type SmtpTransport struct {
-	Host string   `json:"host"`
-	Auth SmtpAuth `json:"auth"`
+type MailgunTransport struct {
+	Domain string `json:"domain"`
+	// defaults to the MAILGUN_API_KEY environment variable
+	SecretKey     string `json:"secretkey"`
+	SecretKeyFile string `json:"secretkeyfile"`
+	PublicKey     string `json:"publickey"`
+	// https://api.eu.mailgun.net/v3 for domains in the EU region
+	Endpoint string `json:"endpoint"`
 }
 
-func (t SmtpTransport) Send(mail Mail) error {
-	err := smtp.SendMail(
-		t.Host,
-		smtp.PlainAuth(t.Auth.Identity, t.Auth.Username, t.Auth.Password, t.Auth.Host),
-		mail.Sender,
-		[]string{mail.Recipient},
-		[]byte(mail.Body),
-	)
+func (t MailgunTransport) Send(mail Mail) error {
+	key, err := readSecret(t.SecretKey, t.SecretKeyFile, "MAILGUN_API_KEY")
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-type MailgunTransport struct {
-	Domain    string `json:"domain"`
-	SecretKey string `json:"secretkey"`
-	PublicKey string `json:"publickey"`
-}
-
-func (t MailgunTransport) Send(mail Mail) error {
 	m := mailgun.NewMailgun(
 		t.Domain,
-		t.SecretKey,
+		key,
 		t.PublicKey,
 	)
+	if t.Endpoint != "" {
+		m.SetAPIBase(t.Endpoint)
+	}
 	message := m.NewMessage(mail.Sender, mail.Subject, mail.Body, mail.Recipient)
-	_, _, err := m.Send(message)
+	if mail.Html != "" {
+		message.SetHtml(mail.Html)
+	}
+	for _, attachment := range mail.Attachments {
+		message.AddBufferAttachment(attachment.Name, attachment.Data)
+	}
+	for _, header := range unsubscribeHeaders(mail) {
+		message.AddHeader(header[0], header[1])
+	}
+	_, _, err = m.Send(message)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const defaultBatchWindow = time.Hour
+const defaultBatchLimit = 5
+
+// mails above this are only counted in the digest
+const maxDigestMails = 100
+
+const defaultDigestSubject = "{{.Count}} search jobs finished"
+const defaultDigestBody = "{{range .Mails}}{{.Subject}}\n{{.Body}}\n\n{{end}}{{if .Omitted}}{{.Omitted}} more jobs finished.\n{{end}}"
+
+// errDeliveryQueued is returned for mails that wait for the digest of their recipient
+var errDeliveryQueued = errors.New("notification is sent with the next digest")
+
+// DigestData are the fields of the digest template
+type DigestData struct {
+	Count   int
+	Mails   []Mail
+	Omitted int
+}
+
+// mailBatchStore counts the mails of each recipient per window and keeps the mails of the digest,
+// recipients are keyed by their address hash
+type mailBatchStore interface {
+	// Admit is true if the mail can be sent on its own, otherwise it is added to the digest
+	Admit(key string, mail Mail, now time.Time, window time.Duration, limit int) (bool, error)
+	// Due lists the recipients whose window ended before until with a pending digest
+	Due(until time.Time, window time.Duration) ([]string, error)
+	// Take removes the digest of a recipient if its window ended before until and starts its next window at now,
+	// which the digest counts towards. Only one caller gets the mails of a digest.
+	Take(key string, until time.Time, now time.Time, window time.Duration) ([]Mail, int, error)
+}
+
+type recipientBatch struct {
+	start   time.Time
+	sent    int
+	pending []Mail
+	omitted int
+}
+
+type memoryMailBatches struct {
+	mu      sync.Mutex
+	batches map[string]*recipientBatch
+}
+
+func (s *memoryMailBatches) Admit(key string, mail Mail, now time.Time, window time.Duration, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch, ok := s.batches[key]
+	if !ok || (len(batch.pending) == 0 && now.Sub(batch.start) >= window) {
+		batch = &recipientBatch{start: now}
+		s.batches[key] = batch
+	}
+	if batch.sent < limit {
+		batch.sent++
+		return true, nil
+	}
+	if len(batch.pending) < maxDigestMails {
+		batch.pending = append(batch.pending, mail)
+	} else {
+		batch.omitted++
+	}
+	return false, nil
+}
+
+// Due also removes the recipients whose window ended without a digest
+func (s *memoryMailBatches) Due(until time.Time, window time.Duration) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0)
+	for key, batch := range s.batches {
+		if batch.start.Add(window).After(until) {
+			continue
+		}
+		if len(batch.pending) > 0 {
+			keys = append(keys, key)
+		} else {
+			delete(s.batches, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryMailBatches) Take(key string, until time.Time, now time.Time, window time.Duration) ([]Mail, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := s.batches[key]
+	if batch == nil || len(batch.pending) == 0 || batch.start.Add(window).After(until) {
+		return nil, 0, nil
+	}
+	s.batches[key] = &recipientBatch{start: now, sent: 1}
+	return batch.pending, batch.omitted, nil
+}
+
+// the batches are shared by all workers using Redis, so the limit holds per recipient and not per worker:
+// mmseqs:mailbatch:<key> holds start, sent and omitted, mmseqs:mailbatch:<key>:pending the mails of the digest
+// and mmseqs:mailbatch:due the recipients by the end of their window
+var admitMailScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local start = tonumber(redis.call("HGET", KEYS[1], "start"))
+if not start or (redis.call("LLEN", KEYS[2]) == 0 and now - start >= window) then
+	redis.call("HMSET", KEYS[1], "start", now, "sent", 0, "omitted", 0)
+	start = now
+end
+redis.call("PEXPIRE", KEYS[1], 2 * window)
+if tonumber(redis.call("HGET", KEYS[1], "sent")) < tonumber(ARGV[3]) then
+	redis.call("HINCRBY", KEYS[1], "sent", 1)
+	return 1
+end
+if redis.call("LLEN", KEYS[2]) < tonumber(ARGV[5]) then
+	redis.call("RPUSH", KEYS[2], ARGV[4])
+else
+	redis.call("HINCRBY", KEYS[1], "omitted", 1)
+end
+redis.call("PEXPIRE", KEYS[2], 2 * window)
+redis.call("ZADD", KEYS[3], start + window, ARGV[6])
+return 0
+`)
+
+var takeDigestScript = redis.NewScript(`
+local due = tonumber(redis.call("ZSCORE", KEYS[3], ARGV[4]))
+if not due or due > tonumber(ARGV[1]) or redis.call("ZREM", KEYS[3], ARGV[4]) == 0 then
+	return {}
+end
+local mails = redis.call("LRANGE", KEYS[2], 0, -1)
+local omitted = redis.call("HGET", KEYS[1], "omitted") or "0"
+redis.call("DEL", KEYS[2])
+redis.call("HMSET", KEYS[1], "start", ARGV[2], "sent", 1, "omitted", 0)
+redis.call("PEXPIRE", KEYS[1], 2 * tonumber(ARGV[3]))
+table.insert(mails, 1, omitted)
+return mails
+`)
+
+type redisMailBatches struct {
+	client *redis.Client
+}
+
+func mailBatchKeys(key string) []string {
+	return []string{"mmseqs:mailbatch:" + key, "mmseqs:mailbatch:" + key + ":pending", "mmseqs:mailbatch:due"}
+}
+
+func (s redisMailBatches) Admit(key string, mail Mail, now time.Time, window time.Duration, limit int) (bool, error) {
+	data, err := json.Marshal(mail)
+	if err != nil {
+		return false, err
+	}
+	admitted, err := admitMailScript.Run(s.client, mailBatchKeys(key), now.UnixMilli(), window.Milliseconds(), limit, data, maxDigestMails, key).Int()
+	return admitted == 1, err
+}
+
+func (s redisMailBatches) Due(until time.Time, window time.Duration) ([]string, error) {
+	return s.client.ZRangeByScore("mmseqs:mailbatch:due", redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(until.UnixMilli(), 10)}).Result()
+}
+
+func (s redisMailBatches) Take(key string, until time.Time, now time.Time, window time.Duration) ([]Mail, int, error) {
+	result, err := takeDigestScript.Run(s.client, mailBatchKeys(key), until.UnixMilli(), now.UnixMilli(), window.Milliseconds(), key).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	values, _ := result.([]interface{})
+	if len(values) == 0 {
+		return nil, 0, nil
+	}
+	omitted, err := strconv.Atoi(values[0].(string))
+	if err != nil {
+		return nil, 0, err
+	}
+	mails := make([]Mail, 0, len(values)-1)
+	for _, value := range values[1:] {
+		var mail Mail
+		if err := json.Unmarshal([]byte(value.(string)), &mail); err != nil {
+			return nil, 0, err
+		}
+		mails = append(mails, mail)
+	}
+	return mails, omitted, nil
+}
+
+func newMailBatchStore(jobsystem JobSystem) mailBatchStore {
+	if redisJobs, ok := jobsystem.(*RedisJobSystem); ok {
+		return redisMailBatches{redisJobs.Client}
+	}
+	return &memoryMailBatches{batches: make(map[string]*recipientBatch)}
+}
+
+// batchingTransport sends the first mails of a recipient in each window and one digest of the others when the window ends
+type batchingTransport struct {
+	MailTransport
+	config ConfigMailBatching
+	window time.Duration
+	store  mailBatchStore
+}
+
+func newBatchingTransport(mailer MailTransport, config ConfigMailBatching, store mailBatchStore) (*batchingTransport, error) {
+	window := defaultBatchWindow
+	if config.Window != "" {
+		var err error
+		if window, err = time.ParseDuration(config.Window); err != nil {
+			return nil, err
+		}
+	}
+	if config.Limit == 0 {
+		config.Limit = defaultBatchLimit
+	}
+	return &batchingTransport{mailer, config, window, store}, nil
+}
+
+// Send returns errDeliveryQueued for mails that are added to the digest
+func (t *batchingTransport) Send(mail Mail) error {
+	admitted, err := t.store.Admit(addressHash(mail.Recipient), mail, time.Now(), t.window, t.config.Limit)
+	if err != nil {
+		return err
+	}
+	if !admitted {
+		return errDeliveryQueued
+	}
+	return t.MailTransport.Send(mail)
+}
+
+// flush sends the digests whose window ended before until
+func (t *batchingTransport) flush(until time.Time) {
+	keys, err := t.store.Due(until, t.window)
+	if err != nil {
+		notifyLog.Error("Failed to read pending digests", "error", err)
+		return
+	}
+	for _, key := range keys {
+		pending, omitted, err := t.store.Take(key, until, time.Now(), t.window)
+		if err != nil {
+			notifyLog.Error("Failed to read digest", "error", err)
+			continue
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		t.send(pending, omitted)
+	}
+}
+
+func (t *batchingTransport) send(pending []Mail, omitted int) {
+	template := t.config.Template
+	if template.Subject == "" {
+		template.Subject = defaultDigestSubject
+	}
+	if template.Body == "" && template.BodyFile == "" {
+		template.Body = defaultDigestBody
+	}
+	digest, err := RenderMail(template, DigestData{len(pending) + omitted, pending, omitted})
+	if err == nil {
+		digest.Sender = pending[0].Sender
+		digest.Recipient = pending[0].Recipient
+		digest.Unsubscribe = pending[0].Unsubscribe
+		err = t.MailTransport.Send(digest)
+	}
+	if err != nil {
+		notifyLog.Error("Failed to send digest", "mails", len(pending)+omitted, "error", err)
+	}
+}
+
+// Run sends the digests once their window ended
+func (t *batchingTransport) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		t.flush(time.Now())
+	}
+}
+
+// Flush sends all pending digests, before the worker exits
+func (t *batchingTransport) Flush() {
+	t.flush(time.UnixMilli(math.MaxInt64 / 2))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchingTransport(t *testing.T) {
+	mailer := &recordingTransport{}
+	store := &memoryMailBatches{batches: make(map[string]*recipientBatch)}
+	batching, err := newBatchingTransport(mailer, ConfigMailBatching{Window: "1h", Limit: 2}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := 0
+	for _, recipient := range []string{"a@example.org", "A@example.org", "a@example.org", "b@example.org", "a@example.org"} {
+		err := batching.Send(Mail{Sender: "mail@example.org", Recipient: recipient, Subject: "Done -- " + recipient, Body: "body"})
+		if errors.Is(err, errDeliveryQueued) {
+			queued++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(mailer.mails) != 3 || queued != 2 {
+		t.Fatalf("expected 3 individual and 2 queued mails, got %d and %d", len(mailer.mails), queued)
+	}
+
+	// the window did not end yet
+	batching.flush(time.Now())
+	if len(mailer.mails) != 3 {
+		t.Fatalf("expected the digest to wait for the window, got %d mails", len(mailer.mails))
+	}
+	batching.flush(time.Now().Add(time.Hour))
+	if len(mailer.mails) != 4 {
+		t.Fatalf("expected one digest, got %d mails", len(mailer.mails))
+	}
+	digest := mailer.mails[3]
+	if digest.Recipient != "a@example.org" || !strings.HasPrefix(digest.Subject, "2 ") || strings.Count(digest.Body, "Done -- ") != 2 {
+		t.Errorf("unexpected digest %+v", digest)
+	}
+
+	// the digest counts towards the limit of the next window
+	batching.Send(Mail{Sender: "mail@example.org", Recipient: "a@example.org", Subject: "Done", Body: "body"})
+	if err := batching.Send(Mail{Sender: "mail@example.org", Recipient: "a@example.org", Subject: "Done", Body: "body"}); !errors.Is(err, errDeliveryQueued) {
+		t.Errorf("expected the limit to carry over, got %v", err)
+	}
+	batching.Flush()
+	if len(mailer.mails) != 6 {
+		t.Errorf("expected the digest to be sent once and the pending one on exit, got %d mails", len(mailer.mails))
+	}
+}
+
+func TestMailBatchesPruned(t *testing.T) {
+	store := &memoryMailBatches{batches: make(map[string]*recipientBatch)}
+	store.Admit("a", Mail{}, time.Now().Add(-2*time.Hour), time.Hour, 5)
+	store.Admit("b", Mail{}, time.Now(), time.Hour, 5)
+	if store.Due(time.Now().Add(-90*time.Minute), time.Hour); len(store.batches) != 2 {
+		t.Errorf("expected the batch to be kept until its window ends before until %+v", store.batches)
+	}
+	if keys, _ := store.Due(time.Now(), time.Hour); len(keys) != 0 || len(store.batches) != 1 || store.batches["b"] == nil {
+		t.Errorf("expected the expired batch to be removed %v %+v", keys, store.batches)
+	}
+}
+
+func TestQueuedDelivery(t *testing.T) {
+	store := &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	batching, err := newBatchingTransport(&recordingTransport{}, ConfigMailBatching{Limit: 1}, &memoryMailBatches{batches: make(map[string]*recipientBatch)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := newDeliveryQueue(map[NotifyChannel]Notifier{NotifyEmail: mailNotifier{batching}}, store, store, ConfigMailRetry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []Id{"abc", "def"} {
+		queue.deliver(pendingDelivery{Id: id, Channel: NotifyEmail, Event: NotifyEvent{Mail: &Mail{}}, Recipient: "user@example.org"})
+	}
+	deliveries, _ := store.Deliveries("def")
+	if len(deliveries) != 1 || deliveries[0].Status != DeliveryQueued || len(store.pending) != 0 {
+		t.Errorf("unexpected status of a batched mail %+v", deliveries)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"go.mozilla.org/pkcs7"
+)
+
+const (
+	MailSigningSmime = "smime"
+	MailSigningPgp   = "pgp"
+)
+
+// rawMailTransport sends complete MIME messages, signed mails need one
+type rawMailTransport interface {
+	MailTransport
+	SendRaw(sender string, recipient string, message []byte) error
+}
+
+// mailSigner creates the detached signature of the signed part of a multipart/signed mail
+type mailSigner interface {
+	// protocol and micalg parameters of the multipart/signed content type
+	Parameters() (string, string)
+	// Sign returns the header and the content of the signature part
+	Sign(part []byte) (textproto.MIMEHeader, []byte, error)
+}
+
+// signingTransport signs mails as S/MIME or PGP/MIME (RFC 1847) before sending them
+type signingTransport struct {
+	transport rawMailTransport
+	signer    mailSigner
+}
+
+// signMail wraps mailer in a signingTransport if mail.signing is configured
+func signMail(mailer MailTransport, config ConfigMail) (MailTransport, error) {
+	if config.Signing == nil {
+		return mailer, nil
+	}
+	raw, ok := mailer.(rawMailTransport)
+	if !ok {
+		return nil, errors.New("signed mails need the smtp or ses mail transport")
+	}
+	signer, err := newMailSigner(*config.Signing)
+	if err != nil {
+		return nil, err
+	}
+	return signingTransport{raw, signer}, nil
+}
+
+func newMailSigner(config ConfigMailSigning) (mailSigner, error) {
+	switch config.Type {
+	case MailSigningSmime:
+		return newSmimeSigner(config)
+	case MailSigningPgp:
+		return newPgpSigner(config)
+	default:
+		return nil, errors.New("unknown mail signing type " + config.Type)
+	}
+}
+
+func (t signingTransport) Send(mail Mail) error {
+	sender, err := netmail.ParseAddress(mail.Sender)
+	if err != nil {
+		return err
+	}
+	recipient, err := netmail.ParseAddress(mail.Recipient)
+	if err != nil {
+		return err
+	}
+	message, err := signedMessage(mail, sender.Address, t.signer)
+	if err != nil {
+		return err
+	}
+	return t.transport.SendRaw(sender.Address, recipient.Address, message)
+}
+
+// signedMessage writes the mail entity and its signature as multipart/signed,
+// the entity is signed exactly as written, with CRLF line endings and without the CRLF before the boundary
+func signedMessage(mail Mail, sender string, signer mailSigner) ([]byte, error) {
+	var part bytes.Buffer
+	header, entity := mailEntity(mail)
+	writeMimeHeader(&part, header)
+	part.Write(entity)
+
+	signatureHeader, signature, err := signer.Sign(part.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	protocol, micalg := signer.Parameters()
+	boundary := multipart.NewWriter(nil).Boundary()
+
+	buffer := bytes.NewBuffer(smtpHeaders(mail, sender))
+	writeMimeHeader(buffer, textproto.MIMEHeader{"Content-Type": {mime.FormatMediaType("multipart/signed", map[string]string{
+		"protocol": protocol,
+		"micalg":   micalg,
+		"boundary": boundary,
+	})}})
+	buffer.WriteString("--" + boundary + "\r\n")
+	buffer.Write(part.Bytes())
+	buffer.WriteString("\r\n--" + boundary + "\r\n")
+	writeMimeHeader(buffer, signatureHeader)
+	buffer.Write(signature)
+	buffer.WriteString("\r\n--" + boundary + "--\r\n")
+	return buffer.Bytes(), nil
+}
+
+type smimeSigner struct {
+	leaf *x509.Certificate
+	// the intermediates of the chain, issuer first
+	parents []*x509.Certificate
+	key     crypto.PrivateKey
+}
+
+func newSmimeSigner(config ConfigMailSigning) (*smimeSigner, error) {
+	pair, err := tls.LoadX509KeyPair(config.Certificate, config.Key)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for _, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	switch pair.PrivateKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, errors.New("S/MIME keys must be RSA or ECDSA")
+	}
+	return &smimeSigner{chain[0], chain[1:], pair.PrivateKey}, nil
+}
+
+func (s *smimeSigner) Parameters() (string, string) {
+	return "application/pkcs7-signature", "sha-256"
+}
+
+func (s *smimeSigner) Sign(part []byte) (textproto.MIMEHeader, []byte, error) {
+	signature, err := s.signature(part)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buffer bytes.Buffer
+	writeBase64Lines(&buffer, signature)
+	return textproto.MIMEHeader{
+		"Content-Type":              {"application/pkcs7-signature; name=smime.p7s"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {"attachment; filename=smime.p7s"},
+	}, buffer.Bytes(), nil
+}
+
+// signature is the DER encoded CMS SignedData of part without the content
+func (s *smimeSigner) signature(part []byte) ([]byte, error) {
+	signed, err := pkcs7.NewSignedData(part)
+	if err != nil {
+		return nil, err
+	}
+	signed.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := signed.AddSignerChain(s.leaf, s.key, s.parents, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	signed.Detach()
+	return signed.Finish()
+}
+
+type pgpSigner struct {
+	entity *openpgp.Entity
+}
+
+func newPgpSigner(config ConfigMailSigning) (*pgpSigner, error) {
+	file, err := os.Open(config.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	keyring, err := openpgp.ReadArmoredKeyRing(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyring) == 0 || keyring[0].PrivateKey == nil {
+		return nil, errors.New("the PGP key file contains no private key")
+	}
+	entity := keyring[0]
+
+	passphrase, err := readSecret(config.Passphrase, config.PassphraseFile, "MAIL_SIGNING_PASSPHRASE")
+	if err != nil {
+		return nil, err
+	}
+	keys := []*packet.PrivateKey{entity.PrivateKey}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil {
+			keys = append(keys, subkey.PrivateKey)
+		}
+	}
+	for _, key := range keys {
+		if key.Encrypted {
+			if err := key.Decrypt([]byte(passphrase)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &pgpSigner{entity}, nil
+}
+
+func (s *pgpSigner) Parameters() (string, string) {
+	return "application/pgp-signature", "pgp-sha256"
+}
+
+func (s *pgpSigner) Sign(part []byte) (textproto.MIMEHeader, []byte, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, s.entity, bytes.NewReader(part), &packet.Config{DefaultHash: crypto.SHA256}); err != nil {
+		return nil, nil, err
+	}
+	armored := strings.ReplaceAll(signature.String(), "\n", "\r\n") + "\r\n"
+	return textproto.MIMEHeader{
+		"Content-Type":        {"application/pgp-signature; name=signature.asc"},
+		"Content-Disposition": {"attachment; filename=signature.asc"},
+	}, []byte(armored), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"mime"
+	netmail "net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"go.mozilla.org/pkcs7"
+)
+
+// signedPart splits a multipart/signed message into the signed part and the content of the signature part
+func signedPart(t *testing.T, message []byte) ([]byte, []byte) {
+	parsed, err := netmail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/signed" {
+		t.Fatalf("not a multipart/signed message:\n%s", message)
+	}
+	parts := strings.Split(string(message), "--"+params["boundary"])
+	if len(parts) != 4 {
+		t.Fatalf("expected two parts, got %d", len(parts)-2)
+	}
+	signature := parts[2][strings.Index(parts[2], "\r\n\r\n")+4:]
+	return []byte(strings.TrimSuffix(strings.TrimPrefix(parts[1], "\r\n"), "\r\n")), []byte(signature)
+}
+
+// signedFiles writes the signed message and its parts for the command line tools
+func signedFiles(t *testing.T, message []byte) (string, string, string) {
+	part, signature := signedPart(t, message)
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "message.eml"), filepath.Join(dir, "part"), filepath.Join(dir, "signature")}
+	for i, data := range [][]byte{message, part, signature} {
+		if err := os.WriteFile(files[i], data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files[0], files[1], files[2]
+}
+
+func TestSmimeSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(7), Subject: pkix.Name{CommonName: "mail@example.org"}, EmailAddresses: []string{"mail@example.org"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	signer := &smimeSigner{cert, nil, key}
+
+	message, err := signedMessage(Mail{Sender: "mail@example.org", Recipient: "user@example.org", Subject: "Done", Body: "body"}, "mail@example.org", signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, signature := signedPart(t, message)
+	if !bytes.HasPrefix(part, []byte("Content-Type: text/plain")) {
+		t.Errorf("unexpected signed part %q", part)
+	}
+	der, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(signature), "\r\n", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := pkcs7.Parse(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.Content = part
+	if err := parsed.Verify(); err != nil {
+		t.Error(err)
+	}
+	if signer := parsed.GetOnlySigner(); signer == nil || signer.SerialNumber.Int64() != 7 {
+		t.Errorf("unexpected signer")
+	}
+
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+	messageFile, _, _ := signedFiles(t, message)
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command(openssl, "smime", "-verify", "-in", messageFile, "-CAfile", certFile, "-out", os.DevNull).CombinedOutput(); err != nil {
+		t.Errorf("openssl did not verify the signature: %v\n%s", err, output)
+	}
+}
+
+func TestPgpSignature(t *testing.T) {
+	entity, err := openpgp.NewEntity("Webserver", "", "mail@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := signedMessage(Mail{Sender: "mail@example.org", Recipient: "user@example.org", Subject: "Done", Body: "body", Html: "<p>body</p>"}, "mail@example.org", &pgpSigner{entity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(message), `micalg=pgp-sha256; protocol="application/pgp-signature"`) {
+		t.Errorf("unexpected content type:\n%s", message)
+	}
+	part, signature := signedPart(t, message)
+	if _, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{entity}, bytes.NewReader(part), bytes.NewReader(signature), nil); err != nil {
+		t.Error(err)
+	}
+
+	gpg, err := exec.LookPath("gpg")
+	if err != nil {
+		t.Skip("gpg is not installed")
+	}
+	home := t.TempDir()
+	var public bytes.Buffer
+	if err := entity.Serialize(&public); err != nil {
+		t.Fatal(err)
+	}
+	imported := exec.Command(gpg, "--homedir", home, "--batch", "--import")
+	imported.Stdin = &public
+	if output, err := imported.CombinedOutput(); err != nil {
+		t.Fatalf("gpg did not import the key: %v\n%s", err, output)
+	}
+	_, partFile, signatureFile := signedFiles(t, message)
+	if output, err := exec.Command(gpg, "--homedir", home, "--batch", "--verify", signatureFile, partFile).CombinedOutput(); err != nil {
+		t.Errorf("gpg did not verify the signature: %v\n%s", err, output)
+	}
+}
+
+func TestSignMailTransport(t *testing.T) {
+	if _, err := signMail(NullTransport{}, ConfigMail{Signing: &ConfigMailSigning{Type: MailSigningPgp}}); err == nil {
+		t.Error("signing needs a raw transport")
+	}
+	if mailer, err := signMail(NullTransport{}, ConfigMail{}); err != nil || mailer != MailTransport(NullTransport{}) {
+		t.Error("mails are only signed if configured")
+	}
+}
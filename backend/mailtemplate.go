@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"strings"
+	texttemplate "text/template"
+)
+
+// MailData are the fields of a job notification that templates can use
+type MailData struct {
+	TicketID  string
+	Status    Status
+	ResultURL string
+	// rounded to seconds, empty unless the job is complete
+	Runtime string
+	// the searched databases separated by commas
+	Database string
+	// hit counts of a complete job
+	Summary string
+	// best hits of a complete job, HitsTable has the first of them as text table
+	Hits      []SummaryHit
+	HitsTable string
+	// message and remediation hint of a failed job
+	Error string
+	Hint  string
+	// signed opt-out link of the recipient, appended to mails that do not show it
+	UnsubscribeURL string
+}
+
+// legacyMailTemplate converts templates with the ticket as %s, like all templates before named fields,
+// the job summary and the best hits are appended to their body
+func legacyMailTemplate(s string, body bool) string {
+	if strings.Contains(s, "{{") {
+		return s
+	}
+	s = strings.ReplaceAll(s, "%s", "{{.TicketID}}")
+	if body {
+		s += "{{if .Summary}}\n\n{{.Summary}}{{end}}{{if .HitsTable}}\n\n{{.HitsTable}}{{end}}"
+	}
+	return s
+}
+
+func readMailTemplate(inline string, file string) (string, error) {
+	if file == "" {
+		return inline, nil
+	}
+	data, err := os.ReadFile(file)
+	return string(data), err
+}
+
+func executeTemplate(tmpl interface{ Execute(io.Writer, any) error }, data any) (string, error) {
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, data)
+	return buffer.String(), err
+}
+
+// RenderMail fills the subject and the parts of a template with MailData or other fields, the html part is only set if the template has one
+func RenderMail(config ConfigMailTemplate, data any) (Mail, error) {
+	var mail Mail
+	subject, err := texttemplate.New("subject").Parse(legacyMailTemplate(config.Subject, false))
+	if err != nil {
+		return mail, err
+	}
+	text, err := executeTemplate(subject, data)
+	if err != nil {
+		return mail, err
+	}
+	// headers can not span multiple lines
+	mail.Subject = strings.Join(strings.Fields(text), " ")
+
+	source, err := readMailTemplate(config.Body, config.BodyFile)
+	if err != nil {
+		return mail, err
+	}
+	body, err := texttemplate.New("body").Parse(legacyMailTemplate(source, config.BodyFile == ""))
+	if err != nil {
+		return mail, err
+	}
+	if mail.Body, err = executeTemplate(body, data); err != nil {
+		return mail, err
+	}
+
+	if config.HtmlFile == "" {
+		return mail, nil
+	}
+	if source, err = readMailTemplate("", config.HtmlFile); err != nil {
+		return mail, err
+	}
+	html, err := htmltemplate.New("html").Parse(source)
+	if err != nil {
+		return mail, err
+	}
+	mail.Html, err = executeTemplate(html, data)
+	return mail, err
+}
+
+// resultUrl links to the result page of the web interface, empty without mail.url
+func resultUrl(config ConfigMail, id Id) string {
+	if config.Url == "" {
+		return ""
+	}
+	return strings.TrimSuffix(config.Url, "/") + "/result/" + string(id) + "/0"
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderMail(t *testing.T) {
+	data := MailData{TicketID: "abc", Status: StatusComplete, ResultURL: resultUrl(ConfigMail{Url: "https://search.example.org/"}, "abc"), Summary: "1 of 1 queries have hits."}
+
+	legacy, err := RenderMail(ConfigMailTemplate{Subject: "Done -- %s", Body: "%s"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Subject != "Done -- abc" || legacy.Body != "abc\n\n1 of 1 queries have hits." || legacy.Html != "" {
+		t.Errorf("unexpected legacy mail %+v", legacy)
+	}
+
+	dir := t.TempDir()
+	html := filepath.Join(dir, "success.html")
+	if err := os.WriteFile(html, []byte(`<a href="{{.ResultURL}}">{{.Database}}</a>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data.Database = "<pdb>"
+	mail, err := RenderMail(ConfigMailTemplate{Subject: "{{.Status}}\n{{.TicketID}}", Body: "{{.ResultURL}}", HtmlFile: html}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mail.Subject != "COMPLETE abc" || mail.Body != "https://search.example.org/result/abc/0" {
+		t.Errorf("unexpected mail %+v", mail)
+	}
+	if !strings.Contains(mail.Html, `href="https://search.example.org/result/abc/0"`) || !strings.Contains(mail.Html, "&lt;pdb&gt;") {
+		t.Errorf("unexpected html part %s", mail.Html)
+	}
+
+	if _, err := RenderMail(ConfigMailTemplate{Subject: "{{.Missing}}"}, data); err == nil {
+		t.Error("unknown field was not reported")
+	}
+}
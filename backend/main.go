@@ -2,9 +2,9 @@ package main
 
 import (
 	"errors"
-	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 )
 
@@ -44,7 +44,7 @@ func ParseConfigName(args []string) (string, []string) {
 	for i := 0; i < len(args); i++ {
 		if args[i] == "-config" {
 			if i+1 == len(args) {
-				log.Fatal(errors.New("config file name is not specified"))
+				logFatal(serverLog, "Invalid arguments", errors.New("config file name is not specified"))
 			}
 			file = args[i+1]
 			i++
@@ -57,15 +57,36 @@ func ParseConfigName(args []string) (string, []string) {
 	return file, resArgs
 }
 
+// ParseJobId returns the id given with -job, which runs only this job and exits
+func ParseJobId(args []string) (Id, []string) {
+	resArgs := make([]string, 0)
+	id := Id("")
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-job" {
+			if i+1 == len(args) {
+				logFatal(serverLog, "Invalid arguments", errors.New("job id is not specified"))
+			}
+			id = Id(args[i+1])
+			i++
+			continue
+		}
+
+		resArgs = append(resArgs, args[i])
+	}
+
+	return id, resArgs
+}
+
 func main() {
 	t, args := ParseType(os.Args[1:])
 	configFile, args := ParseConfigName(args)
+	jobId, args := ParseJobId(args)
 
 	var config ConfigRoot
 	var err error
 	if len(configFile) > 0 {
 		if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
-			log.Println("Creating config file: " + configFile)
+			serverLog.Info("Creating config file", "path", configFile)
 			err = WriteDefaultConfig(configFile)
 			if err != nil {
 				panic(err)
@@ -85,23 +106,77 @@ func main() {
 		panic(err)
 	}
 
+	process := map[RunType]string{LOCAL: "local", WORKER: "worker", SERVER: "server"}[t]
+	if jobId != "" {
+		process = "job-" + string(jobId)
+	}
+	if err := setupLogging(config, process); err != nil {
+		panic(err)
+	}
+
 	if err := config.CheckPaths(); err != nil {
 		panic(err)
 	}
 
+	config.Versions, err = DetectVersions(config)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := SetupResultEncryption(config.Encryption, config.Paths.Results); err != nil {
+		panic(err)
+	}
+
+	if jobId != "" {
+		if err := runSingleJob(config, jobId); err != nil {
+			logFatal(workerLog, "Job failed", err)
+		}
+		return
+	}
+
+	if config.Worker.Slurm != nil && config.Worker.Slurm.Config == "" {
+		if configFile == "" {
+			panic(errors.New("the slurm executor requires a config file"))
+		}
+		absPath, err := filepath.Abs(configFile)
+		if err != nil {
+			panic(err)
+		}
+		config.Worker.Slurm.Config = absPath
+	}
+
+	watchdog, err := MakeDiskWatchdog(config)
+	if err != nil {
+		panic(err)
+	}
+	go watchdog.Run()
+
 	switch t {
 	case WORKER:
+		go tempSweeper(config)
+		if config.Worker.Intermediates != nil {
+			go intermediatesSweeper(config)
+		}
+		if config.Paths.Artifacts != "" {
+			go artifactSweeper(config)
+		}
+		go cacheWarmer(config)
+		if config.Worker.Remote != nil {
+			worker(MakeRemoteJobSystem(*config.Worker.Remote, config.Paths.Results), config, MakeGpuPool(config.Worker.Gpus), watchdog)
+			break
+		}
 		jobsystem, err := MakeRedisJobSystem(config.Redis, config.Paths.Results, false)
 		if err != nil {
 			panic(err)
 		}
-		worker(jobsystem, config)
+		go jobResumer(jobsystem, config)
+		worker(jobsystem, config, MakeGpuPool(config.Worker.Gpus), watchdog)
 	case SERVER:
 		jobsystem, err := MakeRedisJobSystem(config.Redis, config.Paths.Results, config.Server.CheckOld)
 		if err != nil {
 			panic(err)
 		}
-		server(jobsystem, config)
+		server(jobsystem, config, watchdog)
 	case LOCAL:
 		jobsystem, err := MakeLocalJobSystem(config.Paths.Results, config.Local.CheckOld)
 		if err != nil {
@@ -115,11 +190,20 @@ func main() {
 			os.Exit(0)
 		}()
 
+		go tempSweeper(config)
+		if config.Worker.Intermediates != nil {
+			go intermediatesSweeper(config)
+		}
+		if config.Paths.Artifacts != "" {
+			go artifactSweeper(config)
+		}
+		go cacheWarmer(config)
 		loop := make(chan bool)
+		gpus := MakeGpuPool(config.Worker.Gpus)
 		for i := 0; i < config.Local.Workers; i++ {
-			go worker(&jobsystem, config)
+			go worker(&jobsystem, config, gpus, watchdog)
 		}
-		go server(&jobsystem, config)
+		go server(&jobsystem, config, watchdog)
 		<-loop
 	}
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetricHeader starts a metric family in the Prometheus text exposition format
+func writeMetricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeMetric writes one sample, labels are sorted by name
+func writeMetric(w io.Writer, name string, labels map[string]string, value float64) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, label := range names {
+		pairs[i] = label + `="` + metricLabelEscaper.Replace(labels[label]) + `"`
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// writeDiskUsageMetrics exposes the last disk usage measurement
+func writeDiskUsageMetrics(w io.Writer, usage DiskUsage) {
+	writeMetricHeader(w, "mmseqs_database_size_bytes", "gauge", "Size of the files of a database.")
+	for _, db := range usage.Databases {
+		writeMetric(w, "mmseqs_database_size_bytes", map[string]string{"database": db.Path}, float64(db.Size))
+	}
+	writeMetricHeader(w, "mmseqs_databases_size_bytes", "gauge", "Size of the databases directory.")
+	writeMetric(w, "mmseqs_databases_size_bytes", nil, float64(usage.DatabasesTotal))
+	if usage.Quota > 0 {
+		writeMetricHeader(w, "mmseqs_databases_quota_bytes", "gauge", "Maximum size of the databases directory for new databases.")
+		writeMetric(w, "mmseqs_databases_quota_bytes", nil, float64(usage.Quota))
+	}
+	writeMetricHeader(w, "mmseqs_results_size_bytes", "gauge", "Size of the job results directory.")
+	writeMetric(w, "mmseqs_results_size_bytes", nil, float64(usage.Results))
+	writeMetricHeader(w, "mmseqs_results_jobs", "gauge", "Number of job directories in the results directory.")
+	writeMetric(w, "mmseqs_results_jobs", nil, float64(usage.Jobs))
+	writeMetricHeader(w, "mmseqs_temporary_size_bytes", "gauge", "Size of the temporary directory.")
+	writeMetric(w, "mmseqs_temporary_size_bytes", nil, float64(usage.Temporary))
+}
+
+// writeDatabaseStatsMetrics exposes the search statistics of the databases as counters
+func writeDatabaseStatsMetrics(w io.Writer, stats map[string]DatabaseStats) {
+	databases := mostSearchedDatabases(stats, len(stats))
+	writeMetricHeader(w, "mmseqs_database_searches_total", "counter", "Searches submitted for a database.")
+	for _, db := range databases {
+		writeMetric(w, "mmseqs_database_searches_total", map[string]string{"database": db}, float64(stats[db].Searches))
+	}
+	writeMetricHeader(w, "mmseqs_database_query_residues_total", "counter", "Query residues searched against a database.")
+	for _, db := range databases {
+		writeMetric(w, "mmseqs_database_query_residues_total", map[string]string{"database": db}, float64(stats[db].Residues))
+	}
+	writeMetricHeader(w, "mmseqs_database_search_seconds_total", "counter", "Runtime of the finished searches of a database.")
+	for _, db := range databases {
+		writeMetric(w, "mmseqs_database_search_seconds_total", map[string]string{"database": db}, stats[db].Runtime)
+	}
+	writeMetricHeader(w, "mmseqs_database_search_runs_total", "counter", "Finished searches of a database.")
+	for _, db := range databases {
+		writeMetric(w, "mmseqs_database_search_runs_total", map[string]string{"database": db}, float64(stats[db].Runs))
+	}
+}
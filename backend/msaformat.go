@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+)
+
+// msaFormats are the MSA download formats of search jobs, each is written by result2msa into msa_<database>.<format>
+var msaFormats = []struct {
+	Name string
+	// result2msa --msa-format-mode
+	Mode string
+}{{"a3m", "5"}, {"sto", "4"}, {"fasta", "2"}}
+
+func isMsaFormat(format string) bool {
+	for _, f := range msaFormats {
+		if f.Name == format {
+			return true
+		}
+	}
+	return false
+}
+
+func msaPath(base string, database string, format string) string {
+	return filepath.Join(base, "msa_"+database+"."+format)
+}
+
+// writeSearchMsas builds the MSAs of the hits of every query from the temporary directory of an easy-search call that kept its files
+func writeSearchMsas(ctx context.Context, executor Executor, mmseqs string, searchTemp string, result string, target string, base string, database string, extra []string) error {
+	for _, format := range msaFormats {
+		parameters := []string{
+			mmseqs,
+			"result2msa",
+			filepath.Join(searchTemp, "latest", "query"),
+			target,
+			filepath.Join(searchTemp, "latest", result),
+			msaPath(base, database, format.Name),
+			"--msa-format-mode",
+			format.Mode,
+			"--db-load-mode",
+			"2",
+		}
+		if err := runStep(ctx, executor, append(parameters, extra...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMsa writes the MSAs of the given queries of the databases one after the other
+func WriteMsa(w io.Writer, base string, databases []string, format string, queries []uint32) error {
+	out := bufio.NewWriter(w)
+	for _, database := range databases {
+		reader := Reader[uint32]{}
+		if err := reader.Make(dbpaths(msaPath(base, database, format))); err != nil {
+			return err
+		}
+		for _, i := range resultEntries(&reader, queries) {
+			if err := copyEntry(out, &reader, i); err != nil {
+				reader.Delete()
+				return err
+			}
+		}
+		reader.Delete()
+	}
+	return out.Flush()
+}
+
+// lastByteWriter remembers the last byte written through it
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (l *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		l.last = p[len(p)-1]
+	}
+	return l.w.Write(p)
+}
+
+// copyEntry streams an MSA and ends it with a newline if it does not have one
+func copyEntry(out io.Writer, reader *Reader[uint32], id int64) error {
+	entry, err := reader.Open(id)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+	lw := &lastByteWriter{out, '\n'}
+	if _, err := io.Copy(lw, entry); err != nil {
+		return err
+	}
+	if lw.last != '\n' {
+		_, err = out.Write([]byte{'\n'})
+	}
+	return err
+}
+
+func init() {
+	for _, format := range msaFormats {
+		name := format.Name
+		RegisterResultFormatter(name, resultFormat{name, "text/plain", func(r ResultRequest) (ResultWriter, error) {
+			if job, ok := r.Request.Job.(SearchJob); !ok || !job.Msa {
+				return nil, errors.New("No MSA was generated for this job")
+			}
+			return func(w io.Writer) error {
+				return WriteMsa(w, r.Base, r.Databases, name, r.Queries)
+			}, nil
+		}})
+	}
+}
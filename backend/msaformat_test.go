@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteMsa(t *testing.T) {
+	base := t.TempDir()
+	files := map[string]string{
+		"msa_db.a3m":       ">q1\nMKV\n>t1\nMR-\n\x00\x00>q2\nAAA\x00",
+		"msa_db.a3m.index": "0\t0\t17\n1\t17\t1\n2\t18\t8\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(base, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := WriteMsa(&buf, base, []string{"db"}, "a3m", nil); err != nil {
+		t.Fatal(err)
+	}
+	// queries without hits are left out and every MSA ends with a newline
+	if expected := ">q1\nMKV\n>t1\nMR-\n>q2\nAAA\n"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+	if !isMsaFormat("sto") || isMsaFormat("blast") {
+		t.Error("unexpected MSA formats")
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type MsaJob struct {
@@ -52,16 +56,20 @@ func NewMsaJobRequest(query string, dbs []string, validDbs []Params, mode string
 	}
 
 	request := JobRequest{
-		job.Hash(),
-		StatusPending,
-		JobMsa,
-		job,
-		email,
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobMsa,
+		Job:    job,
+		Email:  email,
 	}
 
-	ids := make([]string, len(validDbs))
-	for i, item := range validDbs {
-		ids[i] = item.Path
+	ids := make([]string, 0, len(validDbs))
+	for _, item := range validDbs {
+		// structure databases can only be selected by structure searches
+		if item.Structure {
+			continue
+		}
+		ids = append(ids, item.Path)
 	}
 
 	for _, item := range job.Database {
@@ -73,3 +81,125 @@ func NewMsaJobRequest(query string, dbs []string, validDbs []Params, mode string
 
 	return request, nil
 }
+
+// splitA3m splits the null byte separated output of result2msa into one MSA per query
+func splitA3m(data []byte) [][]byte {
+	msas := bytes.Split(data, []byte{0})
+	if len(msas) > 0 && len(bytes.TrimSpace(msas[len(msas)-1])) == 0 {
+		msas = msas[:len(msas)-1]
+	}
+	return msas
+}
+
+// queryNames are the names of the per query files, the first word of each header like colabfold_search names them,
+// the index of the query if the header gives no usable or unique name
+func queryNames(fasta string) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(fasta, "\n") {
+		if !strings.HasPrefix(line, ">") {
+			continue
+		}
+		name := ""
+		if fields := strings.Fields(line[1:]); len(fields) > 0 {
+			name = cleanPathComponent.ReplaceAllString(fields[0], "_")
+		}
+		if name == "" || seen[name] {
+			name = strconv.Itoa(len(names))
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+func queryName(names []string, index int) string {
+	if index < len(names) {
+		return names[index]
+	}
+	return strconv.Itoa(index)
+}
+
+func addTarData(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Size:    int64(len(data)),
+		Mode:    0644,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// addQueryMsas adds one <name>.a3m file per query to the archive,
+// the MSAs of all given result2msa outputs are concatenated like colabfold_search does
+func addQueryMsas(tw *tar.Writer, names []string, paths []string) error {
+	merged := make([][]byte, 0)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for i, msa := range splitA3m(data) {
+			if i == len(merged) {
+				merged = append(merged, make([]byte, 0, len(msa)))
+			}
+			merged[i] = append(merged[i], msa...)
+		}
+	}
+
+	for i, msa := range merged {
+		if err := addTarData(tw, queryName(names, i)+".a3m", msa); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addQueryTemplates adds one <name>_pdb70.m8 file with the template hits of each query that has hits,
+// the query column of the hits is the first word of the query header
+func addQueryTemplates(tw *tar.Writer, fasta string, names []string, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int)
+	i := 0
+	for _, line := range strings.Split(fasta, "\n") {
+		if !strings.HasPrefix(line, ">") {
+			continue
+		}
+		if fields := strings.Fields(line[1:]); len(fields) > 0 {
+			if _, ok := index[fields[0]]; !ok {
+				index[fields[0]] = i
+			}
+		}
+		i++
+	}
+
+	hits := make(map[int][]byte)
+	order := make([]int, 0)
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		query, _, found := bytes.Cut(line, []byte("\t"))
+		if !found {
+			continue
+		}
+		i, ok := index[string(query)]
+		if !ok {
+			return errors.New("template hit of unknown query " + string(query))
+		}
+		if _, ok := hits[i]; !ok {
+			order = append(order, i)
+		}
+		hits[i] = append(hits[i], line...)
+	}
+	for _, i := range order {
+		if err := addTarData(tw, queryName(names, i)+"_pdb70.m8", hits[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
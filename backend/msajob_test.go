@@ -0,0 +1,68 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQueryMsas(t *testing.T) {
+	dir := t.TempDir()
+	fasta := ">sp|P1 first\nMKV\n>sp|P1 duplicate\nMKA\n>101M_A\nMVL\n"
+	names := queryNames(fasta)
+	if !reflect.DeepEqual(names, []string{"sp_P1", "1", "101M_A"}) {
+		t.Fatalf("unexpected names %v", names)
+	}
+
+	uniref := filepath.Join(dir, "uniref.a3m")
+	env := filepath.Join(dir, "env.a3m")
+	templates := filepath.Join(dir, "pdb70.m8")
+	files := map[string]string{
+		uniref:    ">q1\nMKV\n\x00>q2\nMKA\n\x00>q3\nMVL\n\x00",
+		env:       ">e1\nMKV\n\x00>e2\nMKA\n\x00>e3\nMVL\n\x00",
+		templates: "sp|P1\t1abc_A\t0.9\n101M_A\t2abc_B\t0.8\n101M_A\t3abc_C\t0.7\n",
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buffer bytes.Buffer
+	tw := tar.NewWriter(&buffer)
+	if err := addQueryMsas(tw, names, []string{uniref, env}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addQueryTemplates(tw, fasta, names, templates); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	entries := make(map[string]string)
+	tr := tar.NewReader(&buffer)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		entries[header.Name] = string(data)
+	}
+	expected := map[string]string{
+		"sp_P1.a3m":       ">q1\nMKV\n>e1\nMKV\n",
+		"1.a3m":           ">q2\nMKA\n>e2\nMKA\n",
+		"101M_A.a3m":      ">q3\nMVL\n>e3\nMVL\n",
+		"sp_P1_pdb70.m8":  "sp|P1\t1abc_A\t0.9\n",
+		"101M_A_pdb70.m8": "101M_A\t2abc_B\t0.8\n101M_A\t3abc_C\t0.7\n",
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected archive %v", entries)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type NotifyChannel string
+
+const (
+	NotifyNone  NotifyChannel = "none"
+	NotifyEmail NotifyChannel = "email"
+	// JSON of the ticket to the webhook of the job
+	NotifyWebhook NotifyChannel = "webhook"
+	// message to the chat webhook of the job or mail.webhook
+	NotifyChat NotifyChannel = "chat"
+)
+
+type NotifyEvents string
+
+const (
+	// complete, failed and timed out jobs
+	NotifyAll     NotifyEvents = "all"
+	NotifySuccess NotifyEvents = "success"
+)
+
+// ParseNotification reads the channels from notify= (comma separated or repeated) and the events from notifyon=,
+// channels of registered notifiers take their recipient from notify_<channel>=, so they can not clash with other fields
+func ParseNotification(request *JobRequest, form url.Values) error {
+	for _, value := range form["notify"] {
+		for _, channel := range strings.Split(value, ",") {
+			channel = strings.TrimSpace(channel)
+			switch NotifyChannel(channel) {
+			case NotifyNone, NotifyEmail, NotifyWebhook, NotifyChat:
+				request.Notify = append(request.Notify, NotifyChannel(channel))
+			case "":
+			default:
+				if !isRegisteredNotifier(NotifyChannel(channel)) {
+					return errors.New("invalid notification channel " + channel)
+				}
+				recipient := form.Get("notify_" + channel)
+				if recipient == "" {
+					return errors.New("the " + channel + " channel needs a recipient in notify_" + channel)
+				}
+				if request.Recipients == nil {
+					request.Recipients = make(map[NotifyChannel]string)
+				}
+				request.Notify = append(request.Notify, NotifyChannel(channel))
+				request.Recipients[NotifyChannel(channel)] = recipient
+			}
+		}
+	}
+	if locale := form.Get("locale"); locale != "" {
+		if !validLocale(locale) {
+			return errors.New("invalid locale " + locale)
+		}
+		request.Locale = locale
+	}
+	switch events := NotifyEvents(form.Get("notifyon")); events {
+	case "", NotifyAll, NotifySuccess:
+		request.NotifyOn = events
+	default:
+		return errors.New("invalid notification events " + string(events))
+	}
+	return setJobWebhook(request, form.Get("webhook"))
+}
+
+// jobChannels are the chosen channels of a job, jobs without a choice are notified at the addresses they have
+func jobChannels(config ConfigMail, job JobRequest) []NotifyChannel {
+	if len(job.Notify) > 0 {
+		return job.Notify
+	}
+	channels := make([]NotifyChannel, 0)
+	if job.Email != "" {
+		channels = append(channels, NotifyEmail)
+	}
+	if job.Webhook != "" || config.Webhook != nil {
+		channels = append(channels, NotifyChat)
+	}
+	return channels
+}
+
+// setJobWebhook validates the webhook of a submission, webhooks of the chat channel have to be of a supported chat service
+func setJobWebhook(request *JobRequest, address string) error {
+	if address == "" {
+		if isNotified(request.Notify, NotifyWebhook) {
+			return errors.New("the webhook channel needs a webhook")
+		}
+		return nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return errors.New("webhooks have to use https")
+	}
+	if len(request.Notify) == 0 || isNotified(request.Notify, NotifyChat) {
+		if _, err := detectWebhook(address); err != nil {
+			return err
+		}
+	}
+	request.Webhook = address
+	return nil
+}
+
+var validLocale = regexp.MustCompile(`^[A-Za-z]{2,8}([-_][A-Za-z0-9]{1,8})*$`).MatchString
+
+// localeTemplates are the templates of the most specific configured locale, empty templates fall back to the default ones
+func localeTemplates(config ConfigMail, locale string) (ConfigMailTemplates, string) {
+	templates := config.Templates
+	text := ""
+	if config.Webhook != nil {
+		text = config.Webhook.Text
+	}
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	for locale != "" {
+		if localized, ok := config.Locales[locale]; ok {
+			for _, pair := range [][2]*ConfigMailTemplate{
+				{&templates.Success, &localized.Templates.Success},
+				{&templates.Timeout, &localized.Templates.Timeout},
+				{&templates.Error, &localized.Templates.Error},
+			} {
+				if pair[1].Subject != "" || pair[1].Body != "" || pair[1].BodyFile != "" {
+					*pair[0] = *pair[1]
+				}
+			}
+			if localized.WebhookText != "" {
+				text = localized.WebhookText
+			}
+			break
+		}
+		if i := strings.LastIndex(locale, "-"); i > 0 {
+			locale = locale[:i]
+		} else {
+			locale = ""
+		}
+	}
+	return templates, text
+}
+
+// jobRecipient is the address of a job on a channel, empty if it has none
+func jobRecipient(config ConfigMail, job JobRequest, channel NotifyChannel) string {
+	switch channel {
+	case NotifyNone:
+		return ""
+	case NotifyEmail:
+		return job.Email
+	case NotifyWebhook:
+		return job.Webhook
+	case NotifyChat:
+		if hook := jobWebhook(config, job); hook != nil {
+			return hook.Url
+		}
+		return ""
+	}
+	return job.Recipients[channel]
+}
+
+func isNotified(channels []NotifyChannel, channel NotifyChannel) bool {
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// publicClient refuses to connect to loopback, private and link-local addresses, webhooks of submitters can not reach internal services
+var publicClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network string, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+					return errors.New("webhook address " + host + " is not public")
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// WebhookEvent is posted to the webhook channel when a job finished
+type WebhookEvent struct {
+	Ticket
+	ResultURL string `json:"resultUrl,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+}
+
+// withoutUrl drops the request URL from transport errors, URLs of webhooks and bots contain their secrets
+func withoutUrl(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+func sendWebhookEvent(address string, event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", address, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		return withoutUrl(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("webhook failed with status " + resp.Status)
+	}
+	return nil
+}
+
+// NotifyJob dispatches the notifications of a finished job to its channels, jobErr is nil for complete jobs
+func NotifyJob(config ConfigRoot, queue *DeliveryQueue, job JobRequest, jobErr *JobError) {
+	if jobErr != nil && job.NotifyOn == NotifySuccess {
+		return
+	}
+	data := MailData{
+		TicketID:  string(job.Id),
+		Status:    StatusComplete,
+		ResultURL: resultUrl(config.Mail, job.Id),
+		Database:  strings.Join(jobDatabases(job), ", "),
+	}
+	templates, webhookText := localeTemplates(config.Mail, job.Locale)
+	template := templates.Success
+	var attachment *MailAttachment
+	if jobErr == nil {
+		if summary, err := readJobSummary(filepath.Join(config.Paths.Results, string(job.Id))); err == nil {
+			data.Summary = summary.Text()
+			data.Hits = summary.TopHits
+			data.HitsTable = summary.HitsTable()
+			if config.Mail.Attachment != nil {
+				hits, maxSize := config.Mail.Attachment.Hits, config.Mail.Attachment.MaxSize
+				if hits == 0 {
+					hits = maxSummaryHits
+				}
+				if maxSize == 0 {
+					maxSize = defaultAttachmentSize
+				}
+				if a, ok := summary.hitsAttachment(job.Id, hits, maxSize); ok {
+					attachment = &a
+				}
+			}
+			data.Runtime = time.Duration(summary.Runtime * float64(time.Second)).Round(time.Second).String()
+		}
+	} else {
+		data.Status = errorStatus(jobErr.Code)
+		data.Error = jobErr.Message
+		data.Hint = jobErr.Hint
+		template = templates.Error
+		if jobErr.Code == ErrorTimeout {
+			template = templates.Timeout
+		}
+	}
+
+	if email := jobRecipient(config.Mail, job, NotifyEmail); config.Mail.Unsubscribe != nil && email != "" {
+		link, err := UnsubscribeURL(*config.Mail.Unsubscribe, email)
+		if err != nil {
+			notifyLog.Error("Failed to create unsubscribe link", "ticket", job.Id, "error", err)
+		}
+		data.UnsubscribeURL = link
+	}
+
+	event := NotifyEvent{data, nil, webhookText}
+	if mail, err := RenderMail(template, data); err == nil {
+		mail.Sender = config.Mail.Sender
+		if data.UnsubscribeURL != "" {
+			mail = withUnsubscribeLink(mail, data.UnsubscribeURL)
+		}
+		if attachment != nil {
+			mail.Attachments = []MailAttachment{*attachment}
+		}
+		event.Mail = &mail
+	} else if job.Email != "" {
+		notifyLog.Error("Failed to render mail", "ticket", job.Id, "error", err)
+	}
+	ticket := Ticket{job.Id, data.Status, jobErr}
+	for _, channel := range jobChannels(config.Mail, job) {
+		recipient := jobRecipient(config.Mail, job, channel)
+		if recipient == "" || (channel == NotifyEmail && event.Mail == nil) {
+			continue
+		}
+		queue.deliver(pendingDelivery{Id: job.Id, Channel: channel, Event: event, Ticket: ticket, Recipient: recipient})
+	}
+}
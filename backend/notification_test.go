@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingTransport struct {
+	mails []Mail
+}
+
+func (t *recordingTransport) Send(mail Mail) error {
+	t.mails = append(t.mails, mail)
+	return nil
+}
+
+func TestParseNotification(t *testing.T) {
+	var request JobRequest
+	err := ParseNotification(&request, url.Values{"notify": {"email,chat"}, "notifyon": {"success"}, "webhook": {"https://hooks.slack.com/services/X"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(request.Notify) != 2 || request.NotifyOn != NotifySuccess || request.Webhook == "" {
+		t.Errorf("unexpected notification settings %+v", request)
+	}
+
+	for _, form := range []url.Values{
+		{"notify": {"sms"}},
+		{"notifyon": {"sometimes"}},
+		{"notify": {"webhook"}},
+		{"notify": {"webhook"}, "webhook": {"http://example.org/hook"}},
+		{"notify": {"chat"}, "webhook": {"https://example.org/hook"}},
+	} {
+		if err := ParseNotification(&JobRequest{}, form); err == nil {
+			t.Errorf("invalid settings %v were accepted", form)
+		}
+	}
+	if err := ParseNotification(&JobRequest{}, url.Values{"notify": {"webhook"}, "webhook": {"https://example.org/hook"}}); err != nil {
+		t.Errorf("generic webhook was rejected: %s", err)
+	}
+}
+
+func TestNotifyJob(t *testing.T) {
+	config, _ := DefaultConfig()
+	config.Paths.Results = t.TempDir()
+	config.Mail.Templates.Timeout = ConfigMailTemplate{Subject: "Timeout -- %s", Body: "%s"}
+	mailer := &recordingTransport{}
+	store := &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	queue, _ := newDeliveryQueue(map[NotifyChannel]Notifier{NotifyEmail: mailNotifier{mailer}}, store, store, ConfigMailRetry{})
+	job := JobRequest{Id: "abc", Email: "user@example.org"}
+	if channels := jobChannels(config.Mail, job); len(channels) != 1 || channels[0] != NotifyEmail {
+		t.Errorf("unexpected default channels %v", channels)
+	}
+
+	NotifyJob(config, queue, job, NewJobError(ErrorTimeout, ""))
+	if len(mailer.mails) != 1 || !strings.HasPrefix(mailer.mails[0].Subject, "Timeout") {
+		t.Errorf("unexpected mails %+v", mailer.mails)
+	}
+
+	job.NotifyOn = NotifySuccess
+	NotifyJob(config, queue, job, NewJobError(ErrorInternal, ""))
+	job.Notify = []NotifyChannel{NotifyNone}
+	NotifyJob(config, queue, job, nil)
+	if len(mailer.mails) != 1 {
+		t.Errorf("mails were sent despite the settings: %+v", mailer.mails)
+	}
+}
+
+func TestFailTicket(t *testing.T) {
+	config, _ := DefaultConfig()
+	config.Paths.Results = t.TempDir()
+	jobsystem, _ := MakeLocalJobSystem(config.Paths.Results, false)
+	job := JobRequest{Id: Id("failticket-" + strings.Repeat("0", 27)), Status: StatusPending, Type: JobSearch, Job: SearchJob{Size: 1, Database: []string{"failticket_db"}, Mode: "all"}, Email: "user@example.org"}
+	data, _ := json.Marshal(job)
+	os.Mkdir(filepath.Join(config.Paths.Results, string(job.Id)), 0755)
+	if err := os.WriteFile(filepath.Join(config.Paths.Results, string(job.Id), "job.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mailer := &recordingTransport{}
+	store := &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	queue, _ := newDeliveryQueue(map[NotifyChannel]Notifier{NotifyEmail: mailNotifier{mailer}}, store, store, ConfigMailRetry{})
+
+	failTicket(&jobsystem, config, queue, job.Id, job, time.Second, ErrorTimeout, "timed out waiting for a database update")
+	if ticket, err := jobsystem.GetTicket(job.Id); err != nil || ticket.RawStatus != StatusTimeout || ticket.Error == nil || ticket.Error.Code != ErrorTimeout {
+		t.Errorf("unexpected ticket %+v %v", ticket, err)
+	}
+	if len(mailer.mails) != 1 {
+		t.Errorf("expected a mail about the failed job, got %+v", mailer.mails)
+	}
+	var buffer bytes.Buffer
+	workerMetrics.WriteMetrics(&buffer)
+	if !strings.Contains(buffer.String(), `mmseqs_worker_database_job_failures_total{database="failticket_db",error="TIMEOUT"} 1`) {
+		t.Errorf("the failure is missing in the metrics:\n%s", buffer.String())
+	}
+}
+
+func TestWebhookEventPublicOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	err := sendWebhookEvent(server.URL, WebhookEvent{Ticket{"abc", StatusComplete, nil}, "", ""})
+	if err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("webhook to a loopback address was not refused: %v", err)
+	}
+}
+
+func TestLocaleTemplates(t *testing.T) {
+	config := ConfigMail{
+		Templates: ConfigMailTemplates{Success: ConfigMailTemplate{Subject: "Done"}, Error: ConfigMailTemplate{Subject: "Error"}},
+		Webhook:   &ConfigWebhook{Url: "https://hooks.slack.com/services/X", Text: "Job {{.TicketID}}"},
+		Locales: map[string]ConfigMailLocale{
+			"de":    {ConfigMailTemplates{Success: ConfigMailTemplate{Subject: "Fertig"}}, "Auftrag {{.TicketID}}"},
+			"pt-br": {ConfigMailTemplates{Success: ConfigMailTemplate{Subject: "Pronto"}}, ""},
+		},
+	}
+	for locale, expected := range map[string][3]string{
+		"":      {"Done", "Error", "Job {{.TicketID}}"},
+		"de-AT": {"Fertig", "Error", "Auftrag {{.TicketID}}"},
+		"pt_BR": {"Pronto", "Error", "Job {{.TicketID}}"},
+		"fr":    {"Done", "Error", "Job {{.TicketID}}"},
+	} {
+		templates, text := localeTemplates(config, locale)
+		if templates.Success.Subject != expected[0] || templates.Error.Subject != expected[1] || text != expected[2] {
+			t.Errorf("unexpected templates for %q: %+v %q", locale, templates, text)
+		}
+	}
+
+	if err := ParseNotification(&JobRequest{}, url.Values{"locale": {"de; rm -rf"}}); err == nil {
+		t.Error("invalid locale was accepted")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// NotifyEvent is the outcome of a job as told to notifiers, Mail is the rendered mail template of the outcome
+type NotifyEvent struct {
+	Data MailData `json:"data"`
+	Mail *Mail    `json:"mail,omitempty"`
+	// chat text template in the locale of the job
+	Text string `json:"text,omitempty"`
+}
+
+// Notifier delivers the notification of a job to a recipient of its channel,
+// the recipient is an email address, a webhook URL or the value given for a custom channel
+type Notifier interface {
+	Notify(event NotifyEvent, ticket Ticket, recipient string) error
+}
+
+// NotifierFactory creates the notifier of a channel from mail.notifiers.<channel>, mailer is the mail transport of the worker
+type NotifierFactory func(config ConfigRoot, raw json.RawMessage, mailer MailTransport) (Notifier, error)
+
+var notifierRegistry = struct {
+	sync.RWMutex
+	factories map[NotifyChannel]NotifierFactory
+}{factories: make(map[NotifyChannel]NotifierFactory)}
+
+// RegisterNotifier adds a channel that submitters can choose with notify=<channel> and give the recipient as notify_<channel>=,
+// deployments compile in their own notifiers with an init function calling it. Registering a channel twice panics.
+func RegisterNotifier(channel NotifyChannel, factory NotifierFactory) {
+	notifierRegistry.Lock()
+	defer notifierRegistry.Unlock()
+	if _, ok := notifierRegistry.factories[channel]; ok {
+		panic("notifier " + string(channel) + " registered twice")
+	}
+	notifierRegistry.factories[channel] = factory
+}
+
+func isRegisteredNotifier(channel NotifyChannel) bool {
+	notifierRegistry.RLock()
+	defer notifierRegistry.RUnlock()
+	_, ok := notifierRegistry.factories[channel]
+	return ok
+}
+
+// newNotifiers creates the notifiers of all registered channels
+func newNotifiers(config ConfigRoot, mailer MailTransport) (map[NotifyChannel]Notifier, error) {
+	notifierRegistry.RLock()
+	defer notifierRegistry.RUnlock()
+	notifiers := make(map[NotifyChannel]Notifier, len(notifierRegistry.factories))
+	for channel, factory := range notifierRegistry.factories {
+		notifier, err := factory(config, config.Mail.Notifiers[channel], mailer)
+		if err != nil {
+			return nil, errors.New("notifier " + string(channel) + ": " + err.Error())
+		}
+		notifiers[channel] = notifier
+	}
+	return notifiers, nil
+}
+
+type mailNotifier struct {
+	mailer MailTransport
+}
+
+func (n mailNotifier) Notify(event NotifyEvent, ticket Ticket, recipient string) error {
+	if event.Mail == nil {
+		return errors.New("no mail for job " + string(ticket.Id))
+	}
+	mail := *event.Mail
+	mail.Recipient = recipient
+	return n.mailer.Send(mail)
+}
+
+// chatNotifier posts to the global webhook of mail.webhook or the chat webhook of a job
+type chatNotifier struct {
+	global *ConfigWebhook
+}
+
+func (n chatNotifier) Notify(event NotifyEvent, ticket Ticket, recipient string) error {
+	hook := ConfigWebhook{"", recipient, event.Text}
+	if n.global != nil && n.global.Url == recipient {
+		hook.Type = n.global.Type
+	}
+	return SendWebhook(hook, event.Data)
+}
+
+type webhookNotifier struct{}
+
+func (n webhookNotifier) Notify(event NotifyEvent, ticket Ticket, recipient string) error {
+	return sendWebhookEvent(recipient, WebhookEvent{ticket, event.Data.ResultURL, event.Data.Summary})
+}
+
+func init() {
+	RegisterNotifier(NotifyEmail, func(config ConfigRoot, raw json.RawMessage, mailer MailTransport) (Notifier, error) {
+		return mailNotifier{mailer}, nil
+	})
+	RegisterNotifier(NotifyChat, func(config ConfigRoot, raw json.RawMessage, mailer MailTransport) (Notifier, error) {
+		return chatNotifier{config.Mail.Webhook}, nil
+	})
+	RegisterNotifier(NotifyWebhook, func(config ConfigRoot, raw json.RawMessage, mailer MailTransport) (Notifier, error) {
+		return webhookNotifier{}, nil
+	})
+}
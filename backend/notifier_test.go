@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+type smsNotifier struct {
+	gateway  string
+	messages []string
+}
+
+func (n *smsNotifier) Notify(event NotifyEvent, ticket Ticket, recipient string) error {
+	n.messages = append(n.messages, n.gateway+" "+recipient+" "+string(ticket.Id)+" "+string(event.Data.Status))
+	return nil
+}
+
+func TestRegisterNotifier(t *testing.T) {
+	sms := &smsNotifier{}
+	RegisterNotifier("sms", func(config ConfigRoot, raw json.RawMessage, mailer MailTransport) (Notifier, error) {
+		var settings struct {
+			Gateway string `json:"gateway"`
+		}
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, err
+		}
+		sms.gateway = settings.Gateway
+		return sms, nil
+	})
+	defer func() {
+		notifierRegistry.Lock()
+		delete(notifierRegistry.factories, "sms")
+		notifierRegistry.Unlock()
+	}()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("registering a channel twice should panic")
+			}
+		}()
+		RegisterNotifier("sms", nil)
+	}()
+
+	var request JobRequest
+	if err := ParseNotification(&request, url.Values{"notify": {"sms"}, "sms": {"+49123"}}); err == nil {
+		t.Error("custom channel without a recipient in notify_sms was accepted")
+	}
+	request = JobRequest{Id: "abc"}
+	if err := ParseNotification(&request, url.Values{"notify": {"sms"}, "notify_sms": {"+49123"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, _ := DefaultConfig()
+	config.Paths.Results = t.TempDir()
+	config.Mail.Notifiers = map[NotifyChannel]json.RawMessage{"sms": json.RawMessage(`{"gateway":"gw"}`)}
+	notifiers, err := newNotifiers(config, NullTransport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	queue, _ := newDeliveryQueue(notifiers, store, store, ConfigMailRetry{})
+	NotifyJob(config, queue, request, nil)
+	if len(sms.messages) != 1 || sms.messages[0] != "gw +49123 abc COMPLETE" {
+		t.Errorf("unexpected messages %v", sms.messages)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliverySent     DeliveryStatus = "sent"
+	DeliveryRetrying DeliveryStatus = "retrying"
+	// all attempts failed
+	DeliveryFailed DeliveryStatus = "failed"
+	// the recipient does not get notifications, e.g. it is not verified or unsubscribed
+	DeliverySkipped DeliveryStatus = "skipped"
+	// the mail goes out with the next digest of its recipient
+	DeliveryQueued DeliveryStatus = "queued"
+)
+
+// errDeliverySkipped is returned by transports that drop a notification on purpose, it is not retried
+var errDeliverySkipped = errors.New("notification was not sent to this recipient")
+
+const defaultDeliveryAttempts = 5
+const defaultDeliveryBackoff = time.Minute
+
+// retries are at least attempted once a day
+const maxDeliveryBackoff = 24 * time.Hour
+
+// pending retries of the memory store are written to this file in paths.results
+const deliveryRetryFile = ".notify-retry.json"
+
+// statuses are kept for about as long as results usually are
+const deliveryStatusExpiry = 30 * 24 * time.Hour
+
+// Delivery is the outcome of the notification of a job on one channel
+type Delivery struct {
+	Channel     NotifyChannel  `json:"channel"`
+	Status      DeliveryStatus `json:"status"`
+	Attempts    int            `json:"attempts"`
+	Error       string         `json:"error,omitempty"`
+	NextAttempt *time.Time     `json:"nextAttempt,omitempty"`
+}
+
+// PublicDelivery is what anyone who knows the ticket sees of a Delivery, errors can name the recipient
+type PublicDelivery struct {
+	Channel  NotifyChannel  `json:"channel"`
+	Status   DeliveryStatus `json:"status"`
+	Attempts int            `json:"attempts"`
+}
+
+func publicDeliveries(deliveries []Delivery) []PublicDelivery {
+	public := make([]PublicDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		public = append(public, PublicDelivery{delivery.Channel, delivery.Status, delivery.Attempts})
+	}
+	return public
+}
+
+// pendingDelivery is the notification of a job to one recipient
+type pendingDelivery struct {
+	Id        Id            `json:"id"`
+	Channel   NotifyChannel `json:"channel"`
+	Event     NotifyEvent   `json:"event"`
+	Ticket    Ticket        `json:"ticket"`
+	Recipient string        `json:"recipient"`
+	Attempts  int           `json:"attempts"`
+	// unix time of the next attempt
+	Next int64 `json:"next"`
+}
+
+type deliveryStore interface {
+	Schedule(delivery pendingDelivery) error
+	// Due removes and returns the deliveries to attempt now
+	Due(now time.Time) ([]pendingDelivery, error)
+}
+
+type deliveryStatusStore interface {
+	SetDelivery(id Id, delivery Delivery) error
+	Deliveries(id Id) ([]Delivery, error)
+}
+
+type redisDeliveryStore struct {
+	client *redis.Client
+}
+
+func (s redisDeliveryStore) Schedule(delivery pendingDelivery) error {
+	member, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return s.client.ZAdd("mmseqs:notify:retry", redis.Z{Score: float64(delivery.Next), Member: member}).Err()
+}
+
+func (s redisDeliveryStore) Due(now time.Time) ([]pendingDelivery, error) {
+	members, err := s.client.ZRangeByScore("mmseqs:notify:retry", redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(now.Unix(), 10)}).Result()
+	if err != nil {
+		return nil, err
+	}
+	due := make([]pendingDelivery, 0, len(members))
+	for _, member := range members {
+		// another worker took it if nothing was removed
+		removed, err := s.client.ZRem("mmseqs:notify:retry", member).Result()
+		if err != nil {
+			return due, err
+		}
+		if removed == 0 {
+			continue
+		}
+		var delivery pendingDelivery
+		if err := json.Unmarshal([]byte(member), &delivery); err != nil {
+			notifyLog.Warn("Dropping invalid notification retry", "error", err)
+			continue
+		}
+		due = append(due, delivery)
+	}
+	return due, nil
+}
+
+func (s redisDeliveryStore) SetDelivery(id Id, delivery Delivery) error {
+	value, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	key := "mmseqs:notify:deliveries:" + string(id)
+	_, err = s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(key, string(delivery.Channel), value)
+		pipe.Expire(key, deliveryStatusExpiry)
+		return nil
+	})
+	return err
+}
+
+func (s redisDeliveryStore) Deliveries(id Id) ([]Delivery, error) {
+	values, err := s.client.HGetAll("mmseqs:notify:deliveries:" + string(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0, len(values))
+	for _, value := range values {
+		var delivery Delivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+func sortDeliveries(deliveries []Delivery) {
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Channel < deliveries[j].Channel })
+}
+
+// memoryDeliveryStore keeps retries in memory, server and workers of a local job system share it.
+// Once persist is called the pending retries are also written to a file, so they survive a restart,
+// the statuses are only kept until the process exits.
+type memoryDeliveryStore struct {
+	mu       sync.Mutex
+	path     string
+	pending  []pendingDelivery
+	statuses map[Id]map[NotifyChannel]Delivery
+}
+
+var localDeliveryStore = &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+
+// persist reads the retries left in path by the last process and keeps writing them there
+func (s *memoryDeliveryStore) persist(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == path {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var pending []pendingDelivery
+		if err := json.Unmarshal(data, &pending); err != nil {
+			notifyLog.Warn("Dropping invalid notification retries", "path", path, "error", err)
+		}
+		s.pending = append(s.pending, pending...)
+	}
+	s.path = path
+	return s.save()
+}
+
+func (s *memoryDeliveryStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.pending)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path+".part", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(s.path+".part", s.path)
+}
+
+func (s *memoryDeliveryStore) Schedule(delivery pendingDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, delivery)
+	return s.save()
+}
+
+func (s *memoryDeliveryStore) Due(now time.Time) ([]pendingDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []pendingDelivery
+	remaining := s.pending[:0]
+	for _, delivery := range s.pending {
+		if delivery.Next <= now.Unix() {
+			due = append(due, delivery)
+		} else {
+			remaining = append(remaining, delivery)
+		}
+	}
+	s.pending = remaining
+	if len(due) == 0 {
+		return due, nil
+	}
+	return due, s.save()
+}
+
+func (s *memoryDeliveryStore) SetDelivery(id Id, delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.statuses[id] == nil {
+		s.statuses[id] = make(map[NotifyChannel]Delivery)
+	}
+	s.statuses[id][delivery.Channel] = delivery
+	return nil
+}
+
+func (s *memoryDeliveryStore) Deliveries(id Id) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := make([]Delivery, 0, len(s.statuses[id]))
+	for _, delivery := range s.statuses[id] {
+		deliveries = append(deliveries, delivery)
+	}
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+// newDeliveryStatusStore is where the server reads the delivery status of tickets
+func newDeliveryStatusStore(jobsystem JobSystem) deliveryStatusStore {
+	if redisJobs, ok := jobsystem.(*RedisJobSystem); ok {
+		return redisDeliveryStore{redisJobs.Client}
+	}
+	return localDeliveryStore
+}
+
+// DeliveryQueue sends notifications and retries failed ones with exponential backoff
+type DeliveryQueue struct {
+	notifiers map[NotifyChannel]Notifier
+	store     deliveryStore
+	statuses  deliveryStatusStore
+	attempts  int
+	backoff   time.Duration
+}
+
+// NewDeliveryQueue keeps retries in redis, otherwise in a file in paths.results.
+// Remote workers report the status to the server.
+func NewDeliveryQueue(jobsystem JobSystem, config ConfigRoot, mailer MailTransport) (*DeliveryQueue, error) {
+	notifiers, err := newNotifiers(config, mailer)
+	if err != nil {
+		return nil, err
+	}
+	var store deliveryStore = localDeliveryStore
+	var statuses deliveryStatusStore = localDeliveryStore
+	switch jobs := jobsystem.(type) {
+	case *RedisJobSystem:
+		store = redisDeliveryStore{jobs.Client}
+		statuses = redisDeliveryStore{jobs.Client}
+	case *RemoteJobSystem:
+		statuses = jobs
+	}
+	if store == localDeliveryStore {
+		if err := localDeliveryStore.persist(filepath.Join(config.Paths.Results, deliveryRetryFile)); err != nil {
+			return nil, err
+		}
+	}
+	return newDeliveryQueue(notifiers, store, statuses, config.Mail.Retry)
+}
+
+func newDeliveryQueue(notifiers map[NotifyChannel]Notifier, store deliveryStore, statuses deliveryStatusStore, config ConfigMailRetry) (*DeliveryQueue, error) {
+	attempts := config.Attempts
+	if attempts == 0 {
+		attempts = defaultDeliveryAttempts
+	}
+	backoff := defaultDeliveryBackoff
+	if config.Backoff != "" {
+		var err error
+		if backoff, err = time.ParseDuration(config.Backoff); err != nil {
+			return nil, err
+		}
+	}
+	return &DeliveryQueue{notifiers, store, statuses, attempts, backoff}, nil
+}
+
+// retryDelay doubles the backoff with every failed attempt, up to maxDeliveryBackoff
+func (q *DeliveryQueue) retryDelay(attempts int) time.Duration {
+	delay := q.backoff
+	for i := 1; i < attempts && delay < maxDeliveryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDeliveryBackoff)
+}
+
+// deliver attempts a notification once and schedules a retry if it fails
+func (q *DeliveryQueue) deliver(delivery pendingDelivery) {
+	var err error
+	if notifier, ok := q.notifiers[delivery.Channel]; ok {
+		err = notifier.Notify(delivery.Event, delivery.Ticket, delivery.Recipient)
+	} else {
+		err = errors.New("no notifier for channel " + string(delivery.Channel))
+	}
+	// webhook and bot URLs contain their secrets
+	err = withoutUrl(err)
+	delivery.Attempts++
+	status := Delivery{delivery.Channel, DeliverySent, delivery.Attempts, "", nil}
+	if errors.Is(err, errDeliverySkipped) {
+		status.Status = DeliverySkipped
+	} else if errors.Is(err, errDeliveryQueued) {
+		status.Status = DeliveryQueued
+	} else if err != nil {
+		notifyLog.Warn("Failed to notify", "ticket", delivery.Id, "channel", delivery.Channel, "attempt", delivery.Attempts, "error", err)
+		status.Status = DeliveryFailed
+		status.Error = err.Error()
+		if delivery.Attempts < q.attempts {
+			next := time.Now().Add(q.retryDelay(delivery.Attempts))
+			delivery.Next = next.Unix()
+			if err := q.store.Schedule(delivery); err != nil {
+				notifyLog.Error("Failed to schedule notification retry", "ticket", delivery.Id, "error", err)
+			} else {
+				status.Status = DeliveryRetrying
+				status.NextAttempt = &next
+			}
+		}
+	}
+	if err := q.statuses.SetDelivery(delivery.Id, status); err != nil {
+		notifyLog.Error("Failed to store notification status", "ticket", delivery.Id, "error", err)
+	}
+}
+
+// Retry attempts the due deliveries once
+func (q *DeliveryQueue) Retry() {
+	due, err := q.store.Due(time.Now())
+	if err != nil {
+		notifyLog.Error("Failed to read notification retries", "error", err)
+	}
+	for _, delivery := range due {
+		q.deliver(delivery)
+	}
+}
+
+func (q *DeliveryQueue) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		q.Retry()
+	}
+}
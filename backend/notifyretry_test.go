@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type flakyTransport struct {
+	failures int
+	sent     int
+}
+
+func (t *flakyTransport) Send(mail Mail) error {
+	if t.failures > 0 {
+		t.failures--
+		return errors.New("connection refused")
+	}
+	t.sent++
+	return nil
+}
+
+// retries are scheduled in whole seconds
+func makeDue(store *memoryDeliveryStore) {
+	for i := range store.pending {
+		store.pending[i].Next = 0
+	}
+}
+
+func TestDeliveryQueue(t *testing.T) {
+	mailer := &flakyTransport{failures: 2}
+	store := &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	queue, err := newDeliveryQueue(map[NotifyChannel]Notifier{NotifyEmail: mailNotifier{mailer}}, store, store, ConfigMailRetry{Attempts: 3, Backoff: "1ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue.deliver(pendingDelivery{Id: "abc", Channel: NotifyEmail, Event: NotifyEvent{Mail: &Mail{}}, Recipient: "user@example.org"})
+	deliveries, _ := store.Deliveries("abc")
+	if len(deliveries) != 1 || deliveries[0].Status != DeliveryRetrying || deliveries[0].NextAttempt == nil {
+		t.Fatalf("unexpected status after a failure %+v", deliveries)
+	}
+	for i := 0; i < 2; i++ {
+		makeDue(store)
+		queue.Retry()
+	}
+	deliveries, _ = store.Deliveries("abc")
+	if mailer.sent != 1 || deliveries[0].Status != DeliverySent || deliveries[0].Attempts != 3 {
+		t.Errorf("retry did not deliver: sent %d, %+v", mailer.sent, deliveries)
+	}
+
+	mailer.failures = 3
+	queue.deliver(pendingDelivery{Id: "def", Channel: NotifyEmail, Event: NotifyEvent{Mail: &Mail{}}, Recipient: "user@example.org"})
+	for i := 0; i < 3; i++ {
+		makeDue(store)
+		queue.Retry()
+	}
+	deliveries, _ = store.Deliveries("def")
+	if deliveries[0].Status != DeliveryFailed || deliveries[0].Attempts != 3 || len(store.pending) != 0 {
+		t.Errorf("attempts were not capped: %+v", deliveries)
+	}
+}
+
+func TestDeliveryRetryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), deliveryRetryFile)
+	store := &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	if err := store.persist(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Schedule(pendingDelivery{Id: "abc", Channel: NotifyWebhook, Next: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	if err := restarted.persist(path); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := restarted.Due(time.Unix(2, 0)); err != nil || len(due) != 1 || due[0].Id != "abc" {
+		t.Fatalf("retry was not kept over a restart %+v %v", due, err)
+	}
+	restarted = &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	if err := restarted.persist(path); err != nil || len(restarted.pending) != 0 {
+		t.Errorf("attempted retries are removed from the file %+v %v", restarted.pending, err)
+	}
+}
+
+func TestDeliveryBackoff(t *testing.T) {
+	queue := &DeliveryQueue{backoff: time.Minute}
+	if delay := queue.retryDelay(3); delay != 4*time.Minute {
+		t.Errorf("expected 4m, got %s", delay)
+	}
+	if delay := queue.retryDelay(100); delay != maxDeliveryBackoff {
+		t.Errorf("expected the backoff to be capped, got %s", delay)
+	}
+}
+
+func TestSkippedDelivery(t *testing.T) {
+	store := &memoryDeliveryStore{statuses: make(map[Id]map[NotifyChannel]Delivery)}
+	mailer := optOutTransport{&flakyTransport{}, &memoryOptOutStore{optedOut: map[string]bool{addressHash("user@example.org"): true}}}
+	queue, err := newDeliveryQueue(map[NotifyChannel]Notifier{NotifyEmail: mailNotifier{mailer}}, store, store, ConfigMailRetry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.deliver(pendingDelivery{Id: "abc", Channel: NotifyEmail, Event: NotifyEvent{Mail: &Mail{}}, Recipient: "user@example.org"})
+	deliveries, _ := store.Deliveries("abc")
+	if len(deliveries) != 1 || deliveries[0].Status != DeliverySkipped || len(store.pending) != 0 {
+		t.Errorf("unexpected status of an unsubscribed address %+v", deliveries)
+	}
+}
+
+func TestDeliveryErrorsWithoutUrl(t *testing.T) {
+	err := SendWebhook(ConfigWebhook{Type: "slack", Url: "http://127.0.0.1:1/services/T000/B000/secret"}, MailData{})
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected an error without the webhook address, got %v", err)
+	}
+	public := publicDeliveries([]Delivery{{NotifyWebhook, DeliveryFailed, 1, "failed", nil}})
+	if data, _ := json.Marshal(public); strings.Contains(string(data), "error") {
+		t.Errorf("errors are not public %s", data)
+	}
+}
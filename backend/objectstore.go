@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectInfo describes an object of an object store, keys are relative to the prefix of the store
+type ObjectInfo struct {
+	Key      string
+	Size     int64
+	ETag     string
+	Modified time.Time
+}
+
+// S3Store is a minimal client for S3 compatible object stores (AWS S3, GCS interoperability, MinIO),
+// requests are path-style and signed with AWS signature version 4 if credentials are available
+type S3Store struct {
+	endpoint     string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	bucket       string
+	prefix       string
+	client       *http.Client
+}
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// NewS3Store connects to the bucket and prefix of an s3:// or gs:// URL,
+// credentials default to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+func NewS3Store(config ConfigObjectStore) (*S3Store, error) {
+	u, err := url.Parse(config.Source)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing bucket in " + config.Source)
+	}
+
+	region := config.Region
+	endpoint := config.Endpoint
+	switch u.Scheme {
+	case "s3":
+		if region == "" {
+			region = "us-east-1"
+		}
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+	case "gs":
+		// uses the XML API with HMAC keys
+		if region == "" {
+			region = "auto"
+		}
+		if endpoint == "" {
+			endpoint = "https://storage.googleapis.com"
+		}
+	default:
+		return nil, errors.New("unsupported object store " + config.Source)
+	}
+
+	accessKey := config.AccessKey
+	secretKey := config.SecretKey
+	sessionToken := ""
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Store{
+		strings.TrimSuffix(endpoint, "/"),
+		region,
+		accessKey,
+		secretKey,
+		sessionToken,
+		u.Host,
+		prefix,
+		&http.Client{},
+	}, nil
+}
+
+// awsEscape percent-encodes everything but unreserved characters, slashes are kept if path is set
+func awsEscape(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range values[key] {
+			parts = append(parts, awsEscape(key, false)+"="+awsEscape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signV4 adds an AWS signature version 4 for S3 to a request, all headers that are set and the host are signed
+func signV4(req *http.Request, region string, accessKey string, secretKey string, sessionToken string, now time.Time) {
+	signV4Service(req, "s3", region, accessKey, secretKey, sessionToken, now)
+}
+
+func signV4Service(req *http.Request, service string, region string, accessKey string, secretKey string, sessionToken string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	}
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func (s *S3Store) request(ctx context.Context, key string, query url.Values) (*http.Response, error) {
+	return s.do(ctx, "GET", key, query, nil, 0)
+}
+
+// do sends a request for a key of the bucket, bodies of uploads are not hashed but sent as UNSIGNED-PAYLOAD
+func (s *S3Store) do(ctx context.Context, method string, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	path := "/" + s.bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+	u.RawPath = awsEscape(path, true)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+	if s.accessKey != "" {
+		signV4(req, s.region, s.accessKey, s.secretKey, s.sessionToken, time.Now())
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		return nil, errors.New("object store: " + path + ": " + res.Status + " " + strings.TrimSpace(string(message)))
+	}
+	return res, nil
+}
+
+// List returns the objects whose key starts with prefix
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	type listBucketResult struct {
+		Contents []struct {
+			Key          string
+			Size         int64
+			ETag         string
+			LastModified time.Time
+		}
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+
+	objects := make([]ObjectInfo, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		res, err := s.request(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Contents {
+			objects = append(objects, ObjectInfo{strings.TrimPrefix(item.Key, s.prefix), item.Size, strings.Trim(item.ETag, `"`), item.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Get streams an object, the caller has to close it
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := s.request(ctx, s.prefix+key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// objects larger than this are uploaded in parts if their body can be read at offsets, a single PUT is limited to 5GB
+const multipartThreshold = 256 << 20
+
+// S3 allows at most 10000 parts of at most 5GB each
+const (
+	minPartSize = 64 << 20
+	maxParts    = 10000
+)
+
+// Put uploads an object of a known size
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	if at, ok := body.(io.ReaderAt); ok && size > multipartThreshold {
+		return s.putParts(ctx, key, at, size)
+	}
+	res, err := s.do(ctx, "PUT", s.prefix+key, nil, body, size)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// putParts uploads an object as a multipart upload, the upload is aborted if a part fails
+func (s *S3Store) putParts(ctx context.Context, key string, body io.ReaderAt, size int64) error {
+	res, err := s.do(ctx, "POST", s.prefix+key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	var upload struct {
+		UploadId string
+	}
+	err = xml.NewDecoder(res.Body).Decode(&upload)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	if upload.UploadId == "" {
+		return errors.New("object store: no upload id for " + key)
+	}
+
+	if err := s.uploadParts(ctx, key, upload.UploadId, body, size); err != nil {
+		if res, aerr := s.do(context.Background(), "DELETE", s.prefix+key, url.Values{"uploadId": {upload.UploadId}}, nil, 0); aerr == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3Store) uploadParts(ctx context.Context, key string, upload string, body io.ReaderAt, size int64) error {
+	partSize := int64(minPartSize)
+	if size > partSize*maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+	var complete struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {upload}}
+		res, err := s.do(ctx, "PUT", s.prefix+key, query, io.NewSectionReader(body, offset, length), length)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{number, res.Header.Get("ETag")})
+	}
+
+	payload, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(ctx, "POST", s.prefix+key, url.Values{"uploadId": {upload}}, bytes.NewReader(payload), int64(len(payload)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// failures of the completion can be reported with status 200
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil && err != io.EOF {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		return errors.New("object store: " + key + ": " + result.Code + " " + result.Message)
+	}
+	return nil
+}
+
+// Delete removes an object, removing a missing object is not an error
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	res, err := s.do(ctx, "DELETE", s.prefix+key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
@@ -3,20 +3,27 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"os"
 	"strings"
 )
 
 type PairJob struct {
-	Size  int    `json:"size" validate:"required"`
-	Mode  string `json:"mode"`
-	query string
+	Size int    `json:"size" validate:"required"`
+	Mode string `json:"mode"`
+	// the paired database and the environmental database, the colabfold databases if empty
+	Database []string `json:"database,omitempty"`
+	query    string
 }
 
 func (r PairJob) Hash() Id {
 	h := sha256.New224()
 	h.Write([]byte(r.query))
 	h.Write([]byte(r.Mode))
+	// jobs against the colabfold databases keep their ids
+	for _, database := range r.Database {
+		h.Write([]byte(database))
+	}
 
 	bs := h.Sum(nil)
 	return Id(base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(bs))
@@ -34,19 +41,35 @@ func (r PairJob) WriteFasta(path string) error {
 	return nil
 }
 
-func NewPairJobRequest(query string, mode string, mail string) (JobRequest, error) {
+func NewPairJobRequest(query string, dbs []string, validDbs []Params, mode string, mail string) (JobRequest, error) {
 	job := PairJob{
-		max(strings.Count(query, ">"), 1),
-		mode,
-		query,
+		Size:     max(strings.Count(query, ">"), 1),
+		Mode:     mode,
+		Database: dbs,
+		query:    query,
 	}
 
 	request := JobRequest{
-		job.Hash(),
-		StatusPending,
-		JobPair,
-		job,
-		mail,
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobPair,
+		Job:    job,
+		Email:  mail,
+	}
+
+	if len(dbs) > 2 {
+		return request, errors.New("a paired MSA uses at most a paired and an environmental database")
+	}
+	for _, database := range dbs {
+		valid := false
+		for _, item := range validDbs {
+			if item.Path == database && !item.Structure {
+				valid = true
+			}
+		}
+		if !valid {
+			return request, errors.New("selected databases are not valid")
+		}
 	}
 
 	return request, nil
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the running search job lists its finished databases in its result directory,
+// the server never reads the temporary directory of the worker
+const partialFile = "partial"
+const partialQuery = "partial_query"
+
+var partialMu sync.Mutex
+
+// publishPartial makes the results of a finished database search of a running job available,
+// the query database is copied once since the final mvdb moves it out of the temporary directory
+func publishPartial(resultBase string, tempDir string, index int, database string) error {
+	partialMu.Lock()
+	defer partialMu.Unlock()
+	query := filepath.Join(resultBase, partialQuery)
+	if !fileExists(query + ".index") {
+		latest := filepath.Join(tempDir, strconv.Itoa(index), "latest")
+		// the index goes last, a query database is complete once it exists
+		for _, suffix := range []string{"_h", "_h.index", "_h.dbtype", "", ".dbtype", ".index"} {
+			source := filepath.Join(latest, "query"+suffix)
+			if !fileExists(source) {
+				continue
+			}
+			if err := copyResultFile(source, query+suffix+".tmp"); err != nil {
+				return err
+			}
+			if err := os.Rename(query+suffix+".tmp", query+suffix); err != nil {
+				return err
+			}
+		}
+	}
+	file, err := os.OpenFile(filepath.Join(resultBase, partialFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(database + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// removePartial removes the partial results once the final results of the job are in place
+func removePartial(resultBase string) error {
+	matches, err := filepath.Glob(filepath.Join(resultBase, partialQuery+"*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range append(matches, filepath.Join(resultBase, partialFile)) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishedDatabases returns the databases of a running search job whose results are already written,
+// the worker publishes each of them after its alignments are complete
+func finishedDatabases(resultBase string, databases []string) ([]string, error) {
+	finished := make([]string, 0)
+	file, err := os.Open(filepath.Join(resultBase, partialFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return finished, nil
+	}
+	if err != nil {
+		return finished, err
+	}
+	defer file.Close()
+
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		done[strings.TrimSpace(scanner.Text())] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return finished, err
+	}
+	for _, database := range databases {
+		if done[database] {
+			finished = append(finished, database)
+		}
+	}
+	return finished, nil
+}
+
+// partialQueryDb is the query database of a running search job, it is only moved into the result directory
+// once all databases are searched, until then the copy published with the first finished database is used
+func partialQueryDb(resultBase string) (string, error) {
+	query := filepath.Join(resultBase, partialQuery)
+	if !fileExists(query + ".index") {
+		return "", errors.New("queries of the running job are not available")
+	}
+	return query, nil
+}
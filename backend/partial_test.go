@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFinishedDatabases(t *testing.T) {
+	resultBase := t.TempDir()
+	tempDir := t.TempDir()
+	databases := []string{"uniref", "pdb", "mgnify"}
+	finished, err := finishedDatabases(resultBase, databases)
+	if err != nil || len(finished) != 0 {
+		t.Fatalf("job without checkpoint has finished databases: %v %v", finished, err)
+	}
+	if _, err := partialQueryDb(resultBase); err == nil {
+		t.Error("missing query database was not reported")
+	}
+
+	latest := filepath.Join(tempDir, "2", "latest")
+	if err := os.MkdirAll(latest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"query": "MKV\n\x00", "query.index": "0\t0\t6\n", "query_h": "q\n\x00", "query_h.index": "0\t0\t4\n"} {
+		if err := os.WriteFile(filepath.Join(latest, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := publishPartial(resultBase, tempDir, 2, "mgnify"); err != nil {
+		t.Fatal(err)
+	}
+	// the final mvdb moves the query database away from the temporary directory
+	if err := os.RemoveAll(latest); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishPartial(resultBase, tempDir, 1, "pdb"); err != nil {
+		t.Fatal(err)
+	}
+	finished, err = finishedDatabases(resultBase, databases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(finished, []string{"pdb", "mgnify"}) {
+		t.Errorf("unexpected finished databases %v", finished)
+	}
+	query, err := partialQueryDb(resultBase)
+	if err != nil || query != filepath.Join(resultBase, partialQuery) || !fileExists(query+"_h.index") {
+		t.Errorf("unexpected query database %s %v", query, err)
+	}
+
+	if err := removePartial(resultBase); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(resultBase); len(entries) != 0 {
+		t.Errorf("partial results were not removed: %v", entries)
+	}
+}
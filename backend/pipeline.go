@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// expandPipelineArgs replaces ${NAME} variables in the arguments of a pipeline step, unknown variables are an error
+func expandPipelineArgs(args []string, vars map[string]string) ([]string, error) {
+	expanded := make([]string, len(args))
+	var unknown error
+	for i, arg := range args {
+		expanded[i] = os.Expand(arg, func(name string) string {
+			value, ok := vars[name]
+			if !ok && unknown == nil {
+				unknown = errors.New("unknown pipeline variable " + name)
+			}
+			return value
+		})
+	}
+	return expanded, unknown
+}
+
+func runStep(ctx context.Context, executor Executor, parameters ...string) error {
+	cmd, done, err := execCommand(executor, parameters...)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		if err := cmd.Kill(); err != nil {
+			executorLog.Error("Failed to kill", "error", err)
+		}
+		return &JobTimeoutError{}
+	case err := <-done:
+		return err
+	}
+}
+
+// RunPipeline runs the steps of a configured pipeline for every selected database of a search job.
+// The query database is created first, so that the result viewer works if the last step writes alis_<database>.
+func RunPipeline(ctx context.Context, request JobRequest, job SearchJob, pipeline ConfigPipeline, config ConfigRoot, executor Executor, tempDir string, checkpoint *Checkpoint) error {
+	resultBase := filepath.Join(config.Paths.Results, string(request.Id))
+	queryDb := filepath.Join(resultBase, "query")
+	if !checkpoint.Done("createdb") {
+		err := runStep(ctx, executor, config.Paths.Mmseqs, "createdb", filepath.Join(resultBase, "job.fasta"), queryDb, "--shuffle", "0", "--write-lookup", "1")
+		if err != nil {
+			return &JobExecutionError{err}
+		}
+		if err := checkpoint.Mark("createdb"); err != nil {
+			return &JobExecutionError{err}
+		}
+	}
+
+	_, threads := databaseSlots(config, 1)
+	for index, database := range job.Database {
+		params, err := ReadParams(filepath.Join(config.Paths.Databases, database+".params"))
+		if err != nil {
+			return &JobExecutionError{err}
+		}
+
+		work := filepath.Join(tempDir, strconv.Itoa(index))
+		if err := os.MkdirAll(work, 0755); err != nil {
+			return &JobExecutionError{err}
+		}
+		vars := map[string]string{
+			"QUERY":   filepath.Join(resultBase, "job.fasta"),
+			"QUERYDB": queryDb,
+			"TARGET":  filepath.Join(config.Paths.Databases, database),
+			"DBNAME":  database,
+			"RESULT":  filepath.Join(resultBase, "alis_"+database),
+			"BASE":    resultBase,
+			"WORK":    work,
+			"TMP":     filepath.Join(work, "tmp"),
+			"THREADS": strconv.Itoa(threads),
+		}
+
+		for i, step := range pipeline.Steps {
+			stage := database + "-" + strconv.Itoa(i)
+			if checkpoint.Done(stage) {
+				continue
+			}
+			args, err := expandPipelineArgs(step.Args, vars)
+			if err != nil {
+				return &JobExecutionError{err}
+			}
+			parameters := append([]string{config.Paths.Mmseqs, step.Module}, args...)
+			if err := runStep(ctx, executor.ForDatabase(params), parameters...); err != nil {
+				return &JobExecutionError{err}
+			}
+			if err := checkpoint.Mark(stage); err != nil {
+				return &JobExecutionError{err}
+			}
+		}
+	}
+
+	path := filepath.Join(resultBase, "mmseqs_results_"+string(request.Id)+".tar.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		return &JobExecutionError{err}
+	}
+	if len(pipeline.Output) == 0 {
+		err = ResultArchive(file, request.Id, resultBase)
+	} else {
+		err = pipelineArchive(file, resultBase, pipeline.Output)
+	}
+	if err != nil {
+		file.Close()
+		return &JobExecutionError{err}
+	}
+	if err := file.Close(); err != nil {
+		return &JobExecutionError{err}
+	}
+	return nil
+}
+
+// pipelineArchive packs the files matching the output patterns of a pipeline
+func pipelineArchive(file *os.File, base string, patterns []string) (err error) {
+	gw := gzip.NewWriter(file)
+	defer func() {
+		cerr := gw.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+	tw := tar.NewWriter(gw)
+	defer func() {
+		cerr := tw.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(base, filepath.Base(pattern)))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	for i, path := range paths {
+		if i > 0 && paths[i-1] == path {
+			continue
+		}
+		if err := addFile(tw, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+)
+
+// jobPriority returns the process priority settings of the priority class of a job
+func jobPriority(config ConfigRoot, request JobRequest) ConfigProcessPriority {
+	return config.Worker.Priorities[request.Priority(config.Worker.BatchThreshold)]
+}
+
+// wrap prefixes a call with nice and ionice, the priority is inherited by all child processes
+func (p ConfigProcessPriority) wrap(parameters []string) []string {
+	prefix := make([]string, 0)
+	if p.Nice != 0 {
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(p.Nice))
+	}
+	switch p.IOClass {
+	case "idle":
+		prefix = append(prefix, "ionice", "-c", "3")
+	case "best-effort":
+		prefix = append(prefix, "ionice", "-c", "2", "-n", strconv.Itoa(p.IOLevel))
+	case "realtime":
+		prefix = append(prefix, "ionice", "-c", "1", "-n", strconv.Itoa(p.IOLevel))
+	}
+	if len(prefix) == 0 {
+		return parameters
+	}
+	return append(prefix, parameters...)
+}
+
+// containerArgs converts the cgroup weights to the equivalent docker/podman run arguments
+func (p ConfigProcessPriority) containerArgs() []string {
+	args := make([]string, 0)
+	if p.CPUWeight > 0 {
+		// the default cpu.weight of 100 corresponds to 1024 cpu shares
+		args = append(args, "--cpu-shares", strconv.Itoa(max(2, p.CPUWeight*1024/100)))
+	}
+	if p.IOWeight > 0 {
+		// blkio-weight has to be in 10-1000, io.weight is in 1-10000
+		weight := p.IOWeight / 10
+		if weight < 10 {
+			weight = 10
+		}
+		args = append(args, "--blkio-weight", strconv.Itoa(weight))
+	}
+	return args
+}
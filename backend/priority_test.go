@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcessPriorityWrap(t *testing.T) {
+	call := []string{"mmseqs", "search"}
+	tests := []struct {
+		priority ConfigProcessPriority
+		expected []string
+	}{
+		{ConfigProcessPriority{}, []string{"mmseqs", "search"}},
+		{ConfigProcessPriority{Nice: 10}, []string{"nice", "-n", "10", "mmseqs", "search"}},
+		{ConfigProcessPriority{IOClass: "idle"}, []string{"ionice", "-c", "3", "mmseqs", "search"}},
+		{ConfigProcessPriority{Nice: 19, IOClass: "best-effort", IOLevel: 7}, []string{"nice", "-n", "19", "ionice", "-c", "2", "-n", "7", "mmseqs", "search"}},
+	}
+	for _, test := range tests {
+		got := test.priority.wrap(call)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("wrap(%+v) = %v, expected %v", test.priority, got, test.expected)
+		}
+	}
+}
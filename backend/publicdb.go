@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// params format of databases that are downloaded with the databases module, their source is the database name
+const publicDatabaseFormat = "databases"
+
+// PublicDatabase is a database the databases module of mmseqs/foldseek can download
+type PublicDatabase struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Taxonomy bool   `json:"taxonomy"`
+	URL      string `json:"url"`
+}
+
+// parsePublicDatabases reads the table of downloadable databases from the usage of the databases module,
+// every database is on a line starting with "- " with tab separated name, type, taxonomy and URL columns
+func parsePublicDatabases(usage string) []PublicDatabase {
+	databases := make([]PublicDatabase, 0)
+	for _, line := range strings.Split(usage, "\n") {
+		if !strings.HasPrefix(line, "- ") {
+			continue
+		}
+		fields := strings.Split(strings.TrimPrefix(line, "- "), "\t")
+		if len(fields) < 4 {
+			continue
+		}
+		databases = append(databases, PublicDatabase{
+			strings.TrimSpace(fields[0]),
+			strings.TrimSpace(fields[1]),
+			strings.TrimSpace(fields[2]) == "yes",
+			strings.TrimSpace(fields[3]),
+		})
+	}
+	return databases
+}
+
+func appBinary(config ConfigRoot) string {
+	if config.App == AppFoldSeek {
+		return config.Paths.FoldSeek
+	}
+	return config.Paths.Mmseqs
+}
+
+// PublicDatabases lists the databases that can be downloaded with the installed binary
+func PublicDatabases(config ConfigRoot) ([]PublicDatabase, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// the usage is printed with a non-zero exit code
+	out, _ := exec.CommandContext(ctx, appBinary(config), "databases", "-h").CombinedOutput()
+	databases := parsePublicDatabases(string(out))
+	if len(databases) == 0 {
+		return nil, errors.New("could not list downloadable databases")
+	}
+	return databases, nil
+}
+
+func findPublicDatabase(config ConfigRoot, name string) (PublicDatabase, error) {
+	databases, err := PublicDatabases(config)
+	if err != nil {
+		return PublicDatabase{}, err
+	}
+	for _, db := range databases {
+		if db.Name == name {
+			return db, nil
+		}
+	}
+	return PublicDatabase{}, errors.New("unknown database " + name)
+}
+
+// downloadPublicDatabase runs the databases module and indexes the downloaded database
+func downloadPublicDatabase(ctx context.Context, config ConfigRoot, name string, file string, params Params, executor Executor, tempDir string, stage func(DatabaseStage)) error {
+	_, threads := databaseSlots(config, 1)
+	err := runStep(ctx, executor, appBinary(config), "databases", name, file, filepath.Join(tempDir, "download"), "--threads", strconv.Itoa(threads))
+	if err != nil {
+		return err
+	}
+
+	stage(DatabaseIndexing)
+	parameters := []string{
+		appBinary(config),
+		"createindex",
+		file,
+		filepath.Join(tempDir, "index"),
+		"--remove-tmp-files",
+		"true",
+		"--check-compatible",
+		"1",
+		"--threads",
+		strconv.Itoa(threads),
+	}
+	parameters = append(parameters, indexParameters(params, config)...)
+	parameters = append(parameters, strings.Fields(params.Index)...)
+	if err := runStep(ctx, executor, parameters...); err != nil {
+		return err
+	}
+	return runStep(ctx, executor, appBinary(config), "touchdb", file)
+}
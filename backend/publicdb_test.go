@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePublicDatabases(t *testing.T) {
+	usage := `usage: mmseqs databases <name> <o:sequenceDB> <tmpDir> [options]
+
+  Name                	Type      	Taxonomy	Url                                                           
+- UniRef100           	Aminoacid 	     yes	https://www.uniprot.org/help/uniref
+- PDB70               	Profile   	       -	https://github.com/soedinglab/hh-suite
+options: misc:
+ --tsv BOOL  Return output in TSV format [0]
+`
+	expected := []PublicDatabase{
+		{"UniRef100", "Aminoacid", true, "https://www.uniprot.org/help/uniref"},
+		{"PDB70", "Profile", false, "https://github.com/soedinglab/hh-suite"},
+	}
+	if got := parsePublicDatabases(usage); !reflect.DeepEqual(got, expected) {
+		t.Errorf("parsePublicDatabases = %+v, expected %+v", got, expected)
+	}
+}
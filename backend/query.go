@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	AlphabetProtein    = "ACDEFGHIKLMNPQRSTVWYBZJXUO"
+	AlphabetNucleotide = "ACGTUNRYKMSWBDHV"
+)
+
+// at most this many record errors are reported back
+const maxQueryErrors = 20
+
+type QueryError struct {
+	Errors []string
+}
+
+func (e *QueryError) Error() string {
+	messages := e.Errors
+	suffix := ""
+	if len(messages) > maxQueryErrors {
+		suffix = "\nand " + strconv.Itoa(len(messages)-maxQueryErrors) + " more errors"
+		messages = messages[:maxQueryErrors]
+	}
+	return "invalid query:\n" + strings.Join(messages, "\n") + suffix
+}
+
+type SanitizedQuery struct {
+	Fasta string
+	Size  int
+	// headers of removed records, keyed by the index of the identical record that is searched instead
+	Duplicates map[int][]string
+}
+
+// SanitizeFasta normalizes a FASTA query before it is enqueued. Gaps, white space and terminal stop codons are removed,
+// sequences are upper cased and checked against the alphabet and the configured length limits.
+// With dedup only the first of a set of identical sequences is kept.
+func SanitizeFasta(query string, alphabet string, limits ConfigQueryLimits, dedup bool) (SanitizedQuery, error) {
+	type record struct {
+		header   string
+		sequence strings.Builder
+	}
+	var records []*record
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ">") {
+			records = append(records, &record{header: strings.TrimSpace(line[1:])})
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		// a single sequence can be given without header
+		if len(records) == 0 {
+			records = append(records, &record{header: "query"})
+		}
+		records[len(records)-1].sequence.WriteString(line)
+	}
+
+	var problems []string
+	if len(records) == 0 {
+		problems = append(problems, "no sequences found")
+	}
+	if limits.MaxSequences > 0 && len(records) > limits.MaxSequences {
+		problems = append(problems, fmt.Sprintf("%d sequences exceed the maximum of %d", len(records), limits.MaxSequences))
+	}
+
+	var fasta strings.Builder
+	seen := make(map[string]int)
+	duplicates := make(map[int][]string)
+	size := 0
+	total := 0
+	for i, r := range records {
+		name := "record " + strconv.Itoa(i+1)
+		if r.header != "" {
+			name += " (" + r.header + ")"
+		}
+
+		sequence := strings.Map(func(c rune) rune {
+			switch c {
+			case '-', '.', ' ', '\t', '\r':
+				return -1
+			}
+			if c >= 'a' && c <= 'z' {
+				return c - 'a' + 'A'
+			}
+			return c
+		}, r.sequence.String())
+		sequence = strings.TrimRight(sequence, "*")
+
+		if sequence == "" {
+			problems = append(problems, name+": empty sequence")
+			continue
+		}
+		if pos := strings.IndexFunc(sequence, func(c rune) bool { return !strings.ContainsRune(alphabet, c) }); pos != -1 {
+			c, _ := utf8.DecodeRuneInString(sequence[pos:])
+			problems = append(problems, fmt.Sprintf("%s: invalid character %q at position %d", name, c, pos+1))
+			continue
+		}
+		if limits.MaxLength > 0 && len(sequence) > limits.MaxLength {
+			problems = append(problems, fmt.Sprintf("%s: length %d exceeds the maximum of %d", name, len(sequence), limits.MaxLength))
+			continue
+		}
+
+		if dedup {
+			if first, ok := seen[sequence]; ok {
+				duplicates[first] = append(duplicates[first], r.header)
+				continue
+			}
+			seen[sequence] = size
+		}
+		total += len(sequence)
+		size++
+		fasta.WriteString(">" + r.header + "\n" + sequence + "\n")
+	}
+
+	if limits.TotalLength > 0 && total > limits.TotalLength {
+		problems = append(problems, fmt.Sprintf("total length %d exceeds the maximum of %d", total, limits.TotalLength))
+	}
+	if len(problems) > 0 {
+		return SanitizedQuery{}, &QueryError{problems}
+	}
+	if len(duplicates) == 0 {
+		duplicates = nil
+	}
+	return SanitizedQuery{fasta.String(), size, duplicates}, nil
+}
+
+// hashDuplicates writes the duplicate mapping in a stable order
+func hashDuplicates(h io.Writer, duplicates map[int][]string) {
+	keys := make([]int, 0, len(duplicates))
+	for key := range duplicates {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		h.Write([]byte(strconv.Itoa(key)))
+		for _, header := range duplicates[key] {
+			h.Write([]byte(header))
+		}
+	}
+}
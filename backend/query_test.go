@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFasta(t *testing.T) {
+	query := ">a\nmk-LV.\nAA*\n\n>b\nMKLVAA\n>c\nGGG\n>d\nmklvaa\n"
+	sanitized, err := SanitizeFasta(query, AlphabetProtein, ConfigQueryLimits{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sanitized.Fasta != ">a\nMKLVAA\n>c\nGGG\n" {
+		t.Errorf("unexpected fasta %q", sanitized.Fasta)
+	}
+	if sanitized.Size != 2 {
+		t.Errorf("expected 2 sequences, got %d", sanitized.Size)
+	}
+	if !reflect.DeepEqual(sanitized.Duplicates, map[int][]string{0: {"b", "d"}}) {
+		t.Errorf("unexpected duplicates %v", sanitized.Duplicates)
+	}
+
+	sanitized, err = SanitizeFasta("MKLV\n", AlphabetProtein, ConfigQueryLimits{}, false)
+	if err != nil || sanitized.Fasta != ">query\nMKLV\n" {
+		t.Errorf("unexpected result for sequence without header %q, %v", sanitized.Fasta, err)
+	}
+
+	_, err = SanitizeFasta(">a\nMK1V\n>b\n\n>c\nMKLVMKLV\n", AlphabetProtein, ConfigQueryLimits{MaxLength: 5}, true)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, expected := range []string{"record 1 (a): invalid character '1' at position 3", "record 2 (b): empty sequence", "record 3 (c): length 8 exceeds the maximum of 5"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("error %q does not contain %q", err.Error(), expected)
+		}
+	}
+}
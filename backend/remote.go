@@ -0,0 +1,666 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+const workerTokenHeader = "X-Worker-Token"
+
+// remote workers renew the lease of a job while they run it, jobs whose lease expired are requeued
+const (
+	remoteLeaseDuration = 5 * time.Minute
+	remoteLeaseRenewal  = 1 * time.Minute
+)
+
+// leaseStore keeps the leases of the jobs handed out to remote workers
+type leaseStore interface {
+	Lease(id Id, until time.Time) error
+	Leased(id Id) (bool, error)
+	// Release is true if the lease existed, so only one server requeues an expired job
+	Release(id Id) (bool, error)
+	Expired(now time.Time) ([]Id, error)
+}
+
+type redisLeaseStore struct {
+	client *redis.Client
+}
+
+func (s redisLeaseStore) Lease(id Id, until time.Time) error {
+	return s.client.ZAdd("mmseqs:leases", redis.Z{Score: float64(until.Unix()), Member: string(id)}).Err()
+}
+
+func (s redisLeaseStore) Leased(id Id) (bool, error) {
+	_, err := s.client.ZScore("mmseqs:leases", string(id)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s redisLeaseStore) Release(id Id) (bool, error) {
+	removed, err := s.client.ZRem("mmseqs:leases", string(id)).Result()
+	return removed > 0, err
+}
+
+func (s redisLeaseStore) Expired(now time.Time) ([]Id, error) {
+	members, err := s.client.ZRangeByScore("mmseqs:leases", redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(now.Unix(), 10)}).Result()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]Id, len(members))
+	for i, member := range members {
+		ids[i] = Id(member)
+	}
+	return ids, nil
+}
+
+type memoryLeaseStore struct {
+	mu     sync.Mutex
+	leases map[Id]time.Time
+}
+
+func (s *memoryLeaseStore) Lease(id Id, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leases[id] = until
+	return nil
+}
+
+func (s *memoryLeaseStore) Leased(id Id) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.leases[id]
+	return ok, nil
+}
+
+func (s *memoryLeaseStore) Release(id Id) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.leases[id]
+	delete(s.leases, id)
+	return ok, nil
+}
+
+func (s *memoryLeaseStore) Expired(now time.Time) ([]Id, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]Id, 0)
+	for id, until := range s.leases {
+		if !until.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func newLeaseStore(jobsystem JobSystem) leaseStore {
+	if redisJobs, ok := jobsystem.(*RedisJobSystem); ok {
+		return redisLeaseStore{redisJobs.Client}
+	}
+	return &memoryLeaseStore{leases: make(map[Id]time.Time)}
+}
+
+// requeueExpiredLeases puts the jobs of remote workers that stopped renewing their lease back into the queue
+func requeueExpiredLeases(jobsystem JobSystem, leases leaseStore, now time.Time) {
+	expired, err := leases.Expired(now)
+	if err != nil {
+		workerLog.Error("Failed to check job leases", "error", err)
+		return
+	}
+	for _, id := range expired {
+		released, err := leases.Release(id)
+		if err != nil || !released {
+			continue
+		}
+		status, err := jobsystem.Status(id)
+		if err != nil || (status != StatusPending && status != StatusRunning) {
+			continue
+		}
+		workerLog.Warn("Requeuing job of a remote worker whose lease expired", "ticket", id)
+		if err := jobsystem.Requeue(id); err != nil {
+			workerLog.Error("Failed to requeue job", "ticket", id, "error", err)
+		}
+	}
+}
+
+// validWorkerStatus tells if a remote worker may report status for a job that is currently in state current
+func validWorkerStatus(current Status, status Status) bool {
+	if current != StatusPending && current != StatusRunning {
+		return false
+	}
+	switch status {
+	case StatusRunning, StatusComplete, StatusError, StatusTimeout:
+		return true
+	}
+	return false
+}
+
+// archiveDirectory writes all files below base as a tar.gz, files in skip (relative to base) are left out
+func archiveDirectory(w io.Writer, base string, skip []string) (err error) {
+	gw := gzip.NewWriter(w)
+	defer func() {
+		cerr := gw.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+	tw := tar.NewWriter(gw)
+	defer func() {
+		cerr := tw.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	return filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if isIn(name, skip) != -1 {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, file)
+		return err
+	})
+}
+
+// extractArchive unpacks a tar.gz created by archiveDirectory into base, entries escaping base are rejected
+func extractArchive(r io.Reader, base string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	base = filepath.Clean(base)
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		path := filepath.Join(base, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, base+string(os.PathSeparator)) {
+			return errors.New("invalid path in archive: " + header.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, tr); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// RegisterWorkerHandlers adds the endpoints remote workers use to pull jobs, report their status and upload results
+func RegisterWorkerHandlers(r *mux.Router, jobsystem JobSystem, config ConfigRoot) {
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			token := req.Header.Get(workerTokenHeader)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(config.Server.WorkerToken)) != 1 {
+				http.Error(w, "Invalid worker token", http.StatusUnauthorized)
+				return
+			}
+			next(w, req)
+		}
+	}
+
+	leases := newLeaseStore(jobsystem)
+	go func() {
+		for {
+			time.Sleep(remoteLeaseRenewal)
+			requeueExpiredLeases(jobsystem, leases, time.Now())
+		}
+	}()
+	// leased checks that a job is still handed out to a worker, a requeued job may run on another one
+	leased := func(w http.ResponseWriter, id Id) bool {
+		ok, err := leases.Leased(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		if !ok {
+			http.Error(w, "Job "+string(id)+" is not leased to a worker", http.StatusConflict)
+			return false
+		}
+		return true
+	}
+
+	r.HandleFunc("/worker/dequeue", authorized(func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.Dequeue()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if ticket == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := leases.Lease(ticket.Id, time.Now().Add(remoteLeaseDuration)); err != nil {
+			jobsystem.Requeue(ticket.Id)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Ticket-Id", string(ticket.Id))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		err = archiveDirectory(w, filepath.Join(config.Paths.Results, string(ticket.Id)), nil)
+		if err != nil {
+			leases.Release(ticket.Id)
+			jobsystem.SetStatus(ticket.Id, StatusError)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})).Methods("POST")
+
+	r.HandleFunc("/worker/lease/{ticket}", authorized(func(w http.ResponseWriter, req *http.Request) {
+		id := Id(mux.Vars(req)["ticket"])
+		if !leased(w, id) {
+			return
+		}
+		if err := leases.Lease(id, time.Now().Add(remoteLeaseDuration)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})).Methods("POST")
+
+	r.HandleFunc("/worker/status/{ticket}", authorized(func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !leased(w, ticket.Id) {
+			return
+		}
+		current, err := jobsystem.Status(ticket.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// workers before error codes only send a message
+		status := Status(req.FormValue("status"))
+		code, message := req.FormValue("code"), req.FormValue("error")
+		if code != "" || message != "" {
+			if code == "" {
+				code = string(ErrorInternal)
+			}
+			status = errorStatus(ErrorCode(code))
+		}
+		if !validWorkerStatus(current, status) {
+			http.Error(w, "Job "+string(ticket.Id)+" can not change from "+string(current)+" to "+string(status), http.StatusConflict)
+			return
+		}
+		if status == StatusRunning {
+			err = jobsystem.SetStatus(ticket.Id, status)
+		} else {
+			// the job is finished, its lease ends
+			if _, err := leases.Release(ticket.Id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if code != "" {
+				err = jobsystem.SetError(ticket.Id, ErrorCode(code), message)
+			} else {
+				err = jobsystem.SetStatus(ticket.Id, status)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	})).Methods("POST")
+
+	r.HandleFunc("/worker/result/{ticket}", authorized(func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !leased(w, ticket.Id) {
+			return
+		}
+		err = extractArchive(req.Body, filepath.Join(config.Paths.Results, string(ticket.Id)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	})).Methods("POST")
+
+	deliveries := newDeliveryStatusStore(jobsystem)
+	r.HandleFunc("/worker/delivery/{ticket}", authorized(func(w http.ResponseWriter, req *http.Request) {
+		var delivery Delivery
+		if err := json.NewDecoder(req.Body).Decode(&delivery); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := deliveries.SetDelivery(Id(mux.Vars(req)["ticket"]), delivery); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})).Methods("POST")
+
+	heartbeats := newHeartbeatStore(jobsystem)
+	r.HandleFunc("/worker/heartbeat", authorized(func(w http.ResponseWriter, req *http.Request) {
+		if err := heartbeats.Beat(req.FormValue("worker")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})).Methods("POST")
+
+	verifications := newVerificationStore(jobsystem)
+	r.HandleFunc("/worker/verified", authorized(func(w http.ResponseWriter, req *http.Request) {
+		verified, err := verifications.Verified(req.FormValue("address"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(verified)
+	})).Methods("POST")
+
+	optOuts, err := newOptOutStore(jobsystem, config)
+	if err != nil {
+		panic(err)
+	}
+	r.HandleFunc("/worker/optedout", authorized(func(w http.ResponseWriter, req *http.Request) {
+		optedOut, err := optOuts.OptedOut(req.FormValue("address"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(optedOut)
+	})).Methods("POST")
+}
+
+// RemoteJobSystem is used by workers that do not share the results directory with the server.
+// Jobs are downloaded into the local results directory, results are uploaded when the job finishes.
+type RemoteJobSystem struct {
+	Url     string
+	Token   string
+	Results string
+	client  *http.Client
+
+	mu sync.Mutex
+	// closed when the job finished, its lease is renewed until then
+	renewals map[Id]chan struct{}
+}
+
+func MakeRemoteJobSystem(config ConfigRemote, results string) *RemoteJobSystem {
+	return &RemoteJobSystem{
+		Url:      strings.TrimSuffix(config.Url, "/"),
+		Token:    config.Token,
+		Results:  results,
+		client:   &http.Client{Timeout: 30 * time.Minute},
+		renewals: make(map[Id]chan struct{}),
+	}
+}
+
+var errRemoteUnsupported = errors.New("not supported by remote job system")
+
+func (j *RemoteJobSystem) post(path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", j.Url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(workerTokenHeader, j.Token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, errors.New(path + ": " + resp.Status + " " + strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+func (j *RemoteJobSystem) Dequeue() (*Ticket, error) {
+	resp, err := j.post("/worker/dequeue", "", nil)
+	if err != nil {
+		return &Ticket{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	id := Id(resp.Header.Get("X-Ticket-Id"))
+	if id == "" || filepath.Base(string(id)) != string(id) {
+		return &Ticket{}, errors.New("invalid ticket id")
+	}
+
+	base := filepath.Join(j.Results, string(id))
+	if err := os.RemoveAll(base); err != nil {
+		return &Ticket{}, err
+	}
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return &Ticket{}, err
+	}
+	if err := extractArchive(resp.Body, base); err != nil {
+		return &Ticket{}, err
+	}
+
+	stop := make(chan struct{})
+	j.mu.Lock()
+	j.renewals[id] = stop
+	j.mu.Unlock()
+	go j.renew(id, stop)
+	return &Ticket{id, StatusPending, nil}, nil
+}
+
+// renew extends the lease of a running job, the server requeues the job if the worker stops renewing it
+func (j *RemoteJobSystem) renew(id Id, stop chan struct{}) {
+	ticker := time.NewTicker(remoteLeaseRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			resp, err := j.post("/worker/lease/"+url.PathEscape(string(id)), "", nil)
+			if err != nil {
+				workerLog.Error("Failed to renew job lease", "ticket", id, "error", err)
+				continue
+			}
+			resp.Body.Close()
+		}
+	}
+}
+
+func (j *RemoteJobSystem) finished(id Id) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if stop, ok := j.renewals[id]; ok {
+		close(stop)
+		delete(j.renewals, id)
+	}
+}
+
+// Verified asks the server, which keeps the verified notification addresses
+func (j *RemoteJobSystem) Verified(address string) (bool, error) {
+	resp, err := j.post("/worker/verified", "application/x-www-form-urlencoded", strings.NewReader(url.Values{"address": {address}}.Encode()))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var verified bool
+	err = json.NewDecoder(resp.Body).Decode(&verified)
+	return verified, err
+}
+
+// OptedOut asks the server, which keeps the unsubscribed notification addresses
+func (j *RemoteJobSystem) OptedOut(address string) (bool, error) {
+	resp, err := j.post("/worker/optedout", "application/x-www-form-urlencoded", strings.NewReader(url.Values{"address": {address}}.Encode()))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var optedOut bool
+	err = json.NewDecoder(resp.Body).Decode(&optedOut)
+	return optedOut, err
+}
+
+// SetDelivery reports the notification status of a job to the server
+func (j *RemoteJobSystem) SetDelivery(id Id, delivery Delivery) error {
+	body, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	resp, err := j.post("/worker/delivery/"+url.PathEscape(string(id)), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (j *RemoteJobSystem) Deliveries(id Id) ([]Delivery, error) {
+	return nil, errRemoteUnsupported
+}
+
+// Beat reports the heartbeat of a remote worker to the server
+func (j *RemoteJobSystem) Beat(worker string) error {
+	resp, err := j.post("/worker/heartbeat", "application/x-www-form-urlencoded", strings.NewReader(url.Values{"worker": {worker}}.Encode()))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// upload sends the results of a finished job to the server and removes the local copy
+func (j *RemoteJobSystem) upload(id Id) error {
+	base := filepath.Join(j.Results, string(id))
+	reader, writer := io.Pipe()
+	go func() {
+		// the server keeps the authoritative job.json
+		writer.CloseWithError(archiveDirectory(writer, base, []string{"job.json"}))
+	}()
+
+	resp, err := j.post("/worker/result/"+string(id), "application/octet-stream", reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return os.RemoveAll(base)
+}
+
+func (j *RemoteJobSystem) report(id Id, status Status, code ErrorCode, message string) error {
+	if status != StatusPending && status != StatusRunning {
+		// the lease is renewed during the upload, after the final status the server does not expect it anymore
+		defer j.finished(id)
+		if err := j.upload(id); err != nil {
+			return err
+		}
+	}
+
+	form := url.Values{}
+	form.Set("status", string(status))
+	form.Set("code", string(code))
+	form.Set("error", message)
+	resp, err := j.post("/worker/status/"+string(id), "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (j *RemoteJobSystem) SetStatus(id Id, status Status) error {
+	return j.report(id, status, "", "")
+}
+
+func (j *RemoteJobSystem) SetError(id Id, code ErrorCode, message string) error {
+	return j.report(id, errorStatus(code), code, message)
+}
+
+func (j *RemoteJobSystem) Status(Id) (Status, error) {
+	return StatusUnknown, errRemoteUnsupported
+}
+
+func (j *RemoteJobSystem) GetTicket(Id) (Ticket, error) {
+	return Ticket{}, errRemoteUnsupported
+}
+
+func (j *RemoteJobSystem) NewJob(JobRequest, string, bool) (Ticket, error) {
+	return Ticket{}, errRemoteUnsupported
+}
+
+func (j *RemoteJobSystem) MultiStatus([]string) ([]Ticket, error) {
+	return nil, errRemoteUnsupported
+}
+
+func (j *RemoteJobSystem) QueueLength() (int, error) {
+	return 0, errRemoteUnsupported
+}
+
+func (j *RemoteJobSystem) Requeue(Id) error {
+	return errRemoteUnsupported
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "pdbs"), 0755)
+	os.WriteFile(filepath.Join(src, "job.json"), []byte("{}"), 0644)
+	os.WriteFile(filepath.Join(src, "job.fasta"), []byte(">q\nMAAA\n"), 0644)
+	os.WriteFile(filepath.Join(src, "pdbs", "a.pdb"), []byte("ATOM"), 0644)
+
+	var buf bytes.Buffer
+	if err := archiveDirectory(&buf, src, []string{"job.json"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	if err := extractArchive(&buf, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dst, "pdbs", "a.pdb")); err != nil || string(data) != "ATOM" {
+		t.Errorf("nested file not extracted: %q %v", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "job.fasta")); err != nil || string(data) != ">q\nMAAA\n" {
+		t.Errorf("file not extracted: %q %v", data, err)
+	}
+	if fileExists(filepath.Join(dst, "job.json")) {
+		t.Errorf("skipped file was extracted")
+	}
+}
+
+func TestExtractArchiveRejectsTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "../escape", Size: 1, Mode: 0644, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+	gw.Close()
+
+	dst := t.TempDir()
+	if err := extractArchive(&buf, filepath.Join(dst, "job")); err == nil {
+		t.Errorf("expected an error for an entry outside of the target directory")
+	}
+	if fileExists(filepath.Join(dst, "escape")) {
+		t.Errorf("entry outside of the target directory was written")
+	}
+}
+
+type requeueRecorder struct {
+	JobSystem
+	statuses map[Id]Status
+	requeued []Id
+}
+
+func (j *requeueRecorder) Status(id Id) (Status, error) {
+	return j.statuses[id], nil
+}
+
+func (j *requeueRecorder) Requeue(id Id) error {
+	j.requeued = append(j.requeued, id)
+	return nil
+}
+
+func TestExpiredLeases(t *testing.T) {
+	now := time.Now()
+	leases := &memoryLeaseStore{leases: make(map[Id]time.Time)}
+	leases.Lease("running", now.Add(-time.Second))
+	leases.Lease("renewed", now.Add(remoteLeaseDuration))
+	leases.Lease("complete", now.Add(-time.Second))
+	jobs := &requeueRecorder{statuses: map[Id]Status{"running": StatusRunning, "renewed": StatusRunning, "complete": StatusComplete}}
+
+	requeueExpiredLeases(jobs, leases, now)
+	if len(jobs.requeued) != 1 || jobs.requeued[0] != "running" {
+		t.Errorf("expected only the job with the expired lease to be requeued, got %v", jobs.requeued)
+	}
+	if ok, _ := leases.Leased("running"); ok {
+		t.Error("the lease of a requeued job should be released")
+	}
+	if ok, _ := leases.Leased("renewed"); !ok {
+		t.Error("renewed leases should be kept")
+	}
+	// a second server sees the lease already released
+	requeueExpiredLeases(jobs, leases, now)
+	if len(jobs.requeued) != 1 {
+		t.Errorf("expired leases should be requeued once, got %v", jobs.requeued)
+	}
+}
+
+func TestWorkerStatusTransitions(t *testing.T) {
+	for _, test := range []struct {
+		current Status
+		status  Status
+		valid   bool
+	}{
+		{StatusPending, StatusRunning, true},
+		{StatusRunning, StatusComplete, true},
+		{StatusRunning, StatusTimeout, true},
+		{StatusPending, StatusError, true},
+		{StatusRunning, StatusPending, false},
+		{StatusRunning, "DONE", false},
+		{StatusComplete, StatusRunning, false},
+		{StatusError, StatusComplete, false},
+	} {
+		if validWorkerStatus(test.current, test.status) != test.valid {
+			t.Errorf("%s to %s should be valid: %v", test.current, test.status, test.valid)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultExportColumns = "query,target,pident,alnlen,mismatch,gapopen,qstart,qend,tstart,tend,evalue,bits"
+
+// storedColumns are the positions of the convertalis columns the worker writes into the alignment results of a job,
+// the taxonomy columns only exist for databases with taxonomy, structure searches write placeholders instead
+// to keep the superposition columns appended after them
+func storedColumns(request JobRequest) (map[string]int, error) {
+	names := []string{"query", "target", "pident", "alnlen", "mismatch", "gapopen", "qstart", "qend", "tstart", "tend"}
+	switch request.Job.(type) {
+	case SearchJob:
+		names = append(names, "evalue", "bits", "qlen", "tlen", "qaln", "taln")
+	case StructureSearchJob:
+		names = append(names, "prob", "evalue", "bits", "qlen", "tlen", "qaln", "taln", "tca", "tseq")
+	case ComplexSearchJob:
+		names = append(names, "prob", "evalue", "bits", "qlen", "tlen", "qaln", "taln", "tca", "tseq", "complexassignid", "complexqtmscore", "complexttmscore", "complexu", "complext")
+	default:
+		return nil, errors.New("job type " + string(request.Type) + " has no alignment results")
+	}
+	names = append(names, "taxid", "taxname", "taxlineage")
+	if _, ok := request.Job.(StructureSearchJob); ok {
+		names = append(names, "alntmscore", "u", "t")
+	}
+	columns := make(map[string]int, len(names)+1)
+	for i, name := range names {
+		columns[name] = i
+	}
+	// databases with full headers have the header in the target column
+	columns["theader"] = columns["target"]
+	return columns, nil
+}
+
+func alignedIdentities(qaln string, taln string) int {
+	identities := 0
+	for i := 0; i < len(qaln) && i < len(taln); i++ {
+		if qaln[i] == taln[i] && qaln[i] != '-' {
+			identities++
+		}
+	}
+	return identities
+}
+
+func alignmentCigar(qaln string, taln string) string {
+	var cigar strings.Builder
+	var last byte
+	length := 0
+	for i := 0; i < len(qaln) && i < len(taln); i++ {
+		var op byte = 'M'
+		switch {
+		case qaln[i] == '-':
+			op = 'D'
+		case taln[i] == '-':
+			op = 'I'
+		}
+		if op != last && length > 0 {
+			cigar.WriteString(strconv.Itoa(length))
+			cigar.WriteByte(last)
+			length = 0
+		}
+		last = op
+		length++
+	}
+	if length > 0 {
+		cigar.WriteString(strconv.Itoa(length))
+		cigar.WriteByte(last)
+	}
+	return cigar.String()
+}
+
+// derivedColumns are convertalis columns that are computed from the stored ones
+var derivedColumns = map[string]func(value func(string) string) string{
+	"qcov": func(value func(string) string) string {
+		start, _ := strconv.Atoi(value("qstart"))
+		end, _ := strconv.Atoi(value("qend"))
+		length, _ := strconv.Atoi(value("qlen"))
+		return strconv.FormatFloat(queryCoverage(start, end, length), 'f', 3, 64)
+	},
+	"tcov": func(value func(string) string) string {
+		start, _ := strconv.Atoi(value("tstart"))
+		end, _ := strconv.Atoi(value("tend"))
+		length, _ := strconv.Atoi(value("tlen"))
+		return strconv.FormatFloat(queryCoverage(start, end, length), 'f', 3, 64)
+	},
+	"nident": func(value func(string) string) string {
+		return strconv.Itoa(alignedIdentities(value("qaln"), value("taln")))
+	},
+	"fident": func(value func(string) string) string {
+		length, _ := strconv.Atoi(value("alnlen"))
+		if length == 0 {
+			return "0.000"
+		}
+		return strconv.FormatFloat(float64(alignedIdentities(value("qaln"), value("taln")))/float64(length), 'f', 3, 64)
+	},
+	"cigar": func(value func(string) string) string {
+		return alignmentCigar(value("qaln"), value("taln"))
+	},
+}
+
+// parseExportColumns checks a comma separated column list against the columns available for a job
+func parseExportColumns(list string, stored map[string]int) ([]string, error) {
+	if list == "" {
+		list = defaultExportColumns
+	}
+	columns := strings.Split(list, ",")
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+		if _, ok := stored[column]; !ok && derivedColumns[column] == nil {
+			return nil, errors.New("column " + column + " is not available for this job")
+		}
+		columns[i] = column
+	}
+	return columns, nil
+}
+
+// ExportColumns writes the selected columns of the alignments with a header row, comma separated for csv and tab separated otherwise
+func ExportColumns(w io.Writer, base string, databases []string, request JobRequest, columns []string, csvFormat bool, queries []uint32) error {
+	stored, err := storedColumns(request)
+	if err != nil {
+		return err
+	}
+	_, foldseek, err := resultDatabases(request)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+	var cw *csv.Writer
+	write := func(record []string) error {
+		_, err := out.WriteString(strings.Join(record, "\t") + "\n")
+		return err
+	}
+	if csvFormat {
+		cw = csv.NewWriter(out)
+		write = cw.Write
+	}
+	if err := write(columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	reader := Reader[uint32]{}
+	for _, database := range databases {
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return err
+		}
+		err := forEachAlignment(&reader, resultColumns(foldseek), queries, func(fields []string) error {
+			value := func(name string) string {
+				if i, ok := stored[name]; ok && i < len(fields) {
+					return fields[i]
+				}
+				return ""
+			}
+			for i, column := range columns {
+				if derived := derivedColumns[column]; derived != nil {
+					record[i] = derived(value)
+				} else {
+					record[i] = value(column)
+				}
+			}
+			return write(record)
+		})
+		reader.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// exportFormat writes the columns given in columns= with a header row
+func exportFormat(csvFormat bool) func(r ResultRequest) (ResultWriter, error) {
+	return func(r ResultRequest) (ResultWriter, error) {
+		stored, err := storedColumns(r.Request)
+		if err != nil {
+			return nil, err
+		}
+		columns, err := parseExportColumns(r.Params.Get("columns"), stored)
+		if err != nil {
+			return nil, err
+		}
+		return func(w io.Writer) error {
+			return ExportColumns(w, r.Base, r.Databases, r.Request, columns, csvFormat, r.Queries)
+		}, nil
+	}
+}
+
+func init() {
+	RegisterResultFormatter("tsv", resultFormat{"tsv", "text/tab-separated-values", exportFormat(false)})
+	RegisterResultFormatter("csv", resultFormat{"csv", "text/csv", exportFormat(true)})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExportColumns(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "alis_db", "q1\tt1, a protein\t50.0\t4\t2\t0\t1\t4\t5\t8\t1.2E-5\t30\t8\t20\tAC-D\tACGD\n\x00")
+	request := JobRequest{Type: JobSearch, Job: SearchJob{Database: []string{"db"}}}
+	stored, err := storedColumns(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, err := parseExportColumns("query,theader,evalue,qcov,nident,cigar,taxname", stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := ExportColumns(&out, dir, []string{"db"}, request, columns, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "query\ttheader\tevalue\tqcov\tnident\tcigar\ttaxname\nq1\tt1, a protein\t1.2E-5\t0.500\t3\t2M1D1M\t\n"; out.String() != expected {
+		t.Errorf("unexpected tsv %q", out.String())
+	}
+
+	out.Reset()
+	if err := ExportColumns(&out, dir, []string{"db"}, request, columns[:2], true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "query,theader\nq1,\"t1, a protein\"\n"; out.String() != expected {
+		t.Errorf("unexpected csv %q", out.String())
+	}
+
+	if _, err := parseExportColumns("query,tseq", stored); err == nil {
+		t.Error("columns that are not stored for sequence searches should be rejected")
+	}
+}
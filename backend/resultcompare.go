@@ -0,0 +1,116 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// ComparedScore is the best alignment of a target for a query in one of the compared jobs
+type ComparedScore struct {
+	Database string  `json:"db"`
+	Evalue   float64 `json:"eval"`
+	Bits     int     `json:"score"`
+	Identity float64 `json:"seqId"`
+}
+
+// ComparedHit is a query and target pair with its scores in the first and second job, nil where the job did not find it
+type ComparedHit struct {
+	Query  string         `json:"query"`
+	Target string         `json:"target"`
+	First  *ComparedScore `json:"first,omitempty"`
+	Second *ComparedScore `json:"second,omitempty"`
+	// bit score of the second job minus the one of the first
+	BitsDelta int `json:"scoreDelta"`
+}
+
+type ResultComparison struct {
+	Shared     []ComparedHit `json:"shared"`
+	OnlyFirst  []ComparedHit `json:"onlyFirst"`
+	OnlySecond []ComparedHit `json:"onlySecond"`
+}
+
+type comparedPair struct {
+	query  string
+	target string
+}
+
+// bestHits reads the alignment with the highest bit score of every query and target pair of a job
+func bestHits(base string, request JobRequest, query string) (map[comparedPair]ComparedScore, error) {
+	databases, foldseek, err := resultDatabases(request)
+	if err != nil {
+		return nil, err
+	}
+	columns := resultColumns(foldseek)
+	hits := make(map[comparedPair]ComparedScore)
+	reader := Reader[uint32]{}
+	for _, database := range databases {
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return nil, err
+		}
+		err := forEachAlignment(&reader, columns, nil, func(fields []string) error {
+			if query != "" && fields[0] != query {
+				return nil
+			}
+			evalue, err := strconv.ParseFloat(fields[columns.Evalue], 64)
+			if err != nil {
+				return err
+			}
+			bits, err := strconv.Atoi(fields[columns.Bits])
+			if err != nil {
+				return err
+			}
+			identity, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return err
+			}
+			pair := comparedPair{fields[0], fields[1]}
+			if best, ok := hits[pair]; !ok || bits > best.Bits {
+				hits[pair] = ComparedScore{database, evalue, bits, identity}
+			}
+			return nil
+		})
+		reader.Delete()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hits, nil
+}
+
+// CompareResults diffs the hits of two jobs by query and target, e.g. of the same query searched with different
+// parameters or database versions. query restricts the comparison to one query if it is not empty.
+func CompareResults(firstBase string, first JobRequest, secondBase string, second JobRequest, query string) (ResultComparison, error) {
+	comparison := ResultComparison{make([]ComparedHit, 0), make([]ComparedHit, 0), make([]ComparedHit, 0)}
+	a, err := bestHits(firstBase, first, query)
+	if err != nil {
+		return comparison, err
+	}
+	b, err := bestHits(secondBase, second, query)
+	if err != nil {
+		return comparison, err
+	}
+	for pair, score := range a {
+		score := score
+		if other, ok := b[pair]; ok {
+			comparison.Shared = append(comparison.Shared, ComparedHit{pair.query, pair.target, &score, &other, other.Bits - score.Bits})
+		} else {
+			comparison.OnlyFirst = append(comparison.OnlyFirst, ComparedHit{pair.query, pair.target, &score, nil, -score.Bits})
+		}
+	}
+	for pair, score := range b {
+		score := score
+		if _, ok := a[pair]; !ok {
+			comparison.OnlySecond = append(comparison.OnlySecond, ComparedHit{pair.query, pair.target, nil, &score, score.Bits})
+		}
+	}
+	for _, hits := range [][]ComparedHit{comparison.Shared, comparison.OnlyFirst, comparison.OnlySecond} {
+		sort.Slice(hits, func(i, j int) bool {
+			if hits[i].Query != hits[j].Query {
+				return hits[i].Query < hits[j].Query
+			}
+			return hits[i].Target < hits[j].Target
+		})
+	}
+	return comparison, nil
+}
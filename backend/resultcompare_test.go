@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCompareResults(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writeTestDatabase(t, first, "alis_db", "q1\tA\t90.0\t100\t10\t0\t1\t100\t1\t100\t1E-30\t120\t100\t100\tAAA\tAAA\nq1\tB\t40.0\t100\t60\t0\t1\t100\t1\t100\t1E-5\t40\t100\t100\tAAA\tAAA\n\x00")
+	writeTestDatabase(t, second, "alis_db", "q1\tA\t90.0\t100\t10\t0\t1\t100\t1\t100\t1E-35\t130\t100\t100\tAAA\tAAA\nq1\tC\t30.0\t100\t70\t0\t1\t100\t1\t100\t1E-3\t30\t100\t100\tAAA\tAAA\n\x00")
+	request := JobRequest{Type: JobSearch, Job: SearchJob{Database: []string{"db"}}}
+
+	comparison, err := CompareResults(first, request, second, request, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comparison.Shared) != 1 || comparison.Shared[0].Target != "A" || comparison.Shared[0].BitsDelta != 10 {
+		t.Errorf("unexpected shared hits %+v", comparison.Shared)
+	}
+	if len(comparison.OnlyFirst) != 1 || comparison.OnlyFirst[0].Target != "B" || comparison.OnlyFirst[0].Second != nil {
+		t.Errorf("unexpected hits of the first job %+v", comparison.OnlyFirst)
+	}
+	if len(comparison.OnlySecond) != 1 || comparison.OnlySecond[0].Target != "C" {
+		t.Errorf("unexpected hits of the second job %+v", comparison.OnlySecond)
+	}
+
+	if comparison, err = CompareResults(first, request, second, request, "q2"); err != nil || len(comparison.Shared)+len(comparison.OnlyFirst)+len(comparison.OnlySecond) != 0 {
+		t.Errorf("other queries should not be compared: %+v %v", comparison, err)
+	}
+}
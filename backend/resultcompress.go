@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressed result files are stored next to the original name with this suffix
+const zstdSuffix = ".zst"
+
+// suffix of the optional gzip copies of compressed flat files
+const gzipSuffix = ".gz"
+
+// EncodeAll and DecodeAll can be used concurrently
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// compressedResultFile is true for the flat alignment and MSA files of a result
+func compressedResultFile(name string) bool {
+	for _, suffix := range []string{".m8", ".a3m", ".sto", ".sam"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return name == "foldmason.json"
+}
+
+// CompressResults compresses the alignment databases and the MSA files of a finished job with zstd.
+// The databases are compressed entry by entry, so that single queries can still be read without decompressing the whole file.
+// With gzipCopy the flat files are also written gzip compressed, so clients without zstd support get them compressed as well.
+func CompressResults(dir string, gzipCopy bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, ".index") || strings.HasSuffix(name, zstdSuffix) {
+			continue
+		}
+		if (strings.HasPrefix(name, "alis_") || strings.HasPrefix(name, "msa_")) && fileExists(path+".index") {
+			err = compressDatabase(path)
+		} else if compressedResultFile(name) {
+			err = compressFile(path, gzipCopy)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressDatabase writes <path>.zst with one zstd frame per entry and its index, the originals are only removed once both are complete
+func compressDatabase(path string) error {
+	reader := Reader[uint32]{}
+	if err := reader.Make(dbpaths(path)); err != nil {
+		return err
+	}
+	defer reader.Delete()
+
+	data, err := os.Create(path + zstdSuffix + ".part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(data.Name())
+	index, err := os.Create(path + zstdSuffix + ".index.part")
+	if err != nil {
+		data.Close()
+		return err
+	}
+	defer os.Remove(index.Name())
+
+	dw := bufio.NewWriter(data)
+	iw := bufio.NewWriter(index)
+	var offset uint64
+	for i := int64(0); i < reader.Size(); i++ {
+		var entry string
+		if entry, err = reader.Data(i); err != nil {
+			break
+		}
+		frame := zstdEncoder.EncodeAll([]byte(entry), nil)
+		// entries keep their null byte separator, Data strips it like for uncompressed databases
+		frame = append(frame, 0)
+		if _, err = dw.Write(frame); err != nil {
+			break
+		}
+		key, _ := reader.Key(i)
+		if _, err = iw.WriteString(strconv.FormatUint(uint64(key), 10) + "\t" + strconv.FormatUint(offset, 10) + "\t" + strconv.Itoa(len(frame)) + "\n"); err != nil {
+			break
+		}
+		offset += uint64(len(frame))
+	}
+	if err == nil {
+		err = dw.Flush()
+	}
+	if err == nil {
+		err = iw.Flush()
+	}
+	if cerr := data.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := index.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path+zstdSuffix+".part", path+zstdSuffix); err != nil {
+		return err
+	}
+	if err := os.Rename(path+zstdSuffix+".index.part", path+zstdSuffix+".index"); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return os.Remove(path + ".index")
+}
+
+func compressFile(path string, gzipCopy bool) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + zstdSuffix + ".part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	zw, err := zstd.NewWriter(out)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	var w io.Writer = zw
+	var gzipOut *os.File
+	var gw *gzip.Writer
+	if gzipCopy {
+		if gzipOut, err = os.Create(path + gzipSuffix + ".part"); err != nil {
+			zw.Close()
+			out.Close()
+			return err
+		}
+		defer os.Remove(gzipOut.Name())
+		gw = gzip.NewWriter(gzipOut)
+		w = io.MultiWriter(zw, gw)
+	}
+	_, err = io.Copy(w, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if gzipCopy {
+		if cerr := gw.Close(); err == nil {
+			err = cerr
+		}
+		if cerr := gzipOut.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if gzipCopy {
+		if err := os.Rename(path+gzipSuffix+".part", path+gzipSuffix); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(path+zstdSuffix+".part", path+zstdSuffix); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// acceptsEncoding checks the Accept-Encoding header of the request for the given coding, a q-value of 0 rejects it
+func acceptsEncoding(req *http.Request, coding string) bool {
+	accepted := false
+	for _, value := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, coding) && name != "*" {
+			continue
+		}
+		q := 1.0
+		if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		// the coding itself takes precedence over the wildcard
+		if strings.EqualFold(name, coding) {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
+
+// serveResultFile sends a result file that might have been compressed or encrypted. The compressed files are passed through
+// as they are with the Content-Encoding the client accepts, zstd before gzip, and are only decompressed for clients accepting neither.
+func serveResultFile(w http.ResponseWriter, req *http.Request, path string) error {
+	if resultFileExists(path) || !resultFileExists(path+zstdSuffix) {
+		file, err := openResultFile(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(w, file)
+		return err
+	}
+
+	w.Header().Set("Vary", "Accept-Encoding")
+	for _, encoding := range []struct {
+		name   string
+		suffix string
+	}{{"zstd", zstdSuffix}, {"gzip", gzipSuffix}} {
+		if !acceptsEncoding(req, encoding.name) || !resultFileExists(path+encoding.suffix) {
+			continue
+		}
+		// encrypted files are decrypted on the fly and have a different size
+		if info, err := os.Stat(path + encoding.suffix); err == nil {
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		}
+		file, err := openResultFile(path + encoding.suffix)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w.Header().Set("Content-Encoding", encoding.name)
+		_, err = io.Copy(w, file)
+		return err
+	}
+
+	file, err := openResultFile(path + zstdSuffix)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	zr, err := zstd.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	_, err = io.Copy(w, zr)
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressResults(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"alis_db":        "q1\tt1\t0.9\x00q2\tt2\t0.8\x00",
+		"alis_db.index":  "0\t0\t10\n1\t10\t10\n",
+		"foldmason.json": `{"msa":"AAA"}`,
+		"job.json":       "{}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CompressResults(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alis_db", "alis_db.index", "foldmason.json"} {
+		if fileExists(filepath.Join(dir, name)) {
+			t.Errorf("%s was not replaced", name)
+		}
+	}
+	if !fileExists(filepath.Join(dir, "job.json")) {
+		t.Error("job.json should not be compressed")
+	}
+
+	reader := Reader[uint32]{}
+	if err := reader.Make(dbpaths(filepath.Join(dir, "alis_db"))); err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Delete()
+	if id, ok := reader.Id(1); !ok {
+		t.Error("entry 1 is missing")
+	} else if data, err := reader.Data(id); err != nil || data != "q2\tt2\t0.8" {
+		t.Errorf("unexpected entry %q %v", data, err)
+	}
+
+	path := filepath.Join(dir, "foldmason.json")
+	req := httptest.NewRequest("GET", "/result/foldmason/x", nil)
+	rec := httptest.NewRecorder()
+	if err := serveResultFile(rec, req, path); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Body.String() != files["foldmason.json"] || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("unexpected response %q", rec.Body.String())
+	}
+
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+	rec = httptest.NewRecorder()
+	if err := serveResultFile(rec, req, path); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatal("compressed file should be passed through")
+	}
+	decoded, err := zstdDecoder.DecodeAll(rec.Body.Bytes(), nil)
+	if err != nil || string(decoded) != files["foldmason.json"] {
+		t.Errorf("unexpected body %q %v", decoded, err)
+	}
+
+	req.Header.Set("Accept-Encoding", "gzip, zstd;q=0")
+	rec = httptest.NewRecorder()
+	if err := serveResultFile(rec, req, path); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("the gzip copy should be passed through")
+	}
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := io.ReadAll(gr); err != nil || string(decoded) != files["foldmason.json"] {
+		t.Errorf("unexpected body %q %v", decoded, err)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// encrypted result files are stored next to the original name with this suffix
+const sealedSuffix = ".enc"
+
+// plaintext bytes per sealed chunk of a flat file
+const sealedChunkSize = 64 * 1024
+
+// resultCipher is nil unless encryption is configured, set once at startup
+var resultCipher cipher.AEAD
+
+// resultRoot is paths.results, sealed data is bound to its path below it, which starts with the ticket
+var resultRoot string
+
+// resultCache holds the copies of stored results, they are bound like the originals
+var resultCache string
+
+// SetupResultEncryption reads the server-managed key, the key is base64 encoded and 32 bytes long for AES-256
+func SetupResultEncryption(config *ConfigEncryption, results string) error {
+	if config == nil {
+		return nil
+	}
+	resultRoot = filepath.Clean(results)
+	encoded, err := readSecret(config.Key, config.KeyFile, "")
+	if err != nil {
+		return err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return errors.New("invalid encryption key: " + err.Error())
+	}
+	if len(key) != 32 {
+		return errors.New("the encryption key has to be 32 bytes long")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	resultCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+// job files the server reads before it serves a result, they hold no sequences
+var plaintextResultFiles = []string{"job.json", "job.log", jobSummaryFile, jobHistogramsFile, databaseVersionsFile, resultStoredMarker}
+
+func sealed(data []byte, additional []byte) []byte {
+	nonce := make([]byte, resultCipher.NonceSize(), resultCipher.NonceSize()+len(data)+resultCipher.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return resultCipher.Seal(nonce, nonce, data, additional)
+}
+
+// sealedContext binds the data of a result file to the ticket and the name of the file,
+// so that sealed files or entries can not be swapped between jobs or files
+func sealedContext(path string) []byte {
+	path = strings.TrimSuffix(filepath.Clean(path), sealedSuffix)
+	for _, root := range []string{resultRoot, resultCache} {
+		if root == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return append([]byte(filepath.ToSlash(rel)), 0)
+		}
+	}
+	rel := filepath.Join(filepath.Base(filepath.Dir(path)), filepath.Base(path))
+	return append([]byte(filepath.ToSlash(rel)), 0)
+}
+
+func entryContext(context []byte, key string) []byte {
+	return append(context[:len(context):len(context)], key...)
+}
+
+func unsealed(data []byte, additional []byte) ([]byte, error) {
+	if resultCipher == nil {
+		return nil, errors.New("result is encrypted but no encryption key is configured")
+	}
+	if len(data) < resultCipher.NonceSize() {
+		return nil, errors.New("invalid encrypted data")
+	}
+	size := resultCipher.NonceSize()
+	return resultCipher.Open(nil, data[:size], data[size:], additional)
+}
+
+// EncryptResults encrypts the query and result files of a finished or failed job, after CompressResults.
+// Databases are sealed entry by entry like they are compressed, so single queries can still be read.
+// The kept intermediate files are only downloaded as a whole, every one of them is sealed as a flat file.
+func EncryptResults(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() && name == intermediatesDir {
+			if err := encryptFiles(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.Type().IsRegular() || isIn(name, plaintextResultFiles) != -1 {
+			continue
+		}
+		if strings.HasSuffix(name, ".index") || strings.HasSuffix(name, ".dbtype") || strings.HasSuffix(name, sealedSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if fileExists(path + ".index") {
+			err = encryptDatabase(path)
+		} else {
+			err = encryptFile(path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptFiles seals all files below dir with encryptFile
+func encryptFiles(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || strings.HasSuffix(path, sealedSuffix) {
+			return nil
+		}
+		return encryptFile(path)
+	})
+}
+
+// encryptDatabase writes <path>.enc and its index, the raw entries including a zstd frame are sealed as they are
+func encryptDatabase(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	indexFile, err := os.Open(path + ".index")
+	if err != nil {
+		return err
+	}
+	index := make([]Entry[uint32], 0)
+	entry := Entry[uint32]{}
+	parser := NewTsvParser(bufio.NewReader(indexFile), &entry)
+	for {
+		eof, err := parser.Next()
+		if eof {
+			break
+		}
+		if err != nil {
+			indexFile.Close()
+			return err
+		}
+		index = append(index, entry)
+	}
+	indexFile.Close()
+
+	data, err := os.Create(path + sealedSuffix + ".part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(data.Name())
+	out, err := os.Create(path + sealedSuffix + ".index.part")
+	if err != nil {
+		data.Close()
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	dw := bufio.NewWriter(data)
+	iw := bufio.NewWriter(out)
+	context := sealedContext(path)
+	var offset uint64
+	for _, entry := range index {
+		buffer := make([]byte, entry.Length)
+		if _, err = file.ReadAt(buffer, int64(entry.Offset)); err != nil {
+			break
+		}
+		// the null byte separator stays unencrypted, the key is bound to the entry
+		frame := append(sealed(buffer[:max(len(buffer)-1, 0)], entryContext(context, strconv.FormatUint(uint64(entry.Key), 10))), 0)
+		if _, err = dw.Write(frame); err != nil {
+			break
+		}
+		if _, err = iw.WriteString(strconv.FormatUint(uint64(entry.Key), 10) + "\t" + strconv.FormatUint(offset, 10) + "\t" + strconv.Itoa(len(frame)) + "\n"); err != nil {
+			break
+		}
+		offset += uint64(len(frame))
+	}
+	if err == nil {
+		err = dw.Flush()
+	}
+	if err == nil {
+		err = iw.Flush()
+	}
+	if cerr := data.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path+sealedSuffix+".part", path+sealedSuffix); err != nil {
+		return err
+	}
+	if err := os.Rename(path+sealedSuffix+".index.part", path+sealedSuffix+".index"); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return os.Remove(path + ".index")
+}
+
+// sealedChunkData binds a chunk to its file and position and marks the last one, so that truncated files are detected
+func sealedChunkData(context []byte, index uint64, last bool) []byte {
+	additional := make([]byte, len(context)+9)
+	copy(additional, context)
+	binary.BigEndian.PutUint64(additional[len(context):], index)
+	if last {
+		additional[len(additional)-1] = 1
+	}
+	return additional
+}
+
+// encryptFile writes the file as length prefixed sealed chunks into <path>.enc
+func encryptFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + sealedSuffix + ".part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	err = sealStream(out, bufio.NewReaderSize(in, sealedChunkSize), sealedContext(path))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path+sealedSuffix+".part", path+sealedSuffix); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func sealStream(w io.Writer, r *bufio.Reader, context []byte) error {
+	out := bufio.NewWriter(w)
+	chunk := make([]byte, sealedChunkSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(r, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, peek := r.Peek(1)
+		last := peek != nil
+		frame := sealed(chunk[:n], sealedChunkData(context, index, last))
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(frame)))
+		if _, err := out.Write(length[:]); err != nil {
+			return err
+		}
+		if _, err := out.Write(frame); err != nil {
+			return err
+		}
+		if last {
+			return out.Flush()
+		}
+	}
+}
+
+// sealedReader decrypts a file written by encryptFile
+type sealedReader struct {
+	r       *bufio.Reader
+	closer  io.Closer
+	context []byte
+	index   uint64
+	pending []byte
+	done    bool
+}
+
+func (s *sealedReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		var length [4]byte
+		if _, err := io.ReadFull(s.r, length[:]); err != nil {
+			return 0, errors.New("truncated encrypted file")
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(length[:]))
+		if _, err := io.ReadFull(s.r, frame); err != nil {
+			return 0, errors.New("truncated encrypted file")
+		}
+		_, peek := s.r.Peek(1)
+		last := peek != nil
+		data, err := unsealed(frame, sealedChunkData(s.context, s.index, last))
+		if err != nil {
+			return 0, err
+		}
+		s.index++
+		s.pending = data
+		s.done = last
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *sealedReader) Close() error {
+	return s.closer.Close()
+}
+
+func newSealedReader(r io.ReadCloser, context []byte) io.ReadCloser {
+	return &sealedReader{bufio.NewReaderSize(r, sealedChunkSize+1024), r, context, 0, nil, false}
+}
+
+// resultFileExists is true if a result file exists as it is or encrypted
+func resultFileExists(path string) bool {
+	return fileExists(path) || fileExists(path+sealedSuffix)
+}
+
+// openResultFile opens a result file that might have been encrypted with EncryptResults
+func openResultFile(path string) (io.ReadCloser, error) {
+	if !fileExists(path) && fileExists(path+sealedSuffix) {
+		file, err := os.Open(path + sealedSuffix)
+		if err != nil {
+			return nil, err
+		}
+		return newSealedReader(file, sealedContext(path)), nil
+	}
+	return os.Open(path)
+}
+
+func readResultFile(path string) ([]byte, error) {
+	file, err := openResultFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
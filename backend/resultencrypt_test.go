@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptResults(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	root := t.TempDir()
+	if err := SetupResultEncryption(&ConfigEncryption{Key: key}, root); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { resultCipher = nil }()
+
+	dir := filepath.Join(root, "ticket")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	archive := strings.Repeat("archive", 20000)
+	files := map[string]string{
+		"alis_db":       "q1\tt1\t0.9\x00q2\tt2\t0.8\x00",
+		"alis_db.index": "0\t0\t10\n1\t10\t10\n",
+		"job.fasta":     ">q1\nACGT\n",
+		"job.json":      "{}",
+		"results.tar":   archive,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CompressResults(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptResults(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alis_db.zst", "job.fasta", "results.tar"} {
+		if fileExists(filepath.Join(dir, name)) || !fileExists(filepath.Join(dir, name+sealedSuffix)) {
+			t.Errorf("%s was not encrypted", name)
+		}
+	}
+	if !fileExists(filepath.Join(dir, "job.json")) {
+		t.Error("job.json should not be encrypted")
+	}
+
+	reader := Reader[uint32]{}
+	if err := reader.Make(dbpaths(filepath.Join(dir, "alis_db"))); err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Delete()
+	if id, ok := reader.Id(1); !ok {
+		t.Error("entry 1 is missing")
+	} else if data, err := reader.Data(id); err != nil || data != "q2\tt2\t0.8" {
+		t.Errorf("unexpected entry %q %v", data, err)
+	}
+
+	// query databases are sealed without being compressed
+	query := filepath.Join(root, "other", "query")
+	if err := os.MkdirAll(filepath.Dir(query), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(query, []byte("ACGTACGTACGTACGTACGTACGTACGT\n\x00MKV\n\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(query+".index", []byte("0\t0\t30\n1\t30\t5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptResults(filepath.Dir(query)); err != nil {
+		t.Fatal(err)
+	}
+	queries := Reader[uint32]{}
+	if err := queries.Make(dbpaths(query)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := queries.Data(0); err != nil || data != "ACGTACGTACGTACGTACGTACGTACGT\n" {
+		t.Errorf("unexpected query %q %v", data, err)
+	}
+	if data, err := queries.Data(1); err != nil || data != "MKV\n" {
+		t.Errorf("unexpected query %q %v", data, err)
+	}
+	queries.Delete()
+
+	// sealed entries are bound to the job and the file
+	moved := filepath.Join(dir, "query")
+	for _, suffix := range []string{sealedSuffix, sealedSuffix + ".index"} {
+		if err := os.Rename(query+suffix, moved+suffix); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queries = Reader[uint32]{}
+	if err := queries.Make(dbpaths(moved)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queries.Data(0); err == nil {
+		t.Error("expected an error for an entry of another job")
+	}
+	queries.Delete()
+
+	if data, err := readResultFile(filepath.Join(dir, "job.fasta")); err != nil || string(data) != files["job.fasta"] {
+		t.Errorf("unexpected query %q %v", data, err)
+	}
+	if data, err := readResultFile(filepath.Join(dir, "results.tar")); err != nil || string(data) != archive {
+		t.Errorf("unexpected archive of %d bytes %v", len(data), err)
+	}
+
+	// dropping the last chunk is detected
+	path := filepath.Join(dir, "results.tar"+sealedSuffix)
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, sealed[:4+sealedChunkSize+resultCipher.NonceSize()+resultCipher.Overhead()], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readResultFile(filepath.Join(dir, "results.tar")); err == nil {
+		t.Error("expected an error for a truncated file")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// resultDatabases returns the searched databases of a job and whether its alignments have the prob column of foldseek
+func resultDatabases(request JobRequest) ([]string, bool, error) {
+	switch job := request.Job.(type) {
+	case SearchJob:
+		return job.Database, false, nil
+	case StructureSearchJob:
+		return job.Database, true, nil
+	case ComplexSearchJob:
+		return job.Database, true, nil
+	}
+	return nil, false, errors.New("job type " + string(request.Type) + " has no alignment results")
+}
+
+// alignmentColumns are the positions of the columns after tend in the alignment results,
+// the ones before (query target pident alnlen mismatch gapopen qstart qend tstart tend) are the same for all jobs
+type alignmentColumns struct {
+	Evalue       int
+	Bits         int
+	QueryLength  int
+	TargetLength int
+	QueryAln     int
+	TargetAln    int
+}
+
+func resultColumns(foldseek bool) alignmentColumns {
+	if foldseek {
+		// prob comes before the evalue
+		return alignmentColumns{11, 12, 13, 14, 15, 16}
+	}
+	return alignmentColumns{10, 11, 12, 13, 14, 15}
+}
+
+// blastColumns are the columns of the alignment results that make up BLAST tabular output:
+// qseqid sseqid pident length mismatch gapopen qstart qend sstart send evalue bitscore
+func blastColumns(foldseek bool) []int {
+	columns := resultColumns(foldseek)
+	return []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, columns.Evalue, columns.Bits}
+}
+
+// resultEntries are the positions of the query keys in a result database, nil queries select all entries
+func resultEntries(reader *Reader[uint32], queries []uint32) []int64 {
+	entries := make([]int64, 0, reader.Size())
+	if queries == nil {
+		for i := int64(0); i < reader.Size(); i++ {
+			entries = append(entries, i)
+		}
+		return entries
+	}
+	for _, key := range queries {
+		if i, ok := reader.Id(key); ok {
+			entries = append(entries, i)
+		}
+	}
+	return entries
+}
+
+// forEachAlignment calls f with the columns of each alignment of the given queries in a result database
+func forEachAlignment(reader *Reader[uint32], columns alignmentColumns, queries []uint32, f func(fields []string) error) error {
+	for _, i := range resultEntries(reader, queries) {
+		err := reader.ScanLines(i, func(line string) error {
+			fields := strings.Split(line, "\t")
+			if len(fields) <= columns.TargetAln {
+				return errors.New("unexpected alignment line: " + line)
+			}
+			return f(fields)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BlastTabular writes the alignments of the databases in BLAST tabular format (-outfmt 6), one database after the other
+func BlastTabular(w io.Writer, base string, databases []string, foldseek bool, queries []uint32) error {
+	columns := resultColumns(foldseek)
+	blast := blastColumns(foldseek)
+	out := bufio.NewWriter(w)
+	reader := Reader[uint32]{}
+	for _, database := range databases {
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return err
+		}
+		err := forEachAlignment(&reader, columns, queries, func(fields []string) error {
+			for j, column := range blast {
+				if j > 0 {
+					out.WriteByte('\t')
+				}
+				out.WriteString(fields[column])
+			}
+			return out.WriteByte('\n')
+		})
+		reader.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+func init() {
+	RegisterResultFormatter("blast", resultFormat{"m8", "text/tab-separated-values", func(r ResultRequest) (ResultWriter, error) {
+		return func(w io.Writer) error {
+			return BlastTabular(w, r.Base, r.Databases, r.Foldseek, r.Queries)
+		}, nil
+	}})
+}
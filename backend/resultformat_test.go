@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlastTabular(t *testing.T) {
+	dir := t.TempDir()
+	mmseqs := "q1\tt1\t98.5\t100\t1\t0\t1\t100\t5\t104\t1.2E-50\t180\t100\t120\tAAA\tAAA\n\x00"
+	writeTestDatabase(t, dir, "alis_db", mmseqs)
+	foldseek := "q1\tt2\t50.0\t80\t40\t2\t1\t80\t3\t82\t1.000\t3.1E-10\t90\t80\t90\tAAA\tAAA\n\x00"
+	writeTestDatabase(t, dir, "alis_pdb", foldseek)
+
+	var out strings.Builder
+	if err := BlastTabular(&out, dir, []string{"db"}, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "q1\tt1\t98.5\t100\t1\t0\t1\t100\t5\t104\t1.2E-50\t180\n"; out.String() != expected {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	out.Reset()
+	if err := BlastTabular(&out, dir, []string{"pdb"}, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the prob column is left out
+	if expected := "q1\tt2\t50.0\t80\t40\t2\t1\t80\t3\t82\t3.1E-10\t90\n"; out.String() != expected {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestBlastXML(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "alis_db", "q1\tt1 some protein\t50.0\t4\t2\t0\t1\t4\t5\t8\t1.2E-5\t30\t10\t20\tAC-D\tACGD\n\x00")
+
+	var out strings.Builder
+	if err := BlastXML(&out, dir, []string{"db"}, false, "blastp", nil); err != nil {
+		t.Fatal(err)
+	}
+	res := out.String()
+	for _, expected := range []string{
+		"<BlastOutput_program>blastp</BlastOutput_program>",
+		"<Iteration_query-def>q1</Iteration_query-def>",
+		"<Iteration_query-len>10</Iteration_query-len>",
+		"<Hit_id>t1</Hit_id>",
+		"<Hit_def>some protein</Hit_def>",
+		"<Hsp_identity>2</Hsp_identity>",
+		"<Hsp_gaps>1</Hsp_gaps>",
+		"<Hsp_midline>AC D</Hsp_midline>",
+		"</BlastOutput>",
+	} {
+		if !strings.Contains(res, expected) {
+			t.Errorf("missing %s in %s", expected, res)
+		}
+	}
+}
+
+func TestSAM(t *testing.T) {
+	dir := t.TempDir()
+	alignments := "q1\tchr1 description\t75.0\t4\t1\t0\t3\t6\t11\t14\t1E-3\t20\t10\t100\tAC-GT\tACCGA\n" +
+		"q1\tchr2\t100.0\t4\t0\t0\t1\t4\t20\t17\t1E-3\t20\t10\t100\tACGT\tACGT\n\x00"
+	writeTestDatabase(t, dir, "alis_nt", alignments)
+
+	var out strings.Builder
+	if err := SAM(&out, dir, []string{"nt"}, nil); err == nil {
+		t.Error("results without SAM records should be rejected")
+	}
+
+	// the records as convertalis writes them, with its own header
+	records := "@SQ\tSN:chr1\tLN:100\n" +
+		"q1\t0\tchr1\t11\t255\t2H2M1D2M4H\t*\t0\t0\tACGT\t*\tAS:i:20\tNM:i:2\n" +
+		"q1\t16\tchr2\t17\t255\t6H4M\t*\t0\t0\tACGT\t*\tAS:i:20\tNM:i:0\n\x00"
+	writeTestDatabase(t, dir, "alis_nt_sam", records)
+	out.Reset()
+	if err := SAM(&out, dir, []string{"nt"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "@HD\tVN:1.6\tSO:unsorted\n" +
+		"@SQ\tSN:chr1\tLN:100\n" +
+		"@SQ\tSN:chr2\tLN:100\n" +
+		"@PG\tID:mmseqs\tPN:mmseqs\n" +
+		"q1\t0\tchr1\t11\t255\t2H2M1D2M4H\t*\t0\t0\tACGT\t*\tAS:i:20\tNM:i:2\n" +
+		"q1\t16\tchr2\t17\t255\t6H4M\t*\t0\t0\tACGT\t*\tAS:i:20\tNM:i:0\n"
+	if out.String() != expected {
+		t.Errorf("unexpected output\n%s", out.String())
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// ResultRequest is a download of the results of a completed job
+type ResultRequest struct {
+	Config ConfigRoot
+	// result directory of the job
+	Base      string
+	Request   JobRequest
+	Databases []string
+	Foldseek  bool
+	// keys of the selected query, nil for all queries
+	Queries []uint32
+	// the query parameters of the download, for the options of a format
+	Params url.Values
+}
+
+// ResultWriter writes a prepared download
+type ResultWriter func(w io.Writer) error
+
+// ResultFormatter is a download format of /result/download/{ticket}?format=<name>
+type ResultFormatter interface {
+	Extension() string
+	ContentType() string
+	// Prepare checks that the job can be written in the format before the response is started
+	Prepare(r ResultRequest) (ResultWriter, error)
+}
+
+var resultFormatters = struct {
+	sync.RWMutex
+	formatters map[string]ResultFormatter
+}{formatters: make(map[string]ResultFormatter)}
+
+// RegisterResultFormatter makes a format available for downloads, formats register themselves in an init function.
+// Registering a format twice panics.
+func RegisterResultFormatter(name string, formatter ResultFormatter) {
+	resultFormatters.Lock()
+	defer resultFormatters.Unlock()
+	if _, ok := resultFormatters.formatters[name]; ok {
+		panic("result format " + name + " registered twice")
+	}
+	resultFormatters.formatters[name] = formatter
+}
+
+func resultFormatter(name string) (ResultFormatter, bool) {
+	resultFormatters.RLock()
+	defer resultFormatters.RUnlock()
+	formatter, ok := resultFormatters.formatters[name]
+	return formatter, ok
+}
+
+func resultFormatterNames() []string {
+	resultFormatters.RLock()
+	defer resultFormatters.RUnlock()
+	names := make([]string, 0, len(resultFormatters.formatters))
+	for name := range resultFormatters.formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resultFormat is a ResultFormatter of the functions of a format
+type resultFormat struct {
+	extension   string
+	contentType string
+	prepare     func(r ResultRequest) (ResultWriter, error)
+}
+
+func (f resultFormat) Extension() string {
+	return f.extension
+}
+
+func (f resultFormat) ContentType() string {
+	return f.contentType
+}
+
+func (f resultFormat) Prepare(r ResultRequest) (ResultWriter, error) {
+	return f.prepare(r)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResultFormatters(t *testing.T) {
+	if names := strings.Join(resultFormatterNames(), ","); names != "a3m,blast,blastxml,csv,fasta,gff3,html,sam,sto,tsv" {
+		t.Errorf("unexpected formats %s", names)
+	}
+
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "alis_db", "q1\tt1\t98.5\t100\t1\t0\t1\t100\t5\t104\t1.2E-50\t180\t100\t120\tAAA\tAAA\n\x00")
+	r := ResultRequest{Base: dir, Request: JobRequest{Type: JobSearch, Job: SearchJob{}}, Databases: []string{"db"}}
+	if _, err := resultFormatters.formatters["a3m"].Prepare(r); err == nil {
+		t.Error("expected an error for a job without MSAs")
+	}
+	write, err := resultFormatters.formatters["blast"].Prepare(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "q1\tt1\t") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
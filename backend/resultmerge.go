@@ -0,0 +1,70 @@
+package main
+
+import "sort"
+
+// MergedHit is a hit of a multi-database result annotated with its source database and its links
+type MergedHit struct {
+	Database  string      `json:"db"`
+	Links     []HitLink   `json:"links,omitempty"`
+	Alignment interface{} `json:"alignment"`
+}
+
+// MergedQuery are the hits of one query in all searched databases
+type MergedQuery struct {
+	Query string      `json:"query"`
+	Hits  []MergedHit `json:"hits"`
+}
+
+type rankedHit struct {
+	hit    MergedHit
+	values hitValues
+}
+
+type hitMerger struct {
+	queries []string
+	hits    map[string][]rankedHit
+}
+
+func mergeHits[T interface{ hit() hitValues }](m *hitMerger, res SearchResult, groups [][]T) {
+	for _, group := range groups {
+		for _, hit := range group {
+			values := hit.hit()
+			if _, ok := m.hits[values.query]; !ok {
+				m.queries = append(m.queries, values.query)
+			}
+			m.hits[values.query] = append(m.hits[values.query], rankedHit{MergedHit{res.Database, res.Links[values.target], hit}, values})
+		}
+	}
+}
+
+// MergeResults merges the hits of all databases per query, ordered by E-value and then bit score with ties in database order.
+// The links of addHitLinks are attached to the hits, so it has to be called before.
+func MergeResults(results []SearchResult) []MergedQuery {
+	m := hitMerger{make([]string, 0), make(map[string][]rankedHit)}
+	for _, res := range results {
+		switch alignments := res.Alignments.(type) {
+		case [][]AlignmentEntry:
+			mergeHits(&m, res, alignments)
+		case [][]FoldseekAlignmentEntry:
+			mergeHits(&m, res, alignments)
+		case [][]ComplexAlignmentEntry:
+			mergeHits(&m, res, alignments)
+		}
+	}
+	merged := make([]MergedQuery, 0, len(m.queries))
+	for _, query := range m.queries {
+		ranked := m.hits[query]
+		sort.SliceStable(ranked, func(i, j int) bool {
+			if ranked[i].values.evalue != ranked[j].values.evalue {
+				return ranked[i].values.evalue < ranked[j].values.evalue
+			}
+			return ranked[i].values.bits > ranked[j].values.bits
+		})
+		hits := make([]MergedHit, len(ranked))
+		for i, hit := range ranked {
+			hits[i] = hit.hit
+		}
+		merged = append(merged, MergedQuery{query, hits})
+	}
+	return merged
+}
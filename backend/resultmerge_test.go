@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestMergeResults(t *testing.T) {
+	results := []SearchResult{
+		{"uniref", [][]AlignmentEntry{{{Query: "q1", Target: "A", Eval: 1e-5, Score: 50}, {Query: "q1", Target: "B", Eval: 1e-3, Score: 30}}}, map[string][]HitLink{"A": {{"UniProt", "https://www.uniprot.org/uniprotkb/A"}}}, nil},
+		{"pdb", [][]AlignmentEntry{{{Query: "q1", Target: "C", Eval: 1e-5, Score: 60}}, {{Query: "q2", Target: "D", Eval: 1, Score: 10}}}, nil, nil},
+	}
+	merged := MergeResults(results)
+	if len(merged) != 2 || merged[0].Query != "q1" || merged[1].Query != "q2" {
+		t.Fatalf("unexpected queries %+v", merged)
+	}
+	order := []string{"pdb", "uniref", "uniref"}
+	for i, hit := range merged[0].Hits {
+		if hit.Database != order[i] {
+			t.Errorf("hit %d: expected database %s, got %s", i, order[i], hit.Database)
+		}
+	}
+	if len(merged[0].Hits[1].Links) != 1 || merged[0].Hits[2].Links != nil {
+		t.Errorf("links were not attached to their hits")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultSearchHits = 50
+const maxSearchHits = 1000
+
+// SearchedHit is an alignment whose target header matched a keyword search
+type SearchedHit struct {
+	Database string  `json:"db"`
+	Query    string  `json:"query"`
+	Target   string  `json:"target"`
+	Evalue   float64 `json:"eval"`
+	Bits     int     `json:"score"`
+	Identity float64 `json:"seqId"`
+}
+
+type HitSearchResult struct {
+	// number of matching hits over all pages
+	Total int           `json:"total"`
+	Hits  []SearchedHit `json:"hits"`
+}
+
+// searchTerms splits a keyword search into lower case words, all of them have to be in a header
+func searchTerms(keywords string) []string {
+	return strings.Fields(strings.ToLower(keywords))
+}
+
+func matchesTerms(header string, terms []string) bool {
+	header = strings.ToLower(header)
+	for _, term := range terms {
+		if !strings.Contains(header, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchHits finds the hits of the given queries (nil for all) whose target header contains all words of keywords, ignoring case.
+// The hits are in the order of the results, of which limit are returned starting at offset.
+func SearchHits(base string, databases []string, foldseek bool, queries []uint32, keywords string, offset int, limit int) (HitSearchResult, error) {
+	result := HitSearchResult{0, make([]SearchedHit, 0)}
+	terms := searchTerms(keywords)
+	if len(terms) == 0 {
+		return result, nil
+	}
+	columns := resultColumns(foldseek)
+	reader := Reader[uint32]{}
+	for _, database := range databases {
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return result, err
+		}
+		err := forEachAlignment(&reader, columns, queries, func(fields []string) error {
+			if !matchesTerms(fields[1], terms) {
+				return nil
+			}
+			result.Total++
+			if result.Total <= offset || len(result.Hits) >= limit {
+				return nil
+			}
+			evalue, err := strconv.ParseFloat(fields[columns.Evalue], 64)
+			if err != nil {
+				return err
+			}
+			bits, err := strconv.Atoi(fields[columns.Bits])
+			if err != nil {
+				return err
+			}
+			identity, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return err
+			}
+			result.Hits = append(result.Hits, SearchedHit{database, fields[0], fields[1], evalue, bits, identity})
+			return nil
+		})
+		reader.Delete()
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
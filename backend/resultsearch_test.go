@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestSearchHits(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "alis_db", "q1\tP1 Serine/threonine-protein Kinase\t98.5\t100\t1\t0\t1\t100\t5\t104\t1.2E-50\t180\t100\t120\tAAA\tAAA\n"+
+		"q1\tP2 tyrosine kinase\t50.0\t100\t1\t0\t1\t100\t5\t104\t1E-10\t80\t100\t120\tAAA\tAAA\n"+
+		"q1\tP3 phosphatase\t40.0\t100\t1\t0\t1\t100\t5\t104\t1E-5\t40\t100\t120\tAAA\tAAA\n\x00")
+
+	result, err := SearchHits(dir, []string{"db"}, false, nil, "KINASE", 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || len(result.Hits) != 1 || result.Hits[0].Target != "P2 tyrosine kinase" || result.Hits[0].Bits != 80 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	result, err = SearchHits(dir, []string{"db"}, false, nil, "protein kinase", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 || result.Hits[0].Target != "P1 Serine/threonine-protein Kinase" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
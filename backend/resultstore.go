@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a result directory whose result files only exist in the result store
+const resultStoredMarker = ".stored"
+
+// a complete copy of stored results in the cache, its modification time is when it was last read
+const resultFetchedMarker = ".fetched"
+
+// copies in the cache that were not read for this long are removed
+const resultCacheAge = 1 * time.Hour
+
+// ResultStore copies the result directories of finished jobs into an object store, objects are named <id>/<file>.
+// The job files (job.json, job.log, the query, the summary) always stay in paths.results.
+type ResultStore struct {
+	store     *S3Store
+	results   string
+	cache     string
+	keepLocal bool
+
+	mu sync.Mutex
+	// fetches of the same result wait for each other, those of different results do not
+	fetching map[Id]*resultFetch
+	swept    time.Time
+}
+
+type resultFetch struct {
+	mu      sync.Mutex
+	waiting int
+}
+
+func NewResultStore(config ConfigRoot) (*ResultStore, error) {
+	if config.ResultStore == nil {
+		return nil, nil
+	}
+	store, err := NewS3Store(config.ResultStore.ConfigObjectStore)
+	if err != nil {
+		return nil, err
+	}
+	cache := config.ResultStore.Cache
+	if cache == "" {
+		cache = filepath.Join(os.TempDir(), "mmseqs-results")
+	}
+	resultCache = filepath.Clean(cache)
+	return &ResultStore{
+		store:     store,
+		results:   filepath.Clean(config.Paths.Results),
+		cache:     resultCache,
+		keepLocal: config.ResultStore.KeepLocal,
+		fetching:  make(map[Id]*resultFetch),
+	}, nil
+}
+
+func isJobFile(name string) bool {
+	return strings.HasPrefix(name, "job.")
+}
+
+// resultFiles lists the files of a result directory, the scratch directory and the marker are left out
+func resultFiles(dir string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && (entry.Name() == "tmp" || entry.Name() == intermediatesDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() && entry.Name() != resultStoredMarker {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Upload copies the result files of a job into the store and, unless they are kept locally, removes them afterwards
+func (s *ResultStore) Upload(ctx context.Context, id Id) error {
+	dir := filepath.Join(s.results, string(id))
+	files, err := resultFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := s.put(ctx, id, dir, name); err != nil {
+			return err
+		}
+	}
+	if s.keepLocal {
+		return nil
+	}
+	// the marker is written first, a missing file is then fetched instead of reported missing
+	if err := os.WriteFile(filepath.Join(dir, resultStoredMarker), nil, 0644); err != nil {
+		return err
+	}
+	for _, name := range files {
+		if isJobFile(name) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ResultStore) put(ctx context.Context, id Id, dir string, name string) error {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.store.Put(ctx, string(id)+"/"+name, file, info.Size())
+}
+
+// Stored is true if the result files of a job were moved into the store
+func (s *ResultStore) Stored(id Id) bool {
+	return s != nil && fileExists(filepath.Join(s.results, string(id), resultStoredMarker))
+}
+
+// Fetch returns the results directory to read a job from, that is paths.results unless its result files were moved into
+// the store. Those are downloaded into a copy in the cache, paths.results is left as it is.
+func (s *ResultStore) Fetch(ctx context.Context, id Id) (string, error) {
+	if !s.Stored(id) {
+		return s.results, nil
+	}
+	s.sweepCache(time.Now())
+	unlock := s.lock(id)
+	defer unlock()
+
+	dir := filepath.Join(s.cache, string(id))
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(dir, resultFetchedMarker), now, now); err == nil {
+		return s.cache, nil
+	}
+	if err := os.MkdirAll(s.cache, 0755); err != nil {
+		return "", err
+	}
+	part, err := os.MkdirTemp(s.cache, string(id)+".part")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(part)
+
+	objects, err := s.store.List(ctx, string(id)+"/")
+	if err != nil {
+		return "", err
+	}
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, string(id)+"/")
+		if name == "" || strings.Contains(name, "..") || isJobFile(name) {
+			continue
+		}
+		if err := s.fetch(ctx, object.Key, filepath.Join(part, filepath.FromSlash(name))); err != nil {
+			return "", err
+		}
+	}
+	// the uploaded job files are older than the local ones, the job finished after they were uploaded
+	entries, err := os.ReadDir(filepath.Join(s.results, string(id)))
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isJobFile(entry.Name()) {
+			if err := copyResultFile(filepath.Join(s.results, string(id), entry.Name()), filepath.Join(part, entry.Name())); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(part, resultFetchedMarker), nil, 0644); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.Rename(part, dir); err != nil {
+		return "", err
+	}
+	return s.cache, nil
+}
+
+func (s *ResultStore) lock(id Id) func() {
+	s.mu.Lock()
+	fetch, ok := s.fetching[id]
+	if !ok {
+		fetch = &resultFetch{}
+		s.fetching[id] = fetch
+	}
+	fetch.waiting++
+	s.mu.Unlock()
+
+	fetch.mu.Lock()
+	return func() {
+		fetch.mu.Unlock()
+		s.mu.Lock()
+		fetch.waiting--
+		if fetch.waiting == 0 {
+			delete(s.fetching, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// sweepCache removes the copies that were not read for resultCacheAge and left over partial downloads,
+// it runs at most once per resultCacheAge/4
+func (s *ResultStore) sweepCache(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.swept) < resultCacheAge/4 {
+		s.mu.Unlock()
+		return
+	}
+	s.swept = now
+	s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.cache)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.cache, entry.Name())
+		info, err := os.Stat(filepath.Join(path, resultFetchedMarker))
+		if err != nil {
+			if info, err = entry.Info(); err != nil {
+				continue
+			}
+		}
+		if now.Sub(info.ModTime()) > resultCacheAge {
+			os.RemoveAll(path)
+		}
+	}
+}
+
+func copyResultFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *ResultStore) fetch(ctx context.Context, key string, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	r, err := s.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	file, err := os.Create(path + ".part")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".part")
+		return err
+	}
+	return os.Rename(path+".part", path)
+}
+
+// Open streams a result file of a job from the store
+func (s *ResultStore) Open(ctx context.Context, id Id, name string) (io.ReadCloser, error) {
+	return s.store.Get(ctx, string(id)+"/"+name)
+}
+
+// Delete removes all objects of a job and its copy in the cache, it does nothing on a nil store
+func (s *ResultStore) Delete(ctx context.Context, id Id) error {
+	if s == nil {
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Join(s.cache, string(id))); err != nil {
+		return err
+	}
+	objects, err := s.store.List(ctx, string(id)+"/")
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := s.store.Delete(ctx, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResultStore(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.TrimPrefix(req.URL.Path, "/bucket/")
+		switch {
+		case req.URL.Path == "/bucket":
+			fmt.Fprint(w, "<ListBucketResult>")
+			for key, content := range objects {
+				if strings.HasPrefix(key, req.URL.Query().Get("prefix")) {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", key, len(content))
+				}
+			}
+			fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+		case req.Method == "PUT":
+			data, _ := io.ReadAll(req.Body)
+			objects[key] = string(data)
+		case req.Method == "DELETE":
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			content, ok := objects[key]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			fmt.Fprint(w, content)
+		}
+	}))
+	defer srv.Close()
+
+	var config ConfigRoot
+	config.Paths.Results = t.TempDir()
+	config.ResultStore = &ConfigResultStore{ConfigObjectStore: ConfigObjectStore{Source: "s3://bucket/results", Endpoint: srv.URL}, Cache: t.TempDir()}
+	store, err := NewResultStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := Id(strings.Repeat("a", 38))
+	dir := filepath.Join(config.Paths.Results, string(id))
+	for name, content := range map[string]string{"job.json": "{}", "alis_db": "A\x00", "alis_db.index": "0\t0\t2\n", "tmp/scratch": "x"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	if err := store.Upload(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if objects["results/"+string(id)+"/alis_db"] != "A\x00" || objects["results/"+string(id)+"/tmp/scratch"] != "" {
+		t.Errorf("unexpected objects %v", objects)
+	}
+	if !store.Stored(id) || fileExists(filepath.Join(dir, "alis_db")) || !fileExists(filepath.Join(dir, "job.json")) {
+		t.Fatal("only the job files should be kept locally")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "job.json"), []byte(`{"status":"COMPLETE"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jobsbase, err := store.Fetch(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobsbase != config.ResultStore.Cache {
+		t.Fatalf("stored results are read from the cache, not %s", jobsbase)
+	}
+	if data, err := os.ReadFile(filepath.Join(jobsbase, string(id), "alis_db.index")); err != nil || string(data) != "0\t0\t2\n" {
+		t.Errorf("result was not fetched: %q %v", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(jobsbase, string(id), "job.json")); err != nil || string(data) != `{"status":"COMPLETE"}` {
+		t.Errorf("the local job file should be used: %q %v", data, err)
+	}
+	if !store.Stored(id) || fileExists(filepath.Join(dir, "alis_db")) {
+		t.Error("fetching should not move the results back into paths.results")
+	}
+
+	delete(objects, "results/"+string(id)+"/alis_db")
+	if again, err := store.Fetch(ctx, id); err != nil || again != jobsbase || !fileExists(filepath.Join(jobsbase, string(id), "alis_db")) {
+		t.Errorf("the cached copy should be reused: %s %v", again, err)
+	}
+	store.sweepCache(time.Now().Add(2 * resultCacheAge))
+	if fileExists(filepath.Join(jobsbase, string(id))) {
+		t.Error("unused copies should be removed")
+	}
+	objects["results/"+string(id)+"/alis_db"] = "A\x00"
+
+	if err := store.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("objects left %v", objects)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	defaultStreamLimit = 100
+	maxStreamLimit     = 10000
+)
+
+// StreamedHit is one line of the NDJSON result stream, entry is the position of the query in the results
+type StreamedHit struct {
+	Database  string      `json:"db"`
+	Entry     int64       `json:"entry"`
+	Alignment interface{} `json:"alignment"`
+}
+
+// StreamPage is a range of query entries of a result, Next is the cursor of the following page and empty after the last one
+type StreamPage struct {
+	Start int64
+	End   int64
+	Next  string
+}
+
+// streamPage resolves the cursor and limit parameters against the number of query entries
+func streamPage(cursor string, limit string, entries int64) (StreamPage, error) {
+	var page StreamPage
+	if cursor != "" {
+		start, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || start < 0 {
+			return page, errors.New("invalid cursor " + cursor)
+		}
+		page.Start = start
+	}
+	size := int64(defaultStreamLimit)
+	if limit != "" {
+		value, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || value <= 0 {
+			return page, errors.New("invalid limit " + limit)
+		}
+		if value > maxStreamLimit {
+			value = maxStreamLimit
+		}
+		size = value
+	}
+	if page.Start > entries {
+		page.Start = entries
+	}
+	page.End = page.Start + size
+	if page.End < entries {
+		page.Next = strconv.FormatInt(page.End, 10)
+	} else {
+		page.End = entries
+	}
+	return page, nil
+}
+
+type resultStream struct {
+	databases []string
+	readers   []*Reader[uint32]
+}
+
+// openResultStream opens the alignment results of the databases, all of them have one entry per query
+func openResultStream(base string, databases []string) (*resultStream, error) {
+	s := &resultStream{databases, make([]*Reader[uint32], 0, len(databases))}
+	for _, database := range databases {
+		reader := &Reader[uint32]{}
+		if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.readers = append(s.readers, reader)
+	}
+	return s, nil
+}
+
+func (s *resultStream) Entries() int64 {
+	if len(s.readers) == 0 {
+		return 0
+	}
+	return s.readers[0].Size()
+}
+
+func (s *resultStream) Close() {
+	for _, reader := range s.readers {
+		reader.Delete()
+	}
+}
+
+// streamHits writes the hits of the page as NDJSON, one query after the other with the hits of all databases
+func streamHits[T interface{ hit() hitValues }](s *resultStream, w io.Writer, page StreamPage, filter *HitFilter) error {
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	for entry := page.Start; entry < page.End; entry++ {
+		for i, reader := range s.readers {
+			if entry >= reader.Size() {
+				continue
+			}
+			data, err := reader.Open(entry)
+			if err != nil {
+				return err
+			}
+			hits, err := ReadAlignment[T](data)
+			data.Close()
+			if err != nil {
+				return err
+			}
+			if filter != nil {
+				if filtered := filterHits([][]T{hits}, *filter); len(filtered) > 0 {
+					hits = filtered[0]
+				} else {
+					hits = nil
+				}
+			}
+			for _, hit := range hits {
+				if err := enc.Encode(StreamedHit{s.databases[i], entry, hit}); err != nil {
+					return err
+				}
+			}
+		}
+		// clients see complete queries as soon as possible
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		if flusher, ok := w.(interface{ Flush() }); ok {
+			flusher.Flush()
+		}
+	}
+	return out.Flush()
+}
+
+// StreamResults writes a page of the alignments of a job as NDJSON
+func StreamResults(w io.Writer, s *resultStream, request JobRequest, page StreamPage, filter *HitFilter) error {
+	switch request.Job.(type) {
+	case SearchJob:
+		return streamHits[AlignmentEntry](s, w, page, filter)
+	case StructureSearchJob:
+		return streamHits[FoldseekAlignmentEntry](s, w, page, filter)
+	case ComplexSearchJob:
+		return streamHits[ComplexAlignmentEntry](s, w, page, filter)
+	}
+	return errors.New("job type " + string(request.Type) + " has no alignment results")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStreamResults(t *testing.T) {
+	dir := t.TempDir()
+	first := "q1\tt1\t90.0\t10\t1\t0\t1\t10\t1\t10\t1E-10\t50\t10\t10\tAAA\tAAA\nq1\tt2\t80.0\t10\t2\t0\t1\t10\t1\t10\t1E-2\t20\t10\t10\tAAA\tAAA\n\x00"
+	second := "q2\tt3\t70.0\t10\t3\t0\t1\t10\t1\t10\t1E-5\t30\t10\t10\tAAA\tAAA\n\x00"
+	data := first + second + "\x00"
+	index := "0\t0\t" + strconv.Itoa(len(first)) + "\n1\t" + strconv.Itoa(len(first)) + "\t" + strconv.Itoa(len(second)) + "\n2\t" + strconv.Itoa(len(first)+len(second)) + "\t1\n"
+	if err := os.WriteFile(filepath.Join(dir, "alis_db"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "alis_db.index"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := openResultStream(dir, []string{"db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	page, err := streamPage("", "1", stream.Entries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Start != 0 || page.End != 1 || page.Next != "1" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	request := JobRequest{Type: JobSearch, Job: SearchJob{}}
+	var out strings.Builder
+	filter := HitFilter{MaxEvalue: 1e-3}
+	if err := StreamResults(&out, stream, request, page, &filter); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected the hit with a low e-value only, got %v", lines)
+	}
+	var hit struct {
+		Database  string         `json:"db"`
+		Entry     int64          `json:"entry"`
+		Alignment AlignmentEntry `json:"alignment"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &hit); err != nil {
+		t.Fatal(err)
+	}
+	if hit.Database != "db" || hit.Entry != 0 || hit.Alignment.Target != "t1" {
+		t.Errorf("unexpected hit %+v", hit)
+	}
+
+	page, err = streamPage(page.Next, "10", stream.Entries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Start != 1 || page.End != 3 || page.Next != "" {
+		t.Errorf("unexpected last page %+v", page)
+	}
+	out.Reset()
+	if err := StreamResults(&out, stream, request, page, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "\n") != 1 || !strings.Contains(out.String(), `"entry":1`) {
+		t.Errorf("unexpected last page %s", out.String())
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ResultCleaner removes the result directories of finished jobs, and with them their tickets,
+// once the retention period of their priority class has passed since they finished
+type ResultCleaner struct {
+	config     ConfigRoot
+	store      *ResultStore
+	maxAge     time.Duration
+	priorities map[PriorityClass]time.Duration
+	interval   time.Duration
+
+	mu sync.Mutex
+	// per priority class
+	removed map[PriorityClass]ResultsRemoved
+}
+
+// ResultsRemoved meters what the cleaner removed
+type ResultsRemoved struct {
+	Jobs  int64
+	Bytes uint64
+}
+
+// NewResultCleaner returns nil if no retention period is configured, the results are also removed from the store if there is one
+func NewResultCleaner(config ConfigRoot, store *ResultStore) (*ResultCleaner, error) {
+	retention := config.Server.Retention
+	if retention == nil {
+		return nil, nil
+	}
+	maxAge, err := time.ParseDuration(retention.MaxAge)
+	if err != nil || maxAge <= 0 {
+		return nil, errors.New("invalid server.retention.maxage " + retention.MaxAge)
+	}
+	priorities := make(map[PriorityClass]time.Duration, len(retention.Priorities))
+	for class, value := range retention.Priorities {
+		age, err := time.ParseDuration(value)
+		if err != nil || age <= 0 {
+			return nil, errors.New("invalid retention period " + value + " of priority " + string(class))
+		}
+		priorities[class] = age
+	}
+	interval := time.Hour
+	if retention.Interval != "" {
+		interval, err = time.ParseDuration(retention.Interval)
+		if err != nil || interval <= 0 {
+			return nil, errors.New("invalid server.retention.interval " + retention.Interval)
+		}
+	}
+	return &ResultCleaner{config, store, maxAge, priorities, interval, sync.Mutex{}, make(map[PriorityClass]ResultsRemoved)}, nil
+}
+
+// touchJobFile restarts the retention period of a finished job that was submitted again and reuses its results
+func touchJobFile(workdir string) {
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(workdir, "job.json"), now, now); err != nil && !errors.Is(err, os.ErrNotExist) {
+		cleanupLog.Error("Failed to renew the retention period", "path", workdir, "error", err)
+	}
+}
+
+// Sweep removes the expired results, the job file is rewritten when a job finishes and touched when it is reused,
+// so its modification time is the end or the last submission of the job.
+// Directories without a readable job file are removed after the default retention period.
+func (c *ResultCleaner) Sweep(now time.Time) {
+	base := filepath.Clean(c.config.Paths.Results)
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		cleanupLog.Error("Failed to clean up results", "error", err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !validId(entry.Name()) {
+			continue
+		}
+		dir := filepath.Join(base, entry.Name())
+		maxAge := c.maxAge
+		class := PriorityInteractive
+		info, err := os.Stat(filepath.Join(dir, "job.json"))
+		if err == nil {
+			request, err := getJobRequestFromFile(filepath.Join(dir, "job.json"))
+			if err == nil {
+				if request.Status == StatusPending || request.Status == StatusRunning {
+					continue
+				}
+				class = request.Priority(c.config.Worker.BatchThreshold)
+				if age, ok := c.priorities[class]; ok {
+					maxAge = age
+				}
+			}
+		} else if info, err = entry.Info(); err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) < maxAge {
+			continue
+		}
+
+		size := directorySize(dir)
+		// the store goes first, the directory is kept for another attempt if that fails
+		if err := c.store.Delete(context.Background(), Id(entry.Name())); err != nil {
+			cleanupLog.Error("Failed to remove expired result from the result store", "ticket", entry.Name(), "error", err)
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			cleanupLog.Error("Failed to remove expired result", "ticket", entry.Name(), "error", err)
+			continue
+		}
+		if c.config.Paths.Temporary != "" {
+			os.RemoveAll(jobTempDir(c.config, Id(entry.Name())))
+		}
+		cleanupLog.Info("Removed expired result", "ticket", entry.Name(), "class", class, "bytes", size, "finished", info.ModTime().Format(time.RFC3339))
+
+		c.mu.Lock()
+		removed := c.removed[class]
+		removed.Jobs++
+		removed.Bytes += size
+		c.removed[class] = removed
+		c.mu.Unlock()
+	}
+}
+
+// Removed returns what was removed since the start, per priority class
+func (c *ResultCleaner) Removed() map[PriorityClass]ResultsRemoved {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := make(map[PriorityClass]ResultsRemoved, len(c.removed))
+	for class, value := range c.removed {
+		removed[class] = value
+	}
+	return removed
+}
+
+func (c *ResultCleaner) Run() {
+	for {
+		c.Sweep(time.Now())
+		time.Sleep(c.interval)
+	}
+}
+
+// writeResultCleanerMetrics exposes the removed results as counters
+func writeResultCleanerMetrics(w io.Writer, removed map[PriorityClass]ResultsRemoved) {
+	classes := make([]string, 0, len(removed))
+	for class := range removed {
+		classes = append(classes, string(class))
+	}
+	sort.Strings(classes)
+	writeMetricHeader(w, "mmseqs_results_removed_total", "counter", "Expired job results removed by the retention policy.")
+	for _, class := range classes {
+		writeMetric(w, "mmseqs_results_removed_total", map[string]string{"priority": class}, float64(removed[PriorityClass(class)].Jobs))
+	}
+	writeMetricHeader(w, "mmseqs_results_removed_bytes_total", "counter", "Size of the expired job results removed by the retention policy.")
+	for _, class := range classes {
+		writeMetric(w, "mmseqs_results_removed_bytes_total", map[string]string{"priority": class}, float64(removed[PriorityClass(class)].Bytes))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultCleaner(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Results = t.TempDir()
+	config.Server.Retention = &ConfigRetention{"48h", map[PriorityClass]string{PriorityBatch: "240h"}, ""}
+	cleaner, err := NewResultCleaner(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	write := func(id string, status Status, age time.Duration) string {
+		id += strings.Repeat("a", 38-len(id))
+		dir := filepath.Join(config.Paths.Results, id)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, "job.json")
+		job := `{"id":"` + id + `","status":"` + string(status) + `","type":"search","job":{"size":1,"database":["db"],"mode":"all"}}`
+		if err := os.WriteFile(file, []byte(job), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	expired := write("expired", StatusComplete, 72*time.Hour)
+	recent := write("recent", StatusComplete, time.Hour)
+	running := write("running", StatusRunning, 72*time.Hour)
+	// submitted again and answered with the existing results
+	reused := write("reused", StatusComplete, 72*time.Hour)
+	touchJobFile(reused)
+
+	cleaner.Sweep(now)
+	if fileExists(expired) {
+		t.Error("expired result was not removed")
+	}
+	if !fileExists(recent) || !fileExists(running) || !fileExists(reused) {
+		t.Error("results within the retention period or of running jobs were removed")
+	}
+	if removed := cleaner.Removed()[PriorityInteractive]; removed.Jobs != 1 || removed.Bytes == 0 {
+		t.Errorf("unexpected removed %+v", removed)
+	}
+
+	config.Server.Retention.MaxAge = "0s"
+	if _, err := NewResultCleaner(config, nil); err == nil {
+		t.Error("expected an invalid retention period")
+	}
+}
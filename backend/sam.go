@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// samDatabase checks that SAM output can be written for a search against a database,
+// SAM needs a nucleotide reference
+func samDatabase(job SearchJob, dbPath string) error {
+	st, ok := searchTypes[job.SearchType]
+	if !ok {
+		nucleotide, err := databaseIsNucleotide(dbPath)
+		if err != nil {
+			return err
+		}
+		st.nucleotide = nucleotide
+	}
+	if !st.nucleotide {
+		return errors.New("SAM output needs a nucleotide database, " + filepath.Base(dbPath) + " is not")
+	}
+	return nil
+}
+
+// samPath is the result database with the SAM records of each query of a search against database
+func samPath(base string, database string) string {
+	return filepath.Join(base, "alis_"+database+"_sam")
+}
+
+// writeSearchSam converts the alignments of a finished search to SAM records with convertalis,
+// it needs the temporary files of the search like writeSearchMsas
+func writeSearchSam(ctx context.Context, executor Executor, mmseqs string, searchTemp string, result string, target string, base string, database string, extra []string) error {
+	parameters := []string{
+		mmseqs,
+		"convertalis",
+		filepath.Join(searchTemp, "latest", "query"),
+		target,
+		filepath.Join(searchTemp, "latest", result),
+		samPath(base, database),
+		"--format-mode",
+		"1",
+		"--db-output",
+		"1",
+		"--db-load-mode",
+		"2",
+	}
+	return runStep(ctx, executor, append(parameters, extra...)...)
+}
+
+// samName is the identifier of a (full) header, SAM names can not contain whitespace
+func samName(header string) string {
+	name, _, _ := strings.Cut(header, " ")
+	return name
+}
+
+// SAM writes the alignments of nucleotide searches in SAM format, the records are the ones convertalis wrote.
+// The header lists all targets with hits of the given queries.
+func SAM(w io.Writer, base string, databases []string, queries []uint32) error {
+	columns := resultColumns(false)
+	readers := make([]*Reader[uint32], 0, len(databases))
+	defer func() {
+		for _, reader := range readers {
+			reader.Delete()
+		}
+	}()
+
+	out := bufio.NewWriter(w)
+	out.WriteString("@HD\tVN:1.6\tSO:unsorted\n")
+	seen := make(map[string]bool)
+	for _, database := range databases {
+		if !fileExists(samPath(base, database) + ".index") {
+			return errors.New("SAM output is not available for the results of " + database + ", they were searched before SAM records were written")
+		}
+		alignments := Reader[uint32]{}
+		if err := alignments.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+			return err
+		}
+		err := forEachAlignment(&alignments, columns, queries, func(fields []string) error {
+			name := samName(fields[1])
+			if seen[name] {
+				return nil
+			}
+			seen[name] = true
+			out.WriteString("@SQ\tSN:" + name + "\tLN:" + fields[columns.TargetLength] + "\n")
+			return nil
+		})
+		alignments.Delete()
+		if err != nil {
+			return err
+		}
+		reader := &Reader[uint32]{}
+		if err := reader.Make(dbpaths(samPath(base, database))); err != nil {
+			return err
+		}
+		readers = append(readers, reader)
+	}
+	out.WriteString("@PG\tID:mmseqs\tPN:mmseqs\n")
+
+	for _, reader := range readers {
+		for _, i := range resultEntries(reader, queries) {
+			err := reader.ScanLines(i, func(line string) error {
+				// the header is written once for all databases
+				if line == "" || strings.HasPrefix(line, "@") {
+					return nil
+				}
+				out.WriteString(line)
+				return out.WriteByte('\n')
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return out.Flush()
+}
+
+func init() {
+	RegisterResultFormatter("sam", resultFormat{"sam", "text/plain", func(r ResultRequest) (ResultWriter, error) {
+		job, ok := r.Request.Job.(SearchJob)
+		if !ok {
+			return nil, errors.New("SAM output is only available for sequence searches")
+		}
+		for _, database := range r.Databases {
+			if err := samDatabase(job, filepath.Join(r.Config.Paths.Databases, database)); err != nil {
+				return nil, err
+			}
+		}
+		return func(w io.Writer) error {
+			return SAM(w, r.Base, r.Databases, r.Queries)
+		}, nil
+	}})
+}
@@ -5,8 +5,8 @@ import (
 	"encoding/base64"
 	"errors"
 	"os"
-	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -15,7 +15,18 @@ type SearchJob struct {
 	Database  []string `json:"database" validate:"required"`
 	Mode      string   `json:"mode" validate:"required"`
 	TaxFilter string   `json:"taxfilter"`
-	query     string
+	// one of blastp, blastn, blastx, tblastn, tblastx, selected per database from the query and database type if empty
+	SearchType       string   `json:"searchtype,omitempty"`
+	QueryType        string   `json:"querytype,omitempty"`
+	TranslationTable int      `json:"translationtable,omitempty"`
+	Params           []string `json:"params,omitempty"`
+	// mmseqs search flags from the defaults of each database that Params do not override, keyed by database
+	DatabaseParams map[string][]string `json:"databaseparams,omitempty"`
+	// headers of identical queries that were removed, keyed by the index of the searched query
+	Duplicates map[int][]string `json:"duplicates,omitempty"`
+	// also build the MSAs of the hits with result2msa
+	Msa   bool `json:"msa,omitempty"`
+	query string
 }
 
 func (r SearchJob) Hash() Id {
@@ -26,6 +37,20 @@ func (r SearchJob) Hash() Id {
 	if r.TaxFilter != "" {
 		h.Write([]byte(r.TaxFilter))
 	}
+	if r.SearchType != "" {
+		h.Write([]byte(r.SearchType))
+	}
+	if r.TranslationTable != 0 {
+		h.Write([]byte(strconv.Itoa(r.TranslationTable)))
+	}
+	for _, value := range r.Params {
+		h.Write([]byte(value))
+	}
+	if r.Msa {
+		h.Write([]byte("msa"))
+	}
+	hashDatabaseParams(h, r.DatabaseParams)
+	hashDuplicates(h, r.Duplicates)
 
 	sort.Strings(r.Database)
 
@@ -66,28 +91,47 @@ func isIn(num string, params []string) int {
 	return -1
 }
 
-var validTaxonFilter = regexp.MustCompile(`^[0-9]+(,!?[0-9]+)*$|^$`).MatchString
-
-func NewSearchJobRequest(query string, dbs []string, validDbs []Params, mode string, resultPath string, email string, taxfilter string) (JobRequest, error) {
+func NewSearchJobRequest(query string, duplicates map[int][]string, dbs []string, validDbs []Params, mode string, searchType string, translationTable int, msa bool, resultPath string, email string, taxfilter string, parameters string) (JobRequest, error) {
+	extra, err := ParseExtraParameters(JobSearch, parameters)
+	var defaults map[string][]string
+	if err == nil {
+		defaults, err = databaseSearchDefaults(JobSearch, dbs, validDbs, extra)
+	}
+	mode = defaultSearchMode(mode, dbs, validDbs)
 	job := SearchJob{
 		max(strings.Count(query, ">"), 1),
 		dbs,
 		mode,
 		taxfilter,
+		searchType,
+		DetectQueryType(query),
+		translationTable,
+		extra,
+		defaults,
+		duplicates,
+		msa,
 		query,
 	}
 
 	request := JobRequest{
-		job.Hash(),
-		StatusPending,
-		JobSearch,
-		job,
-		email,
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobSearch,
+		Job:    job,
+		Email:  email,
 	}
 
-	ids := make([]string, len(validDbs))
-	for i, item := range validDbs {
-		ids[i] = item.Path
+	if err != nil {
+		return request, err
+	}
+
+	ids := make([]string, 0, len(validDbs))
+	for _, item := range validDbs {
+		// structure databases can only be selected by structure searches
+		if item.Structure {
+			continue
+		}
+		ids = append(ids, item.Path)
 	}
 
 	for _, item := range job.Database {
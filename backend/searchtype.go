@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	QueryProtein    = "protein"
+	QueryNucleotide = "nucleotide"
+)
+
+// BLAST-style names for the combinations of query and database types, an empty search type is selected automatically
+var searchTypes = map[string]struct {
+	query      string
+	nucleotide bool
+	translated bool
+	// value of mmseqs --search-type
+	value string
+}{
+	"blastp":  {QueryProtein, false, false, "1"},
+	"blastn":  {QueryNucleotide, true, false, "3"},
+	"blastx":  {QueryNucleotide, false, true, "2"},
+	"tblastn": {QueryProtein, true, true, "2"},
+	"tblastx": {QueryNucleotide, true, true, "4"},
+}
+
+// DetectQueryType classifies a sanitized FASTA query as nucleotide if nearly all of its residues are nucleotides
+func DetectQueryType(fasta string) string {
+	residues := 0
+	nucleotides := 0
+	for _, line := range strings.Split(fasta, "\n") {
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		for _, c := range line {
+			residues++
+			switch c {
+			case 'A', 'C', 'G', 'T', 'U', 'N':
+				nucleotides++
+			}
+		}
+	}
+	if residues > 0 && float64(nucleotides) >= 0.9*float64(residues) {
+		return QueryNucleotide
+	}
+	return QueryProtein
+}
+
+// databaseDbType reads the type of an mmseqs database from its .dbtype file
+func databaseDbType(path string) (uint32, error) {
+	data, err := os.ReadFile(path + ".dbtype")
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 4 {
+		return 0, errors.New("invalid dbtype file " + path + ".dbtype")
+	}
+	// the upper bits contain flags like compression
+	return binary.LittleEndian.Uint32(data) & 0xFFFF, nil
+}
+
+func databaseIsNucleotide(path string) (bool, error) {
+	dbtype, err := databaseDbType(path)
+	return dbtype == dbTypeNucleotides, err
+}
+
+func validTranslationTable(table int) bool {
+	return table >= 0 && table <= 31
+}
+
+// CheckSearchType checks that an explicitly selected search type fits the query and the selected databases,
+// databases that are not indexed yet are not checked
+func CheckSearchType(searchType string, queryType string, translationTable int, dbs []string, basepath string) error {
+	if !validTranslationTable(translationTable) {
+		return errors.New("invalid translation table")
+	}
+	if searchType == "" {
+		return nil
+	}
+	st, ok := searchTypes[searchType]
+	if !ok {
+		return errors.New("invalid search type " + searchType)
+	}
+	if st.query != queryType {
+		return errors.New("search type " + searchType + " requires a " + st.query + " query")
+	}
+	for _, db := range dbs {
+		nucleotide, err := databaseIsNucleotide(filepath.Join(basepath, filepath.Base(db)))
+		if err != nil {
+			continue
+		}
+		if nucleotide != st.nucleotide {
+			return errors.New("search type " + searchType + " cannot be used with database " + db)
+		}
+	}
+	return nil
+}
+
+// searchTypeParameters returns the mmseqs parameters for searching a job's query against a database
+func searchTypeParameters(job SearchJob, dbPath string) []string {
+	var parameters []string
+	translated := false
+	if st, ok := searchTypes[job.SearchType]; ok {
+		parameters = append(parameters, "--search-type", st.value)
+		translated = st.translated
+	} else if nucleotide, err := databaseIsNucleotide(dbPath); err == nil {
+		queryNucleotide := job.QueryType == QueryNucleotide
+		switch {
+		case queryNucleotide && nucleotide:
+			parameters = append(parameters, "--search-type", "3")
+		case queryNucleotide != nucleotide:
+			parameters = append(parameters, "--search-type", "2")
+			translated = true
+		}
+	}
+	if translated && job.TranslationTable != 0 {
+		parameters = append(parameters, "--translation-table", strconv.Itoa(job.TranslationTable))
+	}
+	return parameters
+}
+
+// searchTypeAlphabet returns the alphabet queries of a search type are validated against
+func searchTypeAlphabet(searchType string) string {
+	if st, ok := searchTypes[searchType]; ok && st.query == QueryNucleotide {
+		return AlphabetNucleotide
+	}
+	return AlphabetProtein
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,7 +21,6 @@ import (
 	"github.com/didip/tollbooth/v6"
 	"github.com/didip/tollbooth/v6/limiter"
 	"github.com/goji/httpauth"
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
@@ -38,29 +40,83 @@ func CorsCache(h http.Handler, maxAge int) http.Handler {
 	})
 }
 
-func server(jobsystem JobSystem, config ConfigRoot) {
-	go func() {
-		databases, err := Databases(config.Paths.Databases, false)
+func server(jobsystem JobSystem, config ConfigRoot, watchdog *DiskWatchdog) {
+	watcher := NewDatabaseWatcher(jobsystem, config)
+	go watcher.Run(config.Server.DbWatch)
+	usage, err := NewDiskUsageTracker(config)
+	if err != nil {
+		panic(err)
+	}
+	go usage.Run(diskUsageInterval)
+	residency := NewResidencyTracker(config)
+	go residency.Run(residencyInterval)
+	go databaseRemover(config)
+	stats := NewDatabaseStatsRecorder(config)
+	go stats.Run(time.Minute)
+	alerter := NewAlerter(config)
+	if config.Alerts != nil {
+		monitor, err := NewAlertMonitor(jobsystem, *config.Alerts, alerter)
 		if err != nil {
 			panic(err)
 		}
-
-		for _, db := range databases {
-			if db.Status == StatusRunning {
-				continue
-			}
-
-			request, err := NewIndexJobRequest(db.Path, "")
-			if err != nil {
-				panic(err)
-			}
-
-			_, err = jobsystem.NewJob(request, config.Paths.Results, true)
-			if err != nil {
-				panic(err)
-			}
+		go monitor.Run()
+	}
+	go databaseUpdater(jobsystem, config, alerter)
+	resultStore, err := NewResultStore(config)
+	if err != nil {
+		panic(err)
+	}
+	// jobResults is the results directory to read a finished job from
+	jobResults := func(ctx context.Context, id Id) (string, error) {
+		if resultStore == nil {
+			return filepath.Clean(config.Paths.Results), nil
+		}
+		return resultStore.Fetch(ctx, id)
+	}
+	cleaner, err := NewResultCleaner(config, resultStore)
+	if err != nil {
+		panic(err)
+	}
+	if cleaner != nil {
+		go cleaner.Run()
+	}
+	verifications := newVerificationStore(jobsystem)
+	deliveryStatuses := newDeliveryStatusStore(jobsystem)
+	verificationMailer := MailTransport(NullTransport{})
+	if config.Mail.Mailer != nil {
+		verificationMailer = config.Mail.Mailer.GetTransport()
+	}
+	if verificationMailer, err = signMail(verificationMailer, config.Mail); err != nil {
+		panic(err)
+	}
+	optOuts, err := newOptOutStore(jobsystem, config)
+	if err != nil {
+		panic(err)
+	}
+	if config.Mail.Unsubscribe != nil {
+		verificationMailer = optOutTransport{verificationMailer, optOuts}
+	}
+	telegram, err := NewTelegramLinker(jobsystem, config)
+	if err != nil {
+		panic(err)
+	}
+	if telegram != nil {
+		go telegram.Run()
+	}
+	parseNotification := func(request *JobRequest, form url.Values) error {
+		if err := ParseNotification(request, form); err != nil {
+			return err
 		}
-	}()
+		return telegram.Resolve(request)
+	}
+	requestVerification := func(request JobRequest) {
+		if len(request.Notify) > 0 && !isNotified(request.Notify, NotifyEmail) {
+			return
+		}
+		if err := RequestVerification(config.Mail, verifications, verificationMailer, request.Email); err != nil && !errors.Is(err, errDeliverySkipped) {
+			notifyLog.Error("Failed to send verification mail", "ticket", request.Id, "error", err)
+		}
+	}
 
 	baseRouter := mux.NewRouter()
 	var r *mux.Router
@@ -85,13 +141,41 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 
 	databasesHandler := func(complete bool) func(http.ResponseWriter, *http.Request) {
 		return func(w http.ResponseWriter, req *http.Request) {
+			snapshot, err := databaseSnapshot(config.Paths.Databases)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// clients have to revalidate, so that added or removed databases show up immediately
+			etag := databasesETag(snapshot)
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", etag)
+			// the full list includes the build progress, which changes without touching the params
+			if complete && req.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			filter, err := parseDatabaseFilter(req.URL.Query())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			databases, err := Databases(config.Paths.Databases, complete)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
+			// e.g. ?type=protein&taxonomy=true&tag=reference
+			databases = filterDatabases(config.Paths.Databases, databases, filter)
 
-			err = json.NewEncoder(w).Encode(DatabaseResponse{databases})
+			// the full list also reports the progress of databases that are being built
+			if !complete {
+				databases = visibleDatabases(databases, req.Header.Get(sessionTokenHeader))
+				err = json.NewEncoder(w).Encode(DatabaseStatusResponse{DatabaseStatuses(config, databases)})
+			} else {
+				err = json.NewEncoder(w).Encode(DatabaseResponse{databases})
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
@@ -101,8 +185,131 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 	r.HandleFunc("/databases", databasesHandler(true)).Methods("GET")
 	r.HandleFunc("/databases/all", databasesHandler(false)).Methods("GET")
 
+	r.HandleFunc("/databases/groups", func(w http.ResponseWriter, req *http.Request) {
+		type GroupsResponse struct {
+			Groups []DatabaseGroup `json:"groups"`
+		}
+		groups, err := DatabaseGroups(config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-cache")
+		err = json.NewEncoder(w).Encode(GroupsResponse{groups})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}).Methods("GET")
+
 	if config.Server.DbManagment {
-		r.HandleFunc("/databases/order", func(w http.ResponseWriter, req *http.Request) {
+		if config.Server.DbToken == "" {
+			serverLog.Warn("Database management is enabled without a server.dbtoken, all management requests are refused")
+		}
+		r.HandleFunc("/databases/reload", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			added, removed, err := watcher.Reload()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			type ReloadResponse struct {
+				Added   []string `json:"added"`
+				Removed []string `json:"removed"`
+			}
+			err = json.NewEncoder(w).Encode(ReloadResponse{added, removed})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("POST")
+
+		r.HandleFunc("/usage", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache, no-store")
+			err := json.NewEncoder(w).Encode(usage.Usage())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("GET")
+
+		r.HandleFunc("/statistics", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			snapshot, err := stats.Snapshot()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-cache, no-store")
+			err = json.NewEncoder(w).Encode(databaseStatistics(snapshot, usage.Usage()))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("GET")
+
+		// the intermediate files kept of a job with worker.intermediates, path=<file> downloads one of them
+		r.HandleFunc("/debug/intermediates/{ticket}", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			id := Id(mux.Vars(req)["ticket"])
+			if !validId(string(id)) {
+				http.Error(w, "invalid ticket", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-cache, no-store")
+			if name := req.URL.Query().Get("path"); name != "" {
+				path, err := intermediatePath(config, id, name)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				w.Header().Set("Content-Disposition", "attachment; filename=\""+filepath.Base(path)+"\"")
+				if fileExists(path) {
+					http.ServeFile(w, req, path)
+					return
+				}
+				file, err := openResultFile(path)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				defer file.Close()
+				w.Header().Set("Content-Type", "application/octet-stream")
+				io.Copy(w, file)
+				return
+			}
+			files, err := ListIntermediates(config, id)
+			if os.IsNotExist(err) {
+				http.Error(w, "No intermediate files were kept for this job", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = json.NewEncoder(w).Encode(files)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("GET")
+
+		// an empty database[] removes the group
+		r.HandleFunc("/databases/group", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = SaveDatabaseGroup(config, req.FormValue("name"), req.Form["database[]"])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})).Methods("POST")
+
+		r.HandleFunc("/databases/order", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
 			err := req.ParseForm()
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -121,12 +328,14 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-		}).Methods("POST")
+		})).Methods("POST")
 
-		r.HandleFunc("/database", func(w http.ResponseWriter, req *http.Request) {
+		r.HandleFunc("/database", dbManagementAuthorized(config, usage.QuotaLimited(func(w http.ResponseWriter, req *http.Request) {
 			var request JobRequest
 
-			var data string
+			// the input file is either uploaded, given as form value or downloaded from a URL by the worker
+			var upload io.Reader
+			var uploadName string
 			if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
 				err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
 				if err != nil {
@@ -134,79 +343,103 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 					return
 				}
 
-				f, _, err := req.FormFile("file")
-				if err != nil {
+				f, header, err := req.FormFile("file")
+				if err == nil {
+					defer f.Close()
+					upload = f
+					uploadName = header.Filename
+				} else if req.FormValue("url") == "" {
 					http.Error(w, err.Error(), http.StatusBadRequest)
 					return
 				}
-
-				buf := new(bytes.Buffer)
-				buf.ReadFrom(f)
-				data = buf.String()
 			} else {
 				err := req.ParseForm()
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusBadRequest)
 					return
 				}
-				data = req.FormValue("file")
+				if data := req.FormValue("file"); data != "" {
+					upload = strings.NewReader(data)
+				}
+			}
+
+			source := req.FormValue("url")
+			if source != "" {
+				if err := validSourceURL(source); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if err := validChecksum(req.FormValue("checksum")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
 
-			var suffix string
-			switch req.FormValue("format") {
-			case "fasta":
-				suffix = ".fasta"
-			case "stockholm":
-				suffix = ".sto"
-			default:
+			suffix, err := databaseInputSuffix(req.FormValue("format"))
+			if err != nil {
 				http.Error(w, "Invalid database input file", http.StatusBadRequest)
 				return
 			}
 
+			indexOptions, err := parseIndexOptions(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			links, err := parseLinkTemplates(req.FormValue("links"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
 			var path string
 			if len(req.FormValue("path")) > 0 {
 				path = filepath.Base(req.FormValue("path"))
-				if !fileExists(filepath.Join(config.Paths.Databases, path+suffix)) {
+				if !fileExists(filepath.Join(config.Paths.Databases, path+suffix)) && !(req.FormValue("format") == "structures" && fileExists(filepath.Join(config.Paths.Databases, path+structureDirSuffix))) {
 					http.Error(w, "Indicated file does not exist already", http.StatusBadRequest)
 					return
 				}
+				source = ""
 			} else {
-				path = SafePath(config.Paths.Databases, req.FormValue("name"), req.FormValue("version"))
-
-				f, err := os.Create(filepath.Join(config.Paths.Databases, filepath.Base(path+suffix)))
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusBadRequest)
+				if upload == nil && source == "" {
+					http.Error(w, "Either a file or a URL is required", http.StatusBadRequest)
 					return
 				}
-				_, err = f.WriteString(data)
-				if err != nil {
-					f.Close()
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return
-				}
-				err = f.Close()
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return
+				path = SafePath(config.Paths.Databases, req.FormValue("name"), req.FormValue("version"))
+				if upload != nil {
+					source = ""
+					err := writeDatabaseSource(filepath.Join(config.Paths.Databases, filepath.Base(path+suffix)), upload, strings.HasSuffix(uploadName, ".gz"))
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
 				}
 			}
 			params := Params{
-				req.FormValue("name"),
-				req.FormValue("version"),
-				path,
-				req.FormValue("default") == "true",
-				0,
-				false,
-				false,
-				false,
-				req.FormValue("index"),
-				req.FormValue("search"),
-				"",
-				StatusPending,
+				ID:           newDatabaseId(),
+				Name:         req.FormValue("name"),
+				Version:      req.FormValue("version"),
+				Description:  req.FormValue("description"),
+				Citation:     req.FormValue("citation"),
+				Links:        links,
+				Scope:        req.FormValue("scope"),
+				Tags:         splitTags(req.FormValue("tags")),
+				Path:         path,
+				Default:      req.FormValue("default") == "true",
+				Structure:    req.FormValue("format") == "structures",
+				Index:        req.FormValue("index"),
+				IndexOptions: indexOptions,
+				Search:       req.FormValue("search"),
+				GPU:          req.FormValue("gpu") == "true",
+				Source:       source,
+				Format:       req.FormValue("format"),
+				Checksum:     req.FormValue("checksum"),
+				Status:       StatusPending,
 			}
 
 			filename := filepath.Join(config.Paths.Databases, filepath.Base(path+".params"))
-			err := SaveParams(filename, params)
+			err = SaveParams(filename, params)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
@@ -228,9 +461,9 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-		}).Methods("POST")
+		}))).Methods("POST")
 
-		r.HandleFunc("/database", func(w http.ResponseWriter, req *http.Request) {
+		r.HandleFunc("/database", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
 			var path string
 			if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
 				type DatabaseRequest struct {
@@ -251,451 +484,1745 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 
 				path = req.FormValue("path")
 			}
-			ok := DeleteDatabase(filepath.Join(config.Paths.Databases, filepath.Base(path)))
-			if !ok {
-				http.Error(w, "Delete request failed", http.StatusBadRequest)
+			removed, err := DeleteDatabase(config, filepath.Base(path))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-		}).Methods("DELETE")
+			// the files are removed once the last job using the database finished
+			if !removed {
+				w.WriteHeader(http.StatusAccepted)
+			}
+		})).Methods("DELETE")
 
-	}
-	ticketHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
-		var query string
-		var dbs []string
-		var mode string
-		var email string
-		var taxfilter string
+		r.HandleFunc("/database/disable", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 
-		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
-			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+			params, err := SetDatabaseDisabled(config.Paths.Databases, req.FormValue("path"), req.FormValue("disabled") != "false")
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
-			f, _, err := req.FormFile("q")
+			err = json.NewEncoder(w).Encode(params)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
+		})).Methods("POST")
 
-			buf := new(bytes.Buffer)
-			buf.ReadFrom(f)
-			query = buf.String()
-			dbs = req.Form["database[]"]
-			mode = req.FormValue("mode")
-			email = req.FormValue("email")
-			taxfilter = req.FormValue("taxfilter")
-		} else {
+		// builds a database from the sequences of UniProt or NCBI protein accessions, which are fetched by the index job
+		r.HandleFunc("/database/accessions", dbManagementAuthorized(config, usage.QuotaLimited(func(w http.ResponseWriter, req *http.Request) {
 			err := req.ParseForm()
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			query = req.FormValue("q")
-			dbs = req.Form["database[]"]
-			mode = req.FormValue("mode")
-			email = req.FormValue("email")
-			taxfilter = req.FormValue("taxfilter")
-		}
-
-		databases, err := Databases(config.Paths.Databases, true)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
 
-		var request JobRequest
-		if config.App == AppMMseqs2 {
-			request, err = NewSearchJobRequest(query, dbs, databases, mode, config.Paths.Results, email, taxfilter)
-		} else if config.App == AppFoldSeek {
-			modes := strings.Split(mode, "-")
-			modeIdx := isIn("complex", modes)
-			if modeIdx != -1 {
-				modeWithoutComplex := strings.Join(append(modes[:modeIdx], modes[modeIdx+1:]...), "-")
-				request, err = NewComplexSearchJobRequest(query, dbs, databases, modeWithoutComplex, config.Paths.Results, email, taxfilter)
-			} else {
-				request, err = NewStructureSearchJobRequest(query, dbs, databases, mode, config.Paths.Results, email, taxfilter)
+			accessions, err := parseAccessionList(req.FormValue("accessions"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-		} else {
-			http.Error(w, "Job type not supported by this server", http.StatusBadRequest)
-			return
-		}
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		err = json.NewEncoder(w).Encode(result)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-	}
-
-	ticketMsaHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
-		var request JobRequest
-
-		var query string
-		var dbs []string
-		var mode string
-		var email string
-
-		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
-			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+			indexOptions, err := parseIndexOptions(req)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
-			f, _, err := req.FormFile("q")
+			links, err := parseLinkTemplates(req.FormValue("links"))
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
-			buf := new(bytes.Buffer)
-			buf.ReadFrom(f)
-			query = buf.String()
-			dbs = req.Form["database[]"]
-			mode = req.FormValue("mode")
-			email = req.FormValue("email")
-		} else {
-			err := req.ParseForm()
+			path := SafePath(config.Paths.Databases, req.FormValue("name"), req.FormValue("version"))
+			err = os.WriteFile(filepath.Join(config.Paths.Databases, path+".accessions"), []byte(strings.Join(accessions, "\n")+"\n"), 0644)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			query = req.FormValue("q")
-			dbs = req.Form["database[]"]
-			mode = req.FormValue("mode")
-			email = req.FormValue("email")
+			params := Params{
+				ID:           newDatabaseId(),
+				Name:         req.FormValue("name"),
+				Version:      req.FormValue("version"),
+				Description:  req.FormValue("description"),
+				Citation:     req.FormValue("citation"),
+				Links:        links,
+				Scope:        req.FormValue("scope"),
+				Tags:         splitTags(req.FormValue("tags")),
+				Path:         path,
+				Default:      req.FormValue("default") == "true",
+				Index:        req.FormValue("index"),
+				IndexOptions: indexOptions,
+				Search:       req.FormValue("search"),
+				GPU:          req.FormValue("gpu") == "true",
+				Format:       accessionsFormat,
+				Status:       StatusPending,
+			}
+			err = SaveParams(filepath.Join(config.Paths.Databases, path+".params"), params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			request, err := NewIndexJobRequest(path, req.FormValue("email"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result, err := jobsystem.NewJob(request, config.Paths.Results, true)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = json.NewEncoder(w).Encode(result)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}))).Methods("POST")
+
+		// replaces a database by a release that was built elsewhere on the file system, mode is link (default) or move,
+		// keep defaults to the keep of the configured updates of the database
+		r.HandleFunc("/database/swap", dbManagementAuthorized(config, usage.QuotaLimited(func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			path := req.FormValue("path")
+			params, err := ReadParams(filepath.Join(config.Paths.Databases, filepath.Base(path)+".params"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mode := req.FormValue("mode")
+			if mode == "" {
+				mode = "link"
+			}
+			keep := config.Updates[params.Path].Keep
+			if value := req.FormValue("keep"); value != "" {
+				keep, err = strconv.Atoi(value)
+				if err != nil || keep < 0 {
+					http.Error(w, "invalid keep "+value, http.StatusBadRequest)
+					return
+				}
+			}
+
+			swap, err := beginStagedSwap(config, params.Path)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			defer swap.Close()
+			if err := swap.Stage(req.FormValue("source"), mode); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := swap.Verify(params); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// waits for the running searches of the database as long as the client does, new searches wait for the swap,
+			// params are the release the new version is listed as
+			params, err = swap.Commit(req.Context(), keep, func(params *Params) {
+				if upstream := req.FormValue("upstream"); upstream != "" {
+					params.Upstream = upstream
+				}
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			databaseLog.Info("Swapped in a new release", "database", params.Path)
+
+			err = json.NewEncoder(w).Encode(params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}))).Methods("POST")
+
+		// a gzip compressed tar archive of the files and params of a database, sources=true includes its input files
+		r.HandleFunc("/database/export", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			path := filepath.Base(req.URL.Query().Get("path"))
+			if !fileExists(filepath.Join(config.Paths.Databases, path+".params")) {
+				http.Error(w, "database "+path+" not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+path+".tar.gz\"")
+			// the headers are sent already, a failure only ends the stream early
+			if err := ExportDatabase(req.Context(), config, path, req.URL.Query().Get("sources") == "true", w); err != nil {
+				databaseLog.Error("Failed to export database", "database", path, "error", err)
+			}
+		})).Methods("GET")
+
+		// adds a database from the export of another instance, the package is either the request body or fetched
+		// from source (http(s), gs:// or az://), replace=true replaces an existing database with the same path
+		r.HandleFunc("/database/package", dbManagementAuthorized(config, usage.QuotaLimited(func(w http.ResponseWriter, req *http.Request) {
+			query := req.URL.Query()
+			keep := 0
+			if value := query.Get("keep"); value != "" {
+				var err error
+				keep, err = strconv.Atoi(value)
+				if err != nil || keep < 0 {
+					http.Error(w, "invalid keep "+value, http.StatusBadRequest)
+					return
+				}
+			}
+
+			body := io.Reader(req.Body)
+			if source := query.Get("source"); source != "" {
+				if err := validSourceURL(source); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				fetch, err := newSourceRequest(req.Context(), "GET", source)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				res, err := http.DefaultClient.Do(fetch)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadGateway)
+					return
+				}
+				defer res.Body.Close()
+				if res.StatusCode != http.StatusOK {
+					http.Error(w, statusError(source, res).Error(), http.StatusBadGateway)
+					return
+				}
+				body = res.Body
+			}
+
+			params, err := ImportDatabasePackage(req.Context(), config, body, query.Get("replace") == "true", keep)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			databaseLog.Info("Imported database package", "database", params.Path)
+
+			err = json.NewEncoder(w).Encode(params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}))).Methods("POST")
+
+		// registers an already built database from elsewhere on the file system, mode is link (default) or move
+		r.HandleFunc("/database/import", dbManagementAuthorized(config, usage.QuotaLimited(func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mode := req.FormValue("mode")
+			if mode == "" {
+				mode = "link"
+			}
+
+			links, err := parseLinkTemplates(req.FormValue("links"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			params := Params{
+				ID:          newDatabaseId(),
+				Name:        req.FormValue("name"),
+				Version:     req.FormValue("version"),
+				Description: req.FormValue("description"),
+				Citation:    req.FormValue("citation"),
+				Links:       links,
+				Scope:       req.FormValue("scope"),
+				Tags:        splitTags(req.FormValue("tags")),
+				Default:     req.FormValue("default") == "true",
+				Complex:     req.FormValue("complex") == "true",
+				FullHeader:  req.FormValue("full_header") == "true",
+				Search:      req.FormValue("search"),
+				GPU:         req.FormValue("gpu") == "true",
+				Status:      StatusPending,
+			}
+			params, err = ImportDatabase(config, req.FormValue("source"), mode, params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = json.NewEncoder(w).Encode(params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}))).Methods("POST")
+
+		r.HandleFunc("/databases/available", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			databases, err := PublicDatabases(config)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			err = json.NewEncoder(w).Encode(databases)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("GET")
+
+		// the download runs as index job, its progress can be followed with /ticket/log/{ticket}
+		r.HandleFunc("/databases/download", dbManagementAuthorized(config, usage.QuotaLimited(func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			public, err := findPublicDatabase(config, req.FormValue("database"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			indexOptions, err := parseIndexOptions(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			links, err := parseLinkTemplates(req.FormValue("links"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			name := req.FormValue("name")
+			if name == "" {
+				name = public.Name
+			}
+			path := SafePath(config.Paths.Databases, name, req.FormValue("version"))
+			params := Params{
+				ID:           newDatabaseId(),
+				Name:         name,
+				Version:      req.FormValue("version"),
+				Description:  req.FormValue("description"),
+				Citation:     req.FormValue("citation"),
+				Links:        links,
+				Scope:        req.FormValue("scope"),
+				Tags:         splitTags(req.FormValue("tags")),
+				Path:         path,
+				Default:      req.FormValue("default") == "true",
+				Taxonomy:     public.Taxonomy,
+				Structure:    config.App == AppFoldSeek,
+				Index:        req.FormValue("index"),
+				IndexOptions: indexOptions,
+				Search:       req.FormValue("search"),
+				GPU:          req.FormValue("gpu") == "true",
+				Source:       public.Name,
+				Format:       publicDatabaseFormat,
+				Status:       StatusPending,
+			}
+			err = SaveParams(filepath.Join(config.Paths.Databases, path+".params"), params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			request, err := NewIndexJobRequest(path, req.FormValue("email"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result, err := jobsystem.NewJob(request, config.Paths.Results, true)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = json.NewEncoder(w).Encode(result)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}))).Methods("POST")
+
+		// changes the index options of a database and rebuilds its index
+		r.HandleFunc("/database/index", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			indexOptions, err := parseIndexOptions(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			path := filepath.Base(req.FormValue("path"))
+			filename := filepath.Join(config.Paths.Databases, path+".params")
+			if !fileExists(filename) {
+				http.Error(w, "database "+path+" not found", http.StatusBadRequest)
+				return
+			}
+			previous, err := ReadParams(filename)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// the index is only removed if the database can be indexed again right now
+			if !indexRebuildable(filepath.Join(config.Paths.Databases, path), config) {
+				http.Error(w, "Database has no sources to build its index from", http.StatusBadRequest)
+				return
+			}
+			request, err := NewIndexJobRequest(path, req.FormValue("email"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if previous.Status == StatusPending || previous.Status == StatusRunning {
+				http.Error(w, "Database is being built", http.StatusBadRequest)
+				return
+			}
+			if status, err := jobsystem.Status(request.Id); err == nil && (status == StatusPending || status == StatusRunning) {
+				http.Error(w, "Database is being built", http.StatusBadRequest)
+				return
+			}
+
+			aside, err := setAsideIndex(config.Paths.Databases, path)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			params := previous
+			params.IndexOptions = indexOptions
+			if err := SaveParams(filename, params); err != nil {
+				aside.restore()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			result, err := jobsystem.NewJob(request, config.Paths.Results, true)
+			if err != nil {
+				if rerr := SaveParams(filename, previous); rerr != nil {
+					serverLog.Error("Failed to restore the index options", "database", path, "error", rerr)
+				}
+				if rerr := aside.restore(); rerr != nil {
+					serverLog.Error("Failed to restore the index", "database", path, "error", rerr)
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := aside.discard(); err != nil {
+				serverLog.Error("Failed to remove the old index", "database", path, "error", err)
+			}
+
+			err = json.NewEncoder(w).Encode(result)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("POST")
+
+		// replaces the link templates of a database, links is a JSON list of {name, url, pattern}
+		r.HandleFunc("/database/links", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			links, err := parseLinkTemplates(req.FormValue("links"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			params, err := SetDatabaseLinks(config.Paths.Databases, req.FormValue("path"), links)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = json.NewEncoder(w).Encode(params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("POST")
+
+		// tags is a comma separated list, both replace the current values
+		r.HandleFunc("/database/catalog", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			params, err := SetDatabaseCatalog(config.Paths.Databases, req.FormValue("path"), splitTags(req.FormValue("tags")), req.FormValue("scope"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = json.NewEncoder(w).Encode(params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("POST")
+
+		// defaults is a JSON object like {"sensitivity": 7.5, "maxseqs": 1000, "evalue": 0.001, "mode": "summary"}, empty removes them
+		r.HandleFunc("/database/defaults", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			path := req.FormValue("path")
+			params, err := ReadParams(filepath.Join(config.Paths.Databases, filepath.Base(path)+".params"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defaults, err := parseSearchDefaults(req.FormValue("defaults"), params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			params, err = SetDatabaseSearchDefaults(config.Paths.Databases, path, defaults)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = json.NewEncoder(w).Encode(params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("POST")
+
+		r.HandleFunc("/database/rename", dbManagementAuthorized(config, func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			params, err := RenameDatabase(config.Paths.Databases, req.FormValue("path"), req.FormValue("name"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = json.NewEncoder(w).Encode(params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("POST")
+
+	}
+
+	if config.Server.SessionDatabases != nil {
+		sessionLimiter, err := newSessionLimiter(config)
+		if err != nil {
+			panic(err)
+		}
+		// builds an uploaded FASTA file into a database only the session can search, a new session is started without a token
+		r.HandleFunc("/session/database", usage.QuotaLimited(func(w http.ResponseWriter, req *http.Request) {
+			var upload io.Reader
+			var uploadName string
+			if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+				err := req.ParseMultipartForm(int64(32 * 1024 * 1024))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				f, header, err := req.FormFile("file")
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				defer f.Close()
+				upload = f
+				uploadName = header.Filename
+			} else {
+				err := req.ParseForm()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				upload = strings.NewReader(req.FormValue("file"))
+			}
+
+			token := req.Header.Get(sessionTokenHeader)
+			if token == "" {
+				if sessionLimiter != nil {
+					if limitErr := tollbooth.LimitByRequest(sessionLimiter, w, req); limitErr != nil {
+						http.Error(w, "Too many new sessions, send the "+sessionTokenHeader+" of your session", http.StatusTooManyRequests)
+						return
+					}
+				}
+				var err error
+				token, err = newSessionToken()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			name := req.FormValue("name")
+			if name == "" {
+				name = strings.TrimSuffix(uploadName, filepath.Ext(uploadName))
+			}
+			params, err := SaveSessionDatabase(config, token, name, upload)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			request, err := NewIndexJobRequest(params.Path, "")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result, err := jobsystem.NewJob(request, config.Paths.Results, true)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			type SessionDatabaseResponse struct {
+				Token    string `json:"token"`
+				Database Params `json:"database"`
+				Job      Ticket `json:"job"`
+			}
+			err = json.NewEncoder(w).Encode(SessionDatabaseResponse{token, params, result})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		})).Methods("POST")
+
+		r.HandleFunc("/session/databases", func(w http.ResponseWriter, req *http.Request) {
+			databases, err := sessionDatabases(config.Paths.Databases, req.Header.Get(sessionTokenHeader), false)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			type DatabaseResponse struct {
+				Databases []Params `json:"databases"`
+			}
+			err = json.NewEncoder(w).Encode(DatabaseResponse{databases})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}).Methods("GET")
+
+		r.HandleFunc("/session/database", func(w http.ResponseWriter, req *http.Request) {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			removed, err := DeleteSessionDatabase(config, req.Header.Get(sessionTokenHeader), req.FormValue("path"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !removed {
+				w.WriteHeader(http.StatusAccepted)
+			}
+		}).Methods("DELETE")
+	}
+
+	ticketHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
+		var query string
+		var dbs []string
+		var mode string
+		var email string
+		var taxfilter string
+		var accession string
+
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			accession = req.FormValue("accession")
+			f, _, err := req.FormFile("q")
+			if err == nil {
+				buf := new(bytes.Buffer)
+				buf.ReadFrom(f)
+				query = buf.String()
+			} else if accession == "" {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			dbs = req.Form["database[]"]
+			mode = req.FormValue("mode")
+			email = req.FormValue("email")
+			taxfilter = req.FormValue("taxfilter")
+		} else {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			query = req.FormValue("q")
+			accession = req.FormValue("accession")
+			dbs = req.Form["database[]"]
+			mode = req.FormValue("mode")
+			email = req.FormValue("email")
+			taxfilter = req.FormValue("taxfilter")
+		}
+
+		if query == "" && accession != "" {
+			if config.App != AppFoldSeek {
+				http.Error(w, "Accessions are only supported for structure searches", http.StatusBadRequest)
+				return
+			}
+			var err error
+			query, err = FetchStructure(accession)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		dbs, databases, err := resolveJobDatabases(config, watcher, req.Header.Get(sessionTokenHeader), dbs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = CheckTaxonFilter(taxfilter, dbs, databases, config.Paths.Databases)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var request JobRequest
+		if config.App == AppMMseqs2 {
+			searchType := req.FormValue("searchtype")
+			translationTable := 0
+			if value := req.FormValue("translationtable"); value != "" {
+				translationTable, err = strconv.Atoi(value)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			var sanitized SanitizedQuery
+			sanitized, err = SanitizeFasta(query, searchTypeAlphabet(searchType), config.Server.QueryLimits, true)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			request, err = NewSearchJobRequest(sanitized.Fasta, sanitized.Duplicates, dbs, databases, mode, searchType, translationTable, req.FormValue("msa") == "true", config.Paths.Results, email, taxfilter, req.FormValue("params"))
+			if err == nil {
+				job := request.Job.(SearchJob)
+				err = CheckSearchType(job.SearchType, job.QueryType, job.TranslationTable, job.Database, config.Paths.Databases)
+			}
+		} else if config.App == AppFoldSeek {
+			modes := strings.Split(mode, "-")
+			modeIdx := isIn("complex", modes)
+			if modeIdx != -1 {
+				modeWithoutComplex := strings.Join(append(modes[:modeIdx], modes[modeIdx+1:]...), "-")
+				request, err = NewComplexSearchJobRequest(query, dbs, databases, modeWithoutComplex, config.Paths.Results, email, taxfilter, req.FormValue("params"))
+			} else {
+				request, err = NewStructureSearchJobRequest(query, dbs, databases, mode, config.Paths.Results, email, taxfilter, req.FormValue("params"))
+			}
+		} else {
+			http.Error(w, "Job type not supported by this server", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := parseNotification(&request, req.Form); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requestVerification(request)
+		stats.Record(dbs, jobResidues(request))
+
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ticketMsaHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
+		var request JobRequest
+
+		var query string
+		var dbs []string
+		var mode string
+		var email string
+
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			f, _, err := req.FormFile("q")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(f)
+			query = buf.String()
+			dbs = req.Form["database[]"]
+			mode = req.FormValue("mode")
+			email = req.FormValue("email")
+		} else {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			query = req.FormValue("q")
+			dbs = req.Form["database[]"]
+			mode = req.FormValue("mode")
+			email = req.FormValue("email")
+		}
+
+		dbs, databases, err := resolveJobDatabases(config, watcher, req.Header.Get(sessionTokenHeader), dbs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the MSAs are returned per query, identical sequences are kept
+		sanitized, err := SanitizeFasta(query, AlphabetProtein, config.Server.QueryLimits, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request, err = NewMsaJobRequest(sanitized.Fasta, dbs, databases, mode, config.Paths.Results, email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := parseNotification(&request, req.Form); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requestVerification(request)
+		stats.Record(dbs, jobResidues(request))
+
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ticketPairHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
+		var request JobRequest
+
+		var query string
+		var dbs []string
+		var mode string
+		var email string
+
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			f, _, err := req.FormFile("q")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(f)
+			query = buf.String()
+			dbs = req.Form["database[]"]
+			mode = req.FormValue("mode")
+			email = req.FormValue("email")
+		} else {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			query = req.FormValue("q")
+			dbs = req.Form["database[]"]
+			mode = req.FormValue("mode")
+			email = req.FormValue("email")
+		}
+
+		// the colabfold databases are paired against if no databases are selected
+		var databases []Params
+		if len(dbs) > 0 {
+			var err error
+			dbs, databases, err = resolveJobDatabases(config, watcher, req.Header.Get(sessionTokenHeader), dbs)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// all chains of the complex are paired, identical ones included
+		sanitized, err := SanitizeFasta(query, AlphabetProtein, config.Server.QueryLimits, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request, err = NewPairJobRequest(sanitized.Fasta, dbs, databases, mode, email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := parseNotification(&request, req.Form); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requestVerification(request)
+
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ticketClusterHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
+		var query string
+		var email string
+
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			f, _, err := req.FormFile("q")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(f)
+			query = buf.String()
+		} else {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			query = req.FormValue("q")
+		}
+		email = req.FormValue("email")
+
+		mode := req.FormValue("mode")
+		if mode == "" {
+			mode = "cluster"
+		}
+
+		minSeqId := 0.0
+		coverage := 0.8
+		covMode := 0
+		var err error
+		if value := req.FormValue("minseqid"); value != "" {
+			minSeqId, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := req.FormValue("coverage"); value != "" {
+			coverage, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := req.FormValue("covmode"); value != "" {
+			covMode, err = strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// identical sequences are kept, they belong to the same cluster
+		sanitized, err := SanitizeFasta(query, AlphabetProtein, config.Server.QueryLimits, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		request, err := NewClusterJobRequest(sanitized.Fasta, mode, minSeqId, coverage, covMode, email, req.FormValue("params"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := parseNotification(&request, req.Form); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requestVerification(request)
+
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ticketFoldMasonMSAHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
+		var queries []string
+		var fileNames []string
+		var gapOpen int64
+		var gapExtend int64
+
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			files := req.MultipartForm.File["queries[]"]
+			if len(files) == 0 {
+				http.Error(w, "No files uploaded", http.StatusBadRequest)
+				return
+			}
+
+			for _, fileHeader := range files {
+				file, err := fileHeader.Open()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				defer file.Close()
+
+				buf := new(bytes.Buffer)
+				_, err = io.Copy(buf, file)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				query := buf.String()
+				queries = append(queries, query)
+			}
+		} else {
+			err := req.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			queries = req.Form["queries[]"]
+
+		}
+		fileNames = req.Form["fileNames[]"]
+
+		gapOpen, err = strconv.ParseInt(req.FormValue("gapOpen"), 10, 32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		gapExtend, err = strconv.ParseInt(req.FormValue("gapExtend"), 10, 32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		request, err := NewFoldMasonMSAJobRequest(queries, fileNames, gapOpen, gapExtend)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// new jobs are rejected while the results or temporary volume is low on space
+	ticketHandlerFunc = watchdog.Handler(ticketHandlerFunc)
+	ticketClusterHandlerFunc = watchdog.Handler(ticketClusterHandlerFunc)
+	ticketMsaHandlerFunc = watchdog.Handler(ticketMsaHandlerFunc)
+	ticketPairHandlerFunc = watchdog.Handler(ticketPairHandlerFunc)
+	ticketFoldMasonMSAHandlerFunc = watchdog.Handler(ticketFoldMasonMSAHandlerFunc)
+
+	if config.Server.RateLimit != nil {
+		type RateLimitResponse struct {
+			Status string `json:"status"`
+			Reason string `json:"reason"`
+		}
+		b, err := json.Marshal(RateLimitResponse{
+			Status: "RATELIMIT",
+			Reason: config.Server.RateLimit.Reason,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		lmt := tollbooth.NewLimiter(config.Server.RateLimit.Rate, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Duration(config.Server.RateLimit.TTL) * time.Hour}).
+			SetBurst(config.Server.RateLimit.Burst).
+			SetMessageContentType("application/json; charset=utf-8").
+			SetMessage(string(b))
+		if config.Server.RateLimit.IpLookupHeader != "" {
+			lmt.SetIPLookups([]string{config.Server.RateLimit.IpLookupHeader})
+		}
+
+		allowlistedCIDRs := parseCIDRs(config.Server.RateLimit.AllowList)
+		if config.App == AppMMseqs2 || config.App == AppFoldSeek {
+			r.Handle("/ticket", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketHandlerFunc)).Methods("POST")
+		}
+		if config.App == AppMMseqs2 {
+			r.Handle("/ticket/cluster", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketClusterHandlerFunc)).Methods("POST")
+		}
+		if config.App == AppColabFold || config.App == AppPredictProtein {
+			r.Handle("/ticket/msa", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketMsaHandlerFunc)).Methods("POST")
+		}
+		if config.App == AppColabFold {
+			r.Handle("/ticket/pair", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketPairHandlerFunc)).Methods("POST")
+		}
+		if config.App == AppFoldSeek {
+			r.Handle("/ticket/foldmason", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketFoldMasonMSAHandlerFunc)).Methods("POST")
+		}
+	} else {
+		if config.App == AppMMseqs2 || config.App == AppFoldSeek {
+			r.HandleFunc("/ticket", ticketHandlerFunc).Methods("POST")
 		}
+		if config.App == AppMMseqs2 {
+			r.HandleFunc("/ticket/cluster", ticketClusterHandlerFunc).Methods("POST")
+		}
+		if config.App == AppColabFold || config.App == AppPredictProtein {
+			r.HandleFunc("/ticket/msa", ticketMsaHandlerFunc).Methods("POST")
+		}
+		if config.App == AppColabFold {
+			r.HandleFunc("/ticket/pair", ticketPairHandlerFunc).Methods("POST")
+		}
+		if config.App == AppFoldSeek {
+			r.HandleFunc("/ticket/foldmason", ticketFoldMasonMSAHandlerFunc).Methods("POST")
+		}
+	}
+
+	if config.Server.WorkerToken != "" {
+		RegisterWorkerHandlers(r, jobsystem, config)
+	}
+
+	if telegram != nil {
+		telegram.RegisterHandlers(r)
+	}
+	if config.Mail.Verification != nil {
+		r.HandleFunc("/notify/verify/{token}", verifyHandler(config.Mail, verifications)).Methods("GET", "POST")
+	}
+	if config.Mail.Unsubscribe != nil {
+		r.HandleFunc("/notify/unsubscribe/{address}/{signature}", unsubscribeHandler(*config.Mail.Unsubscribe, optOuts)).Methods("GET", "POST")
+	}
 
-		databases, err := Databases(config.Paths.Databases, true)
+	r.HandleFunc("/ticket/type/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		request, err = NewMsaJobRequest(query, dbs, databases, mode, config.Paths.Results, email)
+		request, err := getJobRequestFromFile(filepath.Join(config.Paths.Results, string(ticket.Id), "job.json"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
+
+		type TypeReponse struct {
+			JobType JobType `json:"type"`
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		err = json.NewEncoder(w).Encode(TypeReponse{request.Type})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	}).Methods("GET")
 
-		err = json.NewEncoder(w).Encode(result)
+	r.HandleFunc("/ticket/log/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-	}
-
-	ticketPairHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
-		var request JobRequest
 
-		var query string
-		var mode string
-		var email string
+		sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		// disable response buffering in nginx
+		w.Header().Set("X-Accel-Buffering", "no")
 
-		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
-			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+		status := func() Status {
+			status, err := jobsystem.Status(ticket.Id)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+				return StatusUnknown
 			}
+			return status
+		}
+		path := filepath.Join(config.Paths.Results, string(ticket.Id), "job.log")
+		if err := streamJobLog(req.Context(), w, path, status, sse, 1*time.Second); err != nil {
+			serverLog.Error("Failed to stream job log", "ticket", ticket.Id, "error", err)
+		}
+	}).Methods("GET")
 
-			f, _, err := req.FormFile("q")
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+	r.HandleFunc("/ticket/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		deliveries, err := deliveryStatuses.Deliveries(ticket.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-			buf := new(bytes.Buffer)
-			buf.ReadFrom(f)
-			query = buf.String()
-			mode = req.FormValue("mode")
-			email = req.FormValue("email")
-		} else {
-			err := req.ParseForm()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			query = req.FormValue("q")
-			mode = req.FormValue("mode")
-			email = req.FormValue("email")
+		type TicketResponse struct {
+			Ticket
+			Deliveries []PublicDelivery `json:"deliveries,omitempty"`
+		}
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		err = json.NewEncoder(w).Encode(TicketResponse{ticket, publicDeliveries(deliveries)})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+	}).Methods("GET")
 
-		request, err := NewPairJobRequest(query, mode, email)
+	r.HandleFunc("/tickets", func(w http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
+
+		res, err := jobsystem.MultiStatus(req.Form["tickets[]"])
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		err = json.NewEncoder(w).Encode(result)
+		err = json.NewEncoder(w).Encode(res)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-	}
+	}).Methods("POST")
 
-	ticketFoldMasonMSAHandlerFunc := func(w http.ResponseWriter, req *http.Request) {
-		var queries []string
-		var fileNames []string
-		var gapOpen int64
-		var gapExtend int64
+	r.HandleFunc("/result/download/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		ticket, err := jobsystem.GetTicket(Id(vars["ticket"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
-			err := req.ParseMultipartForm(int64(128 * 1024 * 1024))
+		status, err := jobsystem.Status(ticket.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if status != StatusComplete {
+			jobNotComplete(w, ticket)
+			return
+		}
+
+		// the alignments can be downloaded in any registered result format instead of the archive,
+		// see resultFormatters. query=<identifier> restricts the download to the hits of one query
+		if format := req.URL.Query().Get("format"); format != "" {
+			formatter, ok := resultFormatter(format)
+			if !ok {
+				http.Error(w, "Unknown result format "+format+", available are "+strings.Join(resultFormatterNames(), ", "), http.StatusBadRequest)
+				return
+			}
+			jobsbase, err := jobResults(req.Context(), ticket.Id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			base := filepath.Join(jobsbase, string(ticket.Id))
+			request, err := getJobRequestFromFile(filepath.Join(base, "job.json"))
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-
-			files := req.MultipartForm.File["queries[]"]
-			if len(files) == 0 {
-				http.Error(w, "No files uploaded", http.StatusBadRequest)
+			databases, foldseek, err := resultDatabases(request)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-
-			for _, fileHeader := range files {
-				file, err := fileHeader.Open()
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+			if database := req.URL.Query().Get("database"); database != "" {
+				if isIn(database, databases) == -1 {
+					http.Error(w, "Database not found", http.StatusBadRequest)
 					return
 				}
-				defer file.Close()
-
-				buf := new(bytes.Buffer)
-				_, err = io.Copy(buf, file)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+				databases = []string{database}
+			}
+			var queries []uint32
+			if query := req.URL.Query().Get("query"); query != "" {
+				if _, queries, err = QueryKeys(ticket.Id, jobsbase, query); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
 					return
 				}
-
-				query := buf.String()
-				queries = append(queries, query)
 			}
-		} else {
-			err := req.ParseForm()
+			write, err := formatter.Prepare(ResultRequest{config, base, request, databases, foldseek, queries, req.URL.Query()})
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			queries = req.Form["queries[]"]
 
+			name := "mmseqs_results_" + string(ticket.Id) + "." + formatter.Extension()
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+			w.Header().Set("Content-Type", formatter.ContentType())
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+			if err := write(w); err != nil {
+				serverLog.Error("Failed to write results", "ticket", ticket.Id, "format", format, "error", err)
+			}
+			return
 		}
-		fileNames = req.Form["fileNames[]"]
 
-		gapOpen, err = strconv.ParseInt(req.FormValue("gapOpen"), 10, 32)
+		name := "mmseqs_results_" + string(ticket.Id) + ".tar.gz"
+		// passed through from the result store without a local copy
+		if resultStore.Stored(ticket.Id) {
+			object, err := resultStore.Open(req.Context(), ticket.Id, name)
+			if err != nil && resultCipher != nil {
+				// encrypted archives are decrypted while they are streamed
+				if object, err = resultStore.Open(req.Context(), ticket.Id, name+sealedSuffix); err == nil {
+					object = newSealedReader(object, sealedContext(filepath.Join(resultRoot, string(ticket.Id), name)))
+				}
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer object.Close()
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+			io.Copy(w, object)
+			return
+		}
+		path := filepath.Join(filepath.Clean(config.Paths.Results), string(ticket.Id), name)
+		if !resultFileExists(path) {
+			http.Error(w, "File not found", http.StatusBadRequest)
+			return
+		}
+		file, err := openResultFile(path)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		defer file.Close()
 
-		gapExtend, err = strconv.ParseInt(req.FormValue("gapExtend"), 10, 32)
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		io.Copy(w, bufio.NewReader(file))
+	}).Methods("GET")
+
+	// hit counts and runtimes of a completed job, computed from the results for jobs that finished without a summary
+	r.HandleFunc("/result/summary/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		request, err := NewFoldMasonMSAJobRequest(queries, fileNames, gapOpen, gapExtend)
+		if status, err := jobsystem.Status(ticket.Id); err != nil || status != StatusComplete {
+			jobNotComplete(w, ticket)
+			return
+		}
+		base := filepath.Join(filepath.Clean(config.Paths.Results), string(ticket.Id))
+		summary, err := readJobSummary(base)
+		if os.IsNotExist(err) {
+			var jobsbase string
+			if jobsbase, err = jobResults(req.Context(), ticket.Id); err == nil {
+				base = filepath.Join(jobsbase, string(ticket.Id))
+				var request JobRequest
+				if request, err = getJobRequestFromFile(filepath.Join(base, "job.json")); err == nil {
+					summary, err = SummarizeResults(base, request)
+				}
+			}
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		result, err := jobsystem.NewJob(request, config.Paths.Results, false)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}).Methods("GET")
+
+	// identity, coverage and E-value histograms of the hits per database, computed from the results for jobs that finished without them
+	r.HandleFunc("/result/histograms/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		err = json.NewEncoder(w).Encode(result)
+		if status, err := jobsystem.Status(ticket.Id); err != nil || status != StatusComplete {
+			jobNotComplete(w, ticket)
+			return
+		}
+		base := filepath.Join(filepath.Clean(config.Paths.Results), string(ticket.Id))
+		histograms, err := readJobHistograms(base)
+		if os.IsNotExist(err) {
+			var jobsbase string
+			if jobsbase, err = jobResults(req.Context(), ticket.Id); err == nil {
+				base = filepath.Join(jobsbase, string(ticket.Id))
+				var request JobRequest
+				if request, err = getJobRequestFromFile(filepath.Join(base, "job.json")); err == nil {
+					histograms, err = ComputeHistograms(base, request)
+				}
+			}
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-	}
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if err := json.NewEncoder(w).Encode(histograms); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}).Methods("GET")
 
-	if config.Server.RateLimit != nil {
-		type RateLimitResponse struct {
-			Status string `json:"status"`
-			Reason string `json:"reason"`
+	// UPGMA tree of a query and its hits in Newick format with the clusters below threshold=<distance>,
+	// distances are one minus the identity of the hits on the query positions they share, limit= counts the query as well
+	r.HandleFunc("/result/tree/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		b, err := json.Marshal(RateLimitResponse{
-			Status: "RATELIMIT",
-			Reason: config.Server.RateLimit.Reason,
-		})
+		if status, err := jobsystem.Status(ticket.Id); err != nil || status != StatusComplete {
+			jobNotComplete(w, ticket)
+			return
+		}
+		query := req.URL.Query()
+		limit := defaultTreeHits
+		if value := query.Get("limit"); value != "" {
+			if limit, err = strconv.Atoi(value); err != nil || limit < 2 || limit > maxTreeHits {
+				http.Error(w, "invalid limit "+value, http.StatusBadRequest)
+				return
+			}
+		}
+		threshold := 0.3
+		if value := query.Get("threshold"); value != "" {
+			if threshold, err = strconv.ParseFloat(value, 64); err != nil || threshold < 0 || threshold > 1 {
+				http.Error(w, "invalid threshold "+value, http.StatusBadRequest)
+				return
+			}
+		}
+		jobsbase, err := jobResults(req.Context(), ticket.Id)
 		if err != nil {
-			panic(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-
-		lmt := tollbooth.NewLimiter(config.Server.RateLimit.Rate, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Duration(config.Server.RateLimit.TTL) * time.Hour}).
-			SetBurst(config.Server.RateLimit.Burst).
-			SetMessageContentType("application/json; charset=utf-8").
-			SetMessage(string(b))
-		if config.Server.RateLimit.IpLookupHeader != "" {
-			lmt.SetIPLookups([]string{config.Server.RateLimit.IpLookupHeader})
+		base := filepath.Join(jobsbase, string(ticket.Id))
+		request, err := getJobRequestFromFile(filepath.Join(base, "job.json"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-
-		allowlistedCIDRs := parseCIDRs(config.Server.RateLimit.AllowList)
-		if config.App == AppMMseqs2 || config.App == AppFoldSeek {
-			r.Handle("/ticket", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketHandlerFunc)).Methods("POST")
+		databases, foldseek, err := resultDatabases(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		if config.App == AppColabFold || config.App == AppPredictProtein {
-			r.Handle("/ticket/msa", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketMsaHandlerFunc)).Methods("POST")
+		if database := query.Get("database"); database != "" {
+			if isIn(database, databases) == -1 {
+				http.Error(w, "Database not found", http.StatusBadRequest)
+				return
+			}
+			databases = []string{database}
 		}
-		if config.App == AppColabFold {
-			r.Handle("/ticket/pair", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketPairHandlerFunc)).Methods("POST")
+		_, keys, err := QueryKeys(ticket.Id, jobsbase, query.Get("query"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key := strings.Join([]string{base, strings.Join(databases, ","), query.Get("query"), strconv.Itoa(limit), strconv.FormatFloat(threshold, 'g', -1, 64)}, "\x00")
+		tree, err := hitTrees.get(key, func() (HitTree, error) {
+			return BuildHitTree(base, databases, foldseek, keys, limit, threshold)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if err := json.NewEncoder(w).Encode(tree); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}).Methods("GET")
+
+	// hits whose target header contains all words of q=, paged with page= (from 0) and limit=,
+	// database= and query=<identifier> restrict the search
+	r.HandleFunc("/result/search/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		if config.App == AppFoldSeek {
-			r.Handle("/ticket/foldmason", ratelimitWithAllowlistHandler(allowlistedCIDRs, lmt, ticketFoldMasonMSAHandlerFunc)).Methods("POST")
+		if status, err := jobsystem.Status(ticket.Id); err != nil || status != StatusComplete {
+			jobNotComplete(w, ticket)
+			return
 		}
-	} else {
-		if config.App == AppMMseqs2 || config.App == AppFoldSeek {
-			r.HandleFunc("/ticket", ticketHandlerFunc).Methods("POST")
+		query := req.URL.Query()
+		if strings.TrimSpace(query.Get("q")) == "" {
+			http.Error(w, "Missing search terms", http.StatusBadRequest)
+			return
 		}
-		if config.App == AppColabFold || config.App == AppPredictProtein {
-			r.HandleFunc("/ticket/msa", ticketMsaHandlerFunc).Methods("POST")
+		limit := defaultSearchHits
+		if value := query.Get("limit"); value != "" {
+			if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxSearchHits {
+				http.Error(w, "invalid limit "+value, http.StatusBadRequest)
+				return
+			}
 		}
-		if config.App == AppColabFold {
-			r.HandleFunc("/ticket/pair", ticketPairHandlerFunc).Methods("POST")
+		page := 0
+		if value := query.Get("page"); value != "" {
+			if page, err = strconv.Atoi(value); err != nil || page < 0 {
+				http.Error(w, "invalid page "+value, http.StatusBadRequest)
+				return
+			}
 		}
-		if config.App == AppFoldSeek {
-			r.HandleFunc("/ticket/foldmason", ticketFoldMasonMSAHandlerFunc).Methods("POST")
+		jobsbase, err := jobResults(req.Context(), ticket.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-	}
-
-	r.HandleFunc("/ticket/type/{ticket}", func(w http.ResponseWriter, req *http.Request) {
-		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
+		base := filepath.Join(jobsbase, string(ticket.Id))
+		request, err := getJobRequestFromFile(filepath.Join(base, "job.json"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		request, err := getJobRequestFromFile(filepath.Join(config.Paths.Results, string(ticket.Id), "job.json"))
+		databases, foldseek, err := resultDatabases(request)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		type TypeReponse struct {
-			JobType JobType `json:"type"`
+		if database := query.Get("database"); database != "" {
+			if isIn(database, databases) == -1 {
+				http.Error(w, "Database not found", http.StatusBadRequest)
+				return
+			}
+			databases = []string{database}
 		}
-
-		w.Header().Set("Cache-Control", "public, max-age=3600")
-		err = json.NewEncoder(w).Encode(TypeReponse{request.Type})
+		var queries []uint32
+		if name := query.Get("query"); name != "" {
+			if _, queries, err = QueryKeys(ticket.Id, jobsbase, name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		result, err := SearchHits(base, databases, foldseek, queries, query.Get("q"), page*limit, limit)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
 	}).Methods("GET")
 
-	r.HandleFunc("/ticket/{ticket}", func(w http.ResponseWriter, req *http.Request) {
-		ticket, err := jobsystem.GetTicket(Id(mux.Vars(req)["ticket"]))
+	// shared and unique hits of two completed jobs, query=<identifier> compares the hits of one query
+	r.HandleFunc("/result/compare/{ticket}/{other}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		requests := make([]JobRequest, 0, 2)
+		bases := make([]string, 0, 2)
+		for _, id := range []string{vars["ticket"], vars["other"]} {
+			ticket, err := jobsystem.GetTicket(Id(id))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if status, err := jobsystem.Status(ticket.Id); err != nil || status != StatusComplete {
+				http.Error(w, "Job "+id+" is not complete", http.StatusBadRequest)
+				return
+			}
+			jobsbase, err := jobResults(req.Context(), ticket.Id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			request, err := getJobRequestFromFile(filepath.Join(jobsbase, string(ticket.Id), "job.json"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			requests = append(requests, request)
+			bases = append(bases, filepath.Join(jobsbase, string(ticket.Id)))
+		}
+		comparison, err := CompareResults(bases[0], requests[0], bases[1], requests[1], req.URL.Query().Get("query"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		w.Header().Set("Cache-Control", "no-cache, no-store")
-		err = json.NewEncoder(w).Encode(ticket)
-		if err != nil {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if err := json.NewEncoder(w).Encode(comparison); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
 		}
 	}).Methods("GET")
 
-	r.HandleFunc("/tickets", func(w http.ResponseWriter, req *http.Request) {
-		err := req.ParseForm()
+	// pages of queries with all their hits as NDJSON, the cursor of the next page is in the X-Next-Cursor header
+	r.HandleFunc("/result/stream/{ticket}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		ticket, err := jobsystem.GetTicket(Id(vars["ticket"]))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		res, err := jobsystem.MultiStatus(req.Form["tickets[]"])
+		status, err := jobsystem.Status(ticket.Id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if status != StatusComplete {
+			jobNotComplete(w, ticket)
+			return
+		}
 
-		err = json.NewEncoder(w).Encode(res)
+		jobsbase, err := jobResults(req.Context(), ticket.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		base := filepath.Join(jobsbase, string(ticket.Id))
+		request, err := getJobRequestFromFile(filepath.Join(base, "job.json"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-	}).Methods("POST")
-
-	r.HandleFunc("/result/download/{ticket}", func(w http.ResponseWriter, req *http.Request) {
-		vars := mux.Vars(req)
-		ticket, err := jobsystem.GetTicket(Id(vars["ticket"]))
+		databases, _, err := resultDatabases(request)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		status, err := jobsystem.Status(ticket.Id)
-		if err != nil || status != StatusComplete {
+		if database := req.URL.Query().Get("database"); database != "" {
+			if isIn(database, databases) == -1 {
+				http.Error(w, "Database not found", http.StatusBadRequest)
+				return
+			}
+			databases = []string{database}
+		}
+		filter, filtered, err := ParseHitFilter(req.URL.Query())
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		// limit is the number of queries of a page here
+		filter.Limit = 0
 
-		name := "mmseqs_results_" + string(ticket.Id) + ".tar.gz"
-		path := filepath.Join(filepath.Clean(config.Paths.Results), string(ticket.Id), name)
-		if _, err := os.Stat(path); os.IsNotExist(err) {
+		stream, err := openResultStream(base, databases)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		file, err := os.Open(path)
+		defer stream.Close()
+		page, err := streamPage(req.URL.Query().Get("cursor"), req.URL.Query().Get("limit"), stream.Entries())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
 
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
-		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Type", "application/x-ndjson")
 		w.Header().Set("Cache-Control", "public, max-age=3600")
-		io.Copy(w, bufio.NewReader(file))
+		if page.Next != "" {
+			w.Header().Set("X-Next-Cursor", page.Next)
+		}
+		var hitFilter *HitFilter
+		if filtered {
+			hitFilter = &filter
+		}
+		if err := StreamResults(w, stream, request, page, hitFilter); err != nil {
+			serverLog.Error("Failed to stream results", "ticket", ticket.Id, "error", err)
+		}
 	}).Methods("GET")
 
 	r.HandleFunc("/result/foldmason/{ticket}", func(w http.ResponseWriter, req *http.Request) {
 		vars := mux.Vars(req)
 		ticket, err := jobsystem.GetTicket(Id(vars["ticket"]))
-		log.Println(ticket, "hello")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		path := filepath.Join(filepath.Clean(config.Paths.Results), string(ticket.Id), "foldmason.json")
-
-		file, err := os.Open(path)
+		jobsbase, err := jobResults(req.Context(), ticket.Id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		path := filepath.Join(jobsbase, string(ticket.Id), "foldmason.json")
+		if !resultFileExists(path) && !resultFileExists(path+zstdSuffix) {
+			http.Error(w, "File not found", http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
 
 		w.Header().Set("Cache-Control", "public, max-age=3600")
 		w.Header().Set("Content-Type", "application/json")
 
-		_, err = io.Copy(w, file)
-		if err != nil {
+		if err := serveResultFile(w, req, path); err != nil {
 			http.Error(w, "Failed to send file.", http.StatusInternalServerError)
 			return
 		}
@@ -713,9 +2240,9 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 		case "foldseek":
 			pdbPath := filepath.Join(config.Paths.Results, string(ticket.Id), "job.pdb")
 			cifPath := filepath.Join(config.Paths.Results, string(ticket.Id), "job.cif")
-			if fileExists(pdbPath) {
+			if resultFileExists(pdbPath) {
 				queryPath = pdbPath
-			} else if fileExists(cifPath) {
+			} else if resultFileExists(cifPath) {
 				queryPath = cifPath
 			} else {
 				http.Error(w, "File not found", http.StatusBadRequest)
@@ -724,7 +2251,7 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 		default:
 			queryPath = filepath.Join(config.Paths.Results, string(ticket.Id), "job.fasta")
 		}
-		query, err := os.ReadFile(queryPath)
+		query, err := readResultFile(queryPath)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -755,25 +2282,40 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 			return
 		}
 
-		if status != StatusComplete {
-			http.Error(w, "Job is not complete", http.StatusBadRequest)
+		// running search jobs return the results of the databases that are already searched
+		partial := status == StatusRunning
+		if status != StatusComplete && !partial {
+			jobNotComplete(w, ticket)
 			return
 		}
 
-		request, err := getJobRequestFromFile(filepath.Join(config.Paths.Results, string(ticket.Id), "job.json"))
+		jobsbase := filepath.Clean(config.Paths.Results)
+		if !partial {
+			if jobsbase, err = jobResults(req.Context(), ticket.Id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		request, err := getJobRequestFromFile(filepath.Join(jobsbase, string(ticket.Id), "job.json"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if _, ok := request.Job.(SearchJob); partial && !ok {
+			jobNotComplete(w, ticket)
+			return
+		}
 
 		database := req.URL.Query().Get("database")
 		var fasta []FastaEntry
 		var results []SearchResult
 		var mode string
+		var duplicates []string
 		isFoldseek := false
 		switch job := request.Job.(type) {
 		case SearchJob:
 			mode = job.Mode
+			duplicates = job.Duplicates[int(id)]
 			ids := []int64{id}
 			databases := job.Database
 			if database != "" {
@@ -783,12 +2325,31 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 				}
 				databases = []string{database}
 			}
-			results, err = Alignments(ticket.Id, ids, databases, config.Paths.Results)
+			query := filepath.Join(jobsbase, string(ticket.Id), "query")
+			if partial {
+				resultBase := filepath.Join(jobsbase, string(ticket.Id))
+				finished, err := finishedDatabases(resultBase, databases)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if len(finished) == 0 {
+					jobNotComplete(w, ticket)
+					return
+				}
+				query, err = partialQueryDb(resultBase)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				databases = finished
+			}
+			results, err = Alignments(ticket.Id, ids, databases, jobsbase)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			fasta, err = ReadQueryByIds(ticket.Id, ids, config.Paths.Results)
+			fasta, err = readQueryEntries(query, ids)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
@@ -805,19 +2366,19 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 				}
 				databases = []string{database}
 			}
-			results, err = FSAlignments(ticket.Id, ids, databases, config.Paths.Results)
+			results, err = FSAlignments(ticket.Id, ids, databases, jobsbase)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			fasta, err = ReadQueryByIds(ticket.Id, ids, config.Paths.Results)
+			fasta, err = ReadQueryByIds(ticket.Id, ids, jobsbase)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 		case ComplexSearchJob:
 			mode = job.Mode
-			result, err := Lookup(ticket.Id, 0, math.MaxInt32, config.Paths.Results, false)
+			result, err := Lookup(ticket.Id, 0, math.MaxInt32, jobsbase, false)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
@@ -837,12 +2398,12 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 				}
 				databases = []string{database}
 			}
-			results, err = ComplexAlignments(ticket.Id, keys, databases, config.Paths.Results)
+			results, err = ComplexAlignments(ticket.Id, keys, databases, jobsbase)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			fasta, err = ReadQueryByKeys(ticket.Id, keys, config.Paths.Results)
+			fasta, err = ReadQueryByKeys(ticket.Id, keys, jobsbase)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
@@ -852,6 +2413,38 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 			return
 		}
 
+		filter, filtered, err := ParseHitFilter(req.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filtered {
+			FilterResults(results, filter)
+		}
+		// lca=1 adds the lowest common ancestor of the hits of each query
+		AnnotateTaxonomy(results, req.URL.Query().Get("lca") == "1")
+
+		// partial results change until the job is complete
+		cacheControl := "public, max-age=3600"
+		if partial {
+			cacheControl = "no-cache"
+		}
+
+		if req.URL.Query().Get("aggregate") == "taxon" {
+			type TaxonomyResponse struct {
+				Queries  []FastaEntry   `json:"queries"`
+				Mode     string         `json:"mode"`
+				Taxonomy []TaxonSummary `json:"taxonomy"`
+				Partial  bool           `json:"partial,omitempty"`
+			}
+			w.Header().Set("Cache-Control", cacheControl)
+			err = json.NewEncoder(w).Encode(TaxonomyResponse{fasta, mode, AggregateTaxa(results), partial})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+
 		parIndex := req.URL.Query().Get("index")
 		format := req.URL.Query().Get("format")
 		if isFoldseek && format == "brief" {
@@ -932,19 +2525,134 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 			}
 		}
 
+		addHitLinks(config.Paths.Databases, results)
+		// merge=1 returns one hit list per query across all databases, database=<name> still selects the hits of one database
+		if req.URL.Query().Get("merge") == "1" {
+			type MergedResponse struct {
+				Queries    []FastaEntry  `json:"queries"`
+				Mode       string        `json:"mode"`
+				Databases  []string      `json:"databases"`
+				Results    []MergedQuery `json:"results"`
+				Duplicates []string      `json:"duplicates,omitempty"`
+				Partial    bool          `json:"partial,omitempty"`
+			}
+			databases := make([]string, 0, len(results))
+			for _, res := range results {
+				databases = append(databases, res.Database)
+			}
+			w.Header().Set("Cache-Control", cacheControl)
+			err = json.NewEncoder(w).Encode(MergedResponse{fasta, mode, databases, MergeResults(results), duplicates, partial})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
 		type AlignmentModeResponse struct {
 			Queries []FastaEntry   `json:"queries"`
 			Mode    string         `json:"mode"`
 			Results []SearchResult `json:"results"`
+			// headers of identical queries that share these results
+			Duplicates []string `json:"duplicates,omitempty"`
+			// only the databases that are already searched of a running job
+			Partial bool `json:"partial,omitempty"`
 		}
-		w.Header().Set("Cache-Control", "public, max-age=3600")
-		err = json.NewEncoder(w).Encode(AlignmentModeResponse{fasta, mode, results})
+		w.Header().Set("Cache-Control", cacheControl)
+		err = json.NewEncoder(w).Encode(AlignmentModeResponse{fasta, mode, results, duplicates, partial})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	})
 	r.Handle("/result/{ticket}/{entry}", compressHandler(resultHandler)).Methods("GET")
+	// same as the result of an entry, but addressed by the identifier of the query in the submitted FASTA
+	r.Handle("/result/{ticket}/query/{query}", compressHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		ticket, err := jobsystem.GetTicket(Id(vars["ticket"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jobsbase, err := jobResults(req.Context(), ticket.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entry, _, err := QueryKeys(ticket.Id, jobsbase, vars["query"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		resultHandler.ServeHTTP(w, mux.SetURLVars(req, map[string]string{"ticket": vars["ticket"], "entry": strconv.FormatUint(uint64(entry), 10)}))
+	}))).Methods("GET")
+
+	// full length sequences of hits as FASTA, target=<id> can be repeated or hold a comma separated list
+	r.HandleFunc("/result/{ticket}/target/{database}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		ticket, err := jobsystem.GetTicket(Id(vars["ticket"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request, err := getJobRequestFromFile(filepath.Join(config.Paths.Results, string(ticket.Id), "job.json"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		databases, _, err := resultDatabases(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if isIn(vars["database"], databases) == -1 || !sessionAccessible(config.Paths.Databases, vars["database"], req.Header.Get(sessionTokenHeader)) {
+			http.Error(w, "Database not found", http.StatusBadRequest)
+			return
+		}
+		if status, err := jobsystem.Status(ticket.Id); err != nil || status != StatusComplete {
+			jobNotComplete(w, ticket)
+			return
+		}
+		targets := make([]string, 0)
+		for _, value := range req.URL.Query()["target"] {
+			for _, target := range strings.Split(value, ",") {
+				if target = strings.TrimSpace(target); target != "" {
+					targets = append(targets, target)
+				}
+			}
+		}
+		if len(targets) == 0 {
+			http.Error(w, "No target given", http.StatusBadRequest)
+			return
+		}
+		if len(targets) > 1000 {
+			http.Error(w, "Too many targets", http.StatusBadRequest)
+			return
+		}
+		// only the targets the job found can be read
+		jobsbase, err := jobResults(req.Context(), ticket.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hits, err := alignedTargets(filepath.Join(jobsbase, string(ticket.Id)), vars["database"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, target := range targets {
+			if !hits[targetName(target)] {
+				http.Error(w, "target "+target+" not found", http.StatusNotFound)
+				return
+			}
+		}
+		fasta, err := ReadTargets(filepath.Join(config.Paths.Databases, vars["database"]), targets)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		writeFastaEntries(w, fasta)
+	}).Methods("GET")
 
 	r.HandleFunc("/result/queries/{ticket}/{limit}/{page}", func(w http.ResponseWriter, req *http.Request) {
 		vars := mux.Vars(req)
@@ -966,8 +2674,13 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 			return
 		}
 
+		jobsbase, err := jobResults(req.Context(), ticket.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("Cache-Control", "public, max-age=3600")
-		result, err := Lookup(ticket.Id, page, limit, config.Paths.Results, true)
+		result, err := Lookup(ticket.Id, page, limit, jobsbase, true)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -984,7 +2697,7 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 		a3mbase := config.Paths.ColabFold.Pdb70 + "_a3m"
 		err := a3mreader.Make(a3mbase+".ffdata", a3mbase+".ffindex")
 		if err != nil {
-			log.Fatal(err)
+			logFatal(serverLog, "Failed to open the pdb70 database", err)
 		}
 
 		var hhmreader *Reader[string] = nil
@@ -993,7 +2706,7 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 			hhmreader = &Reader[string]{}
 			err = hhmreader.Make(hhmbase+".ffdata", hhmbase+".ffindex")
 			if err != nil {
-				log.Fatal(err)
+				logFatal(serverLog, "Failed to open the pdb70 database", err)
 			}
 		}
 
@@ -1008,6 +2721,99 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 		}).Methods("GET")
 	}
 
+	r.HandleFunc("/about", func(w http.ResponseWriter, req *http.Request) {
+		type AboutResponse struct {
+			App      ConfigApp    `json:"app"`
+			Versions ToolVersions `json:"versions"`
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		err := json.NewEncoder(w).Encode(AboutResponse{config.App, config.Versions})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}).Methods("GET")
+
+	if config.App == AppMMseqs2 {
+		r.HandleFunc("/pipelines", func(w http.ResponseWriter, req *http.Request) {
+			type PipelineResponse struct {
+				Mode        string `json:"mode"`
+				Description string `json:"description"`
+			}
+			pipelines := make([]PipelineResponse, 0, len(config.Pipelines))
+			for name, pipeline := range config.Pipelines {
+				pipelines = append(pipelines, PipelineResponse{name, pipeline.Description})
+			}
+			sort.Slice(pipelines, func(i, j int) bool { return pipelines[i].Mode < pipelines[j].Mode })
+			err := json.NewEncoder(w).Encode(pipelines)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}).Methods("GET")
+	}
+
+	// page cache residency of the warmed up databases on this host, meaningful if workers run on the same host
+	r.HandleFunc("/warmup", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		err := json.NewEncoder(w).Encode(residency.Statuses())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}).Methods("GET")
+
+	// for load balancers, unavailable until the hot databases are in the page cache of this host
+	r.HandleFunc("/ready", func(w http.ResponseWriter, req *http.Request) {
+		type ReadyResponse struct {
+			Ready bool          `json:"ready"`
+			Cold  []CacheStatus `json:"cold"`
+		}
+		cold := coldFiles(config, residency.Statuses())
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		if len(cold) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		err := json.NewEncoder(w).Encode(ReadyResponse{len(cold) == 0, cold})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeDiskUsageMetrics(w, usage.Usage())
+		if snapshot, err := stats.Snapshot(); err == nil {
+			writeDatabaseStatsMetrics(w, snapshot)
+		} else {
+			databaseLog.Error("Failed to read database statistics", "error", err)
+		}
+		if cleaner != nil {
+			writeResultCleanerMetrics(w, cleaner.Removed())
+		}
+	}).Methods("GET")
+
+	// problems of databases found at startup and by the database watcher
+	r.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		type HealthResponse struct {
+			Status    string           `json:"status"`
+			Databases []DatabaseHealth `json:"databases"`
+		}
+		databases := watcher.Health()
+		status := "ok"
+		if len(databases) > 0 {
+			status = "degraded"
+		}
+
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		err := json.NewEncoder(w).Encode(HealthResponse{status, databases})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}).Methods("GET")
+
 	r.HandleFunc("/queue", func(w http.ResponseWriter, req *http.Request) {
 		type QueueResponse struct {
 			Length int `json:"queued"`
@@ -1030,9 +2836,7 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 	if config.Server.Auth != nil {
 		h = httpauth.SimpleBasicAuth(config.Server.Auth.Username, config.Server.Auth.Password)(h)
 	}
-	if config.Verbose {
-		h = handlers.LoggingHandler(os.Stdout, h)
-	}
+	h = logRequests(h)
 	if config.Server.CORS {
 		c := cors.AllowAll()
 		h = c.Handler(h)
@@ -1044,6 +2848,6 @@ func server(jobsystem JobSystem, config ConfigRoot) {
 		Addr:    config.Server.Address,
 	}
 
-	log.Println("MMseqs2 Webserver")
-	log.Fatal(srv.ListenAndServe())
+	serverLog.Info("MMseqs2 Webserver", "address", config.Server.Address)
+	logFatal(serverLog, "Server stopped", srv.ListenAndServe())
 }
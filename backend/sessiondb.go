@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/didip/tollbooth/v6/limiter"
+)
+
+// clients send the token they got with their first session database to use and add more of them
+const sessionTokenHeader = "X-Session-Token"
+
+const (
+	defaultSessionTTL     = 24 * time.Hour
+	defaultSessionMaxSize = 50 * 1024 * 1024
+)
+
+func sessionTTL(config ConfigSessionDatabases) (time.Duration, error) {
+	if config.TTL == "" {
+		return defaultSessionTTL, nil
+	}
+	return time.ParseDuration(config.TTL)
+}
+
+// newSessionLimiter counts the sessions a client starts, so that uploads without a token can not bypass maxdatabases.
+// A client starts up to maxdatabases sessions per ttl, nil without maxdatabases.
+func newSessionLimiter(config ConfigRoot) (*limiter.Limiter, error) {
+	limits := config.Server.SessionDatabases
+	if limits == nil || limits.MaxDatabases <= 0 {
+		return nil, nil
+	}
+	ttl, err := sessionTTL(*limits)
+	if err != nil {
+		return nil, err
+	}
+	lmt := limiter.New(&limiter.ExpirableOptions{DefaultExpirationTTL: ttl}).
+		SetMax(float64(limits.MaxDatabases) / ttl.Seconds()).
+		SetBurst(limits.MaxDatabases)
+	if config.Server.RateLimit != nil && config.Server.RateLimit.IpLookupHeader != "" {
+		lmt.SetIPLookups([]string{config.Server.RateLimit.IpLookupHeader})
+	}
+	return lmt, nil
+}
+
+// visibleDatabases leaves out the session databases of other sessions
+func visibleDatabases(databases []Params, token string) []Params {
+	hash := ""
+	if token != "" {
+		hash = sessionHash(token)
+	}
+	res := make([]Params, 0, len(databases))
+	for _, params := range databases {
+		if params.Session == "" || params.Session == hash {
+			res = append(res, params)
+		}
+	}
+	return res
+}
+
+func newSessionToken() (string, error) {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// sessionHash is stored in the params, so the params do not reveal the token
+func sessionHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionExpired(params Params, now time.Time) bool {
+	if params.Session == "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, params.Expires)
+	return err != nil || !now.Before(expires)
+}
+
+// sessionDatabases returns the databases of a session, complete limits them to the ones that can be searched
+func sessionDatabases(basepath string, token string, complete bool) ([]Params, error) {
+	if token == "" {
+		return nil, nil
+	}
+	databases, err := Databases(basepath, false)
+	if err != nil {
+		return nil, err
+	}
+	hash := sessionHash(token)
+	now := time.Now()
+	res := make([]Params, 0)
+	for _, params := range databases {
+		if params.Session != hash || params.Remove || sessionExpired(params, now) {
+			continue
+		}
+		if complete && params.Status != StatusComplete {
+			continue
+		}
+		res = append(res, params)
+	}
+	return res, nil
+}
+
+// sessionAccessible is false for the databases of other sessions, databases outside of sessions are always accessible
+func sessionAccessible(basepath string, database string, token string) bool {
+	if !strings.HasPrefix(filepath.Base(database), "session_") {
+		return true
+	}
+	params, err := ReadParams(filepath.Join(basepath, filepath.Base(database)+".params"))
+	return err == nil && token != "" && params.Session == sessionHash(token)
+}
+
+// SaveSessionDatabase stores an uploaded FASTA file as a database of the session, the caller queues its index job
+func SaveSessionDatabase(config ConfigRoot, token string, name string, r io.Reader) (Params, error) {
+	limits := config.Server.SessionDatabases
+	ttl, err := sessionTTL(*limits)
+	if err != nil {
+		return Params{}, err
+	}
+	var maxSize uint64 = defaultSessionMaxSize
+	if limits.MaxSize != "" {
+		var err error
+		if maxSize, err = parseByteSize(limits.MaxSize); err != nil {
+			return Params{}, err
+		}
+	}
+
+	existing, err := sessionDatabases(config.Paths.Databases, token, false)
+	if err != nil {
+		return Params{}, err
+	}
+	if limits.MaxDatabases > 0 && len(existing) >= limits.MaxDatabases {
+		return Params{}, errors.New("session already has the maximum number of databases")
+	}
+
+	hash := sessionHash(token)
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return Params{}, err
+	}
+	path := "session_" + hash[:12] + "_" + hex.EncodeToString(suffix)
+	if name == "" {
+		name = path
+	}
+
+	// one byte more than allowed is read to detect files that are too large
+	input := filepath.Join(config.Paths.Databases, path+".fasta")
+	if err := writeDatabaseSource(input, io.LimitReader(r, int64(maxSize)+1), false); err != nil {
+		return Params{}, err
+	}
+	info, err := os.Stat(input)
+	if err != nil {
+		return Params{}, err
+	}
+	if uint64(info.Size()) > maxSize {
+		os.Remove(input)
+		return Params{}, errors.New("database is larger than " + formatByteSize(maxSize))
+	}
+	if info.Size() == 0 || !strings.HasPrefix(strings.TrimSpace(firstLine(input)), ">") {
+		os.Remove(input)
+		return Params{}, errors.New("database has to be a FASTA file")
+	}
+
+	params := Params{
+		ID:      newDatabaseId(),
+		Name:    name,
+		Path:    path,
+		Format:  "fasta",
+		Session: hash,
+		Expires: time.Now().Add(ttl).UTC().Format(time.RFC3339),
+		Status:  StatusPending,
+	}
+	if err := SaveParams(filepath.Join(config.Paths.Databases, path+".params"), params); err != nil {
+		os.Remove(input)
+		return Params{}, err
+	}
+	return params, nil
+}
+
+func firstLine(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	buf := make([]byte, 1024)
+	n, _ := f.Read(buf)
+	line, _, _ := strings.Cut(string(buf[:n]), "\n")
+	return line
+}
+
+// DeleteSessionDatabase removes a database of a session before it expires
+func DeleteSessionDatabase(config ConfigRoot, token string, path string) (bool, error) {
+	params, err := ReadParams(filepath.Join(config.Paths.Databases, filepath.Base(path)+".params"))
+	if err != nil || token == "" || params.Session != sessionHash(token) {
+		return false, errors.New("database " + path + " not found")
+	}
+	return DeleteDatabase(config, params.Path)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didip/tollbooth/v6"
+)
+
+func TestSessionDatabases(t *testing.T) {
+	var config ConfigRoot
+	config.Paths.Databases = t.TempDir()
+	config.Server.SessionDatabases = &ConfigSessionDatabases{"1h", "64B", 2}
+
+	if _, err := SaveSessionDatabase(config, "a", "", strings.NewReader("ACGT\n")); err == nil {
+		t.Error("expected error for input that is not FASTA")
+	}
+	if _, err := SaveSessionDatabase(config, "a", "", strings.NewReader(">large\n"+strings.Repeat("A", 64)+"\n")); err == nil {
+		t.Error("expected error for input larger than the maximum size")
+	}
+
+	params, err := SaveSessionDatabase(config, "a", "proteome", strings.NewReader(">p1\nMKV\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Session != sessionHash("a") || !fileExists(filepath.Join(config.Paths.Databases, params.Path+".fasta")) {
+		t.Errorf("unexpected session database %+v", params)
+	}
+	if _, err := SaveSessionDatabase(config, "a", "second", strings.NewReader(">p2\nMKV\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveSessionDatabase(config, "a", "third", strings.NewReader(">p3\nMKV\n")); err == nil {
+		t.Error("expected error for too many databases")
+	}
+
+	// only searchable once built and never visible to other sessions or in the database list
+	params.Status = StatusComplete
+	if err := SaveParams(filepath.Join(config.Paths.Databases, params.Path+".params"), params); err != nil {
+		t.Fatal(err)
+	}
+	if dbs, _ := sessionDatabases(config.Paths.Databases, "a", true); len(dbs) != 1 || dbs[0].Path != params.Path {
+		t.Errorf("expected the built database for session a, got %+v", dbs)
+	}
+	if dbs, _ := sessionDatabases(config.Paths.Databases, "b", false); len(dbs) != 0 {
+		t.Errorf("expected no databases for session b, got %+v", dbs)
+	}
+	if dbs, _ := Databases(config.Paths.Databases, true); len(dbs) != 0 {
+		t.Errorf("expected session databases to be hidden, got %+v", dbs)
+	}
+
+	if sessionExpired(params, time.Now()) || !sessionExpired(params, time.Now().Add(2*time.Hour)) {
+		t.Errorf("unexpected expiry of %s", params.Expires)
+	}
+	if sessionExpired(Params{}, time.Now()) {
+		t.Error("databases without session never expire")
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	databases := []Params{{Path: "public"}, {Path: "mine", Session: sessionHash("token")}, {Path: "other", Session: sessionHash("other")}}
+	visible := visibleDatabases(databases, "token")
+	if len(visible) != 2 || visible[1].Path != "mine" {
+		t.Errorf("unexpected visible databases %v", visible)
+	}
+	if visible := visibleDatabases(databases, ""); len(visible) != 1 {
+		t.Errorf("session databases are hidden without a token %v", visible)
+	}
+
+	var config ConfigRoot
+	config.Server.SessionDatabases = &ConfigSessionDatabases{TTL: "1h", MaxDatabases: 2}
+	lmt, err := newSessionLimiter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("POST", "/session/database", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		limited := tollbooth.LimitByRequest(lmt, httptest.NewRecorder(), req) != nil
+		if limited != (i == 2) {
+			t.Errorf("session %d: expected limited=%t", i, i == 2)
+		}
+	}
+}
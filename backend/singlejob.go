@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Jobs that are submitted to a cluster scheduler run this binary with -job <id>,
+// the outcome is written to job.exit in the result directory for the worker to pick up
+const jobOutcomeFile = "job.exit"
+
+func writeJobOutcome(path string, err error) error {
+	var oomErr *JobOutOfMemoryError
+	var timeoutErr *JobTimeoutError
+	outcome := "ok"
+	switch {
+	case err == nil:
+	case errors.As(err, &oomErr):
+		outcome = "oom"
+	case errors.As(err, &timeoutErr):
+		outcome = "timeout"
+	default:
+		outcome = "error " + err.Error()
+	}
+
+	// write atomically, the worker might be polling for the file
+	if err := os.WriteFile(path+".tmp", []byte(outcome+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readJobOutcome returns if the job has finished and the error it finished with
+func readJobOutcome(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	outcome := strings.TrimSpace(string(data))
+	switch {
+	case outcome == "ok":
+		return true, nil
+	case outcome == "oom":
+		return true, &JobOutOfMemoryError{}
+	case outcome == "timeout":
+		return true, &JobTimeoutError{}
+	default:
+		return true, &JobExecutionError{errors.New(strings.TrimPrefix(outcome, "error "))}
+	}
+}
+
+func runSingleJob(config ConfigRoot, id Id) error {
+	base := filepath.Join(config.Paths.Results, string(id))
+	job, err := getJobRequestFromFile(filepath.Join(base, "job.json"))
+	if err != nil {
+		return err
+	}
+
+	timeout, err := jobTimeout(job, config)
+	if err != nil {
+		return err
+	}
+
+	jobLog, err := os.OpenFile(filepath.Join(base, "job.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer jobLog.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// the scheduler takes care of resource limits and GPU assignment
+	executor := LocalExecutor{config, "", jobPriority(config, job), jobLog}
+	err = RunJob(ctx, job, config, executor)
+	if err != nil {
+		workerLog.Error(err.Error())
+	}
+	return writeJobOutcome(filepath.Join(base, jobOutcomeFile), err)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// SlotPool hands out the job slots of a worker, a job of slot cost n waits until n slots are free
+type SlotPool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	size int
+	used int
+}
+
+func NewSlotPool(size int) *SlotPool {
+	p := &SlotPool{size: max(size, 1)}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *SlotPool) Size() int {
+	return p.size
+}
+
+// WaitFree blocks until at least one slot is free
+func (p *SlotPool) WaitFree() {
+	p.mu.Lock()
+	for p.used >= p.size {
+		p.cond.Wait()
+	}
+	p.mu.Unlock()
+}
+
+func (p *SlotPool) Acquire(n int) {
+	p.mu.Lock()
+	for p.used+n > p.size {
+		p.cond.Wait()
+	}
+	p.used += n
+	p.mu.Unlock()
+}
+
+func (p *SlotPool) Release(n int) {
+	p.mu.Lock()
+	p.used -= n
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// jobSlots returns the slot cost of a job, every worker.slotrank of the job rank takes one slot.
+// A job never takes more than all slots, so a big job runs alone instead of waiting forever.
+func jobSlots(config ConfigRoot, request JobRequest) int {
+	slots := max(config.Worker.Slots, 1)
+	job, ok := request.Job.(Job)
+	if !ok || config.Worker.SlotRank <= 0 {
+		return 1
+	}
+	cost := int(math.Ceil(job.Rank() / config.Worker.SlotRank))
+	if cost > slots {
+		return slots
+	}
+	return max(cost, 1)
+}
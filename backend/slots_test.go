@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestJobSlots(t *testing.T) {
+	var config ConfigRoot
+	config.Worker.Slots = 4
+	config.Worker.SlotRank = 10
+
+	tests := []struct {
+		size      int
+		databases int
+		expected  int
+	}{
+		{1, 1, 1},
+		{10, 1, 1},
+		{11, 1, 2},
+		{5, 4, 2},
+		{1000, 2, 4},
+	}
+	for _, test := range tests {
+		request := JobRequest{Id: "id", Status: StatusPending, Type: JobSearch, Job: SearchJob{Size: test.size, Database: make([]string, test.databases)}}
+		if got := jobSlots(config, request); got != test.expected {
+			t.Errorf("jobSlots(size=%d, databases=%d) = %d, expected %d", test.size, test.databases, got, test.expected)
+		}
+	}
+
+	config.Worker.SlotRank = 0
+	request := JobRequest{Id: "id", Status: StatusPending, Type: JobSearch, Job: SearchJob{Size: 1000}}
+	if got := jobSlots(config, request); got != 1 {
+		t.Errorf("jobSlots without slotrank = %d, expected 1", got)
+	}
+}
@@ -14,7 +14,10 @@ type StructureSearchJob struct {
 	Database  []string `json:"database" validate:"required"`
 	Mode      string   `json:"mode" validate:"oneof=3di tmalign 3diaa"`
 	TaxFilter string   `json:"taxfilter"`
-	query     string
+	Params    []string `json:"params,omitempty"`
+	// foldseek easy-search flags from the defaults of each database, e.g. -e or --max-seqs, that Params do not set
+	DatabaseParams map[string][]string `json:"databaseparams,omitempty"`
+	query          string
 }
 
 func (r StructureSearchJob) Hash() Id {
@@ -25,6 +28,10 @@ func (r StructureSearchJob) Hash() Id {
 	if r.TaxFilter != "" {
 		h.Write([]byte(r.TaxFilter))
 	}
+	for _, value := range r.Params {
+		h.Write([]byte(value))
+	}
+	hashDatabaseParams(h, r.DatabaseParams)
 
 	sort.Strings(r.Database)
 
@@ -48,21 +55,33 @@ func (r StructureSearchJob) WritePDB(path string) error {
 	return nil
 }
 
-func NewStructureSearchJobRequest(query string, dbs []string, validDbs []Params, mode string, resultPath string, email string, taxfilter string) (JobRequest, error) {
+func NewStructureSearchJobRequest(query string, dbs []string, validDbs []Params, mode string, resultPath string, email string, taxfilter string, parameters string) (JobRequest, error) {
+	extra, err := ParseExtraParameters(JobStructureSearch, parameters)
+	var defaults map[string][]string
+	if err == nil {
+		defaults, err = databaseSearchDefaults(JobStructureSearch, dbs, validDbs, extra)
+	}
+	mode = defaultSearchMode(mode, dbs, validDbs)
 	job := StructureSearchJob{
 		max(strings.Count(query, "HEADER"), 1),
 		dbs,
 		mode,
 		taxfilter,
+		extra,
+		defaults,
 		query,
 	}
 
 	request := JobRequest{
-		job.Hash(),
-		StatusPending,
-		JobStructureSearch,
-		job,
-		email,
+		Id:     job.Hash(),
+		Status: StatusPending,
+		Type:   JobStructureSearch,
+		Job:    job,
+		Email:  email,
+	}
+
+	if err != nil {
+		return request, err
 	}
 
 	ids := make([]string, len(validDbs))
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// targetName is the identifier of a target in the .lookup file, results of databases with full headers have the whole header as target
+func targetName(target string) string {
+	if fields := strings.Fields(target); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// targetLookup reads the keys of all targets from the .lookup file of a database
+func targetLookup(database string) (map[string]uint32, error) {
+	file, err := os.Open(database + ".lookup")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[string]uint32)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(rest, "\t")
+		if _, found := keys[name]; found {
+			continue
+		}
+		value, err := strconv.ParseUint(key, 10, 32)
+		if err != nil {
+			return nil, errors.New("invalid lookup key " + key)
+		}
+		keys[name] = uint32(value)
+	}
+	return keys, scanner.Err()
+}
+
+// targetDatabase holds the open readers of a database whose targets are served
+type targetDatabase struct {
+	modified time.Time
+	used     time.Time
+	keys     map[string]uint32
+	seq      *Reader[uint32]
+	hdr      *Reader[uint32]
+}
+
+// targetDatabaseCache keeps the last used databases open, so a request does not read their index and lookup again.
+// A database is opened again once its index changes, the files of dropped readers are closed by the garbage collector
+// since requests might still read from them.
+type targetDatabaseCache struct {
+	mu        sync.Mutex
+	max       int
+	databases map[string]*targetDatabase
+}
+
+var targetDatabases = &targetDatabaseCache{max: 4, databases: make(map[string]*targetDatabase)}
+
+func (c *targetDatabaseCache) open(database string) (*targetDatabase, error) {
+	info, err := os.Stat(database + ".index")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.databases[database]; ok && cached.modified.Equal(info.ModTime()) {
+		cached.used = time.Now()
+		return cached, nil
+	}
+
+	keys, err := targetLookup(database)
+	if err != nil {
+		return nil, err
+	}
+	seq := &Reader[uint32]{}
+	if err := seq.Make(dbpaths(database)); err != nil {
+		return nil, err
+	}
+	hdr := &Reader[uint32]{}
+	if err := hdr.Make(dbpaths(database + "_h")); err != nil {
+		seq.Delete()
+		return nil, err
+	}
+	opened := &targetDatabase{info.ModTime(), time.Now(), keys, seq, hdr}
+	delete(c.databases, database)
+	for len(c.databases) >= c.max {
+		oldest := ""
+		for path, cached := range c.databases {
+			if oldest == "" || cached.used.Before(c.databases[oldest].used) {
+				oldest = path
+			}
+		}
+		delete(c.databases, oldest)
+	}
+	c.databases[database] = opened
+	return opened, nil
+}
+
+// ReadTargets returns the full length sequences and headers of targets of a database in the given order
+func ReadTargets(database string, targets []string) ([]FastaEntry, error) {
+	opened, err := targetDatabases.open(database)
+	if err != nil {
+		return nil, err
+	}
+	fasta := make([]FastaEntry, 0, len(targets))
+	for _, target := range targets {
+		key, ok := opened.keys[targetName(target)]
+		if !ok {
+			return nil, errors.New("target " + target + " not found")
+		}
+		id, found := opened.seq.Id(key)
+		if !found {
+			return nil, errors.New("target " + target + " not found")
+		}
+		header := ""
+		if hid, found := opened.hdr.Id(key); found {
+			if header, err = opened.hdr.Data(hid); err != nil {
+				return nil, err
+			}
+		}
+		sequence, err := opened.seq.Data(id)
+		if err != nil {
+			return nil, err
+		}
+		fasta = append(fasta, FastaEntry{strings.TrimSpace(header), strings.TrimSpace(sequence)})
+	}
+	return fasta, nil
+}
+
+// alignedTargets are the names of the targets aligned in the results of a job against a database
+func alignedTargets(base string, database string) (map[string]bool, error) {
+	reader := Reader[uint32]{}
+	if err := reader.Make(dbpaths(filepath.Join(base, "alis_"+database))); err != nil {
+		return nil, err
+	}
+	defer reader.Delete()
+	names := make(map[string]bool)
+	for id := int64(0); id < reader.Size(); id++ {
+		err := reader.ScanLines(id, func(line string) error {
+			if fields := strings.SplitN(line, "\t", 3); len(fields) > 1 {
+				names[targetName(fields[1])] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+func writeFastaEntries(w io.Writer, entries []FastaEntry) error {
+	out := bufio.NewWriter(w)
+	for _, entry := range entries {
+		out.WriteString(">" + entry.Header + "\n" + entry.Sequence + "\n")
+	}
+	return out.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadTargets(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"db":         "MKV\n\x00AAAL\n\x00",
+		"db.index":   "0\t0\t5\n1\t5\t6\n",
+		"db_h":       "P1 first protein\n\x00P2 second protein\n\x00",
+		"db_h.index": "0\t0\t18\n1\t18\t19\n",
+		"db.lookup":  "0\tP1\t0\n1\tP2\t0\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// full header targets are looked up by their first word
+	fasta, err := ReadTargets(filepath.Join(dir, "db"), []string{"P2 second protein", "P1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FastaEntry{{"P2 second protein", "AAAL"}, {"P1 first protein", "MKV"}}
+	if !reflect.DeepEqual(fasta, expected) {
+		t.Errorf("expected %v, got %v", expected, fasta)
+	}
+	if _, err := ReadTargets(filepath.Join(dir, "db"), []string{"P3"}); err == nil {
+		t.Error("unknown targets should not be found")
+	}
+
+	// a rebuilt database is opened again
+	if err := os.WriteFile(filepath.Join(dir, "db"), []byte("MKW\n\x00AAAL\n\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "db.index"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if fasta, err := ReadTargets(filepath.Join(dir, "db"), []string{"P1"}); err != nil || fasta[0].Sequence != "MKW" {
+		t.Errorf("unexpected targets %v %v", fasta, err)
+	}
+}
+
+func TestAlignedTargets(t *testing.T) {
+	dir := t.TempDir()
+	alis := "q1\tP1 first protein\t0.9\n\x00q2\tP2\t0.8\nq2\tP4\t0.5\n\x00"
+	if err := os.WriteFile(filepath.Join(dir, "alis_db"), []byte(alis), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "alis_db.index"), []byte("0\t0\t25\n1\t25\t21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := alignedTargets(dir, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, map[string]bool{"P1": true, "P2": true, "P4": true}) {
+		t.Errorf("unexpected targets %v", targets)
+	}
+}
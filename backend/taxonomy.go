@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// taxon filters use the --taxon-list syntax, e.g. 2,!9606 or !12908&&!28384
+var validTaxonFilter = regexp.MustCompile(`^!?[0-9]+((,|&&|\|\|)!?[0-9]+)*$|^$`).MatchString
+var taxonFilterIds = regexp.MustCompile(`[0-9]+`)
+
+type taxonomyNodes struct {
+	modTime time.Time
+	ids     map[int]struct{}
+}
+
+var taxonomyCache = struct {
+	sync.Mutex
+	nodes map[string]taxonomyNodes
+}{nodes: make(map[string]taxonomyNodes)}
+
+// readTaxonomyNodes reads the taxonomy ids of a NCBI style nodes.dmp, results are cached until the file changes
+func readTaxonomyNodes(path string) (map[int]struct{}, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	taxonomyCache.Lock()
+	defer taxonomyCache.Unlock()
+	if cached, ok := taxonomyCache.nodes[path]; ok && cached.modTime.Equal(stat.ModTime()) {
+		return cached.ids, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ids := make(map[int]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		end := strings.IndexByte(line, '\t')
+		if end == -1 {
+			end = len(line)
+		}
+		id, err := strconv.Atoi(line[:end])
+		if err != nil {
+			continue
+		}
+		ids[id] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	taxonomyCache.nodes[path] = taxonomyNodes{stat.ModTime(), ids}
+	return ids, nil
+}
+
+// CheckTaxonFilter makes sure that at least one of the selected databases has a taxonomy mapping
+// and that all taxonomy ids of the filter are known to these databases
+func CheckTaxonFilter(filter string, dbs []string, validDbs []Params, basepath string) error {
+	if filter == "" {
+		return nil
+	}
+
+	if !validTaxonFilter(filter) {
+		return errors.New("invalid taxon filter")
+	}
+
+	ids := taxonFilterIds.FindAllString(filter, -1)
+	found := false
+	for _, params := range validDbs {
+		if !params.Taxonomy || isIn(params.Path, dbs) == -1 {
+			continue
+		}
+		found = true
+		db := params.Path
+		path := filepath.Join(basepath, db)
+
+		// databases with only a binary _taxonomy file cannot be checked here
+		if !fileExists(path + "_nodes.dmp") {
+			continue
+		}
+		nodes, err := readTaxonomyNodes(path + "_nodes.dmp")
+		if err != nil {
+			return err
+		}
+		var merged map[int]struct{}
+		if fileExists(path + "_merged.dmp") {
+			merged, err = readTaxonomyNodes(path + "_merged.dmp")
+			if err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			taxid, err := strconv.Atoi(id)
+			if err != nil {
+				return errors.New("invalid taxon filter")
+			}
+			_, inNodes := nodes[taxid]
+			_, inMerged := merged[taxid]
+			if !inNodes && !inMerged {
+				return errors.New("unknown taxonomy id " + id + " in database " + db)
+			}
+		}
+	}
+
+	if !found {
+		return errors.New("none of the selected databases supports taxonomy filters")
+	}
+
+	return nil
+}
+
+type TaxonCount struct {
+	TaxonId      json.Number `json:"taxId"`
+	TaxonName    string      `json:"taxName"`
+	TaxonLineage string      `json:"taxLineage,omitempty"`
+	Hits         int         `json:"hits"`
+	// lowest e-value of all hits of the taxon
+	Eval float64 `json:"eval"`
+}
+
+type TaxonSummary struct {
+	Database string       `json:"db"`
+	Taxa     []TaxonCount `json:"taxa"`
+}
+
+type taxonHit struct {
+	id      json.Number
+	name    string
+	lineage string
+	eval    float64
+}
+
+func taxonHits(alignments interface{}) []taxonHit {
+	hits := make([]taxonHit, 0)
+	switch conv := alignments.(type) {
+	case [][]AlignmentEntry:
+		for _, inner := range conv {
+			for _, entry := range inner {
+				hits = append(hits, taxonHit{entry.TaxonId, entry.TaxonName, entry.TaxonLineage, entry.Eval})
+			}
+		}
+	case [][]FoldseekAlignmentEntry:
+		for _, inner := range conv {
+			for _, entry := range inner {
+				hits = append(hits, taxonHit{entry.TaxonId, entry.TaxonName, entry.TaxonLineage, entry.Eval})
+			}
+		}
+	case [][]ComplexAlignmentEntry:
+		for _, inner := range conv {
+			for _, entry := range inner {
+				hits = append(hits, taxonHit{entry.TaxonId, entry.TaxonName, entry.TaxonLineage, entry.Eval})
+			}
+		}
+	}
+	return hits
+}
+
+// AggregateTaxa groups the hits of each database by taxon, the taxa with the most hits come first.
+// Databases without taxonomy are left out.
+func AggregateTaxa(results []SearchResult) []TaxonSummary {
+	summaries := make([]TaxonSummary, 0)
+	for _, result := range results {
+		counts := make(map[json.Number]*TaxonCount)
+		for _, hit := range taxonHits(result.Alignments) {
+			if hit.id == "" {
+				continue
+			}
+			count, ok := counts[hit.id]
+			if !ok {
+				count = &TaxonCount{hit.id, hit.name, hit.lineage, 0, hit.eval}
+				counts[hit.id] = count
+			}
+			count.Hits++
+			if hit.eval < count.Eval {
+				count.Eval = hit.eval
+			}
+		}
+		if len(counts) == 0 {
+			continue
+		}
+
+		taxa := make([]TaxonCount, 0, len(counts))
+		for _, count := range counts {
+			taxa = append(taxa, *count)
+		}
+		sort.Slice(taxa, func(i, j int) bool {
+			if taxa[i].Hits != taxa[j].Hits {
+				return taxa[i].Hits > taxa[j].Hits
+			}
+			return taxa[i].Eval < taxa[j].Eval
+		})
+		summaries = append(summaries, TaxonSummary{result.Database, taxa})
+	}
+	return summaries
+}
+
+// ranks of the prefixes of mmseqs taxonomic lineages, e.g. d_Bacteria;p_Proteobacteria;-_Gammaproteobacteria
+var lineageRanks = map[string]string{
+	"d": "superkingdom",
+	"k": "kingdom",
+	"p": "phylum",
+	"c": "class",
+	"o": "order",
+	"f": "family",
+	"g": "genus",
+	"s": "species",
+	"-": "no rank",
+}
+
+// lineageRank is the rank of the last taxon of a lineage
+func lineageRank(lineage string) string {
+	if lineage == "" {
+		return ""
+	}
+	last := lineage[strings.LastIndexByte(lineage, ';')+1:]
+	prefix, _, found := strings.Cut(last, "_")
+	if !found {
+		return ""
+	}
+	return lineageRanks[prefix]
+}
+
+// TaxonLca is the lowest common ancestor of the hits of a query
+type TaxonLca struct {
+	Query     string `json:"query"`
+	TaxonName string `json:"taxName"`
+	TaxonRank string `json:"taxRank"`
+	// lineage of the ancestor
+	TaxonLineage string `json:"taxLineage"`
+	// hits with a lineage
+	Hits int `json:"hits"`
+}
+
+// lineageLca returns the common ancestor of the lineages as a shared prefix of taxa, empty ones are ignored
+func lineageLca(query string, lineages []string) (TaxonLca, bool) {
+	var common []string
+	hits := 0
+	for _, lineage := range lineages {
+		if lineage == "" {
+			continue
+		}
+		taxa := strings.Split(lineage, ";")
+		if hits == 0 {
+			common = taxa
+		} else {
+			n := 0
+			for n < len(common) && n < len(taxa) && common[n] == taxa[n] {
+				n++
+			}
+			common = common[:n]
+		}
+		hits++
+	}
+	if len(common) == 0 {
+		return TaxonLca{}, false
+	}
+	lineage := strings.Join(common, ";")
+	_, name, _ := strings.Cut(common[len(common)-1], "_")
+	return TaxonLca{query, name, lineageRank(lineage), lineage, hits}, true
+}
+
+// AnnotateTaxonomy adds the rank to each hit with a lineage and, if lca is set,
+// the lowest common ancestor of the hits of each query to the results
+func AnnotateTaxonomy(results []SearchResult, lca bool) {
+	for i := range results {
+		var lcas []TaxonLca
+		add := func(query string, lineages []string) {
+			if !lca {
+				return
+			}
+			if node, ok := lineageLca(query, lineages); ok {
+				lcas = append(lcas, node)
+			}
+		}
+		switch conv := results[i].Alignments.(type) {
+		case [][]AlignmentEntry:
+			for _, inner := range conv {
+				lineages := make([]string, len(inner))
+				for j := range inner {
+					inner[j].TaxonRank = lineageRank(inner[j].TaxonLineage)
+					lineages[j] = inner[j].TaxonLineage
+				}
+				if len(inner) > 0 {
+					add(inner[0].Query, lineages)
+				}
+			}
+		case [][]FoldseekAlignmentEntry:
+			for _, inner := range conv {
+				lineages := make([]string, len(inner))
+				for j := range inner {
+					inner[j].TaxonRank = lineageRank(inner[j].TaxonLineage)
+					lineages[j] = inner[j].TaxonLineage
+				}
+				if len(inner) > 0 {
+					add(inner[0].Query, lineages)
+				}
+			}
+		case [][]ComplexAlignmentEntry:
+			for _, inner := range conv {
+				lineages := make([]string, len(inner))
+				for j := range inner {
+					inner[j].TaxonRank = lineageRank(inner[j].TaxonLineage)
+					lineages[j] = inner[j].TaxonLineage
+				}
+				if len(inner) > 0 {
+					add(inner[0].Query, lineages)
+				}
+			}
+		}
+		results[i].Lca = lcas
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregateTaxa(t *testing.T) {
+	results := []SearchResult{
+		{"uniref", [][]AlignmentEntry{
+			{
+				{Target: "a", Eval: 1e-10, TaxonId: "9606", TaxonName: "Homo sapiens", TaxonLineage: "d_Eukaryota;s_Homo sapiens"},
+				{Target: "b", Eval: 1e-20, TaxonId: "9606", TaxonName: "Homo sapiens", TaxonLineage: "d_Eukaryota;s_Homo sapiens"},
+				{Target: "c", Eval: 1e-5, TaxonId: "562", TaxonName: "Escherichia coli", TaxonLineage: "d_Bacteria;s_Escherichia coli"},
+			},
+		}, nil, nil},
+		{"pdb", [][]AlignmentEntry{{{Target: "d", Eval: 1e-3}}}, nil, nil},
+	}
+
+	expected := []TaxonSummary{
+		{"uniref", []TaxonCount{
+			{"9606", "Homo sapiens", "d_Eukaryota;s_Homo sapiens", 2, 1e-20},
+			{"562", "Escherichia coli", "d_Bacteria;s_Escherichia coli", 1, 1e-5},
+		}},
+	}
+	if got := AggregateTaxa(results); !reflect.DeepEqual(got, expected) {
+		t.Errorf("AggregateTaxa = %+v, expected %+v", got, expected)
+	}
+}
+
+func TestAnnotateTaxonomy(t *testing.T) {
+	results := []SearchResult{
+		{"uniref", [][]AlignmentEntry{
+			{
+				{Query: "q1", Target: "a", TaxonLineage: "d_Bacteria;p_Proteobacteria;-_Gammaproteobacteria;s_Escherichia coli"},
+				{Query: "q1", Target: "b", TaxonLineage: "d_Bacteria;p_Proteobacteria;-_Gammaproteobacteria;s_Salmonella enterica"},
+				{Query: "q1", Target: "c"},
+			},
+		}, nil, nil},
+	}
+	AnnotateTaxonomy(results, true)
+	hits := results[0].Alignments.([][]AlignmentEntry)[0]
+	if hits[0].TaxonRank != "species" || hits[2].TaxonRank != "" {
+		t.Errorf("unexpected ranks %q %q", hits[0].TaxonRank, hits[2].TaxonRank)
+	}
+	expected := []TaxonLca{{"q1", "Gammaproteobacteria", "no rank", "d_Bacteria;p_Proteobacteria;-_Gammaproteobacteria", 2}}
+	if !reflect.DeepEqual(results[0].Lca, expected) {
+		t.Errorf("Lca = %+v, expected %+v", results[0].Lca, expected)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/didip/tollbooth/v6"
+	"github.com/didip/tollbooth/v6/limiter"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+const NotifyTelegram NotifyChannel = "telegram"
+
+// unused link tokens expire, bound ones are kept longer so submitters can reuse them
+const (
+	telegramLinkExpiry  = 1 * time.Hour
+	telegramBoundExpiry = 30 * 24 * time.Hour
+)
+
+// link tokens a client can request per hour
+const telegramLinksPerHour = 10
+
+// the server polling the bot holds the poll lease, other servers sharing Redis wait for it to expire
+const telegramPollLease = 2 * time.Minute
+
+// ConfigTelegram are the settings of mail.notifiers.telegram
+type ConfigTelegram struct {
+	// defaults to the TELEGRAM_BOT_TOKEN environment variable
+	BotToken     string `json:"bottoken"`
+	BotTokenFile string `json:"bottokenfile"`
+	// user name of the bot for t.me links, without the @
+	Bot      string `json:"bot"`
+	Endpoint string `json:"endpoint"`
+	// text/template like the chat notifications, mail.webhook.text or the default text if empty
+	Text string `json:"text"`
+}
+
+func (c ConfigTelegram) api(method string) (string, error) {
+	token, err := readSecret(c.BotToken, c.BotTokenFile, "TELEGRAM_BOT_TOKEN")
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", errors.New("telegram bot token is not configured")
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.telegram.org"
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/bot" + token + "/" + method, nil
+}
+
+func (c ConfigTelegram) sendMessage(chat string, text string) error {
+	address, err := c.api("sendMessage")
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"chat_id": chat, "text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", address, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return withoutUrl(postNotification(req))
+}
+
+// TelegramNotifier messages the chat a submitter linked with the bot, the recipient is the chat ID
+type TelegramNotifier struct {
+	config ConfigTelegram
+}
+
+func (n TelegramNotifier) Notify(event NotifyEvent, ticket Ticket, recipient string) error {
+	source := n.config.Text
+	if source == "" {
+		source = event.Text
+	}
+	if source == "" {
+		source = defaultWebhookText
+	}
+	tmpl, err := texttemplate.New("telegram").Parse(source)
+	if err != nil {
+		return err
+	}
+	text, err := executeTemplate(tmpl, event.Data)
+	if err != nil {
+		return err
+	}
+	return n.config.sendMessage(recipient, text)
+}
+
+func parseTelegramConfig(raw json.RawMessage) (*ConfigTelegram, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var config ConfigTelegram
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func init() {
+	RegisterNotifier(NotifyTelegram, func(config ConfigRoot, raw json.RawMessage, mailer MailTransport) (Notifier, error) {
+		telegram, err := parseTelegramConfig(raw)
+		if err != nil || telegram == nil {
+			return TelegramNotifier{ConfigTelegram{}}, err
+		}
+		return TelegramNotifier{*telegram}, nil
+	})
+}
+
+// telegramLinkStore maps link tokens to the chat that started the bot with them, the chat is empty until then
+type telegramLinkStore interface {
+	Create(token string, expiry time.Duration) error
+	// Bind fails for unknown or expired tokens
+	Bind(token string, chat string) (bool, error)
+	// Chat is empty while the token is not bound
+	Chat(token string) (string, bool, error)
+}
+
+type redisTelegramLinks struct {
+	client *redis.Client
+}
+
+func (s redisTelegramLinks) Create(token string, expiry time.Duration) error {
+	return s.client.Set("mmseqs:telegram:"+token, "", expiry).Err()
+}
+
+func (s redisTelegramLinks) Bind(token string, chat string) (bool, error) {
+	n, err := s.client.Exists("mmseqs:telegram:" + token).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, s.client.Set("mmseqs:telegram:"+token, chat, telegramBoundExpiry).Err()
+}
+
+func (s redisTelegramLinks) Chat(token string) (string, bool, error) {
+	chat, err := s.client.Get("mmseqs:telegram:" + token).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return chat, err == nil, err
+}
+
+type telegramLink struct {
+	chat    string
+	expires time.Time
+}
+
+type memoryTelegramLinks struct {
+	mu    sync.Mutex
+	links map[string]telegramLink
+}
+
+// Create also removes the expired links
+func (s *memoryTelegramLinks) Create(token string, expiry time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for token, link := range s.links {
+		if now.After(link.expires) {
+			delete(s.links, token)
+		}
+	}
+	s.links[token] = telegramLink{"", now.Add(expiry)}
+	return nil
+}
+
+func (s *memoryTelegramLinks) Bind(token string, chat string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[token]
+	if !ok || time.Now().After(link.expires) {
+		return false, nil
+	}
+	s.links[token] = telegramLink{chat, time.Now().Add(telegramBoundExpiry)}
+	return true, nil
+}
+
+func (s *memoryTelegramLinks) Chat(token string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[token]
+	if !ok || time.Now().After(link.expires) {
+		return "", false, nil
+	}
+	return link.chat, true, nil
+}
+
+// telegramPoller decides which server polls the bot, Telegram only allows one poller per bot
+type telegramPoller interface {
+	// Acquire takes or extends the poll lease for owner, it is false while another server holds it
+	Acquire(owner string, lease time.Duration) (bool, error)
+}
+
+// the lease is taken if it is free and extended if owner holds it
+var telegramPollScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+type redisTelegramPoller struct {
+	client *redis.Client
+}
+
+func (p redisTelegramPoller) Acquire(owner string, lease time.Duration) (bool, error) {
+	acquired, err := telegramPollScript.Run(p.client, []string{"mmseqs:telegram:poller"}, owner, lease.Milliseconds()).Int()
+	return acquired == 1, err
+}
+
+// a server without Redis is the only one
+type localTelegramPoller struct{}
+
+func (localTelegramPoller) Acquire(string, time.Duration) (bool, error) {
+	return true, nil
+}
+
+// TelegramLinker runs the binding flow on the server: a submitter requests a link token, starts the bot with it
+// and submits jobs with notify_telegram=<token>, which is replaced by the chat ID
+type TelegramLinker struct {
+	config  ConfigTelegram
+	links   telegramLinkStore
+	poller  telegramPoller
+	limiter *limiter.Limiter
+}
+
+func NewTelegramLinker(jobsystem JobSystem, config ConfigRoot) (*TelegramLinker, error) {
+	telegram, err := parseTelegramConfig(config.Mail.Notifiers[NotifyTelegram])
+	if err != nil || telegram == nil {
+		return nil, err
+	}
+	var links telegramLinkStore = &memoryTelegramLinks{links: make(map[string]telegramLink)}
+	var poller telegramPoller = localTelegramPoller{}
+	if redisJobs, ok := jobsystem.(*RedisJobSystem); ok {
+		links = redisTelegramLinks{redisJobs.Client}
+		poller = redisTelegramPoller{redisJobs.Client}
+	}
+	lmt := limiter.New(&limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour}).
+		SetMax(float64(telegramLinksPerHour) / time.Hour.Seconds()).
+		SetBurst(telegramLinksPerHour)
+	if config.Server.RateLimit != nil && config.Server.RateLimit.IpLookupHeader != "" {
+		lmt.SetIPLookups([]string{config.Server.RateLimit.IpLookupHeader})
+	}
+	return &TelegramLinker{*telegram, links, poller, lmt}, nil
+}
+
+// Resolve replaces the link token of a submission with the chat ID
+func (l *TelegramLinker) Resolve(request *JobRequest) error {
+	if !isNotified(request.Notify, NotifyTelegram) {
+		return nil
+	}
+	if l == nil {
+		return errors.New("telegram notifications are not configured")
+	}
+	chat, ok, err := l.links.Chat(request.Recipients[NotifyTelegram])
+	if err != nil {
+		return err
+	}
+	if !ok || chat == "" {
+		return errors.New("the telegram link is not bound to a chat, start the bot with it first")
+	}
+	request.Recipients[NotifyTelegram] = chat
+	return nil
+}
+
+func (l *TelegramLinker) RegisterHandlers(r *mux.Router) {
+	r.HandleFunc("/notify/telegram", func(w http.ResponseWriter, req *http.Request) {
+		type LinkResponse struct {
+			Token string `json:"token"`
+			Link  string `json:"link"`
+		}
+		if limitErr := tollbooth.LimitByRequest(l.limiter, w, req); limitErr != nil {
+			http.Error(w, "Too many telegram links requested", limitErr.StatusCode)
+			return
+		}
+		token := newVerificationToken()
+		if err := l.links.Create(token, telegramLinkExpiry); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LinkResponse{token, "https://t.me/" + l.config.Bot + "?start=" + token})
+	}).Methods("POST")
+
+	r.HandleFunc("/notify/telegram/{token}", func(w http.ResponseWriter, req *http.Request) {
+		type StatusResponse struct {
+			Bound bool `json:"bound"`
+		}
+		chat, ok, err := l.links.Chat(mux.Vars(req)["token"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Unknown or expired telegram link", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(StatusResponse{chat != ""})
+	}).Methods("GET")
+}
+
+type telegramUpdate struct {
+	UpdateId int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			Id int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// handleUpdate binds the chat of a /start <token> message
+func (l *TelegramLinker) handleUpdate(update telegramUpdate) {
+	if update.Message == nil {
+		return
+	}
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) != 2 || fields[0] != "/start" {
+		return
+	}
+	chat := strconv.FormatInt(update.Message.Chat.Id, 10)
+	ok, err := l.links.Bind(fields[1], chat)
+	if err != nil {
+		notifyLog.Error("Failed to bind telegram chat", "error", err)
+		return
+	}
+	reply := "This link is unknown or expired, request a new one on the search page."
+	if ok {
+		reply = "You will be notified here when your jobs finish."
+	}
+	if err := l.config.sendMessage(chat, reply); err != nil {
+		notifyLog.Error("Failed to reply to telegram chat", "error", err)
+	}
+}
+
+// Run long polls the updates of the bot while this server holds the poll lease
+func (l *TelegramLinker) Run() {
+	client := &http.Client{Timeout: 60 * time.Second}
+	owner := newVerificationToken()
+	var offset int64
+	for {
+		polling, err := l.poller.Acquire(owner, telegramPollLease)
+		if err != nil {
+			notifyLog.Error("Failed to acquire the telegram poll lease", "error", err)
+		}
+		if !polling {
+			time.Sleep(telegramPollLease / 4)
+			continue
+		}
+		address, err := l.config.api("getUpdates?timeout=50&allowed_updates=%5B%22message%22%5D&offset=" + strconv.FormatInt(offset, 10))
+		if err != nil {
+			notifyLog.Error("Telegram notifications are disabled", "error", err)
+			return
+		}
+		updates, err := l.getUpdates(client, address)
+		if err != nil {
+			notifyLog.Error("Failed to read telegram updates", "error", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		for _, update := range updates {
+			l.handleUpdate(update)
+			offset = update.UpdateId + 1
+		}
+	}
+}
+
+func (l *TelegramLinker) getUpdates(client *http.Client, address string) ([]telegramUpdate, error) {
+	resp, err := client.Get(address)
+	if err != nil {
+		return nil, withoutUrl(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Ok          bool             `json:"ok"`
+		Description string           `json:"description"`
+		Result      []telegramUpdate `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.Ok {
+		return nil, errors.New(result.Description)
+	}
+	return result.Result, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTelegramLinking(t *testing.T) {
+	var sent []map[string]string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/botsecret/sendMessage" {
+			http.NotFound(w, req)
+			return
+		}
+		var message map[string]string
+		json.NewDecoder(req.Body).Decode(&message)
+		sent = append(sent, message)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer api.Close()
+
+	config, _ := DefaultConfig()
+	config.Mail.Notifiers = map[NotifyChannel]json.RawMessage{NotifyTelegram: json.RawMessage(`{"bottoken":"secret","bot":"search_bot","endpoint":"` + api.URL + `"}`)}
+	linker, err := NewTelegramLinker(nil, config)
+	if err != nil || linker == nil {
+		t.Fatalf("linker was not created: %v", err)
+	}
+	linker.links.Create("token", telegramLinkExpiry)
+
+	request := JobRequest{Notify: []NotifyChannel{NotifyTelegram}, Recipients: map[NotifyChannel]string{NotifyTelegram: "token"}}
+	if err := linker.Resolve(&request); err == nil {
+		t.Error("unbound link was accepted")
+	}
+
+	var update telegramUpdate
+	json.Unmarshal([]byte(`{"update_id":1,"message":{"text":"/start token","chat":{"id":42}}}`), &update)
+	linker.handleUpdate(update)
+	if err := linker.Resolve(&request); err != nil || request.Recipients[NotifyTelegram] != "42" {
+		t.Fatalf("link was not resolved: %v %v", err, request.Recipients)
+	}
+
+	notifier := TelegramNotifier{linker.config}
+	if err := notifier.Notify(NotifyEvent{Data: MailData{TicketID: "abc"}}, Ticket{}, "42"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || sent[1]["chat_id"] != "42" || !strings.Contains(sent[1]["text"], "Job abc is complete") {
+		t.Errorf("unexpected messages %v", sent)
+	}
+
+	linker.config.Endpoint = "http://127.0.0.1:1"
+	if err := linker.config.sendMessage("42", "text"); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("bot token is in the error: %v", err)
+	}
+}
+
+func TestTelegramLinkLimits(t *testing.T) {
+	links := &memoryTelegramLinks{links: make(map[string]telegramLink)}
+	links.Create("expired", -time.Second)
+	links.Create("open", telegramLinkExpiry)
+	if _, ok := links.links["expired"]; ok {
+		t.Error("expired links should be pruned")
+	}
+	if ok, _ := links.Bind("open", "42"); !ok {
+		t.Fatal("link was not bound")
+	}
+	if expires := links.links["open"].expires; time.Until(expires) < telegramBoundExpiry-time.Minute {
+		t.Errorf("bound links should expire after %s, not %s", telegramBoundExpiry, expires)
+	}
+
+	config, _ := DefaultConfig()
+	config.Mail.Notifiers = map[NotifyChannel]json.RawMessage{NotifyTelegram: json.RawMessage(`{"bottoken":"secret","bot":"search_bot"}`)}
+	linker, err := NewTelegramLinker(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	linker.RegisterHandlers(r)
+	status := 0
+	for i := 0; i <= telegramLinksPerHour; i++ {
+		req := httptest.NewRequest("POST", "/notify/telegram", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		status = w.Code
+	}
+	if status != http.StatusTooManyRequests {
+		t.Errorf("expected link requests to be limited, got %d", status)
+	}
+}
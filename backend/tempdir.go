@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const tempDirPrefix = "mmseqs-web-"
+
+// jobTempDir returns the scratch directory of a job, it is placed inside paths.temporary if configured
+// and otherwise inside the result directory of the job
+func jobTempDir(config ConfigRoot, id Id) string {
+	if config.Paths.Temporary != "" {
+		return filepath.Join(filepath.Clean(config.Paths.Temporary), tempDirPrefix+string(id))
+	}
+	return filepath.Join(filepath.Clean(config.Paths.Results), string(id), "tmp")
+}
+
+// SweepTempDirs removes scratch directories in paths.temporary that were left behind by crashed or restarted workers.
+// Directories of jobs that finished are always removed, directories of pending or running jobs only once they are older than maxAge.
+func SweepTempDirs(config ConfigRoot, maxAge time.Duration) {
+	if config.Paths.Temporary == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(config.Paths.Temporary)
+	if err != nil {
+		cleanupLog.Error("Failed to sweep temporary directories", "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tempDirPrefix) {
+			continue
+		}
+		id := strings.TrimPrefix(entry.Name(), tempDirPrefix)
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		status, _, err := getStatusFromJobFile(filepath.Join(config.Paths.Results, id, "job.json"))
+		active := err == nil && (status == StatusPending || status == StatusRunning)
+		if active && time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+
+		path := filepath.Join(config.Paths.Temporary, entry.Name())
+		cleanupLog.Debug("Removing orphaned temporary directory", "path", path)
+		if err := os.RemoveAll(path); err != nil {
+			cleanupLog.Error("Failed to remove temporary directory", "path", path, "error", err)
+		}
+	}
+}
+
+func tempSweeper(config ConfigRoot) {
+	maxAge, err := time.ParseDuration(config.Worker.TempMaxAge)
+	if err != nil {
+		cleanupLog.Warn("Invalid worker.tempmaxage, temporary directories are not swept", "error", err)
+		return
+	}
+
+	for {
+		SweepTempDirs(config, maxAge)
+		time.Sleep(1 * time.Hour)
+	}
+}
@@ -71,7 +71,10 @@ func GatherTemplates(w io.Writer, templates []string, a3m Reader[string], hhm *R
 			continue
 		}
 
-		entry := a3m.Data(a3mid)
+		entry, err := a3m.Data(a3mid)
+		if err != nil {
+			return err
+		}
 		entryLen := len(entry) + 1
 		a3mData.WriteString(entry)
 		a3mData.WriteRune(rune(0))
@@ -98,7 +101,10 @@ func GatherTemplates(w io.Writer, templates []string, a3m Reader[string], hhm *R
 				continue
 			}
 
-			entry := hhm.Data(hhmid)
+			entry, err := hhm.Data(hhmid)
+			if err != nil {
+				return err
+			}
 			entryLen := len(entry) + 1
 			hhmData.WriteString(entry)
 			hhmData.WriteRune(rune(0))
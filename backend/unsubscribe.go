@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+const unsubscribeText = "To stop notifications to this address open "
+
+// OptOutChecker tells if an address unsubscribed from notifications
+type OptOutChecker interface {
+	OptedOut(address string) (bool, error)
+}
+
+// optOutStore keeps the unsubscribed addresses hashed like the verified ones
+type optOutStore interface {
+	OptOutChecker
+	OptOut(address string) error
+	// OptIn subscribes an address again
+	OptIn(address string) error
+}
+
+type redisOptOutStore struct {
+	client *redis.Client
+}
+
+func (s redisOptOutStore) OptedOut(address string) (bool, error) {
+	return s.client.SIsMember("mmseqs:optout", addressHash(address)).Result()
+}
+
+func (s redisOptOutStore) OptOut(address string) error {
+	return s.client.SAdd("mmseqs:optout", addressHash(address)).Err()
+}
+
+func (s redisOptOutStore) OptIn(address string) error {
+	return s.client.SRem("mmseqs:optout", addressHash(address)).Err()
+}
+
+// optOutFile keeps the unsubscribed addresses of a local job system in paths.results
+const optOutFile = ".notify-optout.json"
+
+type memoryOptOutStore struct {
+	mu       sync.Mutex
+	path     string
+	optedOut map[string]bool
+}
+
+var localOptOutStore = &memoryOptOutStore{optedOut: make(map[string]bool)}
+
+// persist reads the addresses unsubscribed in path and writes every change there
+func (s *memoryOptOutStore) persist(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == path {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var hashes []string
+		if err := json.Unmarshal(data, &hashes); err != nil {
+			return fmt.Errorf("invalid opt-outs in %s: %w", path, err)
+		}
+		for _, hash := range hashes {
+			s.optedOut[hash] = true
+		}
+	}
+	s.path = path
+	return s.save()
+}
+
+func (s *memoryOptOutStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	hashes := make([]string, 0, len(s.optedOut))
+	for hash := range s.optedOut {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path+".part", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(s.path+".part", s.path)
+}
+
+func (s *memoryOptOutStore) OptedOut(address string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.optedOut[addressHash(address)], nil
+}
+
+func (s *memoryOptOutStore) OptOut(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.optedOut[addressHash(address)] = true
+	return s.save()
+}
+
+func (s *memoryOptOutStore) OptIn(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.optedOut, addressHash(address))
+	return s.save()
+}
+
+// newOptOutStore keeps the opt-outs in redis, otherwise in a file in paths.results
+func newOptOutStore(jobsystem JobSystem, config ConfigRoot) (optOutStore, error) {
+	if redisJobs, ok := jobsystem.(*RedisJobSystem); ok {
+		return redisOptOutStore{redisJobs.Client}, nil
+	}
+	if err := localOptOutStore.persist(filepath.Join(config.Paths.Results, optOutFile)); err != nil {
+		return nil, err
+	}
+	return localOptOutStore, nil
+}
+
+// jobOptOuts is checked by workers before mailing, remote workers ask the server
+func jobOptOuts(jobsystem JobSystem, config ConfigRoot) (OptOutChecker, error) {
+	if remote, ok := jobsystem.(*RemoteJobSystem); ok {
+		return remote, nil
+	}
+	return newOptOutStore(jobsystem, config)
+}
+
+// optOutTransport drops mails to addresses that unsubscribed
+type optOutTransport struct {
+	MailTransport
+	checker OptOutChecker
+}
+
+func (t optOutTransport) Send(mail Mail) error {
+	optedOut, err := t.checker.OptedOut(mail.Recipient)
+	if err != nil {
+		return err
+	}
+	if optedOut {
+		notifyLog.Info("Not sending mail to unsubscribed address")
+		return errDeliverySkipped
+	}
+	return t.MailTransport.Send(mail)
+}
+
+func unsubscribeSecret(config ConfigMailUnsubscribe) ([]byte, error) {
+	secret, err := readSecret(config.Secret, config.SecretFile, "MAIL_UNSUBSCRIBE_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, errors.New("mail.unsubscribe needs a secret")
+	}
+	return []byte(secret), nil
+}
+
+func unsubscribeSignature(secret []byte, address string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(address))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// UnsubscribeURL is the signed link that opts an address out of all notifications
+func UnsubscribeURL(config ConfigMailUnsubscribe, address string) (string, error) {
+	secret, err := unsubscribeSecret(config)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(address))
+	return strings.TrimSuffix(config.Url, "/") + "/notify/unsubscribe/" + encoded + "/" + unsubscribeSignature(secret, address), nil
+}
+
+// withUnsubscribeLink appends the link to bodies whose template does not show it and sets it for the List-Unsubscribe header
+func withUnsubscribeLink(mail Mail, link string) Mail {
+	if !strings.Contains(mail.Body, link) {
+		mail.Body = strings.TrimRight(mail.Body, "\n") + "\n\n" + unsubscribeText + link + "\n"
+	}
+	if mail.Html != "" && !strings.Contains(mail.Html, htmltemplate.HTMLEscapeString(link)) {
+		escaped := htmltemplate.HTMLEscapeString(link)
+		paragraph := "<p>" + htmltemplate.HTMLEscapeString(unsubscribeText) + `<a href="` + escaped + `">` + escaped + "</a></p>"
+		if end := strings.LastIndex(strings.ToLower(mail.Html), "</body>"); end >= 0 {
+			mail.Html = mail.Html[:end] + paragraph + mail.Html[end:]
+		} else {
+			mail.Html += paragraph
+		}
+	}
+	mail.Unsubscribe = link
+	return mail
+}
+
+// unsubscribeHeaders are the RFC 8058 one-click unsubscribe headers of a mail
+func unsubscribeHeaders(mail Mail) [][2]string {
+	if mail.Unsubscribe == "" {
+		return nil
+	}
+	return [][2]string{
+		{"List-Unsubscribe", "<" + mail.Unsubscribe + ">"},
+		{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+	}
+}
+
+// unsubscribeHandler shows the confirmation page of a signed link on GET and records the opt-out on POST,
+// which one-click clients send directly. A POST with subscribe=1 subscribes the address again.
+func unsubscribeHandler(config ConfigMailUnsubscribe, store optOutStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		secret, err := unsubscribeSecret(config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		vars := mux.Vars(req)
+		address, err := base64.RawURLEncoding.DecodeString(vars["address"])
+		if err != nil || !hmac.Equal([]byte(vars["signature"]), []byte(unsubscribeSignature(secret, string(address)))) {
+			http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+			return
+		}
+		if req.Method != http.MethodPost {
+			confirmPage(w, "Stop notifications to "+string(address)+"?", "Unsubscribe", "")
+			return
+		}
+		if req.FormValue("subscribe") == "1" {
+			if err := store.OptIn(string(address)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			confirmPage(w, "Notifications to "+string(address)+" are enabled again.", "Unsubscribe", "")
+			return
+		}
+		if err := store.OptOut(string(address)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		confirmPage(w, "Notifications to "+string(address)+" are disabled.", "Subscribe again", "subscribe")
+	}
+}
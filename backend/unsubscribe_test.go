@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestUnsubscribe(t *testing.T) {
+	config := ConfigMailUnsubscribe{Url: "https://search.example.org/api/", Secret: "secret"}
+	store := &memoryOptOutStore{optedOut: make(map[string]bool)}
+	link, err := UnsubscribeURL(config, "User@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://search.example.org/api/notify/unsubscribe/") {
+		t.Fatalf("unexpected link %s", link)
+	}
+	mail := withUnsubscribeLink(Mail{Body: "Done\n", Html: "<html><body><p>Done</p></body></html>"}, link)
+	if mail.Body != "Done\n\n"+unsubscribeText+link+"\n" || withUnsubscribeLink(mail, link).Body != mail.Body {
+		t.Errorf("unexpected body %q", mail.Body)
+	}
+	if !strings.Contains(mail.Html, `<a href="`+link+`">`) || !strings.HasSuffix(mail.Html, "</a></p></body></html>") || withUnsubscribeLink(mail, link).Html != mail.Html {
+		t.Errorf("unexpected html %q", mail.Html)
+	}
+	if message := string(smtpMessage(mail, "mail@example.org")); !strings.Contains(message, "List-Unsubscribe: <"+link+">\r\nList-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n") {
+		t.Errorf("missing unsubscribe headers in %s", message)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/notify/unsubscribe/{address}/{signature}", unsubscribeHandler(config, store))
+	path := strings.TrimPrefix(link, "https://search.example.org/api")
+	forged := path[:strings.LastIndex(path, "/")+1] + unsubscribeSignature([]byte("other"), "User@example.org")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", forged, nil))
+	if w.Code != 400 {
+		t.Errorf("forged link was accepted with %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if optedOut, _ := store.OptedOut("user@example.org"); w.Code != 200 || optedOut {
+		t.Fatalf("opening the link unsubscribed with %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader("List-Unsubscribe=One-Click")))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `name="subscribe"`) {
+		t.Fatalf("unsubscribe failed with %d: %s", w.Code, w.Body.String())
+	}
+	mailer := &recordingTransport{}
+	transport := optOutTransport{mailer, store}
+	transport.Send(Mail{Recipient: "user@example.org"})
+	transport.Send(Mail{Recipient: "other@example.org"})
+	if len(mailer.mails) != 1 || mailer.mails[0].Recipient != "other@example.org" {
+		t.Errorf("mail to an unsubscribed address was sent: %v", mailer.mails)
+	}
+
+	req := httptest.NewRequest("POST", path, strings.NewReader("subscribe=1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if optedOut, _ := store.OptedOut("user@example.org"); optedOut {
+		t.Error("address was not subscribed again")
+	}
+}
+
+func TestOptOutFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), optOutFile)
+	store := &memoryOptOutStore{optedOut: make(map[string]bool)}
+	if err := store.persist(path); err != nil {
+		t.Fatal(err)
+	}
+	store.OptOut("user@example.org")
+	store.OptOut("other@example.org")
+	store.OptIn("other@example.org")
+
+	restarted := &memoryOptOutStore{optedOut: make(map[string]bool)}
+	if err := restarted.persist(path); err != nil {
+		t.Fatal(err)
+	}
+	if optedOut, _ := restarted.OptedOut("user@example.org"); !optedOut {
+		t.Error("opt-out was lost on restart")
+	}
+	if optedOut, _ := restarted.OptedOut("other@example.org"); optedOut {
+		t.Error("opt-in was lost on restart")
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("partial file was left behind: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ToolVersions maps the name of a binary (mmseqs, foldseek, foldmason) to the output of its version command
+type ToolVersions map[string]string
+
+const versionsFile = "versions.json"
+
+// releaseNumber extracts the release of a version string like 15.6f452,
+// development builds only print a commit hash and have no release number
+func releaseNumber(version string) (int, bool) {
+	release, _, found := strings.Cut(version, ".")
+	if !found {
+		return 0, false
+	}
+	number, err := strconv.Atoi(release)
+	if err != nil {
+		return 0, false
+	}
+	return number, true
+}
+
+func binaryVersion(binary string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, binary, "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("could not get version of %s: %s", binary, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DetectVersions runs the binaries used by the configured app with version and checks them against minversions
+func DetectVersions(config ConfigRoot) (ToolVersions, error) {
+	binaries := map[string]string{"mmseqs": config.Paths.Mmseqs}
+	if config.App == AppFoldSeek {
+		binaries = map[string]string{"foldseek": config.Paths.FoldSeek, "foldmason": config.Paths.FoldMason}
+	}
+
+	versions := ToolVersions{}
+	for name, binary := range binaries {
+		version, err := binaryVersion(binary)
+		if err != nil {
+			return nil, err
+		}
+		versions[name] = version
+
+		minimum, ok := config.MinVersions[name]
+		if !ok {
+			continue
+		}
+		release, ok := releaseNumber(version)
+		if !ok {
+			serverLog.Warn("Cannot check the minimum release of a development build", "binary", name, "minimum", minimum, "version", version)
+			continue
+		}
+		if release < minimum {
+			return nil, fmt.Errorf("%s version %s is older than the minimum release %d", name, version, minimum)
+		}
+	}
+	return versions, nil
+}
+
+// writeJobVersions records the versions used to compute a result next to the job.json
+func writeJobVersions(config ConfigRoot, id Id) error {
+	data, err := json.Marshal(config.Versions)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(config.Paths.Results, string(id), versionsFile), data, 0644)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type CacheStatus struct {
+	Database string `json:"database"`
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	// bytes of the file in the page cache, -1 if this is not supported on this platform
+	Resident int64 `json:"resident"`
+}
+
+// warmupFiles returns the precomputed index of a database, or the database itself if it has no index
+func warmupFiles(config ConfigRoot, database string) []string {
+	base := filepath.Join(config.Paths.Databases, filepath.Base(database))
+	if fileExists(base + ".idx") {
+		return []string{base + ".idx", base + ".idx.index"}
+	}
+	return []string{base, base + ".index", base + "_h", base + "_h.index"}
+}
+
+// touchFile reads a file once so that it is pulled into the page cache
+func touchFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.CopyBuffer(io.Discard, file, make([]byte, 4*1024*1024))
+	return err
+}
+
+// hotDatabases are the configured databases followed by the most searched databases that are still installed
+func hotDatabases(config ConfigRoot) []string {
+	warmup := config.Worker.Warmup
+	if warmup == nil {
+		return nil
+	}
+	res := append([]string{}, warmup.Databases...)
+	if warmup.Top <= 0 {
+		return res
+	}
+	stats, err := readDatabaseStats(config.Paths.Databases)
+	if err != nil {
+		databaseLog.Error("Failed to read database statistics", "error", err)
+		return res
+	}
+	added := 0
+	for _, database := range mostSearchedDatabases(stats, len(stats)) {
+		if added == warmup.Top {
+			break
+		}
+		if isIn(database, res) != -1 || !fileExists(filepath.Join(config.Paths.Databases, filepath.Base(database)+".params")) {
+			continue
+		}
+		res = append(res, database)
+		added++
+	}
+	return res
+}
+
+// minResident is the fraction of the files of a hot database that has to be in the page cache for it to count as warm
+func minResident(config ConfigRoot) float64 {
+	if config.Worker.Warmup == nil || config.Worker.Warmup.MinResident == 0 {
+		return 0.9
+	}
+	return config.Worker.Warmup.MinResident
+}
+
+// CacheResidency reports how much of each warmed up file is currently in the page cache
+func CacheResidency(config ConfigRoot) []CacheStatus {
+	statuses := make([]CacheStatus, 0)
+	for _, database := range hotDatabases(config) {
+		for _, path := range warmupFiles(config, database) {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			resident, err := residentBytes(path, info.Size())
+			if err != nil {
+				resident = -1
+			}
+			statuses = append(statuses, CacheStatus{database, filepath.Base(path), info.Size(), resident})
+		}
+	}
+	return statuses
+}
+
+// coldFiles returns the files of hot databases that are not sufficiently in the page cache,
+// files are assumed to be warm on platforms that can not report the residency
+func coldFiles(config ConfigRoot, statuses []CacheStatus) []CacheStatus {
+	cold := make([]CacheStatus, 0)
+	threshold := minResident(config)
+	for _, status := range statuses {
+		if status.Resident >= 0 && float64(status.Resident) < threshold*float64(status.Size) {
+			cold = append(cold, status)
+		}
+	}
+	return cold
+}
+
+// how often the server measures the page cache residency of the hot databases
+const residencyInterval = 30 * time.Second
+
+// ResidencyTracker periodically measures the page cache residency, /warmup and /ready are unauthenticated
+// and must not run mincore over all hot databases per request
+type ResidencyTracker struct {
+	config   ConfigRoot
+	mu       sync.RWMutex
+	statuses []CacheStatus
+}
+
+// NewResidencyTracker measures once, so that a server is not ready before the first measurement
+func NewResidencyTracker(config ConfigRoot) *ResidencyTracker {
+	t := &ResidencyTracker{config, sync.RWMutex{}, nil}
+	t.Refresh()
+	return t
+}
+
+func (t *ResidencyTracker) Refresh() {
+	statuses := CacheResidency(t.config)
+	t.mu.Lock()
+	t.statuses = statuses
+	t.mu.Unlock()
+}
+
+func (t *ResidencyTracker) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		t.Refresh()
+	}
+}
+
+// Statuses returns the last measurement
+func (t *ResidencyTracker) Statuses() []CacheStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.statuses
+}
+
+type fileVersion struct {
+	size     int64
+	modified time.Time
+}
+
+// CacheWarmer reads the files of hot databases into the page cache and reads them again
+// once they were replaced (e.g. by a database update) or evicted (e.g. after a reboot or by other jobs)
+type CacheWarmer struct {
+	config ConfigRoot
+	warmed map[string]fileVersion
+}
+
+func NewCacheWarmer(config ConfigRoot) *CacheWarmer {
+	return &CacheWarmer{config, make(map[string]fileVersion)}
+}
+
+func (c *CacheWarmer) needsWarmup(database string) bool {
+	threshold := minResident(c.config)
+	for _, path := range warmupFiles(c.config, database) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if version, ok := c.warmed[path]; !ok || version.size != info.Size() || !version.modified.Equal(info.ModTime()) {
+			return true
+		}
+		if resident, err := residentBytes(path, info.Size()); err == nil && float64(resident) < threshold*float64(info.Size()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CacheWarmer) warm(database string) {
+	start := time.Now()
+	for _, path := range warmupFiles(c.config, database) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if err := touchFile(path); err != nil {
+			databaseLog.Error("Failed to warm up", "path", path, "error", err)
+			continue
+		}
+		c.warmed[path] = fileVersion{info.Size(), info.ModTime()}
+	}
+	databaseLog.Debug("Warmed up database", "database", database, "duration", time.Since(start).Round(time.Second))
+}
+
+// Run warms up the hot databases at startup, checks every check interval whether one needs to be warmed up again
+// and reads all of them again every interval
+func (c *CacheWarmer) Run(check time.Duration, interval time.Duration) {
+	var lastFull time.Time
+	for {
+		full := lastFull.IsZero() || (interval > 0 && time.Since(lastFull) >= interval)
+		warmed := false
+		for _, database := range hotDatabases(c.config) {
+			if full || c.needsWarmup(database) {
+				c.warm(database)
+				warmed = true
+			}
+		}
+		if full {
+			lastFull = time.Now()
+		}
+
+		if warmed && debugLogging() {
+			for _, status := range CacheResidency(c.config) {
+				if status.Resident >= 0 && status.Size > 0 {
+					databaseLog.Debug("Page cache residency", "file", status.File, "resident", strconv.FormatFloat(100*float64(status.Resident)/float64(status.Size), 'f', 1, 64)+"%", "size", formatByteSize(uint64(status.Size)))
+				}
+			}
+		}
+		time.Sleep(check)
+	}
+}
+
+func cacheWarmer(config ConfigRoot) {
+	if config.Worker.Warmup == nil || (len(config.Worker.Warmup.Databases) == 0 && config.Worker.Warmup.Top == 0) {
+		return
+	}
+	interval, err := time.ParseDuration(config.Worker.Warmup.Interval)
+	if err != nil {
+		databaseLog.Warn("Invalid worker.warmup.interval, databases are only warmed up again when needed", "error", err)
+		interval = 0
+	}
+	check := time.Minute
+	if config.Worker.Warmup.Check != "" {
+		if check, err = time.ParseDuration(config.Worker.Warmup.Check); err != nil || check <= 0 {
+			databaseLog.Warn("Invalid worker.warmup.check, checking every minute", "error", err)
+			check = time.Minute
+		}
+	}
+	NewCacheWarmer(config).Run(check, interval)
+}
@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// residentBytes counts the pages of a file that are in the page cache with mincore
+func residentBytes(path string, size int64) (int64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	data, err := unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Munmap(data)
+
+	pageSize := int64(os.Getpagesize())
+	vec := make([]byte, (size+pageSize-1)/pageSize)
+	_, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(&vec[0])))
+	if errno != 0 {
+		return 0, errno
+	}
+
+	var resident int64
+	for _, page := range vec {
+		if page&1 == 1 {
+			resident += pageSize
+		}
+	}
+	if resident > size {
+		resident = size
+	}
+	return resident, nil
+}
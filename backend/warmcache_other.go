@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func residentBytes(path string, size int64) (int64, error) {
+	return 0, errors.New("page cache residency is only supported on linux")
+}
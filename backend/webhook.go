@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+)
+
+type WebhookType string
+
+const (
+	WebhookSlack   WebhookType = "slack"
+	WebhookDiscord WebhookType = "discord"
+	// incoming webhooks of Teams workflows, they take an adaptive card
+	WebhookTeams WebhookType = "teams"
+)
+
+const defaultWebhookText = "Job {{.TicketID}} {{if .Error}}failed: {{.Error}}. {{.Hint}}{{else}}is complete.{{if .Summary}} {{.Summary}}{{end}}{{end}}{{if .ResultURL}} {{.ResultURL}}{{end}}"
+
+// Discord rejects longer messages
+const maxDiscordMessage = 2000
+
+type ConfigWebhook struct {
+	// detected from the URL if empty
+	Type WebhookType `json:"type"`
+	Url  string      `json:"url" validate:"required,url"`
+	// text/template with the fields of the mail templates
+	Text string `json:"text"`
+}
+
+// detectWebhook returns the type of a chat webhook URL, only the URLs of the supported services are accepted from submitters
+func detectWebhook(address string) (WebhookType, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", err
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case u.Scheme != "https":
+		return "", errors.New("webhooks have to use https")
+	case host == "hooks.slack.com":
+		return WebhookSlack, nil
+	case (host == "discord.com" || host == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+		return WebhookDiscord, nil
+	case strings.HasSuffix(host, ".webhook.office.com") || strings.HasSuffix(host, ".logic.azure.com"):
+		return WebhookTeams, nil
+	}
+	return "", errors.New("unsupported webhook " + host + ", only Slack, Discord and Teams webhooks are supported")
+}
+
+// jobWebhook is the webhook of a submission, or the global one of the server
+func jobWebhook(config ConfigMail, job JobRequest) *ConfigWebhook {
+	if job.Webhook == "" {
+		return config.Webhook
+	}
+	hook := ConfigWebhook{"", job.Webhook, ""}
+	if config.Webhook != nil {
+		hook.Text = config.Webhook.Text
+	}
+	return &hook
+}
+
+func webhookPayload(hook WebhookType, text string) ([]byte, error) {
+	switch hook {
+	case WebhookSlack:
+		return json.Marshal(map[string]string{"text": text})
+	case WebhookDiscord:
+		if len(text) > maxDiscordMessage {
+			text = strings.ToValidUTF8(text[:maxDiscordMessage-3], "") + "..."
+		}
+		return json.Marshal(map[string]string{"content": text})
+	case WebhookTeams:
+		type block struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+			Wrap bool   `json:"wrap"`
+		}
+		type card struct {
+			Type    string  `json:"type"`
+			Schema  string  `json:"$schema"`
+			Version string  `json:"version"`
+			Body    []block `json:"body"`
+		}
+		type attachment struct {
+			ContentType string `json:"contentType"`
+			Content     card   `json:"content"`
+		}
+		return json.Marshal(map[string]interface{}{
+			"type": "message",
+			"attachments": []attachment{{
+				"application/vnd.microsoft.card.adaptive",
+				card{"AdaptiveCard", "http://adaptivecards.io/schemas/adaptive-card.json", "1.4", []block{{"TextBlock", text, true}}},
+			}},
+		})
+	}
+	return nil, errors.New("unsupported webhook type " + string(hook))
+}
+
+// SendWebhook posts the notification of a job to a chat webhook
+func SendWebhook(hook ConfigWebhook, data MailData) error {
+	if hook.Type == "" {
+		var err error
+		if hook.Type, err = detectWebhook(hook.Url); err != nil {
+			return err
+		}
+	}
+	source := hook.Text
+	if source == "" {
+		source = defaultWebhookText
+	}
+	tmpl, err := texttemplate.New("webhook").Parse(source)
+	if err != nil {
+		return err
+	}
+	text, err := executeTemplate(tmpl, data)
+	if err != nil {
+		return err
+	}
+	body, err := webhookPayload(hook.Type, text)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return withoutUrl(postNotification(req))
+}
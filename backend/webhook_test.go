@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDetectWebhook(t *testing.T) {
+	for address, expected := range map[string]WebhookType{
+		"https://hooks.slack.com/services/T0/B0/X":                             WebhookSlack,
+		"https://discord.com/api/webhooks/1/abc":                               WebhookDiscord,
+		"https://prod-01.westeurope.logic.azure.com/workflows/1/triggers/http": WebhookTeams,
+		"https://discord.com/channels/1":                                       "",
+		"http://hooks.slack.com/services/T0/B0/X":                              "",
+		"https://169.254.169.254/latest":                                       "",
+	} {
+		hook, err := detectWebhook(address)
+		if hook != expected || (expected == "") != (err != nil) {
+			t.Errorf("%s: expected %q, got %q %v", address, expected, hook, err)
+		}
+	}
+
+	config := ConfigMail{Webhook: &ConfigWebhook{WebhookSlack, "https://hooks.slack.com/services/global", "{{.TicketID}}"}}
+	if hook := jobWebhook(config, JobRequest{Webhook: "https://discord.com/api/webhooks/1/abc"}); hook.Url != "https://discord.com/api/webhooks/1/abc" || hook.Type != "" || hook.Text != "{{.TicketID}}" {
+		t.Errorf("submission webhook was not used: %+v", hook)
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ = io.ReadAll(req.Body)
+	}))
+	defer server.Close()
+
+	data := MailData{TicketID: "abc", Status: StatusError, Error: "The job ran out of memory", Hint: "Submit fewer queries."}
+	if err := SendWebhook(ConfigWebhook{WebhookSlack, server.URL, ""}, data); err != nil {
+		t.Fatal(err)
+	}
+	var slack map[string]string
+	if err := json.Unmarshal(body, &slack); err != nil {
+		t.Fatal(err)
+	}
+	if slack["text"] != "Job abc failed: The job ran out of memory. Submit fewer queries." {
+		t.Errorf("unexpected slack message %q", slack["text"])
+	}
+
+	data.Summary = strings.Repeat("x", 3000)
+	if err := SendWebhook(ConfigWebhook{WebhookDiscord, server.URL, "{{.Summary}}"}, data); err != nil {
+		t.Fatal(err)
+	}
+	var discord map[string]string
+	if err := json.Unmarshal(body, &discord); err != nil {
+		t.Fatal(err)
+	}
+	if len(discord["content"]) != maxDiscordMessage {
+		t.Errorf("discord message was not truncated: %d", len(discord["content"]))
+	}
+
+	if err := SendWebhook(ConfigWebhook{WebhookTeams, server.URL, "done"}, data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"contentType":"application/vnd.microsoft.card.adaptive"`) || !strings.Contains(string(body), `"text":"done"`) {
+		t.Errorf("unexpected teams message %s", body)
+	}
+}
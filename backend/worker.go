@@ -4,15 +4,16 @@ import (
 	"archive/tar"
 	"bufio"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
+	"io"
+	"math"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,6 +30,10 @@ func (e *JobExecutionError) Error() string {
 	return "Execution Error: " + e.internal.Error()
 }
 
+func (e *JobExecutionError) Unwrap() error {
+	return e.internal
+}
+
 type JobTimeoutError struct {
 }
 
@@ -43,44 +48,36 @@ func (e *JobInvalidError) Error() string {
 	return "Invalid"
 }
 
-func execCommand(verbose bool, parameters ...string) (*exec.Cmd, chan error, error) {
-	cmd := exec.Command(
-		parameters[0],
-		parameters[1:]...,
-	)
-
-	SetSysProcAttr(cmd)
-
-	// Make sure MMseqs2's progress bar doesn't break
-	cmd.Env = append(os.Environ(), "TTY=0", "MMSEQS_CALL_DEPTH=1")
+type JobOutOfMemoryError struct {
+}
 
-	if verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
+func (e *JobOutOfMemoryError) Error() string {
+	return "Out of memory"
+}
 
+func execCommand(executor Executor, parameters ...string) (Process, chan error, error) {
 	done := make(chan error, 1)
-	err := cmd.Start()
+	process, err := executor.Start(parameters)
 	if err != nil {
-		return cmd, done, err
+		return process, done, err
 	}
 
 	go func() {
-		done <- cmd.Wait()
+		done <- process.Wait()
 	}()
 
-	return cmd, done, err
+	return process, done, nil
 }
 
-func execCommandSync(verbose bool, parameters ...string) error {
-	cmd, done, err := execCommand(verbose, parameters...)
+func execCommandSync(executor Executor, parameters ...string) error {
+	cmd, done, err := execCommand(executor, parameters...)
 	if err != nil {
 		return err
 	}
 	select {
 	case <-time.After(1 * time.Minute):
-		if err := KillCommand(cmd); err != nil {
-			log.Printf("Failed to kill: %s\n", err)
+		if err := cmd.Kill(); err != nil {
+			executorLog.Error("Failed to kill", "error", err)
 		}
 		return &JobTimeoutError{}
 	case err := <-done:
@@ -122,14 +119,123 @@ func ismmCIFFile(filePath string) (bool, error) {
 	return false, nil
 }
 
-func RunJob(request JobRequest, config ConfigRoot) (err error) {
+// jobTimeout returns the wall-clock limit of a job, database timeouts take precedence over
+// priority class timeouts, which take precedence over the default timeout
+func jobTimeout(request JobRequest, config ConfigRoot) (time.Duration, error) {
+	timeout := config.Worker.Timeout
+	if t, ok := config.Worker.PriorityTimeouts[request.Priority(config.Worker.BatchThreshold)]; ok && t != "" {
+		timeout = t
+	}
+
+	var dbTimeout time.Duration
+	for _, database := range jobDatabases(request) {
+		params, err := ReadParams(filepath.Join(config.Paths.Databases, database+".params"))
+		if err != nil {
+			return 0, err
+		}
+		if params.Timeout == "" {
+			continue
+		}
+		t, err := time.ParseDuration(params.Timeout)
+		if err != nil {
+			return 0, err
+		}
+		if t > dbTimeout {
+			dbTimeout = t
+		}
+	}
+	if dbTimeout > 0 {
+		return dbTimeout, nil
+	}
+
+	if timeout == "" {
+		return 1 * time.Hour, nil
+	}
+	return time.ParseDuration(timeout)
+}
+
+// databaseSlots returns how many of the selected databases are searched at the same time
+// and how many threads each of these searches may use
+func databaseSlots(config ConfigRoot, databases int) (int, int) {
+	parallel := config.Worker.ParallelDatabases
+	if parallel <= 0 || parallel > databases {
+		parallel = databases
+	}
+	parallel = max(parallel, 1)
+
+	// every call runs in its own cgroup and gets the full cgroup CPU limit
+	if config.Worker.Cgroup != nil && config.Worker.Cgroup.CPUs > 0 {
+		return parallel, max(int(math.Ceil(config.Worker.Cgroup.CPUs)), 1)
+	}
+	cpus := runtime.NumCPU()
+	// share of the CPUs of a job running in a worker slot
+	if config.Worker.CPUs > 0 {
+		cpus = config.Worker.CPUs
+	}
+	return parallel, max(cpus/parallel, 1)
+}
+
+func RunJob(ctx context.Context, request JobRequest, config ConfigRoot, executor Executor) (err error) {
+	tempDir := jobTempDir(config, request.Id)
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return &JobExecutionError{err}
+	}
+	// scratch files are removed on success, failure and timeout unless they are kept for debugging
+	defer func() {
+		workerMetrics.ObserveTemporary(request, directorySize(tempDir))
+		if config.Worker.Intermediates != nil {
+			rerr := keepIntermediates(config, request.Id, tempDir)
+			if rerr == nil {
+				return
+			}
+			workerLog.Error("Failed to keep intermediate files", "ticket", request.Id, "error", rerr)
+		}
+		if rerr := os.RemoveAll(tempDir); rerr != nil {
+			workerLog.Error("Failed to remove temporary directory", "ticket", request.Id, "error", rerr)
+		}
+	}()
+
+	checkpoint, err := loadCheckpoint(tempDir)
+	if err != nil {
+		return &JobExecutionError{err}
+	}
+	heartbeat := make(chan struct{})
+	defer close(heartbeat)
+	go checkpoint.heartbeat(heartbeat)
+
+	started := time.Now()
+	defer func() {
+		if err != nil {
+			return
+		}
+		workerMetrics.ObserveStages(request, checkpoint.Runtimes())
+		if serr := writeJobSummary(config, request, checkpoint.Runtimes(), time.Since(started)); serr != nil {
+			workerLog.Error("Failed to write job summary", "ticket", request.Id, "error", serr)
+		}
+		if serr := writeJobHistograms(filepath.Join(filepath.Clean(config.Paths.Results), string(request.Id)), request); serr != nil {
+			workerLog.Error("Failed to write job histograms", "ticket", request.Id, "error", serr)
+		}
+	}()
+
+	if config.Versions != nil {
+		if err := writeJobVersions(config, request.Id); err != nil {
+			return &JobExecutionError{err}
+		}
+	}
+	if err := writeDatabaseVersions(config, request); err != nil {
+		return &JobExecutionError{err}
+	}
+
 	switch job := request.Job.(type) {
 	case SearchJob:
+		if pipeline, ok := config.Pipelines[job.Mode]; ok {
+			return RunPipeline(ctx, request, job, pipeline, config, executor, tempDir, checkpoint)
+		}
 		resultBase := filepath.Join(config.Paths.Results, string(request.Id))
 		var wg sync.WaitGroup
 		errChan := make(chan error, len(job.Database))
-		maxParallel := config.Worker.ParallelDatabases
-		semaphore := make(chan struct{}, max(1, maxParallel))
+		maxParallel, threads := databaseSlots(config, len(job.Database))
+		semaphore := make(chan struct{}, maxParallel)
 
 		for index, database := range job.Database {
 			wg.Add(1)
@@ -137,6 +243,11 @@ func RunJob(request JobRequest, config ConfigRoot) (err error) {
 			go func(index int, database string) {
 				defer wg.Done()
 				defer func() { <-semaphore }()
+				stage := "search-" + database
+				if checkpoint.Done(stage) {
+					errChan <- nil
+					return
+				}
 				params, err := ReadParams(filepath.Join(config.Paths.Databases, database+".params"))
 				if err != nil {
 					errChan <- &JobExecutionError{err}
@@ -150,7 +261,7 @@ func RunJob(request JobRequest, config ConfigRoot) (err error) {
 				}
 				columns += ",pident,alnlen,mismatch,gapopen,qstart,qend,tstart,tend,evalue,bits,qlen,tlen,qaln,taln"
 				if params.Taxonomy {
-					columns += ",taxid,taxname"
+					columns += ",taxid,taxname,taxlineage"
 				}
 				parameters := []string{
 					config.Paths.Mmseqs,
@@ -158,7 +269,7 @@ func RunJob(request JobRequest, config ConfigRoot) (err error) {
 					filepath.Join(resultBase, "job.fasta"),
 					filepath.Join(config.Paths.Databases, database),
 					filepath.Join(resultBase, "alis_"+database),
-					filepath.Join(resultBase, "tmp"+strconv.Itoa(index)),
+					filepath.Join(tempDir, strconv.Itoa(index)),
 					"--shuffle",
 					"0",
 					"--db-output",
@@ -170,6 +281,12 @@ func RunJob(request JobRequest, config ConfigRoot) (err error) {
 					columns,
 				}
 				parameters = append(parameters, strings.Fields(params.Search)...)
+				parameters = append(parameters, job.DatabaseParams[database]...)
+				parameters = append(parameters, searchTypeParameters(job, filepath.Join(config.Paths.Databases, database))...)
+
+				if params.GPU {
+					parameters = append(parameters, "--gpu", "1")
+				}
 
 				if job.Mode == "summary" {
 					parameters = append(parameters, "--greedy-best-hits")
@@ -180,24 +297,69 @@ func RunJob(request JobRequest, config ConfigRoot) (err error) {
 					parameters = append(parameters, job.TaxFilter)
 				}
 
-				cmd, done, err := execCommand(config.Verbose, parameters...)
+				var threadParams []string
+				if maxParallel > 1 {
+					threadParams = []string{"--threads", strconv.Itoa(threads)}
+					parameters = append(parameters, threadParams...)
+				}
+
+				// result2msa and convertalis need the alignments in the temporary directory
+				sam := samDatabase(job, filepath.Join(config.Paths.Databases, database)) == nil
+				if job.Msa || sam || config.Worker.Intermediates != nil {
+					parameters = append(parameters, "--remove-tmp-files", "0")
+				}
+
+				parameters = append(parameters, job.Params...)
+
+				start := time.Now()
+				cmd, done, err := execCommand(executor.ForDatabase(params), parameters...)
 				if err != nil {
 					errChan <- &JobExecutionError{err}
 					return
 				}
 
 				select {
-				case <-time.After(1 * time.Hour):
-					if err := KillCommand(cmd); err != nil {
-						log.Printf("Failed to kill: %s\n", err)
+				case <-ctx.Done():
+					if err := cmd.Kill(); err != nil {
+						executorLog.Error("Failed to kill", "error", err)
 					}
 					errChan <- &JobTimeoutError{}
 				case err := <-done:
 					if err != nil {
 						errChan <- &JobExecutionError{err}
-					} else {
-						errChan <- nil
+						return
+					}
+					result := "result"
+					if job.Mode == "summary" {
+						result = "result_best"
+					}
+					// SAM records can be downloaded for searches against nucleotide databases
+					if sam {
+						err := writeSearchSam(ctx, executor.ForDatabase(params), config.Paths.Mmseqs, filepath.Join(tempDir, strconv.Itoa(index)), result, filepath.Join(config.Paths.Databases, database), resultBase, database, threadParams)
+						if _, ok := err.(*JobTimeoutError); ok {
+							errChan <- err
+							return
+						} else if err != nil {
+							errChan <- &JobExecutionError{err}
+							return
+						}
+					}
+					if job.Msa {
+						err := writeSearchMsas(ctx, executor.ForDatabase(params), config.Paths.Mmseqs, filepath.Join(tempDir, strconv.Itoa(index)), result, filepath.Join(config.Paths.Databases, database), resultBase, database, threadParams)
+						if _, ok := err.(*JobTimeoutError); ok {
+							errChan <- err
+							return
+						} else if err != nil {
+							errChan <- &JobExecutionError{err}
+							return
+						}
 					}
+					databaseRuntimes.RecordRuntime(database, time.Since(start))
+					if err := publishPartial(resultBase, tempDir, index, database); err != nil {
+						errChan <- &JobExecutionError{err}
+						return
+					}
+					errChan <- checkpoint.Mark(stage)
 				}
 			}(index, database)
 		}
@@ -211,28 +373,19 @@ func RunJob(request JobRequest, config ConfigRoot) (err error) {
 			}
 		}
 
-		err = execCommandSync(
-			config.Verbose,
-			config.Paths.Mmseqs,
-			"mvdb",
-			filepath.Join(resultBase, "tmp0", "latest", "query_h"),
-			filepath.Join(resultBase, "query_h"),
-		)
+		err = moveDb(executor, checkpoint, config.Paths.Mmseqs, filepath.Join(tempDir, "0", "latest", "query_h"), filepath.Join(resultBase, "query_h"))
 		if err != nil {
 			return &JobExecutionError{err}
 		}
-		err = execCommandSync(
-			config.Verbose,
-			config.Paths.Mmseqs,
-			"mvdb",
-			filepath.Join(resultBase, "tmp0", "latest", "query"),
-			filepath.Join(resultBase, "query"),
-		)
+		err = moveDb(executor, checkpoint, config.Paths.Mmseqs, filepath.Join(tempDir, "0", "latest", "query"), filepath.Join(resultBase, "query"))
 		if err != nil {
 			return &JobExecutionError{err}
 		}
+		if err := removePartial(resultBase); err != nil {
+			return &JobExecutionError{err}
+		}
 		for index, _ := range job.Database {
-			err := os.RemoveAll(filepath.Join(resultBase, "tmp"+strconv.Itoa(index)))
+			err := os.RemoveAll(filepath.Join(tempDir, strconv.Itoa(index)))
 			if err != nil {
 				return &JobExecutionError{err}
 			}
@@ -253,16 +406,14 @@ func RunJob(request JobRequest, config ConfigRoot) (err error) {
 			return &JobExecutionError{err}
 		}
 
-		if config.Verbose {
-			log.Print("Process finished gracefully without error")
-		}
+		workerLog.Debug("Process finished gracefully without error", "ticket", request.Id)
 		return nil
 	case StructureSearchJob:
 		resultBase := filepath.Join(config.Paths.Results, string(request.Id))
 		var wg sync.WaitGroup
 		errChan := make(chan error, len(job.Database))
-		maxParallel := config.Worker.ParallelDatabases
-		semaphore := make(chan struct{}, max(1, maxParallel))
+		maxParallel, threads := databaseSlots(config, len(job.Database))
+		semaphore := make(chan struct{}, maxParallel)
 
 		inputFile := filepath.Join(resultBase, "job.pdb")
 		input, err := os.ReadFile(inputFile)
@@ -307,7 +458,7 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 				filepath.Join(resultBase, "job.3di"),
 				resultBase,
 			}
-			err = execCommandSync(config.Verbose, parameters...)
+			err = execCommandSync(executor, parameters...)
 			if err != nil {
 				return &JobExecutionError{err}
 			}
@@ -362,8 +513,18 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 					columns += ",prob"
 				}
 				columns += ",evalue,bits,qlen,tlen,qaln,taln,tca,tseq"
+				// the superposition columns are appended last to keep the positions of older results,
+				// so the taxonomy columns are always written
 				if params.Taxonomy {
-					columns += ",taxid,taxname"
+					columns += ",taxid,taxname,taxlineage"
+				} else {
+					columns += ",empty,empty,empty"
+				}
+				// 3Di queries have no coordinates to superpose
+				if is3Di {
+					columns += ",empty,empty,empty"
+				} else {
+					columns += ",alntmscore,u,t"
 				}
 				parameters := []string{
 					config.Paths.FoldSeek,
@@ -371,7 +532,7 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 					inputFile,
 					filepath.Join(config.Paths.Databases, database),
 					filepath.Join(resultBase, "alis_"+database),
-					filepath.Join(resultBase, "tmp"+strconv.Itoa(index)),
+					filepath.Join(tempDir, strconv.Itoa(index)),
 					// "--shuffle",
 					// "0",
 					"--alignment-type",
@@ -385,6 +546,11 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 					columns,
 				}
 				parameters = append(parameters, strings.Fields(params.Search)...)
+				parameters = append(parameters, job.DatabaseParams[database]...)
+
+				if params.GPU {
+					parameters = append(parameters, "--gpu", "1")
+				}
 
 				if job.Mode == "summary" {
 					parameters = append(parameters, "--greedy-best-hits")
@@ -400,27 +566,39 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 					parameters = append(parameters, job.TaxFilter)
 				}
 
+				if maxParallel > 1 {
+					parameters = append(parameters, "--threads", strconv.Itoa(threads))
+				}
+
+				if config.Worker.Intermediates != nil {
+					parameters = append(parameters, "--remove-tmp-files", "0")
+				}
+
+				parameters = append(parameters, job.Params...)
+
 				if is3Di {
 					parameters = append(parameters, "--sort-by-structure-bits")
 					parameters = append(parameters, "0")
 				}
 
-				cmd, done, err := execCommand(config.Verbose, parameters...)
+				start := time.Now()
+				cmd, done, err := execCommand(executor.ForDatabase(params), parameters...)
 				if err != nil {
 					errChan <- &JobExecutionError{err}
 					return
 				}
 
 				select {
-				case <-time.After(1 * time.Hour):
-					if err := KillCommand(cmd); err != nil {
-						log.Printf("Failed to kill: %s\n", err)
+				case <-ctx.Done():
+					if err := cmd.Kill(); err != nil {
+						executorLog.Error("Failed to kill", "error", err)
 					}
 					errChan <- &JobTimeoutError{}
 				case err := <-done:
 					if err != nil {
 						errChan <- &JobExecutionError{err}
 					} else {
+						databaseRuntimes.RecordRuntime(database, time.Since(start))
 						errChan <- nil
 					}
 				}
@@ -437,29 +615,17 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 		}
 
 		if !is3Di {
-			err = execCommandSync(
-				config.Verbose,
-				config.Paths.FoldSeek,
-				"mvdb",
-				filepath.Join(resultBase, "tmp0", "latest", "query_h"),
-				filepath.Join(resultBase, "query_h"),
-			)
+			err = moveDb(executor, checkpoint, config.Paths.FoldSeek, filepath.Join(tempDir, "0", "latest", "query_h"), filepath.Join(resultBase, "query_h"))
 			if err != nil {
 				return &JobExecutionError{err}
 			}
-			err = execCommandSync(
-				config.Verbose,
-				config.Paths.FoldSeek,
-				"mvdb",
-				filepath.Join(resultBase, "tmp0", "latest", "query"),
-				filepath.Join(resultBase, "query"),
-			)
+			err = moveDb(executor, checkpoint, config.Paths.FoldSeek, filepath.Join(tempDir, "0", "latest", "query"), filepath.Join(resultBase, "query"))
 			if err != nil {
 				return &JobExecutionError{err}
 			}
 		}
 		for index, _ := range job.Database {
-			err := os.RemoveAll(filepath.Join(resultBase, "tmp"+strconv.Itoa(index)))
+			err := os.RemoveAll(filepath.Join(tempDir, strconv.Itoa(index)))
 			if err != nil {
 				return &JobExecutionError{err}
 			}
@@ -480,16 +646,14 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 			return &JobExecutionError{err}
 		}
 
-		if config.Verbose {
-			log.Print("Process finished gracefully without error")
-		}
+		workerLog.Debug("Process finished gracefully without error", "ticket", request.Id)
 		return nil
 	case ComplexSearchJob:
 		resultBase := filepath.Join(config.Paths.Results, string(request.Id))
 		var wg sync.WaitGroup
 		errChan := make(chan error, len(job.Database))
-		maxParallel := config.Worker.ParallelDatabases
-		semaphore := make(chan struct{}, max(1, maxParallel))
+		maxParallel, threads := databaseSlots(config, len(job.Database))
+		semaphore := make(chan struct{}, maxParallel)
 
 		inputFile := filepath.Join(resultBase, "job.pdb")
 
@@ -540,7 +704,7 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 				columns += ",pident,alnlen,mismatch,gapopen,qstart,qend,tstart,tend,prob,evalue,bits,qlen,tlen,qaln,taln,tca,tseq"
 				columns += ",complexassignid,complexqtmscore,complexttmscore,complexu,complext"
 				if params.Taxonomy {
-					columns += ",taxid,taxname"
+					columns += ",taxid,taxname,taxlineage"
 				}
 				parameters := []string{
 					config.Paths.FoldSeek,
@@ -548,7 +712,7 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 					inputFile,
 					filepath.Join(config.Paths.Databases, database),
 					filepath.Join(resultBase, "alis_"+database),
-					filepath.Join(resultBase, "tmp"+strconv.Itoa(index)),
+					filepath.Join(tempDir, strconv.Itoa(index)),
 					// "--shuffle",
 					// "0",
 					"--alignment-type",
@@ -570,6 +734,11 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 				}
 
 				parameters = append(parameters, strings.Fields(par)...)
+				parameters = append(parameters, job.DatabaseParams[database]...)
+
+				if params.GPU {
+					parameters = append(parameters, "--gpu", "1")
+				}
 
 				if job.Mode == "summary" {
 					parameters = append(parameters, "--greedy-best-hits")
@@ -580,22 +749,34 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 					parameters = append(parameters, job.TaxFilter)
 				}
 
-				cmd, done, err := execCommand(config.Verbose, parameters...)
+				if maxParallel > 1 {
+					parameters = append(parameters, "--threads", strconv.Itoa(threads))
+				}
+
+				if config.Worker.Intermediates != nil {
+					parameters = append(parameters, "--remove-tmp-files", "0")
+				}
+
+				parameters = append(parameters, job.Params...)
+
+				start := time.Now()
+				cmd, done, err := execCommand(executor.ForDatabase(params), parameters...)
 				if err != nil {
 					errChan <- &JobExecutionError{err}
 					return
 				}
 
 				select {
-				case <-time.After(1 * time.Hour):
-					if err := KillCommand(cmd); err != nil {
-						log.Printf("Failed to kill: %s\n", err)
+				case <-ctx.Done():
+					if err := cmd.Kill(); err != nil {
+						executorLog.Error("Failed to kill", "error", err)
 					}
 					errChan <- &JobTimeoutError{}
 				case err := <-done:
 					if err != nil {
 						errChan <- &JobExecutionError{err}
 					} else {
+						databaseRuntimes.RecordRuntime(database, time.Since(start))
 						errChan <- nil
 					}
 				}
@@ -611,28 +792,16 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 			}
 		}
 
-		err = execCommandSync(
-			config.Verbose,
-			config.Paths.FoldSeek,
-			"mvdb",
-			filepath.Join(resultBase, "tmp0", "latest", "query_h"),
-			filepath.Join(resultBase, "query_h"),
-		)
+		err = moveDb(executor, checkpoint, config.Paths.FoldSeek, filepath.Join(tempDir, "0", "latest", "query_h"), filepath.Join(resultBase, "query_h"))
 		if err != nil {
 			return &JobExecutionError{err}
 		}
-		err = execCommandSync(
-			config.Verbose,
-			config.Paths.FoldSeek,
-			"mvdb",
-			filepath.Join(resultBase, "tmp0", "latest", "query"),
-			filepath.Join(resultBase, "query"),
-		)
+		err = moveDb(executor, checkpoint, config.Paths.FoldSeek, filepath.Join(tempDir, "0", "latest", "query"), filepath.Join(resultBase, "query"))
 		if err != nil {
 			return &JobExecutionError{err}
 		}
 		for index, _ := range job.Database {
-			err := os.RemoveAll(filepath.Join(resultBase, "tmp"+strconv.Itoa(index)))
+			err := os.RemoveAll(filepath.Join(tempDir, strconv.Itoa(index)))
 			if err != nil {
 				return &JobExecutionError{err}
 			}
@@ -653,9 +822,7 @@ mv -f -- "${BASE}/query.lookup_tmp" "${BASE}/query.lookup"
 			return &JobExecutionError{err}
 		}
 
-		if config.Verbose {
-			log.Print("Process finished gracefully without error")
-		}
+		workerLog.Debug("Process finished gracefully without error", "ticket", request.Id)
 		return nil
 	case MsaJob:
 		resultBase := filepath.Join(config.Paths.Results, string(request.Id))
@@ -673,17 +840,18 @@ DBBASE="$3"
 BASE="$4"
 DB1="$5"
 DB2="$6"
+TMP="${13}"
 mkdir -p "${BASE}"
 "${MMSEQS}" createdb "${QUERY}" "${BASE}/qdb"
-"${MMSEQS}" search "${BASE}/qdb" "${DBBASE}/${DB1}" "${BASE}/res" "${BASE}/tmp" --num-iterations 3 --db-load-mode 2 -a
-"${MMSEQS}" mvdb "${BASE}/tmp/latest/profile_1" "${BASE}/prof_res"
+"${MMSEQS}" search "${BASE}/qdb" "${DBBASE}/${DB1}" "${BASE}/res" "${TMP}" --num-iterations 3 --db-load-mode 2 -a
+"${MMSEQS}" mvdb "${TMP}/latest/profile_1" "${BASE}/prof_res"
 "${MMSEQS}" lndb "${BASE}/qdb_h" "${BASE}/prof_res_h"
 "${MMSEQS}" expandaln "${BASE}/qdb" "${DBBASE}/${DB1}.idx" "${BASE}/res" "${DBBASE}/${DB1}.idx" "${BASE}/res_exp" --expansion-mode 1 --db-load-mode 2
 "${MMSEQS}" filterresult "${BASE}/qdb" "${DBBASE}/${DB1}.idx" "${BASE}/res_exp" "${BASE}/res_filt" --diff 3000 --db-load-mode 2
 "${MMSEQS}" result2msa "${BASE}/qdb" "${DBBASE}/${DB1}.idx" "${BASE}/res_filt" "${BASE}/uniref.sto" --filter-msa 0 --msa-format-mode 4 --db-load-mode 2
 "${MMSEQS}" convertalis "${BASE}/qdb" "${DBBASE}/${DB1}.idx" "${BASE}/res_filt" "${BASE}/uniref.m8" --format-output query,target,fident,alnlen,mismatch,gapopen,qstart,qend,tstart,tend,evalue,bits,qseq,qaln,tseq,taln --db-load-mode 2
 "${MMSEQS}" rmdb "${BASE}/res"
-"${MMSEQS}" search "${BASE}/prof_res" "${DBBASE}/${DB2}" "${BASE}/res" "${BASE}/tmp" --db-load-mode 2 -a
+"${MMSEQS}" search "${BASE}/prof_res" "${DBBASE}/${DB2}" "${BASE}/res" "${TMP}" --db-load-mode 2 -a
 "${MMSEQS}" result2msa "${BASE}/qdb" "${DBBASE}/${DB2}.idx" "${BASE}/res" "${BASE}/pdb70.sto" --filter-msa 0 --msa-format-mode 4 --db-load-mode 2
 "${MMSEQS}" convertalis "${BASE}/qdb" "${DBBASE}/${DB2}.idx" "${BASE}/res" "${BASE}/pdb70.m8" --format-output query,target,fident,alnlen,mismatch,gapopen,qstart,qend,tstart,tend,evalue,bits,qseq,qaln,tseq,taln --db-load-mode 2
 "${MMSEQS}" rmdb "${BASE}/qdb"
@@ -692,7 +860,7 @@ mkdir -p "${BASE}"
 "${MMSEQS}" rmdb "${BASE}/res_exp"
 "${MMSEQS}" rmdb "${BASE}/res_filt"
 rm -f "${BASE}/prof_res"*
-rm -rf "${BASE}/tmp"
+rm -rf "${TMP}"
 `)
 		} else {
 			parallel := config.Paths.ColabFold.ParallelStages
@@ -708,6 +876,8 @@ USE_TEMPLATES="$9"
 FILTER="${10}"
 TAXONOMY="${11}"
 M8OUT="${12}"
+TMP="${13}"
+GPU="${14}"
 EXPAND_EVAL=inf
 ALIGN_EVAL=10
 DIFF=3000
@@ -722,12 +892,15 @@ if [ "${FILTER}" = "1" ]; then
 fi
 export MMSEQS_CALL_DEPTH=1
 SEARCH_PARAM="--num-iterations 3 --db-load-mode 2 -a --k-score 'seq:96,prof:80' -e 0.1 --max-seqs 10000"
+if [ "${GPU}" = "1" ]; then
+  SEARCH_PARAM="${SEARCH_PARAM} --gpu 1 --prefilter-mode 1"
+fi
 FILTER_PARAM="--filter-min-enable 1000 --diff ${DIFF} --qid 0.0,0.2,0.4,0.6,0.8,1.0 --qsc 0 --max-seq-id 0.95"
 EXPAND_PARAM="--expansion-mode 0 -e ${EXPAND_EVAL} --expand-filter-clusters ${FILTER} --max-seq-id 0.95"
 mkdir -p "${BASE}"
 "${MMSEQS}" createdb "${QUERY}" "${BASE}/qdb" --dbtype 1
-"${MMSEQS}" search "${BASE}/qdb" "${DB1}" "${BASE}/res" "${BASE}/tmp1" $SEARCH_PARAM
-"${MMSEQS}" mvdb "${BASE}/tmp1/latest/profile_1" "${BASE}/prof_res"
+"${MMSEQS}" search "${BASE}/qdb" "${DB1}" "${BASE}/res" "${TMP}/1" $SEARCH_PARAM
+"${MMSEQS}" mvdb "${TMP}/1/latest/profile_1" "${BASE}/prof_res"
 "${MMSEQS}" lndb "${BASE}/qdb_h" "${BASE}/prof_res_h"
 `)
 			if parallel {
@@ -760,7 +933,7 @@ fi
 			}
 			script.WriteString(`
 if [ "${USE_TEMPLATES}" = "1" ]; then
-  "${MMSEQS}" search "${BASE}/prof_res" "${DB2}" "${BASE}/res_pdb" "${BASE}/tmp2" --db-load-mode 2 -s 7.5 -a -e 0.1
+  "${MMSEQS}" search "${BASE}/prof_res" "${DB2}" "${BASE}/res_pdb" "${TMP}/2" --db-load-mode 2 -s 7.5 -a -e 0.1
   "${MMSEQS}" convertalis "${BASE}/prof_res" "${DB2}.idx" "${BASE}/res_pdb" "${BASE}/pdb70.m8" --format-output query,target,fident,alnlen,mismatch,gapopen,qstart,qend,tstart,tend,evalue,bits,cigar --db-load-mode 2
   "${MMSEQS}" rmdb "${BASE}/res_pdb"
 fi
@@ -770,9 +943,9 @@ fi
 			}
 			script.WriteString(`
 if [ "${USE_ENV}" = "1" ]; then
-  "${MMSEQS}" search "${BASE}/prof_res" "${DB3}" "${BASE}/res_env" "${BASE}/tmp3" $SEARCH_PARAM
+  "${MMSEQS}" search "${BASE}/prof_res" "${DB3}" "${BASE}/res_env" "${TMP}/3" $SEARCH_PARAM
   "${MMSEQS}" expandaln "${BASE}/prof_res" "${DB3}.idx" "${BASE}/res_env" "${DB3}.idx" "${BASE}/res_env_exp" -e ${EXPAND_EVAL} --expansion-mode 0 --db-load-mode 2
-  "${MMSEQS}" align "${BASE}/tmp3/latest/profile_1" "${DB3}.idx" "${BASE}/res_env_exp" "${BASE}/res_env_exp_realign" --db-load-mode 2 -e ${ALIGN_EVAL} --max-accept ${MAX_ACCEPT} --alt-ali 10 -a
+  "${MMSEQS}" align "${TMP}/3/latest/profile_1" "${DB3}.idx" "${BASE}/res_env_exp" "${BASE}/res_env_exp_realign" --db-load-mode 2 -e ${ALIGN_EVAL} --max-accept ${MAX_ACCEPT} --alt-ali 10 -a
   "${MMSEQS}" filterresult "${BASE}/qdb" "${DB3}.idx" "${BASE}/res_env_exp_realign" "${BASE}/res_env_exp_realign_filter" --db-load-mode 2 --qid 0 --qsc $QSC --diff 0 --max-seq-id 1.0 --filter-min-enable 100
   if [ "${M8OUT}" = "1" ]; then
     "${MMSEQS}" filterresult "${BASE}/qdb" "${DB3}.idx" "${BASE}/res_env_exp_realign_filter" "${BASE}/res_env_exp_realign_filter_filter" --db-load-mode 2 ${FILTER_PARAM}
@@ -795,7 +968,7 @@ fi
 "${MMSEQS}" rmdb "${BASE}/qdb_h"
 "${MMSEQS}" rmdb "${BASE}/res"
 rm -f -- "${BASE}/prof_res"*
-rm -rf -- "${BASE}/tmp1" "${BASE}/tmp2" "${BASE}/tmp3"
+rm -rf -- "${TMP}/1" "${TMP}/2" "${TMP}/3"
 `)
 		}
 		err = script.Close()
@@ -809,6 +982,7 @@ rm -rf -- "${BASE}/tmp1" "${BASE}/tmp2" "${BASE}/tmp3"
 		useFilter := isIn("nofilter", modes) == -1
 		taxonomy := isIn("taxonomy", modes) == 1
 		m8out := isIn("m8output", modes) == 1
+		split := isIn("split", modes) != -1 && !m8out
 		var b2i = map[bool]int{false: 0, true: 1}
 
 		parameters := []string{
@@ -826,17 +1000,19 @@ rm -rf -- "${BASE}/tmp1" "${BASE}/tmp2" "${BASE}/tmp3"
 			strconv.Itoa(b2i[useFilter]),
 			strconv.Itoa(b2i[taxonomy]),
 			strconv.Itoa(b2i[m8out]),
+			tempDir,
+			strconv.Itoa(b2i[colabFoldGpu(config)]),
 		}
 
-		cmd, done, err := execCommand(config.Verbose, parameters...)
+		cmd, done, err := execCommand(executor, parameters...)
 		if err != nil {
 			return &JobExecutionError{err}
 		}
 
 		select {
-		case <-time.After(1 * time.Hour):
-			if err := KillCommand(cmd); err != nil {
-				log.Printf("Failed to kill: %s\n", err)
+		case <-ctx.Done():
+			if err := cmd.Kill(); err != nil {
+				executorLog.Error("Failed to kill", "error", err)
 			}
 			return &JobTimeoutError{}
 		case err := <-done:
@@ -888,6 +1064,27 @@ rm -rf -- "${BASE}/tmp1" "${BASE}/tmp2" "${BASE}/tmp3"
 						suffix = ".m8"
 					}
 
+					// one a3m and template file per query named after the query, like colabfold_search writes them
+					if split {
+						fasta, err := os.ReadFile(filepath.Join(resultBase, "job.fasta"))
+						if err != nil {
+							return err
+						}
+						names := queryNames(string(fasta))
+						msas := []string{filepath.Join(resultBase, "uniref.a3m")}
+						if useEnv {
+							msas = append(msas, filepath.Join(resultBase, "bfd.mgnify30.metaeuk30.smag30.a3m"))
+						}
+						if err := addQueryMsas(tw, names, msas); err != nil {
+							return err
+						}
+						if useTemplates {
+							if err := addQueryTemplates(tw, string(fasta), names, filepath.Join(resultBase, "pdb70.m8")); err != nil {
+								return err
+							}
+						}
+					}
+
 					path := filepath.Join(resultBase, "uniref"+suffix)
 					if err := addFile(tw, path); err != nil {
 						return err
@@ -942,9 +1139,7 @@ rm -rf -- "${BASE}/tmp1" "${BASE}/tmp2" "${BASE}/tmp3"
 			}
 		}
 
-		if config.Verbose {
-			log.Print("Process finished gracefully without error")
-		}
+		workerLog.Debug("Process finished gracefully without error", "ticket", request.Id)
 		return nil
 	case PairJob:
 		resultBase := filepath.Join(config.Paths.Results, string(request.Id))
@@ -963,11 +1158,16 @@ DB2="$6"
 USE_ENV="$7"
 USE_PAIRWISE="$8"
 PAIRING_STRATEGY="$9"
+TMP="${10}"
+GPU="${11}"
 SEARCH_PARAM="--num-iterations 3 --db-load-mode 2 -a --k-score 'seq:96,prof:80' -e 0.1 --max-seqs 10000"
+if [ "${GPU}" = "1" ]; then
+	SEARCH_PARAM="${SEARCH_PARAM} --gpu 1 --prefilter-mode 1"
+fi
 EXPAND_PARAM="--expansion-mode 0 -e inf --expand-filter-clusters 0 --max-seq-id 0.95"
 export MMSEQS_CALL_DEPTH=1
 "${MMSEQS}" createdb "${QUERY}" "${BASE}/qdb" --shuffle 0 --dbtype 1
-"${MMSEQS}" search "${BASE}/qdb" "${DB1}" "${BASE}/res" "${BASE}/tmp" $SEARCH_PARAM
+"${MMSEQS}" search "${BASE}/qdb" "${DB1}" "${BASE}/res" "${TMP}" $SEARCH_PARAM
 if [ "${USE_PAIRWISE}" = "1" ]; then
     for i in qdb res qdb_h; do
 		awk 'BEGIN { OFS="\t"; cnt = 0; } NR == 1 { off = $2; len = $3; next; } { print (2*cnt),off,len; print (2*cnt)+1,$2,$3; cnt+=1; }' "${BASE}/${i}.index" > "${BASE}/${i}.index_tmp"
@@ -992,7 +1192,7 @@ fi
 "${MMSEQS}" rmdb "${BASE}/res_final"
 
 if [ "${USE_ENV}" = "1" ]; then
-	"${MMSEQS}" search "${BASE}/qdb" "${DB2}" "${BASE}/res" "${BASE}/tmp" $SEARCH_PARAM
+	"${MMSEQS}" search "${BASE}/qdb" "${DB2}" "${BASE}/res" "${TMP}" $SEARCH_PARAM
 	"${MMSEQS}" expandaln "${BASE}/qdb" "${DB2}.idx" "${BASE}/res" "${DB2}.idx" "${BASE}/res_exp" --db-load-mode 2 ${EXPAND_PARAM}
 	"${MMSEQS}" align   "${BASE}/qdb" "${DB2}.idx" "${BASE}/res_exp" "${BASE}/res_exp_realign" --db-load-mode 2 -e 0.001 --max-accept 1000000 -c 0.5 --cov-mode 1
 	"${MMSEQS}" pairaln "${BASE}/qdb" "${DB2}.idx" "${BASE}/res_exp_realign" "${BASE}/res_exp_realign_pair" --db-load-mode 2 --pairing-mode "${PAIRING_STRATEGY}" --pairing-dummy-mode 0
@@ -1013,7 +1213,7 @@ fi
 "${MMSEQS}" rmdb "${BASE}/qdb"
 "${MMSEQS}" rmdb "${BASE}/qdb_h"
 
-rm -rf -- "${BASE}/tmp"
+rm -rf -- "${TMP}"
 `)
 		err = script.Close()
 		if err != nil {
@@ -1037,6 +1237,18 @@ rm -rf -- "${BASE}/tmp"
 			pairingStrategy = "1"
 		}
 
+		pairDb := config.Paths.ColabFold.Uniref
+		envDb := config.Paths.ColabFold.EnvironmentalPair
+		if len(job.Database) > 0 {
+			pairDb = filepath.Join(config.Paths.Databases, job.Database[0])
+		}
+		if len(job.Database) > 1 {
+			envDb = filepath.Join(config.Paths.Databases, job.Database[1])
+		}
+		gpu, err := requiresGpu(request, config)
+		if err != nil {
+			return &JobExecutionError{err}
+		}
 		parameters := []string{
 			"/bin/sh",
 			scriptPath,
@@ -1044,22 +1256,24 @@ rm -rf -- "${BASE}/tmp"
 			filepath.Join(resultBase, "job.fasta"),
 			config.Paths.Databases,
 			resultBase,
-			config.Paths.ColabFold.Uniref,
-			config.Paths.ColabFold.EnvironmentalPair,
+			pairDb,
+			envDb,
 			strconv.Itoa(b2i[useEnv]),
 			strconv.Itoa(b2i[usePairwise]),
 			pairingStrategy,
+			tempDir,
+			strconv.Itoa(b2i[gpu]),
 		}
 
-		cmd, done, err := execCommand(config.Verbose, parameters...)
+		cmd, done, err := execCommand(executor, parameters...)
 		if err != nil {
 			return &JobExecutionError{err}
 		}
 
 		select {
-		case <-time.After(1 * time.Hour):
-			if err := KillCommand(cmd); err != nil {
-				log.Printf("Failed to kill: %s\n", err)
+		case <-ctx.Done():
+			if err := cmd.Kill(); err != nil {
+				executorLog.Error("Failed to kill", "error", err)
 			}
 			return &JobTimeoutError{}
 		case err := <-done:
@@ -1118,11 +1332,28 @@ rm -rf -- "${BASE}/tmp"
 				return &JobExecutionError{err}
 			}
 		}
-		if config.Verbose {
-			log.Print("Process finished gracefully without error")
-		}
+		workerLog.Debug("Process finished gracefully without error", "ticket", request.Id)
 		return nil
 	case IndexJob:
+		// the progress of downloads is written to the job log next to the output of the executor
+		var progress io.Writer = io.Discard
+		if f, err := os.OpenFile(filepath.Join(config.Paths.Results, string(request.Id), "job.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			defer f.Close()
+			progress = f
+		}
+		// the current release stays available while an update is built
+		if job.Upstream != "" {
+			if err := UpdateDatabase(ctx, config, job, executor, progress); err != nil {
+				return &JobExecutionError{err}
+			}
+			return nil
+		}
+		// the database is rebuilt in place, so no search may use it in the meantime
+		lock, err := lockDatabase(ctx, config.Paths.Databases, job.Path, true)
+		if err != nil {
+			return &JobExecutionError{err}
+		}
+		defer lock.Unlock()
 		file := filepath.Join(config.Paths.Databases, job.Path)
 		params, err := ReadParams(file + ".params")
 		if err != nil {
@@ -1133,16 +1364,32 @@ rm -rf -- "${BASE}/tmp"
 		if err != nil {
 			return &JobExecutionError{err}
 		}
-		err = CheckDatabase(file, params, config)
+		setStage := func(stage DatabaseStage) {
+			params.Stage = stage
+			if err := SaveParams(file+".params", params); err != nil {
+				databaseLog.Error("Failed to save database stage", "ticket", request.Id, "error", err)
+			}
+		}
+		err = fetchDatabaseSource(ctx, config, file, params, executor.ForDatabase(params), tempDir, setStage, progress)
 		if err != nil {
 			params.Status = StatusError
+			params.Stage = DatabaseFailed
 			SaveParams(file+".params", params)
 			return &JobExecutionError{err}
 		}
-		if config.Verbose {
-			log.Println("Process finished gracefully without error")
+		err = CheckDatabase(file, params, config, executor.ForDatabase(params), setStage)
+		if err != nil {
+			params.Status = StatusError
+			params.Stage = DatabaseFailed
+			SaveParams(file+".params", params)
+			return &JobExecutionError{err}
+		}
+		workerLog.Debug("Process finished gracefully without error", "ticket", request.Id)
+		if err := fillManifest(config.Paths.Databases, &params); err != nil {
+			databaseLog.Error("Failed to collect metadata", "ticket", request.Id, "database", job.Path, "error", err)
 		}
 		params.Status = StatusComplete
+		params.Stage = DatabaseReady
 		err = SaveParams(file+".params", params)
 		if err != nil {
 			return &JobExecutionError{err}
@@ -1155,7 +1402,7 @@ rm -rf -- "${BASE}/tmp"
 			"easy-msa",
 			filepath.Join(resultBase, "pdbs/"),
 			filepath.Join(resultBase, "foldmason"),
-			filepath.Join(resultBase, "tmp/"),
+			tempDir,
 			"--gap-open",
 			strconv.FormatInt(job.GapOpen, 10),
 			"--gap-extend",
@@ -1165,14 +1412,14 @@ rm -rf -- "${BASE}/tmp"
 			"--report-paths",
 			"0",
 		}
-		cmd, done, err := execCommand(config.Verbose, parameters...)
+		cmd, done, err := execCommand(executor, parameters...)
 		if err != nil {
 			return &JobExecutionError{err}
 		}
 		select {
-		case <-time.After(1 * time.Hour):
-			if err := KillCommand(cmd); err != nil {
-				log.Printf("Failed to kill: %s\n", err)
+		case <-ctx.Done():
+			if err := cmd.Kill(); err != nil {
+				executorLog.Error("Failed to kill", "error", err)
 			}
 			return &JobTimeoutError{}
 		case err := <-done:
@@ -1180,9 +1427,86 @@ rm -rf -- "${BASE}/tmp"
 				return &JobExecutionError{err}
 			}
 		}
-		if config.Verbose {
-			log.Print("Process finished gracefully without error")
+		workerLog.Debug("Process finished gracefully without error", "ticket", request.Id)
+		return nil
+	case ClusterJob:
+		resultBase := filepath.Join(config.Paths.Results, string(request.Id))
+		var mode2module = map[string]string{"cluster": "easy-cluster", "linclust": "easy-linclust"}
+		module, found := mode2module[job.Mode]
+		if !found {
+			return &JobExecutionError{errors.New("invalid mode selected")}
+		}
+		parameters := []string{
+			config.Paths.Mmseqs,
+			module,
+			filepath.Join(resultBase, "job.fasta"),
+			filepath.Join(resultBase, "cluster"),
+			tempDir,
+			"--min-seq-id",
+			strconv.FormatFloat(job.MinSeqId, 'f', -1, 64),
+			"-c",
+			strconv.FormatFloat(job.Coverage, 'f', -1, 64),
+			"--cov-mode",
+			strconv.Itoa(job.CovMode),
+			"--remove-tmp-files",
+			"1",
+		}
+		parameters = append(parameters, job.Params...)
+		cmd, done, err := execCommand(executor, parameters...)
+		if err != nil {
+			return &JobExecutionError{err}
+		}
+		select {
+		case <-ctx.Done():
+			if err := cmd.Kill(); err != nil {
+				executorLog.Error("Failed to kill", "error", err)
+			}
+			return &JobTimeoutError{}
+		case err := <-done:
+			if err != nil {
+				return &JobExecutionError{err}
+			}
+		}
+
+		file, err := os.Create(filepath.Join(resultBase, "mmseqs_results_"+string(request.Id)+".tar.gz"))
+		if err != nil {
+			return &JobExecutionError{err}
+		}
+		err = func() (err error) {
+			gw := gzip.NewWriter(file)
+			defer func() {
+				cerr := gw.Close()
+				if err == nil {
+					err = cerr
+				}
+			}()
+			tw := tar.NewWriter(gw)
+			defer func() {
+				cerr := tw.Close()
+				if err == nil {
+					err = cerr
+				}
+			}()
+
+			if err := addFile(tw, filepath.Join(resultBase, "cluster_cluster.tsv")); err != nil {
+				return err
+			}
+			if err := addFile(tw, filepath.Join(resultBase, "cluster_rep_seq.fasta")); err != nil {
+				return err
+			}
+			return nil
+		}()
+		if err != nil {
+			file.Close()
+			return &JobExecutionError{err}
+		}
+		err = file.Close()
+		if err != nil {
+			return &JobExecutionError{err}
 		}
+		os.Remove(filepath.Join(resultBase, "cluster_all_seqs.fasta"))
+
+		workerLog.Debug("Process finished gracefully without error", "ticket", request.Id)
 		return nil
 
 	default:
@@ -1190,13 +1514,49 @@ rm -rf -- "${BASE}/tmp"
 	}
 }
 
-func worker(jobsystem JobSystem, config ConfigRoot) {
-	log.Println("MMseqs2 worker")
+func worker(jobsystem JobSystem, config ConfigRoot, gpus *GpuPool, watchdog *DiskWatchdog) {
+	workerLog.Info("MMseqs2 worker")
+	databaseRuntimes = NewDatabaseStatsRecorder(config)
+	go databaseRuntimes.Run(time.Minute)
 	mailer := MailTransport(NullTransport{})
 	if config.Mail.Mailer != nil {
-		log.Println("Using " + config.Mail.Mailer.Type + " mail transport")
+		notifyLog.Info("Using mail transport", "transport", config.Mail.Mailer.Type)
 		mailer = config.Mail.Mailer.GetTransport()
 	}
+	mailer, err := signMail(mailer, config.Mail)
+	if err != nil {
+		logFatal(notifyLog, "Invalid mail signing", err)
+	}
+	var batching *batchingTransport
+	if config.Mail.Batching != nil {
+		if batching, err = newBatchingTransport(mailer, *config.Mail.Batching, newMailBatchStore(jobsystem)); err != nil {
+			logFatal(notifyLog, "Invalid mail batching", err)
+		}
+		go batching.Run(time.Minute)
+		mailer = batching
+	}
+	if config.Mail.Verification != nil {
+		mailer = verifiedTransport{mailer, jobVerifier(jobsystem)}
+	}
+	if config.Mail.Unsubscribe != nil {
+		optOuts, err := jobOptOuts(jobsystem, config)
+		if err != nil {
+			logFatal(notifyLog, "Failed to read the opt-outs", err)
+		}
+		mailer = optOutTransport{mailer, optOuts}
+	}
+	notifications, err := NewDeliveryQueue(jobsystem, config, mailer)
+	if err != nil {
+		logFatal(notifyLog, "Failed to set up notifications", err)
+	}
+	go notifications.Run(time.Minute)
+	go sendHeartbeats(jobsystem, workerName(config.Worker))
+	removeMetrics := func() {}
+	if config.Worker.Metrics != nil {
+		if removeMetrics, err = serveWorkerMetrics(*config.Worker.Metrics, workerName(config.Worker)); err != nil {
+			logFatal(workerLog, "Invalid worker.metrics", err)
+		}
+	}
 
 	var shouldExit int32 = 0
 	if config.Worker.GracefulExit {
@@ -1209,14 +1569,40 @@ func worker(jobsystem JobSystem, config ConfigRoot) {
 		}()
 	}
 
+	cache, err := NewDatabaseCache(config)
+	if err != nil {
+		logFatal(databaseLog, "Failed to set up the database cache", err)
+	}
+	results, err := NewResultStore(config)
+	if err != nil {
+		logFatal(workerLog, "Failed to set up the result store", err)
+	}
+
+	slots := NewSlotPool(config.Worker.Slots)
+	if slots.Size() > 1 {
+		workerLog.Info("Running job slots in parallel", "slots", slots.Size())
+	}
+	var running sync.WaitGroup
 	for {
+		// only dequeue once a slot is free, so other workers can pick up the job in the meantime
+		slots.WaitFree()
 		if config.Worker.GracefulExit && atomic.LoadInt32(&shouldExit) == 1 {
+			running.Wait()
+			if batching != nil {
+				batching.Flush()
+			}
+			removeMetrics()
 			return
 		}
+		// queued jobs wait until there is enough disk space again
+		if watchdog.Err() != nil {
+			time.Sleep(10 * time.Second)
+			continue
+		}
 		ticket, err := jobsystem.Dequeue()
 		if err != nil {
 			if ticket != nil {
-				log.Print(err)
+				workerLog.Error("Failed to dequeue job", "error", err)
 			}
 			time.Sleep(100 * time.Millisecond)
 			continue
@@ -1232,7 +1618,7 @@ func worker(jobsystem JobSystem, config ConfigRoot) {
 		f, err := os.Open(jobFile)
 		if err != nil {
 			jobsystem.SetStatus(ticket.Id, StatusError)
-			log.Print(err)
+			workerLog.Error("Failed to read job", "ticket", ticket.Id, "error", err)
 			continue
 		}
 
@@ -1242,35 +1628,172 @@ func worker(jobsystem JobSystem, config ConfigRoot) {
 		f.Close()
 		if err != nil {
 			jobsystem.SetStatus(ticket.Id, StatusError)
-			log.Print(err)
+			workerLog.Error("Failed to read job", "ticket", ticket.Id, "error", err)
 			continue
 		}
+		// the params of cached databases are needed to schedule the job, the files are fetched when it runs
+		if cache != nil {
+			if err := cache.FetchParams(context.Background(), cachedDatabases(job)); err != nil {
+				failTicket(jobsystem, config, notifications, ticket.Id, job, 0, ErrorDatabaseMissing, err.Error())
+				workerLog.Error("Failed to fetch database params", "ticket", ticket.Id, "error", err)
+				continue
+			}
+		}
 
-		jobsystem.SetStatus(ticket.Id, StatusRunning)
-		err = RunJob(job, config)
-		mailTemplate := config.Mail.Templates.Success
-		switch err.(type) {
-		case *JobExecutionError, *JobInvalidError:
-			jobsystem.SetStatus(ticket.Id, StatusError)
-			log.Print(err)
-			mailTemplate = config.Mail.Templates.Error
-		case *JobTimeoutError:
-			jobsystem.SetStatus(ticket.Id, StatusError)
-			log.Print(err)
-			mailTemplate = config.Mail.Templates.Timeout
-		case nil:
-			jobsystem.SetStatus(ticket.Id, StatusComplete)
-		}
-		if job.Email != "" {
-			err = mailer.Send(Mail{
-				config.Mail.Sender,
-				job.Email,
-				fmt.Sprintf(mailTemplate.Subject, string(ticket.Id)),
-				fmt.Sprintf(mailTemplate.Body, string(ticket.Id)),
-			})
-			if err != nil {
-				log.Print(err)
+		needsGpu, err := requiresGpu(job, config)
+		if err != nil {
+			failTicket(jobsystem, config, notifications, ticket.Id, job, 0, jobErrorCode(config, err), err.Error())
+			workerLog.Error("Invalid job", "ticket", ticket.Id, "error", err)
+			continue
+		}
+		if needsGpu && gpus.Size() == 0 && !schedulerExecutor(config) {
+			failTicket(jobsystem, config, notifications, ticket.Id, job, 0, ErrorInternal, "no GPU available")
+			workerLog.Error("Job requires a GPU, but none are configured", "ticket", ticket.Id)
+			continue
+		}
+
+		timeout, err := jobTimeout(job, config)
+		if err != nil {
+			failTicket(jobsystem, config, notifications, ticket.Id, job, 0, jobErrorCode(config, err), err.Error())
+			workerLog.Error("Invalid job", "ticket", ticket.Id, "error", err)
+			continue
+		}
+
+		jobLog, err := os.OpenFile(filepath.Join(config.Paths.Results, string(ticket.Id), "job.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			failTicket(jobsystem, config, notifications, ticket.Id, job, 0, ErrorInternal, err.Error())
+			workerLog.Error("Failed to open the job log", "ticket", ticket.Id, "error", err)
+			continue
+		}
+
+		// a big job blocks the queue until enough slots are free, so it can not be starved by small jobs
+		cost := jobSlots(config, job)
+		slots.Acquire(cost)
+		jobConfig := config
+		if slots.Size() > 1 {
+			jobConfig.Worker.CPUs = max(runtime.NumCPU()*cost/slots.Size(), 1)
+		}
+		running.Add(1)
+		go func(ticket *Ticket) {
+			defer running.Done()
+			defer slots.Release(cost)
+			started := time.Now()
+			if cache != nil {
+				dbs := cachedDatabases(job)
+				if err := cache.Acquire(context.Background(), dbs); err != nil {
+					failTicket(jobsystem, config, notifications, ticket.Id, job, time.Since(started), ErrorDatabaseMissing, "fetching databases failed: "+err.Error())
+					workerLog.Error("Fetching databases failed", "ticket", ticket.Id, "error", err)
+					jobLog.Close()
+					return
+				}
+				defer cache.Release(dbs)
+			}
+			// index jobs lock their database themselves, searches wait while a database is rebuilt, at most for their timeout
+			if _, ok := job.Job.(IndexJob); !ok {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				locks, err := lockDatabases(ctx, config.Paths.Databases, jobDatabases(job))
+				cancel()
+				if err == context.DeadlineExceeded {
+					failTicket(jobsystem, config, notifications, ticket.Id, job, time.Since(started), ErrorTimeout, "timed out waiting for a database update")
+					workerLog.Error("Locking databases timed out", "ticket", ticket.Id)
+					jobLog.Close()
+					return
+				}
+				if err != nil {
+					failTicket(jobsystem, config, notifications, ticket.Id, job, time.Since(started), ErrorInternal, "locking databases failed: "+err.Error())
+					workerLog.Error("Locking databases failed", "ticket", ticket.Id, "error", err)
+					jobLog.Close()
+					return
+				}
+				defer unlockDatabases(locks)
+			}
+			runTicket(jobsystem, jobConfig, notifications, gpus, results, ticket.Id, job, needsGpu, timeout, jobLog)
+		}(ticket)
+	}
+}
+
+// failTicket reports a job that failed before it ran like runTicket reports a failed run
+func failTicket(jobsystem JobSystem, config ConfigRoot, notifications *DeliveryQueue, id Id, job JobRequest, elapsed time.Duration, code ErrorCode, message string) {
+	jobErr := NewJobError(code, message)
+	jobsystem.SetError(id, jobErr.Code, jobErr.Message)
+	workerMetrics.ObserveJob(job, elapsed, jobErr.Code)
+	NotifyJob(config, notifications, job, jobErr)
+}
+
+// runTicket executes a dequeued job and reports the outcome to the job system and by email
+func runTicket(jobsystem JobSystem, config ConfigRoot, notifications *DeliveryQueue, gpus *GpuPool, results *ResultStore, id Id, job JobRequest, needsGpu bool, timeout time.Duration, jobLog *os.File) {
+	gpu := ""
+	useGpuPool := needsGpu && !schedulerExecutor(config)
+	if useGpuPool {
+		gpu = gpus.Acquire()
+	}
+
+	jobsystem.SetStatus(id, StatusRunning)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	started := time.Now()
+	// jobs submitted before the submission time was stored are not counted
+	if job.Submitted > 0 {
+		workerMetrics.ObserveQueueWait(job, started.Sub(time.Unix(job.Submitted, 0)))
+	}
+	err := ExecuteJob(ctx, job, config, gpu, needsGpu, timeout, jobLog)
+	elapsed := time.Since(started)
+	cancel()
+	jobLog.Close()
+	if useGpuPool {
+		gpus.Release(gpu)
+	}
+	// errors of parallel database searches are wrapped in execution errors
+	var oomErr *JobOutOfMemoryError
+	var timeoutErr *JobTimeoutError
+	if errors.As(err, &oomErr) {
+		err = oomErr
+	} else if errors.As(err, &timeoutErr) {
+		err = timeoutErr
+	}
+	// the tool output is read before the log is encrypted
+	var jobErr *JobError
+	if err != nil {
+		jobErr = jobFailure(config, err, jobLog.Name())
+	}
+	if err == nil && config.Worker.CompressResults {
+		if err := CompressResults(filepath.Join(config.Paths.Results, string(id)), config.Worker.GzipResults); err != nil {
+			workerLog.Error("Failed to compress the results", "ticket", id, "error", err)
+		}
+	}
+	// failed jobs leave their queries and partial results behind as well
+	if resultCipher != nil {
+		if err := EncryptResults(filepath.Join(config.Paths.Results, string(id))); err != nil {
+			workerLog.Error("Failed to encrypt the results", "ticket", id, "error", err)
+		}
+	}
+	switch err.(type) {
+	case *JobOutOfMemoryError:
+		jobsystem.SetError(id, jobErr.Code, jobErr.Message)
+		workerLog.Error("Job ran out of memory", "ticket", id, "error", err)
+	case *JobExecutionError, *JobInvalidError:
+		jobsystem.SetError(id, jobErr.Code, jobErr.Message)
+		workerLog.Error("Job failed", "ticket", id, "error", err)
+	case *JobTimeoutError:
+		jobsystem.SetError(id, jobErr.Code, jobErr.Message)
+		workerLog.Error("Job timed out", "ticket", id, "error", err)
+	case nil:
+		if config.Paths.Artifacts != "" {
+			if err := DeduplicateResults(config.Paths.Artifacts, filepath.Join(config.Paths.Results, string(id))); err != nil {
+				workerLog.Error("Failed to deduplicate the results", "ticket", id, "error", err)
 			}
 		}
+		// a failed upload leaves the results in paths.results
+		if results != nil {
+			if err := results.Upload(context.Background(), id); err != nil {
+				workerLog.Error("Failed to upload the results", "ticket", id, "error", err)
+			}
+		}
+		jobsystem.SetStatus(id, StatusComplete)
+	}
+	var code ErrorCode
+	if jobErr != nil {
+		code = jobErr.Code
 	}
+	workerMetrics.ObserveJob(job, elapsed, code)
+	NotifyJob(config, notifications, job, jobErr)
 }
@@ -4,6 +4,7 @@ package main
 
 import (
 	"os/exec"
+	"runtime"
 
 	"golang.org/x/sys/unix"
 )
@@ -15,3 +16,17 @@ func SetSysProcAttr(cmd *exec.Cmd) {
 func KillCommand(cmd *exec.Cmd) error {
 	return unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
 }
+
+// peakRss returns the peak resident set size in bytes of the process and of its largest waited for child
+func peakRss() (uint64, uint64) {
+	var self, children unix.Rusage
+	if unix.Getrusage(unix.RUSAGE_SELF, &self) != nil || unix.Getrusage(unix.RUSAGE_CHILDREN, &children) != nil {
+		return 0, 0
+	}
+	// kilobytes everywhere but on macOS
+	unit := uint64(1024)
+	if runtime.GOOS == "darwin" {
+		unit = 1
+	}
+	return uint64(self.Maxrss) * unit, uint64(children.Maxrss) * unit
+}
@@ -12,3 +12,8 @@ func SetSysProcAttr(cmd *exec.Cmd) {
 func KillCommand(cmd *exec.Cmd) error {
 	return cmd.Process.Kill()
 }
+
+// peakRss is not measured on windows
+func peakRss() (uint64, uint64) {
+	return 0, 0
+}
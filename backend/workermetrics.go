@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMetricsPushInterval = 1 * time.Minute
+
+// workerMetricFamilies are the metrics of the jobs a worker ran, summaries are written as _sum and _count
+var workerMetricFamilies = []struct {
+	name string
+	kind string
+	help string
+}{
+	{"mmseqs_worker_job_seconds", "summary", "Runtime of the jobs a worker ran, by job type."},
+	{"mmseqs_worker_database_job_seconds", "summary", "Runtime of the jobs that used a database."},
+	{"mmseqs_worker_stage_seconds", "summary", "Runtime of the stages of complete jobs, by job type."},
+	{"mmseqs_worker_queue_wait_seconds", "summary", "Time between the submission of a job and its start on a worker."},
+	{"mmseqs_worker_temporary_bytes", "summary", "Size of the temporary directory of a job when it finished."},
+	{"mmseqs_worker_job_failures_total", "counter", "Failed jobs by job type and error class."},
+	{"mmseqs_worker_database_job_failures_total", "counter", "Failed jobs that used a database, by error class."},
+}
+
+type metricSample struct {
+	labels map[string]string
+	count  uint64
+	sum    float64
+	max    float64
+}
+
+// WorkerMetrics collects the samples of the workerMetricFamilies in a worker process
+type WorkerMetrics struct {
+	mu sync.Mutex
+	// by family and the encoded labels
+	samples map[string]map[string]*metricSample
+}
+
+func NewWorkerMetrics() *WorkerMetrics {
+	return &WorkerMetrics{samples: make(map[string]map[string]*metricSample)}
+}
+
+// workerMetrics records the jobs of the worker process
+var workerMetrics = NewWorkerMetrics()
+
+func (m *WorkerMetrics) observe(name string, labels map[string]string, value float64) {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	var key strings.Builder
+	for _, label := range names {
+		key.WriteString(label + "=" + labels[label] + "\x00")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	family, ok := m.samples[name]
+	if !ok {
+		family = make(map[string]*metricSample)
+		m.samples[name] = family
+	}
+	sample, ok := family[key.String()]
+	if !ok {
+		sample = &metricSample{labels: labels}
+		family[key.String()] = sample
+	}
+	sample.count++
+	sample.sum += value
+	if value > sample.max {
+		sample.max = value
+	}
+}
+
+// ObserveJob records a finished job, code is empty for complete jobs
+func (m *WorkerMetrics) ObserveJob(job JobRequest, runtime time.Duration, code ErrorCode) {
+	jobType := string(job.Type)
+	m.observe("mmseqs_worker_job_seconds", map[string]string{"type": jobType}, runtime.Seconds())
+	for _, database := range jobDatabases(job) {
+		database = databaseLabel(database)
+		m.observe("mmseqs_worker_database_job_seconds", map[string]string{"database": database}, runtime.Seconds())
+		if code != "" {
+			m.observe("mmseqs_worker_database_job_failures_total", map[string]string{"database": database, "error": string(code)}, 1)
+		}
+	}
+	if code != "" {
+		m.observe("mmseqs_worker_job_failures_total", map[string]string{"type": jobType, "error": string(code)}, 1)
+	}
+}
+
+// databaseLabel is the database of a metric, session databases are counted together
+func databaseLabel(database string) string {
+	if strings.HasPrefix(filepath.Base(database), "session_") {
+		return "session"
+	}
+	return database
+}
+
+func (m *WorkerMetrics) ObserveStages(job JobRequest, runtimes map[string]time.Duration) {
+	for stage, runtime := range runtimes {
+		m.observe("mmseqs_worker_stage_seconds", map[string]string{"type": string(job.Type), "stage": stage}, runtime.Seconds())
+	}
+}
+
+func (m *WorkerMetrics) ObserveQueueWait(job JobRequest, wait time.Duration) {
+	m.observe("mmseqs_worker_queue_wait_seconds", map[string]string{"type": string(job.Type)}, wait.Seconds())
+}
+
+func (m *WorkerMetrics) ObserveTemporary(job JobRequest, size uint64) {
+	m.observe("mmseqs_worker_temporary_bytes", map[string]string{"type": string(job.Type)}, float64(size))
+}
+
+// WriteMetrics writes the metrics in the Prometheus text exposition format
+func (m *WorkerMetrics) WriteMetrics(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, family := range workerMetricFamilies {
+		samples := m.samples[family.name]
+		if len(samples) == 0 {
+			continue
+		}
+		writeMetricHeader(w, family.name, family.kind, family.help)
+		for _, key := range sortedSampleKeys(samples) {
+			sample := samples[key]
+			if family.kind == "counter" {
+				writeMetric(w, family.name, sample.labels, float64(sample.count))
+				continue
+			}
+			writeMetric(w, family.name+"_sum", sample.labels, sample.sum)
+			writeMetric(w, family.name+"_count", sample.labels, float64(sample.count))
+		}
+	}
+
+	if samples := m.samples["mmseqs_worker_temporary_bytes"]; len(samples) > 0 {
+		writeMetricHeader(w, "mmseqs_worker_temporary_max_bytes", "gauge", "Largest temporary directory of a job when it finished, by job type.")
+		for _, key := range sortedSampleKeys(samples) {
+			writeMetric(w, "mmseqs_worker_temporary_max_bytes", samples[key].labels, samples[key].max)
+		}
+	}
+
+	self, children := peakRss()
+	if self > 0 {
+		writeMetricHeader(w, "mmseqs_worker_peak_rss_bytes", "gauge", "Peak resident set size of the worker process.")
+		writeMetric(w, "mmseqs_worker_peak_rss_bytes", nil, float64(self))
+		writeMetricHeader(w, "mmseqs_worker_children_peak_rss_bytes", "gauge", "Peak resident set size of the largest finished tool process of the worker.")
+		writeMetric(w, "mmseqs_worker_children_peak_rss_bytes", nil, float64(children))
+	}
+}
+
+func sortedSampleKeys(samples map[string]*metricSample) []string {
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Push replaces the metrics of the worker in the group <job>/<instance> of a Prometheus pushgateway
+func (m *WorkerMetrics) Push(client *http.Client, gateway string, job string, instance string) error {
+	var body bytes.Buffer
+	m.WriteMetrics(&body)
+	return pushGroup(client, "PUT", gateway, job, instance, &body)
+}
+
+// DeletePushed removes the group of the worker from the pushgateway
+func (m *WorkerMetrics) DeletePushed(client *http.Client, gateway string, job string, instance string) error {
+	return pushGroup(client, "DELETE", gateway, job, instance, nil)
+}
+
+func pushGroup(client *http.Client, method string, gateway string, job string, instance string, body io.Reader) error {
+	address := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job) + "/instance/" + url.PathEscape(instance)
+	req, err := http.NewRequest(method, address, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("pushgateway failed with status " + resp.Status)
+	}
+	return nil
+}
+
+// serveWorkerMetrics exposes the metrics on worker.metrics.address and pushes them to worker.metrics.pushgateway
+// in the group of the worker name, the returned function removes the group when the worker exits
+func serveWorkerMetrics(config ConfigWorkerMetrics, name string) (func(), error) {
+	interval := defaultMetricsPushInterval
+	if config.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(config.Interval); err != nil {
+			return nil, err
+		}
+	}
+	if config.Address != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			workerMetrics.WriteMetrics(w)
+		})
+		go func() {
+			logFatal(workerLog, "Worker metrics server stopped", http.ListenAndServe(config.Address, mux))
+		}()
+	}
+	if config.PushGateway == "" {
+		return func() {}, nil
+	}
+	job := config.Job
+	if job == "" {
+		job = "mmseqs_worker"
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := workerMetrics.Push(client, config.PushGateway, job, name); err != nil {
+				workerLog.Error("Failed to push metrics", "error", err)
+			}
+		}
+	}()
+	return func() {
+		if err := workerMetrics.DeletePushed(client, config.PushGateway, job, name); err != nil {
+			workerLog.Error("Failed to remove pushed metrics", "error", err)
+		}
+	}, nil
+}
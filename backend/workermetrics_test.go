@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerMetrics(t *testing.T) {
+	metrics := NewWorkerMetrics()
+	job := JobRequest{Type: JobSearch, Job: SearchJob{Database: []string{"uniref", "session_0123456789ab_cdef"}}}
+	metrics.ObserveJob(job, 2*time.Second, "")
+	metrics.ObserveJob(job, 4*time.Second, ErrorOutOfMemory)
+	metrics.ObserveStages(job, map[string]time.Duration{"search": time.Second})
+	metrics.ObserveQueueWait(job, 3*time.Second)
+	metrics.ObserveTemporary(job, 100)
+	metrics.ObserveTemporary(job, 300)
+
+	var buffer bytes.Buffer
+	metrics.WriteMetrics(&buffer)
+	text := buffer.String()
+	for _, expected := range []string{
+		"# TYPE mmseqs_worker_job_seconds summary",
+		`mmseqs_worker_job_seconds_sum{type="search"} 6`,
+		`mmseqs_worker_job_seconds_count{type="search"} 2`,
+		`mmseqs_worker_database_job_seconds_count{database="uniref"} 2`,
+		`mmseqs_worker_database_job_seconds_count{database="session"} 2`,
+		`mmseqs_worker_stage_seconds_sum{stage="search",type="search"} 1`,
+		`mmseqs_worker_queue_wait_seconds_sum{type="search"} 3`,
+		`mmseqs_worker_temporary_max_bytes{type="search"} 300`,
+		`mmseqs_worker_job_failures_total{error="OOM",type="search"} 1`,
+		`mmseqs_worker_database_job_failures_total{database="uniref",error="OOM"} 1`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("%q is missing in the metrics:\n%s", expected, text)
+		}
+	}
+
+	var pushed string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		pushed = req.Method + " " + req.URL.Path + "\n" + string(body)
+	}))
+	defer gateway.Close()
+	if err := metrics.Push(gateway.Client(), gateway.URL+"/", "mmseqs_worker", "worker-1"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(pushed, "PUT /metrics/job/mmseqs_worker/instance/worker-1\n") || !strings.Contains(pushed, "mmseqs_worker_job_seconds_sum") {
+		t.Errorf("unexpected push %q", pushed)
+	}
+	if err := metrics.DeletePushed(gateway.Client(), gateway.URL, "mmseqs_worker", "worker-1"); err != nil || pushed != "DELETE /metrics/job/mmseqs_worker/instance/worker-1\n" {
+		t.Errorf("unexpected delete %q %v", pushed, err)
+	}
+}